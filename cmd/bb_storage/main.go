@@ -120,7 +120,7 @@ func main() {
 			buildQueuesTrie.Set(instanceNamePrefix, 0)
 			buildQueuesTrie.Set(instanceNamePrefix, len(buildQueues))
 			buildQueues = append(buildQueues, buildQueueInfo{
-				backend:             builder.NonExecutableBuildQueue,
+				backend:             builder.NewNonExecutableBuildQueue(contentAddressableStorage, configuration.MaximumMessageSizeBytes),
 				backendName:         instanceNamePrefix,
 				instanceNamePatcher: digest.NoopInstanceNamePatcher,
 			})
@@ -170,5 +170,18 @@ func main() {
 	// Web server for metrics and profiling.
 	router := mux.NewRouter()
 	util.RegisterAdministrativeHTTPEndpoints(router)
+	router.Handle("/-/drain", blobstore.DefaultDrainingHTTPHandler)
+	// Readiness probe that exercises the storage backends, as
+	// opposed to /-/healthy, which only checks whether the process
+	// is running.
+	router.HandleFunc("/-/ready", func(w http.ResponseWriter, r *http.Request) {
+		if err := blobstore.CheckHealth(r.Context(), contentAddressableStorage); err != nil {
+			http.Error(w, util.StatusWrap(err, "Content Addressable Storage").Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err := blobstore.CheckHealth(r.Context(), actionCache); err != nil {
+			http.Error(w, util.StatusWrap(err, "Action Cache").Error(), http.StatusServiceUnavailable)
+		}
+	})
 	log.Fatal(http.ListenAndServe(configuration.HttpListenAddress, router))
 }
@@ -2,27 +2,204 @@ package blobstore
 
 import (
 	"io"
+	"math"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
 )
 
-type acReadBufferFactory struct{}
+type acReadBufferFactory struct {
+	maximumMessageSizeBytes int
+	treatOversizedAsMissing bool
+}
+
+// NewACReadBufferFactory creates a ReadBufferFactory that is capable
+// of creating buffers for objects stored in the Action Cache (AC). As
+// these objects are not content addressed, no checksum validation is
+// performed. Instead, objects are required to be valid ActionResult
+// messages that are not larger than the provided maximum size.
+//
+// Objects exceeding the maximum size are rejected with an error
+// created by NewActionResultTooLargeError(). By default its code is
+// INVALID_ARGUMENT. If treatOversizedAsMissing is set, NOT_FOUND is
+// returned instead, causing clients to treat the entry as a cache
+// miss, as opposed to failing the build.
+func NewACReadBufferFactory(maximumMessageSizeBytes int, treatOversizedAsMissing bool) ReadBufferFactory {
+	return &acReadBufferFactory{
+		maximumMessageSizeBytes: maximumMessageSizeBytes,
+		treatOversizedAsMissing: treatOversizedAsMissing,
+	}
+}
 
-func (f acReadBufferFactory) NewBufferFromByteSlice(digest digest.Digest, data []byte, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
-	return buffer.NewProtoBufferFromByteSlice(&remoteexecution.ActionResult{}, data, buffer.BackendProvided(dataIntegrityCallback))
+// getTooLargeCode returns the code of the error that is returned for
+// objects that exceed the maximum size.
+func (f *acReadBufferFactory) getTooLargeCode() codes.Code {
+	if f.treatOversizedAsMissing {
+		return codes.NotFound
+	}
+	return codes.InvalidArgument
 }
 
-func (f acReadBufferFactory) NewBufferFromReader(digest digest.Digest, r io.ReadCloser, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
-	return buffer.NewProtoBufferFromReader(&remoteexecution.ActionResult{}, r, buffer.BackendProvided(dataIntegrityCallback))
+func (f *acReadBufferFactory) checkSize(sizeBytes int64) error {
+	if sizeBytes > int64(f.maximumMessageSizeBytes) {
+		return NewActionResultTooLargeError(f.getTooLargeCode(), sizeBytes, int64(f.maximumMessageSizeBytes))
+	}
+	return nil
+}
+
+// validateActionResultDigest checks whether a digest contained in an
+// ActionResult is well formed. Digests of standard output and error
+// are optional.
+func validateActionResultDigest(instanceName digest.InstanceName, blobDigest *remoteexecution.Digest, optional bool) error {
+	if blobDigest == nil && optional {
+		return nil
+	}
+	_, err := instanceName.NewDigestFromProto(blobDigest)
+	return err
+}
+
+// validateActionResult checks whether all digests contained in an
+// ActionResult are well formed, so that clients don't receive action
+// results that refer to objects that cannot be downloaded.
+func validateActionResult(instanceName digest.InstanceName, actionResult *remoteexecution.ActionResult) error {
+	for _, outputFile := range actionResult.OutputFiles {
+		if err := validateActionResultDigest(instanceName, outputFile.Digest, false); err != nil {
+			return util.StatusWrapf(err, "Invalid digest for output file %#v", outputFile.Path)
+		}
+	}
+	for _, outputDirectory := range actionResult.OutputDirectories {
+		if err := validateActionResultDigest(instanceName, outputDirectory.TreeDigest, false); err != nil {
+			return util.StatusWrapf(err, "Invalid tree digest for output directory %#v", outputDirectory.Path)
+		}
+	}
+	if err := validateActionResultDigest(instanceName, actionResult.StdoutDigest, true); err != nil {
+		return util.StatusWrap(err, "Invalid standard output digest")
+	}
+	if err := validateActionResultDigest(instanceName, actionResult.StderrDigest, true); err != nil {
+		return util.StatusWrap(err, "Invalid standard error digest")
+	}
+	return nil
+}
+
+// unmarshalActionResult unmarshals and validates an ActionResult. Any
+// errors are marked as data integrity errors, so that storage backends
+// may discard malformed objects.
+func unmarshalActionResult(instanceName digest.InstanceName, data []byte) (*remoteexecution.ActionResult, error) {
+	var actionResult remoteexecution.ActionResult
+	if err := proto.Unmarshal(data, &actionResult); err != nil {
+		return nil, buffer.MarkDataIntegrityError(util.StatusWrapWithCode(err, codes.Internal, "Failed to unmarshal message"))
+	}
+	if err := validateActionResult(instanceName, &actionResult); err != nil {
+		return nil, buffer.MarkDataIntegrityError(util.StatusWrapWithCode(err, codes.Internal, "Malformed action result"))
+	}
+	return &actionResult, nil
+}
+
+func (f *acReadBufferFactory) NewBufferFromByteSlice(digest digest.Digest, data []byte, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	if err := f.checkSize(int64(len(data))); err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+
+	// Only report the object as being valid if both unmarshaling
+	// and validation succeed.
+	actionResult, err := unmarshalActionResult(digest.GetInstanceName(), data)
+	if err != nil {
+		dataIntegrityCallback(false)
+		return buffer.NewBufferFromError(err)
+	}
+	return buffer.NewProtoBufferFromProto(actionResult, buffer.BackendProvided(dataIntegrityCallback))
+}
+
+func (f *acReadBufferFactory) NewBufferFromReader(digest digest.Digest, r io.ReadCloser, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	// The size of the object is not known up front. Instead of
+	// reading the object immediately, enforce the maximum size
+	// and validate the object as the buffer is consumed.
+	return buffer.NewValidatedBufferFromReader(f.newValidatingReader(digest, r, dataIntegrityCallback), -1)
+}
+
+func (f *acReadBufferFactory) NewBufferFromFileReader(digest digest.Digest, r filesystem.FileReader, sizeBytes int64, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	if err := f.checkSize(sizeBytes); err != nil {
+		r.Close()
+		return buffer.NewBufferFromError(err)
+	}
+	return buffer.NewValidatedBufferFromReader(f.newValidatingReader(digest, newReaderFromFileReader(r), dataIntegrityCallback), sizeBytes)
+}
+
+type acValidatingReader struct {
+	io.ReadCloser
+	factory               *acReadBufferFactory
+	instanceName          digest.InstanceName
+	dataIntegrityCallback buffer.DataIntegrityCallback
+
+	err  error
+	data []byte
+}
+
+// newValidatingReader creates a decorator for io.ReadCloser that
+// performs on-the-fly validation of ActionResult messages. Similar to
+// the checksum validation performed for the Content Addressable
+// Storage, reading fails as soon as the object exceeds the maximum
+// size, while the message itself is validated upon reaching the end
+// of the stream.
+func (f *acReadBufferFactory) newValidatingReader(digest digest.Digest, r io.ReadCloser, dataIntegrityCallback buffer.DataIntegrityCallback) io.ReadCloser {
+	return &acValidatingReader{
+		ReadCloser:            r,
+		factory:               f,
+		instanceName:          digest.GetInstanceName(),
+		dataIntegrityCallback: dataIntegrityCallback,
+	}
+}
+
+func (r *acValidatingReader) doRead(p []byte) (int, error) {
+	// Read at most one byte more than the maximum size, so that
+	// oversized objects can be detected without reading them in
+	// their entirety. Only a lower bound of the size is reported.
+	maximumSizeBytes := r.factory.maximumMessageSizeBytes
+	if remaining := maximumSizeBytes + 1 - len(r.data); len(p) > remaining {
+		p = p[:remaining]
+	}
+	n, readErr := r.ReadCloser.Read(p)
+	r.data = append(r.data, p[:n]...)
+	if len(r.data) > maximumSizeBytes {
+		return 0, newActionResultTooLargeStreamError(r.factory.getTooLargeCode(), int64(len(r.data)), int64(maximumSizeBytes))
+	}
+
+	if readErr == io.EOF {
+		// Only report the object as being valid if both
+		// unmarshaling and validation succeed.
+		if _, err := unmarshalActionResult(r.instanceName, r.data); err != nil {
+			r.dataIntegrityCallback(false)
+			return 0, err
+		}
+		r.dataIntegrityCallback(true)
+		return n, io.EOF
+	} else if readErr != nil {
+		return 0, readErr
+	}
+	return n, nil
 }
 
-func (f acReadBufferFactory) NewBufferFromFileReader(digest digest.Digest, r filesystem.FileReader, sizeBytes int64, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
-	return f.NewBufferFromReader(digest, newReaderFromFileReader(r), dataIntegrityCallback)
+func (r *acValidatingReader) Read(p []byte) (int, error) {
+	// Return errors from previous iterations. This prevents
+	// resumption of I/O after yielding an error once.
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.doRead(p)
+	r.err = err
+	return n, err
 }
 
-// ACReadBufferFactory is capable of buffers for objects stored in the
-// Action Cache (AC).
-var ACReadBufferFactory ReadBufferFactory = acReadBufferFactory{}
+// ACReadBufferFactory is capable of creating buffers for objects
+// stored in the Action Cache (AC). It does not impose a meaningful
+// limit on the size of objects.
+//
+// Deprecated: Use NewACReadBufferFactory(), which allows specifying
+// the maximum message size.
+var ACReadBufferFactory = NewACReadBufferFactory(math.MaxInt32, false)
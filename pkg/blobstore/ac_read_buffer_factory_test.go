@@ -0,0 +1,206 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestACReadBufferFactory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	readBufferFactory := blobstore.NewACReadBufferFactory(100, false)
+	actionDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Success", func(t *testing.T) {
+		actionResult := &remoteexecution.ActionResult{
+			OutputFiles: []*remoteexecution.OutputFile{
+				{
+					Path: "foo",
+					Digest: &remoteexecution.Digest{
+						Hash:      "8b1a9953c4611296a827abf8c47804d7",
+						SizeBytes: 5,
+					},
+				},
+			},
+			ExitCode: 1,
+		}
+		data, err := proto.Marshal(actionResult)
+		require.NoError(t, err)
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+
+		m, err := readBufferFactory.NewBufferFromByteSlice(actionDigest, data, dataIntegrityCallback.Call).
+			ToProto(&remoteexecution.ActionResult{}, 100)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(actionResult, m))
+	})
+
+	t.Run("MaximumSize", func(t *testing.T) {
+		// Objects that are exactly at the maximum size should
+		// be accepted.
+		actionResult := &remoteexecution.ActionResult{
+			StdoutRaw: make([]byte, 98),
+		}
+		data, err := proto.Marshal(actionResult)
+		require.NoError(t, err)
+		require.Len(t, data, 100)
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+
+		m, err := readBufferFactory.NewBufferFromByteSlice(actionDigest, data, dataIntegrityCallback.Call).
+			ToProto(&remoteexecution.ActionResult{}, 100)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(actionResult, m))
+	})
+
+	t.Run("TooBigByteSlice", func(t *testing.T) {
+		// Oversized objects should be rejected without being
+		// reported as corrupted, as the limit is merely a
+		// setting of this process. The error should report the
+		// actual size and the limit.
+		data, err := proto.Marshal(&remoteexecution.ActionResult{
+			StdoutRaw: make([]byte, 99),
+		})
+		require.NoError(t, err)
+		require.Len(t, data, 101)
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+
+		_, err = readBufferFactory.NewBufferFromByteSlice(actionDigest, data, dataIntegrityCallback.Call).
+			ToProto(&remoteexecution.ActionResult{}, 1000)
+		require.Equal(t, blobstore.NewActionResultTooLargeError(codes.InvalidArgument, 101, 100), err)
+		require.Equal(t, "Action result is 101 bytes in size, while a maximum of 100 bytes is permitted", status.Convert(err).Message())
+		sizeBytes, maximumSizeBytes, ok := blobstore.GetActionResultTooLargeError(err)
+		require.True(t, ok)
+		require.Equal(t, int64(101), sizeBytes)
+		require.Equal(t, int64(100), maximumSizeBytes)
+	})
+
+	t.Run("MaximumSizeReader", func(t *testing.T) {
+		actionResult := &remoteexecution.ActionResult{
+			StdoutRaw: make([]byte, 98),
+		}
+		data, err := proto.Marshal(actionResult)
+		require.NoError(t, err)
+		require.Len(t, data, 100)
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+
+		m, err := readBufferFactory.NewBufferFromReader(actionDigest, ioutil.NopCloser(bytes.NewBuffer(data)), dataIntegrityCallback.Call).
+			ToProto(&remoteexecution.ActionResult{}, 100)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(actionResult, m))
+	})
+
+	t.Run("ReaderDiscarded", func(t *testing.T) {
+		// Creating a buffer from a reader should not cause any
+		// data to be read. Discarding the buffer should merely
+		// close the reader.
+		reader := mock.NewMockReadCloser(ctrl)
+		reader.EXPECT().Close()
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+
+		readBufferFactory.NewBufferFromReader(actionDigest, reader, dataIntegrityCallback.Call).Discard()
+	})
+
+	t.Run("ReaderMalformed", func(t *testing.T) {
+		// Malformed objects should only be reported as such
+		// once the buffer is consumed.
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		b := readBufferFactory.NewBufferFromReader(actionDigest, ioutil.NopCloser(bytes.NewBufferString("Hello")), dataIntegrityCallback.Call)
+
+		dataIntegrityCallback.EXPECT().Call(false)
+		_, err := b.ToProto(&remoteexecution.ActionResult{}, 100)
+		require.Equal(t, codes.Internal, status.Code(err))
+		require.True(t, buffer.IsDataIntegrityError(err))
+	})
+
+	t.Run("TooBigReader", func(t *testing.T) {
+		// As the size of the object is not known up front, it
+		// should not be read beyond the maximum size. Only a
+		// lower bound of its size can be reported.
+		for _, rawSizeBytes := range []int{99, 150} {
+			data, err := proto.Marshal(&remoteexecution.ActionResult{
+				StdoutRaw: make([]byte, rawSizeBytes),
+			})
+			require.NoError(t, err)
+			dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+
+			_, err = readBufferFactory.NewBufferFromReader(actionDigest, ioutil.NopCloser(bytes.NewBuffer(data)), dataIntegrityCallback.Call).
+				ToProto(&remoteexecution.ActionResult{}, 1000)
+			require.Equal(t, codes.InvalidArgument, status.Code(err))
+			require.Equal(t, "Action result is at least 101 bytes in size, while a maximum of 100 bytes is permitted", status.Convert(err).Message())
+			sizeBytes, maximumSizeBytes, ok := blobstore.GetActionResultTooLargeError(err)
+			require.True(t, ok)
+			require.Equal(t, int64(101), sizeBytes)
+			require.Equal(t, int64(100), maximumSizeBytes)
+		}
+	})
+
+	t.Run("TooBigFileReader", func(t *testing.T) {
+		fileReader := mock.NewMockFileReader(ctrl)
+		fileReader.EXPECT().Close()
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+
+		_, err := readBufferFactory.NewBufferFromFileReader(actionDigest, fileReader, 101, dataIntegrityCallback.Call).
+			ToProto(&remoteexecution.ActionResult{}, 1000)
+		require.Equal(t, blobstore.NewActionResultTooLargeError(codes.InvalidArgument, 101, 100), err)
+	})
+
+	t.Run("TooBigTreatedAsMissing", func(t *testing.T) {
+		// If configured, oversized objects should be reported
+		// as being absent, so that clients treat them as a
+		// cache miss.
+		readBufferFactory := blobstore.NewACReadBufferFactory(100, true)
+		fileReader := mock.NewMockFileReader(ctrl)
+		fileReader.EXPECT().Close()
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+
+		_, err := readBufferFactory.NewBufferFromFileReader(actionDigest, fileReader, 101, dataIntegrityCallback.Call).
+			ToProto(&remoteexecution.ActionResult{}, 1000)
+		require.Equal(t, codes.NotFound, status.Code(err))
+		sizeBytes, maximumSizeBytes, ok := blobstore.GetActionResultTooLargeError(err)
+		require.True(t, ok)
+		require.Equal(t, int64(101), sizeBytes)
+		require.Equal(t, int64(100), maximumSizeBytes)
+	})
+
+	t.Run("UnmarshalFailure", func(t *testing.T) {
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(false)
+
+		_, err := readBufferFactory.NewBufferFromByteSlice(actionDigest, []byte("Hello"), dataIntegrityCallback.Call).
+			ToProto(&remoteexecution.ActionResult{}, 100)
+		require.Equal(t, codes.Internal, status.Code(err))
+	})
+
+	t.Run("MalformedDigest", func(t *testing.T) {
+		// Action results that contain malformed digests should
+		// be reported as corrupted.
+		data, err := proto.Marshal(&remoteexecution.ActionResult{
+			StdoutDigest: &remoteexecution.Digest{
+				Hash:      "This is not a hash",
+				SizeBytes: 5,
+			},
+		})
+		require.NoError(t, err)
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(false)
+
+		_, err = readBufferFactory.NewBufferFromByteSlice(actionDigest, data, dataIntegrityCallback.Call).
+			ToProto(&remoteexecution.ActionResult{}, 100)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Malformed action result: Invalid standard output digest: Unknown digest hash length: 18 characters")), err)
+	})
+}
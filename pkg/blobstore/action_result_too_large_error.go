@@ -0,0 +1,72 @@
+package blobstore
+
+import (
+	"strconv"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	actionResultTooLargeErrorDomain = "buildbarn.github.io"
+	actionResultTooLargeErrorReason = "ACTION_RESULT_TOO_LARGE"
+)
+
+// NewActionResultTooLargeError creates a gRPC status error indicating
+// that an ActionResult could not be returned, because it exceeds the
+// maximum permitted size. The actual size and the limit are stored in
+// the details of the status, meaning they can be extracted using
+// GetActionResultTooLargeError(), even after the error has been
+// wrapped using util.StatusWrap*() or sent over gRPC.
+func NewActionResultTooLargeError(code codes.Code, sizeBytes, maximumSizeBytes int64) error {
+	return newActionResultTooLargeError(
+		status.Newf(code, "Action result is %d bytes in size, while a maximum of %d bytes is permitted", sizeBytes, maximumSizeBytes),
+		sizeBytes,
+		maximumSizeBytes)
+}
+
+// newActionResultTooLargeStreamError is similar to
+// NewActionResultTooLargeError(), except that it is used when reading
+// an ActionResult from a stream of unknown size. Such streams are not
+// read beyond the maximum permitted size, meaning that only a lower
+// bound of the size is known. This lower bound is stored in the
+// details of the status.
+func newActionResultTooLargeStreamError(code codes.Code, sizeBytes, maximumSizeBytes int64) error {
+	return newActionResultTooLargeError(
+		status.Newf(code, "Action result is at least %d bytes in size, while a maximum of %d bytes is permitted", sizeBytes, maximumSizeBytes),
+		sizeBytes,
+		maximumSizeBytes)
+}
+
+func newActionResultTooLargeError(s *status.Status, sizeBytes, maximumSizeBytes int64) error {
+	sWithDetails, err := s.WithDetails(&errdetails.ErrorInfo{
+		Reason: actionResultTooLargeErrorReason,
+		Domain: actionResultTooLargeErrorDomain,
+		Metadata: map[string]string{
+			"size_bytes":         strconv.FormatInt(sizeBytes, 10),
+			"maximum_size_bytes": strconv.FormatInt(maximumSizeBytes, 10),
+		},
+	})
+	if err != nil {
+		return s.Err()
+	}
+	return sWithDetails.Err()
+}
+
+// GetActionResultTooLargeError returns the size of an ActionResult and
+// the maximum permitted size if the provided error was created using
+// NewActionResultTooLargeError(). For ActionResults that were read
+// from a stream of unknown size, the size is only a lower bound.
+func GetActionResultTooLargeError(err error) (sizeBytes, maximumSizeBytes int64, ok bool) {
+	for _, detail := range status.Convert(err).Details() {
+		if errorInfo, isErrorInfo := detail.(*errdetails.ErrorInfo); isErrorInfo && errorInfo.Domain == actionResultTooLargeErrorDomain && errorInfo.Reason == actionResultTooLargeErrorReason {
+			sizeBytes, err1 := strconv.ParseInt(errorInfo.Metadata["size_bytes"], 10, 64)
+			maximumSizeBytes, err2 := strconv.ParseInt(errorInfo.Metadata["maximum_size_bytes"], 10, 64)
+			if err1 == nil && err2 == nil {
+				return sizeBytes, maximumSizeBytes, true
+			}
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,190 @@
+package blobstore
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// archiveEntry contains the location of a single blob in an archive.
+// Entries that are stored uncompressed are referenced by offset.
+// Entries in ZIP files that are compressed need to be read through the
+// ZIP decompressor.
+type archiveEntry struct {
+	offsetBytes int64
+	sizeBytes   int64
+	zipFile     *zip.File
+}
+
+type archiveBlobAccess struct {
+	file    *os.File
+	entries map[string]archiveEntry
+}
+
+// NewArchiveBlobAccess creates a BlobAccess that serves blobs from a
+// read-only ZIP or tar archive. This can be used to package a fixed
+// set of blobs, so that they can be served in hermetic environments.
+// This backend is only supported for the Content Addressable Storage
+// (CAS).
+//
+// Every regular file in the archive whose name has the form
+// "<hash>-<size>" is exposed as a blob. Directories leading up to
+// these files are ignored. As the archive does not store instance
+// names or digest functions, blobs are returned for any instance name
+// and digest function whose hash and size match.
+//
+// The archive is indexed upon creation. For tar archives this is done
+// by scanning the archive and recording the offsets of all entries.
+// Attempting to write blobs fails with FAILED_PRECONDITION.
+func NewArchiveBlobAccess(archivePath string) (BlobAccess, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, util.StatusWrapfWithCode(err, codes.Internal, "Failed to open archive %#v", archivePath)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, util.StatusWrapfWithCode(err, codes.Internal, "Failed to obtain size of archive %#v", archivePath)
+	}
+
+	ba := &archiveBlobAccess{
+		file:    f,
+		entries: map[string]archiveEntry{},
+	}
+	if zipReader, err := zip.NewReader(f, info.Size()); err == nil {
+		ba.indexZipArchive(zipReader)
+	} else if err != zip.ErrFormat {
+		f.Close()
+		return nil, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Failed to read ZIP archive %#v", archivePath)
+	} else if err := ba.indexTarArchive(); err != nil {
+		f.Close()
+		return nil, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Failed to read tar archive %#v", archivePath)
+	}
+	return ba, nil
+}
+
+// getArchiveEntryKey converts the name of an entry in an archive to
+// a key that is used to index it, having the form "<hash>-<size>".
+func getArchiveEntryKey(name string, sizeBytes int64) (string, bool) {
+	base := path.Base(name)
+	separator := strings.LastIndexByte(base, '-')
+	if separator < 0 {
+		return "", false
+	}
+	hash := base[:separator]
+	expectedSizeBytes, err := strconv.ParseInt(base[separator+1:], 10, 64)
+	if err != nil || expectedSizeBytes != sizeBytes {
+		return "", false
+	}
+	return getArchiveKey(strings.ToLower(hash), sizeBytes), true
+}
+
+func getArchiveKey(hash string, sizeBytes int64) string {
+	return fmt.Sprintf("%s-%d", hash, sizeBytes)
+}
+
+func (ba *archiveBlobAccess) indexZipArchive(zipReader *zip.Reader) {
+	for _, zipFile := range zipReader.File {
+		if !zipFile.Mode().IsRegular() || zipFile.UncompressedSize64 > math.MaxInt64 {
+			continue
+		}
+		sizeBytes := int64(zipFile.UncompressedSize64)
+		key, ok := getArchiveEntryKey(zipFile.Name, sizeBytes)
+		if !ok {
+			continue
+		}
+		entry := archiveEntry{sizeBytes: sizeBytes}
+		if offsetBytes, err := zipFile.DataOffset(); err == nil && zipFile.Method == zip.Store {
+			entry.offsetBytes = offsetBytes
+		} else {
+			entry.zipFile = zipFile
+		}
+		ba.entries[key] = entry
+	}
+}
+
+func (ba *archiveBlobAccess) indexTarArchive() error {
+	// The tar reader does not report the offsets of entries. Track
+	// the number of bytes consumed instead, which is equal to the
+	// offset of an entry's contents after reading its header.
+	r := &countingReader{r: ba.file}
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if key, ok := getArchiveEntryKey(header.Name, header.Size); ok {
+			ba.entries[key] = archiveEntry{
+				offsetBytes: r.offsetBytes,
+				sizeBytes:   header.Size,
+			}
+		}
+	}
+}
+
+func (ba *archiveBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	entry, ok := ba.entries[getArchiveKey(blobDigest.GetHashString(), blobDigest.GetSizeBytes())]
+	if !ok {
+		return buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found"))
+	}
+	var r io.ReadCloser
+	if entry.zipFile != nil {
+		zipFileReader, err := entry.zipFile.Open()
+		if err != nil {
+			return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.Internal, "Failed to open archive entry"))
+		}
+		r = zipFileReader
+	} else {
+		r = ioutil.NopCloser(io.NewSectionReader(ba.file, entry.offsetBytes, entry.sizeBytes))
+	}
+	return buffer.NewCASBufferFromReader(blobDigest, r, buffer.BackendProvided(buffer.Irreparable(blobDigest)))
+}
+
+func (ba *archiveBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	b.Discard()
+	return status.Error(codes.FailedPrecondition, "Storage backend is read-only")
+}
+
+func (ba *archiveBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	missing := digest.NewSetBuilder()
+	for _, blobDigest := range digests.Items() {
+		if _, ok := ba.entries[getArchiveKey(blobDigest.GetHashString(), blobDigest.GetSizeBytes())]; !ok {
+			missing.Add(blobDigest)
+		}
+	}
+	return missing.Build(), nil
+}
+
+// countingReader is a decorator for io.Reader that keeps track of the
+// number of bytes read.
+type countingReader struct {
+	r           io.Reader
+	offsetBytes int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.offsetBytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,121 @@
+package blobstore_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testArchiveBlobAccess(ctx context.Context, t *testing.T, blobAccess blobstore.BlobAccess) {
+	blobDigest1 := digest.MustNewDigest("hello", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+	blobDigest2 := digest.MustNewDigest("hello", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+	blobDigest3 := digest.MustNewDigest("hello", "82e35a63ceba37e9646434c5dd412ea577147f1e4a41ccde1614253187e3dbf9", 7)
+
+	t.Run("Get", func(t *testing.T) {
+		data, err := blobAccess.Get(ctx, blobDigest1).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+
+		data, err = blobAccess.Get(ctx, blobDigest2).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		_, err := blobAccess.Get(ctx, blobDigest3).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.FailedPrecondition, "Storage backend is read-only"),
+			blobAccess.Put(ctx, blobDigest3, buffer.NewValidatedBufferFromByteSlice([]byte("Goodbye"))))
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(blobDigest1).Add(blobDigest2).Add(blobDigest3).Build())
+		require.NoError(t, err)
+		require.Equal(t, blobDigest3.ToSingletonSet(), missing)
+	})
+}
+
+func TestArchiveBlobAccessZip(t *testing.T) {
+	directory, err := ioutil.TempDir("", "archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(directory)
+
+	// Create a ZIP file containing both a stored and a compressed
+	// entry, together with some entries that should be ignored.
+	archivePath := filepath.Join(directory, "blobs.zip")
+	f, err := os.Create(archivePath)
+	require.NoError(t, err)
+	w := zip.NewWriter(f)
+	for _, entry := range []struct {
+		name   string
+		method uint16
+		data   string
+	}{
+		{"cas/64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c-11", zip.Store, "Hello world"},
+		{"cas/185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969-5", zip.Deflate, "Hello"},
+		{"README", zip.Deflate, "Not a blob"},
+		{"82e35a63ceba37e9646434c5dd412ea577147f1e4a41ccde1614253187e3dbf9-8", zip.Store, "Goodbye"},
+	} {
+		ew, err := w.CreateHeader(&zip.FileHeader{Name: entry.name, Method: entry.method})
+		require.NoError(t, err)
+		_, err = ew.Write([]byte(entry.data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+
+	blobAccess, err := blobstore.NewArchiveBlobAccess(archivePath)
+	require.NoError(t, err)
+	testArchiveBlobAccess(context.Background(), t, blobAccess)
+}
+
+func TestArchiveBlobAccessTar(t *testing.T) {
+	directory, err := ioutil.TempDir("", "archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(directory)
+
+	archivePath := filepath.Join(directory, "blobs.tar")
+	f, err := os.Create(archivePath)
+	require.NoError(t, err)
+	w := tar.NewWriter(f)
+	for _, entry := range []struct {
+		name string
+		data string
+	}{
+		{"README", "Not a blob"},
+		{"cas/64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c-11", "Hello world"},
+		{"cas/185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969-5", "Hello"},
+	} {
+		require.NoError(t, w.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     entry.name,
+			Mode:     0644,
+			Size:     int64(len(entry.data)),
+		}))
+		_, err = w.Write([]byte(entry.data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+
+	blobAccess, err := blobstore.NewArchiveBlobAccess(archivePath)
+	require.NoError(t, err)
+	testArchiveBlobAccess(context.Background(), t, blobAccess)
+}
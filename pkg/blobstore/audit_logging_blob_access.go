@@ -0,0 +1,157 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	auditLoggingBlobAccessPrometheusMetrics sync.Once
+
+	auditLoggingBlobAccessRecordsDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "audit_logging_blob_access_records_dropped_total",
+			Help:      "Number of audit log records that were dropped, due to the queue of records being full.",
+		})
+)
+
+// AuditRecord contains information on a single Get() or Put() call
+// performed against a BlobAccess, as emitted by
+// AuditLoggingBlobAccess.
+type AuditRecord struct {
+	// Timestamp at which the operation started.
+	Timestamp time.Time
+	// Principal that performed the operation. This corresponds to
+	// the subject of the TLS client certificate, or the address of
+	// the client if no certificate was provided.
+	Principal string
+	// Operation is either "Get" or "Put".
+	Operation string
+	// Digest of the blob, which includes the instance name.
+	Digest digest.Digest
+	// Code contains the result of the operation.
+	Code codes.Code
+}
+
+// AuditLogSink is used by AuditLoggingBlobAccess to store records. It
+// may be implemented to write records to a file, a message queue, etc.
+type AuditLogSink interface {
+	WriteAuditRecord(record *AuditRecord) error
+}
+
+type auditLoggingBlobAccess struct {
+	BlobAccess
+	clock   clock.Clock
+	records chan<- *AuditRecord
+}
+
+// NewAuditLoggingBlobAccess creates a decorator for BlobAccess that
+// emits a record for every Get() and Put() call, containing the
+// principal that performed the operation, the digest of the blob and
+// the result. This may be used for security auditing.
+//
+// Records are written to the AuditLogSink asynchronously, so that
+// logging does not add latency to the storage path. Records are
+// dropped if more than queueSize records are pending. Failures to write
+// records are reported through the provided ErrorLogger.
+func NewAuditLoggingBlobAccess(base BlobAccess, sink AuditLogSink, clock clock.Clock, queueSize int, errorLogger util.ErrorLogger) BlobAccess {
+	auditLoggingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(auditLoggingBlobAccessRecordsDropped)
+	})
+
+	records := make(chan *AuditRecord, queueSize)
+	go func() {
+		for record := range records {
+			if err := sink.WriteAuditRecord(record); err != nil {
+				errorLogger.Log(util.StatusWrap(err, "Failed to write audit record"))
+			}
+		}
+	}()
+	return &auditLoggingBlobAccess{
+		BlobAccess: base,
+		clock:      clock,
+		records:    records,
+	}
+}
+
+// GetPrincipal extracts the identity of the client from the context
+// of a gRPC call.
+func GetPrincipal(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		if certs := tlsInfo.State.PeerCertificates; len(certs) > 0 {
+			return certs[0].Subject.String()
+		}
+	}
+	if p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+func (ba *auditLoggingBlobAccess) newRecord(ctx context.Context, operation string, digest digest.Digest) *AuditRecord {
+	return &AuditRecord{
+		Timestamp: ba.clock.Now(),
+		Principal: GetPrincipal(ctx),
+		Operation: operation,
+		Digest:    digest,
+	}
+}
+
+func (ba *auditLoggingBlobAccess) emitRecord(record *AuditRecord) {
+	select {
+	case ba.records <- record:
+	default:
+		auditLoggingBlobAccessRecordsDropped.Inc()
+	}
+}
+
+func (ba *auditLoggingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	// The result of the operation is only known after the buffer
+	// has been consumed. Emit the record when that happens.
+	return buffer.WithErrorHandler(
+		ba.BlobAccess.Get(ctx, digest),
+		&auditLoggingErrorHandler{
+			blobAccess: ba,
+			record:     ba.newRecord(ctx, "Get", digest),
+		})
+}
+
+func (ba *auditLoggingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	record := ba.newRecord(ctx, "Put", digest)
+	err := ba.BlobAccess.Put(ctx, digest, b)
+	record.Code = status.Code(err)
+	ba.emitRecord(record)
+	return err
+}
+
+type auditLoggingErrorHandler struct {
+	blobAccess *auditLoggingBlobAccess
+	record     *AuditRecord
+}
+
+func (eh *auditLoggingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	eh.record.Code = status.Code(err)
+	return nil, err
+}
+
+func (eh *auditLoggingErrorHandler) Done() {
+	eh.blobAccess.emitRecord(eh.record)
+}
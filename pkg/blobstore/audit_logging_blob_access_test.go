@@ -0,0 +1,102 @@
+package blobstore_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuditLoggingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	auditLogSink := mock.NewMockAuditLogSink(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	blobAccess := blobstore.NewAuditLoggingBlobAccess(baseBlobAccess, auditLogSink, clock, 10, errorLogger)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	ctx = peer.NewContext(ctx, &peer.Peer{
+		Addr: &net.TCPAddr{
+			IP:   net.IPv4(192, 168, 1, 1),
+			Port: 12345,
+		},
+	})
+
+	// Records are written asynchronously. Let the sink forward
+	// them, so that the test can wait for them to arrive.
+	records := make(chan *blobstore.AuditRecord, 1)
+	auditLogSink.EXPECT().WriteAuditRecord(gomock.Any()).DoAndReturn(
+		func(record *blobstore.AuditRecord) error {
+			records <- record
+			return nil
+		}).AnyTimes()
+
+	t.Run("GetSuccess", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		baseBlobAccess.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+
+		require.Equal(t, &blobstore.AuditRecord{
+			Timestamp: time.Unix(1000, 0),
+			Principal: "192.168.1.1:12345",
+			Operation: "Get",
+			Digest:    helloDigest,
+			Code:      codes.OK,
+		}, <-records)
+	})
+
+	t.Run("GetFailure", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(1001, 0))
+		baseBlobAccess.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
+
+		_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
+
+		require.Equal(t, &blobstore.AuditRecord{
+			Timestamp: time.Unix(1001, 0),
+			Principal: "192.168.1.1:12345",
+			Operation: "Get",
+			Digest:    helloDigest,
+			Code:      codes.NotFound,
+		}, <-records)
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(1002, 0))
+		baseBlobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.Unavailable, "Server not reachable")
+			})
+
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Server not reachable"),
+			blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+		require.Equal(t, &blobstore.AuditRecord{
+			Timestamp: time.Unix(1002, 0),
+			Principal: "192.168.1.1:12345",
+			Operation: "Put",
+			Digest:    helloDigest,
+			Code:      codes.Unavailable,
+		}, <-records)
+	})
+}
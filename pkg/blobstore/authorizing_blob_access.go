@@ -0,0 +1,107 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Operation of BlobAccess for which authorization is requested.
+type Operation int
+
+const (
+	// OperationGet corresponds to BlobAccess.Get().
+	OperationGet Operation = iota
+	// OperationPut corresponds to BlobAccess.Put().
+	OperationPut
+	// OperationFindMissing corresponds to BlobAccess.FindMissing().
+	OperationFindMissing
+)
+
+func (o Operation) String() string {
+	switch o {
+	case OperationGet:
+		return "Get"
+	case OperationPut:
+		return "Put"
+	case OperationFindMissing:
+		return "FindMissing"
+	default:
+		return "Unknown"
+	}
+}
+
+// Authorizer decides whether the caller of a BlobAccess operation may
+// access data stored under a given instance name. The identity of the
+// caller may be obtained from the context (e.g., the TLS client
+// certificate stored in the gRPC peer information).
+//
+// Authorize() returns false if access is denied. An error is only
+// returned if no decision could be made, such as when an external
+// policy engine is unavailable.
+type Authorizer interface {
+	Authorize(ctx context.Context, instanceName digest.InstanceName, operation Operation) (bool, error)
+}
+
+type authorizingBlobAccess struct {
+	BlobAccess
+	authorizer Authorizer
+}
+
+// NewAuthorizingBlobAccess creates a decorator for BlobAccess that
+// only permits operations for instance names for which the caller is
+// authorized. Operations that are denied fail with PERMISSION_DENIED.
+// This may be used to isolate tenants in multi-tenant setups.
+func NewAuthorizingBlobAccess(base BlobAccess, authorizer Authorizer) BlobAccess {
+	return &authorizingBlobAccess{
+		BlobAccess: base,
+		authorizer: authorizer,
+	}
+}
+
+func (ba *authorizingBlobAccess) authorize(ctx context.Context, instanceName digest.InstanceName, operation Operation) error {
+	allowed, err := ba.authorizer.Authorize(ctx, instanceName, operation)
+	if err != nil {
+		return util.StatusWrapf(err, "Failed to authorize %s for instance name %#v", operation, instanceName.String())
+	}
+	if !allowed {
+		return status.Errorf(codes.PermissionDenied, "Not authorized to perform %s for instance name %#v", operation, instanceName.String())
+	}
+	return nil
+}
+
+func (ba *authorizingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	if err := ba.authorize(ctx, digest.GetInstanceName(), OperationGet); err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return ba.BlobAccess.Get(ctx, digest)
+}
+
+func (ba *authorizingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if err := ba.authorize(ctx, digest.GetInstanceName(), OperationPut); err != nil {
+		b.Discard()
+		return err
+	}
+	return ba.BlobAccess.Put(ctx, digest, b)
+}
+
+func (ba *authorizingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Requests may contain digests for multiple instance names.
+	// Only call into the authorizer once per instance name.
+	seen := map[digest.InstanceName]struct{}{}
+	for _, blobDigest := range digests.Items() {
+		instanceName := blobDigest.GetInstanceName()
+		if _, ok := seen[instanceName]; !ok {
+			seen[instanceName] = struct{}{}
+			if err := ba.authorize(ctx, instanceName, OperationFindMissing); err != nil {
+				return digest.EmptySet, err
+			}
+		}
+	}
+	return ba.BlobAccess.FindMissing(ctx, digests)
+}
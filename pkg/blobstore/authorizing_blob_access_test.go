@@ -0,0 +1,101 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthorizingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	authorizer := mock.NewMockAuthorizer(ctrl)
+	blobAccess := blobstore.NewAuthorizingBlobAccess(baseBlobAccess, authorizer)
+	allowedDigest := digest.MustNewDigest("allowed", "8b1a9953c4611296a827abf8c47804d7", 5)
+	deniedDigest := digest.MustNewDigest("denied", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("GetAllowed", func(t *testing.T) {
+		authorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("allowed"), blobstore.OperationGet).Return(true, nil)
+		baseBlobAccess.EXPECT().Get(ctx, allowedDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, allowedDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("GetDenied", func(t *testing.T) {
+		authorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("denied"), blobstore.OperationGet).Return(false, nil)
+
+		_, err := blobAccess.Get(ctx, deniedDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.PermissionDenied, "Not authorized to perform Get for instance name \"denied\""), err)
+	})
+
+	t.Run("GetAuthorizerFailure", func(t *testing.T) {
+		// Failures of the authorizer should be propagated, as
+		// opposed to being converted to PERMISSION_DENIED.
+		authorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("allowed"), blobstore.OperationGet).
+			Return(false, status.Error(codes.Unavailable, "Policy engine offline"))
+
+		_, err := blobAccess.Get(ctx, allowedDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Unavailable, "Failed to authorize Get for instance name \"allowed\": Policy engine offline"), err)
+	})
+
+	t.Run("PutAllowed", func(t *testing.T) {
+		authorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("allowed"), blobstore.OperationPut).Return(true, nil)
+		baseBlobAccess.EXPECT().Put(ctx, allowedDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, allowedDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("PutDenied", func(t *testing.T) {
+		authorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("denied"), blobstore.OperationPut).Return(false, nil)
+
+		require.Equal(
+			t,
+			status.Error(codes.PermissionDenied, "Not authorized to perform Put for instance name \"denied\""),
+			blobAccess.Put(ctx, deniedDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("FindMissingAllowed", func(t *testing.T) {
+		// The authorizer should only be called once per
+		// instance name.
+		digests := digest.NewSetBuilder().
+			Add(allowedDigest).
+			Add(digest.MustNewDigest("allowed", "6fc422233a40a75a1f028e11c3cd1140", 7)).
+			Build()
+		authorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("allowed"), blobstore.OperationFindMissing).Return(true, nil)
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(allowedDigest.ToSingletonSet(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, allowedDigest.ToSingletonSet(), missing)
+	})
+
+	t.Run("FindMissingDenied", func(t *testing.T) {
+		// Requests should be denied if access to one of the
+		// instance names is denied.
+		digests := digest.NewSetBuilder().Add(allowedDigest).Add(deniedDigest).Build()
+		authorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("allowed"), blobstore.OperationFindMissing).Return(true, nil)
+		authorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("denied"), blobstore.OperationFindMissing).Return(false, nil)
+
+		_, err := blobAccess.FindMissing(ctx, digests)
+		require.Equal(t, status.Error(codes.PermissionDenied, "Not authorized to perform FindMissing for instance name \"denied\""), err)
+	})
+}
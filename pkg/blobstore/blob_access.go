@@ -10,6 +10,23 @@ import (
 // BlobAccess is an abstraction for a data store that can be used to
 // hold both a Bazel Action Cache (AC) and Content Addressable Storage
 // (CAS).
+//
+// Implementations are expected to report the absence of blobs
+// consistently, as decorators (e.g., the ones used for replication
+// and read caching) depend on it:
+//
+// - Get() of a blob that is not present returns a buffer that fails
+//   with NotFound.
+// - FindMissing() reports absent blobs by including them in the
+//   resulting set. It never fails with NotFound.
+// - Put() never fails with NotFound.
+//
+// Other failures, such as the backend being unreachable, should be
+// reported with codes like Unavailable or Internal. Blobs whose
+// contents fail integrity checks should be reported using errors
+// annotated with buffer.MarkDataIntegrityError(). ClassifyError() may
+// be used to distinguish these cases. Backends that don't adhere to
+// these rules may be wrapped using NewErrorCodeNormalizingBlobAccess().
 type BlobAccess interface {
 	Get(ctx context.Context, digest digest.Digest) buffer.Buffer
 	Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error
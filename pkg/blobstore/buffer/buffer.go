@@ -3,9 +3,39 @@ package buffer
 import (
 	"io"
 
+	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// ErrSizeUnknown is returned by Buffer.GetSizeBytes() in case the
+// buffer is backed by a stream whose size can only be determined by
+// consuming it (e.g., ones created through NewValidatedBufferFromReader()
+// with a negative size). Callers that receive this error may still
+// access the buffer's contents through any of the other functions.
+var ErrSizeUnknown = status.Error(codes.Unimplemented, "Size of the buffer cannot be determined without reading its contents")
+
+// ErrDigestUnknown is returned by Buffer.Checksum() in case the digest
+// of the buffer's contents is not known up front. This is the case for
+// buffers backed by streams, as their contents have not been validated
+// yet, and for buffers that are not associated with a digest at all.
+var ErrDigestUnknown = status.Error(codes.Unimplemented, "Digest of the buffer cannot be determined without reading its contents")
+
+// ErrNotSeekable is returned by Buffer.ToSeekableReader() in case the
+// buffer is backed by a stream, or validates its contents while they
+// are being read. Such buffers can only be read sequentially.
+var ErrNotSeekable = status.Error(codes.Unimplemented, "Buffer can only be read sequentially")
+
+// ReadSeekCloser is the interface that groups the basic Read, Seek and
+// Close methods. It is returned by Buffer.ToSeekableReader().
+type ReadSeekCloser interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
 // Buffer of data to be read from/written to the Action Cache (AC) or
 // Content Addressable Storage (CAS).
 //
@@ -31,8 +61,26 @@ type Buffer interface {
 	// Return the size of the data stored in the buffer. This
 	// function may fail if the buffer is in a known error state in
 	// which the size of the object is unknown.
+	//
+	// This function never consumes the buffer. Buffers that are
+	// backed by a stream of which the size is not known up front
+	// return ErrSizeUnknown, as opposed to reading the stream.
+	// Callers that need the size ahead of time must then either
+	// use a code path that does not depend on it (e.g., a chunked
+	// upload), or load the buffer's contents into memory.
 	GetSizeBytes() (int64, error)
 
+	// Return the digest of the data stored in the buffer. Like
+	// GetSizeBytes(), this function never consumes the buffer.
+	//
+	// Only buffers whose contents are held in memory and have
+	// already been validated against a digest are capable of
+	// returning it. Other buffers return ErrDigestUnknown. This
+	// function may be used to check the consistency of data
+	// cheaply (e.g., to assert that a blob written into a mirror
+	// matches the original), without calling ToByteSlice().
+	Checksum() (digest.Digest, error)
+
 	// Of the public functions below, exactly one must be called to
 	// release any resources associated with the buffer (e.g., an
 	// io.ReadCloser).
@@ -54,11 +102,27 @@ type Buffer interface {
 	ToByteSlice(maximumSizeBytes int) ([]byte, error)
 	// Read the contents of the buffer, starting at a given offset,
 	// as a stream of byte slices. Normally used by the Content
-	// Addressable Storage.
-	ToChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader
+	// Addressable Storage. The sizes of the byte slices are
+	// controlled by a ChunkPolicy.
+	ToChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader
 	// Obtain a reader that returns the entire contents of the
 	// buffer.
 	ToReader() io.ReadCloser
+	// Obtain a reader that returns the entire contents of the
+	// buffer, permitting the caller to seek within it. This may be
+	// used by consumers that need random access to large objects
+	// (e.g., archives whose index is stored at the end), without
+	// loading them into memory entirely.
+	//
+	// Data returned by the reader is NOT validated against a
+	// digest, as seeking makes it impossible to compute a checksum
+	// sequentially. For this reason, this function is only
+	// supported by buffers whose contents are validated up front or
+	// are trusted (e.g., ones created through
+	// NewCASBufferFromByteSlice() or
+	// NewValidatedBufferFromFileReader()). Buffers backed by
+	// streams are released and return ErrNotSeekable.
+	ToSeekableReader() (ReadSeekCloser, error)
 	// Obtain two handles to the same underlying object in such a
 	// way that they may get copied. This function may be used when
 	// buffers need to be inspected prior to returning them.
@@ -95,6 +159,6 @@ type Buffer interface {
 	// concatenated. Checksum validation needs to happen across
 	// those parts, which is why the individual parts may be read
 	// with checksum validation disabled.
-	toUnvalidatedChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader
+	toUnvalidatedChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader
 	toUnvalidatedReader(off int64) io.ReadCloser
 }
@@ -8,18 +8,25 @@ import (
 )
 
 type casChunkReaderBuffer struct {
-	digest digest.Digest
-	r      ChunkReader
-	source Source
+	digest        digest.Digest
+	hasherFactory digest.HasherFactory
+	r             ChunkReader
+	source        Source
 }
 
 // NewCASBufferFromChunkReader creates a buffer for an object stored in
 // the Content Addressable Storage, backed by a ChunkReader.
 func NewCASBufferFromChunkReader(digest digest.Digest, r ChunkReader, source Source) Buffer {
+	hasherFactory, err := digest.GetHasherFactory()
+	if err != nil {
+		r.Close()
+		return NewBufferFromError(err)
+	}
 	return &casChunkReaderBuffer{
-		digest: digest,
-		r:      r,
-		source: source,
+		digest:        digest,
+		hasherFactory: hasherFactory,
+		r:             r,
+		source:        source,
 	}
 }
 
@@ -27,12 +34,16 @@ func (b *casChunkReaderBuffer) GetSizeBytes() (int64, error) {
 	return b.digest.GetSizeBytes(), nil
 }
 
+func (b *casChunkReaderBuffer) Checksum() (digest.Digest, error) {
+	return digest.BadDigest, ErrDigestUnknown
+}
+
 func (b *casChunkReaderBuffer) toValidatedChunkReader() ChunkReader {
-	return newCASValidatingChunkReader(b.r, b.digest, b.source)
+	return newCASValidatingChunkReader(b.r, b.digest, b.hasherFactory, b.source)
 }
 
 func (b *casChunkReaderBuffer) IntoWriter(w io.Writer) error {
-	return intoWriterViaChunkReader(b.toValidatedChunkReader(), w)
+	return intoWriterViaChunkReader(b.toValidatedChunkReader(), w, b.digest.GetSizeBytes())
 }
 
 func (b *casChunkReaderBuffer) ReadAt(p []byte, off int64) (int, error) {
@@ -47,24 +58,29 @@ func (b *casChunkReaderBuffer) ToByteSlice(maximumSizeBytes int) ([]byte, error)
 	return toByteSliceViaChunkReader(b.toValidatedChunkReader(), b.digest, maximumSizeBytes)
 }
 
-func (b *casChunkReaderBuffer) ToChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
+func (b *casChunkReaderBuffer) ToChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
 	if err := validateReaderOffset(b.digest.GetSizeBytes(), off); err != nil {
 		b.Discard()
 		return newErrorChunkReader(err)
 	}
-	return newNormalizingChunkReader(newOffsetChunkReader(b.toValidatedChunkReader(), off), maximumChunkSizeBytes)
+	return newNormalizingChunkReader(newOffsetChunkReader(b.toValidatedChunkReader(), off), chunkPolicy)
 }
 
 func (b *casChunkReaderBuffer) ToReader() io.ReadCloser {
 	return newChunkReaderBackedReader(b.toValidatedChunkReader())
 }
 
+func (b *casChunkReaderBuffer) ToSeekableReader() (ReadSeekCloser, error) {
+	b.Discard()
+	return nil, ErrNotSeekable
+}
+
 func (b *casChunkReaderBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
 	return cloneCopyViaByteSlice(b, maximumSizeBytes)
 }
 
 func (b *casChunkReaderBuffer) CloneStream() (Buffer, Buffer) {
-	return newCASClonedBuffer(b, b.digest, b.source).CloneStream()
+	return newCASClonedBuffer(b, b.digest, b.hasherFactory, b.source).CloneStream()
 }
 
 func (b *casChunkReaderBuffer) Discard() {
@@ -75,11 +91,11 @@ func (b *casChunkReaderBuffer) applyErrorHandler(errorHandler ErrorHandler) (Buf
 	// For stream-backed buffers, it is not yet known whether they
 	// may be read successfully. Wrap the buffer into one that
 	// handles I/O errors upon access.
-	return newCASErrorHandlingBuffer(b, errorHandler, b.digest, b.source), false
+	return newCASErrorHandlingBuffer(b, errorHandler, b.digest, b.hasherFactory, b.source), false
 }
 
-func (b *casChunkReaderBuffer) toUnvalidatedChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
-	return newNormalizingChunkReader(newOffsetChunkReader(b.r, off), maximumChunkSizeBytes)
+func (b *casChunkReaderBuffer) toUnvalidatedChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return newNormalizingChunkReader(newOffsetChunkReader(b.r, off), chunkPolicy)
 }
 
 func (b *casChunkReaderBuffer) toUnvalidatedReader(off int64) io.ReadCloser {
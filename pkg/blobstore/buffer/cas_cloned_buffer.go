@@ -1,10 +1,12 @@
 package buffer
 
 import (
+	"context"
 	"io"
 	"sync"
 
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
 	"github.com/golang/protobuf/proto"
 )
 
@@ -12,86 +14,143 @@ const (
 	defaultChunkSizeBytes = 64 * 1024
 )
 
-type casClonedBuffer struct {
-	base   Buffer
-	digest digest.Digest
-	source Source
+// casClonedBufferState contains the state of a casClonedBuffer that is
+// shared by all of its consumers.
+type casClonedBufferState struct {
+	lock               sync.Mutex
+	consumersRemaining uint
+	consumersWaiting   []chan *multiplexedChunkReader
+	needsValidation    bool
+	chunkPolicy        ChunkPolicy
+}
 
-	lock                  sync.Mutex
-	consumersRemaining    uint
-	consumersWaiting      []chan ChunkReader
-	needsValidation       bool
-	maximumChunkSizeBytes int
+type casClonedBuffer struct {
+	base          Buffer
+	digest        digest.Digest
+	hasherFactory digest.HasherFactory
+	source        Source
+	state         *casClonedBufferState
+	ctx           context.Context
 }
 
 // newCASClonedBuffer creates a decorator for CAS-backed buffer objects
 // that permits concurrent access to the same buffer. All consumers will
 // be synchronized, meaning that they will get access to the buffer's
 // contents at the same pace.
-func newCASClonedBuffer(base Buffer, digest digest.Digest, source Source) Buffer {
+func newCASClonedBuffer(base Buffer, digest digest.Digest, hasherFactory digest.HasherFactory, source Source) Buffer {
 	return &casClonedBuffer{
-		base:   base,
-		digest: digest,
-		source: source,
+		base:          base,
+		digest:        digest,
+		hasherFactory: hasherFactory,
+		source:        source,
+		state: &casClonedBufferState{
+			consumersRemaining: 1,
+		},
+		ctx: context.Background(),
+	}
+}
 
-		consumersRemaining:    1,
-		maximumChunkSizeBytes: -1,
+// WithContext binds a context to a Buffer that was obtained through
+// CloneStream(). Consumers of streamed clones normally wait for all
+// other consumers to make progress. Binding a context causes Read()
+// calls on the clone to stop waiting once the context is cancelled, in
+// which case the clone leaves the stream as if it were closed. This
+// permits the other consumers to proceed, even if the consumer of the
+// clone stalls.
+//
+// Buffers that are not streamed clones are returned unmodified, as
+// accessing them never blocks on other consumers.
+func WithContext(b Buffer, ctx context.Context) Buffer {
+	if bCloned, ok := b.(*casClonedBuffer); ok {
+		return &casClonedBuffer{
+			base:          bCloned.base,
+			digest:        bCloned.digest,
+			hasherFactory: bCloned.hasherFactory,
+			source:        bCloned.source,
+			state:         bCloned.state,
+			ctx:           ctx,
+		}
 	}
+	return b
 }
 
 func (b *casClonedBuffer) GetSizeBytes() (int64, error) {
 	return b.digest.GetSizeBytes(), nil
 }
 
-func (b *casClonedBuffer) toChunkReader(needsValidation bool, maximumChunkSizeBytes int) ChunkReader {
-	b.lock.Lock()
-	if b.consumersRemaining == 0 {
+func (b *casClonedBuffer) Checksum() (digest.Digest, error) {
+	return b.base.Checksum()
+}
+
+func (b *casClonedBuffer) toChunkReader(needsValidation bool, chunkPolicy ChunkPolicy) ChunkReader {
+	state := b.state
+	state.lock.Lock()
+	if state.consumersRemaining == 0 {
 		panic("Attempted to obtain a chunk reader for a buffer that is already fully consumed")
 	}
-	b.consumersRemaining--
+	state.consumersRemaining--
 
 	// Provide constraints that this consumer desires.
-	b.needsValidation = b.needsValidation || needsValidation
-	if b.maximumChunkSizeBytes < 0 || b.maximumChunkSizeBytes > maximumChunkSizeBytes {
-		b.maximumChunkSizeBytes = maximumChunkSizeBytes
-	}
+	state.needsValidation = state.needsValidation || needsValidation
+	state.chunkPolicy = state.chunkPolicy.merge(chunkPolicy)
 
 	// Create the underlying ChunkReader in case all consumers have
 	// supplied their constraints.
-	if b.consumersRemaining == 0 {
+	if state.consumersRemaining == 0 {
 		// If there is at least one consumer that needs checksum
 		// validation, we use checksum validation for everyone.
 		var r ChunkReader
-		if b.needsValidation {
-			r = b.base.ToChunkReader(0, b.maximumChunkSizeBytes)
+		if state.needsValidation {
+			r = b.base.ToChunkReader(0, state.chunkPolicy)
 		} else {
-			r = b.base.toUnvalidatedChunkReader(0, b.maximumChunkSizeBytes)
+			r = b.base.toUnvalidatedChunkReader(0, state.chunkPolicy)
 		}
 
 		// Give all consumers their own ChunkReader.
-		rMultiplexed := newMultiplexedChunkReader(r, len(b.consumersWaiting))
-		for _, c := range b.consumersWaiting {
+		rMultiplexed := newMultiplexedChunkReader(r, len(state.consumersWaiting))
+		for _, c := range state.consumersWaiting {
 			c <- rMultiplexed
 		}
-		b.lock.Unlock()
-		return rMultiplexed
+		state.lock.Unlock()
+		return rMultiplexed.newConsumer(b.ctx)
 	}
 
 	// There are other consumers that still have to supply their
 	// constraints. Let the last consumer create the ChunkReader and
 	// hand it out.
-	c := make(chan ChunkReader, 1)
-	b.consumersWaiting = append(b.consumersWaiting, c)
-	b.lock.Unlock()
-	return <-c
+	c := make(chan *multiplexedChunkReader, 1)
+	state.consumersWaiting = append(state.consumersWaiting, c)
+	state.lock.Unlock()
+	select {
+	case rMultiplexed := <-c:
+		return rMultiplexed.newConsumer(b.ctx)
+	case <-b.ctx.Done():
+	}
+
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	for i, cWaiting := range state.consumersWaiting {
+		if cWaiting == c {
+			// The ChunkReader has not been created yet.
+			// Ensure it's not handed out to us.
+			last := len(state.consumersWaiting) - 1
+			state.consumersWaiting[i] = state.consumersWaiting[last]
+			state.consumersWaiting = state.consumersWaiting[:last]
+			return newErrorChunkReader(util.StatusFromContext(b.ctx))
+		}
+	}
+
+	// The ChunkReader got created while the context was cancelled.
+	// Let the consumer leave the stream upon first use.
+	return (<-c).newConsumer(b.ctx)
 }
 
 func (b *casClonedBuffer) IntoWriter(w io.Writer) error {
-	return intoWriterViaChunkReader(b.toChunkReader(true, defaultChunkSizeBytes), w)
+	return intoWriterViaChunkReader(b.toChunkReader(true, ChunkSizeAtMost(defaultChunkSizeBytes)), w, b.digest.GetSizeBytes())
 }
 
 func (b *casClonedBuffer) ReadAt(p []byte, off int64) (int, error) {
-	return readAtViaChunkReader(b.toChunkReader(true, defaultChunkSizeBytes), p, off)
+	return readAtViaChunkReader(b.toChunkReader(true, ChunkSizeAtMost(defaultChunkSizeBytes)), p, off)
 }
 
 func (b *casClonedBuffer) ToProto(m proto.Message, maximumSizeBytes int) (proto.Message, error) {
@@ -99,15 +158,20 @@ func (b *casClonedBuffer) ToProto(m proto.Message, maximumSizeBytes int) (proto.
 }
 
 func (b *casClonedBuffer) ToByteSlice(maximumSizeBytes int) ([]byte, error) {
-	return toByteSliceViaChunkReader(b.toChunkReader(true, defaultChunkSizeBytes), b.digest, maximumSizeBytes)
+	return toByteSliceViaChunkReader(b.toChunkReader(true, ChunkSizeAtMost(defaultChunkSizeBytes)), b.digest, maximumSizeBytes)
 }
 
-func (b *casClonedBuffer) ToChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
-	return newOffsetChunkReader(b.toChunkReader(true, maximumChunkSizeBytes), off)
+func (b *casClonedBuffer) ToChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return newNormalizingChunkReader(newOffsetChunkReader(b.toChunkReader(true, chunkPolicy), off), chunkPolicy)
 }
 
 func (b *casClonedBuffer) ToReader() io.ReadCloser {
-	return newChunkReaderBackedReader(b.toChunkReader(true, defaultChunkSizeBytes))
+	return newChunkReaderBackedReader(b.toChunkReader(true, ChunkSizeAtMost(defaultChunkSizeBytes)))
+}
+
+func (b *casClonedBuffer) ToSeekableReader() (ReadSeekCloser, error) {
+	b.Discard()
+	return nil, ErrNotSeekable
 }
 
 func (b *casClonedBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
@@ -115,31 +179,32 @@ func (b *casClonedBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
 }
 
 func (b *casClonedBuffer) CloneStream() (Buffer, Buffer) {
-	b.lock.Lock()
-	defer b.lock.Unlock()
+	state := b.state
+	state.lock.Lock()
+	defer state.lock.Unlock()
 
-	if b.consumersRemaining == 0 {
+	if state.consumersRemaining == 0 {
 		panic("Attempted to clone stream for a buffer that is already fully consumed")
 	}
-	b.consumersRemaining++
+	state.consumersRemaining++
 	return b, b
 }
 
 func (b *casClonedBuffer) Discard() {
-	b.toChunkReader(false, defaultChunkSizeBytes).Close()
+	b.toChunkReader(false, ChunkSizeAtMost(defaultChunkSizeBytes)).Close()
 }
 
 func (b *casClonedBuffer) applyErrorHandler(errorHandler ErrorHandler) (replacement Buffer, shouldRetry bool) {
 	// For stream-backed buffers, it is not yet known whether they
 	// may be read successfully. Wrap the buffer into one that
 	// handles I/O errors upon access.
-	return newCASErrorHandlingBuffer(b, errorHandler, b.digest, b.source), false
+	return newCASErrorHandlingBuffer(b, errorHandler, b.digest, b.hasherFactory, b.source), false
 }
 
-func (b *casClonedBuffer) toUnvalidatedChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
-	return newOffsetChunkReader(b.toChunkReader(false, maximumChunkSizeBytes), off)
+func (b *casClonedBuffer) toUnvalidatedChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return newNormalizingChunkReader(newOffsetChunkReader(b.toChunkReader(false, chunkPolicy), off), chunkPolicy)
 }
 
 func (b *casClonedBuffer) toUnvalidatedReader(off int64) io.ReadCloser {
-	return newChunkReaderBackedReader(b.toUnvalidatedChunkReader(off, defaultChunkSizeBytes))
+	return newChunkReaderBackedReader(b.toUnvalidatedChunkReader(off, ChunkSizeAtMost(defaultChunkSizeBytes)))
 }
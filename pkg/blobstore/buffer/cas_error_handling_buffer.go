@@ -8,10 +8,11 @@ import (
 )
 
 type casErrorHandlingBuffer struct {
-	base         Buffer
-	errorHandler ErrorHandler
-	digest       digest.Digest
-	source       Source
+	base          Buffer
+	errorHandler  ErrorHandler
+	digest        digest.Digest
+	hasherFactory digest.HasherFactory
+	source        Source
 }
 
 // newCASErrorHandlingBuffer is a decorator for Buffer that handles I/O
@@ -19,12 +20,13 @@ type casErrorHandlingBuffer struct {
 // capable of returning an alternative buffer that should be used to
 // continue the transfer. This decorator will retry/resume the same call
 // against the new buffer.
-func newCASErrorHandlingBuffer(base Buffer, errorHandler ErrorHandler, digest digest.Digest, source Source) Buffer {
+func newCASErrorHandlingBuffer(base Buffer, errorHandler ErrorHandler, digest digest.Digest, hasherFactory digest.HasherFactory, source Source) Buffer {
 	return &casErrorHandlingBuffer{
-		base:         base,
-		errorHandler: errorHandler,
-		digest:       digest,
-		source:       source,
+		base:          base,
+		errorHandler:  errorHandler,
+		digest:        digest,
+		hasherFactory: hasherFactory,
+		source:        source,
 	}
 }
 
@@ -32,6 +34,10 @@ func (b *casErrorHandlingBuffer) GetSizeBytes() (int64, error) {
 	return b.digest.GetSizeBytes(), nil
 }
 
+func (b *casErrorHandlingBuffer) Checksum() (digest.Digest, error) {
+	return digest.BadDigest, ErrDigestUnknown
+}
+
 // tryRepeatedly implements the retrying strategy for buffer operations
 // that can safely be retried in their entirety, without causing partial
 // data to be written twice.
@@ -56,15 +62,15 @@ func (b *casErrorHandlingBuffer) tryRepeatedly(f func(Buffer) error) error {
 	}
 }
 
-func (b *casErrorHandlingBuffer) toValidatedChunkReader(maximumChunkSizeBytes int) ChunkReader {
-	return newCASValidatingChunkReader(b.toUnvalidatedChunkReader(0, maximumChunkSizeBytes), b.digest, b.source)
+func (b *casErrorHandlingBuffer) toValidatedChunkReader(chunkPolicy ChunkPolicy) ChunkReader {
+	return newCASValidatingChunkReader(b.toUnvalidatedChunkReader(0, chunkPolicy), b.digest, b.hasherFactory, b.source)
 }
 
 func (b *casErrorHandlingBuffer) IntoWriter(w io.Writer) error {
 	// This operation cannot use tryRepeatedly(), as individual
 	// retries may write parts to the output stream. Copy into the
 	// output stream using a retrying ChunkReader.
-	return intoWriterViaChunkReader(b.toValidatedChunkReader(64*1024), w)
+	return intoWriterViaChunkReader(b.toValidatedChunkReader(ChunkSizeAtMost(64*1024)), w, b.digest.GetSizeBytes())
 }
 
 func (b *casErrorHandlingBuffer) ReadAt(p []byte, off int64) (n int, translatedErr error) {
@@ -94,16 +100,21 @@ func (b *casErrorHandlingBuffer) ToByteSlice(maximumSizeBytes int) (data []byte,
 	return
 }
 
-func (b *casErrorHandlingBuffer) ToChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
+func (b *casErrorHandlingBuffer) ToChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
 	if err := validateReaderOffset(b.digest.GetSizeBytes(), off); err != nil {
 		b.Discard()
 		return newErrorChunkReader(err)
 	}
-	return newOffsetChunkReader(b.toValidatedChunkReader(maximumChunkSizeBytes), off)
+	return newNormalizingChunkReader(newOffsetChunkReader(b.toValidatedChunkReader(chunkPolicy), off), chunkPolicy)
 }
 
 func (b *casErrorHandlingBuffer) ToReader() io.ReadCloser {
-	return newCASValidatingReader(b.toUnvalidatedReader(0), b.digest, b.source)
+	return newCASValidatingReader(b.toUnvalidatedReader(0), b.digest, b.hasherFactory, b.source)
+}
+
+func (b *casErrorHandlingBuffer) ToSeekableReader() (ReadSeekCloser, error) {
+	b.Discard()
+	return nil, ErrNotSeekable
 }
 
 func (b *casErrorHandlingBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
@@ -111,7 +122,7 @@ func (b *casErrorHandlingBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer
 }
 
 func (b *casErrorHandlingBuffer) CloneStream() (Buffer, Buffer) {
-	return newCASClonedBuffer(b, b.digest, b.source).CloneStream()
+	return newCASClonedBuffer(b, b.digest, b.hasherFactory, b.source).CloneStream()
 }
 
 func (b *casErrorHandlingBuffer) Discard() {
@@ -123,11 +134,11 @@ func (b *casErrorHandlingBuffer) applyErrorHandler(errorHandler ErrorHandler) (B
 	// For stream-backed buffers, it is not yet known whether they
 	// may be read successfully. Wrap the buffer into one that
 	// handles I/O errors upon access.
-	return newCASErrorHandlingBuffer(b, errorHandler, b.digest, b.source), false
+	return newCASErrorHandlingBuffer(b, errorHandler, b.digest, b.hasherFactory, b.source), false
 }
 
-func (b *casErrorHandlingBuffer) toUnvalidatedChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
-	return newErrorHandlingChunkReader(b.base, b.errorHandler, off, maximumChunkSizeBytes)
+func (b *casErrorHandlingBuffer) toUnvalidatedChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return newErrorHandlingChunkReader(b.base, b.errorHandler, off, chunkPolicy)
 }
 
 func (b *casErrorHandlingBuffer) toUnvalidatedReader(off int64) io.ReadCloser {
@@ -12,19 +12,26 @@ import (
 )
 
 type casReaderBuffer struct {
-	digest digest.Digest
-	r      io.ReadCloser
-	source Source
+	digest        digest.Digest
+	hasherFactory digest.HasherFactory
+	r             io.ReadCloser
+	source        Source
 }
 
 // NewCASBufferFromReader creates a buffer for an object stored in the
 // Content Addressable Storage, whose contents may be obtained through a
 // ReadCloser.
 func NewCASBufferFromReader(digest digest.Digest, r io.ReadCloser, source Source) Buffer {
+	hasherFactory, err := digest.GetHasherFactory()
+	if err != nil {
+		r.Close()
+		return NewBufferFromError(err)
+	}
 	return &casReaderBuffer{
-		digest: digest,
-		r:      r,
-		source: source,
+		digest:        digest,
+		hasherFactory: hasherFactory,
+		r:             r,
+		source:        source,
 	}
 }
 
@@ -32,8 +39,12 @@ func (b *casReaderBuffer) GetSizeBytes() (int64, error) {
 	return b.digest.GetSizeBytes(), nil
 }
 
+func (b *casReaderBuffer) Checksum() (digest.Digest, error) {
+	return digest.BadDigest, ErrDigestUnknown
+}
+
 func (b *casReaderBuffer) toValidatedReader() io.ReadCloser {
-	return newCASValidatingReader(b.r, b.digest, b.source)
+	return newCASValidatingReader(b.r, b.digest, b.hasherFactory, b.source)
 }
 
 func (b *casReaderBuffer) IntoWriter(w io.Writer) error {
@@ -84,7 +95,7 @@ func (b *casReaderBuffer) ToByteSlice(maximumSizeBytes int) ([]byte, error) {
 	return ioutil.ReadAll(r)
 }
 
-func (b *casReaderBuffer) ToChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
+func (b *casReaderBuffer) ToChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
 	if err := validateReaderOffset(b.digest.GetSizeBytes(), off); err != nil {
 		b.r.Close()
 		return newErrorChunkReader(err)
@@ -95,19 +106,24 @@ func (b *casReaderBuffer) ToChunkReader(off int64, maximumChunkSizeBytes int) Ch
 		r.Close()
 		return newErrorChunkReader(err)
 	}
-	return newReaderBackedChunkReader(r, maximumChunkSizeBytes)
+	return newReaderBackedChunkReader(r, chunkPolicy)
 }
 
 func (b *casReaderBuffer) ToReader() io.ReadCloser {
 	return b.toValidatedReader()
 }
 
+func (b *casReaderBuffer) ToSeekableReader() (ReadSeekCloser, error) {
+	b.Discard()
+	return nil, ErrNotSeekable
+}
+
 func (b *casReaderBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
 	return cloneCopyViaByteSlice(b, maximumSizeBytes)
 }
 
 func (b *casReaderBuffer) CloneStream() (Buffer, Buffer) {
-	return newCASClonedBuffer(b, b.digest, b.source).CloneStream()
+	return newCASClonedBuffer(b, b.digest, b.hasherFactory, b.source).CloneStream()
 }
 
 func (b *casReaderBuffer) Discard() {
@@ -118,15 +134,15 @@ func (b *casReaderBuffer) applyErrorHandler(errorHandler ErrorHandler) (Buffer,
 	// For stream-backed buffers, it is not yet known whether they
 	// may be read successfully. Wrap the buffer into one that
 	// handles I/O errors upon access.
-	return newCASErrorHandlingBuffer(b, errorHandler, b.digest, b.source), false
+	return newCASErrorHandlingBuffer(b, errorHandler, b.digest, b.hasherFactory, b.source), false
 }
 
-func (b *casReaderBuffer) toUnvalidatedChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
+func (b *casReaderBuffer) toUnvalidatedChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
 	if err := discardFromReader(b.r, off); err != nil {
 		b.r.Close()
 		return newErrorChunkReader(err)
 	}
-	return newReaderBackedChunkReader(b.r, maximumChunkSizeBytes)
+	return newReaderBackedChunkReader(b.r, chunkPolicy)
 }
 
 func (b *casReaderBuffer) toUnvalidatedReader(off int64) io.ReadCloser {
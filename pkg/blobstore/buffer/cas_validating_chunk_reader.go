@@ -23,13 +23,13 @@ type casValidatingChunkReader struct {
 // by the Content Addressable Storage. It has been implemented in such a
 // way that it does not allow access to the full stream's contents in
 // case of size or checksum mismatches.
-func newCASValidatingChunkReader(r ChunkReader, digest digest.Digest, source Source) ChunkReader {
+func newCASValidatingChunkReader(r ChunkReader, digest digest.Digest, hasherFactory digest.HasherFactory, source Source) ChunkReader {
 	return &casValidatingChunkReader{
 		ChunkReader: r,
 		digest:      digest,
 		source:      source,
 
-		hasher:         digest.NewHasher(),
+		hasher:         hasherFactory(),
 		bytesRemaining: digest.GetSizeBytes(),
 	}
 }
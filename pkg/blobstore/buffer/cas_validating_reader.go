@@ -23,13 +23,21 @@ type casValidatingReader struct {
 // by the Content Addressable Storage. It has been implemented in such a
 // way that it does not allow access to the full stream's contents in
 // case of size or checksum mismatches.
-func newCASValidatingReader(r io.ReadCloser, digest digest.Digest, source Source) io.ReadCloser {
+//
+// TODO: Corruption of large blobs is only detected upon reaching the
+// end of the stream, as the checksum can only be computed over the
+// blob's contents in their entirety. Digest functions that are based
+// on a Merkle tree (e.g., BLAKE3) would permit validating individual
+// chunks as they are read, thereby failing early. Add such a mode to
+// this type and casValidatingChunkReader once the digest package
+// supports such digest functions.
+func newCASValidatingReader(r io.ReadCloser, digest digest.Digest, hasherFactory digest.HasherFactory, source Source) io.ReadCloser {
 	return &casValidatingReader{
 		ReadCloser: r,
 		digest:     digest,
 		source:     source,
 
-		hasher:         digest.NewHasher(),
+		hasher:         hasherFactory(),
 		bytesRemaining: digest.GetSizeBytes(),
 	}
 }
@@ -0,0 +1,76 @@
+package buffer
+
+// ChunkPolicy specifies the sizes of the chunks that are returned by
+// ChunkReaders obtained through Buffer.ToChunkReader().
+//
+// When both a minimum and a maximum chunk size are specified, chunks
+// returned by the ChunkReader are at least the minimum and at most the
+// maximum size. The only exception is the final chunk in the stream,
+// which may be smaller than the minimum size. When the minimum size
+// exceeds the maximum size, the maximum size takes precedence, as it
+// is typically imposed by a transfer protocol and cannot be violated.
+type ChunkPolicy struct {
+	minimumSizeBytes int
+	maximumSizeBytes int
+}
+
+// ChunkSizeAtMost is a ChunkPolicy that causes chunks to be at most a
+// provided size. Chunks may be arbitrarily small.
+func ChunkSizeAtMost(maximumSizeBytes int) ChunkPolicy {
+	return ChunkPolicy{
+		maximumSizeBytes: maximumSizeBytes,
+	}
+}
+
+// ChunkSizeAtLeast is a ChunkPolicy that causes chunks to be at least a
+// provided size, except for the final chunk in the stream. Small
+// chunks obtained from the underlying storage are coalesced. This may
+// be used by consumers that process small chunks inefficiently (e.g.,
+// compression encoders).
+func ChunkSizeAtLeast(minimumSizeBytes int) ChunkPolicy {
+	return ChunkPolicy{
+		minimumSizeBytes: minimumSizeBytes,
+	}
+}
+
+// ChunkSizeBetween is a ChunkPolicy that causes chunks to be at least
+// and at most a provided size, except for the final chunk in the
+// stream, which may be smaller than the minimum size.
+func ChunkSizeBetween(minimumSizeBytes int, maximumSizeBytes int) ChunkPolicy {
+	return ChunkPolicy{
+		minimumSizeBytes: minimumSizeBytes,
+		maximumSizeBytes: maximumSizeBytes,
+	}
+}
+
+// hasMaximum returns whether the ChunkPolicy places an upper bound on
+// the size of chunks.
+func (cp ChunkPolicy) hasMaximum() bool {
+	return cp.maximumSizeBytes > 0
+}
+
+// readSizeBytes returns the size of the buffers that ChunkReaders
+// should allocate when reading data from a stream. Filling buffers of
+// this size completely yields chunks that respect the ChunkPolicy.
+func (cp ChunkPolicy) readSizeBytes() int {
+	if cp.hasMaximum() {
+		return cp.maximumSizeBytes
+	}
+	if cp.minimumSizeBytes > defaultChunkSizeBytes {
+		return cp.minimumSizeBytes
+	}
+	return defaultChunkSizeBytes
+}
+
+// merge two ChunkPolicies, returning a ChunkPolicy that is at least as
+// strict as both of them. This is used when multiple consumers of a
+// cloned buffer share the same underlying ChunkReader.
+func (cp ChunkPolicy) merge(other ChunkPolicy) ChunkPolicy {
+	if other.minimumSizeBytes > cp.minimumSizeBytes {
+		cp.minimumSizeBytes = other.minimumSizeBytes
+	}
+	if other.hasMaximum() && (!cp.hasMaximum() || other.maximumSizeBytes < cp.maximumSizeBytes) {
+		cp.maximumSizeBytes = other.maximumSizeBytes
+	}
+	return cp
+}
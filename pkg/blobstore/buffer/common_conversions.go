@@ -11,19 +11,32 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-func intoWriterViaChunkReader(r ChunkReader, w io.Writer) error {
+// intoWriterViaChunkReader copies all data returned by a ChunkReader
+// into a Writer. If sizeBytes is non-negative, the amount of data
+// returned by the ChunkReader is compared against it, so that
+// truncated or overly long streams are not written silently. Data in
+// excess of sizeBytes is not written.
+func intoWriterViaChunkReader(r ChunkReader, w io.Writer, sizeBytes int64) error {
 	defer r.Close()
 
+	bytesWritten := int64(0)
 	for {
 		chunk, err := r.Read()
 		if err == io.EOF {
+			if sizeBytes >= 0 && bytesWritten != sizeBytes {
+				return MarkDataIntegrityError(status.Errorf(codes.DataLoss, "Buffer is %d bytes in size, while %d bytes were expected", bytesWritten, sizeBytes))
+			}
 			return nil
 		} else if err != nil {
 			return err
 		}
+		if sizeBytes >= 0 && int64(len(chunk)) > sizeBytes-bytesWritten {
+			return MarkDataIntegrityError(status.Errorf(codes.DataLoss, "Buffer is at least %d bytes in size, while %d bytes were expected", bytesWritten+int64(len(chunk)), sizeBytes))
+		}
 		if _, err := w.Write(chunk); err != nil {
 			return err
 		}
+		bytesWritten += int64(len(chunk))
 	}
 }
 
@@ -0,0 +1,224 @@
+package buffer
+
+import (
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrPartSizesUnknown is returned by Buffer.ReadAt() on buffers created
+// through NewConcatenatedBuffer() if the sizes of the parts cannot be
+// determined without reading their contents.
+var ErrPartSizesUnknown = status.Error(codes.Unimplemented, "Random access requires the sizes of all parts of the buffer to be known")
+
+type concatenatedBuffer struct {
+	digest digest.Digest
+	source Source
+	parts  []Buffer
+	// Offsets at which the parts start, followed by the total
+	// size. Only set if the sizes of all parts are known.
+	partOffsets []int64
+}
+
+// NewConcatenatedBuffer creates a buffer for an object stored in the
+// Content Addressable Storage, whose contents are the concatenation of
+// the contents of a list of other buffers. This may be used to
+// reassemble blobs that have been decomposed into multiple parts.
+//
+// Every part is validated individually when read, while the contents
+// of the buffer as a whole are validated against the provided digest
+// when read in their entirety. Validation failures of the latter are
+// reported through the provided Source.
+//
+// ReadAt() only reads the parts that overlap with the requested range,
+// meaning that it does not validate the buffer as a whole. It requires
+// that the sizes of all parts are known, as it would otherwise be
+// impossible to locate the parts containing the requested range.
+// ErrPartSizesUnknown is returned if this is not the case.
+func NewConcatenatedBuffer(blobDigest digest.Digest, source Source, parts ...Buffer) Buffer {
+	partOffsets := make([]int64, 0, len(parts)+1)
+	offset := int64(0)
+	for _, part := range parts {
+		sizeBytes, err := part.GetSizeBytes()
+		if err == ErrSizeUnknown {
+			partOffsets = nil
+			break
+		} else if err != nil {
+			discardBuffers(parts)
+			return NewBufferFromError(err)
+		}
+		partOffsets = append(partOffsets, offset)
+		offset += sizeBytes
+	}
+	if partOffsets != nil {
+		if expectedSizeBytes := blobDigest.GetSizeBytes(); offset != expectedSizeBytes {
+			discardBuffers(parts)
+			return NewBufferFromError(source.notifyCASSizeMismatch(expectedSizeBytes, offset))
+		}
+		partOffsets = append(partOffsets, offset)
+	}
+
+	return &concatenatedBuffer{
+		digest:      blobDigest,
+		source:      source,
+		parts:       parts,
+		partOffsets: partOffsets,
+	}
+}
+
+func discardBuffers(buffers []Buffer) {
+	for _, b := range buffers {
+		b.Discard()
+	}
+}
+
+// toCASBuffer converts the concatenated buffer to a plain CAS buffer
+// that reads the parts sequentially. This is used to implement all
+// operations other than ReadAt().
+func (b *concatenatedBuffer) toCASBuffer() Buffer {
+	return NewCASBufferFromChunkReader(b.digest, &concatenatedChunkReader{parts: b.parts}, b.source)
+}
+
+func (b *concatenatedBuffer) GetSizeBytes() (int64, error) {
+	return b.digest.GetSizeBytes(), nil
+}
+
+func (b *concatenatedBuffer) Checksum() (digest.Digest, error) {
+	return digest.BadDigest, ErrDigestUnknown
+}
+
+func (b *concatenatedBuffer) IntoWriter(w io.Writer) error {
+	return b.toCASBuffer().IntoWriter(w)
+}
+
+func (b *concatenatedBuffer) ReadAt(p []byte, off int64) (int, error) {
+	if b.partOffsets == nil {
+		b.Discard()
+		return 0, ErrPartSizesUnknown
+	}
+	sizeBytes := b.partOffsets[len(b.parts)]
+	if err := validateReaderOffset(sizeBytes, off); err != nil {
+		b.Discard()
+		return 0, err
+	}
+
+	// Read data from all parts that overlap with the requested
+	// range. Parts outside of the range are discarded.
+	end := off + int64(len(p))
+	if end > sizeBytes {
+		end = sizeBytes
+	}
+	nTotal := 0
+	for i, part := range b.parts {
+		partStart, partEnd := b.partOffsets[i], b.partOffsets[i+1]
+		if partEnd <= off || partStart >= end {
+			part.Discard()
+			continue
+		}
+		readStart := off
+		if readStart < partStart {
+			readStart = partStart
+		}
+		readEnd := end
+		if readEnd > partEnd {
+			readEnd = partEnd
+		}
+		partP := p[readStart-off : readEnd-off]
+		n, err := part.ReadAt(partP, readStart-partStart)
+		nTotal += n
+		if err != nil && (err != io.EOF || n != len(partP)) {
+			if err == io.EOF {
+				err = status.Errorf(codes.Internal, "Part %d is smaller than its declared size", i)
+			}
+			discardBuffers(b.parts[i+1:])
+			return nTotal, err
+		}
+	}
+	if nTotal < len(p) {
+		return nTotal, io.EOF
+	}
+	return nTotal, nil
+}
+
+func (b *concatenatedBuffer) ToProto(m proto.Message, maximumSizeBytes int) (proto.Message, error) {
+	return b.toCASBuffer().ToProto(m, maximumSizeBytes)
+}
+
+func (b *concatenatedBuffer) ToByteSlice(maximumSizeBytes int) ([]byte, error) {
+	return b.toCASBuffer().ToByteSlice(maximumSizeBytes)
+}
+
+func (b *concatenatedBuffer) ToChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return b.toCASBuffer().ToChunkReader(off, chunkPolicy)
+}
+
+func (b *concatenatedBuffer) ToReader() io.ReadCloser {
+	return b.toCASBuffer().ToReader()
+}
+
+func (b *concatenatedBuffer) ToSeekableReader() (ReadSeekCloser, error) {
+	b.Discard()
+	return nil, ErrNotSeekable
+}
+
+func (b *concatenatedBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
+	return b.toCASBuffer().CloneCopy(maximumSizeBytes)
+}
+
+func (b *concatenatedBuffer) CloneStream() (Buffer, Buffer) {
+	return b.toCASBuffer().CloneStream()
+}
+
+func (b *concatenatedBuffer) Discard() {
+	discardBuffers(b.parts)
+}
+
+func (b *concatenatedBuffer) applyErrorHandler(errorHandler ErrorHandler) (Buffer, bool) {
+	return b.toCASBuffer().applyErrorHandler(errorHandler)
+}
+
+func (b *concatenatedBuffer) toUnvalidatedChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return b.toCASBuffer().toUnvalidatedChunkReader(off, chunkPolicy)
+}
+
+func (b *concatenatedBuffer) toUnvalidatedReader(off int64) io.ReadCloser {
+	return b.toCASBuffer().toUnvalidatedReader(off)
+}
+
+// concatenatedChunkReader is a ChunkReader that returns the contents
+// of a list of buffers in order.
+type concatenatedChunkReader struct {
+	parts   []Buffer
+	current ChunkReader
+}
+
+func (r *concatenatedChunkReader) Read() ([]byte, error) {
+	for {
+		if r.current == nil {
+			if len(r.parts) == 0 {
+				return nil, io.EOF
+			}
+			r.current = r.parts[0].ToChunkReader(0, ChunkSizeAtMost(defaultChunkSizeBytes))
+			r.parts = r.parts[1:]
+		}
+		chunk, err := r.current.Read()
+		if err != io.EOF {
+			return chunk, err
+		}
+		r.current.Close()
+		r.current = nil
+	}
+}
+
+func (r *concatenatedChunkReader) Close() {
+	if r.current != nil {
+		r.current.Close()
+		r.current = nil
+	}
+	discardBuffers(r.parts)
+	r.parts = nil
+}
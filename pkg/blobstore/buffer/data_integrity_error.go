@@ -0,0 +1,42 @@
+package buffer
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	dataIntegrityErrorDomain = "buildbarn.github.io"
+	dataIntegrityErrorReason = "DATA_INTEGRITY"
+)
+
+// MarkDataIntegrityError annotates a gRPC status error to indicate that
+// it was caused by data failing integrity checks (e.g., a blob having
+// the wrong size or checksum, or an Action Cache entry not being a
+// valid message), as opposed to the blob being absent or the backend
+// being unreachable.
+//
+// The annotation is stored in the details of the status, meaning that
+// the error code and message are left intact. It is preserved by
+// util.StatusWrap*() and when the error is sent over gRPC.
+func MarkDataIntegrityError(err error) error {
+	s, detailsErr := status.Convert(err).WithDetails(&errdetails.ErrorInfo{
+		Reason: dataIntegrityErrorReason,
+		Domain: dataIntegrityErrorDomain,
+	})
+	if detailsErr != nil {
+		return err
+	}
+	return s.Err()
+}
+
+// IsDataIntegrityError returns whether an error has been annotated
+// using MarkDataIntegrityError().
+func IsDataIntegrityError(err error) bool {
+	for _, detail := range status.Convert(err).Details() {
+		if errorInfo, ok := detail.(*errdetails.ErrorInfo); ok && errorInfo.Domain == dataIntegrityErrorDomain && errorInfo.Reason == dataIntegrityErrorReason {
+			return true
+		}
+	}
+	return false
+}
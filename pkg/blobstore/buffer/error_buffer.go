@@ -3,6 +3,7 @@ package buffer
 import (
 	"io"
 
+	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/golang/protobuf/proto"
 )
 
@@ -22,6 +23,10 @@ func (b errorBuffer) GetSizeBytes() (int64, error) {
 	return 0, b.err
 }
 
+func (b errorBuffer) Checksum() (digest.Digest, error) {
+	return digest.BadDigest, b.err
+}
+
 func (b errorBuffer) IntoWriter(w io.Writer) error {
 	return b.err
 }
@@ -38,7 +43,7 @@ func (b errorBuffer) ToByteSlice(maximumSizeBytes int) ([]byte, error) {
 	return nil, b.err
 }
 
-func (b errorBuffer) ToChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
+func (b errorBuffer) ToChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
 	return newErrorChunkReader(b.err)
 }
 
@@ -46,6 +51,10 @@ func (b errorBuffer) ToReader() io.ReadCloser {
 	return newErrorReader(b.err)
 }
 
+func (b errorBuffer) ToSeekableReader() (ReadSeekCloser, error) {
+	return nil, b.err
+}
+
 func (b errorBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
 	return b, b
 }
@@ -68,7 +77,7 @@ func (b errorBuffer) applyErrorHandler(errorHandler ErrorHandler) (Buffer, bool)
 	return newB, true
 }
 
-func (b errorBuffer) toUnvalidatedChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
+func (b errorBuffer) toUnvalidatedChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
 	return newErrorChunkReader(b.err)
 }
 
@@ -5,21 +5,21 @@ import (
 )
 
 type errorHandlingChunkReader struct {
-	r                     ChunkReader
-	errorHandler          ErrorHandler
-	off                   int64
-	maximumChunkSizeBytes int
+	r            ChunkReader
+	errorHandler ErrorHandler
+	off          int64
+	chunkPolicy  ChunkPolicy
 }
 
 // newErrorHandlingChunkReader returns a ChunkReader that forwards calls
 // to a reader obtained from a Buffer. Upon I/O failure, it calls into
 // an ErrorHandler to request a new Buffer to continue the transfer.
-func newErrorHandlingChunkReader(b Buffer, errorHandler ErrorHandler, off int64, maximumChunkSizeBytes int) ChunkReader {
+func newErrorHandlingChunkReader(b Buffer, errorHandler ErrorHandler, off int64, chunkPolicy ChunkPolicy) ChunkReader {
 	return &errorHandlingChunkReader{
-		r:                     b.toUnvalidatedChunkReader(off, maximumChunkSizeBytes),
-		errorHandler:          errorHandler,
-		off:                   off,
-		maximumChunkSizeBytes: maximumChunkSizeBytes,
+		r:            b.toUnvalidatedChunkReader(off, chunkPolicy),
+		errorHandler: errorHandler,
+		off:          off,
+		chunkPolicy:  chunkPolicy,
 	}
 }
 
@@ -37,7 +37,7 @@ func (r *errorHandlingChunkReader) Read() ([]byte, error) {
 			return nil, translatedErr
 		}
 		r.r.Close()
-		r.r = b.toUnvalidatedChunkReader(r.off, r.maximumChunkSizeBytes)
+		r.r = b.toUnvalidatedChunkReader(r.off, r.chunkPolicy)
 	}
 }
 
@@ -0,0 +1,90 @@
+package buffer
+
+import (
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewMinimumRateBuffer creates a decorator for Buffer that causes reads
+// of its contents to fail with DEADLINE_EXCEEDED if the rate at which
+// data is returned by the underlying buffer drops below a minimum. The
+// rate is computed over a rolling window of time. It is only enforced
+// after the transfer has been running for at least the duration of the
+// window, so that transfers are not aborted due to slow starts.
+//
+// The rate is validated every time data is returned. This means that
+// this decorator does not abort transfers that are stalled entirely.
+// It should therefore be combined with NewTimeoutBuffer(). The rate is
+// also not validated upon reaching the end of the stream, so that
+// transfers that are slow to be finalized are permitted to complete.
+func NewMinimumRateBuffer(b Buffer, clock clock.Clock, minimumBytesPerSecond int64, window time.Duration) Buffer {
+	return WithChunkReaderDecorator(b, func(r ChunkReader, off int64) ChunkReader {
+		return &minimumRateChunkReader{
+			base:                  r,
+			clock:                 clock,
+			minimumBytesPerSecond: minimumBytesPerSecond,
+			window:                window,
+		}
+	})
+}
+
+// minimumRateSample records the number of bytes that were returned by
+// a single call to Read().
+type minimumRateSample struct {
+	time      time.Time
+	sizeBytes int64
+}
+
+type minimumRateChunkReader struct {
+	base                  ChunkReader
+	clock                 clock.Clock
+	minimumBytesPerSecond int64
+	window                time.Duration
+
+	start           time.Time
+	samples         []minimumRateSample
+	windowSizeBytes int64
+	err             error
+}
+
+func (r *minimumRateChunkReader) Read() ([]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.start.IsZero() {
+		r.start = r.clock.Now()
+	}
+
+	chunk, err := r.base.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	// Add the chunk to the window and discard samples that have
+	// fallen outside of it.
+	now := r.clock.Now()
+	r.samples = append(r.samples, minimumRateSample{
+		time:      now,
+		sizeBytes: int64(len(chunk)),
+	})
+	r.windowSizeBytes += int64(len(chunk))
+	windowStart := now.Add(-r.window)
+	for len(r.samples) > 0 && !r.samples[0].time.After(windowStart) {
+		r.windowSizeBytes -= r.samples[0].sizeBytes
+		r.samples = r.samples[1:]
+	}
+
+	if now.Sub(r.start) >= r.window && float64(r.windowSizeBytes) < float64(r.minimumBytesPerSecond)*r.window.Seconds() {
+		r.err = status.Errorf(codes.DeadlineExceeded, "Transfer rate dropped below %d bytes per second over a window of %s", r.minimumBytesPerSecond, r.window)
+		return nil, r.err
+	}
+	return chunk, nil
+}
+
+func (r *minimumRateChunkReader) Close() {
+	r.base.Close()
+}
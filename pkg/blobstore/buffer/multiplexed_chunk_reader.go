@@ -1,7 +1,10 @@
 package buffer
 
 import (
+	"context"
 	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
 )
 
 type readResult struct {
@@ -19,18 +22,30 @@ type multiplexedChunkReader struct {
 
 // newMultiplexedChunkReader creates a decorator for ChunkReader that
 // multiplexes data on the stream to multiple consumers. Calling Read()
-// on this stream will hang until all other consumers either call Read()
-// or Close().
+// on a consumer will hang until all other consumers either call Read()
+// or Close(), or until the context of the consumer is cancelled.
 //
 // This multiplexer is used by Buffer.CloneStream(), which can be used
 // to implement advanced buffer replication strategies.
-func newMultiplexedChunkReader(r ChunkReader, additionalConsumers int) ChunkReader {
+func newMultiplexedChunkReader(r ChunkReader, additionalConsumers int) *multiplexedChunkReader {
 	return &multiplexedChunkReader{
 		r:                r,
 		pendingConsumers: 1 + additionalConsumers,
 	}
 }
 
+// newConsumer returns a ChunkReader for one of the consumers of the
+// multiplexed stream. Calls to Read() on the ChunkReader return once
+// the provided context is cancelled, in which case the consumer leaves
+// the multiplexed stream as if Close() was called. This prevents
+// consumers from blocking indefinitely on other consumers that stall.
+func (r *multiplexedChunkReader) newConsumer(ctx context.Context) ChunkReader {
+	return &multiplexedChunkReaderConsumer{
+		r:   r,
+		ctx: ctx,
+	}
+}
+
 func (r *multiplexedChunkReader) readAndShareWithOthers(currentConsumerContinues int) ([]byte, error) {
 	data, err := r.r.Read()
 	for _, c := range r.waitingConsumers {
@@ -41,11 +56,19 @@ func (r *multiplexedChunkReader) readAndShareWithOthers(currentConsumerContinues
 	return data, err
 }
 
-func (r *multiplexedChunkReader) Read() ([]byte, error) {
+// read data from the multiplexed stream. If the context is cancelled,
+// the consumer leaves the stream and the boolean return value is set
+// to true.
+func (r *multiplexedChunkReader) read(ctx context.Context) ([]byte, bool, error) {
 	r.lock.Lock()
 	if r.pendingConsumers <= 0 {
 		panic("Multiplexed chunk reader has no pending consumers")
 	}
+	if ctx.Err() != nil {
+		r.closeLocked()
+		r.lock.Unlock()
+		return nil, true, util.StatusFromContext(ctx)
+	}
 	r.pendingConsumers--
 
 	if r.pendingConsumers == 0 {
@@ -54,7 +77,7 @@ func (r *multiplexedChunkReader) Read() ([]byte, error) {
 		// data with the rest.
 		data, err := r.readAndShareWithOthers(1)
 		r.lock.Unlock()
-		return data, err
+		return data, false, err
 	}
 
 	// At least one more consumer needs to call Read(). Wait for it
@@ -62,14 +85,34 @@ func (r *multiplexedChunkReader) Read() ([]byte, error) {
 	c := make(chan readResult, 1)
 	r.waitingConsumers = append(r.waitingConsumers, c)
 	r.lock.Unlock()
-	result := <-c
-	return result.data, result.err
-}
+	select {
+	case result := <-c:
+		return result.data, false, result.err
+	case <-ctx.Done():
+	}
 
-func (r *multiplexedChunkReader) Close() {
 	r.lock.Lock()
 	defer r.lock.Unlock()
+	for i, cWaiting := range r.waitingConsumers {
+		if cWaiting == c {
+			// Results have not been shared with us yet.
+			// Stop waiting, so that the other consumers
+			// no longer include us.
+			last := len(r.waitingConsumers) - 1
+			r.waitingConsumers[i] = r.waitingConsumers[last]
+			r.waitingConsumers = r.waitingConsumers[:last]
+			return nil, true, util.StatusFromContext(ctx)
+		}
+	}
+
+	// Results were shared with us while the context got cancelled,
+	// meaning we're now expected to participate in the next
+	// iteration. Opt out from it.
+	r.closeLocked()
+	return nil, true, util.StatusFromContext(ctx)
+}
 
+func (r *multiplexedChunkReader) closeLocked() {
 	if r.pendingConsumers <= 0 {
 		panic("Multiplexed chunk reader has no pending consumers")
 	}
@@ -90,3 +133,28 @@ func (r *multiplexedChunkReader) Close() {
 		r.readAndShareWithOthers(0)
 	}
 }
+
+type multiplexedChunkReaderConsumer struct {
+	r   *multiplexedChunkReader
+	ctx context.Context
+	err error
+}
+
+func (r *multiplexedChunkReaderConsumer) Read() ([]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	data, left, err := r.r.read(r.ctx)
+	if left {
+		r.err = err
+	}
+	return data, err
+}
+
+func (r *multiplexedChunkReaderConsumer) Close() {
+	if r.err == nil {
+		r.r.lock.Lock()
+		r.r.closeLocked()
+		r.r.lock.Unlock()
+	}
+}
@@ -57,7 +57,7 @@ func TestNewCASBufferFromByteSliceSizeMismatch(t *testing.T) {
 		digest,
 		[]byte("Hello"),
 		buffer.BackendProvided(dataIntegrityCallback.Call)).ToByteSlice(5)
-	require.Equal(t, status.Error(codes.Internal, "Buffer is 5 bytes in size, while 6 bytes were expected"), err)
+	require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is 5 bytes in size, while 6 bytes were expected")), err)
 }
 
 func TestNewCASBufferFromByteSliceHashMismatch(t *testing.T) {
@@ -71,5 +71,32 @@ func TestNewCASBufferFromByteSliceHashMismatch(t *testing.T) {
 		digest,
 		[]byte("Hello"),
 		buffer.BackendProvided(dataIntegrityCallback.Call)).ToByteSlice(5)
-	require.Equal(t, status.Error(codes.Internal, "Buffer has checksum 8b1a9953c4611296a827abf8c47804d7, while d41d8cd98f00b204e9800998ecf8427e was expected"), err)
+	require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum 8b1a9953c4611296a827abf8c47804d7, while d41d8cd98f00b204e9800998ecf8427e was expected")), err)
+}
+
+func TestNewCASBufferFromByteSliceChecksum(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	helloDigest := digest.MustNewDigest("ubuntu1804", "8b1a9953c4611296a827abf8c47804d7", 5)
+	dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+	dataIntegrityCallback.EXPECT().Call(true)
+
+	b := buffer.NewCASBufferFromByteSlice(
+		helloDigest,
+		[]byte("Hello"),
+		buffer.BackendProvided(dataIntegrityCallback.Call))
+	checksum, err := b.Checksum()
+	require.NoError(t, err)
+	require.Equal(t, helloDigest, checksum)
+
+	// The digest should be retained by clones of the buffer.
+	b1, b2 := b.CloneCopy(5)
+	checksum, err = b1.Checksum()
+	require.NoError(t, err)
+	require.Equal(t, helloDigest, checksum)
+	b1.Discard()
+
+	data, err := b2.ToByteSlice(5)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
 }
@@ -2,6 +2,7 @@ package buffer_test
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"testing"
 
@@ -79,7 +80,7 @@ func TestNewCASBufferFromChunkReaderIntoWriter(t *testing.T) {
 			helloDigest,
 			chunkReader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).IntoWriter(writer)
-		require.Equal(t, status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected")), err)
 	})
 }
 
@@ -171,7 +172,7 @@ func TestNewCASBufferFromChunkReaderReadAt(t *testing.T) {
 			chunkReader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ReadAt(p[:], 1)
 		require.Equal(t, 0, n)
-		require.Equal(t, status.Error(codes.Internal, "Buffer is 3 bytes in size, while 5 bytes were expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is 3 bytes in size, while 5 bytes were expected")), err)
 	})
 
 	t.Run("SizeTooLarge", func(t *testing.T) {
@@ -188,7 +189,7 @@ func TestNewCASBufferFromChunkReaderReadAt(t *testing.T) {
 			chunkReader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ReadAt(p[:], 1)
 		require.Equal(t, 0, n)
-		require.Equal(t, status.Error(codes.Internal, "Buffer is at least 6 bytes in size, while 5 bytes were expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is at least 6 bytes in size, while 5 bytes were expected")), err)
 	})
 
 	t.Run("ChecksumFailure", func(t *testing.T) {
@@ -205,7 +206,7 @@ func TestNewCASBufferFromChunkReaderReadAt(t *testing.T) {
 			chunkReader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ReadAt(p[:], 1)
 		require.Equal(t, 0, n)
-		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum 56f2d4d0b97e43f94505299dc45942a1, while 8b1a9953c4611296a827abf8c47804d7 was expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum 56f2d4d0b97e43f94505299dc45942a1, while 8b1a9953c4611296a827abf8c47804d7 was expected")), err)
 	})
 
 	t.Run("IOFailure", func(t *testing.T) {
@@ -287,7 +288,7 @@ func TestNewCASBufferFromChunkReaderToProto(t *testing.T) {
 			chunkReader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).
 			ToProto(&remoteexecution.ActionResult{}, len(exampleActionResultBytes))
-		require.Equal(t, status.Error(codes.Internal, "Buffer is 3 bytes in size, while 134 bytes were expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is 3 bytes in size, while 134 bytes were expected")), err)
 	})
 
 	t.Run("InvalidProtobuf", func(t *testing.T) {
@@ -395,7 +396,7 @@ func TestNewCASBufferFromChunkReaderToChunkReader(t *testing.T) {
 			chunkReader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ToChunkReader(
 			/* offset = */ 3,
-			/* chunk size = */ 2)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(2))
 		chunk, err := r.Read()
 		require.NoError(t, err)
 		require.Equal(t, []byte("lo"), chunk)
@@ -418,6 +419,74 @@ func TestNewCASBufferFromChunkReaderToChunkReader(t *testing.T) {
 		r.Close()
 	})
 
+	t.Run("AtLeast", func(t *testing.T) {
+		chunkReader := mock.NewMockChunkReader(ctrl)
+		chunkReader.EXPECT().Read().Return([]byte("H"), nil)
+		chunkReader.EXPECT().Read().Return([]byte("e"), nil)
+		chunkReader.EXPECT().Read().Return([]byte("llo"), nil)
+		chunkReader.EXPECT().Read().Return([]byte(" wor"), nil)
+		chunkReader.EXPECT().Read().Return([]byte("ld"), nil)
+		chunkReader.EXPECT().Read().Return(nil, io.EOF)
+		chunkReader.EXPECT().Close()
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+
+		// Small chunks should be coalesced until at least the
+		// minimum size is reached. The final chunk may be
+		// smaller.
+		r := buffer.NewCASBufferFromChunkReader(
+			helloDigest,
+			chunkReader,
+			buffer.BackendProvided(dataIntegrityCallback.Call)).ToChunkReader(
+			/* offset = */ 0,
+			/* chunk policy = */ buffer.ChunkSizeAtLeast(4))
+		chunk, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), chunk)
+		chunk, err = r.Read()
+		require.NoError(t, err)
+		require.Equal(t, []byte(" wor"), chunk)
+		chunk, err = r.Read()
+		require.NoError(t, err)
+		require.Equal(t, []byte("ld"), chunk)
+		_, err = r.Read()
+		require.Equal(t, io.EOF, err)
+		r.Close()
+	})
+
+	t.Run("Between", func(t *testing.T) {
+		chunkReader := mock.NewMockChunkReader(ctrl)
+		chunkReader.EXPECT().Read().Return([]byte("H"), nil)
+		chunkReader.EXPECT().Read().Return([]byte("ello worl"), nil)
+		chunkReader.EXPECT().Read().Return([]byte("d"), nil)
+		chunkReader.EXPECT().Read().Return(nil, io.EOF)
+		chunkReader.EXPECT().Close()
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+
+		// When both a minimum and a maximum are provided,
+		// coalesced chunks should still be split up. Leftover
+		// data should be coalesced with the chunks that follow.
+		r := buffer.NewCASBufferFromChunkReader(
+			helloDigest,
+			chunkReader,
+			buffer.BackendProvided(dataIntegrityCallback.Call)).ToChunkReader(
+			/* offset = */ 0,
+			/* chunk policy = */ buffer.ChunkSizeBetween(3, 4))
+		chunk, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hell"), chunk)
+		chunk, err = r.Read()
+		require.NoError(t, err)
+		require.Equal(t, []byte("o wo"), chunk)
+		chunk, err = r.Read()
+		require.NoError(t, err)
+		require.Equal(t, []byte("rld"), chunk)
+		_, err = r.Read()
+		require.Equal(t, io.EOF, err)
+		r.Close()
+	})
+
 	t.Run("AtTheEnd", func(t *testing.T) {
 		chunkReader := mock.NewMockChunkReader(ctrl)
 		chunkReader.EXPECT().Read().Return([]byte("Hello world"), nil)
@@ -433,7 +502,7 @@ func TestNewCASBufferFromChunkReaderToChunkReader(t *testing.T) {
 			chunkReader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ToChunkReader(
 			/* offset = */ 11,
-			/* chunk size = */ 2)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(2))
 		_, err := r.Read()
 		require.Equal(t, io.EOF, err)
 		r.Close()
@@ -449,7 +518,7 @@ func TestNewCASBufferFromChunkReaderToChunkReader(t *testing.T) {
 			chunkReader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ToChunkReader(
 			/* offset = */ -1,
-			/* chunk size = */ 2)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(2))
 		_, err := r.Read()
 		require.Equal(t, status.Error(codes.InvalidArgument, "Negative read offset: -1"), err)
 		r.Close()
@@ -465,7 +534,7 @@ func TestNewCASBufferFromChunkReaderToChunkReader(t *testing.T) {
 			chunkReader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ToChunkReader(
 			/* offset = */ 12,
-			/* chunk size = */ 2)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(2))
 		_, err := r.Read()
 		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is 11 bytes in size, while a read at offset 12 was requested"), err)
 		r.Close()
@@ -487,14 +556,14 @@ func TestNewCASBufferFromChunkReaderToChunkReader(t *testing.T) {
 			chunkReader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ToChunkReader(
 			/* offset = */ 0,
-			/* chunk size = */ 10)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(10))
 		chunk, err := r.Read()
 		require.NoError(t, err)
 		require.Equal(t, []byte("Hello "), chunk)
 		_, err = r.Read()
-		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected")), err)
 		_, err = r.Read()
-		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected")), err)
 		r.Close()
 	})
 }
@@ -562,11 +631,11 @@ func TestNewCASBufferFromChunkReaderToReader(t *testing.T) {
 		var p [20]byte
 		n, err := r.Read(p[:])
 		require.Equal(t, 6, n)
-		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected")), err)
 		require.Equal(t, []byte("Hello "), p[:6])
 		n, err = r.Read(p[:])
 		require.Equal(t, 0, n)
-		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected")), err)
 		require.Nil(t, r.Close())
 	})
 }
@@ -629,10 +698,10 @@ func TestNewCASBufferFromChunkReaderCloneCopy(t *testing.T) {
 			buffer.BackendProvided(dataIntegrityCallback.Call)).CloneCopy(10)
 
 		_, err := b1.ToByteSlice(10)
-		require.Equal(t, status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected")), err)
 
 		_, err = b2.ToByteSlice(10)
-		require.Equal(t, status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected")), err)
 	})
 
 	t.Run("TooBig", func(t *testing.T) {
@@ -733,19 +802,86 @@ func TestNewCASBufferFromChunkReaderCloneStream(t *testing.T) {
 
 		go func() {
 			_, err := b1.ToByteSlice(10)
-			require.Equal(t, status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected"), err)
+			require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected")), err)
 			done <- struct{}{}
 		}()
 
 		go func() {
 			_, err := b2.ToByteSlice(10)
-			require.Equal(t, status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected"), err)
+			require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected")), err)
 			done <- struct{}{}
 		}()
 
 		<-done
 		<-done
 	})
+
+	t.Run("CancelledConsumer", func(t *testing.T) {
+		// Three consumers read the same stream, where one of
+		// them gets cancelled after reading the first chunk.
+		// The other consumers should be able to read the
+		// remainder of the stream.
+		chunkReader := mock.NewMockChunkReader(ctrl)
+		chunkReader.EXPECT().Read().Return([]byte("Hel"), nil)
+		chunkReader.EXPECT().Read().Return([]byte("lo"), nil)
+		chunkReader.EXPECT().Read().Return(nil, io.EOF)
+		chunkReader.EXPECT().Close()
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+
+		b1, b2 := buffer.NewCASBufferFromChunkReader(
+			helloDigest,
+			chunkReader,
+			buffer.BackendProvided(dataIntegrityCallback.Call)).CloneStream()
+		b2, b3 := b2.CloneStream()
+		ctx, cancel := context.WithCancel(context.Background())
+		b3 = buffer.WithContext(b3, ctx)
+		proceed := make(chan struct{})
+		done := make(chan struct{}, 2)
+
+		for _, b := range []buffer.Buffer{b1, b2} {
+			go func(b buffer.Buffer) {
+				r := b.ToChunkReader(0, buffer.ChunkSizeAtMost(10))
+				chunk, err := r.Read()
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hel"), chunk)
+				<-proceed
+				chunk, err = r.Read()
+				require.NoError(t, err)
+				require.Equal(t, []byte("lo"), chunk)
+				_, err = r.Read()
+				require.Equal(t, io.EOF, err)
+				r.Close()
+				done <- struct{}{}
+			}(b)
+		}
+
+		r3 := b3.ToChunkReader(0, buffer.ChunkSizeAtMost(10))
+		chunk, err := r3.Read()
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hel"), chunk)
+
+		// Cancelling the context should cause a pending call
+		// to Read() to return, even though the other
+		// consumers have not made any progress yet.
+		cancelled := make(chan error, 1)
+		go func() {
+			_, err := r3.Read()
+			cancelled <- err
+		}()
+		cancel()
+		require.Equal(t, status.Error(codes.Canceled, "context canceled"), <-cancelled)
+
+		close(proceed)
+		<-done
+		<-done
+
+		// Once cancelled, the consumer should no longer
+		// participate in the stream.
+		_, err = r3.Read()
+		require.Equal(t, status.Error(codes.Canceled, "context canceled"), err)
+		r3.Close()
+	})
 }
 
 func TestNewCASBufferFromChunkReaderDiscard(t *testing.T) {
@@ -32,6 +32,21 @@ func TestNewCASBufferFromReaderGetSizeBytes(t *testing.T) {
 	b.Discard()
 }
 
+func TestNewCASBufferFromReaderChecksum(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// The digest of a stream backed buffer is not known until its
+	// contents have been read and validated.
+	helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+	reader := mock.NewMockReadCloser(ctrl)
+	reader.EXPECT().Close()
+
+	b := buffer.NewCASBufferFromReader(helloDigest, reader, buffer.UserProvided)
+	_, err := b.Checksum()
+	require.Equal(t, buffer.ErrDigestUnknown, err)
+	b.Discard()
+}
+
 func TestNewCASBufferFromReaderIntoWriter(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
@@ -77,7 +92,7 @@ func TestNewCASBufferFromReaderIntoWriter(t *testing.T) {
 			helloDigest,
 			reader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).IntoWriter(writer)
-		require.Equal(t, status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected")), err)
 	})
 }
 
@@ -155,7 +170,7 @@ func TestNewCASBufferFromReaderReadAt(t *testing.T) {
 			reader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ReadAt(p[:], 1)
 		require.Equal(t, 0, n)
-		require.Equal(t, status.Error(codes.Internal, "Buffer is 3 bytes in size, while 5 bytes were expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is 3 bytes in size, while 5 bytes were expected")), err)
 	})
 
 	t.Run("SizeTooLarge", func(t *testing.T) {
@@ -169,7 +184,7 @@ func TestNewCASBufferFromReaderReadAt(t *testing.T) {
 			reader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ReadAt(p[:], 1)
 		require.Equal(t, 0, n)
-		require.Equal(t, status.Error(codes.Internal, "Buffer is at least 6 bytes in size, while 5 bytes were expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is at least 6 bytes in size, while 5 bytes were expected")), err)
 	})
 
 	t.Run("ChecksumFailure", func(t *testing.T) {
@@ -183,7 +198,7 @@ func TestNewCASBufferFromReaderReadAt(t *testing.T) {
 			reader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ReadAt(p[:], 1)
 		require.Equal(t, 0, n)
-		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum 56f2d4d0b97e43f94505299dc45942a1, while 8b1a9953c4611296a827abf8c47804d7 was expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum 56f2d4d0b97e43f94505299dc45942a1, while 8b1a9953c4611296a827abf8c47804d7 was expected")), err)
 	})
 
 	t.Run("IOFailure", func(t *testing.T) {
@@ -256,7 +271,7 @@ func TestNewCASBufferFromReaderToProto(t *testing.T) {
 			reader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).
 			ToProto(&remoteexecution.ActionResult{}, len(exampleActionResultBytes))
-		require.Equal(t, status.Error(codes.Internal, "Buffer is 3 bytes in size, while 134 bytes were expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is 3 bytes in size, while 134 bytes were expected")), err)
 	})
 
 	t.Run("InvalidProtobuf", func(t *testing.T) {
@@ -347,7 +362,7 @@ func TestNewCASBufferFromReaderToChunkReader(t *testing.T) {
 			reader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ToChunkReader(
 			/* offset = */ 3,
-			/* chunk size = */ 2)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(2))
 		chunk, err := r.Read()
 		require.NoError(t, err)
 		require.Equal(t, []byte("lo"), chunk)
@@ -379,7 +394,7 @@ func TestNewCASBufferFromReaderToChunkReader(t *testing.T) {
 			reader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ToChunkReader(
 			/* offset = */ 11,
-			/* chunk size = */ 2)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(2))
 		_, err := r.Read()
 		require.Equal(t, io.EOF, err)
 		r.Close()
@@ -395,7 +410,7 @@ func TestNewCASBufferFromReaderToChunkReader(t *testing.T) {
 			reader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ToChunkReader(
 			/* offset = */ -1,
-			/* chunk size = */ 2)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(2))
 		_, err := r.Read()
 		require.Equal(t, status.Error(codes.InvalidArgument, "Negative read offset: -1"), err)
 		r.Close()
@@ -411,7 +426,7 @@ func TestNewCASBufferFromReaderToChunkReader(t *testing.T) {
 			reader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ToChunkReader(
 			/* offset = */ 12,
-			/* chunk size = */ 2)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(2))
 		_, err := r.Read()
 		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is 11 bytes in size, while a read at offset 12 was requested"), err)
 		r.Close()
@@ -429,14 +444,14 @@ func TestNewCASBufferFromReaderToChunkReader(t *testing.T) {
 			reader,
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ToChunkReader(
 			/* offset = */ 0,
-			/* chunk size = */ 10)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(10))
 		chunk, err := r.Read()
 		require.NoError(t, err)
 		require.Equal(t, []byte("Hello worl"), chunk)
 		_, err = r.Read()
-		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected")), err)
 		_, err = r.Read()
-		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected")), err)
 		r.Close()
 	})
 }
@@ -492,14 +507,31 @@ func TestNewCASBufferFromReaderToReader(t *testing.T) {
 		var p [20]byte
 		n, err := r.Read(p[:])
 		require.Equal(t, 0, n)
-		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected")), err)
 		n, err = r.Read(p[:])
 		require.Equal(t, 0, n)
-		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum d46893336c594d884bb1b9b4f5299f4a, while 3e25960a79dbc69b674cd4ec67a72c62 was expected")), err)
 		require.Nil(t, r.Close())
 	})
 }
 
+func TestNewCASBufferFromReaderToSeekableReader(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Buffers backed by streams validate their contents while
+	// being read sequentially. They cannot provide a seekable
+	// reader, and should be released immediately.
+	reader := mock.NewMockReadCloser(ctrl)
+	reader.EXPECT().Close()
+	dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+
+	_, err := buffer.NewCASBufferFromReader(
+		digest.MustNewDigest("foo", "3e25960a79dbc69b674cd4ec67a72c62", 11),
+		reader,
+		buffer.BackendProvided(dataIntegrityCallback.Call)).ToSeekableReader()
+	require.Equal(t, buffer.ErrNotSeekable, err)
+}
+
 func TestNewCASBufferFromReaderCloneCopy(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
@@ -558,10 +590,10 @@ func TestNewCASBufferFromReaderCloneCopy(t *testing.T) {
 			buffer.BackendProvided(dataIntegrityCallback.Call)).CloneCopy(10)
 
 		_, err := b1.ToByteSlice(10)
-		require.Equal(t, status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected")), err)
 
 		_, err = b2.ToByteSlice(10)
-		require.Equal(t, status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is 0 bytes in size, while 5 bytes were expected")), err)
 	})
 
 	t.Run("TooBig", func(t *testing.T) {
@@ -0,0 +1,122 @@
+package buffer_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewConcatenatedBuffer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	blobDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	t.Run("ToByteSlice", func(t *testing.T) {
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+
+		data, err := buffer.NewConcatenatedBuffer(
+			blobDigest,
+			buffer.BackendProvided(dataIntegrityCallback.Call),
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+			buffer.NewValidatedBufferFromByteSlice([]byte(" ")),
+			buffer.NewValidatedBufferFromByteSlice([]byte("world")),
+		).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("HashMismatch", func(t *testing.T) {
+		// The contents of the buffer as a whole should be
+		// validated against the digest.
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(false)
+
+		_, err := buffer.NewConcatenatedBuffer(
+			blobDigest,
+			buffer.BackendProvided(dataIntegrityCallback.Call),
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+			buffer.NewValidatedBufferFromByteSlice([]byte(" World")),
+		).ToByteSlice(100)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum b10a8db164e0754105b7a99be72e3fe5, while 3e25960a79dbc69b674cd4ec67a72c62 was expected")), err)
+	})
+
+	t.Run("SizeMismatch", func(t *testing.T) {
+		// If the sizes of the parts are known, a mismatch with
+		// the size in the digest can be detected immediately.
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(false)
+
+		_, err := buffer.NewConcatenatedBuffer(
+			blobDigest,
+			buffer.BackendProvided(dataIntegrityCallback.Call),
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+		).ToByteSlice(100)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer is 5 bytes in size, while 11 bytes were expected")), err)
+	})
+
+	t.Run("ReadAtSpanningParts", func(t *testing.T) {
+		// ReadAt() should only read the parts that overlap with
+		// the requested range. As the buffer is not read in
+		// its entirety, no validation takes place.
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+
+		var p [4]byte
+		n, err := buffer.NewConcatenatedBuffer(
+			blobDigest,
+			buffer.BackendProvided(dataIntegrityCallback.Call),
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+			buffer.NewValidatedBufferFromByteSlice([]byte(" ")),
+			buffer.NewValidatedBufferFromByteSlice([]byte("world")),
+		).ReadAt(p[:], 3)
+		require.NoError(t, err)
+		require.Equal(t, 4, n)
+		require.Equal(t, []byte("lo w"), p[:])
+	})
+
+	t.Run("ReadAtEndOfBuffer", func(t *testing.T) {
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+
+		var p [5]byte
+		n, err := buffer.NewConcatenatedBuffer(
+			blobDigest,
+			buffer.BackendProvided(dataIntegrityCallback.Call),
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+			buffer.NewValidatedBufferFromByteSlice([]byte(" world")),
+		).ReadAt(p[:], 8)
+		require.Equal(t, io.EOF, err)
+		require.Equal(t, 3, n)
+		require.Equal(t, []byte("rld"), p[:n])
+	})
+
+	t.Run("ReadAtInvalidOffset", func(t *testing.T) {
+		// Offsets outside of the buffer should be rejected,
+		// like for other types of buffers.
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+
+		var p [5]byte
+		_, err := buffer.NewConcatenatedBuffer(
+			blobDigest,
+			buffer.BackendProvided(dataIntegrityCallback.Call),
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+			buffer.NewValidatedBufferFromByteSlice([]byte(" world")),
+		).ReadAt(p[:], -1)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Negative read offset: -1"), err)
+
+		_, err = buffer.NewConcatenatedBuffer(
+			blobDigest,
+			buffer.BackendProvided(dataIntegrityCallback.Call),
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+			buffer.NewValidatedBufferFromByteSlice([]byte(" world")),
+		).ReadAt(p[:], 12)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is 11 bytes in size, while a read at offset 12 was requested"), err)
+	})
+}
@@ -0,0 +1,72 @@
+package buffer_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewMinimumRateBuffer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("SufficientRate", func(t *testing.T) {
+		// The rate is not enforced during the first window.
+		// Slow completion of the transfer should also not cause
+		// it to fail.
+		chunkReader := mock.NewMockChunkReader(ctrl)
+		gomock.InOrder(
+			chunkReader.EXPECT().Read().Return([]byte("Hel"), nil),
+			chunkReader.EXPECT().Read().Return([]byte("lo"), nil),
+			chunkReader.EXPECT().Read().Return(nil, io.EOF))
+		chunkReader.EXPECT().Close()
+		clock := mock.NewMockClock(ctrl)
+		gomock.InOrder(
+			clock.EXPECT().Now().Return(time.Unix(1000, 0)),
+			clock.EXPECT().Now().Return(time.Unix(1005, 0)),
+			clock.EXPECT().Now().Return(time.Unix(1009, 0)))
+
+		b := buffer.NewMinimumRateBuffer(
+			buffer.NewCASBufferFromChunkReader(helloDigest, chunkReader, buffer.BackendProvided(buffer.Irreparable(helloDigest))),
+			clock,
+			/* minimumBytesPerSecond = */ 1,
+			10*time.Second)
+		data, err := b.ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("InsufficientRate", func(t *testing.T) {
+		// Only five bytes were received within the last ten
+		// seconds, while at least ten bytes are expected.
+		chunkReader := mock.NewMockChunkReader(ctrl)
+		gomock.InOrder(
+			chunkReader.EXPECT().Read().Return([]byte("Hel"), nil),
+			chunkReader.EXPECT().Read().Return([]byte("lo"), nil))
+		chunkReader.EXPECT().Close()
+		clock := mock.NewMockClock(ctrl)
+		gomock.InOrder(
+			clock.EXPECT().Now().Return(time.Unix(1000, 0)),
+			clock.EXPECT().Now().Return(time.Unix(1005, 0)),
+			clock.EXPECT().Now().Return(time.Unix(1012, 0)))
+
+		b := buffer.NewMinimumRateBuffer(
+			buffer.NewCASBufferFromChunkReader(helloDigest, chunkReader, buffer.BackendProvided(buffer.Irreparable(helloDigest))),
+			clock,
+			/* minimumBytesPerSecond = */ 1,
+			10*time.Second)
+		_, err := b.ToByteSlice(10)
+		require.Equal(t, status.Error(codes.DeadlineExceeded, "Transfer rate dropped below 1 bytes per second over a window of 10s"), err)
+	})
+}
@@ -43,7 +43,7 @@ func TestNewProtoBufferFromByteSliceGetSizeBytes(t *testing.T) {
 			[]byte("Hello world"),
 			buffer.BackendProvided(dataIntegrityCallback.Call))
 		_, err := b.GetSizeBytes()
-		require.Equal(t, status.Error(codes.Internal, "Failed to unmarshal message: proto: can't skip unknown wire type 4"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Failed to unmarshal message: proto: can't skip unknown wire type 4")), err)
 		b.Discard()
 	})
 }
@@ -112,7 +112,7 @@ func TestNewProtoBufferFromByteSliceReadAt(t *testing.T) {
 			[]byte("Hello world"),
 			buffer.UserProvided).ReadAt(p[:], 0)
 		require.Equal(t, 0, n)
-		require.Equal(t, status.Error(codes.InvalidArgument, "Failed to unmarshal message: proto: can't skip unknown wire type 4"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.InvalidArgument, "Failed to unmarshal message: proto: can't skip unknown wire type 4")), err)
 	})
 
 	t.Run("DataCorruptionIrreparable", func(t *testing.T) {
@@ -122,7 +122,7 @@ func TestNewProtoBufferFromByteSliceReadAt(t *testing.T) {
 			[]byte("Hello world"),
 			buffer.BackendProvided(buffer.Irreparable(digest.MustNewDigest("hello", "f988a36ed06e17f6c4a258ec8e03fe88", 123)))).ReadAt(p[:], 0)
 		require.Equal(t, 0, n)
-		require.Equal(t, status.Error(codes.Internal, "Failed to unmarshal message: proto: can't skip unknown wire type 4"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Failed to unmarshal message: proto: can't skip unknown wire type 4")), err)
 	})
 
 	t.Run("DataCorruptionReparable", func(t *testing.T) {
@@ -135,7 +135,7 @@ func TestNewProtoBufferFromByteSliceReadAt(t *testing.T) {
 			[]byte("Hello world"),
 			buffer.BackendProvided(dataIntegrityCallback.Call)).ReadAt(p[:], 0)
 		require.Equal(t, 0, n)
-		require.Equal(t, status.Error(codes.Internal, "Failed to unmarshal message: proto: can't skip unknown wire type 4"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Failed to unmarshal message: proto: can't skip unknown wire type 4")), err)
 	})
 }
 
@@ -180,7 +180,7 @@ func TestNewProtoBufferFromByteSliceToChunkReader(t *testing.T) {
 		exampleActionResultBytes,
 		buffer.BackendProvided(dataIntegrityCallback.Call)).ToChunkReader(
 		/* offset = */ 0,
-		/* chunk size = */ 10000)
+		/* chunk policy = */ buffer.ChunkSizeAtMost(10000))
 
 	data, err := r.Read()
 	require.NoError(t, err)
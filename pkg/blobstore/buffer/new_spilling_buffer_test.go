@@ -0,0 +1,60 @@
+package buffer_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSpillingBuffer(t *testing.T) {
+	t.Run("InMemory", func(t *testing.T) {
+		// Data that fits within the memory limit should be
+		// returned as is.
+		sb := buffer.NewSpillingBuffer(5, "")
+		n, err := sb.Write([]byte("Hello"))
+		require.NoError(t, err)
+		require.Equal(t, 5, n)
+
+		data, err := sb.Build().ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("Spilled", func(t *testing.T) {
+		// Data in excess of the memory limit should end up in
+		// the spill file. Random access and streaming should
+		// both work across the boundary.
+		sb := buffer.NewSpillingBuffer(4, "")
+		for _, chunk := range []string{"He", "llo w", "orld"} {
+			n, err := sb.Write([]byte(chunk))
+			require.NoError(t, err)
+			require.Equal(t, len(chunk), n)
+		}
+
+		b1, b2 := sb.Build().CloneStream()
+		sizeBytes, err := b1.GetSizeBytes()
+		require.NoError(t, err)
+		require.Equal(t, int64(11), sizeBytes)
+
+		var p [5]byte
+		n, err := b1.ReadAt(p[:], 2)
+		require.NoError(t, err)
+		require.Equal(t, 5, n)
+		require.Equal(t, []byte("llo w"), p[:])
+
+		writer := bytes.NewBuffer(nil)
+		require.NoError(t, b2.IntoWriter(writer))
+		require.Equal(t, []byte("Hello world"), writer.Bytes())
+	})
+
+	t.Run("Discard", func(t *testing.T) {
+		// Discarding a SpillingBuffer should be permitted
+		// without building a Buffer first.
+		sb := buffer.NewSpillingBuffer(0, "")
+		_, err := sb.Write([]byte("Hello"))
+		require.NoError(t, err)
+		sb.Discard()
+	})
+}
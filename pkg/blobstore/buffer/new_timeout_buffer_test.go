@@ -0,0 +1,77 @@
+package buffer_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewTimeoutBuffer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Progressing", func(t *testing.T) {
+		// Every chunk that is returned should cause the timer to
+		// be reset, so that slow transfers may complete.
+		chunkReader := mock.NewMockChunkReader(ctrl)
+		gomock.InOrder(
+			chunkReader.EXPECT().Read().Return([]byte("Hel"), nil),
+			chunkReader.EXPECT().Read().Return([]byte("lo"), nil),
+			chunkReader.EXPECT().Read().Return(nil, io.EOF))
+		chunkReader.EXPECT().Close()
+		clock := mock.NewMockClock(ctrl)
+		for i := 0; i < 3; i++ {
+			timer := mock.NewMockTimer(ctrl)
+			clock.EXPECT().NewTimer(time.Minute).Return(timer, nil)
+			timer.EXPECT().Stop().Return(true)
+		}
+
+		b := buffer.NewTimeoutBuffer(
+			buffer.NewCASBufferFromChunkReader(helloDigest, chunkReader, buffer.BackendProvided(buffer.Irreparable(helloDigest))),
+			clock,
+			time.Minute)
+		data, err := b.ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("Stalled", func(t *testing.T) {
+		// If the underlying reader does not return any data
+		// before the timer expires, the transfer should fail.
+		unblock := make(chan struct{})
+		closed := make(chan struct{})
+		chunkReader := mock.NewMockChunkReader(ctrl)
+		chunkReader.EXPECT().Read().DoAndReturn(func() ([]byte, error) {
+			<-unblock
+			return nil, status.Error(codes.Unavailable, "Connection reset")
+		})
+		chunkReader.EXPECT().Close().Do(func() { close(closed) })
+		clock := mock.NewMockClock(ctrl)
+		timerChannel := make(chan time.Time, 1)
+		clock.EXPECT().NewTimer(time.Minute).Return(mock.NewMockTimer(ctrl), timerChannel)
+		timerChannel <- time.Unix(1000, 0)
+
+		b := buffer.NewTimeoutBuffer(
+			buffer.NewCASBufferFromChunkReader(helloDigest, chunkReader, buffer.BackendProvided(buffer.Irreparable(helloDigest))),
+			clock,
+			time.Minute)
+		_, err := b.ToByteSlice(10)
+		require.Equal(t, status.Error(codes.DeadlineExceeded, "No data was received within 1m0s"), err)
+
+		// The underlying reader may only be closed after the
+		// call to Read() has completed.
+		close(unblock)
+		<-closed
+	})
+}
@@ -14,6 +14,12 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+func TestNewValidatedBufferFromByteSliceChecksum(t *testing.T) {
+	// The buffer is not associated with a digest.
+	_, err := buffer.NewValidatedBufferFromByteSlice([]byte("Hello")).Checksum()
+	require.Equal(t, buffer.ErrDigestUnknown, err)
+}
+
 func TestNewValidatedBufferFromByteSliceReadAt(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
 		var p [5]byte
@@ -97,7 +103,7 @@ func TestNewValidatedBufferFromByteSliceToChunkReader(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
 		r := buffer.NewValidatedBufferFromByteSlice([]byte("Hello")).ToChunkReader(
 			/* offset = */ 1,
-			/* chunk size = */ 2)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(2))
 
 		data, err := r.Read()
 		require.NoError(t, err)
@@ -121,7 +127,7 @@ func TestNewValidatedBufferFromByteSliceToChunkReader(t *testing.T) {
 		// return an end-of-file immediately.
 		r := buffer.NewValidatedBufferFromByteSlice([]byte("Hello")).ToChunkReader(
 			/* offset = */ 5,
-			/* chunk size = */ 2)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(2))
 		_, err := r.Read()
 		require.Equal(t, io.EOF, err)
 		r.Close()
@@ -130,7 +136,7 @@ func TestNewValidatedBufferFromByteSliceToChunkReader(t *testing.T) {
 	t.Run("NegativeOffset", func(t *testing.T) {
 		r := buffer.NewValidatedBufferFromByteSlice([]byte("Hello")).ToChunkReader(
 			/* offset = */ -123,
-			/* chunk size = */ 1024)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(1024))
 
 		_, err := r.Read()
 		require.Equal(t, status.Error(codes.InvalidArgument, "Negative read offset: -123"), err)
@@ -141,7 +147,7 @@ func TestNewValidatedBufferFromByteSliceToChunkReader(t *testing.T) {
 	t.Run("TooFar", func(t *testing.T) {
 		r := buffer.NewValidatedBufferFromByteSlice([]byte("Hello")).ToChunkReader(
 			/* offset = */ 6,
-			/* chunk size = */ 1024)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(1024))
 
 		_, err := r.Read()
 		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is 5 bytes in size, while a read at offset 6 was requested"), err)
@@ -160,6 +166,31 @@ func TestNewValidatedBufferFromByteSliceToReader(t *testing.T) {
 	require.NoError(t, r.Close())
 }
 
+func TestNewValidatedBufferFromByteSliceToSeekableReader(t *testing.T) {
+	r, err := buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")).ToSeekableReader()
+	require.NoError(t, err)
+
+	// Seek to the end and read backwards, as is done by consumers
+	// of archive formats that store their index at the end.
+	off, err := r.Seek(-5, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), off)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, []byte("world"), data)
+
+	off, err = r.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), off)
+	var p [5]byte
+	n, err := io.ReadFull(r, p[:])
+	require.Equal(t, 5, n)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), p[:])
+
+	require.NoError(t, r.Close())
+}
+
 func TestNewValidatedBufferFromByteSliceCloneCopy(t *testing.T) {
 	b1, b2 := buffer.NewValidatedBufferFromByteSlice([]byte("Hello")).CloneCopy(10)
 
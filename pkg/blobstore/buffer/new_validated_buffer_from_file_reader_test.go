@@ -191,7 +191,7 @@ func TestNewValidatedBufferFromFileReaderToChunkReader(t *testing.T) {
 		// large.
 		r := buffer.NewValidatedBufferFromFileReader(reader, 11).ToChunkReader(
 			/* offset = */ 3,
-			/* chunk size = */ 2)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(2))
 		chunk, err := r.Read()
 		require.NoError(t, err)
 		require.Equal(t, []byte("lo"), chunk)
@@ -219,7 +219,7 @@ func TestNewValidatedBufferFromFileReaderToChunkReader(t *testing.T) {
 		// return an end-of-file immediately.
 		r := buffer.NewValidatedBufferFromFileReader(reader, 11).ToChunkReader(
 			/* offset = */ 11,
-			/* chunk size = */ 2)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(2))
 		_, err := r.Read()
 		require.Equal(t, io.EOF, err)
 		r.Close()
@@ -231,7 +231,7 @@ func TestNewValidatedBufferFromFileReaderToChunkReader(t *testing.T) {
 
 		r := buffer.NewValidatedBufferFromFileReader(reader, 11).ToChunkReader(
 			/* offset = */ -1,
-			/* chunk size = */ 2)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(2))
 		_, err := r.Read()
 		require.Equal(t, status.Error(codes.InvalidArgument, "Negative read offset: -1"), err)
 		r.Close()
@@ -243,7 +243,7 @@ func TestNewValidatedBufferFromFileReaderToChunkReader(t *testing.T) {
 
 		r := buffer.NewValidatedBufferFromFileReader(reader, 11).ToChunkReader(
 			/* offset = */ 12,
-			/* chunk size = */ 2)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(2))
 		_, err := r.Read()
 		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is 11 bytes in size, while a read at offset 12 was requested"), err)
 		r.Close()
@@ -258,7 +258,7 @@ func TestNewValidatedBufferFromFileReaderToChunkReader(t *testing.T) {
 
 		r := buffer.NewValidatedBufferFromFileReader(reader, 11).ToChunkReader(
 			/* offset = */ 3,
-			/* chunk size = */ 2)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(2))
 		_, err := r.Read()
 		require.Equal(t, status.Error(codes.Internal, "Storage backend on fire"), err)
 		r.Close()
@@ -326,6 +326,46 @@ func TestNewValidatedBufferFromFileReaderToReader(t *testing.T) {
 	})
 }
 
+func TestNewValidatedBufferFromFileReaderToSeekableReader(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	reader := mock.NewMockFileReader(ctrl)
+	gomock.InOrder(
+		reader.EXPECT().ReadAt(gomock.Any(), int64(6)).DoAndReturn(func(p []byte, off int64) (int, error) {
+			return copy(p, []byte("world")), nil
+		}),
+		reader.EXPECT().ReadAt(gomock.Any(), int64(0)).DoAndReturn(func(p []byte, off int64) (int, error) {
+			return copy(p, []byte("Hello")), nil
+		}),
+		reader.EXPECT().Close(),
+	)
+
+	r, err := buffer.NewValidatedBufferFromFileReader(reader, 11).ToSeekableReader()
+	require.NoError(t, err)
+
+	// Reads should be translated to ReadAt() calls at the offset
+	// that was last sought to.
+	off, err := r.Seek(-5, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), off)
+	var p [5]byte
+	n, err := r.Read(p[:])
+	require.Equal(t, 5, n)
+	require.NoError(t, err)
+	require.Equal(t, []byte("world"), p[:])
+
+	off, err = r.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), off)
+	n, err = r.Read(p[:])
+	require.Equal(t, 5, n)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), p[:])
+
+	// Closing the reader should release the underlying file.
+	require.NoError(t, r.Close())
+}
+
 func TestNewValidatedBufferFromFileReaderCloneCopy(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
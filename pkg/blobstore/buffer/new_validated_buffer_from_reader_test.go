@@ -0,0 +1,110 @@
+package buffer_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newStringReadCloser(s string) io.ReadCloser {
+	return ioutil.NopCloser(strings.NewReader(s))
+}
+
+func TestNewValidatedBufferFromReaderGetSizeBytes(t *testing.T) {
+	t.Run("Known", func(t *testing.T) {
+		b := buffer.NewValidatedBufferFromReader(newStringReadCloser("Hello"), 5)
+		n, err := b.GetSizeBytes()
+		require.NoError(t, err)
+		require.Equal(t, int64(5), n)
+		b.Discard()
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		// The stream should not be consumed to determine the
+		// size. Its contents should remain accessible.
+		b := buffer.NewValidatedBufferFromReader(newStringReadCloser("Hello"), -1)
+		_, err := b.GetSizeBytes()
+		require.Equal(t, buffer.ErrSizeUnknown, err)
+
+		data, err := b.ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+}
+
+func TestNewValidatedBufferFromReaderIntoWriter(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		writer := bytes.NewBuffer(nil)
+		require.NoError(t, buffer.NewValidatedBufferFromReader(newStringReadCloser("Hello"), 5).IntoWriter(writer))
+		require.Equal(t, []byte("Hello"), writer.Bytes())
+	})
+
+	t.Run("TooShort", func(t *testing.T) {
+		writer := bytes.NewBuffer(nil)
+		err := buffer.NewValidatedBufferFromReader(newStringReadCloser("Hello"), 6).IntoWriter(writer)
+		require.Equal(t, status.Error(codes.DataLoss, "Buffer is 5 bytes in size, while 6 bytes were expected"), err)
+	})
+
+	t.Run("TooLong", func(t *testing.T) {
+		writer := bytes.NewBuffer(nil)
+		err := buffer.NewValidatedBufferFromReader(newStringReadCloser("Hello"), 4).IntoWriter(writer)
+		require.Equal(t, status.Error(codes.DataLoss, "Buffer is at least 5 bytes in size, while 4 bytes were expected"), err)
+		require.True(t, buffer.IsDataIntegrityError(err))
+	})
+}
+
+func TestNewValidatedBufferFromReaderToByteSlice(t *testing.T) {
+	t.Run("KnownSizeTooBig", func(t *testing.T) {
+		_, err := buffer.NewValidatedBufferFromReader(newStringReadCloser("Hello"), 5).ToByteSlice(4)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is 5 bytes in size, while a maximum of 4 bytes is permitted"), err)
+	})
+
+	t.Run("UnknownSizeTooBig", func(t *testing.T) {
+		_, err := buffer.NewValidatedBufferFromReader(newStringReadCloser("Hello"), -1).ToByteSlice(4)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is at least 5 bytes in size, while a maximum of 4 bytes is permitted"), err)
+	})
+}
+
+func TestNewValidatedBufferFromReaderToChunkReader(t *testing.T) {
+	t.Run("Offset", func(t *testing.T) {
+		r := buffer.NewValidatedBufferFromReader(newStringReadCloser("Hello world"), 11).ToChunkReader(6, buffer.ChunkSizeAtMost(2))
+		for _, expected := range []string{"wo", "rl", "d"} {
+			chunk, err := r.Read()
+			require.NoError(t, err)
+			require.Equal(t, []byte(expected), chunk)
+		}
+		_, err := r.Read()
+		require.Equal(t, io.EOF, err)
+		r.Close()
+	})
+
+	t.Run("OffsetTooHigh", func(t *testing.T) {
+		r := buffer.NewValidatedBufferFromReader(newStringReadCloser("Hello"), 5).ToChunkReader(6, buffer.ChunkSizeAtMost(2))
+		_, err := r.Read()
+		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is 5 bytes in size, while a read at offset 6 was requested"), err)
+		r.Close()
+	})
+}
+
+func TestNewValidatedBufferFromReaderCloneStream(t *testing.T) {
+	b1, b2 := buffer.NewValidatedBufferFromReader(newStringReadCloser("Hello"), -1).CloneStream()
+	done := make(chan struct{})
+	go func() {
+		data, err := b1.ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+		close(done)
+	}()
+	data, err := b2.ToByteSlice(10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+	<-done
+}
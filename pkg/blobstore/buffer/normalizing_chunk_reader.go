@@ -2,38 +2,58 @@ package buffer
 
 type normalizingChunkReader struct {
 	ChunkReader
-	maximumChunkSizeBytes int
-	lastChunk             []byte
+	chunkPolicy ChunkPolicy
+
+	pendingChunk []byte
+	pendingOwned bool
+	err          error
 }
 
 // newNormalizingChunkReader creates a decorator for ChunkReader that
-// normalizes the sizes of the chunks returned by Read(). It causes
-// empty chunks to be omitted. Chunks that exceed a provided maximum
-// size are decomposed into smaller ones.
-func newNormalizingChunkReader(r ChunkReader, maximumChunkSizeBytes int) ChunkReader {
+// normalizes the sizes of the chunks returned by Read(), so that they
+// respect a ChunkPolicy. It causes empty chunks to be omitted. Chunks
+// that exceed the maximum size are decomposed into smaller ones, while
+// chunks below the minimum size are coalesced with the ones that
+// follow. A smaller chunk is only returned at the end of the stream.
+func newNormalizingChunkReader(r ChunkReader, chunkPolicy ChunkPolicy) ChunkReader {
 	return &normalizingChunkReader{
-		ChunkReader:           r,
-		maximumChunkSizeBytes: maximumChunkSizeBytes,
+		ChunkReader: r,
+		chunkPolicy: chunkPolicy,
 	}
 }
 
 func (r *normalizingChunkReader) Read() ([]byte, error) {
 	for {
-		if len(r.lastChunk) > 0 {
-			if len(r.lastChunk) > r.maximumChunkSizeBytes {
-				chunk := r.lastChunk[:r.maximumChunkSizeBytes]
-				r.lastChunk = r.lastChunk[r.maximumChunkSizeBytes:]
-				return chunk, nil
+		// Return pending data if there is enough of it, or if no
+		// more data can be obtained from the underlying reader.
+		if n := len(r.pendingChunk); n > 0 && (n >= r.chunkPolicy.minimumSizeBytes || r.err != nil) {
+			if r.chunkPolicy.hasMaximum() && n > r.chunkPolicy.maximumSizeBytes {
+				n = r.chunkPolicy.maximumSizeBytes
 			}
-			chunk := r.lastChunk
-			r.lastChunk = nil
+			chunk := r.pendingChunk[:n]
+			r.pendingChunk = r.pendingChunk[n:]
 			return chunk, nil
 		}
+		if r.err != nil {
+			return nil, r.err
+		}
 
 		chunk, err := r.ChunkReader.Read()
 		if err != nil {
-			return nil, err
+			r.err = err
+		} else if len(r.pendingChunk) == 0 {
+			r.pendingChunk = chunk
+			r.pendingOwned = false
+		} else if len(chunk) > 0 {
+			// Coalesce the chunk with pending data. Chunks
+			// returned by the underlying reader may be shared
+			// (e.g., when multiplexed), meaning they must be
+			// copied into a separate buffer.
+			if !r.pendingOwned {
+				r.pendingChunk = append(make([]byte, 0, len(r.pendingChunk)+len(chunk)), r.pendingChunk...)
+				r.pendingOwned = true
+			}
+			r.pendingChunk = append(r.pendingChunk, chunk...)
 		}
-		r.lastChunk = chunk
 	}
 }
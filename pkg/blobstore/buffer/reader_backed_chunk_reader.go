@@ -5,22 +5,24 @@ import (
 )
 
 type readerBackedChunkReader struct {
-	r                     io.ReadCloser
-	maximumChunkSizeBytes int
+	r           io.ReadCloser
+	chunkPolicy ChunkPolicy
 }
 
 // newReaderBackedChunkReader creates a ChunkReader based on an existing
 // ReadCloser. It attempts to read data from the ReadCloser, turning it
-// into chunks of the maximum permitted size.
-func newReaderBackedChunkReader(r io.ReadCloser, maximumChunkSizeBytes int) ChunkReader {
+// into chunks of the maximum permitted size. As chunks are filled
+// completely, only the final chunk may be smaller than the minimum size
+// of the ChunkPolicy.
+func newReaderBackedChunkReader(r io.ReadCloser, chunkPolicy ChunkPolicy) ChunkReader {
 	return &readerBackedChunkReader{
-		r:                     r,
-		maximumChunkSizeBytes: maximumChunkSizeBytes,
+		r:           r,
+		chunkPolicy: chunkPolicy,
 	}
 }
 
 func (r *readerBackedChunkReader) Read() ([]byte, error) {
-	b := make([]byte, r.maximumChunkSizeBytes)
+	b := make([]byte, r.chunkPolicy.readSizeBytes())
 	n, err := io.ReadFull(r.r, b[:])
 	if n > 0 {
 		return b[:n], nil
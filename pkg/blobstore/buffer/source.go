@@ -53,47 +53,47 @@ func (s Source) notifyDataValid() {
 // failing to be marshaled properly.
 func (s Source) notifyProtoMarshalFailure(marshalErr error) error {
 	s.dataIntegrityCallback(false)
-	return util.StatusWrapWithCode(marshalErr, s.errorCode, "Failed to marshal message")
+	return MarkDataIntegrityError(util.StatusWrapWithCode(marshalErr, s.errorCode, "Failed to marshal message"))
 }
 
 // notifyProtoUnmarshalFailure triggers a repair due to a Protobuf
 // message failing to be unmarshaled properly.
 func (s Source) notifyProtoUnmarshalFailure(unmarshalErr error) error {
 	s.dataIntegrityCallback(false)
-	return util.StatusWrapWithCode(unmarshalErr, s.errorCode, "Failed to unmarshal message")
+	return MarkDataIntegrityError(util.StatusWrapWithCode(unmarshalErr, s.errorCode, "Failed to unmarshal message"))
 }
 
 // notifyCASTooBig triggers a repair due to a Content Addressable
 // Storage object being larger than expected.
 func (s Source) notifyCASTooBig(sizeExpected int64, sizeObserved int64) error {
 	s.dataIntegrityCallback(false)
-	return status.Errorf(
+	return MarkDataIntegrityError(status.Errorf(
 		s.errorCode,
 		"Buffer is at least %d bytes in size, while %d bytes were expected",
 		sizeObserved,
-		sizeExpected)
+		sizeExpected))
 }
 
 // notifyCASSizeMismatch triggers a repair due to a Content Addressable
 // Storage object having the wrong exact size.
 func (s Source) notifyCASSizeMismatch(sizeExpected int64, sizeObserved int64) error {
 	s.dataIntegrityCallback(false)
-	return status.Errorf(
+	return MarkDataIntegrityError(status.Errorf(
 		s.errorCode,
 		"Buffer is %d bytes in size, while %d bytes were expected",
 		sizeObserved,
-		sizeExpected)
+		sizeExpected))
 }
 
 // notifyCASHashMismatch triggers a repair due to a Content Addressable
 // Storage object having the wrong cryptographic checksum.
 func (s Source) notifyCASHashMismatch(hashExpected []byte, hashObserved []byte) error {
 	s.dataIntegrityCallback(false)
-	return status.Errorf(
+	return MarkDataIntegrityError(status.Errorf(
 		s.errorCode,
 		"Buffer has checksum %s, while %s was expected",
 		hex.EncodeToString(hashObserved),
-		hex.EncodeToString(hashExpected))
+		hex.EncodeToString(hashExpected)))
 }
 
 var (
@@ -0,0 +1,145 @@
+package buffer
+
+import (
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// SpillingBuffer is a writable container for data of which the size is
+// not known up front, such as the body of a large upload. The first
+// memLimitBytes bytes written to it are held in memory, while the
+// remainder is written to a spill file.
+//
+// Once all data has been written, Build() converts it to a Buffer that
+// implements all operations, including ReadAt() and CloneStream(). The
+// spill file is closed (and thus removed) when that Buffer is
+// discarded or consumed.
+type SpillingBuffer struct {
+	memLimitBytes    int
+	spillFileFactory SpillFileFactory
+
+	prefix      []byte
+	spillWriter spillFileWriter
+	err         error
+}
+
+// NewSpillingBuffer creates a SpillingBuffer that places its spill
+// file in a provided directory. If the directory is the empty string,
+// the system's temporary directory is used.
+func NewSpillingBuffer(memLimitBytes int, tempDir string) *SpillingBuffer {
+	return newSpillingBuffer(memLimitBytes, NewTemporarySpillFileFactory(tempDir))
+}
+
+func newSpillingBuffer(memLimitBytes int, spillFileFactory SpillFileFactory) *SpillingBuffer {
+	return &SpillingBuffer{
+		memLimitBytes:    memLimitBytes,
+		spillFileFactory: spillFileFactory,
+	}
+}
+
+// Write data into the SpillingBuffer. The spill file is only created
+// once the memory limit is exceeded.
+func (sb *SpillingBuffer) Write(p []byte) (int, error) {
+	if sb.err != nil {
+		return 0, sb.err
+	}
+
+	n := 0
+	if remaining := sb.memLimitBytes - len(sb.prefix); remaining > 0 {
+		n = len(p)
+		if n > remaining {
+			n = remaining
+		}
+		sb.prefix = append(sb.prefix, p[:n]...)
+		p = p[n:]
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+
+	if sb.spillWriter.f == nil {
+		f, err := sb.spillFileFactory()
+		if err != nil {
+			sb.err = util.StatusWrap(err, "Failed to create spill file")
+			return n, sb.err
+		}
+		sb.spillWriter.f = f
+	}
+	nSpilled, err := sb.spillWriter.Write(p)
+	if err != nil {
+		sb.err = util.StatusWrap(err, "Failed to write to spill file")
+	}
+	return n + nSpilled, sb.err
+}
+
+// Build a Buffer from the data written into the SpillingBuffer. The
+// SpillingBuffer may no longer be used afterwards.
+func (sb *SpillingBuffer) Build() Buffer {
+	if sb.err != nil {
+		err := sb.err
+		sb.Discard()
+		return NewBufferFromError(err)
+	}
+	prefix := sb.prefix
+	sb.prefix = nil
+	if sb.spillWriter.f == nil {
+		return NewValidatedBufferFromByteSlice(prefix)
+	}
+	return NewValidatedBufferFromFileReader(
+		&spilledFileReader{
+			prefix: prefix,
+			f:      sb.spillWriter.f,
+		},
+		int64(len(prefix))+sb.spillWriter.offset)
+}
+
+// Discard the data written into the SpillingBuffer, closing the spill
+// file if one was created. This may be used instead of Build() in case
+// writing the data fails.
+func (sb *SpillingBuffer) Discard() {
+	if sb.spillWriter.f != nil {
+		sb.spillWriter.f.Close()
+		sb.spillWriter.f = nil
+	}
+	sb.prefix = nil
+}
+
+// spilledFileReader is an implementation of filesystem.FileReader for
+// data of which the first part is held in memory, while the remainder
+// is stored in a spill file.
+type spilledFileReader struct {
+	prefix []byte
+	f      filesystem.FileReader
+}
+
+func (r *spilledFileReader) ReadAt(p []byte, off int64) (int, error) {
+	nPrefix := 0
+	if prefixSize := int64(len(r.prefix)); off < prefixSize {
+		nPrefix = copy(p, r.prefix[off:])
+		if nPrefix == len(p) {
+			return nPrefix, nil
+		}
+		p = p[nPrefix:]
+		off = prefixSize
+	}
+	n, err := r.f.ReadAt(p, off-int64(len(r.prefix)))
+	return nPrefix + n, err
+}
+
+func (r *spilledFileReader) Close() error {
+	r.prefix = nil
+	return r.f.Close()
+}
+
+// spillFileWriter is an adapter for filesystem.FileReadWriter that
+// permits data to be written into it sequentially.
+type spillFileWriter struct {
+	f      filesystem.FileReadWriter
+	offset int64
+}
+
+func (w *spillFileWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
@@ -0,0 +1,236 @@
+package buffer
+
+import (
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TeeWriteErrorPolicy determines how Tee() responds to errors returned
+// by the io.Writer to which a copy of the data is written.
+type TeeWriteErrorPolicy int
+
+const (
+	// TeeWriteErrorAbort causes reads of the buffer to fail when
+	// writing to the io.Writer fails. This should be used if the
+	// copy must be complete (e.g., when populating a cache).
+	TeeWriteErrorAbort TeeWriteErrorPolicy = iota
+	// TeeWriteErrorIgnore causes errors writing to the io.Writer to
+	// be ignored. Once an error occurs, no further data is written.
+	// This may be used for best-effort logging.
+	TeeWriteErrorIgnore
+)
+
+// teeWriter holds the state of the io.WriteCloser to which Tee()
+// copies data. It ensures that the io.WriteCloser is closed exactly
+// once.
+type teeWriter struct {
+	w                io.WriteCloser
+	writeErrorPolicy TeeWriteErrorPolicy
+	writeErr         error
+	closed           bool
+}
+
+func (tw *teeWriter) write(p []byte) error {
+	if tw.closed || tw.writeErr != nil {
+		return nil
+	}
+	if _, err := tw.w.Write(p); err != nil {
+		tw.writeErr = err
+		if tw.writeErrorPolicy == TeeWriteErrorAbort {
+			return util.StatusWrap(err, "Failed to write copy of data")
+		}
+	}
+	return nil
+}
+
+// close the io.WriteCloser. If the io.WriteCloser provides a
+// CloseWithError() function (e.g., io.PipeWriter), it is used to
+// inform the recipient that it did not receive all data.
+func (tw *teeWriter) close(err error) {
+	if tw.closed {
+		return
+	}
+	tw.closed = true
+	if err == nil {
+		err = tw.writeErr
+	}
+	if err != nil {
+		if ew, ok := tw.w.(interface{ CloseWithError(error) error }); ok {
+			ew.CloseWithError(err)
+			return
+		}
+	}
+	tw.w.Close()
+}
+
+type teeChunkReader struct {
+	base ChunkReader
+	w    *teeWriter
+}
+
+func (r *teeChunkReader) Read() ([]byte, error) {
+	chunk, err := r.base.Read()
+	if err == io.EOF {
+		r.w.close(nil)
+		return nil, err
+	} else if err != nil {
+		r.w.close(err)
+		return nil, err
+	}
+	if err := r.w.write(chunk); err != nil {
+		r.w.close(err)
+		return nil, err
+	}
+	return chunk, nil
+}
+
+func (r *teeChunkReader) Close() {
+	r.base.Close()
+	r.w.close(status.Error(codes.Canceled, "Buffer was not read in its entirety"))
+}
+
+type teeBuffer struct {
+	base Buffer
+	w    *teeWriter
+}
+
+// Tee returns a decorated Buffer that writes a copy of all data to an
+// io.WriteCloser as it is being read by the consumer. This is more
+// lightweight than CloneStream() when the second recipient of the data
+// is a simple writer, as it does not require running a separate
+// goroutine.
+//
+// Chunks are written to w as soon as they are read. For buffers whose
+// contents are validated against a digest, validation only completes
+// once all data has been read. This means that w may receive data that
+// later turns out to be corrupted.
+//
+// The io.WriteCloser is closed after the buffer has been read or
+// discarded. If the buffer is discarded, reading fails (including
+// failing checksum validation) or the buffer is not read in its
+// entirety, w is closed through CloseWithError() if available, so that
+// the recipient may discard the incomplete or corrupted copy. Writers
+// that do not provide CloseWithError() are closed normally in that
+// case, meaning they cannot distinguish a corrupted copy from a valid
+// one. Callers that require a valid copy must therefore provide a
+// writer that implements CloseWithError().
+//
+// When the buffer is cloned, data is only written to w as it is
+// consumed through the first of the two resulting buffers.
+func Tee(b Buffer, w io.WriteCloser, writeErrorPolicy TeeWriteErrorPolicy) Buffer {
+	return &teeBuffer{
+		base: b,
+		w: &teeWriter{
+			w:                w,
+			writeErrorPolicy: writeErrorPolicy,
+		},
+	}
+}
+
+func (b *teeBuffer) GetSizeBytes() (int64, error) {
+	return b.base.GetSizeBytes()
+}
+
+func (b *teeBuffer) Checksum() (digest.Digest, error) {
+	return b.base.Checksum()
+}
+
+func (b *teeBuffer) IntoWriter(w io.Writer) error {
+	sizeBytes, err := b.base.GetSizeBytes()
+	if err != nil {
+		sizeBytes = -1
+	}
+	return intoWriterViaChunkReader(b.ToChunkReader(0, ChunkSizeAtMost(defaultChunkSizeBytes)), w, sizeBytes)
+}
+
+func (b *teeBuffer) ReadAt(p []byte, off int64) (int, error) {
+	return readAtViaChunkReader(b.ToChunkReader(0, ChunkSizeAtMost(defaultChunkSizeBytes)), p, off)
+}
+
+func (b *teeBuffer) ToProto(m proto.Message, maximumSizeBytes int) (proto.Message, error) {
+	return toProtoViaByteSlice(b, m, maximumSizeBytes)
+}
+
+func (b *teeBuffer) ToByteSlice(maximumSizeBytes int) ([]byte, error) {
+	// Reject buffers whose size is known to be too large, without
+	// reading any data.
+	if sizeBytes, err := b.base.GetSizeBytes(); err == nil && sizeBytes > int64(maximumSizeBytes) {
+		b.Discard()
+		return nil, status.Errorf(codes.InvalidArgument, "Buffer is %d bytes in size, while a maximum of %d bytes is permitted", sizeBytes, maximumSizeBytes)
+	}
+
+	r := b.ToChunkReader(0, ChunkSizeAtMost(defaultChunkSizeBytes))
+	defer r.Close()
+
+	var data []byte
+	for {
+		chunk, err := r.Read()
+		if err == io.EOF {
+			return data, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(data)+len(chunk) > maximumSizeBytes {
+			return nil, status.Errorf(codes.InvalidArgument, "Buffer is at least %d bytes in size, while a maximum of %d bytes is permitted", len(data)+len(chunk), maximumSizeBytes)
+		}
+		data = append(data, chunk...)
+	}
+}
+
+func (b *teeBuffer) ToChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	// Even if the consumer only requests the tail of the data,
+	// the copy written to the io.WriteCloser must be complete.
+	return newOffsetChunkReader(
+		&teeChunkReader{
+			base: b.base.ToChunkReader(0, chunkPolicy),
+			w:    b.w,
+		},
+		off)
+}
+
+func (b *teeBuffer) ToReader() io.ReadCloser {
+	return newChunkReaderBackedReader(b.ToChunkReader(0, ChunkSizeAtMost(defaultChunkSizeBytes)))
+}
+
+func (b *teeBuffer) ToSeekableReader() (ReadSeekCloser, error) {
+	b.Discard()
+	return nil, ErrNotSeekable
+}
+
+func (b *teeBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
+	return cloneCopyViaByteSlice(b, maximumSizeBytes)
+}
+
+func (b *teeBuffer) CloneStream() (Buffer, Buffer) {
+	b1, b2 := b.base.CloneStream()
+	return &teeBuffer{base: b1, w: b.w}, b2
+}
+
+func (b *teeBuffer) Discard() {
+	b.base.Discard()
+	b.w.close(status.Error(codes.Canceled, "Buffer was discarded"))
+}
+
+func (b *teeBuffer) applyErrorHandler(errorHandler ErrorHandler) (Buffer, bool) {
+	replacement, shouldRetry := b.base.applyErrorHandler(errorHandler)
+	return &teeBuffer{base: replacement, w: b.w}, shouldRetry
+}
+
+func (b *teeBuffer) toUnvalidatedChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return newOffsetChunkReader(
+		&teeChunkReader{
+			base: b.base.toUnvalidatedChunkReader(0, chunkPolicy),
+			w:    b.w,
+		},
+		off)
+}
+
+func (b *teeBuffer) toUnvalidatedReader(off int64) io.ReadCloser {
+	return newChunkReaderBackedReader(b.toUnvalidatedChunkReader(off, ChunkSizeAtMost(defaultChunkSizeBytes)))
+}
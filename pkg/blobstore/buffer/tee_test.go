@@ -0,0 +1,114 @@
+package buffer_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// teeRecorder is an io.WriteCloser that records the data written to it
+// and the way in which it was closed.
+type teeRecorder struct {
+	data     []byte
+	writeErr error
+	closed   bool
+	closeErr error
+}
+
+func (r *teeRecorder) Write(p []byte) (int, error) {
+	if r.writeErr != nil {
+		return 0, r.writeErr
+	}
+	r.data = append(r.data, p...)
+	return len(p), nil
+}
+
+func (r *teeRecorder) Close() error {
+	return r.CloseWithError(nil)
+}
+
+func (r *teeRecorder) CloseWithError(err error) error {
+	if r.closed {
+		panic("Writer closed multiple times")
+	}
+	r.closed = true
+	r.closeErr = err
+	return nil
+}
+
+func TestTee(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	t.Run("Success", func(t *testing.T) {
+		recorder := &teeRecorder{}
+		data, err := buffer.Tee(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")), recorder, buffer.TeeWriteErrorAbort).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+		require.Equal(t, &teeRecorder{data: []byte("Hello"), closed: true}, recorder)
+	})
+
+	t.Run("ReadAtTail", func(t *testing.T) {
+		// Even if only the tail of the buffer is read, the
+		// writer should receive a copy starting at the
+		// beginning of the data.
+		recorder := &teeRecorder{}
+		p := make([]byte, 2)
+		n, err := buffer.Tee(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")), recorder, buffer.TeeWriteErrorAbort).ReadAt(p, 3)
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+		require.Equal(t, []byte("lo"), p)
+		require.Equal(t, []byte("Hello"), recorder.data)
+		require.True(t, recorder.closed)
+	})
+
+	t.Run("Discard", func(t *testing.T) {
+		recorder := &teeRecorder{}
+		buffer.Tee(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")), recorder, buffer.TeeWriteErrorAbort).Discard()
+		require.Equal(t, &teeRecorder{closed: true, closeErr: status.Error(codes.Canceled, "Buffer was discarded")}, recorder)
+	})
+
+	t.Run("ChecksumMismatch", func(t *testing.T) {
+		// Data that fails validation should not be written,
+		// and the writer should be informed about the failure.
+		blobDigest := digest.MustNewDigest("ubuntu1804", "d41d8cd98f00b204e9800998ecf8427e", 5)
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(false)
+		recorder := &teeRecorder{}
+
+		_, err := buffer.Tee(
+			buffer.NewCASBufferFromByteSlice(blobDigest, []byte("Hello"), buffer.BackendProvided(dataIntegrityCallback.Call)),
+			recorder,
+			buffer.TeeWriteErrorAbort).ToByteSlice(10)
+		expectedErr := buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum 8b1a9953c4611296a827abf8c47804d7, while d41d8cd98f00b204e9800998ecf8427e was expected"))
+		require.Equal(t, expectedErr, err)
+		require.Equal(t, &teeRecorder{closed: true, closeErr: expectedErr}, recorder)
+	})
+
+	t.Run("WriteErrorAbort", func(t *testing.T) {
+		recorder := &teeRecorder{writeErr: status.Error(codes.ResourceExhausted, "Out of disk space")}
+		_, err := buffer.Tee(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")), recorder, buffer.TeeWriteErrorAbort).ToByteSlice(10)
+		require.Equal(t, status.Error(codes.ResourceExhausted, "Failed to write copy of data: Out of disk space"), err)
+		require.True(t, recorder.closed)
+		require.Equal(t, err, recorder.closeErr)
+	})
+
+	t.Run("WriteErrorIgnore", func(t *testing.T) {
+		// Write errors should not cause reads to fail, but the
+		// writer should still be informed that its copy is
+		// incomplete.
+		recorder := &teeRecorder{writeErr: status.Error(codes.ResourceExhausted, "Out of disk space")}
+		data, err := buffer.Tee(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")), recorder, buffer.TeeWriteErrorIgnore).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+		require.True(t, recorder.closed)
+		require.Equal(t, status.Error(codes.ResourceExhausted, "Out of disk space"), recorder.closeErr)
+	})
+}
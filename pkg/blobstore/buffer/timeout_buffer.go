@@ -0,0 +1,87 @@
+package buffer
+
+import (
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewTimeoutBuffer creates a decorator for Buffer that causes reads of
+// its contents to fail with DEADLINE_EXCEEDED if no data is returned
+// by the underlying buffer within a given amount of time. The timeout
+// is reset every time data is returned, meaning that transfers that
+// are slow, but still make progress, are permitted to complete.
+//
+// This decorator may be used to prevent consumers from blocking
+// indefinitely on backends that have become unresponsive, without the
+// context being cancelled.
+func NewTimeoutBuffer(b Buffer, clock clock.Clock, idleTimeout time.Duration) Buffer {
+	return WithChunkReaderDecorator(b, func(r ChunkReader, off int64) ChunkReader {
+		return &timeoutChunkReader{
+			base:        r,
+			clock:       clock,
+			idleTimeout: idleTimeout,
+		}
+	})
+}
+
+type chunkReadResult struct {
+	chunk []byte
+	err   error
+}
+
+type timeoutChunkReader struct {
+	base        ChunkReader
+	clock       clock.Clock
+	idleTimeout time.Duration
+
+	// If a call to Read() against the underlying ChunkReader timed
+	// out, this channel receives its eventual result. It needs to
+	// be drained before the underlying ChunkReader may be closed.
+	pending <-chan chunkReadResult
+	err     error
+}
+
+func (r *timeoutChunkReader) Read() ([]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// Call into the underlying ChunkReader asynchronously, so that
+	// we can stop waiting for it if the timeout expires.
+	results := make(chan chunkReadResult, 1)
+	go func() {
+		chunk, err := r.base.Read()
+		results <- chunkReadResult{chunk: chunk, err: err}
+	}()
+
+	timer, timerChannel := r.clock.NewTimer(r.idleTimeout)
+	select {
+	case result := <-results:
+		timer.Stop()
+		return result.chunk, result.err
+	case <-timerChannel:
+		r.pending = results
+		r.err = status.Errorf(codes.DeadlineExceeded, "No data was received within %s", r.idleTimeout)
+		return nil, r.err
+	}
+}
+
+func (r *timeoutChunkReader) Close() {
+	if r.pending == nil {
+		r.base.Close()
+		return
+	}
+
+	// A call to Read() is still in progress. Don't block the
+	// caller, but close the underlying ChunkReader as soon as it
+	// has completed.
+	pending, base := r.pending, r.base
+	go func() {
+		<-pending
+		base.Close()
+	}()
+}
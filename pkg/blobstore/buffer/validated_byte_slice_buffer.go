@@ -16,10 +16,22 @@ type validatedByteSliceBuffer struct {
 	data []byte
 }
 
+// emptyBuffer is a shared instance of an empty buffer, so that no
+// allocations are needed to create one.
+var emptyBuffer Buffer = &validatedByteSliceBuffer{}
+
+// NewEmptyBuffer creates a Buffer that contains no data.
+func NewEmptyBuffer() Buffer {
+	return emptyBuffer
+}
+
 // NewValidatedBufferFromByteSlice creates a Buffer that is backed by a
 // slice of bytes. No checking of data integrity is performed, as it is
 // assumed that the data stored in the slice is valid.
 func NewValidatedBufferFromByteSlice(data []byte) Buffer {
+	if len(data) == 0 {
+		return emptyBuffer
+	}
 	return &validatedByteSliceBuffer{
 		data: data,
 	}
@@ -35,9 +47,18 @@ func NewCASBufferFromByteSlice(digest digest.Digest, data []byte, source Source)
 		return NewBufferFromError(source.notifyCASSizeMismatch(expectedSizeBytes, actualSizeBytes))
 	}
 
-	// Compare the blob's checksum.
+	// Compare the blob's checksum. This can be skipped for the
+	// empty blob, as the digest itself can be checked.
+	if digest.IsEmptyBlob() {
+		source.notifyDataValid()
+		return casByteSliceBuffer{digest: digest}
+	}
+	hasherFactory, err := digest.GetHasherFactory()
+	if err != nil {
+		return NewBufferFromError(err)
+	}
 	expectedChecksum := digest.GetHashBytes()
-	hasher := digest.NewHasher()
+	hasher := hasherFactory()
 	hasher.Write(data)
 	actualChecksum := hasher.Sum(nil)
 	if bytes.Compare(expectedChecksum, actualChecksum) != 0 {
@@ -45,13 +66,20 @@ func NewCASBufferFromByteSlice(digest digest.Digest, data []byte, source Source)
 	}
 
 	source.notifyDataValid()
-	return NewValidatedBufferFromByteSlice(data)
+	return casByteSliceBuffer{
+		validatedByteSliceBuffer: validatedByteSliceBuffer{data: data},
+		digest:                   digest,
+	}
 }
 
 func (b validatedByteSliceBuffer) GetSizeBytes() (int64, error) {
 	return int64(len(b.data)), nil
 }
 
+func (b validatedByteSliceBuffer) Checksum() (digest.Digest, error) {
+	return digest.BadDigest, ErrDigestUnknown
+}
+
 func (b validatedByteSliceBuffer) IntoWriter(w io.Writer) error {
 	_, err := w.Write(b.data)
 	return err
@@ -82,14 +110,18 @@ func (b validatedByteSliceBuffer) ToByteSlice(maximumSizeBytes int) ([]byte, err
 	return b.data, nil
 }
 
-func (b validatedByteSliceBuffer) ToChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
-	return b.toUnvalidatedChunkReader(off, maximumChunkSizeBytes)
+func (b validatedByteSliceBuffer) ToChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return b.toUnvalidatedChunkReader(off, chunkPolicy)
 }
 
 func (b validatedByteSliceBuffer) ToReader() io.ReadCloser {
 	return b.toUnvalidatedReader(0)
 }
 
+func (b validatedByteSliceBuffer) ToSeekableReader() (ReadSeekCloser, error) {
+	return byteSliceReadSeekCloser{Reader: bytes.NewReader(b.data)}, nil
+}
+
 func (b validatedByteSliceBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
 	return b, b
 }
@@ -107,13 +139,13 @@ func (b validatedByteSliceBuffer) applyErrorHandler(errorHandler ErrorHandler) (
 	return b, false
 }
 
-func (b validatedByteSliceBuffer) toUnvalidatedChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
+func (b validatedByteSliceBuffer) toUnvalidatedChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
 	if err := validateReaderOffset(int64(len(b.data)), off); err != nil {
 		return newErrorChunkReader(err)
 	}
 	return &byteSliceChunkReader{
-		maximumChunkSizeBytes: maximumChunkSizeBytes,
-		data:                  b.data[off:],
+		chunkPolicy: chunkPolicy,
+		data:        b.data[off:],
 	}
 }
 
@@ -125,8 +157,8 @@ func (b validatedByteSliceBuffer) toUnvalidatedReader(off int64) io.ReadCloser {
 }
 
 type byteSliceChunkReader struct {
-	maximumChunkSizeBytes int
-	data                  []byte
+	chunkPolicy ChunkPolicy
+	data        []byte
 }
 
 func (r *byteSliceChunkReader) Read() ([]byte, error) {
@@ -135,14 +167,54 @@ func (r *byteSliceChunkReader) Read() ([]byte, error) {
 		// No more data to return.
 		return nil, io.EOF
 	}
-	if len(data) <= r.maximumChunkSizeBytes {
+	maximumSizeBytes := r.chunkPolicy.maximumSizeBytes
+	if !r.chunkPolicy.hasMaximum() || len(data) <= maximumSizeBytes {
 		// Last chunk of data to be returned.
 		r.data = nil
 		return data, nil
 	}
-	// Full chunk of data still available.
-	r.data = r.data[r.maximumChunkSizeBytes:]
-	return data[:r.maximumChunkSizeBytes], nil
+	// Full chunk of data still available. As the data is
+	// contiguous, chunks of the maximum size always respect the
+	// minimum size as well.
+	r.data = r.data[maximumSizeBytes:]
+	return data[:maximumSizeBytes], nil
 }
 
 func (r *byteSliceChunkReader) Close() {}
+
+// byteSliceReadSeekCloser is returned by
+// validatedByteSliceBuffer.ToSeekableReader(). As the buffer holds no
+// resources, closing the reader is a no-op.
+type byteSliceReadSeekCloser struct {
+	*bytes.Reader
+}
+
+func (r byteSliceReadSeekCloser) Close() error {
+	return nil
+}
+
+// casByteSliceBuffer is a byte slice backed buffer whose contents have
+// been validated against a digest. As opposed to buffers created
+// through NewValidatedBufferFromByteSlice(), it is capable of returning
+// the digest through Checksum().
+type casByteSliceBuffer struct {
+	validatedByteSliceBuffer
+	digest digest.Digest
+}
+
+func (b casByteSliceBuffer) Checksum() (digest.Digest, error) {
+	return b.digest, nil
+}
+
+func (b casByteSliceBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
+	return b, b
+}
+
+func (b casByteSliceBuffer) CloneStream() (Buffer, Buffer) {
+	return b, b
+}
+
+func (b casByteSliceBuffer) applyErrorHandler(errorHandler ErrorHandler) (Buffer, bool) {
+	errorHandler.Done()
+	return b, false
+}
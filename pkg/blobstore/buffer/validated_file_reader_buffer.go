@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"sync/atomic"
 
+	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/buildbarn/bb-storage/pkg/filesystem"
 	"github.com/golang/protobuf/proto"
 
@@ -41,6 +42,10 @@ func (b *validatedReaderBuffer) GetSizeBytes() (int64, error) {
 	return b.sizeBytes, nil
 }
 
+func (b *validatedReaderBuffer) Checksum() (digest.Digest, error) {
+	return digest.BadDigest, ErrDigestUnknown
+}
+
 func (b *validatedReaderBuffer) IntoWriter(w io.Writer) error {
 	defer b.Discard()
 	_, err := io.Copy(w, io.NewSectionReader(b.r, 0, b.sizeBytes))
@@ -65,8 +70,8 @@ func (b *validatedReaderBuffer) ToByteSlice(maximumSizeBytes int) ([]byte, error
 	return ioutil.ReadAll(io.NewSectionReader(b.r, 0, b.sizeBytes))
 }
 
-func (b *validatedReaderBuffer) ToChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
-	return b.toUnvalidatedChunkReader(off, maximumChunkSizeBytes)
+func (b *validatedReaderBuffer) ToChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return b.toUnvalidatedChunkReader(off, chunkPolicy)
 }
 
 func (b *validatedReaderBuffer) ToReader() io.ReadCloser {
@@ -76,6 +81,13 @@ func (b *validatedReaderBuffer) ToReader() io.ReadCloser {
 	}
 }
 
+func (b *validatedReaderBuffer) ToSeekableReader() (ReadSeekCloser, error) {
+	return &validatedFileReaderReader{
+		SectionReader: *io.NewSectionReader(b.r, 0, b.sizeBytes),
+		b:             b,
+	}, nil
+}
+
 func (b *validatedReaderBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
 	atomic.AddInt32(&b.cloneCount, 1)
 	return b, b
@@ -107,12 +119,12 @@ func (b *validatedReaderBuffer) applyErrorHandler(errorHandler ErrorHandler) (re
 	return b, false
 }
 
-func (b *validatedReaderBuffer) toUnvalidatedChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
+func (b *validatedReaderBuffer) toUnvalidatedChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
 	if err := validateReaderOffset(b.sizeBytes, off); err != nil {
 		b.Discard()
 		return newErrorChunkReader(err)
 	}
-	return newReaderBackedChunkReader(b.toUnvalidatedReader(off), maximumChunkSizeBytes)
+	return newReaderBackedChunkReader(b.toUnvalidatedReader(off), chunkPolicy)
 }
 
 func (b *validatedReaderBuffer) toUnvalidatedReader(off int64) io.ReadCloser {
@@ -0,0 +1,165 @@
+package buffer
+
+import (
+	"context"
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type validatedStreamBuffer struct {
+	r         ChunkReader
+	sizeBytes int64
+}
+
+// NewValidatedBufferFromReader creates a Buffer that is backed by a
+// ReadCloser. No checking of data integrity is performed, as it is
+// assumed that the data returned by the reader is valid. This makes it
+// suitable for returning parts of blobs, which cannot be validated
+// against a digest.
+//
+// If sizeBytes is non-negative, it is the size of the data returned by
+// the reader. Reading the buffer fails if the stream turns out to be
+// shorter or longer. If sizeBytes is negative, the size is not known
+// up front, causing GetSizeBytes() to return ErrSizeUnknown.
+func NewValidatedBufferFromReader(r io.ReadCloser, sizeBytes int64) Buffer {
+	return &validatedStreamBuffer{
+		r: &sizeCheckingChunkReader{
+			ChunkReader: newReaderBackedChunkReader(r, ChunkSizeAtMost(defaultChunkSizeBytes)),
+			sizeBytes:   sizeBytes,
+		},
+		sizeBytes: sizeBytes,
+	}
+}
+
+func (b *validatedStreamBuffer) GetSizeBytes() (int64, error) {
+	if b.sizeBytes < 0 {
+		return 0, ErrSizeUnknown
+	}
+	return b.sizeBytes, nil
+}
+
+func (b *validatedStreamBuffer) Checksum() (digest.Digest, error) {
+	return digest.BadDigest, ErrDigestUnknown
+}
+
+func (b *validatedStreamBuffer) IntoWriter(w io.Writer) error {
+	return intoWriterViaChunkReader(b.r, w, -1)
+}
+
+func (b *validatedStreamBuffer) ReadAt(p []byte, off int64) (int, error) {
+	return readAtViaChunkReader(b.r, p, off)
+}
+
+func (b *validatedStreamBuffer) ToProto(m proto.Message, maximumSizeBytes int) (proto.Message, error) {
+	return toProtoViaByteSlice(b, m, maximumSizeBytes)
+}
+
+func (b *validatedStreamBuffer) ToByteSlice(maximumSizeBytes int) ([]byte, error) {
+	defer b.r.Close()
+
+	if b.sizeBytes > int64(maximumSizeBytes) {
+		return nil, status.Errorf(codes.InvalidArgument, "Buffer is %d bytes in size, while a maximum of %d bytes is permitted", b.sizeBytes, maximumSizeBytes)
+	}
+	var data []byte
+	for {
+		chunk, err := b.r.Read()
+		if err == io.EOF {
+			return data, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(chunk) > maximumSizeBytes-len(data) {
+			return nil, status.Errorf(codes.InvalidArgument, "Buffer is at least %d bytes in size, while a maximum of %d bytes is permitted", len(data)+len(chunk), maximumSizeBytes)
+		}
+		data = append(data, chunk...)
+	}
+}
+
+func (b *validatedStreamBuffer) ToChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return b.toUnvalidatedChunkReader(off, chunkPolicy)
+}
+
+func (b *validatedStreamBuffer) ToReader() io.ReadCloser {
+	return newChunkReaderBackedReader(b.r)
+}
+
+func (b *validatedStreamBuffer) ToSeekableReader() (ReadSeekCloser, error) {
+	b.Discard()
+	return nil, ErrNotSeekable
+}
+
+func (b *validatedStreamBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
+	return cloneCopyViaByteSlice(b, maximumSizeBytes)
+}
+
+func (b *validatedStreamBuffer) CloneStream() (Buffer, Buffer) {
+	r := newMultiplexedChunkReader(b.r, 1)
+	return &validatedStreamBuffer{
+		r:         r.newConsumer(context.Background()),
+		sizeBytes: b.sizeBytes,
+	}, &validatedStreamBuffer{
+		r:         r.newConsumer(context.Background()),
+		sizeBytes: b.sizeBytes,
+	}
+}
+
+func (b *validatedStreamBuffer) Discard() {
+	b.r.Close()
+}
+
+func (b *validatedStreamBuffer) applyErrorHandler(errorHandler ErrorHandler) (Buffer, bool) {
+	// Like NewValidatedBufferFromFileReader(), the data is assumed
+	// to be valid. The stream cannot be restarted, meaning there is
+	// no way to let the error handler provide a replacement buffer
+	// in case of I/O errors.
+	errorHandler.Done()
+	return b, false
+}
+
+func (b *validatedStreamBuffer) toUnvalidatedChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	if b.sizeBytes >= 0 {
+		if err := validateReaderOffset(b.sizeBytes, off); err != nil {
+			b.Discard()
+			return newErrorChunkReader(err)
+		}
+	}
+	return newNormalizingChunkReader(newOffsetChunkReader(b.r, off), chunkPolicy)
+}
+
+func (b *validatedStreamBuffer) toUnvalidatedReader(off int64) io.ReadCloser {
+	return newChunkReaderBackedReader(b.toUnvalidatedChunkReader(off, ChunkSizeAtMost(defaultChunkSizeBytes)))
+}
+
+// sizeCheckingChunkReader is a decorator for ChunkReader that causes
+// reads to fail if the stream is not of the expected size. No size
+// checking is performed if the expected size is negative.
+type sizeCheckingChunkReader struct {
+	ChunkReader
+	sizeBytes int64
+	bytesRead int64
+}
+
+func (r *sizeCheckingChunkReader) Read() ([]byte, error) {
+	chunk, err := r.ChunkReader.Read()
+	if r.sizeBytes < 0 {
+		return chunk, err
+	}
+	if err == io.EOF {
+		if r.bytesRead != r.sizeBytes {
+			return nil, MarkDataIntegrityError(status.Errorf(codes.DataLoss, "Buffer is %d bytes in size, while %d bytes were expected", r.bytesRead, r.sizeBytes))
+		}
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, err
+	}
+	if int64(len(chunk)) > r.sizeBytes-r.bytesRead {
+		return nil, MarkDataIntegrityError(status.Errorf(codes.DataLoss, "Buffer is at least %d bytes in size, while %d bytes were expected", r.bytesRead+int64(len(chunk)), r.sizeBytes))
+	}
+	r.bytesRead += int64(len(chunk))
+	return chunk, nil
+}
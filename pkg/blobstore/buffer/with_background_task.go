@@ -3,6 +3,7 @@ package buffer
 import (
 	"io"
 
+	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/golang/protobuf/proto"
 )
 
@@ -70,6 +71,10 @@ func (b *bufferWithBackgroundTask) GetSizeBytes() (int64, error) {
 	return b.base.GetSizeBytes()
 }
 
+func (b *bufferWithBackgroundTask) Checksum() (digest.Digest, error) {
+	return b.base.Checksum()
+}
+
 func (b *bufferWithBackgroundTask) IntoWriter(w io.Writer) error {
 	err := b.base.IntoWriter(w)
 	<-b.task.completion
@@ -106,14 +111,26 @@ func (b *bufferWithBackgroundTask) ToByteSlice(maximumSizeBytes int) ([]byte, er
 	return data, b.task.err
 }
 
-func (b *bufferWithBackgroundTask) ToChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
-	return b.decorateChunkReader(b.base.ToChunkReader(off, maximumChunkSizeBytes))
+func (b *bufferWithBackgroundTask) ToChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return b.decorateChunkReader(b.base.ToChunkReader(off, chunkPolicy))
 }
 
 func (b *bufferWithBackgroundTask) ToReader() io.ReadCloser {
 	return b.decorateReader(b.base.ToReader())
 }
 
+func (b *bufferWithBackgroundTask) ToSeekableReader() (ReadSeekCloser, error) {
+	r, err := b.base.ToSeekableReader()
+	if err != nil {
+		<-b.task.completion
+		return nil, err
+	}
+	return &readSeekerWithBackgroundTask{
+		ReadSeekCloser: r,
+		task:           b.task,
+	}, nil
+}
+
 func (b *bufferWithBackgroundTask) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
 	b1, b2 := b.base.CloneCopy(maximumSizeBytes)
 	return b.decorateBuffer(b1), b.decorateBuffer(b2)
@@ -134,8 +151,8 @@ func (b *bufferWithBackgroundTask) applyErrorHandler(errorHandler ErrorHandler)
 	return b.decorateBuffer(replacement), shouldRetry
 }
 
-func (b *bufferWithBackgroundTask) toUnvalidatedChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
-	return b.decorateChunkReader(b.base.toUnvalidatedChunkReader(off, maximumChunkSizeBytes))
+func (b *bufferWithBackgroundTask) toUnvalidatedChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return b.decorateChunkReader(b.base.toUnvalidatedChunkReader(off, chunkPolicy))
 }
 
 func (b *bufferWithBackgroundTask) toUnvalidatedReader(off int64) io.ReadCloser {
@@ -181,3 +198,17 @@ func (r *readerWithBackgroundTask) Close() error {
 	}
 	return r.task.err
 }
+
+type readSeekerWithBackgroundTask struct {
+	ReadSeekCloser
+	task *BackgroundTask
+}
+
+func (r *readSeekerWithBackgroundTask) Close() error {
+	err := r.ReadSeekCloser.Close()
+	<-r.task.completion
+	if err != nil {
+		return err
+	}
+	return r.task.err
+}
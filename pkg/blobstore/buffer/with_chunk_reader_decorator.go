@@ -0,0 +1,139 @@
+package buffer
+
+import (
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ChunkReaderDecorator is a function that wraps a ChunkReader. It is
+// used by WithChunkReaderDecorator(). The offset at which the
+// ChunkReader starts returning data within the buffer is provided,
+// so that decorators may distinguish reads of the full contents from
+// partial reads.
+type ChunkReaderDecorator func(r ChunkReader, off int64) ChunkReader
+
+type bufferWithChunkReaderDecorator struct {
+	base      Buffer
+	decorator ChunkReaderDecorator
+}
+
+// WithChunkReaderDecorator returns a decorated Buffer that causes all
+// data to be read through a ChunkReader that is wrapped by the
+// provided function. This may, for example, be used to throttle the
+// rate at which the buffer's contents are consumed, or to observe the
+// progress of transfers. Chunks are obtained from the decorator after
+// checksum validation has been performed.
+//
+// When the buffer is cloned, the decorator is applied to both copies
+// of the data individually.
+func WithChunkReaderDecorator(b Buffer, decorator ChunkReaderDecorator) Buffer {
+	return &bufferWithChunkReaderDecorator{
+		base:      b,
+		decorator: decorator,
+	}
+}
+
+func (b *bufferWithChunkReaderDecorator) decorateBuffer(replacement Buffer) Buffer {
+	return &bufferWithChunkReaderDecorator{
+		base:      replacement,
+		decorator: b.decorator,
+	}
+}
+
+func (b *bufferWithChunkReaderDecorator) GetSizeBytes() (int64, error) {
+	return b.base.GetSizeBytes()
+}
+
+func (b *bufferWithChunkReaderDecorator) Checksum() (digest.Digest, error) {
+	return b.base.Checksum()
+}
+
+func (b *bufferWithChunkReaderDecorator) IntoWriter(w io.Writer) error {
+	// The decorator may alter the data returned by the base buffer.
+	// Ensure that the decorated stream still has the expected size.
+	sizeBytes, err := b.base.GetSizeBytes()
+	if err != nil {
+		sizeBytes = -1
+	}
+	return intoWriterViaChunkReader(b.ToChunkReader(0, ChunkSizeAtMost(defaultChunkSizeBytes)), w, sizeBytes)
+}
+
+func (b *bufferWithChunkReaderDecorator) ReadAt(p []byte, off int64) (int, error) {
+	return readAtViaChunkReader(b.ToChunkReader(0, ChunkSizeAtMost(defaultChunkSizeBytes)), p, off)
+}
+
+func (b *bufferWithChunkReaderDecorator) ToProto(m proto.Message, maximumSizeBytes int) (proto.Message, error) {
+	return toProtoViaByteSlice(b, m, maximumSizeBytes)
+}
+
+func (b *bufferWithChunkReaderDecorator) ToByteSlice(maximumSizeBytes int) ([]byte, error) {
+	// Reject buffers whose size is known to be too large, without
+	// reading any data.
+	if sizeBytes, err := b.base.GetSizeBytes(); err == nil && sizeBytes > int64(maximumSizeBytes) {
+		b.base.Discard()
+		return nil, status.Errorf(codes.InvalidArgument, "Buffer is %d bytes in size, while a maximum of %d bytes is permitted", sizeBytes, maximumSizeBytes)
+	}
+
+	r := b.ToChunkReader(0, ChunkSizeAtMost(defaultChunkSizeBytes))
+	defer r.Close()
+
+	var data []byte
+	for {
+		chunk, err := r.Read()
+		if err == io.EOF {
+			return data, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(data)+len(chunk) > maximumSizeBytes {
+			return nil, status.Errorf(codes.InvalidArgument, "Buffer is at least %d bytes in size, while a maximum of %d bytes is permitted", len(data)+len(chunk), maximumSizeBytes)
+		}
+		data = append(data, chunk...)
+	}
+}
+
+func (b *bufferWithChunkReaderDecorator) ToChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return b.decorator(b.base.ToChunkReader(off, chunkPolicy), off)
+}
+
+func (b *bufferWithChunkReaderDecorator) ToReader() io.ReadCloser {
+	return newChunkReaderBackedReader(b.ToChunkReader(0, ChunkSizeAtMost(defaultChunkSizeBytes)))
+}
+
+func (b *bufferWithChunkReaderDecorator) ToSeekableReader() (ReadSeekCloser, error) {
+	// Seeking would permit the caller to bypass the decorator.
+	b.base.Discard()
+	return nil, ErrNotSeekable
+}
+
+func (b *bufferWithChunkReaderDecorator) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
+	b1, b2 := b.base.CloneCopy(maximumSizeBytes)
+	return b.decorateBuffer(b1), b.decorateBuffer(b2)
+}
+
+func (b *bufferWithChunkReaderDecorator) CloneStream() (Buffer, Buffer) {
+	b1, b2 := b.base.CloneStream()
+	return b.decorateBuffer(b1), b.decorateBuffer(b2)
+}
+
+func (b *bufferWithChunkReaderDecorator) Discard() {
+	b.base.Discard()
+}
+
+func (b *bufferWithChunkReaderDecorator) applyErrorHandler(errorHandler ErrorHandler) (Buffer, bool) {
+	replacement, shouldRetry := b.base.applyErrorHandler(errorHandler)
+	return b.decorateBuffer(replacement), shouldRetry
+}
+
+func (b *bufferWithChunkReaderDecorator) toUnvalidatedChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return b.decorator(b.base.toUnvalidatedChunkReader(off, chunkPolicy), off)
+}
+
+func (b *bufferWithChunkReaderDecorator) toUnvalidatedReader(off int64) io.ReadCloser {
+	return newChunkReaderBackedReader(b.toUnvalidatedChunkReader(off, ChunkSizeAtMost(defaultChunkSizeBytes)))
+}
@@ -0,0 +1,76 @@
+package buffer_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWithChunkReaderDecoratorIntoWriter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	t.Run("Success", func(t *testing.T) {
+		b := buffer.WithChunkReaderDecorator(
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+			func(r buffer.ChunkReader, off int64) buffer.ChunkReader { return r })
+		writer := bytes.NewBuffer(nil)
+		require.NoError(t, b.IntoWriter(writer))
+		require.Equal(t, []byte("Hello"), writer.Bytes())
+	})
+
+	t.Run("ShortStream", func(t *testing.T) {
+		// If the decorated ChunkReader reaches EOF before the
+		// expected amount of data is returned, IntoWriter()
+		// should not complete successfully.
+		chunkReader := mock.NewMockChunkReader(ctrl)
+		gomock.InOrder(
+			chunkReader.EXPECT().Read().Return([]byte("Hel"), nil),
+			chunkReader.EXPECT().Read().Return(nil, io.EOF))
+		chunkReader.EXPECT().Close()
+		b := buffer.WithChunkReaderDecorator(
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+			func(r buffer.ChunkReader, off int64) buffer.ChunkReader {
+				r.Close()
+				return chunkReader
+			})
+
+		writer := bytes.NewBuffer(nil)
+		require.Equal(
+			t,
+			buffer.MarkDataIntegrityError(status.Error(codes.DataLoss, "Buffer is 3 bytes in size, while 5 bytes were expected")),
+			b.IntoWriter(writer))
+		require.Equal(t, []byte("Hel"), writer.Bytes())
+	})
+
+	t.Run("LongStream", func(t *testing.T) {
+		// Data in excess of the expected size should cause
+		// IntoWriter() to fail, without writing the excess data.
+		chunkReader := mock.NewMockChunkReader(ctrl)
+		gomock.InOrder(
+			chunkReader.EXPECT().Read().Return([]byte("Hello"), nil),
+			chunkReader.EXPECT().Read().Return([]byte(" world"), nil))
+		chunkReader.EXPECT().Close()
+		b := buffer.WithChunkReaderDecorator(
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+			func(r buffer.ChunkReader, off int64) buffer.ChunkReader {
+				r.Close()
+				return chunkReader
+			})
+
+		writer := bytes.NewBuffer(nil)
+		require.Equal(
+			t,
+			buffer.MarkDataIntegrityError(status.Error(codes.DataLoss, "Buffer is at least 11 bytes in size, while 5 bytes were expected")),
+			b.IntoWriter(writer))
+		require.Equal(t, []byte("Hello"), writer.Bytes())
+	})
+}
@@ -0,0 +1,46 @@
+package buffer
+
+import (
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WithComputedDigest reads the contents of a buffer in their entirety,
+// computing their digest using a given digest function. It returns the
+// digest and a buffer containing the same contents, so that it may be
+// stored under the computed digest. Like WithKnownSize(), contents of
+// up to maximumMemorySizeBytes in size are kept in memory, while
+// larger contents are written to a spill file.
+//
+// If the size of the buffer is known and differs from the size of the
+// data that was read, INVALID_ARGUMENT is returned.
+func WithComputedDigest(b Buffer, instanceName digest.InstanceName, digestFunction remoteexecution.DigestFunction_Value, maximumMemorySizeBytes int, spillFileFactory SpillFileFactory) (digest.Digest, Buffer) {
+	declaredSizeBytes, err := b.GetSizeBytes()
+	sizeKnown := err == nil
+	if err != nil && err != ErrSizeUnknown {
+		b.Discard()
+		return digest.BadDigest, NewBufferFromError(err)
+	}
+
+	r := b.ToReader()
+	defer r.Close()
+	hashingReader, err := digest.NewHashingReader(r, instanceName, digestFunction)
+	if err != nil {
+		return digest.BadDigest, NewBufferFromError(err)
+	}
+	bComputed := newBufferFromSpilledReader(hashingReader, maximumMemorySizeBytes, spillFileFactory)
+	blobDigest, ok := hashingReader.GetDigest()
+	if !ok {
+		// Reading the data failed. The buffer that is
+		// returned contains the error.
+		return digest.BadDigest, bComputed
+	}
+	if sizeKnown && blobDigest.GetSizeBytes() != declaredSizeBytes {
+		bComputed.Discard()
+		return digest.BadDigest, NewBufferFromError(status.Errorf(codes.InvalidArgument, "Buffer is %d bytes in size, while %d bytes were declared", blobDigest.GetSizeBytes(), declaredSizeBytes))
+	}
+	return blobDigest, bComputed
+}
@@ -0,0 +1,101 @@
+package buffer_test
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWithComputedDigest(t *testing.T) {
+	instanceName := digest.MustNewInstanceName("hello")
+
+	t.Run("InMemory", func(t *testing.T) {
+		blobDigest, b := buffer.WithComputedDigest(
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+			instanceName,
+			remoteexecution.DigestFunction_MD5,
+			100,
+			nil)
+		require.Equal(t, digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5), blobDigest)
+		data, err := b.ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("SpillFile", func(t *testing.T) {
+		// Contents that don't fit in memory should be written
+		// to a spill file.
+		blobDigest, b := buffer.WithComputedDigest(
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+			instanceName,
+			remoteexecution.DigestFunction_MD5,
+			2,
+			buffer.NewTemporarySpillFile)
+		require.Equal(t, digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5), blobDigest)
+		data, err := b.ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("SpillFileReadAt", func(t *testing.T) {
+		// Only the part of the contents that doesn't fit in
+		// memory is written to the spill file. Reads spanning
+		// both parts should be stitched together.
+		blobDigest, b := buffer.WithComputedDigest(
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+			instanceName,
+			remoteexecution.DigestFunction_MD5,
+			2,
+			buffer.NewTemporarySpillFileFactory(os.TempDir()))
+		require.Equal(t, digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5), blobDigest)
+
+		var p [3]byte
+		b1, b := b.CloneCopy(10)
+		n, err := b1.ReadAt(p[:], 1)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+		require.Equal(t, []byte("ell"), p[:])
+
+		b2, b := b.CloneCopy(10)
+		n, err = b2.ReadAt(p[:], 3)
+		require.Equal(t, io.EOF, err)
+		require.Equal(t, 2, n)
+		require.Equal(t, []byte("lo"), p[:2])
+
+		data, err := b.ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("UnsupportedDigestFunction", func(t *testing.T) {
+		blobDigest, b := buffer.WithComputedDigest(
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+			instanceName,
+			remoteexecution.DigestFunction_UNKNOWN,
+			100,
+			nil)
+		require.Equal(t, digest.BadDigest, blobDigest)
+		_, err := b.GetSizeBytes()
+		require.Equal(t, status.Error(codes.InvalidArgument, "Unsupported digest function: UNKNOWN"), err)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		blobDigest, b := buffer.WithComputedDigest(
+			buffer.NewBufferFromError(status.Error(codes.Internal, "Disk on fire")),
+			instanceName,
+			remoteexecution.DigestFunction_MD5,
+			100,
+			nil)
+		require.Equal(t, digest.BadDigest, blobDigest)
+		_, err := b.GetSizeBytes()
+		require.Equal(t, status.Error(codes.Internal, "Disk on fire"), err)
+	})
+}
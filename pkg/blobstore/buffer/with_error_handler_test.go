@@ -49,7 +49,7 @@ func TestWithErrorHandlerOnProtoBuffers(t *testing.T) {
 				&remoteexecution.ActionResult{},
 				[]byte("Hello"),
 				buffer.UserProvided), nil)
-		errorHandler.EXPECT().OnError(status.Error(codes.InvalidArgument, "Failed to unmarshal message: proto: can't skip unknown wire type 4")).
+		errorHandler.EXPECT().OnError(buffer.MarkDataIntegrityError(status.Error(codes.InvalidArgument, "Failed to unmarshal message: proto: can't skip unknown wire type 4"))).
 			Return(nil, status.Error(codes.Internal, "Maximum number of retries reached"))
 		errorHandler.EXPECT().Done()
 
@@ -66,7 +66,7 @@ func TestWithErrorHandlerOnProtoBuffers(t *testing.T) {
 				&remoteexecution.ActionResult{},
 				[]byte("Hello"),
 				buffer.UserProvided), nil)
-		errorHandler.EXPECT().OnError(status.Error(codes.InvalidArgument, "Failed to unmarshal message: proto: can't skip unknown wire type 4")).
+		errorHandler.EXPECT().OnError(buffer.MarkDataIntegrityError(status.Error(codes.InvalidArgument, "Failed to unmarshal message: proto: can't skip unknown wire type 4"))).
 			Return(buffer.NewProtoBufferFromProto(&exampleActionResultMessage, buffer.UserProvided), nil)
 		errorHandler.EXPECT().Done()
 
@@ -103,7 +103,7 @@ func TestWithErrorHandlerOnSimpleCASBuffers(t *testing.T) {
 		errorHandler := mock.NewMockErrorHandler(ctrl)
 		errorHandler.EXPECT().OnError(status.Error(codes.Internal, "Network error")).
 			Return(buffer.NewCASBufferFromByteSlice(digest, []byte("Hello"), buffer.UserProvided), nil)
-		errorHandler.EXPECT().OnError(status.Error(codes.InvalidArgument, "Buffer is 5 bytes in size, while 11 bytes were expected")).
+		errorHandler.EXPECT().OnError(buffer.MarkDataIntegrityError(status.Error(codes.InvalidArgument, "Buffer is 5 bytes in size, while 11 bytes were expected"))).
 			Return(nil, status.Error(codes.Internal, "Maximum number of retries reached"))
 		errorHandler.EXPECT().Done()
 
@@ -117,7 +117,7 @@ func TestWithErrorHandlerOnSimpleCASBuffers(t *testing.T) {
 		errorHandler := mock.NewMockErrorHandler(ctrl)
 		errorHandler.EXPECT().OnError(status.Error(codes.Internal, "Network error")).
 			Return(buffer.NewCASBufferFromByteSlice(digest, []byte("Hello"), buffer.UserProvided), nil)
-		errorHandler.EXPECT().OnError(status.Error(codes.InvalidArgument, "Buffer is 5 bytes in size, while 11 bytes were expected")).
+		errorHandler.EXPECT().OnError(buffer.MarkDataIntegrityError(status.Error(codes.InvalidArgument, "Buffer is 5 bytes in size, while 11 bytes were expected"))).
 			Return(buffer.NewCASBufferFromByteSlice(digest, []byte("Hello world"), buffer.UserProvided), nil)
 		errorHandler.EXPECT().Done()
 
@@ -199,7 +199,7 @@ func TestWithErrorHandlerOnCASBuffersIntoWriter(t *testing.T) {
 		// stream.
 		writer := bytes.NewBuffer(nil)
 		err := buffer.WithErrorHandler(b1, errorHandler).IntoWriter(writer)
-		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum 3c61ab3f7343f99e0d18e0a7dfb3b0ce, while 3e25960a79dbc69b674cd4ec67a72c62 was expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum 3c61ab3f7343f99e0d18e0a7dfb3b0ce, while 3e25960a79dbc69b674cd4ec67a72c62 was expected")), err)
 		require.Equal(t, []byte("Xyzzy "), writer.Bytes())
 	})
 }
@@ -278,7 +278,7 @@ func TestWithErrorHandlerOnCASBuffersToProto(t *testing.T) {
 		b2 := buffer.NewCASBufferFromChunkReader(exampleActionResultDigest, reader2, buffer.UserProvided)
 
 		errorHandler := mock.NewMockErrorHandler(ctrl)
-		errorHandler.EXPECT().OnError(status.Error(codes.InvalidArgument, "Buffer is 5 bytes in size, while 134 bytes were expected")).Return(b2, nil)
+		errorHandler.EXPECT().OnError(buffer.MarkDataIntegrityError(status.Error(codes.InvalidArgument, "Buffer is 5 bytes in size, while 134 bytes were expected"))).Return(b2, nil)
 		errorHandler.EXPECT().Done()
 
 		// Operations like ToProto() may be safely retried, even
@@ -329,7 +329,7 @@ func TestWithErrorHandlerOnCASBuffersToChunkReader(t *testing.T) {
 
 		r := buffer.WithErrorHandler(b1, errorHandler).ToChunkReader(
 			/* offset = */ 2,
-			/* chunk size = */ 10)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(10))
 		chunk, err := r.Read()
 		require.NoError(t, err)
 		require.Equal(t, []byte("llo "), chunk)
@@ -358,7 +358,7 @@ func TestWithErrorHandlerOnCASBuffersToChunkReader(t *testing.T) {
 		// already written is not a problem.
 		r := buffer.WithErrorHandler(b1, errorHandler).ToChunkReader(
 			/* offset = */ 4,
-			/* chunk size = */ 3)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(3))
 		chunk, err := r.Read()
 		require.NoError(t, err)
 		require.Equal(t, []byte("o "), chunk)
@@ -397,12 +397,12 @@ func TestWithErrorHandlerOnCASBuffersToChunkReader(t *testing.T) {
 		// stream.
 		r := buffer.WithErrorHandler(b1, errorHandler).ToChunkReader(
 			/* offset = */ 0,
-			/* chunk size = */ 1000)
+			/* chunk policy = */ buffer.ChunkSizeAtMost(1000))
 		chunk, err := r.Read()
 		require.NoError(t, err)
 		require.Equal(t, []byte("Xyzzy "), chunk)
 		_, err = r.Read()
-		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum 3c61ab3f7343f99e0d18e0a7dfb3b0ce, while 3e25960a79dbc69b674cd4ec67a72c62 was expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum 3c61ab3f7343f99e0d18e0a7dfb3b0ce, while 3e25960a79dbc69b674cd4ec67a72c62 was expected")), err)
 		r.Close()
 	})
 }
@@ -478,7 +478,7 @@ func TestWithErrorHandlerOnCASBuffersToReader(t *testing.T) {
 		// stream.
 		r := buffer.WithErrorHandler(b1, errorHandler).ToReader()
 		data, err := ioutil.ReadAll(r)
-		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum 3c61ab3f7343f99e0d18e0a7dfb3b0ce, while 3e25960a79dbc69b674cd4ec67a72c62 was expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum 3c61ab3f7343f99e0d18e0a7dfb3b0ce, while 3e25960a79dbc69b674cd4ec67a72c62 was expected")), err)
 		require.Equal(t, []byte("Xyzzy "), data)
 		require.NoError(t, r.Close())
 	})
@@ -0,0 +1,80 @@
+package buffer
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+)
+
+// SpillFileFactory is called by WithKnownSize() to obtain a file to
+// which the contents of a buffer are written, in case they are too
+// large to be held in memory. The file is closed once the buffer
+// returned by WithKnownSize() is released. It is the responsibility
+// of the SpillFileFactory to ensure that the file is removed after
+// being closed.
+type SpillFileFactory func() (filesystem.FileReadWriter, error)
+
+// NewTemporarySpillFile is an implementation of SpillFileFactory that
+// creates spill files in the system's temporary directory. Files are
+// unlinked immediately after creation, so that their storage space is
+// reclaimed automatically when closed.
+func NewTemporarySpillFile() (filesystem.FileReadWriter, error) {
+	return newTemporarySpillFileInDirectory("")
+}
+
+// NewTemporarySpillFileFactory returns an implementation of
+// SpillFileFactory that is similar to NewTemporarySpillFile, except
+// that spill files are created in a provided directory. This may be
+// used to place spill files on a file system that is large enough to
+// hold them.
+func NewTemporarySpillFileFactory(directory string) SpillFileFactory {
+	return func() (filesystem.FileReadWriter, error) {
+		return newTemporarySpillFileInDirectory(directory)
+	}
+}
+
+func newTemporarySpillFileInDirectory(directory string) (filesystem.FileReadWriter, error) {
+	f, err := ioutil.TempFile(directory, "bb_storage_spill")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(f.Name()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// WithKnownSize returns a buffer for which GetSizeBytes() is
+// guaranteed not to return ErrSizeUnknown. Buffers whose size is known
+// are returned as is. For other buffers, the contents are read in
+// their entirety to determine the size. The first
+// maximumMemorySizeBytes bytes of the contents are kept in memory,
+// while the remainder is written to a spill file.
+//
+// The buffer returned by this function may be consumed in any way,
+// including IntoWriter(). This makes it possible for storage backends
+// that need to know the size of a blob before storing it (e.g., to
+// allocate space) to operate on buffers of unknown size.
+func WithKnownSize(b Buffer, maximumMemorySizeBytes int, spillFileFactory SpillFileFactory) Buffer {
+	if _, err := b.GetSizeBytes(); err != ErrSizeUnknown {
+		return b
+	}
+
+	r := b.ToReader()
+	defer r.Close()
+	return newBufferFromSpilledReader(r, maximumMemorySizeBytes, spillFileFactory)
+}
+
+// newBufferFromSpilledReader reads all data from a reader into a
+// SpillingBuffer, returning a buffer that contains the same data.
+func newBufferFromSpilledReader(r io.Reader, maximumMemorySizeBytes int, spillFileFactory SpillFileFactory) Buffer {
+	sb := newSpillingBuffer(maximumMemorySizeBytes, spillFileFactory)
+	if _, err := io.Copy(sb, r); err != nil {
+		sb.Discard()
+		return NewBufferFromError(err)
+	}
+	return sb.Build()
+}
@@ -0,0 +1,113 @@
+package buffer_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWithKnownSize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	t.Run("KnownSize", func(t *testing.T) {
+		// Buffers whose size is known should be returned as is,
+		// without creating any spill files.
+		b := buffer.WithKnownSize(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")), 0, nil)
+		n, err := b.GetSizeBytes()
+		require.NoError(t, err)
+		require.Equal(t, int64(5), n)
+		data, err := b.ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("UnknownSizeInMemory", func(t *testing.T) {
+		// Buffers of unknown size that are small enough should
+		// be held in memory, without creating spill files.
+		b := buffer.WithKnownSize(
+			buffer.NewValidatedBufferFromReader(ioutil.NopCloser(strings.NewReader("Hello")), -1),
+			5,
+			func() (filesystem.FileReadWriter, error) {
+				t.Fatal("Spill file should not be created")
+				return nil, nil
+			})
+		n, err := b.GetSizeBytes()
+		require.NoError(t, err)
+		require.Equal(t, int64(5), n)
+		data, err := b.ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("UnknownSizeSpilled", func(t *testing.T) {
+		// Data in excess of the memory limit should be written
+		// to a spill file. The resulting buffer should be
+		// readable in its entirety, and permit random access
+		// across the boundary between memory and the spill file.
+		spillFiles := 0
+		newSpillFile := func() (filesystem.FileReadWriter, error) {
+			spillFiles++
+			return buffer.NewTemporarySpillFile()
+		}
+
+		b1, b2 := buffer.WithKnownSize(
+			buffer.NewValidatedBufferFromReader(ioutil.NopCloser(strings.NewReader("Hello world")), -1),
+			4,
+			newSpillFile).CloneCopy(100)
+		require.Equal(t, 1, spillFiles)
+		n, err := b1.GetSizeBytes()
+		require.NoError(t, err)
+		require.Equal(t, int64(11), n)
+
+		writer := bytes.NewBuffer(nil)
+		require.NoError(t, b1.IntoWriter(writer))
+		require.Equal(t, []byte("Hello world"), writer.Bytes())
+
+		var p [5]byte
+		n2, err := b2.ReadAt(p[:], 2)
+		require.NoError(t, err)
+		require.Equal(t, 5, n2)
+		require.Equal(t, []byte("llo w"), p[:])
+	})
+
+	t.Run("UnknownSizeReadFailure", func(t *testing.T) {
+		reader := mock.NewMockReadCloser(ctrl)
+		reader.EXPECT().Read(gomock.Any()).Return(0, status.Error(codes.Unavailable, "Connection lost"))
+		reader.EXPECT().Close()
+
+		b := buffer.WithKnownSize(buffer.NewValidatedBufferFromReader(reader, -1), 100, buffer.NewTemporarySpillFile)
+		_, err := b.GetSizeBytes()
+		require.Equal(t, status.Error(codes.Unavailable, "Connection lost"), err)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		b := buffer.WithKnownSize(buffer.NewBufferFromError(status.Error(codes.Internal, "Disk on fire")), 0, nil)
+		_, err := b.GetSizeBytes()
+		require.Equal(t, status.Error(codes.Internal, "Disk on fire"), err)
+	})
+}
+
+func TestNewTemporarySpillFile(t *testing.T) {
+	f, err := buffer.NewTemporarySpillFile()
+	require.NoError(t, err)
+
+	n, err := f.WriteAt([]byte("Hello"), 0)
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+
+	// Spill files can be wrapped in a buffer directly, which takes
+	// ownership of the file.
+	data, err := buffer.NewValidatedBufferFromFileReader(f, 5).ToByteSlice(10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+}
@@ -0,0 +1,211 @@
+package buffer
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MemoryLimiter keeps track of the amount of memory that is allocated
+// by buffers that are in flight. A single instance may be shared by
+// many buffers, so that a hard ceiling is placed on the total amount of
+// memory used, regardless of any limits on individual requests.
+type MemoryLimiter struct {
+	maximumSizeBytes int64
+	blocking         bool
+
+	lock          sync.Mutex
+	usedSizeBytes int64
+	released      chan struct{}
+}
+
+// NewMemoryLimiter creates a MemoryLimiter that permits buffers to
+// allocate up to a given number of bytes in total. If blocking is set,
+// allocations that exceed the limit wait until sufficient memory is
+// released. Otherwise, they fail with RESOURCE_EXHAUSTED immediately.
+func NewMemoryLimiter(maximumSizeBytes int64, blocking bool) *MemoryLimiter {
+	return &MemoryLimiter{
+		maximumSizeBytes: maximumSizeBytes,
+		blocking:         blocking,
+	}
+}
+
+func (l *MemoryLimiter) acquire(ctx context.Context, sizeBytes int64) error {
+	if sizeBytes > l.maximumSizeBytes {
+		return status.Errorf(codes.ResourceExhausted, "Buffer requires %d bytes of memory, which exceeds the total limit of %d bytes", sizeBytes, l.maximumSizeBytes)
+	}
+
+	l.lock.Lock()
+	for l.usedSizeBytes+sizeBytes > l.maximumSizeBytes {
+		if !l.blocking {
+			l.lock.Unlock()
+			return status.Errorf(codes.ResourceExhausted, "Buffer requires %d bytes of memory, while only %d bytes are available", sizeBytes, l.maximumSizeBytes-l.usedSizeBytes)
+		}
+
+		// Wait for other buffers to release memory.
+		if l.released == nil {
+			l.released = make(chan struct{})
+		}
+		released := l.released
+		l.lock.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return util.StatusFromContext(ctx)
+		}
+		l.lock.Lock()
+	}
+	l.usedSizeBytes += sizeBytes
+	l.lock.Unlock()
+	return nil
+}
+
+func (l *MemoryLimiter) release(sizeBytes int64) {
+	l.lock.Lock()
+	l.usedSizeBytes -= sizeBytes
+	if l.released != nil {
+		close(l.released)
+		l.released = nil
+	}
+	l.lock.Unlock()
+}
+
+type bufferWithMemoryLimiter struct {
+	base    Buffer
+	ctx     context.Context
+	limiter *MemoryLimiter
+}
+
+// WithMemoryLimiter returns a decorated Buffer that reserves memory in
+// a MemoryLimiter for the operations that load the buffer's contents
+// into memory: ToByteSlice(), ToProto() and CloneCopy().
+//
+// The amount of memory reserved is the size of the buffer, capped to
+// the maximum size provided to these operations. For buffers whose
+// size is unknown (i.e., ones backed by a stream), the maximum size is
+// reserved, as that bounds the amount of memory allocated. Memory
+// reserved by ToByteSlice() and ToProto() is released when these
+// calls return. Memory reserved by CloneCopy() is released once both
+// clones have been consumed or discarded.
+//
+// Operations that stream the buffer's contents don't allocate memory
+// proportional to the size of the buffer. They don't reserve any
+// memory, meaning that buffers that exceed the limit may still be
+// transferred. Reservations are made in the context of ctx, meaning
+// that blocking reservations can be cancelled by cancelling it.
+func WithMemoryLimiter(ctx context.Context, b Buffer, limiter *MemoryLimiter) Buffer {
+	return &bufferWithMemoryLimiter{
+		base:    b,
+		ctx:     ctx,
+		limiter: limiter,
+	}
+}
+
+func (b *bufferWithMemoryLimiter) decorateBuffer(replacement Buffer) Buffer {
+	return WithMemoryLimiter(b.ctx, replacement, b.limiter)
+}
+
+// acquire memory for loading the contents of the buffer into memory.
+// If the size of the buffer cannot be determined due to it being in an
+// error state, no memory is reserved, as the operation will fail
+// without allocating any.
+func (b *bufferWithMemoryLimiter) acquire(maximumSizeBytes int) (int64, error) {
+	sizeBytes, err := b.base.GetSizeBytes()
+	if err == ErrSizeUnknown || (err == nil && sizeBytes > int64(maximumSizeBytes)) {
+		sizeBytes = int64(maximumSizeBytes)
+	} else if err != nil {
+		return 0, nil
+	}
+	if err := b.limiter.acquire(b.ctx, sizeBytes); err != nil {
+		b.base.Discard()
+		return 0, err
+	}
+	return sizeBytes, nil
+}
+
+func (b *bufferWithMemoryLimiter) GetSizeBytes() (int64, error) {
+	return b.base.GetSizeBytes()
+}
+
+func (b *bufferWithMemoryLimiter) Checksum() (digest.Digest, error) {
+	return b.base.Checksum()
+}
+
+func (b *bufferWithMemoryLimiter) IntoWriter(w io.Writer) error {
+	return b.base.IntoWriter(w)
+}
+
+func (b *bufferWithMemoryLimiter) ReadAt(p []byte, off int64) (int, error) {
+	return b.base.ReadAt(p, off)
+}
+
+func (b *bufferWithMemoryLimiter) ToProto(m proto.Message, maximumSizeBytes int) (proto.Message, error) {
+	sizeBytes, err := b.acquire(maximumSizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer b.limiter.release(sizeBytes)
+	return b.base.ToProto(m, maximumSizeBytes)
+}
+
+func (b *bufferWithMemoryLimiter) ToByteSlice(maximumSizeBytes int) ([]byte, error) {
+	sizeBytes, err := b.acquire(maximumSizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer b.limiter.release(sizeBytes)
+	return b.base.ToByteSlice(maximumSizeBytes)
+}
+
+func (b *bufferWithMemoryLimiter) ToChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return b.base.ToChunkReader(off, chunkPolicy)
+}
+
+func (b *bufferWithMemoryLimiter) ToReader() io.ReadCloser {
+	return b.base.ToReader()
+}
+
+func (b *bufferWithMemoryLimiter) ToSeekableReader() (ReadSeekCloser, error) {
+	return b.base.ToSeekableReader()
+}
+
+func (b *bufferWithMemoryLimiter) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
+	sizeBytes, err := b.acquire(maximumSizeBytes)
+	if err != nil {
+		return NewBufferFromError(err), NewBufferFromError(err)
+	}
+	// Both clones share the memory that is reserved. Only release
+	// it once both of them are done. As their contents are already
+	// held in memory, they don't need to reserve any memory on
+	// their own.
+	return WithReleaseFunc(b.base, func() { b.limiter.release(sizeBytes) }).CloneCopy(maximumSizeBytes)
+}
+
+func (b *bufferWithMemoryLimiter) CloneStream() (Buffer, Buffer) {
+	b1, b2 := b.base.CloneStream()
+	return b.decorateBuffer(b1), b.decorateBuffer(b2)
+}
+
+func (b *bufferWithMemoryLimiter) Discard() {
+	b.base.Discard()
+}
+
+func (b *bufferWithMemoryLimiter) applyErrorHandler(errorHandler ErrorHandler) (Buffer, bool) {
+	replacement, shouldRetry := b.base.applyErrorHandler(errorHandler)
+	return b.decorateBuffer(replacement), shouldRetry
+}
+
+func (b *bufferWithMemoryLimiter) toUnvalidatedChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return b.base.toUnvalidatedChunkReader(off, chunkPolicy)
+}
+
+func (b *bufferWithMemoryLimiter) toUnvalidatedReader(off int64) io.ReadCloser {
+	return b.base.toUnvalidatedReader(off)
+}
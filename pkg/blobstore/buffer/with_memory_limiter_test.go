@@ -0,0 +1,112 @@
+package buffer_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWithMemoryLimiterToByteSlice(t *testing.T) {
+	ctx := context.Background()
+	limiter := buffer.NewMemoryLimiter(10, false)
+
+	t.Run("KnownSize", func(t *testing.T) {
+		// Memory should be reserved according to the size of
+		// the buffer, and released once the call completes.
+		for i := 0; i < 3; i++ {
+			data, err := buffer.WithMemoryLimiter(ctx, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")), limiter).ToByteSlice(10)
+			require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is 11 bytes in size, while a maximum of 10 bytes is permitted"), err)
+			require.Nil(t, data)
+
+			data, err = buffer.WithMemoryLimiter(ctx, buffer.NewValidatedBufferFromByteSlice([]byte("Hello")), limiter).ToByteSlice(10)
+			require.NoError(t, err)
+			require.Equal(t, []byte("Hello"), data)
+		}
+	})
+
+	t.Run("UnknownSize", func(t *testing.T) {
+		// For buffers of unknown size, the maximum size should
+		// be reserved.
+		_, err := buffer.WithMemoryLimiter(
+			ctx,
+			buffer.NewValidatedBufferFromReader(ioutil.NopCloser(bytes.NewBufferString("Hello")), -1),
+			limiter).ToByteSlice(20)
+		require.Equal(t, status.Error(codes.ResourceExhausted, "Buffer requires 20 bytes of memory, which exceeds the total limit of 10 bytes"), err)
+
+		data, err := buffer.WithMemoryLimiter(
+			ctx,
+			buffer.NewValidatedBufferFromReader(ioutil.NopCloser(bytes.NewBufferString("Hello")), -1),
+			limiter).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+}
+
+func TestWithMemoryLimiterCloneCopy(t *testing.T) {
+	ctx := context.Background()
+	limiter := buffer.NewMemoryLimiter(10, false)
+
+	// Memory reserved by CloneCopy() should only be released once
+	// both clones are done.
+	b1, b2 := buffer.WithMemoryLimiter(ctx, buffer.NewValidatedBufferFromByteSlice([]byte("Hello")), limiter).CloneCopy(10)
+
+	_, err := buffer.WithMemoryLimiter(ctx, buffer.NewValidatedBufferFromByteSlice([]byte("Hello!")), limiter).ToByteSlice(10)
+	require.Equal(t, status.Error(codes.ResourceExhausted, "Buffer requires 6 bytes of memory, while only 5 bytes are available"), err)
+
+	data, err := b1.ToByteSlice(10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+
+	_, err = buffer.WithMemoryLimiter(ctx, buffer.NewValidatedBufferFromByteSlice([]byte("Hello!")), limiter).ToByteSlice(10)
+	require.Equal(t, status.Error(codes.ResourceExhausted, "Buffer requires 6 bytes of memory, while only 5 bytes are available"), err)
+
+	b2.Discard()
+
+	data, err = buffer.WithMemoryLimiter(ctx, buffer.NewValidatedBufferFromByteSlice([]byte("Hello!")), limiter).ToByteSlice(10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello!"), data)
+}
+
+func TestWithMemoryLimiterBlocking(t *testing.T) {
+	ctx := context.Background()
+	limiter := buffer.NewMemoryLimiter(10, true)
+
+	b1, b2 := buffer.WithMemoryLimiter(ctx, buffer.NewValidatedBufferFromByteSlice([]byte("Hello")), limiter).CloneCopy(10)
+
+	// Reservations that don't fit should block until their context
+	// is cancelled.
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := buffer.WithMemoryLimiter(canceledCtx, buffer.NewValidatedBufferFromByteSlice([]byte("Hello!")), limiter).ToByteSlice(10)
+	require.Equal(t, status.Error(codes.Canceled, "context canceled"), err)
+
+	// Releasing memory should unblock pending reservations.
+	done := make(chan struct{})
+	go func() {
+		data, err := buffer.WithMemoryLimiter(ctx, buffer.NewValidatedBufferFromByteSlice([]byte("Hello!")), limiter).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello!"), data)
+		close(done)
+	}()
+	b1.Discard()
+	b2.Discard()
+	<-done
+}
+
+func TestWithMemoryLimiterIntoWriter(t *testing.T) {
+	// Streaming buffers doesn't allocate memory proportional to
+	// their size. This should be permitted, even if the buffer
+	// exceeds the limit.
+	limiter := buffer.NewMemoryLimiter(5, false)
+
+	var out bytes.Buffer
+	require.NoError(t, buffer.WithMemoryLimiter(context.Background(), buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")), limiter).IntoWriter(&out))
+	require.Equal(t, []byte("Hello world"), out.Bytes())
+}
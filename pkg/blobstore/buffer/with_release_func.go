@@ -0,0 +1,197 @@
+package buffer
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/protobuf/proto"
+)
+
+// releaseFunc is a reference counted handle to the function that was
+// provided to WithReleaseFunc(). Every buffer obtained by cloning holds
+// a reference, ensuring that the function is only called once all
+// clones have been consumed.
+type releaseFunc struct {
+	references int32
+	release    func()
+}
+
+func (f *releaseFunc) acquire() {
+	atomic.AddInt32(&f.references, 1)
+}
+
+func (f *releaseFunc) done() {
+	if atomic.AddInt32(&f.references, -1) == 0 {
+		f.release()
+	}
+}
+
+type bufferWithReleaseFunc struct {
+	base    Buffer
+	release *releaseFunc
+}
+
+// WithReleaseFunc returns a decorated Buffer that calls a function at
+// the end of its lifetime. This is the point at which its contents
+// have been consumed, the buffer has been discarded, or the reader
+// obtained from it has been closed. If the buffer is cloned, the
+// function is called once all clones have reached the end of their
+// lifetime.
+//
+// This function may be used by implementations of BlobAccess that need
+// to hold on to resources while the buffer is in use, such as slots
+// that limit concurrency. Unlike ErrorHandler.Done(), which may be
+// called as soon as data is known to be valid, the function is not
+// called before the underlying data source is released.
+func WithReleaseFunc(b Buffer, release func()) Buffer {
+	return &bufferWithReleaseFunc{
+		base: b,
+		release: &releaseFunc{
+			references: 1,
+			release:    release,
+		},
+	}
+}
+
+func (b *bufferWithReleaseFunc) decorateBuffer(replacement Buffer) Buffer {
+	return &bufferWithReleaseFunc{
+		base:    replacement,
+		release: b.release,
+	}
+}
+
+func (b *bufferWithReleaseFunc) decorateChunkReader(r ChunkReader) ChunkReader {
+	return &chunkReaderWithReleaseFunc{
+		ChunkReader: r,
+		release:     b.release,
+	}
+}
+
+func (b *bufferWithReleaseFunc) decorateReader(r io.ReadCloser) io.ReadCloser {
+	return &readerWithReleaseFunc{
+		ReadCloser: r,
+		release:    b.release,
+	}
+}
+
+func (b *bufferWithReleaseFunc) GetSizeBytes() (int64, error) {
+	return b.base.GetSizeBytes()
+}
+
+func (b *bufferWithReleaseFunc) Checksum() (digest.Digest, error) {
+	return b.base.Checksum()
+}
+
+func (b *bufferWithReleaseFunc) IntoWriter(w io.Writer) error {
+	defer b.release.done()
+	return b.base.IntoWriter(w)
+}
+
+func (b *bufferWithReleaseFunc) ReadAt(p []byte, off int64) (int, error) {
+	defer b.release.done()
+	return b.base.ReadAt(p, off)
+}
+
+func (b *bufferWithReleaseFunc) ToProto(m proto.Message, maximumSizeBytes int) (proto.Message, error) {
+	defer b.release.done()
+	return b.base.ToProto(m, maximumSizeBytes)
+}
+
+func (b *bufferWithReleaseFunc) ToByteSlice(maximumSizeBytes int) ([]byte, error) {
+	defer b.release.done()
+	return b.base.ToByteSlice(maximumSizeBytes)
+}
+
+func (b *bufferWithReleaseFunc) ToChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return b.decorateChunkReader(b.base.ToChunkReader(off, chunkPolicy))
+}
+
+func (b *bufferWithReleaseFunc) ToReader() io.ReadCloser {
+	return b.decorateReader(b.base.ToReader())
+}
+
+func (b *bufferWithReleaseFunc) ToSeekableReader() (ReadSeekCloser, error) {
+	r, err := b.base.ToSeekableReader()
+	if err != nil {
+		b.release.done()
+		return nil, err
+	}
+	return &readSeekerWithReleaseFunc{
+		ReadSeekCloser: r,
+		release:        b.release,
+	}, nil
+}
+
+func (b *bufferWithReleaseFunc) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
+	b1, b2 := b.base.CloneCopy(maximumSizeBytes)
+	b.release.acquire()
+	return b.decorateBuffer(b1), b.decorateBuffer(b2)
+}
+
+func (b *bufferWithReleaseFunc) CloneStream() (Buffer, Buffer) {
+	b1, b2 := b.base.CloneStream()
+	b.release.acquire()
+	return b.decorateBuffer(b1), b.decorateBuffer(b2)
+}
+
+func (b *bufferWithReleaseFunc) Discard() {
+	b.base.Discard()
+	b.release.done()
+}
+
+func (b *bufferWithReleaseFunc) applyErrorHandler(errorHandler ErrorHandler) (Buffer, bool) {
+	replacement, shouldRetry := b.base.applyErrorHandler(errorHandler)
+	return b.decorateBuffer(replacement), shouldRetry
+}
+
+func (b *bufferWithReleaseFunc) toUnvalidatedChunkReader(off int64, chunkPolicy ChunkPolicy) ChunkReader {
+	return b.decorateChunkReader(b.base.toUnvalidatedChunkReader(off, chunkPolicy))
+}
+
+func (b *bufferWithReleaseFunc) toUnvalidatedReader(off int64) io.ReadCloser {
+	return b.decorateReader(b.base.toUnvalidatedReader(off))
+}
+
+type chunkReaderWithReleaseFunc struct {
+	ChunkReader
+	release *releaseFunc
+}
+
+func (r *chunkReaderWithReleaseFunc) Close() {
+	if r.release != nil {
+		r.ChunkReader.Close()
+		r.release.done()
+		r.release = nil
+	}
+}
+
+type readerWithReleaseFunc struct {
+	io.ReadCloser
+	release *releaseFunc
+}
+
+func (r *readerWithReleaseFunc) Close() error {
+	if r.release == nil {
+		return nil
+	}
+	err := r.ReadCloser.Close()
+	r.release.done()
+	r.release = nil
+	return err
+}
+
+type readSeekerWithReleaseFunc struct {
+	ReadSeekCloser
+	release *releaseFunc
+}
+
+func (r *readSeekerWithReleaseFunc) Close() error {
+	if r.release == nil {
+		return nil
+	}
+	err := r.ReadSeekCloser.Close()
+	r.release.done()
+	r.release = nil
+	return err
+}
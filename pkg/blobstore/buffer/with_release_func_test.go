@@ -0,0 +1,89 @@
+package buffer_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithReleaseFuncToByteSlice(t *testing.T) {
+	released := 0
+	b := buffer.WithReleaseFunc(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")), func() { released++ })
+
+	data, err := b.ToByteSlice(10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+	require.Equal(t, 1, released)
+}
+
+func TestWithReleaseFuncToChunkReader(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// The function should only be called when the reader is
+	// closed, as opposed to when the data has been read, as the
+	// underlying file is still open at that point.
+	reader := mock.NewMockFileReader(ctrl)
+	reader.EXPECT().ReadAt(gomock.Any(), int64(0)).DoAndReturn(func(p []byte, off int64) (int, error) {
+		return copy(p, []byte("Hello")), nil
+	})
+	released := 0
+	b := buffer.WithReleaseFunc(buffer.NewValidatedBufferFromFileReader(reader, 5), func() { released++ })
+
+	r := b.ToChunkReader(0, buffer.ChunkSizeAtMost(10))
+	data, err := r.Read()
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+	require.Equal(t, 0, released)
+
+	reader.EXPECT().Close()
+	r.Close()
+	require.Equal(t, 1, released)
+}
+
+func TestWithReleaseFuncToReader(t *testing.T) {
+	released := 0
+	b := buffer.WithReleaseFunc(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")), func() { released++ })
+
+	r := b.ToReader()
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+	require.Equal(t, 0, released)
+
+	require.NoError(t, r.Close())
+	require.Equal(t, 1, released)
+}
+
+func TestWithReleaseFuncCloneStream(t *testing.T) {
+	// The function should only be called once both clones have
+	// been consumed.
+	released := 0
+	b1, b2 := buffer.WithReleaseFunc(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")), func() { released++ }).CloneStream()
+
+	done := make(chan struct{})
+	go func() {
+		data, err := b1.ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+		close(done)
+	}()
+	b2.Discard()
+	<-done
+	require.Equal(t, 1, released)
+}
+
+func TestWithReleaseFuncDiscard(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	reader := mock.NewMockFileReader(ctrl)
+	released := 0
+	b := buffer.WithReleaseFunc(buffer.NewValidatedBufferFromFileReader(reader, 5), func() { released++ })
+
+	reader.EXPECT().Close()
+	b.Discard()
+	require.Equal(t, 1, released)
+}
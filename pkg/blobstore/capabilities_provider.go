@@ -0,0 +1,82 @@
+package blobstore
+
+import (
+	"context"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/bazelbuild/remote-apis/build/bazel/semver"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// Capabilities describes limits and features of a storage backend.
+type Capabilities struct {
+	// DigestFunctions contains the digest functions that may be
+	// used to store blobs in the backend.
+	DigestFunctions []remoteexecution.DigestFunction_Value
+	// MaximumBatchSizeBytes contains the maximum total size of
+	// blobs that may be transferred as part of a single batch
+	// request. A value of zero indicates that no limit is imposed,
+	// other than the maximum gRPC message size.
+	MaximumBatchSizeBytes int64
+}
+
+// DefaultCapabilities are the capabilities of backends that do not
+// implement CapabilitiesProvider. Such backends support all digest
+// functions that are supported by this implementation, without
+// imposing any limits on the size of batches.
+var DefaultCapabilities = Capabilities{
+	DigestFunctions: digest.SupportedDigestFunctions,
+}
+
+// CapabilitiesProvider is an optional capability of BlobAccess
+// implementations that are able to report limits and features of the
+// storage backend. Decorators may use this information to determine
+// how requests should be forwarded to the backend, instead of relying
+// on hardcoded limits.
+type CapabilitiesProvider interface {
+	GetCapabilities(ctx context.Context, instanceName digest.InstanceName) (Capabilities, error)
+}
+
+// GetCapabilities obtains the capabilities of a storage backend. If
+// the BlobAccess implements CapabilitiesProvider, the request is
+// forwarded to GetCapabilities(). Otherwise, DefaultCapabilities is
+// returned.
+func GetCapabilities(ctx context.Context, blobAccess BlobAccess, instanceName digest.InstanceName) (Capabilities, error) {
+	if capabilitiesProvider, ok := blobAccess.(CapabilitiesProvider); ok {
+		return capabilitiesProvider.GetCapabilities(ctx, instanceName)
+	}
+	return DefaultCapabilities, nil
+}
+
+// GetServerCapabilities derives the capabilities that should be
+// announced to clients of a Content Addressable Storage server from
+// the capabilities of the storage backend. This ensures that the
+// announced capabilities match the limits enforced by the backend and
+// its decorators.
+//
+// The maximum batch size is capped to the maximum message size used by
+// the server, as that limit is enforced by the BatchReadBlobs() and
+// BatchUpdateBlobs() implementations.
+func GetServerCapabilities(ctx context.Context, contentAddressableStorage BlobAccess, instanceName digest.InstanceName, maximumMessageSizeBytes int64, actionCacheUpdateEnabled bool) (*remoteexecution.ServerCapabilities, error) {
+	capabilities, err := GetCapabilities(ctx, contentAddressableStorage, instanceName)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to obtain capabilities of the Content Addressable Storage")
+	}
+	maximumBatchSizeBytes := capabilities.MaximumBatchSizeBytes
+	if maximumBatchSizeBytes == 0 || maximumBatchSizeBytes > maximumMessageSizeBytes {
+		maximumBatchSizeBytes = maximumMessageSizeBytes
+	}
+	return &remoteexecution.ServerCapabilities{
+		CacheCapabilities: &remoteexecution.CacheCapabilities{
+			DigestFunction: capabilities.DigestFunctions,
+			ActionCacheUpdateCapabilities: &remoteexecution.ActionCacheUpdateCapabilities{
+				UpdateEnabled: actionCacheUpdateEnabled,
+			},
+			MaxBatchTotalSizeBytes:      maximumBatchSizeBytes,
+			SymlinkAbsolutePathStrategy: remoteexecution.SymlinkAbsolutePathStrategy_ALLOWED,
+		},
+		LowApiVersion:  &semver.SemVer{Major: 2},
+		HighApiVersion: &semver.SemVer{Major: 2},
+	}, nil
+}
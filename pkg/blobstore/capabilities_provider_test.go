@@ -0,0 +1,71 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/bazelbuild/remote-apis/build/bazel/semver"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetServerCapabilities(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	t.Run("DefaultCapabilities", func(t *testing.T) {
+		// Backends that don't report capabilities should cause
+		// the maximum message size to be announced as the
+		// maximum batch size.
+		serverCapabilities, err := blobstore.GetServerCapabilities(ctx, mock.NewMockBlobAccess(ctrl), digest.MustNewInstanceName("hello"), 4*1024*1024, true)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(&remoteexecution.ServerCapabilities{
+			CacheCapabilities: &remoteexecution.CacheCapabilities{
+				DigestFunction: digest.SupportedDigestFunctions,
+				ActionCacheUpdateCapabilities: &remoteexecution.ActionCacheUpdateCapabilities{
+					UpdateEnabled: true,
+				},
+				MaxBatchTotalSizeBytes:      4 * 1024 * 1024,
+				SymlinkAbsolutePathStrategy: remoteexecution.SymlinkAbsolutePathStrategy_ALLOWED,
+			},
+			LowApiVersion:  &semver.SemVer{Major: 2},
+			HighApiVersion: &semver.SemVer{Major: 2},
+		}, serverCapabilities))
+	})
+
+	t.Run("BackendLimits", func(t *testing.T) {
+		// Limits imposed by the backend should be reflected.
+		blobAccess := blobstore.NewDigestFunctionFilteringBlobAccess(
+			mock.NewMockBlobAccess(ctrl),
+			func(instanceName digest.InstanceName) []remoteexecution.DigestFunction_Value {
+				return []remoteexecution.DigestFunction_Value{remoteexecution.DigestFunction_SHA256}
+			})
+		serverCapabilities, err := blobstore.GetServerCapabilities(ctx, blobAccess, digest.MustNewInstanceName("hello"), 4*1024*1024, false)
+		require.NoError(t, err)
+		require.Equal(t, []remoteexecution.DigestFunction_Value{remoteexecution.DigestFunction_SHA256}, serverCapabilities.CacheCapabilities.DigestFunction)
+		require.False(t, serverCapabilities.CacheCapabilities.ActionCacheUpdateCapabilities.UpdateEnabled)
+	})
+
+	t.Run("BackendLimitsThroughAdapters", func(t *testing.T) {
+		// The adapters that are placed in front of every
+		// backend should not hide the limits of the backend.
+		blobAccess := blobstore.NewTracingBlobAccess(
+			blobstore.NewMetricsBlobAccess(
+				blobstore.NewDigestFunctionFilteringBlobAccess(
+					mock.NewMockBlobAccess(ctrl),
+					func(instanceName digest.InstanceName) []remoteexecution.DigestFunction_Value {
+						return []remoteexecution.DigestFunction_Value{remoteexecution.DigestFunction_SHA256}
+					}),
+				mock.NewMockClock(ctrl),
+				"capabilities_test"),
+			"capabilities_test",
+			mock.NewMockTracer(ctrl))
+		serverCapabilities, err := blobstore.GetServerCapabilities(ctx, blobAccess, digest.MustNewInstanceName("hello"), 4*1024*1024, false)
+		require.NoError(t, err)
+		require.Equal(t, []remoteexecution.DigestFunction_Value{remoteexecution.DigestFunction_SHA256}, serverCapabilities.CacheCapabilities.DigestFunction)
+	})
+}
@@ -0,0 +1,284 @@
+package chunking
+
+import (
+	"context"
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/chunking"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// chunkReadSizeBytes is the maximum size of the chunks of data that
+// are read from the backend storing chunks when reassembling a blob.
+const chunkReadSizeBytes = 64 * 1024
+
+type chunkingBlobAccess struct {
+	chunkBlobAccess          blobstore.BlobAccess
+	manifestBlobAccess       blobstore.BlobAccess
+	chunkSizeBytes           int64
+	maximumManifestSizeBytes int
+}
+
+// NewChunkingBlobAccess creates a decorator for BlobAccess that stores
+// blobs larger than chunkSizeBytes by decomposing them into chunks of
+// chunkSizeBytes in size. This permits storing blobs that are larger
+// than what the underlying storage backend supports (e.g., circular
+// storage backends having a small data file).
+//
+// Chunks are stored in chunkBlobAccess under their own digests, which
+// are computed using the same digest function as the original blob.
+// A Manifest message listing the digests of the chunks is stored in
+// chunkBlobAccess as well, under the digest of the serialized
+// manifest. A ManifestReference message pointing to the manifest is
+// stored in manifestBlobAccess under the digest of the original blob.
+// Blobs that are not larger than chunkSizeBytes are stored in
+// chunkBlobAccess directly.
+//
+// When reading blobs, the manifest and every chunk are validated
+// against their own digests by chunkBlobAccess, while the reassembled
+// blob is validated against the digest of the original blob.
+func NewChunkingBlobAccess(chunkBlobAccess blobstore.BlobAccess, manifestBlobAccess blobstore.BlobAccess, chunkSizeBytes int64, maximumManifestSizeBytes int) blobstore.BlobAccess {
+	return &chunkingBlobAccess{
+		chunkBlobAccess:          chunkBlobAccess,
+		manifestBlobAccess:       manifestBlobAccess,
+		chunkSizeBytes:           chunkSizeBytes,
+		maximumManifestSizeBytes: maximumManifestSizeBytes,
+	}
+}
+
+// getManifestDigest reads the manifest reference of a blob that has
+// been decomposed into chunks, returning the digest of its manifest.
+func (ba *chunkingBlobAccess) getManifestDigest(ctx context.Context, blobDigest digest.Digest) (digest.Digest, error) {
+	m, err := ba.manifestBlobAccess.Get(ctx, blobDigest).ToProto(&pb.ManifestReference{}, ba.maximumManifestSizeBytes)
+	if err != nil {
+		return digest.BadDigest, err
+	}
+	manifestDigest, err := blobDigest.GetInstanceName().NewDigestFromProto(m.(*pb.ManifestReference).ManifestDigest)
+	if err != nil {
+		return digest.BadDigest, util.StatusWrapWithCode(err, codes.Internal, "Manifest reference contains invalid digest")
+	}
+	if manifestDigest.GetDigestFunction() != blobDigest.GetDigestFunction() {
+		return digest.BadDigest, status.Errorf(codes.Internal, "Manifest uses digest function %s, while the blob uses %s", manifestDigest.GetDigestFunction(), blobDigest.GetDigestFunction())
+	}
+	return manifestDigest, nil
+}
+
+// getChunkDigests reads the manifest of a blob that has been
+// decomposed into chunks, returning the digests of its chunks.
+func (ba *chunkingBlobAccess) getChunkDigests(ctx context.Context, blobDigest digest.Digest, manifestDigest digest.Digest) ([]digest.Digest, error) {
+	m, err := ba.chunkBlobAccess.Get(ctx, manifestDigest).ToProto(&pb.Manifest{}, ba.maximumManifestSizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	manifest := m.(*pb.Manifest)
+
+	// Validate that the manifest describes a blob of the right
+	// size, so that reads of the chunks yield the correct amount
+	// of data.
+	chunkSizeBytes := manifest.ChunkSizeBytes
+	if chunkSizeBytes <= 0 {
+		return nil, status.Errorf(codes.Internal, "Manifest has invalid chunk size %d", chunkSizeBytes)
+	}
+	sizeBytes := blobDigest.GetSizeBytes()
+	if expectedChunks := (sizeBytes + chunkSizeBytes - 1) / chunkSizeBytes; int64(len(manifest.ChunkDigests)) != expectedChunks {
+		return nil, status.Errorf(codes.Internal, "Manifest contains %d chunks, while %d chunks were expected", len(manifest.ChunkDigests), expectedChunks)
+	}
+	instanceName := blobDigest.GetInstanceName()
+	digestFunction := blobDigest.GetDigestFunction()
+	chunkDigests := make([]digest.Digest, 0, len(manifest.ChunkDigests))
+	for i, chunkDigestProto := range manifest.ChunkDigests {
+		chunkDigest, err := instanceName.NewDigestFromProto(chunkDigestProto)
+		if err != nil {
+			return nil, util.StatusWrapfWithCode(err, codes.Internal, "Manifest contains invalid digest for chunk %d", i)
+		}
+		if chunkDigest.GetDigestFunction() != digestFunction {
+			return nil, status.Errorf(codes.Internal, "Chunk %d uses digest function %s, while the blob uses %s", i, chunkDigest.GetDigestFunction(), digestFunction)
+		}
+		expectedSizeBytes := chunkSizeBytes
+		if remainingBytes := sizeBytes - int64(i)*chunkSizeBytes; remainingBytes < expectedSizeBytes {
+			expectedSizeBytes = remainingBytes
+		}
+		if chunkDigest.GetSizeBytes() != expectedSizeBytes {
+			return nil, status.Errorf(codes.Internal, "Chunk %d is %d bytes in size, while %d bytes were expected", i, chunkDigest.GetSizeBytes(), expectedSizeBytes)
+		}
+		chunkDigests = append(chunkDigests, chunkDigest)
+	}
+	return chunkDigests, nil
+}
+
+func (ba *chunkingBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	if blobDigest.GetSizeBytes() <= ba.chunkSizeBytes {
+		return ba.chunkBlobAccess.Get(ctx, blobDigest)
+	}
+	manifestDigest, err := ba.getManifestDigest(ctx, blobDigest)
+	if err != nil {
+		return buffer.NewBufferFromError(util.StatusWrap(err, "Failed to read manifest reference"))
+	}
+	chunkDigests, err := ba.getChunkDigests(ctx, blobDigest, manifestDigest)
+	if err != nil {
+		return buffer.NewBufferFromError(util.StatusWrap(err, "Failed to read manifest"))
+	}
+	return buffer.NewCASBufferFromChunkReader(
+		blobDigest,
+		&concatenatingChunkReader{
+			ctx:          ctx,
+			blobAccess:   ba.chunkBlobAccess,
+			chunkDigests: chunkDigests,
+		},
+		buffer.BackendProvided(buffer.Irreparable(blobDigest)))
+}
+
+func (ba *chunkingBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	if blobDigest.GetSizeBytes() <= ba.chunkSizeBytes {
+		return ba.chunkBlobAccess.Put(ctx, blobDigest, b)
+	}
+
+	// Store the chunks of the blob. The manifest and the reference
+	// to it are only stored after all chunks have been stored and
+	// the blob has been validated in its entirety, so that
+	// incomplete or corrupted blobs never become visible.
+	r := b.ToChunkReader(0, buffer.ChunkSizeBetween(int(ba.chunkSizeBytes), int(ba.chunkSizeBytes)))
+	defer r.Close()
+	manifest := pb.Manifest{
+		ChunkSizeBytes: ba.chunkSizeBytes,
+	}
+	for {
+		chunk, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		generator := blobDigest.NewGenerator()
+		generator.Write(chunk)
+		chunkDigest := generator.Sum()
+		if err := ba.chunkBlobAccess.Put(ctx, chunkDigest, buffer.NewValidatedBufferFromByteSlice(chunk)); err != nil {
+			return util.StatusWrapf(err, "Failed to store chunk %d", len(manifest.ChunkDigests))
+		}
+		manifest.ChunkDigests = append(manifest.ChunkDigests, chunkDigest.GetProto())
+	}
+
+	data, err := proto.Marshal(&manifest)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal manifest")
+	}
+	generator := blobDigest.NewGenerator()
+	generator.Write(data)
+	manifestDigest := generator.Sum()
+	if err := ba.chunkBlobAccess.Put(ctx, manifestDigest, buffer.NewValidatedBufferFromByteSlice(data)); err != nil {
+		return util.StatusWrap(err, "Failed to store manifest")
+	}
+	if err := ba.manifestBlobAccess.Put(ctx, blobDigest, buffer.NewProtoBufferFromProto(&pb.ManifestReference{
+		ManifestDigest: manifestDigest.GetProto(),
+	}, buffer.UserProvided)); err != nil {
+		return util.StatusWrap(err, "Failed to store manifest reference")
+	}
+	return nil
+}
+
+func (ba *chunkingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Partition the digests by whether they are stored as chunks.
+	smallDigests := digest.NewSetBuilder()
+	largeDigests := digest.NewSetBuilder()
+	for _, blobDigest := range digests.Items() {
+		if blobDigest.GetSizeBytes() <= ba.chunkSizeBytes {
+			smallDigests.Add(blobDigest)
+		} else {
+			largeDigests.Add(blobDigest)
+		}
+	}
+
+	missingReferences, presentReferences, err := blobstore.FindMissingAndPresent(ctx, ba.manifestBlobAccess, largeDigests.Build())
+	if err != nil {
+		return digest.EmptySet, util.StatusWrap(err, "Failed to find missing manifest references")
+	}
+
+	// Blobs for which a manifest exists are only present if all
+	// of their chunks are present. Check the existence of the
+	// chunks and the small blobs using a single call.
+	missingLargeDigests := digest.NewSetBuilder()
+	allSmallDigests := smallDigests.Build()
+	chunkBlobDigests := digest.NewSetBuilder()
+	for _, blobDigest := range allSmallDigests.Items() {
+		chunkBlobDigests.Add(blobDigest)
+	}
+	blobsByChunk := map[digest.Digest][]digest.Digest{}
+	for _, blobDigest := range presentReferences.Items() {
+		manifestDigest, err := ba.getManifestDigest(ctx, blobDigest)
+		if status.Code(err) == codes.NotFound {
+			missingLargeDigests.Add(blobDigest)
+			continue
+		} else if err != nil {
+			return digest.EmptySet, util.StatusWrapf(err, "Failed to read manifest reference of blob %#v", blobDigest.String())
+		}
+		chunkDigests, err := ba.getChunkDigests(ctx, blobDigest, manifestDigest)
+		if status.Code(err) == codes.NotFound {
+			missingLargeDigests.Add(blobDigest)
+			continue
+		} else if err != nil {
+			return digest.EmptySet, util.StatusWrapf(err, "Failed to read manifest of blob %#v", blobDigest.String())
+		}
+		for _, chunkDigest := range chunkDigests {
+			chunkBlobDigests.Add(chunkDigest)
+			blobsByChunk[chunkDigest] = append(blobsByChunk[chunkDigest], blobDigest)
+		}
+	}
+	missingChunkBlobs, err := ba.chunkBlobAccess.FindMissing(ctx, chunkBlobDigests.Build())
+	if err != nil {
+		return digest.EmptySet, util.StatusWrap(err, "Failed to find missing blobs")
+	}
+	for _, chunkDigest := range missingChunkBlobs.Items() {
+		for _, blobDigest := range blobsByChunk[chunkDigest] {
+			missingLargeDigests.Add(blobDigest)
+		}
+	}
+	_, missingSmallDigests, _ := digest.GetDifferenceAndIntersection(allSmallDigests, missingChunkBlobs)
+
+	return digest.GetUnion([]digest.Set{
+		missingSmallDigests,
+		missingReferences,
+		missingLargeDigests.Build(),
+	}), nil
+}
+
+// concatenatingChunkReader is a ChunkReader that returns the contents
+// of the chunks of a blob in order, thereby reassembling the original
+// blob. Chunks are only read from storage when needed.
+type concatenatingChunkReader struct {
+	ctx          context.Context
+	blobAccess   blobstore.BlobAccess
+	chunkDigests []digest.Digest
+	current      buffer.ChunkReader
+}
+
+func (r *concatenatingChunkReader) Read() ([]byte, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunkDigests) == 0 {
+				return nil, io.EOF
+			}
+			r.current = r.blobAccess.Get(r.ctx, r.chunkDigests[0]).ToChunkReader(0, buffer.ChunkSizeAtMost(chunkReadSizeBytes))
+			r.chunkDigests = r.chunkDigests[1:]
+		}
+		chunk, err := r.current.Read()
+		if err != io.EOF {
+			return chunk, err
+		}
+		r.current.Close()
+		r.current = nil
+	}
+}
+
+func (r *concatenatingChunkReader) Close() {
+	if r.current != nil {
+		r.current.Close()
+		r.current = nil
+	}
+}
@@ -0,0 +1,219 @@
+package chunking_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/chunking"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/chunking"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestChunkingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	chunkBlobAccess := mock.NewMockBlobAccess(ctrl)
+	manifestBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := chunking.NewChunkingBlobAccess(chunkBlobAccess, manifestBlobAccess, 4, 1000)
+
+	smallDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 4)
+	largeDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	otherLargeDigest := digest.MustNewDigest("hello", "86fb269d190d2c85f6e0468ceca42a20", 12)
+	chunkDigests := []digest.Digest{
+		digest.MustNewDigest("hello", "1824e8e0307cbfdd1993511ab040075c", 4),
+		digest.MustNewDigest("hello", "e7c52a655c23270552b9bf9ea01b1483", 4),
+		digest.MustNewDigest("hello", "e90c8e1edb39b713d0675837a44d40d7", 3),
+	}
+	chunkContents := []string{"Hell", "o wo", "rld"}
+	manifest := &pb.Manifest{
+		ChunkSizeBytes: 4,
+		ChunkDigests: []*remoteexecution.Digest{
+			chunkDigests[0].GetProto(),
+			chunkDigests[1].GetProto(),
+			chunkDigests[2].GetProto(),
+		},
+	}
+	manifestData, err := proto.Marshal(manifest)
+	require.NoError(t, err)
+	generator := largeDigest.NewGenerator()
+	generator.Write(manifestData)
+	manifestDigest := generator.Sum()
+	manifestReference := &pb.ManifestReference{
+		ManifestDigest: manifestDigest.GetProto(),
+	}
+
+	t.Run("GetSmall", func(t *testing.T) {
+		// Small blobs should be read from the chunk backend
+		// directly.
+		chunkBlobAccess.EXPECT().Get(ctx, smallDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hell")))
+
+		data, err := blobAccess.Get(ctx, smallDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hell"), data)
+	})
+
+	t.Run("GetLargeManifestNotFound", func(t *testing.T) {
+		manifestBlobAccess.EXPECT().Get(ctx, largeDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		_, err := blobAccess.Get(ctx, largeDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Failed to read manifest reference: Object not found"), err)
+	})
+
+	t.Run("GetLargeManifestInvalid", func(t *testing.T) {
+		manifestBlobAccess.EXPECT().Get(ctx, largeDigest).Return(buffer.NewProtoBufferFromProto(manifestReference, buffer.UserProvided))
+		chunkBlobAccess.EXPECT().Get(ctx, manifestDigest).Return(buffer.NewProtoBufferFromProto(&pb.Manifest{
+			ChunkSizeBytes: 4,
+			ChunkDigests: []*remoteexecution.Digest{
+				chunkDigests[0].GetProto(),
+				chunkDigests[1].GetProto(),
+			},
+		}, buffer.UserProvided))
+
+		_, err := blobAccess.Get(ctx, largeDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Internal, "Failed to read manifest: Manifest contains 2 chunks, while 3 chunks were expected"), err)
+	})
+
+	t.Run("GetLargeSuccess", func(t *testing.T) {
+		// Large blobs should be reassembled from their chunks.
+		manifestBlobAccess.EXPECT().Get(ctx, largeDigest).Return(buffer.NewProtoBufferFromProto(manifestReference, buffer.UserProvided))
+		chunkBlobAccess.EXPECT().Get(ctx, manifestDigest).Return(buffer.NewProtoBufferFromProto(manifest, buffer.UserProvided))
+		for i, chunkDigest := range chunkDigests {
+			chunkBlobAccess.EXPECT().Get(ctx, chunkDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte(chunkContents[i])))
+		}
+
+		data, err := blobAccess.Get(ctx, largeDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("PutSmall", func(t *testing.T) {
+		chunkBlobAccess.EXPECT().Put(ctx, smallDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hell"), data)
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, smallDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hell"))))
+	})
+
+	t.Run("PutLargeSuccess", func(t *testing.T) {
+		for i, chunkDigest := range chunkDigests {
+			expectedData := []byte(chunkContents[i])
+			chunkBlobAccess.EXPECT().Put(ctx, chunkDigest, gomock.Any()).DoAndReturn(
+				func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+					data, err := b.ToByteSlice(100)
+					require.NoError(t, err)
+					require.Equal(t, expectedData, data)
+					return nil
+				})
+		}
+		// The manifest should be stored under its own digest,
+		// while the reference to it is stored under the digest
+		// of the original blob.
+		chunkBlobAccess.EXPECT().Put(ctx, manifestDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				m, err := b.ToProto(&pb.Manifest{}, 1000)
+				require.NoError(t, err)
+				require.True(t, proto.Equal(manifest, m))
+				return nil
+			})
+		manifestBlobAccess.EXPECT().Put(ctx, largeDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				m, err := b.ToProto(&pb.ManifestReference{}, 1000)
+				require.NoError(t, err)
+				require.True(t, proto.Equal(manifestReference, m))
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, largeDigest, buffer.NewCASBufferFromByteSlice(largeDigest, []byte("Hello world"), buffer.UserProvided)))
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		// The large blob should be reported as missing, as one
+		// of its chunks is missing. The existence of the small
+		// blob and the chunks should be checked using a single
+		// call.
+		manifestBlobAccess.EXPECT().FindMissing(ctx, largeDigest.ToSingletonSet()).Return(digest.EmptySet, nil)
+		manifestBlobAccess.EXPECT().Get(ctx, largeDigest).Return(buffer.NewProtoBufferFromProto(manifestReference, buffer.UserProvided))
+		chunkBlobAccess.EXPECT().Get(ctx, manifestDigest).Return(buffer.NewProtoBufferFromProto(manifest, buffer.UserProvided))
+		chunkBlobAccess.EXPECT().FindMissing(
+			ctx,
+			digest.NewSetBuilder().Add(smallDigest).Add(chunkDigests[0]).Add(chunkDigests[1]).Add(chunkDigests[2]).Build(),
+		).Return(chunkDigests[1].ToSingletonSet(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(smallDigest).Add(largeDigest).Build())
+		require.NoError(t, err)
+		require.Equal(t, largeDigest.ToSingletonSet(), missing)
+	})
+
+	t.Run("FindMissingManifestReferenceMissing", func(t *testing.T) {
+		// Blobs whose manifest reference is missing should be
+		// reported as missing, without attempting to read the
+		// manifest.
+		chunkBlobAccess.EXPECT().FindMissing(ctx, digest.EmptySet).Return(digest.EmptySet, nil)
+		manifestBlobAccess.EXPECT().FindMissing(ctx, largeDigest.ToSingletonSet()).Return(largeDigest.ToSingletonSet(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, largeDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, largeDigest.ToSingletonSet(), missing)
+	})
+
+	t.Run("FindMissingManifestMissing", func(t *testing.T) {
+		// Blobs whose manifest reference is present, but whose
+		// manifest is missing should be reported as missing.
+		manifestBlobAccess.EXPECT().FindMissing(ctx, largeDigest.ToSingletonSet()).Return(digest.EmptySet, nil)
+		manifestBlobAccess.EXPECT().Get(ctx, largeDigest).Return(buffer.NewProtoBufferFromProto(manifestReference, buffer.UserProvided))
+		chunkBlobAccess.EXPECT().Get(ctx, manifestDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		chunkBlobAccess.EXPECT().FindMissing(ctx, digest.EmptySet).Return(digest.EmptySet, nil)
+
+		missing, err := blobAccess.FindMissing(ctx, largeDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, largeDigest.ToSingletonSet(), missing)
+	})
+
+	t.Run("FindMissingPresentAndMissingManifestReferences", func(t *testing.T) {
+		// When some manifest references are present and others
+		// are missing, only the present ones should be read.
+		// Blobs whose manifest reference is missing should be
+		// reported as missing, regardless of their chunks.
+		manifestBlobAccess.EXPECT().FindMissing(
+			ctx,
+			digest.NewSetBuilder().Add(largeDigest).Add(otherLargeDigest).Build(),
+		).Return(otherLargeDigest.ToSingletonSet(), nil)
+		manifestBlobAccess.EXPECT().Get(ctx, largeDigest).Return(buffer.NewProtoBufferFromProto(manifestReference, buffer.UserProvided))
+		chunkBlobAccess.EXPECT().Get(ctx, manifestDigest).Return(buffer.NewProtoBufferFromProto(manifest, buffer.UserProvided))
+		chunkBlobAccess.EXPECT().FindMissing(
+			ctx,
+			digest.NewSetBuilder().Add(smallDigest).Add(chunkDigests[0]).Add(chunkDigests[1]).Add(chunkDigests[2]).Build(),
+		).Return(smallDigest.ToSingletonSet(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(smallDigest).Add(largeDigest).Add(otherLargeDigest).Build())
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(smallDigest).Add(otherLargeDigest).Build(), missing)
+	})
+
+	t.Run("PutLargeCorrupted", func(t *testing.T) {
+		// Chunks may already have been written, but the
+		// manifest should not be written if the blob doesn't
+		// match its digest.
+		chunkBlobAccess.EXPECT().Put(ctx, gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			}).AnyTimes()
+
+		err := blobAccess.Put(ctx, largeDigest, buffer.NewCASBufferFromByteSlice(largeDigest, []byte("Hello World"), buffer.UserProvided))
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
@@ -0,0 +1,40 @@
+package chunking
+
+import (
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/chunking"
+)
+
+type manifestReferenceReadBufferFactory struct{}
+
+func (f manifestReferenceReadBufferFactory) NewBufferFromByteSlice(digest digest.Digest, data []byte, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	return buffer.NewProtoBufferFromByteSlice(&pb.ManifestReference{}, data, buffer.BackendProvided(dataIntegrityCallback))
+}
+
+func (f manifestReferenceReadBufferFactory) NewBufferFromReader(digest digest.Digest, r io.ReadCloser, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	return buffer.NewProtoBufferFromReader(&pb.ManifestReference{}, r, buffer.BackendProvided(dataIntegrityCallback))
+}
+
+func (f manifestReferenceReadBufferFactory) NewBufferFromFileReader(digest digest.Digest, r filesystem.FileReader, sizeBytes int64, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	return f.NewBufferFromReader(
+		digest,
+		&struct {
+			io.SectionReader
+			io.Closer
+		}{
+			SectionReader: *io.NewSectionReader(r, 0, sizeBytes),
+			Closer:        r,
+		},
+		dataIntegrityCallback)
+}
+
+// ManifestReferenceReadBufferFactory is capable of creating buffers
+// for ManifestReference messages stored by ChunkingBlobAccess. It may
+// be provided to storage backends (e.g., LocalBlobAccess) that are used
+// to store manifest references.
+var ManifestReferenceReadBufferFactory blobstore.ReadBufferFactory = manifestReferenceReadBufferFactory{}
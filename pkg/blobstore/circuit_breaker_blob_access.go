@@ -0,0 +1,258 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	circuitBreakerBlobAccessPrometheusMetrics sync.Once
+
+	circuitBreakerBlobAccessState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "circuit_breaker_blob_access_state",
+			Help:      "State of the circuit breaker, where 0 means closed, 1 means open and 2 means half-open.",
+		},
+		[]string{"name", "operation"})
+)
+
+// CircuitBreakerThresholds contains the parameters that determine when
+// the circuit breaker created by NewCircuitBreakerBlobAccess() trips.
+type CircuitBreakerThresholds struct {
+	// Duration of the window over which the error ratio is
+	// computed.
+	Window time.Duration
+	// Minimum number of requests that need to be observed within
+	// the window before the circuit breaker may trip.
+	MinimumRequests int
+	// Ratio of requests failing with errors of class
+	// ErrorClassUnavailable or DEADLINE_EXCEEDED at which the
+	// circuit breaker trips.
+	ErrorRatio float64
+	// Amount of time the circuit breaker remains open, before a
+	// single request is permitted to probe whether the backend has
+	// recovered. If the outcome of a probe is not known within
+	// this amount of time, another probe is permitted.
+	Cooldown time.Duration
+}
+
+type circuitBreakerState int
+
+const (
+	circuitBreakerStateClosed circuitBreakerState = iota
+	circuitBreakerStateOpen
+	circuitBreakerStateHalfOpen
+)
+
+// circuitBreaker keeps track of the error ratio of a single type of
+// operation.
+type circuitBreaker struct {
+	clock      clock.Clock
+	thresholds *CircuitBreakerThresholds
+	operation  string
+	stateGauge prometheus.Gauge
+
+	lock          sync.Mutex
+	state         circuitBreakerState
+	windowStart   time.Time
+	requests      int
+	failures      int
+	openUntil     time.Time
+	probe         uint64
+	probeDeadline time.Time
+}
+
+func newCircuitBreaker(clock clock.Clock, thresholds *CircuitBreakerThresholds, name, operation string) *circuitBreaker {
+	cb := &circuitBreaker{
+		clock:      clock,
+		thresholds: thresholds,
+		operation:  operation,
+		stateGauge: circuitBreakerBlobAccessState.WithLabelValues(name, operation),
+	}
+	cb.stateGauge.Set(float64(circuitBreakerStateClosed))
+	return cb
+}
+
+func (cb *circuitBreaker) setState(state circuitBreakerState) {
+	cb.state = state
+	cb.stateGauge.Set(float64(state))
+}
+
+func (cb *circuitBreaker) resetWindow(now time.Time) {
+	cb.windowStart = now
+	cb.requests = 0
+	cb.failures = 0
+}
+
+// start is called before an operation is forwarded to the backend. If
+// the operation is the single request that is used to probe the
+// backend while the circuit breaker is half-open, it returns a
+// non-zero identifier of the probe.
+func (cb *circuitBreaker) start() (uint64, error) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.state == circuitBreakerStateClosed {
+		return 0, nil
+	}
+	now := cb.clock.Now()
+	if cb.state == circuitBreakerStateOpen {
+		if now.Before(cb.openUntil) {
+			return 0, status.Errorf(codes.Unavailable, "Circuit breaker for %s() is open, as the backend is failing", cb.operation)
+		}
+		cb.setState(circuitBreakerStateHalfOpen)
+	} else if now.Before(cb.probeDeadline) {
+		return 0, status.Errorf(codes.Unavailable, "Circuit breaker for %s() is half-open, and is already probing the backend", cb.operation)
+	}
+
+	// Start a new probe. The outcome of the previous probe, if
+	// any, is ignored from now on. This prevents the circuit
+	// breaker from getting stuck in case the outcome of a probe is
+	// never reported (e.g., when a buffer returned by Get() is
+	// never consumed).
+	cb.probe++
+	cb.probeDeadline = now.Add(cb.thresholds.Cooldown)
+	return cb.probe, nil
+}
+
+// finish is called once the result of an operation is known.
+func (cb *circuitBreaker) finish(probe uint64, err error) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	// Requests that time out are counted as failures as well, as a
+	// backend that is stalling is as problematic as one that is
+	// unreachable.
+	failed := ClassifyError(err) == ErrorClassUnavailable || status.Code(err) == codes.DeadlineExceeded
+	now := cb.clock.Now()
+	switch cb.state {
+	case circuitBreakerStateClosed:
+		if now.Sub(cb.windowStart) >= cb.thresholds.Window {
+			cb.resetWindow(now)
+		}
+		cb.requests++
+		if failed {
+			cb.failures++
+			if cb.requests >= cb.thresholds.MinimumRequests && float64(cb.failures) >= cb.thresholds.ErrorRatio*float64(cb.requests) {
+				cb.setState(circuitBreakerStateOpen)
+				cb.openUntil = now.Add(cb.thresholds.Cooldown)
+			}
+		}
+	case circuitBreakerStateHalfOpen:
+		// Only the outcome of the current probe determines
+		// whether the circuit breaker closes. Requests that
+		// were started before the circuit breaker opened and
+		// probes that were superseded are ignored.
+		if probe != cb.probe {
+			return
+		}
+		if failed {
+			cb.setState(circuitBreakerStateOpen)
+			cb.openUntil = now.Add(cb.thresholds.Cooldown)
+		} else {
+			cb.setState(circuitBreakerStateClosed)
+			cb.resetWindow(now)
+		}
+	}
+}
+
+type circuitBreakerBlobAccess struct {
+	BlobAccess
+	getBreaker         *circuitBreaker
+	putBreaker         *circuitBreaker
+	findMissingBreaker *circuitBreaker
+}
+
+// NewCircuitBreakerBlobAccess creates a decorator for BlobAccess that
+// stops forwarding requests to a backend that is failing. For every
+// type of operation, it tracks the ratio of requests failing with
+// errors of class ErrorClassUnavailable or DEADLINE_EXCEEDED. Other
+// errors, such as NOT_FOUND, are treated as successes.
+//
+// When the error ratio exceeds the configured threshold, the circuit
+// breaker opens, causing requests to fail with UNAVAILABLE immediately
+// for the duration of the cooldown. Afterwards, the circuit breaker
+// becomes half-open, in which a single request is forwarded. If it
+// succeeds, the circuit breaker closes. Otherwise, it opens again. For
+// Get(), the outcome of the probe is only known once the buffer has
+// been consumed or discarded. If this does not happen within the
+// cooldown, another request is forwarded to probe the backend.
+//
+// When combined with a decorator that retries failed requests, this
+// decorator should be placed below it. This causes retries to be
+// rejected immediately while the circuit breaker is open, instead of
+// them being sent to the backend.
+func NewCircuitBreakerBlobAccess(base BlobAccess, clock clock.Clock, thresholds CircuitBreakerThresholds, name string) BlobAccess {
+	circuitBreakerBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(circuitBreakerBlobAccessState)
+	})
+
+	return &circuitBreakerBlobAccess{
+		BlobAccess:         base,
+		getBreaker:         newCircuitBreaker(clock, &thresholds, name, "Get"),
+		putBreaker:         newCircuitBreaker(clock, &thresholds, name, "Put"),
+		findMissingBreaker: newCircuitBreaker(clock, &thresholds, name, "FindMissing"),
+	}
+}
+
+func (ba *circuitBreakerBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	probe, err := ba.getBreaker.start()
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	// The outcome of the request is only known once the buffer
+	// has been consumed.
+	return buffer.WithErrorHandler(
+		ba.BlobAccess.Get(ctx, digest),
+		&circuitBreakerErrorHandler{
+			breaker: ba.getBreaker,
+			probe:   probe,
+		})
+}
+
+func (ba *circuitBreakerBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	probe, err := ba.putBreaker.start()
+	if err != nil {
+		b.Discard()
+		return err
+	}
+	err = ba.BlobAccess.Put(ctx, digest, b)
+	ba.putBreaker.finish(probe, err)
+	return err
+}
+
+func (ba *circuitBreakerBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	probe, err := ba.findMissingBreaker.start()
+	if err != nil {
+		return digest.EmptySet, err
+	}
+	missing, err := ba.BlobAccess.FindMissing(ctx, digests)
+	ba.findMissingBreaker.finish(probe, err)
+	return missing, err
+}
+
+type circuitBreakerErrorHandler struct {
+	breaker *circuitBreaker
+	probe   uint64
+	err     error
+}
+
+func (eh *circuitBreakerErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	eh.err = err
+	return nil, err
+}
+
+func (eh *circuitBreakerErrorHandler) Done() {
+	eh.breaker.finish(eh.probe, eh.err)
+}
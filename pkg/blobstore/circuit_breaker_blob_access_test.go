@@ -0,0 +1,161 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCircuitBreakerBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewCircuitBreakerBlobAccess(
+		baseBlobAccess,
+		clock,
+		blobstore.CircuitBreakerThresholds{
+			Window:          time.Minute,
+			MinimumRequests: 2,
+			ErrorRatio:      0.5,
+			Cooldown:        10 * time.Second,
+		},
+		"test")
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digests := helloDigest.ToSingletonSet()
+
+	// NOT_FOUND errors should not count towards tripping the
+	// circuit breaker. Subsequent requests should still be
+	// forwarded.
+	for i := int64(0); i < 2; i++ {
+		baseBlobAccess.EXPECT().Get(ctx, helloDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
+		clock.EXPECT().Now().Return(time.Unix(1000+i, 0))
+		_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(10)
+		require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
+	}
+
+	// UNAVAILABLE errors should count towards tripping the
+	// circuit breaker.
+	baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, nil)
+	clock.EXPECT().Now().Return(time.Unix(1001, 0))
+	_, err := blobAccess.FindMissing(ctx, digests)
+	require.NoError(t, err)
+
+	baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, status.Error(codes.Unavailable, "Server not reachable"))
+	clock.EXPECT().Now().Return(time.Unix(1002, 0))
+	_, err = blobAccess.FindMissing(ctx, digests)
+	require.Equal(t, status.Error(codes.Unavailable, "Server not reachable"), err)
+
+	// The circuit breaker for FindMissing() should now be open,
+	// causing requests to be rejected without contacting the
+	// backend. Other operations should not be affected.
+	clock.EXPECT().Now().Return(time.Unix(1005, 0))
+	_, err = blobAccess.FindMissing(ctx, digests)
+	require.Equal(t, status.Error(codes.Unavailable, "Circuit breaker for FindMissing() is open, as the backend is failing"), err)
+
+	baseBlobAccess.EXPECT().Get(ctx, helloDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+	clock.EXPECT().Now().Return(time.Unix(1006, 0))
+	data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+
+	// After the cooldown, a single request should be let through
+	// to probe the backend. As it fails, the circuit breaker
+	// should open again.
+	clock.EXPECT().Now().Return(time.Unix(1012, 0))
+	baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, status.Error(codes.Unavailable, "Server not reachable"))
+	clock.EXPECT().Now().Return(time.Unix(1012, 0))
+	_, err = blobAccess.FindMissing(ctx, digests)
+	require.Equal(t, status.Error(codes.Unavailable, "Server not reachable"), err)
+
+	clock.EXPECT().Now().Return(time.Unix(1015, 0))
+	_, err = blobAccess.FindMissing(ctx, digests)
+	require.Equal(t, status.Error(codes.Unavailable, "Circuit breaker for FindMissing() is open, as the backend is failing"), err)
+
+	// Once a probe succeeds, the circuit breaker should close.
+	clock.EXPECT().Now().Return(time.Unix(1022, 0))
+	baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, nil)
+	clock.EXPECT().Now().Return(time.Unix(1022, 0))
+	_, err = blobAccess.FindMissing(ctx, digests)
+	require.NoError(t, err)
+
+	baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digests, nil)
+	clock.EXPECT().Now().Return(time.Unix(1023, 0))
+	missing, err := blobAccess.FindMissing(ctx, digests)
+	require.NoError(t, err)
+	require.Equal(t, digests, missing)
+}
+
+func TestCircuitBreakerBlobAccessStuckProbe(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewCircuitBreakerBlobAccess(
+		baseBlobAccess,
+		clock,
+		blobstore.CircuitBreakerThresholds{
+			Window:          time.Minute,
+			MinimumRequests: 1,
+			ErrorRatio:      0.5,
+			Cooldown:        10 * time.Second,
+		},
+		"test")
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	// DEADLINE_EXCEEDED errors should count towards tripping the
+	// circuit breaker, as stalling backends are failing as well.
+	baseBlobAccess.EXPECT().Get(ctx, helloDigest).Return(buffer.NewBufferFromError(status.Error(codes.DeadlineExceeded, "Request timed out")))
+	clock.EXPECT().Now().Return(time.Unix(1000, 0))
+	_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(10)
+	require.Equal(t, status.Error(codes.DeadlineExceeded, "Request timed out"), err)
+
+	clock.EXPECT().Now().Return(time.Unix(1005, 0))
+	_, err = blobAccess.Get(ctx, helloDigest).ToByteSlice(10)
+	require.Equal(t, status.Error(codes.Unavailable, "Circuit breaker for Get() is open, as the backend is failing"), err)
+
+	// After the cooldown, a single request should be let through
+	// to probe the backend. Its outcome is only known once the
+	// buffer is consumed, meaning other requests are rejected in
+	// the meantime.
+	clock.EXPECT().Now().Return(time.Unix(1011, 0))
+	baseBlobAccess.EXPECT().Get(ctx, helloDigest).Return(buffer.NewCASBufferFromReader(helloDigest, ioutil.NopCloser(bytes.NewBufferString("Hello")), buffer.UserProvided))
+	stuckBuffer := blobAccess.Get(ctx, helloDigest)
+
+	clock.EXPECT().Now().Return(time.Unix(1015, 0))
+	_, err = blobAccess.Get(ctx, helloDigest).ToByteSlice(10)
+	require.Equal(t, status.Error(codes.Unavailable, "Circuit breaker for Get() is half-open, and is already probing the backend"), err)
+
+	// If the outcome of the probe is not known within the
+	// cooldown, another probe should be permitted. If it succeeds,
+	// the circuit breaker should close.
+	clock.EXPECT().Now().Return(time.Unix(1022, 0))
+	baseBlobAccess.EXPECT().Get(ctx, helloDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+	clock.EXPECT().Now().Return(time.Unix(1022, 0))
+	data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+
+	// Discarding the buffer of the first probe should merely be
+	// counted as a regular request.
+	clock.EXPECT().Now().Return(time.Unix(1023, 0))
+	stuckBuffer.Discard()
+
+	baseBlobAccess.EXPECT().Get(ctx, helloDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+	clock.EXPECT().Now().Return(time.Unix(1024, 0))
+	data, err = blobAccess.Get(ctx, helloDigest).ToByteSlice(10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+}
@@ -0,0 +1,70 @@
+package circular
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+)
+
+// BlobMetadata is a small, fixed-size record that may be stored
+// alongside every blob in the offset store. It may be used for garbage
+// collection and auditing purposes.
+//
+// As the metadata is stored in the same offset store entry as the
+// location of the blob, it is invalidated together with the blob once
+// the cursors advance past the blob's data.
+type BlobMetadata struct {
+	// The time at which the blob was written, with a precision of
+	// seconds. The zero value is used if no time is known.
+	UploadTimestamp time.Time
+	// A truncated SHA-256 hash of the identity of the client that
+	// uploaded the blob. The identity itself is not stored, as it
+	// may be of arbitrary length.
+	UploaderHash [16]byte
+}
+
+// blobMetadataRecord is the on-disk format of BlobMetadata.
+type blobMetadataRecord [8 + 16]byte
+
+func newBlobMetadataRecord(metadata BlobMetadata) blobMetadataRecord {
+	var record blobMetadataRecord
+	if !metadata.UploadTimestamp.IsZero() {
+		binary.LittleEndian.PutUint64(record[:], uint64(metadata.UploadTimestamp.Unix()))
+	}
+	copy(record[8:], metadata.UploaderHash[:])
+	return record
+}
+
+func (mr *blobMetadataRecord) toBlobMetadata() BlobMetadata {
+	var metadata BlobMetadata
+	if seconds := int64(binary.LittleEndian.Uint64(mr[:])); seconds != 0 {
+		metadata.UploadTimestamp = time.Unix(seconds, 0)
+	}
+	copy(metadata.UploaderHash[:], mr[8:])
+	return metadata
+}
+
+// BlobMetadataFunc is called by the BlobAccess returned by
+// NewCircularBlobAccess() to obtain the metadata that should be stored
+// alongside a blob that is being written.
+type BlobMetadataFunc func(ctx context.Context) BlobMetadata
+
+// NewUploaderBlobMetadataFunc creates a BlobMetadataFunc that records
+// the current time and a hash of the identity of the client performing
+// the upload.
+func NewUploaderBlobMetadataFunc(clock clock.Clock) BlobMetadataFunc {
+	return func(ctx context.Context) BlobMetadata {
+		metadata := BlobMetadata{
+			UploadTimestamp: clock.Now(),
+		}
+		if principal := blobstore.GetPrincipal(ctx); principal != "" {
+			hash := sha256.Sum256([]byte(principal))
+			copy(metadata.UploaderHash[:], hash[:])
+		}
+		return metadata
+	}
+}
@@ -0,0 +1,229 @@
+package circular
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	bloomFilterOffsetStorePrometheusMetrics sync.Once
+
+	bloomFilterOffsetStoreLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore_circular",
+			Name:      "bloom_filter_offset_store_lookups_total",
+			Help:      "Number of digests looked up in the Bloom filter of the offset store, and whether the backend confirmed the result.",
+		},
+		[]string{"result"})
+	bloomFilterOffsetStoreLookupsDefinitelyMissing = bloomFilterOffsetStoreLookups.WithLabelValues("DefinitelyMissing")
+	bloomFilterOffsetStoreLookupsTruePositive      = bloomFilterOffsetStoreLookups.WithLabelValues("TruePositive")
+	bloomFilterOffsetStoreLookupsFalsePositive     = bloomFilterOffsetStoreLookups.WithLabelValues("FalsePositive")
+
+	bloomFilterOffsetStoreRotations = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore_circular",
+			Name:      "bloom_filter_offset_store_rotations_total",
+			Help:      "Number of times the Bloom filter of the offset store was cleared, due to the write cursor wrapping around the data file.",
+		})
+)
+
+// bloomFilter is a simple Bloom filter for simpleDigests. The bit
+// positions of an element are derived from the hash contained in the
+// digest using double hashing, as the hash is already uniformly
+// distributed.
+type bloomFilter struct {
+	bits          []uint64
+	hashFunctions int
+}
+
+func newBloomFilter(sizeBytes uint64, hashFunctions int) bloomFilter {
+	return bloomFilter{
+		bits:          make([]uint64, (sizeBytes+7)/8),
+		hashFunctions: hashFunctions,
+	}
+}
+
+func (bf *bloomFilter) getPositions(digest simpleDigest, callback func(word int, mask uint64) bool) bool {
+	h1 := binary.LittleEndian.Uint64(digest[:]) ^ uint64(binary.LittleEndian.Uint32(digest[sha256.Size:]))*0x9e3779b97f4a7c15
+	h2 := binary.LittleEndian.Uint64(digest[8:]) | 1
+	sizeBits := uint64(len(bf.bits)) * 64
+	for i := 0; i < bf.hashFunctions; i++ {
+		position := (h1 + uint64(i)*h2) % sizeBits
+		if !callback(int(position/64), 1<<(position%64)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (bf *bloomFilter) add(digest simpleDigest) {
+	bf.getPositions(digest, func(word int, mask uint64) bool {
+		bf.bits[word] |= mask
+		return true
+	})
+}
+
+func (bf *bloomFilter) mayContain(digest simpleDigest) bool {
+	return bf.getPositions(digest, func(word int, mask uint64) bool {
+		return bf.bits[word]&mask != 0
+	})
+}
+
+func (bf *bloomFilter) clear() {
+	for i := range bf.bits {
+		bf.bits[i] = 0
+	}
+}
+
+type bloomFilterOffsetStore struct {
+	backend       OffsetStore
+	dataSizeBytes uint64
+
+	// Two generations of Bloom filters. Digests are always added
+	// to the current generation. The current generation was
+	// started when the write cursor was at currentStart.
+	current      bloomFilter
+	previous     bloomFilter
+	currentStart uint64
+}
+
+// NewBloomFilterOffsetStore is an adapter for OffsetStore that keeps
+// an in-memory Bloom filter of all digests stored in the backend. The
+// Bloom filter is consulted before performing lookups against the
+// backend, so that lookups for digests that are definitely absent
+// don't need to access underlying storage. This speeds up
+// FindMissing() calls for large data sets, where most of the probes
+// against the offset store would otherwise be cache-unfriendly.
+//
+// As Bloom filters don't support removal, entries that age out of the
+// data file are removed by maintaining two generations of filters.
+// Every time the write cursor has advanced by the size of the data
+// file, the oldest generation is cleared and reused. By then, all
+// digests that were added to it refer to data that has been
+// overwritten.
+//
+// The Bloom filter is populated by iterating over the backend upon
+// construction.
+func NewBloomFilterOffsetStore(backend OffsetStore, cursors Cursors, dataSizeBytes, filterSizeBytes uint64, hashFunctions int) (OffsetStore, error) {
+	bloomFilterOffsetStorePrometheusMetrics.Do(func() {
+		prometheus.MustRegister(bloomFilterOffsetStoreLookups)
+		prometheus.MustRegister(bloomFilterOffsetStoreRotations)
+	})
+
+	if filterSizeBytes == 0 || hashFunctions <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "The size of the Bloom filter and the number of hash functions must be positive")
+	}
+
+	os := &bloomFilterOffsetStore{
+		backend:       backend,
+		dataSizeBytes: dataSizeBytes,
+		current:       newBloomFilter(filterSizeBytes, hashFunctions),
+		previous:      newBloomFilter(filterSizeBytes, hashFunctions),
+		currentStart:  cursors.Write,
+	}
+	if err := backend.Iterate(digest.EmptyInstanceName, cursors, func(blobDigest digest.Digest) error {
+		os.current.add(newSimpleDigest(blobDigest))
+		return nil
+	}); err != nil {
+		return nil, util.StatusWrap(err, "Failed to populate Bloom filter")
+	}
+	return os, nil
+}
+
+// maybeRotate clears the oldest generation of the Bloom filter if the
+// write cursor has advanced by at least the size of the data file
+// since the current generation was started.
+func (os *bloomFilterOffsetStore) maybeRotate(cursors Cursors) {
+	if cursors.Write >= os.currentStart+os.dataSizeBytes {
+		os.previous.clear()
+		os.current, os.previous = os.previous, os.current
+		os.currentStart = cursors.Write
+		bloomFilterOffsetStoreRotations.Inc()
+	}
+}
+
+func (os *bloomFilterOffsetStore) mayContain(digest simpleDigest) bool {
+	return os.current.mayContain(digest) || os.previous.mayContain(digest)
+}
+
+func (os *bloomFilterOffsetStore) Get(digest digest.Digest, cursors Cursors) (uint64, int64, bool, error) {
+	os.maybeRotate(cursors)
+	if !os.mayContain(newSimpleDigest(digest)) {
+		bloomFilterOffsetStoreLookupsDefinitelyMissing.Inc()
+		return 0, 0, false, nil
+	}
+
+	offset, length, found, err := os.backend.Get(digest, cursors)
+	if err == nil {
+		if found {
+			bloomFilterOffsetStoreLookupsTruePositive.Inc()
+		} else {
+			bloomFilterOffsetStoreLookupsFalsePositive.Inc()
+		}
+	}
+	return offset, length, found, err
+}
+
+func (os *bloomFilterOffsetStore) GetMany(digests []digest.Digest, cursors Cursors) ([]OffsetStoreGetResult, error) {
+	// Only forward lookups for digests that may be present to the
+	// backend.
+	os.maybeRotate(cursors)
+	results := make([]OffsetStoreGetResult, len(digests))
+	var maybeIndices []int
+	var maybeDigests []digest.Digest
+	for i, blobDigest := range digests {
+		if os.mayContain(newSimpleDigest(blobDigest)) {
+			maybeIndices = append(maybeIndices, i)
+			maybeDigests = append(maybeDigests, blobDigest)
+		}
+	}
+	bloomFilterOffsetStoreLookupsDefinitelyMissing.Add(float64(len(digests) - len(maybeDigests)))
+	if len(maybeDigests) == 0 {
+		return results, nil
+	}
+
+	maybeResults, err := os.backend.GetMany(maybeDigests, cursors)
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range maybeResults {
+		results[maybeIndices[i]] = result
+		if result.Found {
+			bloomFilterOffsetStoreLookupsTruePositive.Inc()
+		} else {
+			bloomFilterOffsetStoreLookupsFalsePositive.Inc()
+		}
+	}
+	return results, nil
+}
+
+func (os *bloomFilterOffsetStore) GetMetadata(digest digest.Digest, cursors Cursors) (BlobMetadata, bool, error) {
+	os.maybeRotate(cursors)
+	if !os.mayContain(newSimpleDigest(digest)) {
+		return BlobMetadata{}, false, nil
+	}
+	return os.backend.GetMetadata(digest, cursors)
+}
+
+func (os *bloomFilterOffsetStore) Put(digest digest.Digest, offset uint64, length int64, metadata BlobMetadata, cursors Cursors) error {
+	os.maybeRotate(cursors)
+	if err := os.backend.Put(digest, offset, length, metadata, cursors); err != nil {
+		return err
+	}
+	os.current.add(newSimpleDigest(digest))
+	return nil
+}
+
+func (os *bloomFilterOffsetStore) Iterate(instanceName digest.InstanceName, cursors Cursors, callback func(digest digest.Digest) error) error {
+	return os.backend.Iterate(instanceName, cursors, callback)
+}
@@ -0,0 +1,79 @@
+package circular_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/circular"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBloomFilterOffsetStore(t *testing.T) {
+	digest1 := digest.MustNewDigest("", "00000000000000000000000000000001", 1)
+	digest2 := digest.MustNewDigest("", "00000000000000000000000000000002", 2)
+	digest3 := digest.MustNewDigest("", "00000000000000000000000000000003", 3)
+	backend := circular.NewFileOffsetStore(&memoryFile{}, 1024*1024)
+
+	// Entries that are already present in the backend should be
+	// loaded into the Bloom filter upon construction.
+	require.NoError(t, backend.Put(digest1, 100, 1, circular.BlobMetadata{}, circular.Cursors{Read: 0, Write: 200}))
+	offsetStore, err := circular.NewBloomFilterOffsetStore(backend, circular.Cursors{Read: 0, Write: 200}, 1000, 1024, 4)
+	require.NoError(t, err)
+
+	offset, length, found, err := offsetStore.Get(digest1, circular.Cursors{Read: 0, Write: 200})
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(100), offset)
+	require.Equal(t, int64(1), length)
+
+	_, _, found, err = offsetStore.Get(digest2, circular.Cursors{Read: 0, Write: 200})
+	require.NoError(t, err)
+	require.False(t, found)
+
+	// Entries written through the adapter should be added to the
+	// Bloom filter.
+	require.NoError(t, offsetStore.Put(digest2, 950, 2, circular.BlobMetadata{}, circular.Cursors{Read: 0, Write: 1000}))
+	results, err := offsetStore.GetMany([]digest.Digest{digest1, digest2, digest3}, circular.Cursors{Read: 0, Write: 1000})
+	require.NoError(t, err)
+	require.Equal(t, []circular.OffsetStoreGetResult{
+		{Offset: 100, Length: 1, Found: true},
+		{Offset: 950, Length: 2, Found: true},
+		{},
+	}, results)
+
+	// Once the write cursor has advanced by the size of the data
+	// file, a new generation of the Bloom filter is started. Entries
+	// that still refer to valid data must remain visible.
+	results, err = offsetStore.GetMany([]digest.Digest{digest1, digest2, digest3}, circular.Cursors{Read: 950, Write: 1950})
+	require.NoError(t, err)
+	require.Equal(t, []circular.OffsetStoreGetResult{
+		{},
+		{Offset: 950, Length: 2, Found: true},
+		{},
+	}, results)
+
+	require.NoError(t, offsetStore.Put(digest3, 1960, 3, circular.BlobMetadata{}, circular.Cursors{Read: 960, Write: 1970}))
+	results, err = offsetStore.GetMany([]digest.Digest{digest1, digest2, digest3}, circular.Cursors{Read: 960, Write: 2900})
+	require.NoError(t, err)
+	require.Equal(t, []circular.OffsetStoreGetResult{
+		{},
+		{},
+		{Offset: 1960, Length: 3, Found: true},
+	}, results)
+
+	// After another full wrap, entries added after the previous
+	// rotation should still be visible.
+	results, err = offsetStore.GetMany([]digest.Digest{digest3}, circular.Cursors{Read: 1960, Write: 2960})
+	require.NoError(t, err)
+	require.Equal(t, []circular.OffsetStoreGetResult{
+		{Offset: 1960, Length: 3, Found: true},
+	}, results)
+}
+
+func TestBloomFilterOffsetStoreInvalidConfiguration(t *testing.T) {
+	_, err := circular.NewBloomFilterOffsetStore(circular.NewFileOffsetStore(&memoryFile{}, 1024), circular.Cursors{}, 1000, 0, 4)
+	require.Equal(t, status.Error(codes.InvalidArgument, "The size of the Bloom filter and the number of hash functions must be positive"), err)
+}
@@ -2,19 +2,31 @@ package circular
 
 type bulkAllocatingStateStore struct {
 	StateStore
-	chunkSize   uint64
-	writeCursor uint64
+	chunkSize      uint64
+	alignmentBytes uint64
+	writeCursor    uint64
 }
 
 // NewBulkAllocatingStateStore is an adapter for StateStore that reduces
 // the number of Allocate() calls on the underlying implementation by
 // allocating data as larger chunks. These chunks are then sub-allocated
 // as needed.
-func NewBulkAllocatingStateStore(stateStore StateStore, chunkSize uint64) StateStore {
+//
+// If alignmentBytes is greater than one, offsets returned by Allocate()
+// are rounded up to a multiple of it. This may be used to let writes
+// to the data file start at block boundaries, which is required when
+// using O_DIRECT. The padding that is inserted in front of blobs is
+// allocated from the underlying StateStore, meaning it is taken into
+// account when invalidating data that is about to be overwritten.
+func NewBulkAllocatingStateStore(stateStore StateStore, chunkSize, alignmentBytes uint64) StateStore {
+	if alignmentBytes < 1 {
+		alignmentBytes = 1
+	}
 	return &bulkAllocatingStateStore{
-		StateStore:  stateStore,
-		chunkSize:   chunkSize,
-		writeCursor: ^uint64(0),
+		StateStore:     stateStore,
+		chunkSize:      chunkSize,
+		alignmentBytes: alignmentBytes,
+		writeCursor:    ^uint64(0),
 	}
 }
 
@@ -29,10 +41,13 @@ func (ss *bulkAllocatingStateStore) Allocate(sizeBytes int64) (uint64, error) {
 		ss.writeCursor = cursors.Write
 	}
 
-	// Allocate more space if needed.
+	// Allocate more space if needed, including any padding that is
+	// needed to align the offset of the blob.
+	padding := (ss.alignmentBytes - ss.writeCursor%ss.alignmentBytes) % ss.alignmentBytes
+	spaceNeeded := padding + uint64(sizeBytes)
 	spaceLeft := cursors.Write - ss.writeCursor
-	if uint64(sizeBytes) > spaceLeft {
-		spaceMissing := uint64(sizeBytes) - spaceLeft
+	if spaceNeeded > spaceLeft {
+		spaceMissing := spaceNeeded - spaceLeft
 		allocationSize := (spaceMissing + ss.chunkSize - 1) / ss.chunkSize * ss.chunkSize
 		if _, err := ss.StateStore.Allocate(int64(allocationSize)); err != nil {
 			return 0, err
@@ -40,7 +55,7 @@ func (ss *bulkAllocatingStateStore) Allocate(sizeBytes int64) (uint64, error) {
 	}
 
 	// Perform allocation from current chunk.
-	offset := ss.writeCursor
-	ss.writeCursor += uint64(sizeBytes)
+	offset := ss.writeCursor + padding
+	ss.writeCursor = offset + uint64(sizeBytes)
 	return offset, nil
 }
@@ -0,0 +1,101 @@
+package circular_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/circular"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBulkAllocatingStateStore(t *testing.T) {
+	baseStateStore, err := circular.NewFileStateStore(&memoryFile{}, 1000)
+	require.NoError(t, err)
+	stateStore := circular.NewBulkAllocatingStateStore(baseStateStore, 100, 1)
+
+	// Space should be allocated from the underlying StateStore in
+	// chunks, which are then sub-allocated.
+	offset, err := stateStore.Allocate(30)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offset)
+	offset, err = stateStore.Allocate(50)
+	require.NoError(t, err)
+	require.Equal(t, uint64(30), offset)
+	require.Equal(t, circular.Cursors{Read: 0, Write: 100}, stateStore.GetCursors())
+
+	offset, err = stateStore.Allocate(30)
+	require.NoError(t, err)
+	require.Equal(t, uint64(80), offset)
+	require.Equal(t, circular.Cursors{Read: 0, Write: 200}, stateStore.GetCursors())
+}
+
+func TestBulkAllocatingStateStoreAlignment(t *testing.T) {
+	baseStateStore, err := circular.NewFileStateStore(&memoryFile{}, 1000)
+	require.NoError(t, err)
+	stateStore := circular.NewBulkAllocatingStateStore(baseStateStore, 96, 16)
+
+	// Perform many allocations of varying sizes, causing the data
+	// file to wrap around many times. Every allocation should be
+	// aligned, start after the end of the previous allocation and
+	// still be contained in the cursors.
+	var previousEnd uint64
+	for i := 0; i < 1000; i++ {
+		sizeBytes := int64(i*37%150 + 1)
+		offset, err := stateStore.Allocate(sizeBytes)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), offset%16)
+		require.LessOrEqual(t, previousEnd, offset)
+		cursors := stateStore.GetCursors()
+		require.True(t, cursors.Contains(offset, sizeBytes))
+		previousEnd = offset + uint64(sizeBytes)
+	}
+
+	// Invalidations may cause the read cursor to end up at an
+	// unaligned offset. Subsequent allocations should still be
+	// aligned.
+	cursors := stateStore.GetCursors()
+	require.NoError(t, stateStore.Invalidate(cursors.Write-5, 3))
+	offset, err := stateStore.Allocate(10)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offset%16)
+	cursors = stateStore.GetCursors()
+	require.True(t, cursors.Contains(offset, 10))
+}
+
+// alignmentCheckingFile is a ReadWriterAt that fails writes at offsets
+// that are not aligned.
+type alignmentCheckingFile struct {
+	memoryFile
+	alignmentBytes int64
+}
+
+func (f *alignmentCheckingFile) WriteAt(p []byte, off int64) (int, error) {
+	if off%f.alignmentBytes != 0 {
+		return 0, status.Errorf(codes.Internal, "Write at unaligned offset %d", off)
+	}
+	return f.memoryFile.WriteAt(p, off)
+}
+
+func TestBulkAllocatingStateStoreAlignmentWraparound(t *testing.T) {
+	ctx := context.Background()
+
+	// If the size of the data file is a multiple of the alignment,
+	// writes to the data file should remain aligned after the
+	// offsets wrap around at the end of the data file.
+	baseStateStore, err := circular.NewFileStateStore(&memoryFile{}, 1024)
+	require.NoError(t, err)
+	stateStore := circular.NewBulkAllocatingStateStore(baseStateStore, 96, 16)
+	dataStore := circular.NewFileDataStore(&alignmentCheckingFile{alignmentBytes: 16}, 1024)
+
+	for i := 0; i < 1000; i++ {
+		sizeBytes := int64(i*37%150 + 1)
+		offset, err := stateStore.Allocate(sizeBytes)
+		require.NoError(t, err)
+		require.NoError(t, dataStore.Put(ctx, bytes.NewReader(make([]byte, sizeBytes)), offset))
+	}
+	require.Less(t, uint64(10*1024), stateStore.GetCursors().Write)
+}
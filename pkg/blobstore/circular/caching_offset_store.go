@@ -52,8 +52,57 @@ func (os *cachingOffsetStore) Get(digest digest.Digest, cursors Cursors) (uint64
 	return offset, length, found, err
 }
 
-func (os *cachingOffsetStore) Put(digest digest.Digest, offset uint64, length int64, cursors Cursors) error {
-	if err := os.backend.Put(digest, offset, length, cursors); err != nil {
+func (os *cachingOffsetStore) GetMany(digests []digest.Digest, cursors Cursors) ([]OffsetStoreGetResult, error) {
+	// Serve as many lookups as possible from the cache. Forward
+	// the remaining lookups to the backend in a single batch.
+	results := make([]OffsetStoreGetResult, len(digests))
+	var missIndices []int
+	var missDigests []digest.Digest
+	for i, blobDigest := range digests {
+		simpleDigest := newSimpleDigest(blobDigest)
+		slot := binary.LittleEndian.Uint32(simpleDigest[:]) % uint32(len(os.table))
+		if foundRecord := os.table[slot]; foundRecord.digest == simpleDigest && cursors.Contains(foundRecord.offset, foundRecord.length) {
+			results[i] = OffsetStoreGetResult{
+				Offset: foundRecord.offset,
+				Length: foundRecord.length,
+				Found:  true,
+			}
+		} else {
+			missIndices = append(missIndices, i)
+			missDigests = append(missDigests, blobDigest)
+		}
+	}
+	if len(missDigests) == 0 {
+		return results, nil
+	}
+
+	missResults, err := os.backend.GetMany(missDigests, cursors)
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range missResults {
+		results[missIndices[i]] = result
+		if result.Found {
+			simpleDigest := newSimpleDigest(missDigests[i])
+			slot := binary.LittleEndian.Uint32(simpleDigest[:]) % uint32(len(os.table))
+			os.table[slot] = cachedRecord{
+				digest: simpleDigest,
+				offset: result.Offset,
+				length: result.Length,
+			}
+		}
+	}
+	return results, nil
+}
+
+func (os *cachingOffsetStore) GetMetadata(digest digest.Digest, cursors Cursors) (BlobMetadata, bool, error) {
+	// Metadata is not cached, as it is expected to be requested
+	// infrequently.
+	return os.backend.GetMetadata(digest, cursors)
+}
+
+func (os *cachingOffsetStore) Put(digest digest.Digest, offset uint64, length int64, metadata BlobMetadata, cursors Cursors) error {
+	if err := os.backend.Put(digest, offset, length, metadata, cursors); err != nil {
 		return err
 	}
 
@@ -66,3 +115,7 @@ func (os *cachingOffsetStore) Put(digest digest.Digest, offset uint64, length in
 	}
 	return nil
 }
+
+func (os *cachingOffsetStore) Iterate(instanceName digest.InstanceName, cursors Cursors, callback func(digest digest.Digest) error) error {
+	return os.backend.Iterate(instanceName, cursors, callback)
+}
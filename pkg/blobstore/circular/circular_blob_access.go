@@ -5,12 +5,12 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
-	"log"
 	"sync"
 
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -20,17 +20,75 @@ import (
 
 // OffsetStore maps a digest to an offset within the data file. This is
 // where the blob's contents may be found.
+//
+// Implementations of OffsetStore that store data, such as the one
+// returned by NewFileOffsetStore(), only take the hash and size of the
+// digest into account. This corresponds to digest.KeyWithoutInstance,
+// permitting identical blobs to be deduplicated across instances. To
+// obtain behavior that corresponds to digest.KeyWithInstance, a
+// separate OffsetStore needs to be used for every instance name, using
+// NewDemultiplexingOffsetStore().
+//
+// GetMany() is a batch version of Get(), returning the results of the
+// lookups in the same order as the provided digests. Implementations
+// may reorder the lookups internally to improve locality of access.
+// It is used by FindMissing(), so that the lock of the storage backend
+// is held for a shorter amount of time.
+//
+// Iterate() calls a callback for every digest that refers to data
+// within the provided cursors, reconstructing digests using the
+// provided instance name. Every digest is reported at most once.
+// Unlike Get() and Put(), it may be called without holding the lock of
+// the storage backend, meaning that implementations must not modify
+// any state while iterating, and must tolerate records being
+// overwritten by concurrent calls to Put(). Records that are read
+// while being overwritten must be discarded, as opposed to being
+// reported as digests that were never stored. Digests that cannot be
+// reconstructed from the records (e.g., because their hash was
+// truncated) are not reported.
+//
+// GetMetadata() returns the BlobMetadata that was provided to Put().
+// Implementations that don't store metadata discard it in Put(), and
+// return an error with code UNIMPLEMENTED from GetMetadata().
 type OffsetStore interface {
 	Get(digest digest.Digest, cursors Cursors) (uint64, int64, bool, error)
-	Put(digest digest.Digest, offset uint64, length int64, cursors Cursors) error
+	GetMany(digests []digest.Digest, cursors Cursors) ([]OffsetStoreGetResult, error)
+	GetMetadata(digest digest.Digest, cursors Cursors) (BlobMetadata, bool, error)
+	Put(digest digest.Digest, offset uint64, length int64, metadata BlobMetadata, cursors Cursors) error
+	Iterate(instanceName digest.InstanceName, cursors Cursors, callback func(digest digest.Digest) error) error
+}
+
+// OffsetStoreGetResult contains the result of a single lookup
+// performed by OffsetStore.GetMany(). Offset and Length are only set
+// if Found is true.
+type OffsetStoreGetResult struct {
+	Offset uint64
+	Length int64
+	Found  bool
 }
 
 // DataStore is where the data corresponding with a blob is stored. Data
 // can be accessed by providing an offset within the data store and its
-// length.
+// length. Readers returned by Get() must be closed, so that any
+// resources associated with them may be released. Put() should stop
+// writing data as soon as the provided context is canceled.
 type DataStore interface {
-	Put(r io.Reader, offset uint64) error
-	Get(offset uint64, size int64) io.Reader
+	Put(ctx context.Context, r io.Reader, offset uint64) error
+	Get(offset uint64, size int64) io.ReadCloser
+}
+
+// CopyingDataStore is an optional extension of DataStore, implemented
+// by stores that can move data between offsets efficiently, without
+// it being passed through the caller (e.g., using copy_file_range()).
+// This may be used to compact the data store, by moving blobs that are
+// still in use to the write cursor.
+//
+// Callers should use CopyData(), which falls back to using Get() and
+// Put() for stores that don't implement this interface.
+type CopyingDataStore interface {
+	DataStore
+
+	Copy(ctx context.Context, srcOffset uint64, dstOffset uint64, length int64) error
 }
 
 // StateStore is where global metadata of the circular storage backend
@@ -42,10 +100,35 @@ type StateStore interface {
 	Invalidate(offset uint64, sizeBytes int64) error
 }
 
+// IndexReplacer is implemented by the BlobAccess returned by
+// NewCircularBlobAccess(). It permits replacing the OffsetStore and
+// StateStore while the storage backend is in use (e.g., after the
+// offset store has been rebuilt to remove stale entries).
+//
+// As buffers returned by Get() that are still being consumed refer to
+// offsets within the DataStore, the DataStore is not replaced. Offsets
+// in the DataStore are only overwritten when the write cursor
+// advances. A replacement is therefore only permitted if the cursors
+// of the new StateStore do not lie before the ones of the current
+// StateStore. This ensures that regions of the DataStore that are in
+// the process of being written are not allocated a second time, and
+// that data that has been invalidated does not become visible again.
+// Blobs that are not contained in the new OffsetStore (e.g., because
+// they were written after it was rebuilt) are reported as absent.
+type IndexReplacer interface {
+	ReplaceIndex(offsetStore OffsetStore, stateStore StateStore) error
+}
+
 type circularBlobAccess struct {
 	// Fields that are constant or lockless.
-	dataStore         DataStore
-	readBufferFactory blobstore.ReadBufferFactory
+	dataStore              DataStore
+	readBufferFactory      blobstore.ReadBufferFactory
+	maximumMemorySizeBytes int
+	spillFileFactory       buffer.SpillFileFactory
+	validateOnPut          bool
+	repairer               blobstore.BlobRepairer
+	errorLogger            util.ErrorLogger
+	blobMetadataFunc       BlobMetadataFunc
 
 	// Fields protected by the lock.
 	lock        sync.Mutex
@@ -56,107 +139,317 @@ type circularBlobAccess struct {
 // NewCircularBlobAccess creates a new circular storage backend. Instead
 // of writing data to storage directly, all three storage files are
 // injected through separate interfaces.
-func NewCircularBlobAccess(offsetStore OffsetStore, dataStore DataStore, stateStore StateStore, readBufferFactory blobstore.ReadBufferFactory) blobstore.BlobAccess {
+//
+// Space in the data store needs to be allocated before a blob is
+// written. For buffers whose size is not known up front, the blob is
+// therefore read in its entirety first. Blobs of up to
+// maximumMemorySizeBytes in size are held in memory, while larger blobs
+// are written to files obtained through spillFileFactory.
+//
+// By default, Put() trusts that the contents of the buffer match the
+// digest. If validateOnPut is set, the data is checksummed while being
+// written, and blobs with mismatching contents are rejected before
+// being added to the offset store. This causes malformed data provided
+// by untrusted clients to be detected early, at the cost of additional
+// CPU usage. This option may only be enabled for the Content
+// Addressable Storage.
+//
+// Blobs that are found to be malformed while being read are deleted,
+// which is reported through the provided ErrorLogger. If a
+// BlobRepairer is provided, it is called afterwards to restore the
+// blob, so that successive reads may succeed. The BlobRepairer is
+// called without holding the lock of this backend, meaning that it may
+// call back into it.
+//
+// If a BlobMetadataFunc is provided, it is called for every blob that
+// is written, and the resulting metadata is stored in the offset
+// store. It can be obtained through OffsetStore.GetMetadata() by
+// tooling that operates on the offset store directly. This requires
+// the use of an OffsetStore that is capable of storing metadata, such
+// as the one returned by NewFileOffsetStoreWithMetadata().
+func NewCircularBlobAccess(offsetStore OffsetStore, dataStore DataStore, stateStore StateStore, readBufferFactory blobstore.ReadBufferFactory, maximumMemorySizeBytes int, spillFileFactory buffer.SpillFileFactory, validateOnPut bool, repairer blobstore.BlobRepairer, errorLogger util.ErrorLogger, blobMetadataFunc BlobMetadataFunc) blobstore.RangeReadingBlobAccess {
 	return &circularBlobAccess{
-		offsetStore:       offsetStore,
-		dataStore:         dataStore,
-		stateStore:        stateStore,
-		readBufferFactory: readBufferFactory,
+		offsetStore:            offsetStore,
+		dataStore:              dataStore,
+		stateStore:             stateStore,
+		readBufferFactory:      readBufferFactory,
+		maximumMemorySizeBytes: maximumMemorySizeBytes,
+		spillFileFactory:       spillFileFactory,
+		validateOnPut:          validateOnPut,
+		repairer:               repairer,
+		errorLogger:            errorLogger,
+		blobMetadataFunc:       blobMetadataFunc,
 	}
 }
 
+// newBuffer creates a buffer for a blob that is stored at a given
+// offset in the data store. Blobs that turn out to be malformed are
+// invalidated and repaired.
+func (ba *circularBlobAccess) newBuffer(digest digest.Digest, offset uint64, length int64) buffer.Buffer {
+	// Provide the size of the data as stored, as it may differ from
+	// the size in the digest (e.g., when the data is compressed).
+	return ba.readBufferFactory.NewBufferFromFileReader(
+		digest,
+		newDataStoreFileReader(ba.dataStore, offset, length),
+		length,
+		func(dataIsValid bool) {
+			if !dataIsValid {
+				// Only hold the lock while invalidating, so
+				// that the repairer may call back into this
+				// backend.
+				ba.lock.Lock()
+				err := ba.stateStore.Invalidate(offset, length)
+				ba.lock.Unlock()
+				if err == nil {
+					ba.errorLogger.Log(status.Errorf(codes.Internal, "Blob %#v at offset %d with length %d was malformed", digest.String(), offset, length))
+				} else {
+					ba.errorLogger.Log(util.StatusWrapf(err, "Blob %#v at offset %d with length %d was malformed and could not be deleted", digest.String(), offset, length))
+				}
+				if ba.repairer != nil {
+					ba.repairer(digest, ba)
+				}
+			}
+		})
+}
+
 func (ba *circularBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
 	_, span := trace.StartSpan(ctx, "circularBlobAccess.Get")
 	defer span.End()
 
 	ba.lock.Lock()
-	span.Annotate(nil, "Lock obtained, calling GetCursors")
 	cursors := ba.stateStore.GetCursors()
 	offset, length, ok, err := ba.offsetStore.Get(digest, cursors)
+	ba.lock.Unlock()
 	span.Annotate([]trace.Attribute{
 		trace.Int64Attribute("offset", int64(offset)),
 		trace.Int64Attribute("length", length),
 		trace.BoolAttribute("object_found", ok),
 	}, "offsetStore.Get completed")
-	ba.lock.Unlock()
 	if err != nil {
 		return buffer.NewBufferFromError(err)
 	} else if ok {
-		return ba.readBufferFactory.NewBufferFromReader(
-			digest,
-			ioutil.NopCloser(ba.dataStore.Get(offset, length)),
-			func(dataIsValid bool) {
-				if !dataIsValid {
-					ba.lock.Lock()
-					err := ba.stateStore.Invalidate(offset, length)
-					defer ba.lock.Unlock()
-					if err == nil {
-						log.Printf("Blob %#v was malformed and has been deleted successfully", digest.String())
-					} else {
-						log.Printf("Blob %#v was malformed and could not be deleted: %s", digest.String(), err)
-					}
-				}
-			})
+		return ba.newBuffer(digest, offset, length)
 	}
 	return buffer.NewBufferFromError(status.Errorf(codes.NotFound, "Blob not found"))
 }
 
+func (ba *circularBlobAccess) GetRange(ctx context.Context, digest digest.Digest, offset int64, sizeBytes int64) buffer.Buffer {
+	sizeBytes, err := blobstore.GetRangeSizeBytes(digest, offset, sizeBytes)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+
+	ba.lock.Lock()
+	cursors := ba.stateStore.GetCursors()
+	blobOffset, length, ok, err := ba.offsetStore.Get(digest, cursors)
+	ba.lock.Unlock()
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	} else if !ok {
+		return buffer.NewBufferFromError(status.Errorf(codes.NotFound, "Blob not found"))
+	} else if length != digest.GetSizeBytes() {
+		// The blob is stored in a different representation
+		// (e.g., compressed), meaning that the range cannot be
+		// read from the data store directly. Decode the blob
+		// through the ReadBufferFactory and discard the data
+		// surrounding the range.
+		r := ba.newBuffer(digest, blobOffset, length).ToReader()
+		if _, err := io.CopyN(ioutil.Discard, r, offset); err != nil {
+			r.Close()
+			return buffer.NewBufferFromError(err)
+		}
+		return buffer.NewValidatedBufferFromReader(
+			struct {
+				io.Reader
+				io.Closer
+			}{
+				Reader: io.LimitReader(r, sizeBytes),
+				Closer: r,
+			},
+			sizeBytes)
+	}
+
+	// As the data is not validated, ensure that it has not been
+	// overwritten while it is being read.
+	f := newDataStoreFileReader(ba.dataStore, blobOffset, length)
+	return buffer.NewValidatedBufferFromReader(
+		&rangeReader{
+			Reader:     io.NewSectionReader(f, offset, sizeBytes),
+			closer:     f,
+			ba:         ba,
+			blobOffset: blobOffset,
+			length:     length,
+		},
+		sizeBytes)
+}
+
+// rangeReader is returned by GetRange() to stream part of a blob from
+// the data store. As the data cannot be validated against the blob's
+// digest, it checks whether the blob is still present after every
+// read, so that data that has been overwritten is never returned.
+type rangeReader struct {
+	io.Reader
+	closer     io.Closer
+	ba         *circularBlobAccess
+	blobOffset uint64
+	length     int64
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.ba.lock.Lock()
+	cursors := r.ba.stateStore.GetCursors()
+	r.ba.lock.Unlock()
+	if !cursors.Contains(r.blobOffset, r.length) {
+		return 0, status.Error(codes.NotFound, "Blob not found")
+	}
+	return n, err
+}
+
+func (r *rangeReader) Close() error {
+	return r.closer.Close()
+}
+
 func (ba *circularBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	// The size of the blob needs to be known to allocate space for
+	// it.
+	b = buffer.WithKnownSize(b, ba.maximumMemorySizeBytes, ba.spillFileFactory)
 	sizeBytes, err := b.GetSizeBytes()
 	if err != nil {
 		b.Discard()
 		return err
 	}
-
-	// TODO: This would be more efficient if it passed the buffer
-	// down, so IntoWriter() could be used.
+	// DataStore.Put() consumes a reader, which is why ToReader() is
+	// used instead of IntoWriter(). Buffers of unknown size have
+	// already been stored in memory or a spill file by
+	// WithKnownSize(), so their contents are not read twice.
 	r := b.ToReader()
+	if ba.validateOnPut {
+		// Let the data be checksummed while being written. A
+		// mismatch causes DataStore.Put() to fail, preventing
+		// the blob from being committed.
+		r = buffer.NewCASBufferFromReader(digest, r, buffer.UserProvided).ToReader()
+	}
 	defer r.Close()
 
-	_, span := trace.StartSpan(ctx, "circularBlobAccess.Put")
-	defer span.End()
-
 	// Allocate space in the data store.
 	ba.lock.Lock()
-	span.Annotatef(nil, "Lock obtained, allocating %d bytes", sizeBytes)
 	offset, err := ba.stateStore.Allocate(sizeBytes)
 	ba.lock.Unlock()
 	if err != nil {
 		return err
 	}
-	span.Annotatef(nil, "Store allocated, offset %d", offset)
 
-	// Write the data to storage.
-	if err := ba.dataStore.Put(r, offset); err != nil {
+	// Write the data to storage. If writing fails (e.g., due to the
+	// context being canceled), the allocated region may contain
+	// partially written data. There is no need to invalidate it
+	// explicitly, as it is only indexed after writing succeeds.
+	// Calling StateStore.Invalidate() would be harmful, as it also
+	// discards all data stored before the region.
+	if err := ba.dataStore.Put(ctx, r, offset); err != nil {
 		return err
 	}
+	return ba.commit(ctx, digest, offset, sizeBytes)
+}
+
+// commit adds an entry to the offset store for a blob that has been
+// written to the data store successfully.
+func (ba *circularBlobAccess) commit(ctx context.Context, digest digest.Digest, offset uint64, sizeBytes int64) error {
+	var metadata BlobMetadata
+	if ba.blobMetadataFunc != nil {
+		metadata = ba.blobMetadataFunc(ctx)
+	}
 
-	span.Annotate(nil, "Obtaining lock")
 	ba.lock.Lock()
-	span.Annotate(nil, "Lock obtained, calling GetCursors")
+	defer ba.lock.Unlock()
+
 	cursors := ba.stateStore.GetCursors()
-	if cursors.Contains(offset, sizeBytes) {
-		span.Annotate(nil, "Updating offsetStore")
-		err = ba.offsetStore.Put(digest, offset, sizeBytes, cursors)
-	} else {
-		err = errors.New("Data became stale before write completed")
+	if !cursors.Contains(offset, sizeBytes) {
+		return errors.New("Data became stale before write completed")
 	}
+	return ba.offsetStore.Put(digest, offset, sizeBytes, metadata, cursors)
+}
+
+// ListDigests calls a callback for every blob that is stored for a
+// given instance name. The offset store is iterated without holding
+// the lock, so that other operations are not blocked for the duration
+// of the iteration. This is safe, as OffsetStore.Iterate() is required
+// to tolerate concurrent calls to Put(). The results are thus a
+// best-effort snapshot: blobs written or overwritten while iterating
+// may or may not be reported.
+func (ba *circularBlobAccess) ListDigests(ctx context.Context, instanceName digest.InstanceName, callback func(digest digest.Digest) error) error {
+	// The offset store may be swapped out by ReplaceIndex(), so it
+	// must be obtained while holding the lock.
+	ba.lock.Lock()
+	offsetStore := ba.offsetStore
+	cursors := ba.stateStore.GetCursors()
 	ba.lock.Unlock()
-	return err
+
+	return offsetStore.Iterate(instanceName, cursors, func(digest digest.Digest) error {
+		if err := util.StatusFromContext(ctx); err != nil {
+			return err
+		}
+		return callback(digest)
+	})
 }
 
 func (ba *circularBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	missing, _, err := ba.FindMissingAndPresent(ctx, digests)
+	return missing, err
+}
+
+func (ba *circularBlobAccess) FindMissingAndPresent(ctx context.Context, digests digest.Set) (digest.Set, digest.Set, error) {
+	if digests.Empty() {
+		return digest.EmptySet, digest.EmptySet, nil
+	}
+
 	ba.lock.Lock()
 	defer ba.lock.Unlock()
 
-	cursors := ba.stateStore.GetCursors()
+	blobDigests := digests.Items()
+	results, err := ba.offsetStore.GetMany(blobDigests, ba.stateStore.GetCursors())
+	if err != nil {
+		return digest.EmptySet, digest.EmptySet, err
+	}
 	missingDigests := digest.NewSetBuilder()
-	for _, blobDigest := range digests.Items() {
-		if _, _, ok, err := ba.offsetStore.Get(blobDigest, cursors); err != nil {
-			return digest.EmptySet, err
-		} else if !ok {
-			missingDigests.Add(blobDigest)
+	presentDigests := digest.NewSetBuilder()
+	for i, result := range results {
+		if result.Found {
+			presentDigests.Add(blobDigests[i])
+		} else {
+			missingDigests.Add(blobDigests[i])
 		}
 	}
-	return missingDigests.Build(), nil
+	return missingDigests.Build(), presentDigests.Build(), nil
+}
+
+func (ba *circularBlobAccess) ReplaceIndex(offsetStore OffsetStore, stateStore StateStore) error {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	oldCursors := ba.stateStore.GetCursors()
+	newCursors := stateStore.GetCursors()
+	if newCursors.Read < oldCursors.Read || newCursors.Write < oldCursors.Write {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			"Cursors of the new state store (read %d, write %d) lie before the current cursors (read %d, write %d)",
+			newCursors.Read,
+			newCursors.Write,
+			oldCursors.Read,
+			oldCursors.Write)
+	}
+	ba.offsetStore = offsetStore
+	ba.stateStore = stateStore
+	return nil
+}
+
+func (ba *circularBlobAccess) CheckHealth(ctx context.Context) error {
+	ba.lock.Lock()
+	cursors := ba.stateStore.GetCursors()
+	ba.lock.Unlock()
+
+	if cursors.Read > cursors.Write {
+		return status.Errorf(codes.Internal, "Read cursor %d lies beyond write cursor %d", cursors.Read, cursors.Write)
+	}
+	return nil
 }
@@ -0,0 +1,565 @@
+package circular_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/circular"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// cancelingReader is a reader that cancels a context upon first use.
+// It can be used to simulate clients that go away during uploads.
+type cancelingReader struct {
+	r      *bytes.Reader
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	r.cancel()
+	return r.r.Read(p)
+}
+
+// newInMemoryCircularBlobAccess creates a circular storage backend
+// whose files are all stored in memory. The data file is returned, so
+// that tests may corrupt its contents.
+func newInMemoryCircularBlobAccess(t *testing.T, errorLogger util.ErrorLogger) (blobstore.BlobAccess, *memoryFile) {
+	stateStore, err := circular.NewFileStateStore(&memoryFile{}, 1024*1024)
+	require.NoError(t, err)
+	dataFile := &memoryFile{}
+	blobAccess := circular.NewCircularBlobAccess(
+		circular.NewFileOffsetStore(&memoryFile{}, 16*1024),
+		circular.NewFileDataStore(dataFile, 1024*1024),
+		stateStore,
+		blobstore.CASReadBufferFactory,
+		1024,
+		buffer.NewTemporarySpillFile,
+		false,
+		nil,
+		errorLogger,
+		nil)
+	return blobAccess, dataFile
+}
+
+func TestCircularBlobAccessPutCanceled(t *testing.T) {
+	ctx := context.Background()
+	blobAccess, _ := newInMemoryCircularBlobAccess(t, util.DefaultErrorLogger)
+
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	// Cancel the context while a large blob is being written. The
+	// write should be interrupted.
+	largeData := bytes.Repeat([]byte("x"), 200000)
+	largeHash := md5.Sum(largeData)
+	largeDigest := digest.MustNewDigest("hello", hex.EncodeToString(largeHash[:]), int64(len(largeData)))
+	canceledCtx, cancel := context.WithCancel(ctx)
+	require.Equal(
+		t,
+		status.Error(codes.Canceled, "context canceled"),
+		blobAccess.Put(
+			canceledCtx,
+			largeDigest,
+			buffer.NewCASBufferFromReader(
+				largeDigest,
+				ioutil.NopCloser(&cancelingReader{
+					r:      bytes.NewReader(largeData),
+					cancel: cancel,
+				}),
+				buffer.UserProvided)))
+
+	// The partially written blob should not be indexed, while data
+	// written previously should remain accessible.
+	missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(helloDigest).Add(largeDigest).Build())
+	require.NoError(t, err)
+	require.Equal(t, largeDigest.ToSingletonSet(), missing)
+
+	_, err = blobAccess.Get(ctx, largeDigest).ToByteSlice(len(largeData))
+	require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
+
+	data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+
+	// Retrying the upload should succeed.
+	require.NoError(t, blobAccess.Put(ctx, largeDigest, buffer.NewValidatedBufferFromByteSlice(largeData)))
+	data, err = blobAccess.Get(ctx, largeDigest).ToByteSlice(len(largeData))
+	require.NoError(t, err)
+	require.Equal(t, largeData, data)
+}
+
+func TestCircularBlobAccessPutSizeUnknown(t *testing.T) {
+	ctx := context.Background()
+	blobAccess, _ := newInMemoryCircularBlobAccess(t, util.DefaultErrorLogger)
+
+	// Space can only be allocated once the size of the blob is
+	// known. Buffers of unknown size should be read in their
+	// entirety before being written.
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromReader(ioutil.NopCloser(bytes.NewBufferString("Hello")), -1)))
+	data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+}
+
+func TestCircularBlobAccessPutSizeUnknownSpilled(t *testing.T) {
+	ctx := context.Background()
+	stateStore, err := circular.NewFileStateStore(&memoryFile{}, 1024*1024)
+	require.NoError(t, err)
+	spillFiles := 0
+	blobAccess := circular.NewCircularBlobAccess(
+		circular.NewFileOffsetStore(&memoryFile{}, 16*1024),
+		circular.NewFileDataStore(&memoryFile{}, 1024*1024),
+		stateStore,
+		blobstore.CASReadBufferFactory,
+		1024,
+		func() (filesystem.FileReadWriter, error) {
+			spillFiles++
+			return buffer.NewTemporarySpillFile()
+		},
+		false,
+		nil,
+		util.DefaultErrorLogger,
+		nil)
+
+	// Buffers of unknown size that exceed the memory limit should
+	// be written to a spill file to determine their size, after
+	// which they should be stored as usual.
+	largeData := bytes.Repeat([]byte("x"), 200000)
+	largeHash := md5.Sum(largeData)
+	largeDigest := digest.MustNewDigest("hello", hex.EncodeToString(largeHash[:]), int64(len(largeData)))
+	require.NoError(t, blobAccess.Put(ctx, largeDigest, buffer.NewValidatedBufferFromReader(ioutil.NopCloser(bytes.NewReader(largeData)), -1)))
+	require.Equal(t, 1, spillFiles)
+
+	data, err := blobAccess.Get(ctx, largeDigest).ToByteSlice(len(largeData))
+	require.NoError(t, err)
+	require.Equal(t, largeData, data)
+}
+
+func TestCircularBlobAccessPutValidated(t *testing.T) {
+	ctx := context.Background()
+	stateStore, err := circular.NewFileStateStore(&memoryFile{}, 1024*1024)
+	require.NoError(t, err)
+	blobAccess := circular.NewCircularBlobAccess(
+		circular.NewFileOffsetStore(&memoryFile{}, 16*1024),
+		circular.NewFileDataStore(&memoryFile{}, 1024*1024),
+		stateStore,
+		blobstore.CASReadBufferFactory,
+		1024,
+		buffer.NewTemporarySpillFile,
+		true,
+		nil,
+		util.DefaultErrorLogger,
+		nil)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	// Buffers whose contents don't match the digest should be
+	// rejected, even if they claim to be valid. The blob should
+	// not become accessible.
+	require.Equal(
+		t,
+		buffer.MarkDataIntegrityError(status.Error(codes.InvalidArgument, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected")),
+		blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hallo"))))
+	_, err = blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+	require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
+
+	// Buffers with the right contents should be stored.
+	require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+}
+
+func TestCircularBlobAccessGetMalformed(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	blobAccess, dataFile := newInMemoryCircularBlobAccess(t, errorLogger)
+
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	// Corrupt the blob in the data file. Reading the blob should
+	// fail, and the blob should be deleted. This should be
+	// reported through the error logger.
+	dataFile.data[0] = 'J'
+	errorLogger.EXPECT().Log(status.Error(codes.Internal, "Blob \"8b1a9953c4611296a827abf8c47804d7-5-hello\" at offset 0 with length 5 was malformed"))
+
+	_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+	require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum bedad9eef4de4b391cc5aeb8ddbe6387, while 8b1a9953c4611296a827abf8c47804d7 was expected")), err)
+
+	_, err = blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+	require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
+}
+
+func TestCircularBlobAccessGetRange(t *testing.T) {
+	ctx := context.Background()
+	blobAccess, _ := newInMemoryCircularBlobAccess(t, util.DefaultErrorLogger)
+	rangeReadingBlobAccess := blobAccess.(blobstore.RangeReadingBlobAccess)
+
+	// Store a blob that is larger than a single chunk, so that
+	// reading a range of it requires multiple reads against the
+	// data store.
+	data := make([]byte, 200000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	hash := md5.Sum(data)
+	blobDigest := digest.MustNewDigest("hello", hex.EncodeToString(hash[:]), int64(len(data)))
+	require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(data)))
+
+	t.Run("Middle", func(t *testing.T) {
+		b := rangeReadingBlobAccess.GetRange(ctx, blobDigest, 1000, 150000)
+		sizeBytes, err := b.GetSizeBytes()
+		require.NoError(t, err)
+		require.Equal(t, int64(150000), sizeBytes)
+		rangeData, err := b.ToByteSlice(200000)
+		require.NoError(t, err)
+		require.Equal(t, data[1000:151000], rangeData)
+	})
+
+	t.Run("Clamped", func(t *testing.T) {
+		rangeData, err := rangeReadingBlobAccess.GetRange(ctx, blobDigest, 190000, 150000).ToByteSlice(200000)
+		require.NoError(t, err)
+		require.Equal(t, data[190000:], rangeData)
+	})
+
+	t.Run("OffsetTooLarge", func(t *testing.T) {
+		_, err := rangeReadingBlobAccess.GetRange(ctx, blobDigest, 200001, 10).ToByteSlice(200000)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Read offset 200001 exceeds blob size 200000"), err)
+	})
+}
+
+func TestCircularBlobAccessGetRangeCompressed(t *testing.T) {
+	ctx := context.Background()
+	stateStore, err := circular.NewFileStateStore(&memoryFile{}, 1024*1024)
+	require.NoError(t, err)
+	blobAccess := circular.NewCircularBlobAccess(
+		circular.NewFileOffsetStore(&memoryFile{}, 16*1024),
+		circular.NewFileDataStore(&memoryFile{}, 1024*1024),
+		stateStore,
+		blobstore.NewDecompressingReadBufferFactory(blobstore.CASReadBufferFactory),
+		1024,
+		buffer.NewTemporarySpillFile,
+		false,
+		nil,
+		util.DefaultErrorLogger,
+		nil)
+	rangeReadingBlobAccess := blobAccess.(blobstore.RangeReadingBlobAccess)
+
+	// Store a blob in compressed form. Its stored size differs
+	// from the size in its digest, meaning that ranges can only be
+	// obtained by decompressing the blob.
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	_, err = w.Write([]byte("Hello world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	blobDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(compressed.Bytes())))
+
+	data, err := rangeReadingBlobAccess.GetRange(ctx, blobDigest, 6, 100).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("world"), data)
+}
+
+func TestCircularBlobAccessFindMissingAndPresent(t *testing.T) {
+	ctx := context.Background()
+	blobAccess, _ := newInMemoryCircularBlobAccess(t, util.DefaultErrorLogger)
+	presenceReportingBlobAccess := blobAccess.(blobstore.PresenceReportingBlobAccess)
+
+	// Both the missing and the present blobs should be reported,
+	// as opposed to the set of present blobs being derived from the
+	// input incorrectly.
+	presentDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	missingDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	require.NoError(t, blobAccess.Put(ctx, presentDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	missing, present, err := presenceReportingBlobAccess.FindMissingAndPresent(ctx, digest.NewSetBuilder().Add(presentDigest).Add(missingDigest).Build())
+	require.NoError(t, err)
+	require.Equal(t, missingDigest.ToSingletonSet(), missing)
+	require.Equal(t, presentDigest.ToSingletonSet(), present)
+}
+
+func TestCircularBlobAccessListDigests(t *testing.T) {
+	ctx := context.Background()
+	blobAccess, _ := newInMemoryCircularBlobAccess(t, util.DefaultErrorLogger)
+	digestLister := blobAccess.(blobstore.DigestLister)
+	instanceName := digest.MustNewInstanceName("hello")
+
+	// Store blobs using different hashing algorithms. Blobs that
+	// are stored multiple times should only be reported once.
+	digest1 := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digest2 := digest.MustNewDigest("hello", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+	require.NoError(t, blobAccess.Put(ctx, digest1, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	require.NoError(t, blobAccess.Put(ctx, digest2, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	require.NoError(t, blobAccess.Put(ctx, digest1, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	var digests []digest.Digest
+	require.NoError(t, digestLister.ListDigests(ctx, instanceName, func(blobDigest digest.Digest) error {
+		digests = append(digests, blobDigest)
+		return nil
+	}))
+	require.ElementsMatch(t, []digest.Digest{digest1, digest2}, digests)
+
+	// Errors returned by the callback should stop iteration.
+	require.Equal(
+		t,
+		status.Error(codes.Internal, "Disk on fire"),
+		digestLister.ListDigests(ctx, instanceName, func(blobDigest digest.Digest) error {
+			return status.Error(codes.Internal, "Disk on fire")
+		}))
+}
+
+func TestCircularBlobAccessReadOnly(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	// Write a blob into storage.
+	offsetFile := &memoryFile{}
+	dataFile := &memoryFile{}
+	stateFile := &memoryFile{}
+	stateStore, err := circular.NewFileStateStore(stateFile, 1024*1024)
+	require.NoError(t, err)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	require.NoError(t, circular.NewCircularBlobAccess(
+		circular.NewFileOffsetStore(offsetFile, 16*1024),
+		circular.NewFileDataStore(dataFile, 1024*1024),
+		stateStore,
+		blobstore.CASReadBufferFactory,
+		1024,
+		buffer.NewTemporarySpillFile,
+		false,
+		nil,
+		util.DefaultErrorLogger,
+		nil).Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	// Reopen storage in read-only mode.
+	stateStore, err = circular.NewFileStateStore(circular.NewReadOnlyReadWriterAt(stateFile), 1024*1024)
+	require.NoError(t, err)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	blobAccess := circular.NewCircularBlobAccess(
+		circular.NewFileOffsetStore(circular.NewReadOnlyReadWriterAt(offsetFile), 16*1024),
+		circular.NewFileDataStore(circular.NewReadOnlyReadWriterAt(dataFile), 1024*1024),
+		circular.NewReadOnlyStateStore(stateStore),
+		blobstore.CASReadBufferFactory,
+		1024,
+		buffer.NewTemporarySpillFile,
+		false,
+		nil,
+		errorLogger,
+		nil)
+	stateFileContents := append([]byte(nil), stateFile.data...)
+
+	data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+
+	// Writes should be rejected.
+	require.Equal(
+		t,
+		status.Error(codes.FailedPrecondition, "Storage backend is read-only"),
+		blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	// Malformed blobs should only be reported, as opposed to being
+	// deleted.
+	dataFile.data[0] = 'J'
+	errorLogger.EXPECT().Log(status.Error(codes.Internal, "Blob \"8b1a9953c4611296a827abf8c47804d7-5-hello\" at offset 0 with length 5 was malformed")).Times(2)
+	for i := 0; i < 2; i++ {
+		_, err = blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum bedad9eef4de4b391cc5aeb8ddbe6387, while 8b1a9953c4611296a827abf8c47804d7 was expected")), err)
+	}
+	require.Equal(t, stateFileContents, stateFile.data)
+}
+
+func TestCircularBlobAccessReplaceIndex(t *testing.T) {
+	ctx := context.Background()
+	stateFile := &memoryFile{}
+	stateStore, err := circular.NewFileStateStore(stateFile, 1024*1024)
+	require.NoError(t, err)
+	blobAccess := circular.NewCircularBlobAccess(
+		circular.NewFileOffsetStore(&memoryFile{}, 16*1024),
+		circular.NewFileDataStore(&memoryFile{}, 1024*1024),
+		stateStore,
+		blobstore.CASReadBufferFactory,
+		1024,
+		buffer.NewTemporarySpillFile,
+		false,
+		nil,
+		util.DefaultErrorLogger,
+		nil)
+	indexReplacer := blobAccess.(circular.IndexReplacer)
+
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	t.Run("CursorsBehind", func(t *testing.T) {
+		// A state store whose write cursor lies before the
+		// current one could cause regions of the data store to
+		// be allocated twice. This must be rejected.
+		newStateStore, err := circular.NewFileStateStore(&memoryFile{}, 1024*1024)
+		require.NoError(t, err)
+		require.Equal(
+			t,
+			status.Error(codes.FailedPrecondition, "Cursors of the new state store (read 0, write 0) lie before the current cursors (read 0, write 5)"),
+			indexReplacer.ReplaceIndex(circular.NewFileOffsetStore(&memoryFile{}, 16*1024), newStateStore))
+
+		// The existing index should remain in use.
+		data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Swap in an empty offset store. The blob should no
+		// longer be visible, but writing it again should work.
+		newStateStore, err := circular.NewFileStateStore(stateFile.clone(), 1024*1024)
+		require.NoError(t, err)
+		require.NoError(t, indexReplacer.ReplaceIndex(circular.NewFileOffsetStore(&memoryFile{}, 16*1024), newStateStore))
+
+		_, err = blobAccess.Get(ctx, helloDigest).ToByteSlice(10)
+		require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
+
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+		data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+}
+
+func TestCircularBlobAccessRepairFromPeer(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	peer := mock.NewMockBlobAccess(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	stateStore, err := circular.NewFileStateStore(&memoryFile{}, 1024*1024)
+	require.NoError(t, err)
+	dataFile := &memoryFile{}
+	blobAccess := circular.NewCircularBlobAccess(
+		circular.NewFileOffsetStore(&memoryFile{}, 16*1024),
+		circular.NewFileDataStore(dataFile, 1024*1024),
+		stateStore,
+		blobstore.CASReadBufferFactory,
+		1024,
+		buffer.NewTemporarySpillFile,
+		false,
+		blobstore.NewPeerBlobRepairer(peer, clock.SystemClock, 0, time.Minute, errorLogger),
+		errorLogger,
+		nil)
+
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	// Corrupt the blob in the data file. Reading the blob should
+	// fail, causing it to be deleted and fetched from the peer.
+	dataFile.data[0] = 'J'
+	errorLogger.EXPECT().Log(status.Error(codes.Internal, "Blob \"8b1a9953c4611296a827abf8c47804d7-5-hello\" at offset 0 with length 5 was malformed"))
+	peer.EXPECT().Get(gomock.Any(), helloDigest).
+		Return(buffer.NewCASBufferFromByteSlice(helloDigest, []byte("Hello"), buffer.UserProvided))
+
+	_, err = blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+	require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum bedad9eef4de4b391cc5aeb8ddbe6387, while 8b1a9953c4611296a827abf8c47804d7 was expected")), err)
+
+	// The repair is performed in the background. Once completed,
+	// the blob should be readable again.
+	require.Eventually(t, func() bool {
+		missing, err := blobAccess.FindMissing(ctx, helloDigest.ToSingletonSet())
+		require.NoError(t, err)
+		return missing.Empty()
+	}, 10*time.Second, time.Millisecond)
+	data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+}
+
+func TestCircularBlobAccessRepairSynchronously(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	// Repairers may call back into the storage backend directly.
+	// This should not deadlock, as the lock of the storage backend
+	// is released prior to calling the repairer.
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	stateStore, err := circular.NewFileStateStore(&memoryFile{}, 1024*1024)
+	require.NoError(t, err)
+	dataFile := &memoryFile{}
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	repairs := 0
+	blobAccess := circular.NewCircularBlobAccess(
+		circular.NewFileOffsetStore(&memoryFile{}, 16*1024),
+		circular.NewFileDataStore(dataFile, 1024*1024),
+		stateStore,
+		blobstore.CASReadBufferFactory,
+		1024,
+		buffer.NewTemporarySpillFile,
+		false,
+		func(blobDigest digest.Digest, destination blobstore.BlobAccess) {
+			require.Equal(t, helloDigest, blobDigest)
+			require.NoError(t, destination.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+			repairs++
+		},
+		errorLogger,
+		nil)
+
+	require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	dataFile.data[0] = 'J'
+	errorLogger.EXPECT().Log(status.Error(codes.Internal, "Blob \"8b1a9953c4611296a827abf8c47804d7-5-hello\" at offset 0 with length 5 was malformed"))
+
+	_, err = blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+	require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum bedad9eef4de4b391cc5aeb8ddbe6387, while 8b1a9953c4611296a827abf8c47804d7 was expected")), err)
+	require.Equal(t, 1, repairs)
+
+	data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+}
+
+func TestCircularBlobAccessPutMetadata(t *testing.T) {
+	ctx := context.Background()
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	offsetStore := circular.NewFileOffsetStoreWithMetadata(&memoryFile{}, 16*1024)
+	stateStore, err := circular.NewFileStateStore(&memoryFile{}, 1024*1024)
+	require.NoError(t, err)
+	metadata := circular.BlobMetadata{
+		UploadTimestamp: time.Unix(1600000000, 0),
+		UploaderHash:    [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+	}
+	blobAccess := circular.NewCircularBlobAccess(
+		offsetStore,
+		circular.NewFileDataStore(&memoryFile{}, 1024*1024),
+		stateStore,
+		blobstore.CASReadBufferFactory,
+		1024,
+		buffer.NewTemporarySpillFile,
+		false,
+		nil,
+		util.DefaultErrorLogger,
+		func(ctx context.Context) circular.BlobMetadata {
+			return metadata
+		})
+
+	// Metadata obtained while writing the blob should be stored
+	// alongside it in the offset store.
+	require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	storedMetadata, found, err := offsetStore.GetMetadata(helloDigest, stateStore.GetCursors())
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, metadata, storedMetadata)
+}
@@ -0,0 +1,28 @@
+package circular
+
+import (
+	"context"
+)
+
+// CopyData copies length bytes of data stored at srcOffset to
+// dstOffset within a DataStore. The source and destination regions may
+// not overlap.
+//
+// If the DataStore implements CopyingDataStore, the copy is performed
+// natively. Otherwise, the data is read using Get() and written back
+// using Put().
+func CopyData(ctx context.Context, dataStore DataStore, srcOffset uint64, dstOffset uint64, length int64) error {
+	if copyingDataStore, ok := dataStore.(CopyingDataStore); ok {
+		return copyingDataStore.Copy(ctx, srcOffset, dstOffset, length)
+	}
+	return copyDataViaGetAndPut(ctx, dataStore, srcOffset, dstOffset, length)
+}
+
+// copyDataViaGetAndPut copies data within a DataStore by passing it
+// through the caller. This may be used by implementations of
+// CopyingDataStore in case native copying is unavailable.
+func copyDataViaGetAndPut(ctx context.Context, dataStore DataStore, srcOffset uint64, dstOffset uint64, length int64) error {
+	r := dataStore.Get(srcOffset, length)
+	defer r.Close()
+	return dataStore.Put(ctx, r, dstOffset)
+}
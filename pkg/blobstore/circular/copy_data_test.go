@@ -0,0 +1,79 @@
+package circular_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/circular"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyData(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Fallback", func(t *testing.T) {
+		// The data store returned by NewFileDataStore() has no
+		// native support for copying. Data should be copied
+		// using Get() and Put(), wrapping around at the end of
+		// the file on both sides.
+		dataStore := circular.NewFileDataStore(&memoryFile{}, 10)
+		require.NoError(t, dataStore.Put(ctx, bytes.NewBufferString("Hello"), 18))
+		require.NoError(t, circular.CopyData(ctx, dataStore, 18, 24, 5))
+
+		data, err := ioutil.ReadAll(dataStore.Get(24, 5))
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("File", func(t *testing.T) {
+		// Data stores backed by actual files may copy data
+		// natively (e.g., using copy_file_range() on Linux).
+		// This should also wrap around at the end of the file.
+		f, err := ioutil.TempFile("", "data")
+		require.NoError(t, err)
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		dataStore := circular.NewFileDataStore(f, 10)
+		require.NoError(t, dataStore.Put(ctx, bytes.NewBufferString("0123456789"), 0))
+		require.NoError(t, dataStore.Put(ctx, bytes.NewBufferString("Hello"), 18))
+		require.NoError(t, circular.CopyData(ctx, dataStore, 18, 24, 5))
+
+		data, err := ioutil.ReadAll(dataStore.Get(24, 5))
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("Native", func(t *testing.T) {
+		// Data stores that implement CopyingDataStore should
+		// have their Copy() method called.
+		dataStore := &fakeCopyingDataStore{
+			DataStore: circular.NewFileDataStore(&memoryFile{}, 10),
+		}
+		require.NoError(t, circular.CopyData(ctx, dataStore, 3, 7, 2))
+		require.Equal(t, []fakeCopy{{srcOffset: 3, dstOffset: 7, length: 2}}, dataStore.copies)
+	})
+}
+
+type fakeCopy struct {
+	srcOffset uint64
+	dstOffset uint64
+	length    int64
+}
+
+type fakeCopyingDataStore struct {
+	circular.DataStore
+	copies []fakeCopy
+}
+
+func (ds *fakeCopyingDataStore) Copy(ctx context.Context, srcOffset uint64, dstOffset uint64, length int64) error {
+	ds.copies = append(ds.copies, fakeCopy{
+		srcOffset: srcOffset,
+		dstOffset: dstOffset,
+		length:    length,
+	})
+	return nil
+}
@@ -0,0 +1,78 @@
+package circular
+
+import (
+	"io"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+)
+
+// dataStoreFileReader exposes a blob stored in a DataStore as a
+// filesystem.FileReader. This permits passing it to
+// ReadBufferFactory.NewBufferFromFileReader(), which, unlike
+// NewBufferFromReader(), also receives the size of the data as stored.
+//
+// As buffers tend to read data sequentially, a single reader obtained
+// from the DataStore is reused for as long as reads are contiguous.
+type dataStoreFileReader struct {
+	dataStore DataStore
+	offset    uint64
+	sizeBytes int64
+
+	lock    sync.Mutex
+	r       io.ReadCloser
+	rOffset int64
+}
+
+func newDataStoreFileReader(dataStore DataStore, offset uint64, sizeBytes int64) filesystem.FileReader {
+	return &dataStoreFileReader{
+		dataStore: dataStore,
+		offset:    offset,
+		sizeBytes: sizeBytes,
+	}
+}
+
+func (f *dataStoreFileReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.sizeBytes {
+		return 0, io.EOF
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.r == nil || f.rOffset != off {
+		if f.r != nil {
+			f.r.Close()
+		}
+		f.r = f.dataStore.Get(f.offset+uint64(off), f.sizeBytes-off)
+		f.rOffset = off
+	}
+
+	// Perform a short read at the end of the blob.
+	truncated := false
+	if remaining := f.sizeBytes - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+		truncated = true
+	}
+	n, err := io.ReadFull(f.r, p)
+	f.rOffset += int64(n)
+	if err != nil {
+		return n, err
+	}
+	if truncated {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *dataStoreFileReader) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.r == nil {
+		return nil
+	}
+	err := f.r.Close()
+	f.r = nil
+	return err
+}
@@ -33,11 +33,68 @@ func (os *demultiplexingOffsetStore) Get(digest digest.Digest, cursors Cursors)
 	return backend.Get(digest, cursors)
 }
 
-func (os *demultiplexingOffsetStore) Put(digest digest.Digest, offset uint64, length int64, cursors Cursors) error {
+func (os *demultiplexingOffsetStore) GetMany(digests []digest.Digest, cursors Cursors) ([]OffsetStoreGetResult, error) {
+	// Partition the digests by instance name, so that every
+	// backend receives a single batch.
+	type instanceBatch struct {
+		indices []int
+		digests []digest.Digest
+	}
+	var instances []string
+	batches := map[string]*instanceBatch{}
+	for i, blobDigest := range digests {
+		instance := blobDigest.GetInstanceName().String()
+		batch, ok := batches[instance]
+		if !ok {
+			batch = &instanceBatch{}
+			batches[instance] = batch
+			instances = append(instances, instance)
+		}
+		batch.indices = append(batch.indices, i)
+		batch.digests = append(batch.digests, blobDigest)
+	}
+
+	results := make([]OffsetStoreGetResult, len(digests))
+	for _, instance := range instances {
+		backend, err := os.offsetStoreGetter(instance)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Failed to obtain offset store for instance %#v", instance)
+		}
+		batch := batches[instance]
+		batchResults, err := backend.GetMany(batch.digests, cursors)
+		if err != nil {
+			return nil, err
+		}
+		for i, result := range batchResults {
+			results[batch.indices[i]] = result
+		}
+	}
+	return results, nil
+}
+
+func (os *demultiplexingOffsetStore) GetMetadata(digest digest.Digest, cursors Cursors) (BlobMetadata, bool, error) {
+	instance := digest.GetInstanceName().String()
+	backend, err := os.offsetStoreGetter(instance)
+	if err != nil {
+		return BlobMetadata{}, false, util.StatusWrapf(err, "Failed to obtain offset store for instance %#v", instance)
+	}
+	return backend.GetMetadata(digest, cursors)
+}
+
+func (os *demultiplexingOffsetStore) Put(digest digest.Digest, offset uint64, length int64, metadata BlobMetadata, cursors Cursors) error {
 	instance := digest.GetInstanceName().String()
 	backend, err := os.offsetStoreGetter(instance)
 	if err != nil {
 		return util.StatusWrapf(err, "Failed to obtain offset store for instance %#v", instance)
 	}
-	return backend.Put(digest, offset, length, cursors)
+	return backend.Put(digest, offset, length, metadata, cursors)
+}
+
+func (os *demultiplexingOffsetStore) Iterate(instanceName digest.InstanceName, cursors Cursors, callback func(digest digest.Digest) error) error {
+	instance := instanceName.String()
+	backend, err := os.offsetStoreGetter(instance)
+	if err != nil {
+		return util.StatusWrapf(err, "Failed to obtain offset store for instance %#v", instance)
+	}
+	return backend.Iterate(instanceName, cursors, callback)
 }
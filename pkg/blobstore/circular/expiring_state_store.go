@@ -0,0 +1,130 @@
+package circular
+
+import (
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+)
+
+// ExpiringStateStore is a StateStore that keeps track of the time at
+// which data was allocated, making it possible to expire data based on
+// its age, as opposed to only when the data file wraps around.
+type ExpiringStateStore interface {
+	StateStore
+
+	// ExpireOlderThan advances the read cursor past all data that
+	// was allocated before a given point in time. Blobs stored in
+	// this data are no longer contained in the cursors, causing
+	// them to be reported as absent.
+	ExpireOlderThan(t time.Time) error
+}
+
+type allocationRecord struct {
+	endOffset uint64
+	time      time.Time
+}
+
+type expiringStateStore struct {
+	base  StateStore
+	clock clock.Clock
+
+	lock        sync.Mutex
+	allocations []allocationRecord
+}
+
+// NewExpiringStateStore is an adapter for StateStore that records a
+// timestamp for every allocation. Records are discarded as soon as the
+// data they describe is no longer contained in the cursors, meaning
+// that memory usage is proportional to the number of blobs stored.
+//
+// Timestamps are not persisted. Data that is already present at the
+// time of creation is assumed to have been allocated at that time.
+//
+// As ExpireOlderThan() is typically called from a separate goroutine,
+// all calls against the underlying StateStore are serialized.
+func NewExpiringStateStore(base StateStore, clock clock.Clock) ExpiringStateStore {
+	ss := &expiringStateStore{
+		base:  base,
+		clock: clock,
+	}
+	if cursors := base.GetCursors(); cursors.Write > cursors.Read {
+		ss.allocations = append(ss.allocations, allocationRecord{
+			endOffset: cursors.Write,
+			time:      clock.Now(),
+		})
+	}
+	return ss
+}
+
+// pruneAllocations discards records of allocations that are no longer
+// contained in the cursors.
+func (ss *expiringStateStore) pruneAllocations() {
+	cursors := ss.base.GetCursors()
+	i := 0
+	for i < len(ss.allocations) && ss.allocations[i].endOffset <= cursors.Read {
+		i++
+	}
+	ss.allocations = ss.allocations[i:]
+}
+
+func (ss *expiringStateStore) GetCursors() Cursors {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	return ss.base.GetCursors()
+}
+
+func (ss *expiringStateStore) Allocate(sizeBytes int64) (uint64, error) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	offset, err := ss.base.Allocate(sizeBytes)
+	if err != nil {
+		return 0, err
+	}
+	ss.allocations = append(ss.allocations, allocationRecord{
+		endOffset: offset + uint64(sizeBytes),
+		time:      ss.clock.Now(),
+	})
+	ss.pruneAllocations()
+	return offset, nil
+}
+
+func (ss *expiringStateStore) Invalidate(offset uint64, sizeBytes int64) error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	err := ss.base.Invalidate(offset, sizeBytes)
+	ss.pruneAllocations()
+	return err
+}
+
+func (ss *expiringStateStore) ExpireOlderThan(t time.Time) error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	// Find the end of the newest allocation that was made before
+	// the provided point in time.
+	i := 0
+	for i < len(ss.allocations) && ss.allocations[i].time.Before(t) {
+		i++
+	}
+	if i == 0 {
+		return nil
+	}
+	endOffset := ss.allocations[i-1].endOffset
+	if cursors := ss.base.GetCursors(); endOffset <= cursors.Read {
+		ss.pruneAllocations()
+		return nil
+	}
+
+	// Invalidate the final byte of the allocation, as opposed to
+	// performing a zero-sized invalidation at its end. This
+	// ensures the read cursor ends up at the right offset, even if
+	// the underlying StateStore forces invalidations to be
+	// positive in size.
+	err := ss.base.Invalidate(endOffset-1, 1)
+	ss.pruneAllocations()
+	return err
+}
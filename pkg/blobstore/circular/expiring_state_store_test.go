@@ -0,0 +1,75 @@
+package circular_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/circular"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiringStateStore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	baseStateStore, err := circular.NewFileStateStore(&memoryFile{}, 100)
+	require.NoError(t, err)
+	clock := mock.NewMockClock(ctrl)
+	stateStore := circular.NewExpiringStateStore(baseStateStore, clock)
+
+	// Perform a couple of allocations at different points in time.
+	clock.EXPECT().Now().Return(time.Unix(1000, 0))
+	offset, err := stateStore.Allocate(10)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offset)
+	clock.EXPECT().Now().Return(time.Unix(1010, 0))
+	offset, err = stateStore.Allocate(20)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), offset)
+	clock.EXPECT().Now().Return(time.Unix(1020, 0))
+	offset, err = stateStore.Allocate(5)
+	require.NoError(t, err)
+	require.Equal(t, uint64(30), offset)
+	require.Equal(t, circular.Cursors{Read: 0, Write: 35}, stateStore.GetCursors())
+
+	// Expiring data should only move the read cursor past
+	// allocations made before the provided point in time.
+	require.NoError(t, stateStore.ExpireOlderThan(time.Unix(1000, 0)))
+	require.Equal(t, circular.Cursors{Read: 0, Write: 35}, stateStore.GetCursors())
+	require.NoError(t, stateStore.ExpireOlderThan(time.Unix(1015, 0)))
+	require.Equal(t, circular.Cursors{Read: 30, Write: 35}, stateStore.GetCursors())
+	require.NoError(t, stateStore.ExpireOlderThan(time.Unix(1015, 0)))
+	require.Equal(t, circular.Cursors{Read: 30, Write: 35}, stateStore.GetCursors())
+
+	// Once data is overwritten due to wraparound, expiring it
+	// should have no effect.
+	clock.EXPECT().Now().Return(time.Unix(1030, 0))
+	offset, err = stateStore.Allocate(100)
+	require.NoError(t, err)
+	require.Equal(t, uint64(35), offset)
+	require.Equal(t, circular.Cursors{Read: 35, Write: 135}, stateStore.GetCursors())
+	require.NoError(t, stateStore.ExpireOlderThan(time.Unix(1025, 0)))
+	require.Equal(t, circular.Cursors{Read: 35, Write: 135}, stateStore.GetCursors())
+	require.NoError(t, stateStore.ExpireOlderThan(time.Unix(1031, 0)))
+	require.Equal(t, circular.Cursors{Read: 135, Write: 135}, stateStore.GetCursors())
+}
+
+func TestExpiringStateStoreExistingData(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Data that is already present upon creation should be treated
+	// as if it was allocated at the time of creation.
+	baseStateStore, err := circular.NewFileStateStore(&memoryFile{}, 100)
+	require.NoError(t, err)
+	_, err = baseStateStore.Allocate(40)
+	require.NoError(t, err)
+	clock := mock.NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(time.Unix(1000, 0))
+	stateStore := circular.NewExpiringStateStore(baseStateStore, clock)
+
+	require.NoError(t, stateStore.ExpireOlderThan(time.Unix(1000, 0)))
+	require.Equal(t, circular.Cursors{Read: 0, Write: 40}, stateStore.GetCursors())
+	require.NoError(t, stateStore.ExpireOlderThan(time.Unix(1001, 0)))
+	require.Equal(t, circular.Cursors{Read: 40, Write: 40}, stateStore.GetCursors())
+}
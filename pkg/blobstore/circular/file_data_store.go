@@ -1,7 +1,10 @@
 package circular
 
 import (
+	"context"
 	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
 )
 
 type fileDataStore struct {
@@ -13,6 +16,9 @@ type fileDataStore struct {
 // All data is stored in a single file, where all blobs are concatenated
 // directly. As the file pointer wraps around at a configured size, old
 // data is automatically overwritten by new data.
+//
+// On Linux, the store implements CopyingDataStore for files that
+// expose their file descriptor, using copy_file_range().
 func NewFileDataStore(file ReadWriterAt, size uint64) DataStore {
 	return &fileDataStore{
 		file: file,
@@ -20,8 +26,15 @@ func NewFileDataStore(file ReadWriterAt, size uint64) DataStore {
 	}
 }
 
-func (ds *fileDataStore) Put(r io.Reader, offset uint64) error {
+func (ds *fileDataStore) Put(ctx context.Context, r io.Reader, offset uint64) error {
 	for {
+		// Stop writing if the caller is no longer interested
+		// in the results, so that a stalled writer does not
+		// keep the allocated region occupied indefinitely.
+		if err := util.StatusFromContext(ctx); err != nil {
+			return err
+		}
+
 		// Read data. If at the end of the storage file, limit
 		// the size to ensure proper wrap-around.
 		writeOffset := offset % ds.size
@@ -47,7 +60,7 @@ func (ds *fileDataStore) Put(r io.Reader, offset uint64) error {
 	}
 }
 
-func (ds *fileDataStore) Get(offset uint64, size int64) io.Reader {
+func (ds *fileDataStore) Get(offset uint64, size int64) io.ReadCloser {
 	return &fileDataStoreReader{
 		ds:     ds,
 		offset: offset,
@@ -87,3 +100,7 @@ func (f *fileDataStoreReader) Read(b []byte) (n int, err error) {
 	f.size -= readLength
 	return int(readLength), nil
 }
+
+func (f *fileDataStoreReader) Close() error {
+	return nil
+}
@@ -0,0 +1,57 @@
+// +build linux
+
+package circular
+
+import (
+	"context"
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"golang.org/x/sys/unix"
+)
+
+// Copy data within the data file using copy_file_range(). This
+// prevents data from being copied into userspace, and permits file
+// systems that support reflinks to share the underlying storage.
+func (ds *fileDataStore) Copy(ctx context.Context, srcOffset uint64, dstOffset uint64, length int64) error {
+	f, ok := ds.file.(interface{ Fd() uintptr })
+	if !ok {
+		return copyDataViaGetAndPut(ctx, ds, srcOffset, dstOffset, length)
+	}
+	fd := int(f.Fd())
+	for remaining := uint64(length); remaining > 0; {
+		if err := util.StatusFromContext(ctx); err != nil {
+			return err
+		}
+
+		// Limit the size of the copy to ensure proper
+		// wrap-around at the end of the storage file, on both
+		// the reading and the writing side.
+		readOffset := int64(srcOffset % ds.size)
+		writeOffset := int64(dstOffset % ds.size)
+		copyLength := remaining
+		if copyLength > ds.size-uint64(readOffset) {
+			copyLength = ds.size - uint64(readOffset)
+		}
+		if copyLength > ds.size-uint64(writeOffset) {
+			copyLength = ds.size - uint64(writeOffset)
+		}
+
+		n, err := unix.CopyFileRange(fd, &readOffset, fd, &writeOffset, int(copyLength), 0)
+		if err == unix.ENOSYS || err == unix.EXDEV || err == unix.EOPNOTSUPP {
+			// The kernel or file system does not support
+			// copy_file_range(). Copy the remaining data
+			// through userspace.
+			return copyDataViaGetAndPut(ctx, ds, srcOffset, dstOffset, int64(remaining))
+		} else if err != nil {
+			return util.StatusWrapf(err, "Failed to copy data from offset %d to offset %d", readOffset, writeOffset)
+		} else if n == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		srcOffset += uint64(n)
+		dstOffset += uint64(n)
+		remaining -= uint64(n)
+	}
+	return nil
+}
@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
+	"sort"
 	"sync"
 
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -48,25 +52,33 @@ var (
 )
 
 // offsetRecord contains the hash table entries written to disk. They
-// consist of four components:
+// consist of five components:
 //
 // - A simple digest of the blob (hash and size),
 // - The attempt (i.e., how many times this entry got pushed to its next
 //   preferential slot in the hash table).
 // - The offset of the blob's data within the data file.
 // - The length of the blob's data within the data file.
+// - Optionally, the metadata of the blob.
 //
 // The attempt is part of the record, as it makes it possible to
 // distinguish a record from random garbage data. It allows us to
 // validate that an entry could have been stored in that location in the
 // first place.
-type offsetRecord [len(simpleDigest{}) + 4 + 8 + 8]byte
+//
+// The metadata is placed at the end of the record, so that offset
+// stores that don't store metadata can simply truncate records.
+type offsetRecord [offsetRecordSizeBytesWithoutMetadata + len(blobMetadataRecord{})]byte
+
+const offsetRecordSizeBytesWithoutMetadata = len(simpleDigest{}) + 4 + 8 + 8
 
-func newOffsetRecord(digest simpleDigest, offset uint64, length int64) offsetRecord {
+func newOffsetRecord(digest simpleDigest, offset uint64, length int64, metadata BlobMetadata) offsetRecord {
 	var offsetRecord offsetRecord
 	copy(offsetRecord[:], digest[:])
 	binary.LittleEndian.PutUint64(offsetRecord[len(simpleDigest{})+4:], offset)
 	binary.LittleEndian.PutUint64(offsetRecord[len(simpleDigest{})+4+8:], uint64(length))
+	metadataRecord := newBlobMetadataRecord(metadata)
+	copy(offsetRecord[offsetRecordSizeBytesWithoutMetadata:], metadataRecord[:])
 	return offsetRecord
 }
 
@@ -95,6 +107,12 @@ func (or *offsetRecord) getLength() int64 {
 	return int64(binary.LittleEndian.Uint64(or[len(simpleDigest{})+4+8:]))
 }
 
+func (or *offsetRecord) getMetadata() BlobMetadata {
+	var metadataRecord blobMetadataRecord
+	copy(metadataRecord[:], or[offsetRecordSizeBytesWithoutMetadata:])
+	return metadataRecord.toBlobMetadata()
+}
+
 func (or *offsetRecord) digestAndAttemptEqual(other offsetRecord) bool {
 	return bytes.Equal(or[:len(simpleDigest{})+4], other[:len(simpleDigest{})+4])
 }
@@ -106,8 +124,9 @@ func (or *offsetRecord) withAttempt(attempt uint32) offsetRecord {
 }
 
 type fileOffsetStore struct {
-	file ReadWriterAt
-	size uint64
+	file            ReadWriterAt
+	size            uint64
+	recordSizeBytes uint64
 }
 
 // NewFileOffsetStore creates a file-based accessor for the offset
@@ -119,63 +138,144 @@ type fileOffsetStore struct {
 // approach, where objects may only be displaced to less preferential
 // slots by objects with a higher offset. In other words, more recently
 // stored blobs displace older ones.
+//
+// Metadata provided to Put() is discarded. GetMetadata() returns an
+// error with code UNIMPLEMENTED.
 func NewFileOffsetStore(file ReadWriterAt, size uint64) OffsetStore {
+	return newFileOffsetStore(file, size, uint64(offsetRecordSizeBytesWithoutMetadata))
+}
+
+// NewFileOffsetStoreWithMetadata is identical to NewFileOffsetStore(),
+// except that every entry in the offset file is extended to hold a
+// BlobMetadata record. This increases the size of entries, meaning
+// fewer entries fit in an offset file of a given size.
+//
+// The layout of the offset file differs from the one used by
+// NewFileOffsetStore(). Entries written using one layout are not
+// recognized by the other, meaning that switching between them causes
+// the offset file to be treated as empty.
+func NewFileOffsetStoreWithMetadata(file ReadWriterAt, size uint64) OffsetStore {
+	return newFileOffsetStore(file, size, uint64(len(offsetRecord{})))
+}
+
+func newFileOffsetStore(file ReadWriterAt, size, recordSizeBytes uint64) OffsetStore {
 	operationsPrometheusMetrics.Do(func() {
 		prometheus.MustRegister(operationsIterations)
 	})
 
 	return &fileOffsetStore{
-		file: file,
-		size: size,
+		file:            file,
+		size:            size,
+		recordSizeBytes: recordSizeBytes,
 	}
 }
 
 // getPositionOfSlot computes the location at which a hash table slot is
 // stored within the offset file.
 func (os *fileOffsetStore) getPositionOfSlot(slot uint32) int64 {
-	recordLen := uint64(len(offsetRecord{}))
-	return int64((uint64(slot) % (os.size / recordLen)) * recordLen)
+	return int64((uint64(slot) % (os.size / os.recordSizeBytes)) * os.recordSizeBytes)
 }
 
 func (os *fileOffsetStore) getRecordAtPosition(position int64) (offsetRecord, error) {
 	var record offsetRecord
-	if _, err := os.file.ReadAt(record[:], position); err != nil && err != io.EOF {
+	if _, err := os.file.ReadAt(record[:os.recordSizeBytes], position); err != nil && err != io.EOF {
 		return record, err
 	}
 	return record, nil
 }
 
 func (os *fileOffsetStore) putRecordAtPosition(record offsetRecord, position int64) error {
-	_, err := os.file.WriteAt(record[:], position)
+	_, err := os.file.WriteAt(record[:os.recordSizeBytes], position)
 	return err
 }
 
 func (os *fileOffsetStore) Get(digest digest.Digest, cursors Cursors) (uint64, int64, bool, error) {
-	record := newOffsetRecord(newSimpleDigest(digest), 0, 0)
+	record, found, iterations, result, err := os.lookup(newSimpleDigest(digest), cursors)
+	result.Observe(float64(iterations))
+	if err != nil || !found {
+		return 0, 0, false, err
+	}
+	return record.getOffset(), record.getLength(), true, nil
+}
+
+func (os *fileOffsetStore) GetMetadata(digest digest.Digest, cursors Cursors) (BlobMetadata, bool, error) {
+	if os.recordSizeBytes < uint64(len(offsetRecord{})) {
+		return BlobMetadata{}, false, status.Error(codes.Unimplemented, "Offset store is not configured to store blob metadata")
+	}
+	record, found, iterations, result, err := os.lookup(newSimpleDigest(digest), cursors)
+	result.Observe(float64(iterations))
+	if err != nil || !found {
+		return BlobMetadata{}, false, err
+	}
+	return record.getMetadata(), true, nil
+}
+
+func (os *fileOffsetStore) GetMany(digests []digest.Digest, cursors Cursors) ([]OffsetStoreGetResult, error) {
+	// Perform lookups in the order in which their preferential
+	// slots are stored in the offsets file. This causes the file
+	// to be accessed sequentially, as opposed to randomly.
+	type pendingLookup struct {
+		index    int
+		digest   simpleDigest
+		position int64
+	}
+	lookups := make([]pendingLookup, 0, len(digests))
+	for i, blobDigest := range digests {
+		sd := newSimpleDigest(blobDigest)
+		record := newOffsetRecord(sd, 0, 0, BlobMetadata{})
+		lookups = append(lookups, pendingLookup{
+			index:    i,
+			digest:   sd,
+			position: os.getPositionOfSlot(record.getSlot()),
+		})
+	}
+	sort.Slice(lookups, func(i, j int) bool {
+		return lookups[i].position < lookups[j].position
+	})
+
+	results := make([]OffsetStoreGetResult, len(digests))
+	for _, lookup := range lookups {
+		record, found, iterations, result, err := os.lookup(lookup.digest, cursors)
+		result.Observe(float64(iterations))
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			results[lookup.index] = OffsetStoreGetResult{
+				Offset: record.getOffset(),
+				Length: record.getLength(),
+				Found:  true,
+			}
+		}
+	}
+	return results, nil
+}
+
+// lookup searches the hash table for the record of a digest. In
+// addition to the record and whether it was found, it returns the
+// number of iterations performed and the metric that should be used to
+// report them.
+func (os *fileOffsetStore) lookup(digest simpleDigest, cursors Cursors) (offsetRecord, bool, uint32, prometheus.Observer, error) {
+	record := newOffsetRecord(digest, 0, 0, BlobMetadata{})
 	for iteration := uint32(1); ; iteration++ {
 		if iteration >= maximumIterations {
-			operationsIterationsGetTooManyIterations.Observe(float64(iteration))
-			return 0, 0, false, nil
+			return offsetRecord{}, false, iteration, operationsIterationsGetTooManyIterations, nil
 		}
 
 		lookupRecord := record.withAttempt(iteration - 1)
 		position := os.getPositionOfSlot(lookupRecord.getSlot())
 		storedRecord, err := os.getRecordAtPosition(position)
 		if err != nil {
-			operationsIterationsGetError.Observe(float64(iteration))
-			return 0, 0, false, err
+			return offsetRecord{}, false, iteration, operationsIterationsGetError, err
 		}
 		if !cursors.Contains(storedRecord.getOffset(), storedRecord.getLength()) {
-			operationsIterationsGetNotFound.Observe(float64(iteration))
-			return 0, 0, false, nil
+			return offsetRecord{}, false, iteration, operationsIterationsGetNotFound, nil
 		}
 		if storedRecord.digestAndAttemptEqual(lookupRecord) {
-			operationsIterationsGetSuccess.Observe(float64(iteration))
-			return storedRecord.getOffset(), storedRecord.getLength(), true, nil
+			return storedRecord, true, iteration, operationsIterationsGetSuccess, nil
 		}
 		if os.getPositionOfSlot(storedRecord.getSlot()) != position {
-			operationsIterationsGetNotFound.Observe(float64(iteration))
-			return 0, 0, false, nil
+			return offsetRecord{}, false, iteration, operationsIterationsGetNotFound, nil
 		}
 	}
 }
@@ -213,10 +313,10 @@ func (os *fileOffsetStore) putRecord(record offsetRecord, cursors Cursors) (offs
 	return record.withAttempt(attempt + 1), true, nil
 }
 
-func (os *fileOffsetStore) Put(digest digest.Digest, offset uint64, length int64, cursors Cursors) error {
+func (os *fileOffsetStore) Put(digest digest.Digest, offset uint64, length int64, metadata BlobMetadata, cursors Cursors) error {
 	// Insert the new record. Doing this may yield another that got
 	// displaced. Iteratively try to re-insert those.
-	record := newOffsetRecord(newSimpleDigest(digest), offset, length)
+	record := newOffsetRecord(newSimpleDigest(digest), offset, length, metadata)
 	for iteration := 1; ; iteration++ {
 		if iteration > maximumIterations {
 			operationsIterationsPutTooManyIterations.Observe(float64(iteration))
@@ -234,3 +334,42 @@ func (os *fileOffsetStore) Put(digest digest.Digest, offset uint64, length int64
 		}
 	}
 }
+
+func (os *fileOffsetStore) Iterate(instanceName digest.InstanceName, cursors Cursors, callback func(digest digest.Digest) error) error {
+	for slot := uint64(0); slot < os.size/os.recordSizeBytes; slot++ {
+		// Skip records that refer to data outside the valid
+		// region, or that could not have been stored at this
+		// position in the first place (e.g., garbage).
+		position := int64(slot * os.recordSizeBytes)
+		record, err := os.getRecordAtPosition(position)
+		if err != nil {
+			return err
+		}
+		if !cursors.Contains(record.getOffset(), record.getLength()) ||
+			record.getAttempt() >= maximumIterations ||
+			os.getPositionOfSlot(record.getSlot()) != position {
+			continue
+		}
+		var sd simpleDigest
+		copy(sd[:], record[:])
+		blobDigest, ok := sd.toDigest(instanceName)
+		if !ok {
+			continue
+		}
+
+		// The same blob may be stored multiple times. Only
+		// report the record that Get() would return. This also
+		// discards records that were read while being
+		// overwritten by a concurrent call to Put().
+		storedRecord, found, _, _, err := os.lookup(sd, cursors)
+		if err != nil {
+			return err
+		}
+		if found && storedRecord.getOffset() == record.getOffset() {
+			if err := callback(blobDigest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
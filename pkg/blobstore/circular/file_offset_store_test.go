@@ -0,0 +1,183 @@
+package circular_test
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/circular"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestDigests creates a list of distinct digests that may be used to
+// populate an OffsetStore.
+func newTestDigests(instanceNames []string, count int) []digest.Digest {
+	digests := make([]digest.Digest, 0, count)
+	for i := 0; i < count; i++ {
+		var seed [8]byte
+		binary.LittleEndian.PutUint64(seed[:], uint64(i))
+		hash := sha256.Sum256(seed[:])
+		digests = append(digests, digest.MustNewDigest(instanceNames[i%len(instanceNames)], hex.EncodeToString(hash[:]), int64(i+1)))
+	}
+	return digests
+}
+
+func TestFileOffsetStoreGetMany(t *testing.T) {
+	for name, offsetStore := range map[string]circular.OffsetStore{
+		"File":             circular.NewFileOffsetStore(&memoryFile{}, 1024*1024),
+		"FileWithMetadata": circular.NewFileOffsetStoreWithMetadata(&memoryFile{}, 1024*1024),
+		"Caching": circular.NewCachingOffsetStore(
+			circular.NewFileOffsetStore(&memoryFile{}, 1024*1024),
+			16),
+		"Demultiplexing": func() circular.OffsetStore {
+			offsetStores := map[string]circular.OffsetStore{}
+			return circular.NewDemultiplexingOffsetStore(func(instanceName string) (circular.OffsetStore, error) {
+				offsetStore, ok := offsetStores[instanceName]
+				if !ok {
+					offsetStore = circular.NewFileOffsetStore(&memoryFile{}, 1024*1024)
+					offsetStores[instanceName] = offsetStore
+				}
+				return offsetStore, nil
+			})
+		}(),
+	} {
+		offsetStore := offsetStore
+		t.Run(name, func(t *testing.T) {
+			// Only store every other digest. Results of
+			// GetMany() should be identical to those of
+			// calling Get() for every digest.
+			cursors := circular.Cursors{Read: 0, Write: 100000}
+			digests := newTestDigests([]string{"a", "b", "c"}, 100)
+			for i := 0; i < len(digests); i += 2 {
+				require.NoError(t, offsetStore.Put(digests[i], uint64(i*100), int64(i+1), circular.BlobMetadata{}, cursors))
+			}
+
+			results, err := offsetStore.GetMany(digests, cursors)
+			require.NoError(t, err)
+			require.Len(t, results, len(digests))
+			for i, blobDigest := range digests {
+				offset, length, found, err := offsetStore.Get(blobDigest, cursors)
+				require.NoError(t, err)
+				require.Equal(t, circular.OffsetStoreGetResult{
+					Offset: offset,
+					Length: length,
+					Found:  found,
+				}, results[i])
+				require.Equal(t, i%2 == 0, found)
+			}
+		})
+	}
+}
+
+func TestFileOffsetStoreGetMetadata(t *testing.T) {
+	digests := newTestDigests([]string{""}, 2)
+	metadata := circular.BlobMetadata{
+		UploadTimestamp: time.Unix(1600000000, 0),
+		UploaderHash:    [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+	}
+
+	t.Run("WithoutMetadata", func(t *testing.T) {
+		// The default layout of the offset file has no space
+		// for metadata.
+		cursors := circular.Cursors{Read: 0, Write: 1000}
+		offsetStore := circular.NewFileOffsetStore(&memoryFile{}, 1024*1024)
+		require.NoError(t, offsetStore.Put(digests[0], 100, 1, metadata, cursors))
+
+		_, _, err := offsetStore.GetMetadata(digests[0], cursors)
+		require.Equal(t, status.Error(codes.Unimplemented, "Offset store is not configured to store blob metadata"), err)
+	})
+
+	t.Run("WithMetadata", func(t *testing.T) {
+		cursors := circular.Cursors{Read: 0, Write: 1000}
+		offsetStore := circular.NewFileOffsetStoreWithMetadata(&memoryFile{}, 1024*1024)
+		require.NoError(t, offsetStore.Put(digests[0], 100, 1, metadata, cursors))
+
+		storedMetadata, found, err := offsetStore.GetMetadata(digests[0], cursors)
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, metadata, storedMetadata)
+
+		// Storing metadata should not affect regular lookups.
+		offset, length, found, err := offsetStore.Get(digests[0], cursors)
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, uint64(100), offset)
+		require.Equal(t, int64(1), length)
+
+		_, found, err = offsetStore.GetMetadata(digests[1], cursors)
+		require.NoError(t, err)
+		require.False(t, found)
+
+		// Metadata should be invalidated together with the
+		// blob once the read cursor advances past its data.
+		_, found, err = offsetStore.GetMetadata(digests[0], circular.Cursors{Read: 200, Write: 1200})
+		require.NoError(t, err)
+		require.False(t, found)
+	})
+}
+
+func TestFileOffsetStoreIterate(t *testing.T) {
+	cursors := circular.Cursors{Read: 0, Write: 1000}
+	offsetStore := circular.NewFileOffsetStore(&memoryFile{}, 1024*1024)
+	instanceName := digest.MustNewInstanceName("hello")
+
+	// Sizes of 4 GiB and more should be preserved.
+	largeDigest := digest.MustNewDigest("hello", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5*1024*1024*1024)
+	require.NoError(t, offsetStore.Put(largeDigest, 100, 1, circular.BlobMetadata{}, cursors))
+
+	// Hashes that are longer than SHA-256 are truncated when
+	// stored. They should not be reported as SHA-256 digests.
+	sha512Digest := digest.MustNewDigest("hello", "3615f80c9d293ed7402687f94b22d58e529b8cc7916f8fac7fddf7fbd5af4cf777d3d795a7a00a16bf7e7f3fb9561ee9baae480da9fe7a18769e71886b03f315", 5)
+	require.NoError(t, offsetStore.Put(sha512Digest, 200, 1, circular.BlobMetadata{}, cursors))
+
+	var digests []digest.Digest
+	require.NoError(t, offsetStore.Iterate(instanceName, cursors, func(blobDigest digest.Digest) error {
+		digests = append(digests, blobDigest)
+		return nil
+	}))
+	require.Equal(t, []digest.Digest{largeDigest}, digests)
+
+	// The truncated digest should still be retrievable.
+	offset, _, found, err := offsetStore.Get(sha512Digest, cursors)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(200), offset)
+}
+
+// BenchmarkFileOffsetStoreFindMissing compares the time spent looking
+// up digests one by one against the time spent by GetMany(), for a
+// request of the size that is typically sent by FindMissing() calls
+// for large builds. The lock of the circular storage backend is held
+// during this time.
+func BenchmarkFileOffsetStoreFindMissing(b *testing.B) {
+	cursors := circular.Cursors{Read: 0, Write: 1 << 40}
+	offsetStore := circular.NewFileOffsetStore(&memoryFile{}, 64*1024*1024)
+	digests := newTestDigests([]string{""}, 5000)
+	for i := 0; i < len(digests); i += 2 {
+		require.NoError(b, offsetStore.Put(digests[i], uint64(i*100), int64(i+1), circular.BlobMetadata{}, cursors))
+	}
+
+	b.Run("Get", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, blobDigest := range digests {
+				if _, _, _, err := offsetStore.Get(blobDigest, cursors); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("GetMany", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			if _, err := offsetStore.GetMany(digests, cursors); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
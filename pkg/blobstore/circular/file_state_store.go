@@ -2,37 +2,78 @@ package circular
 
 import (
 	"encoding/binary"
+	"hash/crc32"
 	"io"
 	"log"
 )
 
+const (
+	// The size of a single generation of cursors stored in the state
+	// file: a generation number, the read and write cursors and a
+	// checksum over the former.
+	stateRecordSize = 8 + 8 + 8 + 4
+	// The number of bytes at which generations of cursors are stored
+	// in the state file.
+	stateRecordStride = 32
+)
+
 type fileStateStore struct {
-	file     ReadWriterAt
-	dataSize uint64
-	cursors  Cursors
+	file       ReadWriterAt
+	dataSize   uint64
+	cursors    Cursors
+	generation uint64
+}
+
+// readStateRecord reads a single generation of cursors from the state
+// file. It returns false in case the record is absent, torn or
+// otherwise corrupted.
+func readStateRecord(file ReadWriterAt, slot int) (uint64, Cursors, bool, error) {
+	var data [stateRecordSize]byte
+	if n, err := file.ReadAt(data[:], int64(slot*stateRecordStride)); err == io.EOF {
+		if n < len(data) {
+			return 0, Cursors{}, false, nil
+		}
+	} else if err != nil {
+		return 0, Cursors{}, false, err
+	}
+	if crc32.ChecksumIEEE(data[:24]) != binary.LittleEndian.Uint32(data[24:]) {
+		return 0, Cursors{}, false, nil
+	}
+	generation := binary.LittleEndian.Uint64(data[:])
+	cursors := Cursors{
+		Read:  binary.LittleEndian.Uint64(data[8:]),
+		Write: binary.LittleEndian.Uint64(data[16:]),
+	}
+	if generation == 0 || cursors.Read > cursors.Write {
+		return 0, Cursors{}, false, nil
+	}
+	return generation, cursors, true, nil
 }
 
 // NewFileStateStore creates a new storage for global metadata of a
 // circular storage backend. Right now only a set of read/write cursors
 // are stored.
+//
+// Cursors are written to one of two slots in an alternating fashion.
+// Each slot is protected by a checksum. This ensures that a crash
+// while writing cursors always leaves the previous generation of
+// cursors intact. Upon startup, the newest valid generation is used.
 func NewFileStateStore(file ReadWriterAt, dataSize uint64) (StateStore, error) {
-	var cursors Cursors
-	var data [16]byte
-	if _, err := file.ReadAt(data[:], 0); err == nil {
-		readCursor := binary.LittleEndian.Uint64(data[:])
-		writeCursor := binary.LittleEndian.Uint64(data[8:])
-		if readCursor <= writeCursor {
-			cursors.Read = readCursor
-			cursors.Write = writeCursor
-		}
-	} else if err != io.EOF {
-		return nil, err
-	}
-	return &fileStateStore{
+	ss := &fileStateStore{
 		file:     file,
 		dataSize: dataSize,
-		cursors:  cursors,
-	}, nil
+	}
+	for slot := 0; slot < 2; slot++ {
+		generation, cursors, ok, err := readStateRecord(file, slot)
+		if err != nil {
+			return nil, err
+		}
+		if ok && generation > ss.generation {
+			ss.generation = generation
+			ss.cursors = cursors
+		}
+	}
+	return ss, nil
 }
 
 func (ss *fileStateStore) GetCursors() Cursors {
@@ -40,19 +81,24 @@ func (ss *fileStateStore) GetCursors() Cursors {
 }
 
 func (ss *fileStateStore) put(cursors Cursors) error {
-	// Store cursors.
+	// Store cursors in the slot that does not contain the current
+	// generation.
 	if cursors.Read > cursors.Write {
 		log.Fatalf("Attempted to write cursors %d > %d", cursors.Read, cursors.Write)
 	}
-	var data [16]byte
-	binary.LittleEndian.PutUint64(data[:], cursors.Read)
-	binary.LittleEndian.PutUint64(data[8:], cursors.Write)
-	if _, err := ss.file.WriteAt(data[:], 0); err != nil {
+	generation := ss.generation + 1
+	var data [stateRecordSize]byte
+	binary.LittleEndian.PutUint64(data[:], generation)
+	binary.LittleEndian.PutUint64(data[8:], cursors.Read)
+	binary.LittleEndian.PutUint64(data[16:], cursors.Write)
+	binary.LittleEndian.PutUint32(data[24:], crc32.ChecksumIEEE(data[:24]))
+	if _, err := ss.file.WriteAt(data[:], int64(generation%2)*stateRecordStride); err != nil {
 		return err
 	}
 
 	// Cache cursors for future GetCursors() calls.
 	ss.cursors = cursors
+	ss.generation = generation
 	return nil
 }
 
@@ -0,0 +1,116 @@
+package circular_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/circular"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryFile is a simple in-memory implementation of ReadWriterAt.
+type memoryFile struct {
+	data []byte
+}
+
+func (f *memoryFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memoryFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+	return copy(f.data[off:], p), nil
+}
+
+func (f *memoryFile) clone() *memoryFile {
+	return &memoryFile{data: append([]byte(nil), f.data...)}
+}
+
+func TestFileStateStoreReopen(t *testing.T) {
+	f := &memoryFile{}
+	stateStore, err := circular.NewFileStateStore(f, 100)
+	require.NoError(t, err)
+	require.Equal(t, circular.Cursors{}, stateStore.GetCursors())
+
+	offset, err := stateStore.Allocate(30)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offset)
+	offset, err = stateStore.Allocate(80)
+	require.NoError(t, err)
+	require.Equal(t, uint64(30), offset)
+	require.Equal(t, circular.Cursors{Read: 10, Write: 110}, stateStore.GetCursors())
+
+	// Reopening the state file should yield the latest cursors.
+	stateStore, err = circular.NewFileStateStore(f, 100)
+	require.NoError(t, err)
+	require.Equal(t, circular.Cursors{Read: 10, Write: 110}, stateStore.GetCursors())
+
+	// Writes after reopening should also be persisted.
+	require.NoError(t, stateStore.Invalidate(10, 50))
+	stateStore, err = circular.NewFileStateStore(f, 100)
+	require.NoError(t, err)
+	require.Equal(t, circular.Cursors{Read: 60, Write: 110}, stateStore.GetCursors())
+}
+
+func TestFileStateStoreCrashRecovery(t *testing.T) {
+	// Perform a series of allocations, capturing the contents of
+	// the state file after every write.
+	f := &memoryFile{}
+	stateStore, err := circular.NewFileStateStore(f, 100)
+	require.NoError(t, err)
+	history := []circular.Cursors{stateStore.GetCursors()}
+	snapshots := []*memoryFile{f.clone()}
+	for i := 0; i < 10; i++ {
+		_, err := stateStore.Allocate(int64(17 * (i + 1)))
+		require.NoError(t, err)
+		history = append(history, stateStore.GetCursors())
+		snapshots = append(snapshots, f.clone())
+	}
+
+	t.Run("TornWrites", func(t *testing.T) {
+		// Simulate a crash during every write, where only a
+		// prefix of the modified bytes made it to disk. The
+		// store should either recover the cursors from before
+		// or after the write.
+		for i := 1; i < len(snapshots); i++ {
+			before, after := snapshots[i-1].data, snapshots[i].data
+			for n := 0; n <= len(after); n++ {
+				torn := &memoryFile{data: append([]byte(nil), after[:n]...)}
+				if n < len(before) {
+					torn.data = append(torn.data, before[n:]...)
+				}
+				stateStore, err := circular.NewFileStateStore(torn, 100)
+				require.NoError(t, err)
+				cursors := stateStore.GetCursors()
+				require.Contains(t, []circular.Cursors{history[i-1], history[i]}, cursors)
+			}
+		}
+	})
+
+	t.Run("Truncation", func(t *testing.T) {
+		// Truncating the state file at any offset should yield
+		// one of the cursors that were written previously.
+		final := snapshots[len(snapshots)-1].data
+		for n := 0; n <= len(final); n++ {
+			truncated := &memoryFile{data: append([]byte(nil), final[:n]...)}
+			stateStore, err := circular.NewFileStateStore(truncated, 100)
+			require.NoError(t, err)
+			cursors := stateStore.GetCursors()
+			require.Contains(t, history, cursors)
+			require.LessOrEqual(t, cursors.Read, cursors.Write)
+
+			// The recovered store should remain writable.
+			_, err = stateStore.Allocate(1)
+			require.NoError(t, err)
+		}
+	})
+}
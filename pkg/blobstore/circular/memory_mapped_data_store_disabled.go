@@ -0,0 +1,16 @@
+// +build windows
+
+package circular
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewMemoryMappedDataStore creates a file-based store for blob
+// contents, where reads are performed through memory maps. This
+// implementation is a stub for operating systems that don't support
+// memory mapping files.
+func NewMemoryMappedDataStore(file MemoryMappableFile, size uint64) (DataStore, error) {
+	return nil, status.Error(codes.Unimplemented, "Memory mapping data files is not supported on this platform")
+}
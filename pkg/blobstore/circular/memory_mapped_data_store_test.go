@@ -0,0 +1,119 @@
+// +build darwin freebsd linux
+
+package circular_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/circular"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDataFile creates a temporary data file of a given size that
+// is filled with random data.
+func newTestDataFile(t testing.TB, size int) (*os.File, []byte) {
+	f, err := ioutil.TempFile("", "data")
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(f.Name()))
+
+	data := make([]byte, size)
+	rand.New(rand.NewSource(0)).Read(data)
+	_, err = f.WriteAt(data, 0)
+	require.NoError(t, err)
+	return f, data
+}
+
+func TestMemoryMappedDataStore(t *testing.T) {
+	// Use a size that is not a multiple of the page size, so that
+	// windows are not aligned to page boundaries.
+	const size = 3*65536 + 123
+	f, data := newTestDataFile(t, size)
+	defer f.Close()
+	ctx := context.Background()
+
+	dataStore, err := circular.NewMemoryMappedDataStore(f, size)
+	require.NoError(t, err)
+
+	t.Run("Get", func(t *testing.T) {
+		r := dataStore.Get(5000, 1000)
+		readData, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, data[5000:6000], readData)
+		require.NoError(t, r.Close())
+	})
+
+	t.Run("GetEmpty", func(t *testing.T) {
+		r := dataStore.Get(5000, 0)
+		readData, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Empty(t, readData)
+		require.NoError(t, r.Close())
+	})
+
+	t.Run("GetWrapAround", func(t *testing.T) {
+		// Regions that wrap around the end of the file should
+		// be read using two windows. Offsets larger than the
+		// size of the file should be reduced.
+		r := dataStore.Get(3*size-100, 300)
+		readData, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, append(append([]byte(nil), data[size-100:]...), data[:200]...), readData)
+		require.NoError(t, r.Close())
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		// Writes should be visible through the memory maps.
+		require.NoError(t, dataStore.Put(ctx, bytes.NewBufferString("Hello"), size-2))
+
+		r := dataStore.Get(size-2, 5)
+		readData, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), readData)
+		require.NoError(t, r.Close())
+	})
+}
+
+// BenchmarkDataStoreGet compares the performance of random reads
+// against the file data store, which calls pread(), and the memory
+// mapped data store.
+func BenchmarkDataStoreGet(b *testing.B) {
+	const size = 64 * 1024 * 1024
+	f, _ := newTestDataFile(b, size)
+	defer f.Close()
+
+	memoryMappedDataStore, err := circular.NewMemoryMappedDataStore(f, size)
+	require.NoError(b, err)
+	dataStores := map[string]circular.DataStore{
+		"File":         circular.NewFileDataStore(f, size),
+		"MemoryMapped": memoryMappedDataStore,
+	}
+	readSizes := map[string]int64{
+		"1KiB":   1024,
+		"256KiB": 256 * 1024,
+	}
+	for dataStoreName, dataStore := range dataStores {
+		for readSizeName, readSize := range readSizes {
+			b.Run(dataStoreName+"/"+readSizeName, func(b *testing.B) {
+				random := rand.New(rand.NewSource(0))
+				var buf [65536]byte
+				for n := 0; n < b.N; n++ {
+					r := dataStore.Get(uint64(random.Int63n(size)), readSize)
+					for {
+						if _, err := r.Read(buf[:]); err == io.EOF {
+							break
+						} else if err != nil {
+							b.Fatal(err)
+						}
+					}
+					r.Close()
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// +build darwin freebsd linux
+
+package circular
+
+import (
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"golang.org/x/sys/unix"
+)
+
+type memoryMappedDataStore struct {
+	DataStore
+
+	file     MemoryMappableFile
+	size     uint64
+	pageSize uint64
+}
+
+// NewMemoryMappedDataStore creates a file-based store for blob
+// contents that is identical to the one created by NewFileDataStore(),
+// except that reads are performed through memory maps. Every call to
+// Get() maps the requested region of the file into memory, which is
+// released when the returned reader is closed. This prevents system
+// call overhead for every individual read. As setting up a memory map
+// and faulting in its pages has a cost of its own, whether this
+// performs better than NewFileDataStore() depends on object sizes and
+// the size of the reads performed by the caller.
+//
+// Regions that wrap around the end of the file are accessed through
+// two separate memory maps. Because accessing memory maps beyond the
+// end of a file causes the process to crash, the data file must not be
+// truncated while in use.
+func NewMemoryMappedDataStore(file MemoryMappableFile, size uint64) (DataStore, error) {
+	return &memoryMappedDataStore{
+		DataStore: NewFileDataStore(file, size),
+		file:      file,
+		size:      size,
+		pageSize:  uint64(unix.Getpagesize()),
+	}, nil
+}
+
+func (ds *memoryMappedDataStore) Get(offset uint64, size int64) io.ReadCloser {
+	r := &memoryMappedDataStoreReader{}
+	fd := int(ds.file.Fd())
+	readOffset := offset % ds.size
+	for remaining := uint64(size); remaining > 0; {
+		// Limit the size of the window to ensure proper
+		// wrap-around at the end of the storage file.
+		length := remaining
+		if length > ds.size-readOffset {
+			length = ds.size - readOffset
+		}
+		if err := r.addWindow(fd, ds.pageSize, readOffset, length); err != nil {
+			r.Close()
+			return errorReadCloser{
+				err: util.StatusWrapf(err, "Failed to memory map data file at offset %d", readOffset),
+			}
+		}
+		readOffset = (readOffset + length) % ds.size
+		remaining -= length
+	}
+	return r
+}
+
+// memoryMappedDataStoreReader is the io.ReadCloser that is returned by
+// memoryMappedDataStore.Get(). It copies data from one or more memory
+// maps, which are released upon closure.
+type memoryMappedDataStoreReader struct {
+	mappings [][]byte
+	windows  [][]byte
+}
+
+func (r *memoryMappedDataStoreReader) addWindow(fd int, pageSize, offset, length uint64) error {
+	// Memory maps need to start at page boundaries.
+	alignedOffset := offset - offset%pageSize
+	mapping, err := unix.Mmap(fd, int64(alignedOffset), int(offset+length-alignedOffset), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	r.mappings = append(r.mappings, mapping)
+	r.windows = append(r.windows, mapping[offset-alignedOffset:])
+	return nil
+}
+
+func (r *memoryMappedDataStoreReader) Read(b []byte) (int, error) {
+	for len(r.windows) > 0 && len(r.windows[0]) == 0 {
+		r.windows = r.windows[1:]
+	}
+	if len(r.windows) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, r.windows[0])
+	r.windows[0] = r.windows[0][n:]
+	return n, nil
+}
+
+func (r *memoryMappedDataStoreReader) Close() error {
+	var firstErr error
+	for _, mapping := range r.mappings {
+		if err := unix.Munmap(mapping); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	r.mappings = nil
+	r.windows = nil
+	return firstErr
+}
@@ -0,0 +1,36 @@
+package circular
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type readOnlyStateStore struct {
+	cursors Cursors
+}
+
+// NewReadOnlyStateStore is an adapter for StateStore that prevents any
+// modifications to the state of the storage backend. The cursors are
+// pinned to the values they had at the time of creation. This may be
+// used to serve data from an immutable snapshot of storage.
+//
+// Allocations are rejected. Invalidations are ignored, meaning that
+// blobs that are found to be malformed while being read are not
+// deleted. Their corruption is only logged.
+func NewReadOnlyStateStore(stateStore StateStore) StateStore {
+	return &readOnlyStateStore{
+		cursors: stateStore.GetCursors(),
+	}
+}
+
+func (ss *readOnlyStateStore) GetCursors() Cursors {
+	return ss.cursors
+}
+
+func (ss *readOnlyStateStore) Allocate(sizeBytes int64) (uint64, error) {
+	return 0, status.Error(codes.FailedPrecondition, "Storage backend is read-only")
+}
+
+func (ss *readOnlyStateStore) Invalidate(offset uint64, sizeBytes int64) error {
+	return nil
+}
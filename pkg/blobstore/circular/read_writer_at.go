@@ -2,6 +2,9 @@ package circular
 
 import (
 	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // ReadWriterAt is an interface for the file operations performed by the
@@ -10,3 +13,44 @@ type ReadWriterAt interface {
 	io.ReaderAt
 	io.WriterAt
 }
+
+// MemoryMappableFile is a file that, in addition to supporting reads
+// and writes at arbitrary offsets, exposes its file descriptor, so that
+// it may be mapped into memory.
+type MemoryMappableFile interface {
+	ReadWriterAt
+
+	Fd() uintptr
+}
+
+type readOnlyReadWriterAt struct {
+	io.ReaderAt
+}
+
+// NewReadOnlyReadWriterAt creates a ReadWriterAt for a file that has
+// been opened for reading only, as is done when serving data from an
+// immutable snapshot of storage. Writes fail with FAILED_PRECONDITION.
+// If the file exposes its file descriptor, the resulting
+// ReadWriterAt implements MemoryMappableFile.
+func NewReadOnlyReadWriterAt(r io.ReaderAt) ReadWriterAt {
+	if f, ok := r.(interface{ Fd() uintptr }); ok {
+		return readOnlyMemoryMappableFile{
+			readOnlyReadWriterAt: readOnlyReadWriterAt{ReaderAt: r},
+			fd:                   f,
+		}
+	}
+	return readOnlyReadWriterAt{ReaderAt: r}
+}
+
+func (readOnlyReadWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	return 0, status.Error(codes.FailedPrecondition, "Storage backend is read-only")
+}
+
+type readOnlyMemoryMappableFile struct {
+	readOnlyReadWriterAt
+	fd interface{ Fd() uintptr }
+}
+
+func (f readOnlyMemoryMappableFile) Fd() uintptr {
+	return f.fd.Fd()
+}
@@ -0,0 +1,74 @@
+package circular
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type segmentAligningStateStore struct {
+	StateStore
+	segmentSizeBytes uint64
+}
+
+// NewSegmentAligningStateStore creates a decorator for StateStore that
+// makes the cursors suitable for use with the DataStore returned by
+// NewSegmentedDataStore().
+//
+// Allocate() ensures that blobs never cross segment boundaries. If a
+// blob does not fit in the remainder of the current segment, the
+// remainder is skipped, so that the blob is placed at the start of the
+// next segment. Blobs larger than a single segment are rejected.
+//
+// Whenever the read cursor of the underlying StateStore ends up in the
+// middle of a segment (e.g., because its oldest data is about to be
+// overwritten), it is moved to the start of the next segment. This
+// causes all data in that segment to become inaccessible at once, so
+// that the segment may be deleted.
+//
+// The underlying StateStore must allocate space at the write cursor,
+// as done by the one returned by NewFileStateStore(). It cannot be
+// combined with NewBulkAllocatingStateStore().
+func NewSegmentAligningStateStore(base StateStore, segmentSizeBytes uint64) StateStore {
+	return &segmentAligningStateStore{
+		StateStore:       base,
+		segmentSizeBytes: segmentSizeBytes,
+	}
+}
+
+// alignReadCursor moves the read cursor to the start of the next
+// segment if it lies in the middle of a segment.
+func (ss *segmentAligningStateStore) alignReadCursor() error {
+	cursors := ss.StateStore.GetCursors()
+	if remainder := cursors.Read % ss.segmentSizeBytes; remainder != 0 {
+		return ss.StateStore.Invalidate(cursors.Read-remainder+ss.segmentSizeBytes, 0)
+	}
+	return nil
+}
+
+func (ss *segmentAligningStateStore) Allocate(sizeBytes int64) (uint64, error) {
+	if uint64(sizeBytes) > ss.segmentSizeBytes {
+		return 0, status.Errorf(codes.InvalidArgument, "Blob is %d bytes in size, while segments are only %d bytes in size", sizeBytes, ss.segmentSizeBytes)
+	}
+
+	// Skip the remainder of the current segment if the blob
+	// doesn't fit in it.
+	cursors := ss.StateStore.GetCursors()
+	if used := cursors.Write % ss.segmentSizeBytes; used != 0 && used+uint64(sizeBytes) > ss.segmentSizeBytes {
+		if _, err := ss.StateStore.Allocate(int64(ss.segmentSizeBytes - used)); err != nil {
+			return 0, err
+		}
+	}
+
+	offset, err := ss.StateStore.Allocate(sizeBytes)
+	if err != nil {
+		return 0, err
+	}
+	return offset, ss.alignReadCursor()
+}
+
+func (ss *segmentAligningStateStore) Invalidate(offset uint64, sizeBytes int64) error {
+	if err := ss.StateStore.Invalidate(offset, sizeBytes); err != nil {
+		return err
+	}
+	return ss.alignReadCursor()
+}
@@ -0,0 +1,51 @@
+package circular_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/circular"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSegmentAligningStateStore(t *testing.T) {
+	// Three segments of 100 bytes each.
+	baseStateStore, err := circular.NewFileStateStore(&memoryFile{}, 300)
+	require.NoError(t, err)
+	stateStore := circular.NewSegmentAligningStateStore(baseStateStore, 100)
+
+	// Blobs that fit in the current segment are placed directly
+	// after each other.
+	offset, err := stateStore.Allocate(60)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offset)
+
+	// Blobs that don't fit in the remainder of the current segment
+	// should be placed at the start of the next segment.
+	offset, err = stateStore.Allocate(60)
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), offset)
+	offset, err = stateStore.Allocate(100)
+	require.NoError(t, err)
+	require.Equal(t, uint64(200), offset)
+	require.Equal(t, circular.Cursors{Read: 0, Write: 300}, stateStore.GetCursors())
+
+	// Blobs that are larger than a segment can never be stored.
+	_, err = stateStore.Allocate(101)
+	require.Equal(t, status.Error(codes.InvalidArgument, "Blob is 101 bytes in size, while segments are only 100 bytes in size"), err)
+
+	// Allocating space in the fourth segment should cause the first
+	// segment to be invalidated in its entirety, even though only
+	// part of it needs to be overwritten.
+	offset, err = stateStore.Allocate(50)
+	require.NoError(t, err)
+	require.Equal(t, uint64(300), offset)
+	require.Equal(t, circular.Cursors{Read: 100, Write: 350}, stateStore.GetCursors())
+
+	// Invalidating data should also invalidate the remainder of
+	// the segment.
+	require.NoError(t, stateStore.Invalidate(120, 10))
+	require.Equal(t, circular.Cursors{Read: 200, Write: 350}, stateStore.GetCursors())
+}
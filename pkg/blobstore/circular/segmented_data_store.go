@@ -0,0 +1,169 @@
+package circular
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type segmentedDataStore struct {
+	directory        filesystem.Directory
+	segmentSizeBytes uint64
+	segmentCount     uint64
+
+	lock     sync.Mutex
+	segments []uint64
+}
+
+// getSegmentName returns the filename of the segment file with a given
+// index.
+func getSegmentName(segment uint64) string {
+	return fmt.Sprintf("%016x", segment)
+}
+
+// NewSegmentedDataStore creates a store for blob contents that, as
+// opposed to the one returned by NewFileDataStore(), stores data in a
+// directory of append-only segment files. Offset o is stored in
+// segment o / segmentSizeBytes. Instead of overwriting data in place,
+// the oldest segments are deleted in their entirety when a new segment
+// is created, so that at most segmentCount segments are present.
+//
+// This DataStore must be used in combination with a StateStore
+// returned by NewSegmentAligningStateStore(), configured with the same
+// segment size and a data size of segmentCount * segmentSizeBytes.
+// That StateStore ensures that blobs never cross segment boundaries,
+// and that the read cursor never points into a segment that may have
+// been deleted. As Cursors.Contains() only reports data as being
+// present if it lies at or after the read cursor, entries in the
+// OffsetStore that refer to deleted segments automatically become
+// invalid.
+func NewSegmentedDataStore(directory filesystem.Directory, segmentSizeBytes uint64, segmentCount uint64) (DataStore, error) {
+	// Obtain the list of segments that were created previously.
+	entries, err := directory.ReadDir()
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to read segment directory")
+	}
+	var segments []uint64
+	for _, entry := range entries {
+		if segment, err := strconv.ParseUint(entry.Name(), 16, 64); err == nil && entry.Name() == getSegmentName(segment) {
+			segments = append(segments, segment)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+
+	return &segmentedDataStore{
+		directory:        directory,
+		segmentSizeBytes: segmentSizeBytes,
+		segmentCount:     segmentCount,
+		segments:         segments,
+	}, nil
+}
+
+// openSegmentForWriting opens a segment file for writing, creating it
+// if it does not exist yet. Creating a segment causes segments that
+// can no longer be referenced by the read cursor to be deleted.
+func (ds *segmentedDataStore) openSegmentForWriting(segment uint64) (filesystem.FileReadWriter, error) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	i := sort.Search(len(ds.segments), func(i int) bool { return ds.segments[i] >= segment })
+	if i == len(ds.segments) || ds.segments[i] != segment {
+		// Delete segments that are too old to be referenced.
+		for len(ds.segments) > 0 && ds.segments[0]+ds.segmentCount <= segment {
+			if err := ds.directory.Remove(getSegmentName(ds.segments[0])); err != nil && !os.IsNotExist(err) {
+				return nil, util.StatusWrapf(err, "Failed to delete segment %d", ds.segments[0])
+			}
+			ds.segments = ds.segments[1:]
+			i--
+		}
+		ds.segments = append(ds.segments, 0)
+		copy(ds.segments[i+1:], ds.segments[i:])
+		ds.segments[i] = segment
+	}
+
+	f, err := ds.directory.OpenReadWrite(getSegmentName(segment), filesystem.CreateReuse(0644))
+	if err != nil {
+		return nil, util.StatusWrapf(err, "Failed to open segment %d", segment)
+	}
+	return f, nil
+}
+
+func (ds *segmentedDataStore) Put(ctx context.Context, r io.Reader, offset uint64) error {
+	segment := offset / ds.segmentSizeBytes
+	f, err := ds.openSegmentForWriting(segment)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	writeOffset := offset % ds.segmentSizeBytes
+	for {
+		// Stop writing if the caller is no longer interested
+		// in the results.
+		if err := util.StatusFromContext(ctx); err != nil {
+			return err
+		}
+
+		var b [65536]byte
+		n, readErr := r.Read(b[:])
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		if writeOffset+uint64(n) > ds.segmentSizeBytes {
+			return status.Errorf(codes.Internal, "Data at offset %d crosses the boundary of segment %d", offset, segment)
+		}
+		if _, writeErr := f.WriteAt(b[:n], int64(writeOffset)); writeErr != nil {
+			return writeErr
+		}
+		writeOffset += uint64(n)
+
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+func (ds *segmentedDataStore) Get(offset uint64, size int64) io.ReadCloser {
+	// Segments are opened for the duration of the read. This
+	// permits segments to be deleted while being read, as the data
+	// remains accessible until the file is closed.
+	segment := offset / ds.segmentSizeBytes
+	f, err := ds.directory.OpenRead(getSegmentName(segment))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errorReadCloser{err: status.Errorf(codes.NotFound, "Segment %d no longer exists", segment)}
+		}
+		return errorReadCloser{err: util.StatusWrapf(err, "Failed to open segment %d", segment)}
+	}
+	return &struct {
+		io.SectionReader
+		io.Closer
+	}{
+		SectionReader: *io.NewSectionReader(f, int64(offset%ds.segmentSizeBytes), size),
+		Closer:        f,
+	}
+}
+
+// errorReadCloser is returned by segmentedDataStore.Get() in case the
+// segment cannot be opened.
+type errorReadCloser struct {
+	err error
+}
+
+func (r errorReadCloser) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func (r errorReadCloser) Close() error {
+	return nil
+}
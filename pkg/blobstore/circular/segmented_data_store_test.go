@@ -0,0 +1,78 @@
+package circular_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/circular"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSegmentedDataStore(t *testing.T) {
+	ctx := context.Background()
+	path, err := ioutil.TempDir("", "segments")
+	require.NoError(t, err)
+	defer os.RemoveAll(path)
+	directory, err := filesystem.NewLocalDirectory(path)
+	require.NoError(t, err)
+	defer directory.Close()
+
+	// Keep two segments of 10 bytes around.
+	dataStore, err := circular.NewSegmentedDataStore(directory, 10, 2)
+	require.NoError(t, err)
+
+	t.Run("PutAndGet", func(t *testing.T) {
+		require.NoError(t, dataStore.Put(ctx, bytes.NewBufferString("Hello"), 2))
+		data, err := ioutil.ReadAll(dataStore.Get(2, 5))
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("CrossSegmentBoundary", func(t *testing.T) {
+		// Blobs may not span multiple segments. This is
+		// normally prevented by NewSegmentAligningStateStore().
+		require.Equal(
+			t,
+			status.Error(codes.Internal, "Data at offset 7 crosses the boundary of segment 0"),
+			dataStore.Put(ctx, bytes.NewBufferString("Hello"), 7))
+	})
+
+	t.Run("Rotation", func(t *testing.T) {
+		// Writing into the second segment should keep the first
+		// segment around.
+		require.NoError(t, dataStore.Put(ctx, bytes.NewBufferString("world"), 10))
+		data, err := ioutil.ReadAll(dataStore.Get(2, 5))
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+
+		// Writing into the third segment should cause the first
+		// segment to be deleted.
+		require.NoError(t, dataStore.Put(ctx, bytes.NewBufferString("!"), 20))
+		_, err = ioutil.ReadAll(dataStore.Get(2, 5))
+		require.Equal(t, status.Error(codes.NotFound, "Segment 0 no longer exists"), err)
+		data, err = ioutil.ReadAll(dataStore.Get(10, 5))
+		require.NoError(t, err)
+		require.Equal(t, []byte("world"), data)
+	})
+
+	t.Run("Reopen", func(t *testing.T) {
+		// Segments created previously should be picked up when
+		// the data store is recreated, so that they are deleted
+		// when no longer needed.
+		reopenedDataStore, err := circular.NewSegmentedDataStore(directory, 10, 2)
+		require.NoError(t, err)
+		require.NoError(t, reopenedDataStore.Put(ctx, bytes.NewBufferString("?"), 30))
+		_, err = ioutil.ReadAll(reopenedDataStore.Get(10, 5))
+		require.Equal(t, status.Error(codes.NotFound, "Segment 1 no longer exists"), err)
+		data, err := ioutil.ReadAll(reopenedDataStore.Get(20, 1))
+		require.NoError(t, err)
+		require.Equal(t, []byte("!"), data)
+	})
+}
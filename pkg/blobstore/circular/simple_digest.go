@@ -1,8 +1,11 @@
 package circular
 
 import (
+	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 
 	"github.com/buildbarn/bb-storage/pkg/digest"
 )
@@ -11,13 +14,62 @@ import (
 // storage backend uses.
 //
 // Digests are encoded by storing the hash, followed by the size. Enough
-// space is left for a SHA-256 sum.
+// space is left for a SHA-256 sum. The instance name is not stored, as
+// is the case for keys obtained through digest.KeyWithoutInstance.
+//
+// Longer hashes (SHA-384, SHA-512) are truncated. To prevent these from
+// being reconstructed as SHA-256 digests that were never stored, the
+// top bit of the size is set for them.
 type simpleDigest [sha256.Size + 8]byte
 
+const simpleDigestTruncatedHash = 1 << 63
+
 // NewSimpleDigest converts a Digest to a simpleDigest.
 func newSimpleDigest(digest digest.Digest) simpleDigest {
 	var sd simpleDigest
-	copy(sd[:], digest.GetHashBytes())
-	binary.LittleEndian.PutUint32(sd[sha256.Size:], uint32(digest.GetSizeBytes()))
+	hash := digest.GetHashBytes()
+	sizeBytes := uint64(digest.GetSizeBytes())
+	if len(hash) > sha256.Size {
+		sizeBytes |= simpleDigestTruncatedHash
+	}
+	copy(sd[:sha256.Size], hash)
+	binary.LittleEndian.PutUint64(sd[sha256.Size:], sizeBytes)
 	return sd
 }
+
+// toDigest converts a simpleDigest back to a Digest. As the length of
+// the hash is not stored, it is inferred from the number of trailing
+// zero bytes. This means that only digests using MD5, SHA-1 and
+// SHA-256 can be reconstructed reliably. Digests whose hash was
+// truncated by newSimpleDigest() cannot be reconstructed, causing false
+// to be returned.
+func (sd simpleDigest) toDigest(instanceName digest.InstanceName) (digest.Digest, bool) {
+	sizeBytes := binary.LittleEndian.Uint64(sd[sha256.Size:])
+	if sizeBytes&simpleDigestTruncatedHash != 0 {
+		return digest.BadDigest, false
+	}
+	for _, hashLength := range []int{md5.Size, sha1.Size, sha256.Size} {
+		if isZero(sd[hashLength:sha256.Size]) {
+			if isZero(sd[:hashLength]) {
+				return digest.BadDigest, false
+			}
+			d, err := instanceName.NewDigest(
+				hex.EncodeToString(sd[:hashLength]),
+				int64(sizeBytes))
+			if err != nil {
+				return digest.BadDigest, false
+			}
+			return d, true
+		}
+	}
+	return digest.BadDigest, false
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package circular
+
+import (
+	"io"
+)
+
+// maximumConsecutiveEmptyStripeOperations is the number of times an
+// operation against a file may return zero bytes without an error,
+// before stripingReadWriterAt gives up with io.ErrNoProgress. This
+// matches the limit used by the bufio package.
+const maximumConsecutiveEmptyStripeOperations = 100
+
+type stripingReadWriterAt struct {
+	files           []ReadWriterAt
+	stripeSizeBytes int64
+}
+
+// NewStripingDataStore creates a file-based store for blob contents
+// that behaves like the one returned by NewFileDataStore(), except that
+// data is spread out across multiple files. The logical offset space is
+// split up into stripes of a fixed size, which are assigned to the
+// files in a round-robin fashion. This permits concurrent reads and
+// writes to be spread out across multiple storage devices.
+//
+// Every file must be capable of storing size / len(files) bytes,
+// rounded up to a multiple of stripeSizeBytes.
+func NewStripingDataStore(files []ReadWriterAt, stripeSizeBytes int64, size uint64) DataStore {
+	return NewFileDataStore(
+		&stripingReadWriterAt{
+			files:           files,
+			stripeSizeBytes: stripeSizeBytes,
+		},
+		size)
+}
+
+// forEachStripe splits up an operation at a logical offset into
+// operations against the individual files.
+func (rw *stripingReadWriterAt) forEachStripe(p []byte, off int64, f func(file ReadWriterAt, p []byte, off int64) (int, error)) (int, error) {
+	nTotal := 0
+	emptyOperations := 0
+	for len(p) > 0 {
+		stripe := off / rw.stripeSizeBytes
+		offsetInStripe := off % rw.stripeSizeBytes
+		chunk := p
+		if remaining := rw.stripeSizeBytes - offsetInStripe; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+
+		fileCount := int64(len(rw.files))
+		n, err := f(
+			rw.files[stripe%fileCount],
+			chunk,
+			stripe/fileCount*rw.stripeSizeBytes+offsetInStripe)
+		nTotal += n
+		if err != nil {
+			return nTotal, err
+		}
+		if n == 0 {
+			emptyOperations++
+			if emptyOperations >= maximumConsecutiveEmptyStripeOperations {
+				return nTotal, io.ErrNoProgress
+			}
+			continue
+		}
+		emptyOperations = 0
+		p = p[n:]
+		off += int64(n)
+	}
+	return nTotal, nil
+}
+
+func (rw *stripingReadWriterAt) ReadAt(p []byte, off int64) (int, error) {
+	return rw.forEachStripe(p, off, func(file ReadWriterAt, p []byte, off int64) (int, error) {
+		return file.ReadAt(p, off)
+	})
+}
+
+func (rw *stripingReadWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	return rw.forEachStripe(p, off, func(file ReadWriterAt, p []byte, off int64) (int, error) {
+		return file.WriteAt(p, off)
+	})
+}
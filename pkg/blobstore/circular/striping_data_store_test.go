@@ -0,0 +1,67 @@
+package circular_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/circular"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripingDataStore(t *testing.T) {
+	files := []*memoryFile{{}, {}, {}}
+	dataStore := circular.NewStripingDataStore(
+		[]circular.ReadWriterAt{files[0], files[1], files[2]},
+		4,
+		24)
+
+	// Write data that spans multiple stripe boundaries. Stripes
+	// should be assigned to files in a round-robin fashion.
+	require.NoError(t, dataStore.Put(context.Background(), bytes.NewBufferString("AAAABBBBCCCCDDDDEE"), 0))
+	require.Equal(t, []byte("AAAADDDD"), files[0].data)
+	require.Equal(t, []byte("BBBBEE"), files[1].data)
+	require.Equal(t, []byte("CCCC"), files[2].data)
+
+	data, err := ioutil.ReadAll(dataStore.Get(2, 12))
+	require.NoError(t, err)
+	require.Equal(t, []byte("AABBBBCCCCDD"), data)
+
+	// Writes that wrap around the end of the data store should
+	// continue at the start of the first file.
+	require.NoError(t, dataStore.Put(context.Background(), bytes.NewBufferString("FFFFFFGGGG"), 18))
+	require.Equal(t, []byte("GGGGDDDD"), files[0].data)
+	require.Equal(t, []byte("BBBBEEFF"), files[1].data)
+	require.Equal(t, []byte("CCCCFFFF"), files[2].data)
+
+	data, err = ioutil.ReadAll(dataStore.Get(18, 10))
+	require.NoError(t, err)
+	require.Equal(t, []byte("FFFFFFGGGG"), data)
+}
+
+// stallingFile is a ReadWriterAt for which all operations complete
+// without transferring any data, nor returning an error.
+type stallingFile struct{}
+
+func (stallingFile) ReadAt(p []byte, off int64) (int, error) {
+	return 0, nil
+}
+
+func (stallingFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, nil
+}
+
+func TestStripingDataStoreNoProgress(t *testing.T) {
+	dataStore := circular.NewStripingDataStore(
+		[]circular.ReadWriterAt{stallingFile{}, stallingFile{}},
+		4,
+		16)
+
+	// Files that don't make any progress should cause operations
+	// to fail, as opposed to retrying them indefinitely.
+	_, err := ioutil.ReadAll(dataStore.Get(2, 8))
+	require.Equal(t, io.ErrNoProgress, err)
+	require.Equal(t, io.ErrNoProgress, dataStore.Put(context.Background(), bytes.NewBufferString("Hello"), 0))
+}
@@ -72,13 +72,19 @@ func (q *findMissingQueue) addDirectory(directory *remoteexecution.Directory) er
 
 // Finalize by checking the last batch of digests for existence.
 func (q *findMissingQueue) finalize() error {
-	missing, err := q.contentAddressableStorage.FindMissing(q.context, q.pending.Build())
+	pending := q.pending.Build()
+	missing, err := q.contentAddressableStorage.FindMissing(q.context, pending)
 	if err != nil {
 		return util.StatusWrap(err, "Failed to determine existence of child objects")
 	}
 	if digest, ok := missing.First(); ok {
 		return status.Errorf(codes.NotFound, "Object %s referenced by the action result is not present in the Content Addressable Storage", digest)
 	}
+
+	// Clients that obtain an action result tend to download its
+	// outputs shortly afterwards. Give the Content Addressable
+	// Storage the opportunity to warm its caches.
+	blobstore.Prefetch(q.context, q.contentAddressableStorage, pending)
 	return nil
 }
 
@@ -103,6 +109,10 @@ type completenessCheckingBlobAccess struct {
 // needs to be rebuilt. By calling it, Bazel indicates that all
 // associated output files must remain present during the build for
 // forward progress to be made.
+//
+// Objects referenced by complete ActionResult entries are announced
+// to the Content Addressable Storage through blobstore.Prefetch(), as
+// clients tend to download them shortly afterwards.
 func NewCompletenessCheckingBlobAccess(actionCache blobstore.BlobAccess, contentAddressableStorage blobstore.BlobAccess, batchSize int, maximumMessageSizeBytes int) blobstore.BlobAccess {
 	return &completenessCheckingBlobAccess{
 		BlobAccess:                actionCache,
@@ -256,3 +256,58 @@ func TestCompletenessCheckingBlobAccess(t *testing.T) {
 		require.True(t, proto.Equal(actualResult, &actionResult))
 	})
 }
+
+// prefetchingBlobAccess is a BlobAccess that implements
+// blobstore.Prefetcher, whose calls are forwarded to mocks.
+type prefetchingBlobAccess struct {
+	*mock.MockBlobAccess
+	*mock.MockPrefetcher
+}
+
+func TestCompletenessCheckingBlobAccessPrefetch(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	actionCache := mock.NewMockBlobAccess(ctrl)
+	contentAddressableStorage := prefetchingBlobAccess{
+		MockBlobAccess: mock.NewMockBlobAccess(ctrl),
+		MockPrefetcher: mock.NewMockPrefetcher(ctrl),
+	}
+	completenessCheckingBlobAccess := completenesschecking.NewCompletenessCheckingBlobAccess(
+		actionCache,
+		contentAddressableStorage,
+		5,
+		1000)
+
+	actionDigest := digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 123)
+	outputDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	actionResult := &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{
+				Path:   "bazel-out/foo.o",
+				Digest: outputDigest.GetProto(),
+			},
+		},
+	}
+
+	t.Run("Complete", func(t *testing.T) {
+		// Outputs of complete action results are likely to be
+		// downloaded by the client. They should be prefetched.
+		actionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewProtoBufferFromProto(actionResult, buffer.UserProvided))
+		contentAddressableStorage.MockBlobAccess.EXPECT().FindMissing(ctx, outputDigest.ToSingletonSet()).Return(digest.EmptySet, nil)
+		contentAddressableStorage.MockPrefetcher.EXPECT().Prefetch(ctx, outputDigest.ToSingletonSet())
+
+		_, err := completenessCheckingBlobAccess.Get(ctx, actionDigest).ToProto(&remoteexecution.ActionResult{}, 1000)
+		require.NoError(t, err)
+	})
+
+	t.Run("Incomplete", func(t *testing.T) {
+		// There is no point in prefetching outputs of action
+		// results that are incomplete, as the client is going
+		// to rebuild the action.
+		actionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewProtoBufferFromProto(actionResult, buffer.UserProvided))
+		contentAddressableStorage.MockBlobAccess.EXPECT().FindMissing(ctx, outputDigest.ToSingletonSet()).Return(outputDigest.ToSingletonSet(), nil)
+
+		_, err := completenessCheckingBlobAccess.Get(ctx, actionDigest).ToProto(&remoteexecution.ActionResult{}, 1000)
+		require.Equal(t, status.Error(codes.NotFound, "Object 8b1a9953c4611296a827abf8c47804d7-5-hello referenced by the action result is not present in the Content Addressable Storage"), err)
+	})
+}
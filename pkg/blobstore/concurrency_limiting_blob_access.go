@@ -0,0 +1,96 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	concurrencyLimitingBlobAccessPrometheusMetrics sync.Once
+
+	concurrencyLimitingBlobAccessOperationsInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "concurrency_limiting_blob_access_operations_in_flight",
+			Help:      "Number of operations that are currently permitted to run against the backend.",
+		},
+		[]string{"name", "operation"})
+)
+
+// concurrencyLimiter keeps track of the number of operations of a
+// single type that are running.
+type concurrencyLimiter struct {
+	slots    chan struct{}
+	inFlight prometheus.Gauge
+}
+
+func newConcurrencyLimiter(name, operation string, maximumConcurrency int) concurrencyLimiter {
+	return concurrencyLimiter{
+		slots:    make(chan struct{}, maximumConcurrency),
+		inFlight: concurrencyLimitingBlobAccessOperationsInFlight.WithLabelValues(name, operation),
+	}
+}
+
+func (cl *concurrencyLimiter) acquire(ctx context.Context) error {
+	select {
+	case cl.slots <- struct{}{}:
+		cl.inFlight.Inc()
+		return nil
+	case <-ctx.Done():
+		return util.StatusFromContext(ctx)
+	}
+}
+
+func (cl *concurrencyLimiter) release() {
+	cl.inFlight.Dec()
+	<-cl.slots
+}
+
+type concurrencyLimitingBlobAccess struct {
+	BlobAccess
+	getLimiter concurrencyLimiter
+	putLimiter concurrencyLimiter
+}
+
+// NewConcurrencyLimitingBlobAccess creates a decorator for BlobAccess
+// that places a limit on the number of Get() and Put() operations that
+// run against the backend concurrently. This may be used to protect
+// backends against bursts of traffic. Calls that exceed the limit block
+// until a slot becomes available, or until the context is cancelled.
+//
+// As buffers returned by Get() may stream data from the backend, slots
+// for Get() are only released once the buffer has been consumed or
+// discarded, or once the reader obtained from it has been closed.
+func NewConcurrencyLimitingBlobAccess(base BlobAccess, name string, maximumConcurrentGets, maximumConcurrentPuts int) BlobAccess {
+	concurrencyLimitingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(concurrencyLimitingBlobAccessOperationsInFlight)
+	})
+
+	return &concurrencyLimitingBlobAccess{
+		BlobAccess: base,
+		getLimiter: newConcurrencyLimiter(name, "Get", maximumConcurrentGets),
+		putLimiter: newConcurrencyLimiter(name, "Put", maximumConcurrentPuts),
+	}
+}
+
+func (ba *concurrencyLimitingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	if err := ba.getLimiter.acquire(ctx); err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return buffer.WithReleaseFunc(ba.BlobAccess.Get(ctx, digest), ba.getLimiter.release)
+}
+
+func (ba *concurrencyLimitingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if err := ba.putLimiter.acquire(ctx); err != nil {
+		b.Discard()
+		return err
+	}
+	defer ba.putLimiter.release()
+	return ba.BlobAccess.Put(ctx, digest, b)
+}
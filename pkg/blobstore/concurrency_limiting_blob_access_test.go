@@ -0,0 +1,122 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConcurrencyLimitingBlobAccessGet(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewConcurrencyLimitingBlobAccess(baseBlobAccess, "test_get", 1, 1)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	// The first call should be forwarded to the backend. As the
+	// buffer isn't consumed yet, it keeps holding on to the only
+	// slot that is available.
+	chunkReader := mock.NewMockChunkReader(ctrl)
+	baseBlobAccess.EXPECT().Get(ctx, helloDigest).Return(
+		buffer.NewCASBufferFromChunkReader(helloDigest, chunkReader, buffer.BackendProvided(buffer.Irreparable(helloDigest))))
+	b1 := blobAccess.Get(ctx, helloDigest)
+
+	// The second call should block until its context is cancelled.
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := blobAccess.Get(canceledCtx, helloDigest).ToByteSlice(10)
+	require.Equal(t, status.Error(codes.Canceled, "context canceled"), err)
+
+	// Consuming the first buffer should release the slot, causing
+	// subsequent calls to be forwarded to the backend again.
+	chunkReader.EXPECT().Read().Return([]byte("Hello"), nil)
+	chunkReader.EXPECT().Read().Return(nil, status.Error(codes.Internal, "Server on fire"))
+	chunkReader.EXPECT().Close()
+	_, err = b1.ToByteSlice(10)
+	require.Equal(t, status.Error(codes.Internal, "Server on fire"), err)
+
+	baseBlobAccess.EXPECT().Get(ctx, helloDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+	data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+}
+
+func TestConcurrencyLimitingBlobAccessGetFileReader(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewConcurrencyLimitingBlobAccess(baseBlobAccess, "test_get_file_reader", 1, 1)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	// Buffers backed by local files consider their data to be
+	// valid up front. The slot should nonetheless remain occupied
+	// until the reader obtained from the buffer is closed, as the
+	// file is still being read.
+	fileReader := mock.NewMockFileReader(ctrl)
+	fileReader.EXPECT().ReadAt(gomock.Any(), int64(0)).DoAndReturn(func(p []byte, off int64) (int, error) {
+		return copy(p, []byte("Hello")), nil
+	})
+	baseBlobAccess.EXPECT().Get(ctx, helloDigest).Return(buffer.NewValidatedBufferFromFileReader(fileReader, 5))
+	r := blobAccess.Get(ctx, helloDigest).ToChunkReader(0, buffer.ChunkSizeAtMost(10))
+	data, err := r.Read()
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = blobAccess.Get(canceledCtx, helloDigest).ToByteSlice(10)
+	require.Equal(t, status.Error(codes.Canceled, "context canceled"), err)
+
+	// Closing the reader should release the slot.
+	fileReader.EXPECT().Close()
+	r.Close()
+
+	baseBlobAccess.EXPECT().Get(ctx, helloDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+	data, err = blobAccess.Get(ctx, helloDigest).ToByteSlice(10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+}
+
+func TestConcurrencyLimitingBlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewConcurrencyLimitingBlobAccess(baseBlobAccess, "test_put", 1, 1)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	// While a slot is occupied, other calls should block until
+	// their context is cancelled.
+	canceledCtx, cancel := context.WithCancel(ctx)
+	baseBlobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			cancel()
+			require.Equal(
+				t,
+				status.Error(codes.Canceled, "context canceled"),
+				blobAccess.Put(canceledCtx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+			data, err := b.ToByteSlice(10)
+			require.NoError(t, err)
+			require.Equal(t, []byte("Hello"), data)
+			return nil
+		})
+	require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	// Once the first call has completed, the slot should be
+	// available again.
+	baseBlobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			b.Discard()
+			return nil
+		})
+	require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+}
@@ -35,8 +35,12 @@ func (bac *acBlobAccessCreator) GetBaseDigestKeyFormat() digest.KeyFormat {
 	return digest.KeyWithInstance
 }
 
+func (bac *acBlobAccessCreator) GetMaximumMessageSizeBytes() int {
+	return bac.maximumMessageSizeBytes
+}
+
 func (bac *acBlobAccessCreator) GetReadBufferFactory() blobstore.ReadBufferFactory {
-	return blobstore.ACReadBufferFactory
+	return blobstore.NewACReadBufferFactory(bac.maximumMessageSizeBytes, false)
 }
 
 func (bac *acBlobAccessCreator) GetStorageTypeName() string {
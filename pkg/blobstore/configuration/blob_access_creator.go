@@ -21,6 +21,11 @@ type BlobAccessCreator interface {
 	// return digest.KeyWithoutInstance, so that identical objects
 	// are only stored once.
 	GetBaseDigestKeyFormat() digest.KeyFormat
+	// GetMaximumMessageSizeBytes() returns the maximum size of
+	// messages that are exchanged with clients. Storage backends
+	// may use it to bound the amount of memory used to hold blobs
+	// whose size is not known up front.
+	GetMaximumMessageSizeBytes() int
 	// GetReadBufferFactory() returns operations that can be used by
 	// BlobAccess to create Buffer objects to return data.
 	GetReadBufferFactory() blobstore.ReadBufferFactory
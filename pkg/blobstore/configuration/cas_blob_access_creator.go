@@ -10,6 +10,7 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/buildbarn/bb-storage/pkg/grpc"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+	grpc_pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
 	"github.com/buildbarn/bb-storage/pkg/util"
 	"github.com/google/uuid"
 
@@ -40,6 +41,10 @@ func (bac *casBlobAccessCreator) GetBaseDigestKeyFormat() digest.KeyFormat {
 	return digest.KeyWithoutInstance
 }
 
+func (bac *casBlobAccessCreator) GetMaximumMessageSizeBytes() int {
+	return bac.maximumMessageSizeBytes
+}
+
 func (bac *casBlobAccessCreator) GetReadBufferFactory() blobstore.ReadBufferFactory {
 	return blobstore.CASReadBufferFactory
 }
@@ -59,21 +64,76 @@ func (bac *casBlobAccessCreator) NewCustomBlobAccess(configuration *pb.BlobAcces
 		if err != nil {
 			return BlobAccessInfo{}, "", err
 		}
+		blobAccess := base.BlobAccess
+		if cacheConfiguration := backend.ExistenceCaching.MissingExistenceCache; cacheConfiguration != nil {
+			// Objects may be missing for one instance name,
+			// while being present for another.
+			missingCache, err := digest.NewExistenceCacheFromConfiguration(cacheConfiguration, digest.KeyWithInstance, "NegativeExistenceCachingBlobAccess")
+			if err != nil {
+				return BlobAccessInfo{}, "", err
+			}
+			blobAccess = blobstore.NewNegativeExistenceCachingBlobAccess(blobAccess, missingCache)
+		}
 		return BlobAccessInfo{
-			BlobAccess:      blobstore.NewExistenceCachingBlobAccess(base.BlobAccess, existenceCache),
+			BlobAccess:      blobstore.NewExistenceCachingBlobAccess(blobAccess, existenceCache),
 			DigestKeyFormat: base.DigestKeyFormat,
 		}, "existence_caching", nil
 	case *pb.BlobAccessConfiguration_Grpc:
-		client, err := bac.grpcClientFactory.NewClientFromConfiguration(backend.Grpc)
+		return bac.newGRPCCASBlobAccess(backend.Grpc, grpcclients.CASBlobAccessOptions{}, "grpc")
+	case *pb.BlobAccessConfiguration_GrpcCas:
+		if backend.GrpcCas.MaximumInFlightWriteBytes < 0 {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Maximum in-flight write bytes cannot be negative")
+		}
+		compressors := backend.GrpcCas.Compressors
+		return bac.newGRPCCASBlobAccess(backend.GrpcCas.Client, grpcclients.CASBlobAccessOptions{
+			ValidateReadSizes:         backend.GrpcCas.ValidateReadSizes,
+			ReadPrefetchChunks:        int(backend.GrpcCas.ReadPrefetchChunks),
+			MaximumInFlightWriteBytes: backend.GrpcCas.MaximumInFlightWriteBytes,
+			Compressors: grpcclients.CASCompressors{
+				Read:            compressors.GetRead(),
+				Write:           compressors.GetWrite(),
+				FindMissing:     compressors.GetFindMissing(),
+				GetCapabilities: compressors.GetGetCapabilities(),
+			},
+		}, "grpc_cas")
+	case *pb.BlobAccessConfiguration_HttpCas:
+		if backend.HttpCas.MaximumConcurrency <= 0 {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Maximum concurrency must be positive")
+		}
+		return BlobAccessInfo{
+			BlobAccess: blobstore.NewHTTPCASBlobAccess(
+				http.DefaultClient,
+				backend.HttpCas.Address,
+				int(backend.HttpCas.MaximumConcurrency)),
+			DigestKeyFormat: digest.KeyWithInstance,
+		}, "http_cas", nil
+	case *pb.BlobAccessConfiguration_Archive:
+		blobAccess, err := blobstore.NewArchiveBlobAccess(backend.Archive.Path)
 		if err != nil {
 			return BlobAccessInfo{}, "", err
 		}
-		// TODO: Should we provide a configuration option, so
-		// that digest.KeyWithoutInstance can be used?
 		return BlobAccessInfo{
-			BlobAccess:      grpcclients.NewCASBlobAccess(client, uuid.NewRandom, 65536),
-			DigestKeyFormat: digest.KeyWithInstance,
-		}, "grpc", nil
+			BlobAccess:      blobAccess,
+			DigestKeyFormat: digest.KeyWithoutInstance,
+		}, "archive", nil
+	case *pb.BlobAccessConfiguration_PutDeduplicating:
+		base, err := NewNestedBlobAccess(backend.PutDeduplicating, bac)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		return BlobAccessInfo{
+			BlobAccess:      blobstore.NewPutDeduplicatingBlobAccess(base.BlobAccess),
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "put_deduplicating", nil
+	case *pb.BlobAccessConfiguration_PutSkipping:
+		base, err := NewNestedBlobAccess(backend.PutSkipping, bac)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		return BlobAccessInfo{
+			BlobAccess:      blobstore.NewPutSkippingBlobAccess(base.BlobAccess),
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "put_skipping", nil
 	case *pb.BlobAccessConfiguration_ReferenceExpanding:
 		// The backend used by ReferenceExpandingBlobAccess is
 		// an Indirect Content Addressable Storage (ICAS). This
@@ -106,6 +166,19 @@ func (bac *casBlobAccessCreator) NewCustomBlobAccess(configuration *pb.BlobAcces
 	}
 }
 
+func (bac *casBlobAccessCreator) newGRPCCASBlobAccess(configuration *grpc_pb.ClientConfiguration, options grpcclients.CASBlobAccessOptions, backendType string) (BlobAccessInfo, string, error) {
+	client, err := bac.grpcClientFactory.NewClientFromConfiguration(configuration)
+	if err != nil {
+		return BlobAccessInfo{}, "", err
+	}
+	// TODO: Should we provide a configuration option, so
+	// that digest.KeyWithoutInstance can be used?
+	return BlobAccessInfo{
+		BlobAccess:      grpcclients.NewCASBlobAccess(client, uuid.NewRandom, 65536, options),
+		DigestKeyFormat: digest.KeyWithInstance,
+	}, backendType, nil
+}
+
 func (bac *casBlobAccessCreator) WrapTopLevelBlobAccess(blobAccess blobstore.BlobAccess) blobstore.BlobAccess {
 	// For the Content Addressable Storage it is required that the empty
 	// blob is always present. This decorator ensures that requests
@@ -33,6 +33,10 @@ func (bac *icasBlobAccessCreator) GetBaseDigestKeyFormat() digest.KeyFormat {
 	return digest.KeyWithoutInstance
 }
 
+func (bac *icasBlobAccessCreator) GetMaximumMessageSizeBytes() int {
+	return bac.maximumMessageSizeBytes
+}
+
 func (bac *icasBlobAccessCreator) GetReadBufferFactory() blobstore.ReadBufferFactory {
 	return blobstore.ICASReadBufferFactory
 }
@@ -4,10 +4,14 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"os"
 	"time"
 
 	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/circular"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
@@ -21,6 +25,7 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/filesystem"
 	"github.com/buildbarn/bb-storage/pkg/grpc"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/tracing"
 	"github.com/buildbarn/bb-storage/pkg/util"
 	"github.com/go-redis/redis"
 	"github.com/golang/protobuf/ptypes"
@@ -154,7 +159,13 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 			return BlobAccessInfo{}, "", err
 		}
 		return BlobAccessInfo{
-			BlobAccess:      readcaching.NewReadCachingBlobAccess(slow.BlobAccess, fast.BlobAccess, replicator),
+			BlobAccess: readcaching.NewReadCachingBlobAccess(
+				slow.BlobAccess,
+				fast.BlobAccess,
+				replicator,
+				int(backend.ReadCaching.MaximumPrefetchConcurrency),
+				backend.ReadCaching.MaximumPrefetchQueuedSizeBytes,
+				util.DefaultErrorLogger),
 			DigestKeyFormat: slow.DigestKeyFormat,
 		}, "read_caching", nil
 	case *pb.BlobAccessConfiguration_Redis:
@@ -255,17 +266,48 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 			return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Redis configuration must either be clustered or single server")
 		}
 
+		maximumBlobSizeBytes := backend.Redis.MaximumBlobSizeBytes
+		if maximumBlobSizeBytes == 0 {
+			maximumBlobSizeBytes = blobstore.RedisMaximumValueSizeBytes
+		} else if maximumBlobSizeBytes < 0 || maximumBlobSizeBytes > blobstore.RedisMaximumValueSizeBytes {
+			return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Maximum blob size must be between 0 and %d bytes", blobstore.RedisMaximumValueSizeBytes)
+		}
+
+		// The sizes of digests only correspond to the sizes of
+		// blobs for the Content Addressable Storage.
+		_, contentAddressed := creator.(*casBlobAccessCreator)
 		digestKeyFormat := creator.GetBaseDigestKeyFormat()
 		return BlobAccessInfo{
 			BlobAccess: blobstore.NewRedisBlobAccess(
 				redisClient,
 				readBufferFactory,
 				digestKeyFormat,
+				backend.Redis.KeyPrefix,
+				maximumBlobSizeBytes,
+				contentAddressed,
 				keyTTL,
 				backend.Redis.ReplicationCount,
 				replicationTimeout),
 			DigestKeyFormat: digestKeyFormat,
 		}, "redis", nil
+	case *pb.BlobAccessConfiguration_AwsS3:
+		if backend.AwsS3.MaximumFindMissingConcurrency <= 0 {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Maximum FindMissing() concurrency must be positive")
+		}
+		sess, err := aws.NewSessionFromConfiguration(backend.AwsS3.AwsSession)
+		if err != nil {
+			return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to create AWS session")
+		}
+		return BlobAccessInfo{
+			BlobAccess: blobstore.NewS3BlobAccess(
+				s3.New(sess),
+				s3manager.NewUploader(sess),
+				backend.AwsS3.Bucket,
+				backend.AwsS3.KeyPrefix,
+				readBufferFactory,
+				int(backend.AwsS3.MaximumFindMissingConcurrency)),
+			DigestKeyFormat: digest.KeyWithInstance,
+		}, "aws_s3", nil
 	case *pb.BlobAccessConfiguration_Remote:
 		return BlobAccessInfo{
 			BlobAccess:      blobstore.NewRemoteBlobAccess(backend.Remote.Address, storageTypeName, readBufferFactory),
@@ -340,8 +382,42 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 		if err != nil {
 			return BlobAccessInfo{}, "", err
 		}
+		var pendingReplicationQueue mirrored.PendingReplicationQueue
+		var reconciliationInterval time.Duration
+		if config := backend.Mirrored.PendingReplication; config != nil {
+			if config.MaximumQueueSize <= 0 {
+				return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Maximum pending replication queue size must be positive")
+			}
+			reconciliationInterval, err = ptypes.Duration(config.ReconciliationInterval)
+			if err != nil {
+				return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to obtain reconciliation interval")
+			}
+			if reconciliationInterval <= 0 {
+				return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Reconciliation interval must be positive")
+			}
+			if config.PersistentStatePath == "" {
+				pendingReplicationQueue = mirrored.NewInMemoryPendingReplicationQueue(int(config.MaximumQueueSize))
+			} else {
+				pendingReplicationQueue, err = mirrored.NewPersistentPendingReplicationQueue(config.PersistentStatePath, int(config.MaximumQueueSize))
+				if err != nil {
+					return BlobAccessInfo{}, "", err
+				}
+			}
+		}
+		blobAccess := mirrored.NewMirroredBlobAccess(backendA.BlobAccess, backendB.BlobAccess, replicatorAToB, replicatorBToA, pendingReplicationQueue)
+		if pendingReplicationQueue != nil {
+			// Periodically copy objects that were only
+			// written into backend A into backend B.
+			go func() {
+				for range time.NewTicker(reconciliationInterval).C {
+					if err := blobAccess.Reconcile(context.Background()); err != nil {
+						util.DefaultErrorLogger.Log(util.StatusWrap(err, "Failed to reconcile mirrored backends"))
+					}
+				}
+			}()
+		}
 		return BlobAccessInfo{
-			BlobAccess:      mirrored.NewMirroredBlobAccess(backendA.BlobAccess, backendB.BlobAccess, replicatorAToB, replicatorBToA),
+			BlobAccess:      blobAccess,
 			DigestKeyFormat: backendA.DigestKeyFormat.Combine(backendB.DigestKeyFormat),
 		}, "mirrored", nil
 	case *pb.BlobAccessConfiguration_Local:
@@ -387,6 +463,15 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 					readBufferFactory,
 					dataIntegrityCheckingCache)
 			}
+			if dataBackend.BlockDevice.DecompressOnRead {
+				// Decompression needs to be applied on top of
+				// validation caching, as cached buffers are
+				// returned without being passed to the base.
+				if storageTypeName != "cas" {
+					return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Decompression on read is only supported for the Content Addressable Storage")
+				}
+				cachedReadBufferFactory = blobstore.NewDecompressingReadBufferFactory(cachedReadBufferFactory)
+			}
 
 			blockAllocator = local.NewPartitioningBlockAllocator(
 				f,
@@ -437,6 +522,45 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 			BlobAccess:      readfallback.NewReadFallbackBlobAccess(primary.BlobAccess, secondary.BlobAccess, replicator),
 			DigestKeyFormat: primary.DigestKeyFormat.Combine(secondary.DigestKeyFormat),
 		}, "read_fallback", nil
+	case *pb.BlobAccessConfiguration_ReadFallbackChain:
+		if len(backend.ReadFallbackChain.Backends) == 0 {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Cannot create read fallback chain blob access without any backends")
+		}
+		backends := make([]blobstore.BlobAccess, 0, len(backend.ReadFallbackChain.Backends))
+		var combinedDigestKeyFormat digest.KeyFormat
+		for i, backendConfiguration := range backend.ReadFallbackChain.Backends {
+			backend, err := NewNestedBlobAccess(backendConfiguration, creator)
+			if err != nil {
+				return BlobAccessInfo{}, "", util.StatusWrapf(err, "Backend %d", i)
+			}
+			backends = append(backends, backend.BlobAccess)
+			if i == 0 {
+				combinedDigestKeyFormat = backend.DigestKeyFormat
+			} else {
+				combinedDigestKeyFormat = combinedDigestKeyFormat.Combine(backend.DigestKeyFormat)
+			}
+		}
+		return BlobAccessInfo{
+			BlobAccess: readfallback.NewReadFallbackChainBlobAccess(
+				backends,
+				backend.ReadFallbackChain.PutToAllBackends,
+				backend.ReadFallbackChain.ContinueOnError),
+			DigestKeyFormat: combinedDigestKeyFormat,
+		}, "read_fallback_chain", nil
+	case *pb.BlobAccessConfiguration_Drainable:
+		if backend.Drainable.Name == "" {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Drainable blob access requires a name")
+		}
+		base, err := NewNestedBlobAccess(backend.Drainable.Backend, creator)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		drainableBlobAccess := blobstore.NewDrainableBlobAccess(base.BlobAccess, backend.Drainable.Name)
+		blobstore.DefaultDrainingHTTPHandler.Register(backend.Drainable.Name, drainableBlobAccess)
+		return BlobAccessInfo{
+			BlobAccess:      drainableBlobAccess,
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "drainable", nil
 	case *pb.BlobAccessConfiguration_Demultiplexing:
 		// Construct a trie for each of the backends specified
 		// in the configuration indexed by instance name prefix.
@@ -478,6 +602,29 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 				}),
 			DigestKeyFormat: digest.KeyWithInstance,
 		}, "demultiplexing", nil
+	case *pb.BlobAccessConfiguration_SizeLimiting:
+		base, err := NewNestedBlobAccess(backend.SizeLimiting.Backend, creator)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		perInstanceMaximumSizeBytes := map[digest.InstanceName]int64{}
+		for k, v := range backend.SizeLimiting.PerInstanceMaximumSizeBytes {
+			instanceName, err := digest.NewInstanceName(k)
+			if err != nil {
+				return BlobAccessInfo{}, "", util.StatusWrapf(err, "Invalid instance name %#v", k)
+			}
+			perInstanceMaximumSizeBytes[instanceName] = v
+		}
+		return BlobAccessInfo{
+			BlobAccess: blobstore.NewSizeLimitingBlobAccess(
+				base.BlobAccess,
+				backend.SizeLimiting.MaximumSizeBytes,
+				func(instanceName digest.InstanceName) (int64, bool) {
+					maximumSizeBytes, ok := perInstanceMaximumSizeBytes[instanceName]
+					return maximumSizeBytes, ok
+				}),
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "size_limiting", nil
 	}
 	return creator.NewCustomBlobAccess(configuration)
 }
@@ -494,8 +641,12 @@ func NewNestedBlobAccess(configuration *pb.BlobAccessConfiguration, creator Blob
 	if err != nil {
 		return BlobAccessInfo{}, err
 	}
+	name := fmt.Sprintf("%s_%s", creator.GetStorageTypeName(), backendType)
 	return BlobAccessInfo{
-		BlobAccess:      blobstore.NewMetricsBlobAccess(backend.BlobAccess, clock.SystemClock, fmt.Sprintf("%s_%s", creator.GetStorageTypeName(), backendType)),
+		BlobAccess: blobstore.NewTracingBlobAccess(
+			blobstore.NewMetricsBlobAccess(backend.BlobAccess, clock.SystemClock, name),
+			name,
+			tracing.OpenCensusTracer),
 		DigestKeyFormat: backend.DigestKeyFormat,
 	}, nil
 }
@@ -545,39 +696,79 @@ func createCircularBlobAccess(config *pb.CircularBlobAccessConfiguration, creato
 		return nil, err
 	}
 	defer circularDirectory.Close()
-	dataFile, err := circularDirectory.OpenReadWrite("data", filesystem.CreateReuse(0644))
+	openFile := func(name string) (circular.ReadWriterAt, error) {
+		if config.ReadOnly {
+			// Don't create files, nor open them for
+			// writing, as storage may reside on a
+			// read-only mount.
+			f, err := circularDirectory.OpenRead(name)
+			if err != nil {
+				return nil, err
+			}
+			return circular.NewReadOnlyReadWriterAt(f), nil
+		}
+		return circularDirectory.OpenReadWrite(name, filesystem.CreateReuse(0644))
+	}
+	stateFile, err := openFile("state")
 	if err != nil {
 		return nil, err
 	}
-	stateFile, err := circularDirectory.OpenReadWrite("state", filesystem.CreateReuse(0644))
+
+	stateStore, err := circular.NewFileStateStore(stateFile, config.DataFileSizeBytes)
 	if err != nil {
 		return nil, err
 	}
 
+	// Optionally place a Bloom filter in front of offset files, so
+	// that lookups for absent blobs don't need to access them.
+	newOffsetStore := func(offsetFile circular.ReadWriterAt) (circular.OffsetStore, error) {
+		var offsetStore circular.OffsetStore
+		if config.StoreBlobMetadata {
+			offsetStore = circular.NewFileOffsetStoreWithMetadata(offsetFile, config.OffsetFileSizeBytes)
+		} else {
+			offsetStore = circular.NewFileOffsetStore(offsetFile, config.OffsetFileSizeBytes)
+		}
+		if config.BloomFilterSizeBytes > 0 {
+			var err error
+			offsetStore, err = circular.NewBloomFilterOffsetStore(
+				offsetStore,
+				stateStore.GetCursors(),
+				config.DataFileSizeBytes,
+				config.BloomFilterSizeBytes,
+				int(config.BloomFilterHashFunctions))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return circular.NewCachingOffsetStore(offsetStore, uint(config.OffsetCacheSize)), nil
+	}
+
 	var offsetStore circular.OffsetStore
 	switch creator.GetBaseDigestKeyFormat() {
 	case digest.KeyWithoutInstance:
 		// Open a single offset file for all entries. This is
 		// sufficient for the Content Addressable Storage.
-		offsetFile, err := circularDirectory.OpenReadWrite("offset", filesystem.CreateReuse(0644))
+		offsetFile, err := openFile("offset")
+		if err != nil {
+			return nil, err
+		}
+		offsetStore, err = newOffsetStore(offsetFile)
 		if err != nil {
 			return nil, err
 		}
-		offsetStore = circular.NewCachingOffsetStore(
-			circular.NewFileOffsetStore(offsetFile, config.OffsetFileSizeBytes),
-			uint(config.OffsetCacheSize))
 	case digest.KeyWithInstance:
 		// Open an offset file for every instance. This is
 		// required for the Action Cache.
 		offsetStores := map[string]circular.OffsetStore{}
 		for _, instance := range config.Instances {
-			offsetFile, err := circularDirectory.OpenReadWrite("offset."+instance, filesystem.CreateReuse(0644))
+			offsetFile, err := openFile("offset." + instance)
 			if err != nil {
 				return nil, err
 			}
-			offsetStores[instance] = circular.NewCachingOffsetStore(
-				circular.NewFileOffsetStore(offsetFile, config.OffsetFileSizeBytes),
-				uint(config.OffsetCacheSize))
+			offsetStores[instance], err = newOffsetStore(offsetFile)
+			if err != nil {
+				return nil, util.StatusWrapf(err, "Failed to create offset store for instance %#v", instance)
+			}
 		}
 		offsetStore = circular.NewDemultiplexingOffsetStore(func(instance string) (circular.OffsetStore, error) {
 			offsetStore, ok := offsetStores[instance]
@@ -587,17 +778,161 @@ func createCircularBlobAccess(config *pb.CircularBlobAccessConfiguration, creato
 			return offsetStore, nil
 		})
 	}
-	stateStore, err := circular.NewFileStateStore(stateFile, config.DataFileSizeBytes)
-	if err != nil {
-		return nil, err
+
+	readBufferFactory := creator.GetReadBufferFactory()
+	if config.TreatOversizedActionResultsAsMissing {
+		acCreator, ok := creator.(*acBlobAccessCreator)
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "Oversized action results can only be treated as missing for the Action Cache")
+		}
+		readBufferFactory = blobstore.NewACReadBufferFactory(acCreator.maximumMessageSizeBytes, true)
+	}
+
+	var dataStore circular.DataStore
+	if segmentSizeBytes := config.DataSegmentSizeBytes; segmentSizeBytes > 0 {
+		// Store data in a directory of segment files.
+		if config.DataFileSizeBytes%segmentSizeBytes != 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "Data file size %d is not a multiple of the data segment size %d", config.DataFileSizeBytes, segmentSizeBytes)
+		}
+		if config.MemoryMapDataFile {
+			return nil, status.Error(codes.InvalidArgument, "Data segments cannot be memory mapped")
+		}
+		if config.DataAllocationAlignmentBytes > 1 {
+			return nil, status.Error(codes.InvalidArgument, "Data allocation alignment cannot be used in combination with data segments")
+		}
+		if !config.ReadOnly {
+			if err := circularDirectory.Mkdir("data_segments", 0777); err != nil && !os.IsExist(err) {
+				return nil, util.StatusWrap(err, "Failed to create data segment directory")
+			}
+		}
+		segmentDirectory, err := circularDirectory.EnterDirectory("data_segments")
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to open data segment directory")
+		}
+		dataStore, err = circular.NewSegmentedDataStore(segmentDirectory, segmentSizeBytes, config.DataFileSizeBytes/segmentSizeBytes)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Alignment is applied to offsets before they wrap around
+		// at the end of the data file. Writes to the data file are
+		// thus only aligned if its size is a multiple of the
+		// alignment.
+		if alignmentBytes := config.DataAllocationAlignmentBytes; alignmentBytes > 1 && config.DataFileSizeBytes%alignmentBytes != 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "Data file size %d is not a multiple of the data allocation alignment %d", config.DataFileSizeBytes, alignmentBytes)
+		}
+		dataFile, err := openFile("data")
+		if err != nil {
+			return nil, err
+		}
+		dataStore = circular.NewFileDataStore(dataFile, config.DataFileSizeBytes)
+		if config.MemoryMapDataFile {
+			memoryMappableDataFile, ok := dataFile.(circular.MemoryMappableFile)
+			if !ok {
+				return nil, status.Error(codes.InvalidArgument, "Data file cannot be memory mapped")
+			}
+			dataStore, err = circular.NewMemoryMappedDataStore(memoryMappableDataFile, config.DataFileSizeBytes)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if config.ValidateOnPut {
+		if _, ok := creator.(*casBlobAccessCreator); !ok {
+			return nil, status.Error(codes.InvalidArgument, "Blobs can only be validated on write for the Content Addressable Storage")
+		}
+	}
+
+	var blobMetadataFunc circular.BlobMetadataFunc
+	if config.StoreBlobMetadata {
+		blobMetadataFunc = circular.NewUploaderBlobMetadataFunc(clock.SystemClock)
+	}
+
+	var repairer blobstore.BlobRepairer
+	if config.RepairPeer != nil {
+		if config.ReadOnly {
+			return nil, status.Error(codes.InvalidArgument, "Corrupted blobs cannot be repaired if the storage backend is read-only")
+		}
+		peer, err := NewNestedBlobAccess(config.RepairPeer, creator)
+		if err != nil {
+			return nil, err
+		}
+		var maximumDelay time.Duration
+		if config.RepairMaximumDelay != nil {
+			maximumDelay, err = ptypes.Duration(config.RepairMaximumDelay)
+			if err != nil {
+				return nil, util.StatusWrap(err, "Failed to obtain repair maximum delay")
+			}
+		}
+		timeout := time.Minute
+		if config.RepairTimeout != nil {
+			timeout, err = ptypes.Duration(config.RepairTimeout)
+			if err != nil {
+				return nil, util.StatusWrap(err, "Failed to obtain repair timeout")
+			}
+		}
+		repairer = blobstore.NewPeerBlobRepairer(peer.BlobAccess, clock.SystemClock, maximumDelay, timeout, util.DefaultErrorLogger)
 	}
 
+	if config.ReadOnly {
+		if config.BlobTtl != nil {
+			return nil, status.Error(codes.InvalidArgument, "Blobs cannot expire if the storage backend is read-only")
+		}
+		return blobstore.NewReadOnlyBlobAccess(
+			circular.NewCircularBlobAccess(
+				offsetStore,
+				dataStore,
+				circular.NewReadOnlyStateStore(stateStore),
+				readBufferFactory,
+				creator.GetMaximumMessageSizeBytes(),
+				buffer.NewTemporarySpillFile,
+				config.ValidateOnPut,
+				nil,
+				util.DefaultErrorLogger,
+				blobMetadataFunc)), nil
+	}
+	var writableStateStore circular.StateStore
+	if config.DataSegmentSizeBytes > 0 {
+		// Segments require that space is allocated at the
+		// write cursor, which prevents bulk allocation.
+		writableStateStore = circular.NewSegmentAligningStateStore(stateStore, config.DataSegmentSizeBytes)
+	} else {
+		writableStateStore = circular.NewBulkAllocatingStateStore(
+			stateStore,
+			config.DataAllocationChunkSizeBytes,
+			config.DataAllocationAlignmentBytes)
+	}
+	if config.BlobTtl != nil {
+		blobTTL, err := ptypes.Duration(config.BlobTtl)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to obtain blob TTL")
+		}
+		if blobTTL <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "Blob TTL must be positive")
+		}
+
+		// Periodically expire blobs that have been stored for
+		// longer than the TTL.
+		expiringStateStore := circular.NewExpiringStateStore(writableStateStore, clock.SystemClock)
+		writableStateStore = expiringStateStore
+		go func() {
+			for range time.NewTicker(blobTTL / 10).C {
+				if err := expiringStateStore.ExpireOlderThan(clock.SystemClock.Now().Add(-blobTTL)); err != nil {
+					util.DefaultErrorLogger.Log(util.StatusWrap(err, "Failed to expire blobs"))
+				}
+			}
+		}()
+	}
 	return circular.NewCircularBlobAccess(
 		offsetStore,
-		circular.NewFileDataStore(dataFile, config.DataFileSizeBytes),
-		circular.NewPositiveSizedBlobStateStore(
-			circular.NewBulkAllocatingStateStore(
-				stateStore,
-				config.DataAllocationChunkSizeBytes)),
-		creator.GetReadBufferFactory()), nil
+		dataStore,
+		circular.NewPositiveSizedBlobStateStore(writableStateStore),
+		readBufferFactory,
+		creator.GetMaximumMessageSizeBytes(),
+		buffer.NewTemporarySpillFile,
+		config.ValidateOnPut,
+		repairer,
+		util.DefaultErrorLogger,
+		blobMetadataFunc), nil
 }
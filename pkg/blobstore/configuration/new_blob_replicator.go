@@ -31,6 +31,15 @@ func NewBlobReplicatorFromConfiguration(configuration *pb.BlobReplicatorConfigur
 			return nil, err
 		}
 		return replication.NewQueuedBlobReplicator(source, base, existenceCache), nil
+	case *pb.BlobReplicatorConfiguration_ConcurrencyLimiting:
+		base, err := NewBlobReplicatorFromConfiguration(mode.ConcurrencyLimiting.Base, source, sink, creator)
+		if err != nil {
+			return nil, err
+		}
+		if mode.ConcurrencyLimiting.MaximumConcurrency <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "Maximum concurrency must be positive")
+		}
+		return replication.NewConcurrencyLimitingBlobReplicator(source, base, int(mode.ConcurrencyLimiting.MaximumConcurrency)), nil
 	default:
 		return creator.NewCustomBlobReplicator(configuration, source, sink)
 	}
@@ -0,0 +1,131 @@
+package blobstore
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+)
+
+// gzipMagic is the header with which every gzip stream starts.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+type decompressingReadBufferFactory struct {
+	base ReadBufferFactory
+}
+
+// NewDecompressingReadBufferFactory creates a decorator for
+// ReadBufferFactory that transparently decompresses gzip compressed
+// blobs. Blobs that are not compressed are passed on as is. This makes
+// it possible to enable compression of data at rest, without needing
+// to rewrite data that was stored previously. Data integrity checking
+// is performed against the decompressed data.
+//
+// Whether a blob is compressed is determined by comparing its stored
+// size against the size in its digest. Only if these differ and the
+// data starts with the gzip magic number, it is decompressed. This
+// prevents uncompressed blobs that happen to start with the gzip magic
+// number from being misdetected. Writers must therefore store blobs
+// uncompressed if compressing them does not alter their size.
+//
+// Buffers created through NewBufferFromReader() have no known stored
+// size. As the magic number alone cannot be used to distinguish
+// compressed blobs from uncompressed blobs that start with it, their
+// data is passed on as is. Storage backends that may contain compressed
+// blobs must therefore use NewBufferFromByteSlice() or
+// NewBufferFromFileReader().
+//
+// This decorator may only be used in combination with the Content
+// Addressable Storage (CAS), as the sizes in digests of other storage
+// types do not correspond to the size of the data.
+func NewDecompressingReadBufferFactory(base ReadBufferFactory) ReadBufferFactory {
+	return &decompressingReadBufferFactory{
+		base: base,
+	}
+}
+
+func (f *decompressingReadBufferFactory) NewBufferFromByteSlice(blobDigest digest.Digest, data []byte, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	if int64(len(data)) == blobDigest.GetSizeBytes() || !bytes.HasPrefix(data, gzipMagic) {
+		return f.base.NewBufferFromByteSlice(blobDigest, data, dataIntegrityCallback)
+	}
+	return f.base.NewBufferFromReader(blobDigest, newGzipDecompressingReader(ioutil.NopCloser(bytes.NewReader(data)), dataIntegrityCallback), dataIntegrityCallback)
+}
+
+func (f *decompressingReadBufferFactory) NewBufferFromReader(blobDigest digest.Digest, r io.ReadCloser, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	return f.base.NewBufferFromReader(blobDigest, r, dataIntegrityCallback)
+}
+
+func (f *decompressingReadBufferFactory) NewBufferFromFileReader(blobDigest digest.Digest, r filesystem.FileReader, sizeBytes int64, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	if sizeBytes != blobDigest.GetSizeBytes() {
+		magic := make([]byte, len(gzipMagic))
+		if n, _ := r.ReadAt(magic, 0); n == len(magic) && bytes.Equal(magic, gzipMagic) {
+			return f.base.NewBufferFromReader(blobDigest, newGzipDecompressingReader(&struct {
+				io.Reader
+				io.Closer
+			}{
+				Reader: io.NewSectionReader(r, 0, sizeBytes),
+				Closer: r,
+			}, dataIntegrityCallback), dataIntegrityCallback)
+		}
+	}
+	return f.base.NewBufferFromFileReader(blobDigest, r, sizeBytes, dataIntegrityCallback)
+}
+
+// gzipDecompressingReader is a decompressing reader for gzip streams.
+// Construction of the gzip reader is deferred until the first read, so
+// that errors in the header are reported through the buffer.
+//
+// Malformed gzip streams are reported as data integrity errors, so that
+// they are treated the same way as checksum mismatches of uncompressed
+// data. As the base buffer only validates the decompressed data, the
+// data integrity callback is invoked directly.
+type gzipDecompressingReader struct {
+	r                     io.ReadCloser
+	dataIntegrityCallback buffer.DataIntegrityCallback
+
+	gzip *gzip.Reader
+	err  error
+}
+
+func newGzipDecompressingReader(r io.ReadCloser, dataIntegrityCallback buffer.DataIntegrityCallback) io.ReadCloser {
+	return &gzipDecompressingReader{
+		r:                     r,
+		dataIntegrityCallback: dataIntegrityCallback,
+	}
+}
+
+func (r *gzipDecompressingReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.gzip == nil {
+		gr, err := gzip.NewReader(r.r)
+		if err != nil {
+			return 0, r.convertError(err)
+		}
+		r.gzip = gr
+	}
+	n, err := r.gzip.Read(p)
+	return n, r.convertError(err)
+}
+
+func (r *gzipDecompressingReader) convertError(err error) error {
+	if _, ok := err.(flate.CorruptInputError); ok || err == gzip.ErrHeader || err == gzip.ErrChecksum || err == io.ErrUnexpectedEOF {
+		r.dataIntegrityCallback(false)
+		r.err = buffer.MarkDataIntegrityError(util.StatusWrapWithCode(err, codes.DataLoss, "Failed to decompress blob"))
+		return r.err
+	}
+	return err
+}
+
+func (r *gzipDecompressingReader) Close() error {
+	return r.r.Close()
+}
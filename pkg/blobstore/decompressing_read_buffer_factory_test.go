@@ -0,0 +1,127 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDecompressingReadBufferFactory(t *testing.T) {
+	ctrl, _ := gomock.WithContext(context.Background(), t)
+
+	readBufferFactory := blobstore.NewDecompressingReadBufferFactory(blobstore.CASReadBufferFactory)
+	blobDigest := digest.MustNewDigest("hello", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	_, err := w.Write([]byte("Hello world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	t.Run("ByteSliceUncompressed", func(t *testing.T) {
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+
+		data, err := readBufferFactory.NewBufferFromByteSlice(blobDigest, []byte("Hello world"), dataIntegrityCallback.Call).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("ByteSliceCompressed", func(t *testing.T) {
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+
+		data, err := readBufferFactory.NewBufferFromByteSlice(blobDigest, compressed.Bytes(), dataIntegrityCallback.Call).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("ByteSliceUncompressedWithMagic", func(t *testing.T) {
+		// Uncompressed blobs that happen to start with the gzip
+		// magic number should not be decompressed, as their
+		// size matches the one in the digest.
+		rawData := []byte("\x1f\x8bHello world")
+		generator := blobDigest.NewGenerator()
+		generator.Write(rawData)
+		rawDigest := generator.Sum()
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+
+		data, err := readBufferFactory.NewBufferFromByteSlice(rawDigest, rawData, dataIntegrityCallback.Call).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, rawData, data)
+	})
+
+	t.Run("FileReaderCompressed", func(t *testing.T) {
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+		fileReader := mock.NewMockFileReader(ctrl)
+		fileReader.EXPECT().ReadAt(gomock.Any(), gomock.Any()).DoAndReturn(bytes.NewReader(compressed.Bytes()).ReadAt).AnyTimes()
+		fileReader.EXPECT().Close()
+
+		data, err := readBufferFactory.NewBufferFromFileReader(blobDigest, fileReader, int64(compressed.Len()), dataIntegrityCallback.Call).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	// A gzip file that is stored as a blob of its own has a digest
+	// whose size matches the stored size. It should be returned as
+	// is, regardless of how it is read.
+	generator := blobDigest.NewGenerator()
+	generator.Write(compressed.Bytes())
+	gzipFileDigest := generator.Sum()
+
+	t.Run("FileReaderGzipFileUncompressed", func(t *testing.T) {
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+		fileReader := mock.NewMockFileReader(ctrl)
+		fileReader.EXPECT().ReadAt(gomock.Any(), gomock.Any()).DoAndReturn(bytes.NewReader(compressed.Bytes()).ReadAt).AnyTimes()
+		fileReader.EXPECT().Close()
+
+		data, err := readBufferFactory.NewBufferFromFileReader(gzipFileDigest, fileReader, int64(compressed.Len()), dataIntegrityCallback.Call).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, compressed.Bytes(), data)
+	})
+
+	t.Run("ReaderGzipFileUncompressed", func(t *testing.T) {
+		// Readers don't provide the stored size, meaning their
+		// data should never be decompressed.
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+
+		data, err := readBufferFactory.NewBufferFromReader(gzipFileDigest, ioutil.NopCloser(bytes.NewReader(compressed.Bytes())), dataIntegrityCallback.Call).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, compressed.Bytes(), data)
+	})
+
+	t.Run("ReaderUncompressed", func(t *testing.T) {
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+
+		data, err := readBufferFactory.NewBufferFromReader(blobDigest, ioutil.NopCloser(bytes.NewBufferString("Hello world")), dataIntegrityCallback.Call).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("CorruptedCompressedData", func(t *testing.T) {
+		// Compressed data that cannot be decompressed should be
+		// reported as being corrupted.
+		corrupted := append([]byte(nil), compressed.Bytes()[:compressed.Len()-4]...)
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(false)
+
+		_, err := readBufferFactory.NewBufferFromByteSlice(blobDigest, corrupted, dataIntegrityCallback.Call).ToByteSlice(100)
+		require.Equal(t, codes.DataLoss, status.Code(err))
+	})
+}
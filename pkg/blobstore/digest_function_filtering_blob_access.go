@@ -0,0 +1,95 @@
+package blobstore
+
+import (
+	"context"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AllowedDigestFunctionsGetter is the callback type used by
+// DigestFunctionFilteringBlobAccess to obtain the list of digest
+// functions that may be used for a given instance name.
+type AllowedDigestFunctionsGetter func(instanceName digest.InstanceName) []remoteexecution.DigestFunction_Value
+
+type digestFunctionFilteringBlobAccess struct {
+	BlobAccess
+	allowedDigestFunctionsGetter AllowedDigestFunctionsGetter
+}
+
+// NewDigestFunctionFilteringBlobAccess creates a decorator for
+// BlobAccess that only permits access to blobs whose digest function
+// is allowed for the instance name. This can be used to prevent
+// instance names from storing blobs using a mixture of digest
+// functions. Requests for blobs using other digest functions fail with
+// INVALID_ARGUMENT.
+func NewDigestFunctionFilteringBlobAccess(base BlobAccess, allowedDigestFunctionsGetter AllowedDigestFunctionsGetter) BlobAccess {
+	return &digestFunctionFilteringBlobAccess{
+		BlobAccess:                   base,
+		allowedDigestFunctionsGetter: allowedDigestFunctionsGetter,
+	}
+}
+
+func (ba *digestFunctionFilteringBlobAccess) checkDigest(blobDigest digest.Digest) error {
+	digestFunction := blobDigest.GetDigestFunction()
+	instanceName := blobDigest.GetInstanceName()
+	for _, allowedDigestFunction := range ba.allowedDigestFunctionsGetter(instanceName) {
+		if digestFunction == allowedDigestFunction {
+			return nil
+		}
+	}
+	return status.Errorf(codes.InvalidArgument, "Digest function %s is not permitted for instance name %#v", digestFunction, instanceName.String())
+}
+
+func (ba *digestFunctionFilteringBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	if err := ba.checkDigest(digest); err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return ba.BlobAccess.Get(ctx, digest)
+}
+
+func (ba *digestFunctionFilteringBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if err := ba.checkDigest(digest); err != nil {
+		b.Discard()
+		return err
+	}
+	return ba.BlobAccess.Put(ctx, digest, b)
+}
+
+func (ba *digestFunctionFilteringBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Reject the request as a whole, as opposed to omitting
+	// disallowed digests from the results. Omitting them would
+	// cause the client to assume they are present.
+	for _, blobDigest := range digests.Items() {
+		if err := ba.checkDigest(blobDigest); err != nil {
+			return digest.EmptySet, err
+		}
+	}
+	return ba.BlobAccess.FindMissing(ctx, digests)
+}
+
+func (ba *digestFunctionFilteringBlobAccess) GetCapabilities(ctx context.Context, instanceName digest.InstanceName) (Capabilities, error) {
+	capabilities, err := GetCapabilities(ctx, ba.BlobAccess, instanceName)
+	if err != nil {
+		return Capabilities{}, err
+	}
+
+	// Only announce digest functions that are both supported by
+	// the backend and allowed for the instance name.
+	allowedDigestFunctions := ba.allowedDigestFunctionsGetter(instanceName)
+	digestFunctions := make([]remoteexecution.DigestFunction_Value, 0, len(capabilities.DigestFunctions))
+	for _, digestFunction := range capabilities.DigestFunctions {
+		for _, allowedDigestFunction := range allowedDigestFunctions {
+			if digestFunction == allowedDigestFunction {
+				digestFunctions = append(digestFunctions, digestFunction)
+				break
+			}
+		}
+	}
+	capabilities.DigestFunctions = digestFunctions
+	return capabilities, nil
+}
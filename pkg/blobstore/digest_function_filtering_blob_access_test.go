@@ -0,0 +1,100 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDigestFunctionFilteringBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewDigestFunctionFilteringBlobAccess(
+		baseBlobAccess,
+		func(instanceName digest.InstanceName) []remoteexecution.DigestFunction_Value {
+			if instanceName == digest.MustNewInstanceName("legacy") {
+				return []remoteexecution.DigestFunction_Value{
+					remoteexecution.DigestFunction_MD5,
+					remoteexecution.DigestFunction_SHA256,
+				}
+			}
+			return []remoteexecution.DigestFunction_Value{
+				remoteexecution.DigestFunction_SHA256,
+			}
+		})
+	md5Digest := digest.MustNewDigest("default", "8b1a9953c4611296a827abf8c47804d7", 5)
+	sha256Digest := digest.MustNewDigest("default", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+	legacyMD5Digest := digest.MustNewDigest("legacy", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("GetDisallowed", func(t *testing.T) {
+		_, err := blobAccess.Get(ctx, md5Digest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Digest function MD5 is not permitted for instance name \"default\""), err)
+	})
+
+	t.Run("GetAllowed", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, legacyMD5Digest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, legacyMD5Digest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("PutDisallowed", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Digest function MD5 is not permitted for instance name \"default\""),
+			blobAccess.Put(ctx, md5Digest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("PutAllowed", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Put(ctx, sha256Digest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, sha256Digest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("FindMissingDisallowed", func(t *testing.T) {
+		// Disallowed digests should cause the request to fail,
+		// as opposed to them being dropped silently.
+		_, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(md5Digest).Add(sha256Digest).Build())
+		require.Equal(t, status.Error(codes.InvalidArgument, "Digest function MD5 is not permitted for instance name \"default\""), err)
+	})
+
+	t.Run("FindMissingAllowed", func(t *testing.T) {
+		digests := digest.NewSetBuilder().Add(legacyMD5Digest).Add(sha256Digest).Build()
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(sha256Digest.ToSingletonSet(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, sha256Digest.ToSingletonSet(), missing)
+	})
+
+	t.Run("GetCapabilities", func(t *testing.T) {
+		// Only digest functions that are allowed for the
+		// instance name should be announced.
+		capabilities, err := blobstore.GetCapabilities(ctx, blobAccess, digest.MustNewInstanceName("legacy"))
+		require.NoError(t, err)
+		require.Equal(t, blobstore.Capabilities{
+			DigestFunctions: []remoteexecution.DigestFunction_Value{
+				remoteexecution.DigestFunction_MD5,
+				remoteexecution.DigestFunction_SHA256,
+			},
+		}, capabilities)
+	})
+}
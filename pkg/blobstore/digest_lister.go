@@ -0,0 +1,33 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DigestLister is an optional capability of BlobAccess implementations
+// that are capable of enumerating the blobs they store. It may be used
+// by tooling for verifying or migrating the contents of a storage
+// backend.
+type DigestLister interface {
+	// ListDigests calls a callback for every blob that is stored
+	// for a given instance name. Results are streamed, as opposed
+	// to being collected in memory. Iteration stops as soon as the
+	// callback returns an error, which is then returned.
+	ListDigests(ctx context.Context, instanceName digest.InstanceName, callback func(digest digest.Digest) error) error
+}
+
+// ListDigests calls a callback for every blob that is stored by a
+// storage backend for a given instance name. If the BlobAccess does
+// not implement DigestLister, an error with code UNIMPLEMENTED is
+// returned.
+func ListDigests(ctx context.Context, blobAccess BlobAccess, instanceName digest.InstanceName, callback func(digest digest.Digest) error) error {
+	if digestLister, ok := blobAccess.(DigestLister); ok {
+		return digestLister.ListDigests(ctx, instanceName, callback)
+	}
+	return status.Error(codes.Unimplemented, "Storage backend does not support listing digests")
+}
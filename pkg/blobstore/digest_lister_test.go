@@ -0,0 +1,65 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// digestListingBlobAccess is a BlobAccess that implements
+// DigestLister, whose calls are forwarded to mocks.
+type digestListingBlobAccess struct {
+	*mock.MockBlobAccess
+	*mock.MockDigestLister
+}
+
+func TestListDigests(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	instanceName := digest.MustNewInstanceName("hello")
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Unimplemented", func(t *testing.T) {
+		blobAccess := mock.NewMockBlobAccess(ctrl)
+
+		require.Equal(
+			t,
+			status.Error(codes.Unimplemented, "Storage backend does not support listing digests"),
+			blobstore.ListDigests(ctx, blobAccess, instanceName, func(digest.Digest) error {
+				t.Fatal("Callback should not be invoked")
+				return nil
+			}))
+	})
+
+	t.Run("ThroughAdapters", func(t *testing.T) {
+		// The adapters that are placed in front of every
+		// backend should forward calls to ListDigests().
+		backend := digestListingBlobAccess{
+			MockBlobAccess:   mock.NewMockBlobAccess(ctrl),
+			MockDigestLister: mock.NewMockDigestLister(ctrl),
+		}
+		backend.MockDigestLister.EXPECT().ListDigests(ctx, instanceName, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, instanceName digest.InstanceName, callback func(digest digest.Digest) error) error {
+				return callback(helloDigest)
+			})
+		blobAccess := blobstore.NewTracingBlobAccess(
+			blobstore.NewMetricsBlobAccess(backend, mock.NewMockClock(ctrl), "digest_lister_test"),
+			"digest_lister_test",
+			mock.NewMockTracer(ctrl))
+
+		var digests []digest.Digest
+		require.NoError(t, blobstore.ListDigests(ctx, blobAccess, instanceName, func(blobDigest digest.Digest) error {
+			digests = append(digests, blobDigest)
+			return nil
+		}))
+		require.Equal(t, []digest.Digest{helloDigest}, digests)
+	})
+}
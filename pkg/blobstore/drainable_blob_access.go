@@ -0,0 +1,82 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	drainableBlobAccessPrometheusMetrics sync.Once
+
+	drainableBlobAccessDraining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "drainable_blob_access_draining",
+			Help:      "Whether the backend is being drained, meaning that writes are rejected.",
+		},
+		[]string{"name"})
+)
+
+// DrainableBlobAccess is a BlobAccess that can be put in a draining
+// state, in which writes are rejected.
+type DrainableBlobAccess interface {
+	BlobAccess
+
+	// SetDraining enables or disables draining. While draining,
+	// calls to Put() fail with UNAVAILABLE, while calls to Get()
+	// and FindMissing() are forwarded to the backend.
+	SetDraining(draining bool)
+}
+
+type drainableBlobAccess struct {
+	BlobAccess
+
+	draining      uint32
+	drainingGauge prometheus.Gauge
+}
+
+// NewDrainableBlobAccess creates a decorator for BlobAccess that can be
+// used to gracefully remove a backend from a deployment. Once draining
+// is enabled, writes are rejected with UNAVAILABLE, causing clients
+// (e.g., ones using MirroredBlobAccess or ShardingBlobAccess) to fail
+// over to other backends. Data that is already stored remains
+// accessible until the backend is taken down.
+func NewDrainableBlobAccess(base BlobAccess, name string) DrainableBlobAccess {
+	drainableBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(drainableBlobAccessDraining)
+	})
+
+	drainingGauge := drainableBlobAccessDraining.WithLabelValues(name)
+	drainingGauge.Set(0)
+	return &drainableBlobAccess{
+		BlobAccess:    base,
+		drainingGauge: drainingGauge,
+	}
+}
+
+func (ba *drainableBlobAccess) SetDraining(draining bool) {
+	if draining {
+		atomic.StoreUint32(&ba.draining, 1)
+		ba.drainingGauge.Set(1)
+	} else {
+		atomic.StoreUint32(&ba.draining, 0)
+		ba.drainingGauge.Set(0)
+	}
+}
+
+func (ba *drainableBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if atomic.LoadUint32(&ba.draining) != 0 {
+		b.Discard()
+		return status.Error(codes.Unavailable, "Backend is being drained")
+	}
+	return ba.BlobAccess.Put(ctx, digest, b)
+}
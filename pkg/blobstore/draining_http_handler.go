@@ -0,0 +1,64 @@
+package blobstore
+
+import (
+	"net/http"
+	"sync"
+)
+
+// DrainingHTTPHandler is an HTTP handler that can be used by
+// orchestration tooling to toggle draining of instances of
+// DrainableBlobAccess by name. Requests need to provide the name of the
+// backend through the "name" query parameter. POST requests enable
+// draining, while DELETE requests disable it.
+type DrainingHTTPHandler struct {
+	lock     sync.Mutex
+	backends map[string][]DrainableBlobAccess
+}
+
+// DefaultDrainingHTTPHandler is the DrainingHTTPHandler against which
+// backends created from configuration files are registered.
+var DefaultDrainingHTTPHandler = NewDrainingHTTPHandler()
+
+// NewDrainingHTTPHandler creates a DrainingHTTPHandler that does not
+// have any backends registered.
+func NewDrainingHTTPHandler() *DrainingHTTPHandler {
+	return &DrainingHTTPHandler{
+		backends: map[string][]DrainableBlobAccess{},
+	}
+}
+
+// Register a DrainableBlobAccess, so that its draining state can be
+// changed through HTTP. Multiple backends may be registered under the
+// same name (e.g., one for the Content Addressable Storage and one for
+// the Action Cache), in which case they are toggled together.
+func (h *DrainingHTTPHandler) Register(name string, blobAccess DrainableBlobAccess) {
+	h.lock.Lock()
+	h.backends[name] = append(h.backends[name], blobAccess)
+	h.lock.Unlock()
+}
+
+func (h *DrainingHTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var draining bool
+	switch r.Method {
+	case http.MethodPost:
+		draining = true
+	case http.MethodDelete:
+		draining = false
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "Only POST and DELETE requests are supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	h.lock.Lock()
+	backends, ok := h.backends[name]
+	h.lock.Unlock()
+	if !ok {
+		http.Error(w, "No drainable backend with this name exists", http.StatusNotFound)
+		return
+	}
+	for _, backend := range backends {
+		backend.SetDraining(draining)
+	}
+}
@@ -0,0 +1,58 @@
+package blobstore_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDrainingHTTPHandler(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewDrainableBlobAccess(baseBlobAccess, "shard0")
+	handler := blobstore.NewDrainingHTTPHandler()
+	handler.Register("shard0", blobAccess)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	serve := func(method string, target string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w.Code
+	}
+
+	t.Run("UnknownName", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/-/drain?name=shard1"))
+	})
+
+	t.Run("UnsupportedMethod", func(t *testing.T) {
+		require.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodGet, "/-/drain?name=shard0"))
+	})
+
+	t.Run("Drain", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, serve(http.MethodPost, "/-/drain?name=shard0"))
+
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Backend is being drained"),
+			blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("Undrain", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, serve(http.MethodDelete, "/-/drain?name=shard0"))
+
+		baseBlobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).Return(nil)
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+}
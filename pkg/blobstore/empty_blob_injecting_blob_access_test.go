@@ -53,7 +53,7 @@ func TestEmptyBlobInjectingBlobAccessGet(t *testing.T) {
 	t.Run("EmptyInvalid", func(t *testing.T) {
 		// Validation should still be performed on empty blobs.
 		_, err := blobAccess.Get(ctx, digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 0)).ToByteSlice(0)
-		require.Equal(t, err, status.Error(codes.InvalidArgument, "Buffer has checksum d41d8cd98f00b204e9800998ecf8427e, while 3e25960a79dbc69b674cd4ec67a72c62 was expected"))
+		require.Equal(t, err, buffer.MarkDataIntegrityError(status.Error(codes.InvalidArgument, "Buffer has checksum d41d8cd98f00b204e9800998ecf8427e, while 3e25960a79dbc69b674cd4ec67a72c62 was expected")))
 	})
 }
 
@@ -0,0 +1,45 @@
+package blobstore
+
+import (
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorClass describes the reason an operation against a BlobAccess
+// failed, in a way that decorators can act upon.
+type ErrorClass int
+
+const (
+	// ErrorClassOther is used for errors that don't fall in any of
+	// the other classes.
+	ErrorClassOther ErrorClass = iota
+	// ErrorClassNotFound indicates that the blob is absent.
+	ErrorClassNotFound
+	// ErrorClassDataIntegrity indicates that the blob is present,
+	// but that its contents failed integrity checks, such as the
+	// size or checksum not matching the digest.
+	ErrorClassDataIntegrity
+	// ErrorClassUnavailable indicates that the backend could not be
+	// reached. Retrying the operation may succeed.
+	ErrorClassUnavailable
+)
+
+// ClassifyError determines the ErrorClass of an error returned by a
+// BlobAccess. Data integrity errors are identified through the details
+// attached to them by buffer.MarkDataIntegrityError(), as their status
+// code depends on where the data originated.
+func ClassifyError(err error) ErrorClass {
+	if buffer.IsDataIntegrityError(err) {
+		return ErrorClassDataIntegrity
+	}
+	switch status.Code(err) {
+	case codes.NotFound:
+		return ErrorClassNotFound
+	case codes.Unavailable:
+		return ErrorClassUnavailable
+	default:
+		return ErrorClassOther
+	}
+}
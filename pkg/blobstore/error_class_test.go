@@ -0,0 +1,40 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassifyError(t *testing.T) {
+	t.Run("NotFound", func(t *testing.T) {
+		require.Equal(t, blobstore.ErrorClassNotFound, blobstore.ClassifyError(status.Error(codes.NotFound, "Blob not found")))
+	})
+
+	t.Run("Unavailable", func(t *testing.T) {
+		require.Equal(t, blobstore.ErrorClassUnavailable, blobstore.ClassifyError(status.Error(codes.Unavailable, "Server not reachable")))
+	})
+
+	t.Run("DataIntegrity", func(t *testing.T) {
+		// Checksum mismatches should be classified as such,
+		// even if the error has been wrapped afterwards.
+		helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+		_, err := buffer.NewCASBufferFromByteSlice(helloDigest, []byte("Hallo"), buffer.BackendProvided(buffer.Irreparable(helloDigest))).ToByteSlice(10)
+		require.Equal(t, codes.Internal, status.Code(err))
+		require.Equal(t, blobstore.ErrorClassDataIntegrity, blobstore.ClassifyError(err))
+		require.Equal(t, blobstore.ErrorClassDataIntegrity, blobstore.ClassifyError(util.StatusWrap(err, "Primary")))
+	})
+
+	t.Run("Other", func(t *testing.T) {
+		require.Equal(t, blobstore.ErrorClassOther, blobstore.ClassifyError(status.Error(codes.Internal, "Disk on fire")))
+		require.Equal(t, blobstore.ErrorClassOther, blobstore.ClassifyError(context.Canceled))
+	})
+}
@@ -0,0 +1,76 @@
+package blobstore
+
+import (
+	"context"
+	"os"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type errorCodeNormalizingBlobAccess struct {
+	BlobAccess
+}
+
+// NewErrorCodeNormalizingBlobAccess creates a decorator for BlobAccess
+// that ensures that the absence of blobs is reported in the way
+// described in the documentation of BlobAccess. This may be placed on
+// top of backends that don't adhere to these rules, such as custom
+// implementations that are backed by a file system or a third-party
+// storage service.
+//
+// Errors returned by Get() that indicate that a file does not exist
+// are converted to NotFound. FindMissing() calls that fail with
+// NotFound are converted to successful calls that report all digests
+// as missing. Put() calls that fail with NotFound (e.g., due to a
+// storage bucket not existing) are converted to Internal, so that
+// clients don't confuse them with the blob being absent.
+func NewErrorCodeNormalizingBlobAccess(base BlobAccess) BlobAccess {
+	return &errorCodeNormalizingBlobAccess{
+		BlobAccess: base,
+	}
+}
+
+// isAbsenceError returns whether an error indicates that an object is
+// not present.
+func isAbsenceError(err error) bool {
+	return status.Code(err) == codes.NotFound || os.IsNotExist(err)
+}
+
+func (ba *errorCodeNormalizingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	return buffer.WithErrorHandler(ba.BlobAccess.Get(ctx, digest), errorCodeNormalizingErrorHandler{})
+}
+
+func (ba *errorCodeNormalizingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	err := ba.BlobAccess.Put(ctx, digest, b)
+	if isAbsenceError(err) {
+		return util.StatusWrapWithCode(err, codes.Internal, "Backend reported object as absent")
+	}
+	return err
+}
+
+func (ba *errorCodeNormalizingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	missing, err := ba.BlobAccess.FindMissing(ctx, digests)
+	if err != nil {
+		if isAbsenceError(err) {
+			return digests, nil
+		}
+		return digest.EmptySet, err
+	}
+	return missing, nil
+}
+
+type errorCodeNormalizingErrorHandler struct{}
+
+func (eh errorCodeNormalizingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	if status.Code(err) != codes.NotFound && os.IsNotExist(err) {
+		return nil, util.StatusWrapWithCode(err, codes.NotFound, "Blob not found")
+	}
+	return nil, err
+}
+
+func (eh errorCodeNormalizingErrorHandler) Done() {}
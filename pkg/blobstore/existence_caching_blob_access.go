@@ -35,13 +35,12 @@ func (ba *existenceCachingBlobAccess) FindMissing(ctx context.Context, digests d
 	maybeMissing := ba.existenceCache.RemoveExisting(digests)
 
 	// Check existence of the remaining digests.
-	missing, err := ba.BlobAccess.FindMissing(ctx, maybeMissing)
+	missing, present, err := FindMissingAndPresent(ctx, ba.BlobAccess, maybeMissing)
 	if err != nil {
 		return digest.EmptySet, err
 	}
 
 	// Insert the digests that were present for future calls.
-	present, _, _ := digest.GetDifferenceAndIntersection(maybeMissing, missing)
 	ba.existenceCache.Add(present)
 	return missing, nil
 }
@@ -0,0 +1,49 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type existencePrecheckingBlobAccess struct {
+	BlobAccess
+	existenceSource BlobAccess
+}
+
+// NewExistencePrecheckingBlobAccess creates a decorator for BlobAccess
+// that calls FindMissing() against an existence source before calling
+// Get(). Blobs reported as missing cause Get() to fail with NOT_FOUND
+// immediately, without calling into the backend.
+//
+// This may be used for backends for which setting up a read is
+// expensive, while checking for existence is cheap. For example, when
+// placed on top of a gRPC client, it causes FindMissingBlobs() to be
+// called prior to opening a ByteStream Read() stream. As this adds a
+// round trip for blobs that are present, it only pays off if reads of
+// absent blobs are frequent.
+//
+// The existence source is typically the backend itself, but may also
+// be a separate index that is cheaper to query.
+func NewExistencePrecheckingBlobAccess(base BlobAccess, existenceSource BlobAccess) BlobAccess {
+	return &existencePrecheckingBlobAccess{
+		BlobAccess:      base,
+		existenceSource: existenceSource,
+	}
+}
+
+func (ba *existencePrecheckingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	missing, err := ba.existenceSource.FindMissing(ctx, digest.ToSingletonSet())
+	if err != nil {
+		return buffer.NewBufferFromError(util.StatusWrap(err, "Failed to check for existence of blob"))
+	}
+	if !missing.Empty() {
+		return buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found"))
+	}
+	return ba.BlobAccess.Get(ctx, digest)
+}
@@ -0,0 +1,101 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// findMissingCall keeps track of a call to FindMissing() against the
+// backend, whose results may be shared by multiple callers.
+type findMissingCall struct {
+	done    chan struct{}
+	missing digest.Set
+	err     error
+}
+
+type findMissingDeduplicatingBlobAccess struct {
+	BlobAccess
+
+	lock  sync.Mutex
+	calls map[[sha256.Size]byte]*findMissingCall
+}
+
+// NewFindMissingDeduplicatingBlobAccess creates a decorator for
+// BlobAccess that collapses concurrent calls to FindMissing() with
+// identical sets of digests into a single call against the backend.
+// Results are shared among all callers.
+//
+// Unlike ExistenceCachingBlobAccess, this decorator does not cache
+// results across time. Once a call completes, successive calls are
+// forwarded to the backend once again. This means that failures do
+// not affect calls made afterwards.
+func NewFindMissingDeduplicatingBlobAccess(base BlobAccess) BlobAccess {
+	return &findMissingDeduplicatingBlobAccess{
+		BlobAccess: base,
+		calls:      map[[sha256.Size]byte]*findMissingCall{},
+	}
+}
+
+// getFindMissingKey computes a key for a set of digests. As the
+// digests in a set are sorted, identical sets yield identical keys.
+func getFindMissingKey(digests digest.Set) [sha256.Size]byte {
+	hasher := sha256.New()
+	for _, blobDigest := range digests.Items() {
+		hasher.Write([]byte(blobDigest.GetKey(digest.KeyWithInstance)))
+		hasher.Write([]byte{0})
+	}
+	var key [sha256.Size]byte
+	hasher.Sum(key[:0])
+	return key
+}
+
+func (ba *findMissingDeduplicatingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	if digests.Empty() {
+		return digest.EmptySet, nil
+	}
+
+	key := getFindMissingKey(digests)
+	for {
+		ba.lock.Lock()
+		if call, ok := ba.calls[key]; ok {
+			// An identical call is already in flight. Wait
+			// for its results.
+			ba.lock.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return digest.EmptySet, util.StatusFromContext(ctx)
+			}
+
+			// Don't propagate cancelation of the call that
+			// is shared to callers whose context is still
+			// valid. Retry instead.
+			if code := status.Code(call.err); code == codes.Canceled || code == codes.DeadlineExceeded {
+				continue
+			}
+			return call.missing, call.err
+		}
+
+		// Perform the call against the backend.
+		call := &findMissingCall{
+			done: make(chan struct{}),
+		}
+		ba.calls[key] = call
+		ba.lock.Unlock()
+
+		call.missing, call.err = ba.BlobAccess.FindMissing(ctx, digests)
+
+		ba.lock.Lock()
+		delete(ba.calls, key)
+		ba.lock.Unlock()
+		close(call.done)
+		return call.missing, call.err
+	}
+}
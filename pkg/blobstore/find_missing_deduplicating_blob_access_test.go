@@ -0,0 +1,134 @@
+package blobstore_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// doneObservingContext is a context that reports the first call to
+// Done(). It can be used to determine when a call is waiting for an
+// identical call that is in flight.
+type doneObservingContext struct {
+	context.Context
+	once     sync.Once
+	observed chan struct{}
+}
+
+func newDoneObservingContext(ctx context.Context) *doneObservingContext {
+	return &doneObservingContext{
+		Context:  ctx,
+		observed: make(chan struct{}),
+	}
+}
+
+func (ctx *doneObservingContext) Done() <-chan struct{} {
+	ctx.once.Do(func() { close(ctx.observed) })
+	return ctx.Context.Done()
+}
+
+func TestFindMissingDeduplicatingBlobAccessFindMissing(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewFindMissingDeduplicatingBlobAccess(baseBlobAccess)
+	digests := digest.NewSetBuilder().
+		Add(digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)).
+		Add(digest.MustNewDigest("hello", "6fc422233a40a75a1f028e11c3cd1140", 7)).
+		Build()
+
+	t.Run("Empty", func(t *testing.T) {
+		// Empty sets should not be forwarded.
+		missing, err := blobAccess.FindMissing(ctx, digest.EmptySet)
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).
+			Return(digest.EmptySet, status.Error(codes.Internal, "Server on fire"))
+
+		_, err := blobAccess.FindMissing(ctx, digests)
+		require.Equal(t, status.Error(codes.Internal, "Server on fire"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Failures of previous calls should not be cached.
+		missingDigests := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5).ToSingletonSet()
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(missingDigests, nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, missingDigests, missing)
+	})
+
+	// testSharedResults calls FindMissing() while an identical call
+	// is in flight. The results of the call in flight should be
+	// returned to all callers.
+	testSharedResults := func(t *testing.T, missingDigests digest.Set, expectedErr error) {
+		const waitersCount = 3
+		type result struct {
+			missing digest.Set
+			err     error
+		}
+		results := make(chan result, waitersCount)
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) (digest.Set, error) {
+				for i := 0; i < waitersCount; i++ {
+					waiterCtx := newDoneObservingContext(ctx)
+					go func() {
+						missing, err := blobAccess.FindMissing(waiterCtx, digests)
+						results <- result{missing: missing, err: err}
+					}()
+					<-waiterCtx.observed
+				}
+				return missingDigests, expectedErr
+			})
+
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.Equal(t, expectedErr, err)
+		require.Equal(t, missingDigests, missing)
+		for i := 0; i < waitersCount; i++ {
+			r := <-results
+			require.Equal(t, expectedErr, r.err)
+			require.Equal(t, missingDigests, r.missing)
+		}
+	}
+
+	t.Run("ConcurrentSuccess", func(t *testing.T) {
+		testSharedResults(t, digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5).ToSingletonSet(), nil)
+	})
+
+	t.Run("ConcurrentFailure", func(t *testing.T) {
+		// Errors other than cancelation should also be shared.
+		testSharedResults(t, digest.EmptySet, status.Error(codes.Internal, "Server on fire"))
+	})
+
+	t.Run("ConcurrentCanceled", func(t *testing.T) {
+		// While a call is in flight, identical calls should
+		// wait for it to complete, as opposed to calling into
+		// the backend. Waiting should respect cancelation.
+		missingDigests := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5).ToSingletonSet()
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) (digest.Set, error) {
+				waiterCtx, cancel := context.WithCancel(ctx)
+				cancel()
+				_, err := blobAccess.FindMissing(waiterCtx, digests)
+				require.Equal(t, status.Error(codes.Canceled, "context canceled"), err)
+				return missingDigests, nil
+			})
+
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, missingDigests, missing)
+	})
+}
@@ -3,6 +3,7 @@ package grpcclients
 import (
 	"context"
 	"io"
+	"sync"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
@@ -13,13 +14,119 @@ import (
 
 	"google.golang.org/genproto/googleapis/bytestream"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	// Register the gzip compressor, so that it may be used by
+	// setting fields in CASCompressors.
+	_ "google.golang.org/grpc/encoding/gzip"
 )
 
 type casBlobAccess struct {
 	byteStreamClient                bytestream.ByteStreamClient
 	contentAddressableStorageClient remoteexecution.ContentAddressableStorageClient
+	capabilitiesClient              remoteexecution.CapabilitiesClient
 	uuidGenerator                   util.UUIDGenerator
 	readChunkSize                   int
+	validateReadSizes               bool
+	readPrefetchChunks              int
+	writeChunkSize                  int
+	writeBudget                     *writeBudget
+	readResourceNameFormatter       ReadResourceNameFormatter
+	writeResourceNameFormatter      WriteResourceNameFormatter
+	readCallOptions                 []grpc.CallOption
+	writeCallOptions                []grpc.CallOption
+	findMissingCallOptions          []grpc.CallOption
+	getCapabilitiesCallOptions      []grpc.CallOption
+
+	capabilitiesLock sync.Mutex
+	capabilities     map[digest.InstanceName]blobstore.Capabilities
+}
+
+// ReadResourceNameFormatter is a function that computes the resource
+// name that is provided to ByteStream Read() calls to read a blob.
+type ReadResourceNameFormatter func(digest digest.Digest) string
+
+// WriteResourceNameFormatter is a function that computes the resource
+// name that is provided to ByteStream Write() calls to write a blob.
+// The UUID identifies the upload.
+type WriteResourceNameFormatter func(digest digest.Digest, uuid uuid.UUID) string
+
+// CASCompressors contains the names of the gRPC compressors (e.g.,
+// "gzip") that CASBlobAccess uses for the RPCs that it performs. This
+// compression is applied to individual gRPC messages, and is
+// independent of any compression of blobs themselves. Empty strings
+// cause RPCs to be performed without compression.
+//
+// Compression tends to pay off for FindMissingBlobs(), as its
+// requests and responses consist of many small digests. It is
+// typically not beneficial for ByteStream Read() and Write(), as the
+// contents of blobs may already be compressed.
+type CASCompressors struct {
+	Read            string
+	Write           string
+	FindMissing     string
+	GetCapabilities string
+}
+
+func newCompressorCallOptions(compressor string) []grpc.CallOption {
+	if compressor == "" {
+		return nil
+	}
+	return []grpc.CallOption{grpc.UseCompressor(compressor)}
+}
+
+// CASBlobAccess is a BlobAccess for the Content Addressable Storage
+// that is backed by a GRPC service. In addition to the operations
+// provided by BlobAccess, it is capable of reading parts of blobs by
+// setting ReadOffset and ReadLimit in ByteStream read requests. It
+// also reports the capabilities of the server, by calling into the
+// REv2 Capabilities service.
+type CASBlobAccess interface {
+	blobstore.RangeReadingBlobAccess
+	blobstore.CapabilitiesProvider
+}
+
+// CASBlobAccessOptions contains optional settings of the BlobAccess
+// that is returned by NewCASBlobAccess(). The zero value yields a
+// BlobAccess that behaves as described in the REv2 specification,
+// without performing any additional validation or buffering.
+type CASBlobAccessOptions struct {
+	// If set, Get() checks that the number of bytes returned by the
+	// server matches the size of the digest, failing with DATA_LOSS
+	// if it does not. Though checksum validation also detects such
+	// inconsistencies, this yields a more specific error message in
+	// case the server (or a proxy in between) misbehaves.
+	ValidateReadSizes bool
+
+	// If positive, Get() receives data from the server in a
+	// background goroutine, keeping up to ReadPrefetchChunks chunks
+	// ahead of the consumer. This hides the latency of the
+	// connection while the consumer is processing data, at the cost
+	// of additional memory usage.
+	ReadPrefetchChunks int
+
+	// If positive, it limits the amount of data that Put() calls
+	// collectively read from buffers, but have not yet handed over
+	// to gRPC. As ByteStream Write() is a client streaming RPC, the
+	// server does not acknowledge individual chunks. Put() instead
+	// relies on gRPC's flow control, which causes sending to block
+	// when the server is slow to consume data, and never reads
+	// ahead of that. Memory usage of all concurrent Put() calls is
+	// thus bounded by MaximumInFlightWriteBytes, plus the
+	// per-stream buffering performed by gRPC's HTTP/2 transport.
+	MaximumInFlightWriteBytes int64
+
+	// The resource names that are used for ByteStream calls. This
+	// may be needed to interact with servers that use a different
+	// layout than the one described in the REv2 specification. If
+	// nil, the layout from the REv2 specification is used.
+	ReadResourceNameFormatter  ReadResourceNameFormatter
+	WriteResourceNameFormatter WriteResourceNameFormatter
+
+	// The compression that is applied to gRPC messages, which may
+	// be configured for every kind of RPC separately.
+	Compressors CASCompressors
 }
 
 // NewCASBlobAccess creates a BlobAccess handle that relays any requests
@@ -27,26 +134,145 @@ type casBlobAccess struct {
 // remoteexecution.ContentAddressableStorage services. Those are the
 // services that Bazel uses to access blobs stored in the Content
 // Addressable Storage.
-func NewCASBlobAccess(client grpc.ClientConnInterface, uuidGenerator util.UUIDGenerator, readChunkSize int) blobstore.BlobAccess {
-	return &casBlobAccess{
+func NewCASBlobAccess(client grpc.ClientConnInterface, uuidGenerator util.UUIDGenerator, readChunkSize int, options CASBlobAccessOptions) CASBlobAccess {
+	readResourceNameFormatter := options.ReadResourceNameFormatter
+	if readResourceNameFormatter == nil {
+		readResourceNameFormatter = digest.Digest.GetByteStreamReadPath
+	}
+	writeResourceNameFormatter := options.WriteResourceNameFormatter
+	if writeResourceNameFormatter == nil {
+		writeResourceNameFormatter = digest.Digest.GetByteStreamWritePath
+	}
+	ba := &casBlobAccess{
 		byteStreamClient:                bytestream.NewByteStreamClient(client),
 		contentAddressableStorageClient: remoteexecution.NewContentAddressableStorageClient(client),
+		capabilitiesClient:              remoteexecution.NewCapabilitiesClient(client),
 		uuidGenerator:                   uuidGenerator,
 		readChunkSize:                   readChunkSize,
+		validateReadSizes:               options.ValidateReadSizes,
+		readPrefetchChunks:              options.ReadPrefetchChunks,
+		writeChunkSize:                  readChunkSize,
+		readResourceNameFormatter:       readResourceNameFormatter,
+		writeResourceNameFormatter:      writeResourceNameFormatter,
+		readCallOptions:                 newCompressorCallOptions(options.Compressors.Read),
+		writeCallOptions:                newCompressorCallOptions(options.Compressors.Write),
+		findMissingCallOptions:          newCompressorCallOptions(options.Compressors.FindMissing),
+		getCapabilitiesCallOptions:      newCompressorCallOptions(options.Compressors.GetCapabilities),
+		capabilities:                    map[digest.InstanceName]blobstore.Capabilities{},
 	}
+	if options.MaximumInFlightWriteBytes > 0 {
+		// Ensure that individual chunks fit in the budget.
+		if int64(ba.writeChunkSize) > options.MaximumInFlightWriteBytes {
+			ba.writeChunkSize = int(options.MaximumInFlightWriteBytes)
+		}
+		ba.writeBudget = newWriteBudget(options.MaximumInFlightWriteBytes)
+	}
+	return ba
 }
 
+// getReadResourceName computes the resource name for ByteStream Read()
+// calls, rejecting empty resource names returned by custom formatters.
+func (ba *casBlobAccess) getReadResourceName(digest digest.Digest) (string, error) {
+	resourceName := ba.readResourceNameFormatter(digest)
+	if resourceName == "" {
+		return "", status.Errorf(codes.InvalidArgument, "Resource name formatter returned an empty resource name for reading blob %#v", digest.String())
+	}
+	return resourceName, nil
+}
+
+// getWriteResourceName computes the resource name for ByteStream
+// Write() calls, rejecting empty resource names returned by custom
+// formatters.
+func (ba *casBlobAccess) getWriteResourceName(digest digest.Digest) (string, error) {
+	resourceName := ba.writeResourceNameFormatter(digest, uuid.Must(ba.uuidGenerator()))
+	if resourceName == "" {
+		return "", status.Errorf(codes.InvalidArgument, "Resource name formatter returned an empty resource name for writing blob %#v", digest.String())
+	}
+	return resourceName, nil
+}
+
+// maximumReadReconnectsWithoutProgress is the maximum number of times
+// byteStreamChunkReader reissues a ByteStream Read() call after the
+// stream fails, without having received any data in between.
+const maximumReadReconnectsWithoutProgress = 3
+
+// byteStreamChunkReader is a ChunkReader that returns data obtained
+// through a ByteStream Read() call. When the stream fails with
+// UNAVAILABLE (e.g., due to the connection to the server being
+// interrupted), the Read() call is reissued, using ReadOffset to
+// continue where the previous stream left off. As this happens
+// underneath the validation performed by the buffer layer, the blob's
+// checksum is still computed over the contents in their entirety.
 type byteStreamChunkReader struct {
-	client bytestream.ByteStream_ReadClient
-	cancel context.CancelFunc
+	ctx              context.Context
+	byteStreamClient bytestream.ByteStreamClient
+	resourceName     string
+	callOptions      []grpc.CallOption
+
+	client                    bytestream.ByteStream_ReadClient
+	cancel                    context.CancelFunc
+	receivedSizeBytes         int64
+	reconnectsWithoutProgress int
 }
 
-func (r *byteStreamChunkReader) Read() ([]byte, error) {
-	chunk, err := r.client.Recv()
+func (r *byteStreamChunkReader) reconnect() error {
+	r.cancel()
+	ctxWithCancel, cancel := context.WithCancel(r.ctx)
+	client, err := r.byteStreamClient.Read(ctxWithCancel, &bytestream.ReadRequest{
+		ResourceName: r.resourceName,
+		ReadOffset:   r.receivedSizeBytes,
+	}, r.callOptions...)
 	if err != nil {
+		cancel()
+		return err
+	}
+	r.client = client
+	r.cancel = cancel
+	return nil
+}
+
+func (r *byteStreamChunkReader) Read() ([]byte, error) {
+	for {
+		chunk, err := r.client.Recv()
+		if err == nil {
+			r.receivedSizeBytes += int64(len(chunk.Data))
+			if len(chunk.Data) > 0 {
+				r.reconnectsWithoutProgress = 0
+			}
+			return chunk.Data, nil
+		}
+		if status.Code(err) != codes.Unavailable || r.reconnectsWithoutProgress >= maximumReadReconnectsWithoutProgress {
+			return nil, err
+		}
+		r.reconnectsWithoutProgress++
+		if errReconnect := r.reconnect(); errReconnect != nil {
+			return nil, util.StatusWrapf(errReconnect, "Failed to resume reading at offset %d", r.receivedSizeBytes)
+		}
+	}
+}
+
+// sizeValidatingByteStreamChunkReader is a decorator for
+// byteStreamChunkReader that compares the number of bytes received
+// against the size of the digest.
+type sizeValidatingByteStreamChunkReader struct {
+	*byteStreamChunkReader
+	expectedSizeBytes int64
+}
+
+func (r *sizeValidatingByteStreamChunkReader) Read() ([]byte, error) {
+	chunk, err := r.byteStreamChunkReader.Read()
+	if err == io.EOF {
+		if r.receivedSizeBytes != r.expectedSizeBytes {
+			return nil, buffer.MarkDataIntegrityError(status.Errorf(codes.DataLoss, "Server returned %d bytes, while %d bytes were requested", r.receivedSizeBytes, r.expectedSizeBytes))
+		}
+		return nil, io.EOF
+	} else if err != nil {
 		return nil, err
 	}
-	return chunk.Data, nil
+	if r.receivedSizeBytes > r.expectedSizeBytes {
+		return nil, buffer.MarkDataIntegrityError(status.Errorf(codes.DataLoss, "Server returned more than the %d bytes requested", r.expectedSizeBytes))
+	}
+	return chunk, nil
 }
 
 func (r *byteStreamChunkReader) Close() {
@@ -54,61 +280,194 @@ func (r *byteStreamChunkReader) Close() {
 }
 
 func (ba *casBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	// The empty blob is always present. There is no need to
+	// contact the server to obtain it.
+	if digest.IsEmptyBlob() {
+		return buffer.NewCASBufferFromByteSlice(digest, nil, buffer.BackendProvided(buffer.Irreparable(digest)))
+	}
+
+	resourceName, err := ba.getReadResourceName(digest)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+
+	// When prefetching, all streams are created from a context that
+	// the prefetcher can cancel to interrupt reading.
+	readCtx, cancelRead := ctx, context.CancelFunc(func() {})
+	if ba.readPrefetchChunks > 0 {
+		readCtx, cancelRead = context.WithCancel(ctx)
+	}
+
+	ctxWithCancel, cancel := context.WithCancel(readCtx)
+	client, err := ba.byteStreamClient.Read(ctxWithCancel, &bytestream.ReadRequest{
+		ResourceName: resourceName,
+	}, ba.readCallOptions...)
+	if err != nil {
+		cancel()
+		cancelRead()
+		return buffer.NewBufferFromError(err)
+	}
+	byteStreamReader := &byteStreamChunkReader{
+		ctx:              readCtx,
+		byteStreamClient: ba.byteStreamClient,
+		resourceName:     resourceName,
+		callOptions:      ba.readCallOptions,
+		client:           client,
+		cancel:           cancel,
+	}
+	var r buffer.ChunkReader = byteStreamReader
+	if ba.validateReadSizes {
+		r = &sizeValidatingByteStreamChunkReader{
+			byteStreamChunkReader: byteStreamReader,
+			expectedSizeBytes:     digest.GetSizeBytes(),
+		}
+	}
+	if ba.readPrefetchChunks > 0 {
+		r = newPrefetchingChunkReader(readCtx, cancelRead, r, ba.readPrefetchChunks)
+	}
+	return buffer.NewCASBufferFromChunkReader(digest, r, buffer.BackendProvided(buffer.Irreparable(digest)))
+}
+
+// GetRange reads part of a blob by setting ReadOffset and ReadLimit in
+// the ByteStream read request, so that only the requested part is
+// transferred. As a checksum can only be computed over the contents of
+// a blob in their entirety, the data in the returned buffer is not
+// validated against the digest, similar to buffers created through
+// buffer.NewValidatedBufferFromReader(). Only its size is checked.
+func (ba *casBlobAccess) GetRange(ctx context.Context, digest digest.Digest, offset int64, sizeBytes int64) buffer.Buffer {
+	sizeBytes, err := blobstore.GetRangeSizeBytes(digest, offset, sizeBytes)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	if sizeBytes == 0 {
+		return buffer.NewEmptyBuffer()
+	}
+	resourceName, err := ba.getReadResourceName(digest)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+
 	ctxWithCancel, cancel := context.WithCancel(ctx)
 	client, err := ba.byteStreamClient.Read(ctxWithCancel, &bytestream.ReadRequest{
-		ResourceName: digest.GetByteStreamReadPath(),
-	})
+		ResourceName: resourceName,
+		ReadOffset:   offset,
+		ReadLimit:    sizeBytes,
+	}, ba.readCallOptions...)
 	if err != nil {
 		cancel()
 		return buffer.NewBufferFromError(err)
 	}
-	return buffer.NewCASBufferFromChunkReader(digest, &byteStreamChunkReader{
-		client: client,
-		cancel: cancel,
-	}, buffer.BackendProvided(buffer.Irreparable(digest)))
+	return buffer.NewValidatedBufferFromReader(
+		&byteStreamReader{
+			client: client,
+			cancel: cancel,
+		},
+		sizeBytes)
+}
+
+// byteStreamReader is an io.ReadCloser that returns data obtained
+// through a ByteStream Read() call. Unlike byteStreamChunkReader, it
+// does not reissue the call when the stream fails.
+type byteStreamReader struct {
+	client    bytestream.ByteStream_ReadClient
+	cancel    context.CancelFunc
+	lastChunk []byte
+}
+
+func (r *byteStreamReader) Read(p []byte) (int, error) {
+	for len(r.lastChunk) == 0 {
+		chunk, err := r.client.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.lastChunk = chunk.Data
+	}
+	n := copy(p, r.lastChunk)
+	r.lastChunk = r.lastChunk[n:]
+	return n, nil
+}
+
+func (r *byteStreamReader) Close() error {
+	r.cancel()
+	return nil
 }
 
 func (ba *casBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
-	r := b.ToChunkReader(0, ba.readChunkSize)
+	if digest.IsEmptyBlob() {
+		// Still validate the buffer's contents, so that
+		// callers get consistent results.
+		_, err := b.ToByteSlice(0)
+		return err
+	}
+
+	resourceName, err := ba.getWriteResourceName(digest)
+	if err != nil {
+		b.Discard()
+		return err
+	}
+
+	r := b.ToChunkReader(0, buffer.ChunkSizeAtMost(ba.writeChunkSize))
 	defer r.Close()
 
-	client, err := ba.byteStreamClient.Write(ctx)
+	client, err := ba.byteStreamClient.Write(ctx, ba.writeCallOptions...)
 	if err != nil {
 		return err
 	}
 
-	resourceName := digest.GetByteStreamWritePath(uuid.Must(ba.uuidGenerator()))
 	writeOffset := int64(0)
 	for {
-		if data, err := r.Read(); err == nil {
-			// Non-terminating chunk.
-			if err := client.Send(&bytestream.WriteRequest{
+		if ba.writeBudget != nil {
+			if err := ba.writeBudget.acquire(ctx, int64(ba.writeChunkSize)); err != nil {
+				return err
+			}
+		}
+		data, err := r.Read()
+		if err == nil {
+			// Non-terminating chunk. Send() blocks if the
+			// server is slow to consume data. Only read the
+			// next chunk once this one has been accepted.
+			err := client.Send(&bytestream.WriteRequest{
 				ResourceName: resourceName,
 				WriteOffset:  writeOffset,
 				Data:         data,
-			}); err != nil {
+			})
+			ba.releaseWriteBudget()
+			if err != nil {
 				return err
 			}
 			writeOffset += int64(len(data))
 			resourceName = ""
-		} else if err == io.EOF {
-			// Terminating chunk.
-			if err := client.Send(&bytestream.WriteRequest{
-				ResourceName: resourceName,
-				WriteOffset:  writeOffset,
-				FinishWrite:  true,
-			}); err != nil {
-				return err
-			}
-			_, err := client.CloseAndRecv()
+			continue
+		}
+		ba.releaseWriteBudget()
+		if err != io.EOF {
 			return err
-		} else {
+		}
+
+		// Terminating chunk.
+		if err := client.Send(&bytestream.WriteRequest{
+			ResourceName: resourceName,
+			WriteOffset:  writeOffset,
+			FinishWrite:  true,
+		}); err != nil {
 			return err
 		}
+		_, err = client.CloseAndRecv()
+		return err
+	}
+}
+
+func (ba *casBlobAccess) releaseWriteBudget() {
+	if ba.writeBudget != nil {
+		ba.writeBudget.release(int64(ba.writeChunkSize))
 	}
 }
 
 func (ba *casBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	if digests.Empty() {
+		return digest.EmptySet, nil
+	}
+
 	// Partition all digests by instance name, as the
 	// FindMissingBlobs() RPC can only process digests for a single
 	// instance.
@@ -125,7 +484,7 @@ func (ba *casBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (d
 			InstanceName: instanceName.String(),
 			BlobDigests:  blobDigests,
 		}
-		response, err := ba.contentAddressableStorageClient.FindMissingBlobs(ctx, &request)
+		response, err := ba.contentAddressableStorageClient.FindMissingBlobs(ctx, &request, ba.findMissingCallOptions...)
 		if err != nil {
 			return digest.EmptySet, err
 		}
@@ -141,3 +500,35 @@ func (ba *casBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (d
 	}
 	return missingDigests.Build(), nil
 }
+
+func (ba *casBlobAccess) GetCapabilities(ctx context.Context, instanceName digest.InstanceName) (blobstore.Capabilities, error) {
+	ba.capabilitiesLock.Lock()
+	capabilities, ok := ba.capabilities[instanceName]
+	ba.capabilitiesLock.Unlock()
+	if ok {
+		return capabilities, nil
+	}
+
+	// Capabilities of the server are not expected to change over
+	// time. Only successful responses are cached, so that transient
+	// failures are retried.
+	serverCapabilities, err := ba.capabilitiesClient.GetCapabilities(ctx, &remoteexecution.GetCapabilitiesRequest{
+		InstanceName: instanceName.String(),
+	}, ba.getCapabilitiesCallOptions...)
+	if err != nil {
+		return blobstore.Capabilities{}, err
+	}
+	cacheCapabilities := serverCapabilities.CacheCapabilities
+	if cacheCapabilities == nil {
+		return blobstore.Capabilities{}, status.Errorf(codes.FailedPrecondition, "Server does not provide cache capabilities for instance name %#v", instanceName.String())
+	}
+	capabilities = blobstore.Capabilities{
+		DigestFunctions:       cacheCapabilities.DigestFunction,
+		MaximumBatchSizeBytes: cacheCapabilities.MaxBatchTotalSizeBytes,
+	}
+
+	ba.capabilitiesLock.Lock()
+	ba.capabilities[instanceName] = capabilities
+	ba.capabilitiesLock.Unlock()
+	return capabilities, nil
+}
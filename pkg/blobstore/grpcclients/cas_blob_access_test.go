@@ -0,0 +1,607 @@
+package grpcclients_test
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/grpcclients"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCASBlobAccessGet(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	client := mock.NewMockClientConnInterface(ctrl)
+	blobDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	blobAccess := grpcclients.NewCASBlobAccess(client, uuid.NewRandom, 10, grpcclients.CASBlobAccessOptions{ValidateReadSizes: true})
+
+	// expectRead sets up expectations for a ByteStream Read() call,
+	// for which the server returns the provided chunks of data.
+	expectRead := func(chunks ...string) {
+		clientStream := mock.NewMockClientStream(ctrl)
+		client.EXPECT().NewStream(gomock.Any(), gomock.Any(), "/google.bytestream.ByteStream/Read").Return(clientStream, nil)
+		clientStream.EXPECT().SendMsg(&bytestream.ReadRequest{
+			ResourceName: "hello/blobs/3e25960a79dbc69b674cd4ec67a72c62/11",
+		})
+		clientStream.EXPECT().CloseSend()
+		for _, chunk := range chunks {
+			data := []byte(chunk)
+			clientStream.EXPECT().RecvMsg(gomock.Any()).DoAndReturn(func(m interface{}) error {
+				m.(*bytestream.ReadResponse).Data = data
+				return nil
+			})
+		}
+		clientStream.EXPECT().RecvMsg(gomock.Any()).Return(io.EOF).AnyTimes()
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		expectRead("Hello ", "world")
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("TooFewBytes", func(t *testing.T) {
+		expectRead("Hello")
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.DataLoss, "Server returned 5 bytes, while 11 bytes were requested")), err)
+	})
+
+	t.Run("TooManyBytes", func(t *testing.T) {
+		expectRead("Hello ", "world!")
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.DataLoss, "Server returned more than the 11 bytes requested")), err)
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		// If the stream fails with UNAVAILABLE, reading should
+		// continue where the first stream left off, as opposed
+		// to restarting at the beginning of the blob.
+		clientStream1 := mock.NewMockClientStream(ctrl)
+		client.EXPECT().NewStream(gomock.Any(), gomock.Any(), "/google.bytestream.ByteStream/Read").Return(clientStream1, nil)
+		clientStream1.EXPECT().SendMsg(&bytestream.ReadRequest{
+			ResourceName: "hello/blobs/3e25960a79dbc69b674cd4ec67a72c62/11",
+		})
+		clientStream1.EXPECT().CloseSend()
+		clientStream1.EXPECT().RecvMsg(gomock.Any()).DoAndReturn(func(m interface{}) error {
+			m.(*bytestream.ReadResponse).Data = []byte("Hello ")
+			return nil
+		})
+		clientStream1.EXPECT().RecvMsg(gomock.Any()).Return(status.Error(codes.Unavailable, "Connection reset by peer"))
+
+		clientStream2 := mock.NewMockClientStream(ctrl)
+		client.EXPECT().NewStream(gomock.Any(), gomock.Any(), "/google.bytestream.ByteStream/Read").Return(clientStream2, nil)
+		clientStream2.EXPECT().SendMsg(&bytestream.ReadRequest{
+			ResourceName: "hello/blobs/3e25960a79dbc69b674cd4ec67a72c62/11",
+			ReadOffset:   6,
+		})
+		clientStream2.EXPECT().CloseSend()
+		clientStream2.EXPECT().RecvMsg(gomock.Any()).DoAndReturn(func(m interface{}) error {
+			m.(*bytestream.ReadResponse).Data = []byte("world")
+			return nil
+		})
+		clientStream2.EXPECT().RecvMsg(gomock.Any()).Return(io.EOF).AnyTimes()
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("ResumeWithoutProgress", func(t *testing.T) {
+		// Reconnecting should give up if the server
+		// repeatedly fails without returning any data.
+		for i := 0; i < 4; i++ {
+			clientStream := mock.NewMockClientStream(ctrl)
+			client.EXPECT().NewStream(gomock.Any(), gomock.Any(), "/google.bytestream.ByteStream/Read").Return(clientStream, nil)
+			clientStream.EXPECT().SendMsg(gomock.Any())
+			clientStream.EXPECT().CloseSend()
+			clientStream.EXPECT().RecvMsg(gomock.Any()).Return(status.Error(codes.Unavailable, "Connection refused"))
+		}
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Unavailable, "Connection refused"), err)
+	})
+
+	t.Run("EmptyBlob", func(t *testing.T) {
+		// The empty blob should be returned without contacting
+		// the server.
+		data, err := blobAccess.Get(
+			ctx,
+			digest.MustNewDigest("hello", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0),
+		).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Empty(t, data)
+	})
+}
+
+func TestCASBlobAccessGetPrefetch(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	client := mock.NewMockClientConnInterface(ctrl)
+	blobDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	blobAccess := grpcclients.NewCASBlobAccess(client, uuid.NewRandom, 10, grpcclients.CASBlobAccessOptions{
+		ValidateReadSizes:  true,
+		ReadPrefetchChunks: 2,
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		clientStream := mock.NewMockClientStream(ctrl)
+		client.EXPECT().NewStream(gomock.Any(), gomock.Any(), "/google.bytestream.ByteStream/Read").Return(clientStream, nil)
+		clientStream.EXPECT().SendMsg(gomock.Any())
+		clientStream.EXPECT().CloseSend()
+		for _, chunk := range []string{"Hello", " ", "world"} {
+			data := []byte(chunk)
+			clientStream.EXPECT().RecvMsg(gomock.Any()).DoAndReturn(func(m interface{}) error {
+				m.(*bytestream.ReadResponse).Data = data
+				return nil
+			})
+		}
+		clientStream.EXPECT().RecvMsg(gomock.Any()).Return(io.EOF)
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		// Errors returned by the server should be propagated.
+		clientStream := mock.NewMockClientStream(ctrl)
+		client.EXPECT().NewStream(gomock.Any(), gomock.Any(), "/google.bytestream.ByteStream/Read").Return(clientStream, nil)
+		clientStream.EXPECT().SendMsg(gomock.Any())
+		clientStream.EXPECT().CloseSend()
+		clientStream.EXPECT().RecvMsg(gomock.Any()).Return(status.Error(codes.Internal, "Disk on fire"))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Internal, "Disk on fire"), err)
+	})
+
+	t.Run("CloseEarly", func(t *testing.T) {
+		// Closing the ChunkReader while the background
+		// goroutine is waiting for data should interrupt it.
+		clientStream := mock.NewMockClientStream(ctrl)
+		var streamCtx context.Context
+		client.EXPECT().NewStream(gomock.Any(), gomock.Any(), "/google.bytestream.ByteStream/Read").
+			DoAndReturn(func(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+				streamCtx = ctx
+				return clientStream, nil
+			})
+		clientStream.EXPECT().SendMsg(gomock.Any())
+		clientStream.EXPECT().CloseSend()
+		clientStream.EXPECT().RecvMsg(gomock.Any()).DoAndReturn(func(m interface{}) error {
+			m.(*bytestream.ReadResponse).Data = []byte("Hello")
+			return nil
+		})
+		clientStream.EXPECT().RecvMsg(gomock.Any()).DoAndReturn(func(m interface{}) error {
+			<-streamCtx.Done()
+			return status.Error(codes.Canceled, "context canceled")
+		})
+
+		r := blobAccess.Get(ctx, blobDigest).ToChunkReader(0, buffer.ChunkSizeAtMost(100))
+		chunk, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), chunk)
+		r.Close()
+	})
+}
+
+// slowWriter is an io.Writer that sleeps for a fixed amount of time
+// for every call, simulating a consumer that forwards data to another
+// system.
+type slowWriter struct {
+	delay time.Duration
+}
+
+func (w slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	return len(p), nil
+}
+
+// BenchmarkCASBlobAccessGetPrefetch measures the throughput of Get()
+// against a server on a link with a 50ms round-trip time, while the
+// consumer takes an equal amount of time to process every chunk. With
+// prefetching enabled, receiving data overlaps with processing it.
+func BenchmarkCASBlobAccessGetPrefetch(b *testing.B) {
+	const chunkCount = 4
+	const chunkSizeBytes = 1024
+	data := make([]byte, chunkCount*chunkSizeBytes)
+	hash := md5.Sum(data)
+	blobDigest := digest.MustNewDigest("hello", hex.EncodeToString(hash[:]), int64(len(data)))
+
+	for _, readPrefetchChunks := range []int{0, 1, 4} {
+		b.Run(fmt.Sprintf("Prefetch%d", readPrefetchChunks), func(b *testing.B) {
+			ctrl, ctx := gomock.WithContext(context.Background(), b)
+			client := mock.NewMockClientConnInterface(ctrl)
+			blobAccess := grpcclients.NewCASBlobAccess(client, uuid.NewRandom, chunkSizeBytes, grpcclients.CASBlobAccessOptions{
+				ValidateReadSizes:  true,
+				ReadPrefetchChunks: readPrefetchChunks,
+			})
+
+			clientStream := mock.NewMockClientStream(ctrl)
+			client.EXPECT().NewStream(gomock.Any(), gomock.Any(), "/google.bytestream.ByteStream/Read").Return(clientStream, nil).AnyTimes()
+			clientStream.EXPECT().SendMsg(gomock.Any()).AnyTimes()
+			clientStream.EXPECT().CloseSend().AnyTimes()
+
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < chunkCount; j++ {
+					chunk := data[j*chunkSizeBytes : (j+1)*chunkSizeBytes]
+					clientStream.EXPECT().RecvMsg(gomock.Any()).DoAndReturn(func(m interface{}) error {
+						time.Sleep(50 * time.Millisecond)
+						m.(*bytestream.ReadResponse).Data = chunk
+						return nil
+					})
+				}
+				clientStream.EXPECT().RecvMsg(gomock.Any()).Return(io.EOF)
+
+				if err := blobAccess.Get(ctx, blobDigest).IntoWriter(slowWriter{delay: 50 * time.Millisecond}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCASBlobAccessGetRange(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	client := mock.NewMockClientConnInterface(ctrl)
+	blobDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	blobAccess := grpcclients.NewCASBlobAccess(client, uuid.NewRandom, 10, grpcclients.CASBlobAccessOptions{ValidateReadSizes: true})
+
+	// expectReadRange sets up expectations for a ByteStream Read()
+	// call with a given offset and limit, for which the server
+	// returns the provided chunks of data.
+	expectReadRange := func(offset int64, limit int64, chunks ...string) {
+		clientStream := mock.NewMockClientStream(ctrl)
+		client.EXPECT().NewStream(gomock.Any(), gomock.Any(), "/google.bytestream.ByteStream/Read").Return(clientStream, nil)
+		clientStream.EXPECT().SendMsg(&bytestream.ReadRequest{
+			ResourceName: "hello/blobs/3e25960a79dbc69b674cd4ec67a72c62/11",
+			ReadOffset:   offset,
+			ReadLimit:    limit,
+		})
+		clientStream.EXPECT().CloseSend()
+		for _, chunk := range chunks {
+			data := []byte(chunk)
+			clientStream.EXPECT().RecvMsg(gomock.Any()).DoAndReturn(func(m interface{}) error {
+				m.(*bytestream.ReadResponse).Data = data
+				return nil
+			})
+		}
+		clientStream.EXPECT().RecvMsg(gomock.Any()).Return(io.EOF).AnyTimes()
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		expectReadRange(2, 5, "llo", " w")
+
+		data, err := blobAccess.GetRange(ctx, blobDigest, 2, 5).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("llo w"), data)
+	})
+
+	t.Run("NotValidated", func(t *testing.T) {
+		// The data cannot be validated against the digest, as
+		// only part of the blob is read.
+		expectReadRange(6, 5, "WORLD")
+
+		data, err := blobAccess.GetRange(ctx, blobDigest, 6, 5).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("WORLD"), data)
+	})
+
+	t.Run("ClampedToEnd", func(t *testing.T) {
+		// Ranges extending beyond the end of the blob should be
+		// clamped, so that the server is not asked for more
+		// data than is available.
+		expectReadRange(6, 5, "world")
+
+		data, err := blobAccess.GetRange(ctx, blobDigest, 6, 100).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("world"), data)
+	})
+
+	t.Run("AtEnd", func(t *testing.T) {
+		// Reading at the end of the blob yields an empty buffer,
+		// without contacting the server.
+		data, err := blobAccess.GetRange(ctx, blobDigest, 11, 5).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Empty(t, data)
+	})
+
+	t.Run("NegativeOffset", func(t *testing.T) {
+		_, err := blobAccess.GetRange(ctx, blobDigest, -1, 5).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Negative read offset: -1"), err)
+	})
+
+	t.Run("OffsetTooHigh", func(t *testing.T) {
+		_, err := blobAccess.GetRange(ctx, blobDigest, 12, 5).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Read offset 12 exceeds blob size 11"), err)
+	})
+
+	t.Run("NegativeSize", func(t *testing.T) {
+		_, err := blobAccess.GetRange(ctx, blobDigest, 0, -1).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Negative read size: -1"), err)
+	})
+
+	t.Run("TooFewBytes", func(t *testing.T) {
+		expectReadRange(2, 5, "llo")
+
+		_, err := blobAccess.GetRange(ctx, blobDigest, 2, 5).ToByteSlice(100)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.DataLoss, "Buffer is 3 bytes in size, while 5 bytes were expected")), err)
+	})
+
+	t.Run("TooManyBytes", func(t *testing.T) {
+		expectReadRange(2, 5, "llo", " wo")
+
+		_, err := blobAccess.GetRange(ctx, blobDigest, 2, 5).ToByteSlice(100)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.DataLoss, "Buffer is at least 6 bytes in size, while 5 bytes were expected")), err)
+	})
+}
+
+func TestCASBlobAccessPutEmptyBlob(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	client := mock.NewMockClientConnInterface(ctrl)
+	blobAccess := grpcclients.NewCASBlobAccess(client, uuid.NewRandom, 10, grpcclients.CASBlobAccessOptions{ValidateReadSizes: true})
+	emptyDigest := digest.MustNewDigest("hello", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0)
+
+	t.Run("Success", func(t *testing.T) {
+		// Storing the empty blob should not cause any
+		// requests to be sent to the server.
+		require.NoError(t, blobAccess.Put(ctx, emptyDigest, buffer.NewValidatedBufferFromByteSlice(nil)))
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		// Errors of the provided buffer should still be
+		// propagated.
+		require.Equal(
+			t,
+			status.Error(codes.Internal, "I/O error"),
+			blobAccess.Put(ctx, emptyDigest, buffer.NewBufferFromError(status.Error(codes.Internal, "I/O error"))))
+	})
+}
+
+func TestCASBlobAccessPutWriteBudget(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	client := mock.NewMockClientConnInterface(ctrl)
+	blobDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	blobAccess := grpcclients.NewCASBlobAccess(client, uuid.NewRandom, 10, grpcclients.CASBlobAccessOptions{
+		ValidateReadSizes:         true,
+		MaximumInFlightWriteBytes: 10,
+	})
+
+	// Let the first call to Put() block while sending its first
+	// chunk. This exhausts the write budget.
+	clientStream1 := mock.NewMockClientStream(ctrl)
+	client.EXPECT().NewStream(gomock.Any(), gomock.Any(), "/google.bytestream.ByteStream/Write").Return(clientStream1, nil)
+	sendStarted := make(chan struct{})
+	sendUnblock := make(chan struct{})
+	clientStream1.EXPECT().SendMsg(gomock.Any()).DoAndReturn(func(m interface{}) error {
+		close(sendStarted)
+		<-sendUnblock
+		return status.Error(codes.Unavailable, "Server gone")
+	})
+	put1Done := make(chan error)
+	go func() {
+		put1Done <- blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+	}()
+	<-sendStarted
+
+	// A second call to Put() should not be able to read any data
+	// from its buffer, as no budget is available.
+	clientStream2 := mock.NewMockClientStream(ctrl)
+	client.EXPECT().NewStream(gomock.Any(), gomock.Any(), "/google.bytestream.ByteStream/Write").Return(clientStream2, nil)
+	ctx2, cancel := context.WithCancel(ctx)
+	cancel()
+	require.Equal(
+		t,
+		status.Error(codes.Canceled, "context canceled"),
+		blobAccess.Put(ctx2, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+
+	// Once the first call to Put() completes, budget is released.
+	close(sendUnblock)
+	require.Equal(t, status.Error(codes.Unavailable, "Server gone"), <-put1Done)
+
+	clientStream3 := mock.NewMockClientStream(ctrl)
+	client.EXPECT().NewStream(gomock.Any(), gomock.Any(), "/google.bytestream.ByteStream/Write").Return(clientStream3, nil)
+	clientStream3.EXPECT().SendMsg(gomock.Any()).Return(status.Error(codes.Unavailable, "Server gone"))
+	require.Equal(
+		t,
+		status.Error(codes.Unavailable, "Server gone"),
+		blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+}
+
+func TestCASBlobAccessGetCapabilities(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	client := mock.NewMockClientConnInterface(ctrl)
+	blobAccess := grpcclients.NewCASBlobAccess(client, uuid.NewRandom, 10, grpcclients.CASBlobAccessOptions{ValidateReadSizes: true})
+	instanceName := digest.MustNewInstanceName("hello")
+
+	t.Run("Failure", func(t *testing.T) {
+		// Errors should be propagated and not be cached.
+		client.EXPECT().Invoke(
+			ctx,
+			"/build.bazel.remote.execution.v2.Capabilities/GetCapabilities",
+			&remoteexecution.GetCapabilitiesRequest{InstanceName: "hello"},
+			gomock.Any(),
+			gomock.Any(),
+		).Return(status.Error(codes.Unavailable, "Server not reachable"))
+
+		_, err := blobAccess.GetCapabilities(ctx, instanceName)
+		require.Equal(t, status.Error(codes.Unavailable, "Server not reachable"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Successful responses should be cached.
+		client.EXPECT().Invoke(
+			ctx,
+			"/build.bazel.remote.execution.v2.Capabilities/GetCapabilities",
+			&remoteexecution.GetCapabilitiesRequest{InstanceName: "hello"},
+			gomock.Any(),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+			reply.(*remoteexecution.ServerCapabilities).CacheCapabilities = &remoteexecution.CacheCapabilities{
+				DigestFunction: []remoteexecution.DigestFunction_Value{
+					remoteexecution.DigestFunction_SHA256,
+				},
+				MaxBatchTotalSizeBytes: 4 * 1024 * 1024,
+			}
+			return nil
+		})
+
+		for i := 0; i < 2; i++ {
+			capabilities, err := blobAccess.GetCapabilities(ctx, instanceName)
+			require.NoError(t, err)
+			require.Equal(t, blobstore.Capabilities{
+				DigestFunctions: []remoteexecution.DigestFunction_Value{
+					remoteexecution.DigestFunction_SHA256,
+				},
+				MaximumBatchSizeBytes: 4 * 1024 * 1024,
+			}, capabilities)
+		}
+	})
+}
+
+func TestCASBlobAccessResourceNameFormatters(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	client := mock.NewMockClientConnInterface(ctrl)
+	blobDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	uuidGenerator := func() (uuid.UUID, error) {
+		return uuid.MustParse("7d659e2d-4ab0-4bdc-a6d2-1ad4f7f2e1e7"), nil
+	}
+
+	t.Run("Custom", func(t *testing.T) {
+		blobAccess := grpcclients.NewCASBlobAccess(client, uuidGenerator, 10, grpcclients.CASBlobAccessOptions{
+			ValidateReadSizes: true,
+			ReadResourceNameFormatter: func(digest digest.Digest) string {
+				return fmt.Sprintf("cas/%s/%s", digest.GetInstanceName(), digest.GetHashString())
+			},
+			WriteResourceNameFormatter: func(digest digest.Digest, uuid uuid.UUID) string {
+				return fmt.Sprintf("cas/%s/uploads/%s/%s", digest.GetInstanceName(), uuid, digest.GetHashString())
+			},
+		})
+
+		// Reads should use the custom resource name.
+		readStream := mock.NewMockClientStream(ctrl)
+		client.EXPECT().NewStream(gomock.Any(), gomock.Any(), "/google.bytestream.ByteStream/Read").Return(readStream, nil)
+		readStream.EXPECT().SendMsg(&bytestream.ReadRequest{
+			ResourceName: "cas/hello/3e25960a79dbc69b674cd4ec67a72c62",
+		})
+		readStream.EXPECT().CloseSend()
+		readStream.EXPECT().RecvMsg(gomock.Any()).DoAndReturn(func(m interface{}) error {
+			m.(*bytestream.ReadResponse).Data = []byte("Hello world")
+			return nil
+		})
+		readStream.EXPECT().RecvMsg(gomock.Any()).Return(io.EOF).AnyTimes()
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+
+		// Writes should use the custom resource name as well.
+		writeStream := mock.NewMockClientStream(ctrl)
+		client.EXPECT().NewStream(gomock.Any(), gomock.Any(), "/google.bytestream.ByteStream/Write").Return(writeStream, nil)
+		writeStream.EXPECT().SendMsg(&bytestream.WriteRequest{
+			ResourceName: "cas/hello/uploads/7d659e2d-4ab0-4bdc-a6d2-1ad4f7f2e1e7/3e25960a79dbc69b674cd4ec67a72c62",
+			Data:         []byte("Hello worl"),
+		}).Return(status.Error(codes.Unavailable, "Server gone"))
+
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Server gone"),
+			blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		// Formatters that return empty resource names should
+		// cause requests to fail without contacting the server.
+		blobAccess := grpcclients.NewCASBlobAccess(client, uuidGenerator, 10, grpcclients.CASBlobAccessOptions{
+			ValidateReadSizes:          true,
+			ReadResourceNameFormatter:  func(digest digest.Digest) string { return "" },
+			WriteResourceNameFormatter: func(digest digest.Digest, uuid uuid.UUID) string { return "" },
+		})
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Resource name formatter returned an empty resource name for reading blob \"3e25960a79dbc69b674cd4ec67a72c62-11-hello\""), err)
+
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Resource name formatter returned an empty resource name for writing blob \"3e25960a79dbc69b674cd4ec67a72c62-11-hello\""),
+			blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+}
+
+func TestCASBlobAccessCompressors(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	client := mock.NewMockClientConnInterface(ctrl)
+	blobAccess := grpcclients.NewCASBlobAccess(client, uuid.NewRandom, 10, grpcclients.CASBlobAccessOptions{
+		ValidateReadSizes: true,
+		Compressors: grpcclients.CASCompressors{
+			FindMissing: "gzip",
+		},
+	})
+	blobDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	t.Run("FindMissing", func(t *testing.T) {
+		// FindMissingBlobs() should be called with the
+		// configured compressor.
+		client.EXPECT().Invoke(
+			ctx,
+			"/build.bazel.remote.execution.v2.ContentAddressableStorage/FindMissingBlobs",
+			&remoteexecution.FindMissingBlobsRequest{
+				InstanceName: "hello",
+				BlobDigests:  []*remoteexecution.Digest{blobDigest.GetProto()},
+			},
+			gomock.Any(),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+			require.Equal(t, []grpc.CallOption{grpc.UseCompressor("gzip")}, opts)
+			return nil
+		})
+
+		missing, err := blobAccess.FindMissing(ctx, blobDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	})
+
+	t.Run("GetCapabilities", func(t *testing.T) {
+		// No compressor is configured for GetCapabilities(),
+		// meaning no call options should be provided.
+		client.EXPECT().Invoke(
+			ctx,
+			"/build.bazel.remote.execution.v2.Capabilities/GetCapabilities",
+			&remoteexecution.GetCapabilitiesRequest{InstanceName: "hello"},
+			gomock.Any(),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+			require.Empty(t, opts)
+			return status.Error(codes.Unavailable, "Server not reachable")
+		})
+
+		_, err := blobAccess.GetCapabilities(ctx, digest.MustNewInstanceName("hello"))
+		require.Equal(t, status.Error(codes.Unavailable, "Server not reachable"), err)
+	})
+}
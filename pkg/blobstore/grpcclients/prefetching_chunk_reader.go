@@ -0,0 +1,78 @@
+package grpcclients
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+)
+
+type prefetchResult struct {
+	chunk []byte
+	err   error
+}
+
+// prefetchingChunkReader is a decorator for ChunkReader that reads
+// chunks from the underlying ChunkReader in a background goroutine,
+// keeping up to a fixed number of chunks ahead of the consumer. When
+// used in combination with byteStreamChunkReader, this causes
+// round-trips to the server to overlap with processing of the data,
+// which improves throughput on high-latency links.
+type prefetchingChunkReader struct {
+	base    buffer.ChunkReader
+	cancel  context.CancelFunc
+	results <-chan prefetchResult
+	done    <-chan struct{}
+	err     error
+}
+
+// newPrefetchingChunkReader creates a prefetchingChunkReader. The
+// context must be the one that is used by the underlying ChunkReader,
+// so that a goroutine blocked in the underlying ChunkReader can be
+// interrupted by calling the provided cancelation function.
+func newPrefetchingChunkReader(ctx context.Context, cancel context.CancelFunc, base buffer.ChunkReader, maximumChunks int) buffer.ChunkReader {
+	results := make(chan prefetchResult, maximumChunks)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			chunk, err := base.Read()
+			select {
+			case results <- prefetchResult{chunk: chunk, err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				// Also covers io.EOF, which is forwarded
+				// to the consumer like any other error.
+				return
+			}
+		}
+	}()
+	return &prefetchingChunkReader{
+		base:    base,
+		cancel:  cancel,
+		results: results,
+		done:    done,
+	}
+}
+
+func (r *prefetchingChunkReader) Read() ([]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	result := <-r.results
+	if result.err != nil {
+		r.err = result.err
+		return nil, result.err
+	}
+	return result.chunk, nil
+}
+
+func (r *prefetchingChunkReader) Close() {
+	// Interrupt the goroutine and wait for it to terminate before
+	// closing the underlying ChunkReader, as ChunkReaders may not
+	// be accessed concurrently.
+	r.cancel()
+	<-r.done
+	r.base.Close()
+}
@@ -0,0 +1,56 @@
+package grpcclients
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// writeBudget limits the number of bytes that may be in flight across
+// all ByteStream Write() calls issued by a client. Bytes are acquired
+// before a chunk is read from the buffer that is being written, and
+// released once the chunk has been handed over to the gRPC stream.
+type writeBudget struct {
+	lock           sync.Mutex
+	availableBytes int64
+	released       chan struct{}
+}
+
+func newWriteBudget(maximumSizeBytes int64) *writeBudget {
+	return &writeBudget{
+		availableBytes: maximumSizeBytes,
+		released:       make(chan struct{}),
+	}
+}
+
+// acquire blocks until the requested number of bytes is available, or
+// until the context is cancelled.
+func (wb *writeBudget) acquire(ctx context.Context, sizeBytes int64) error {
+	for {
+		wb.lock.Lock()
+		if wb.availableBytes >= sizeBytes {
+			wb.availableBytes -= sizeBytes
+			wb.lock.Unlock()
+			return nil
+		}
+		released := wb.released
+		wb.lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return util.StatusFromContext(ctx)
+		case <-released:
+		}
+	}
+}
+
+// release bytes that were previously acquired, waking up any callers
+// of acquire() that are waiting for bytes to become available.
+func (wb *writeBudget) release(sizeBytes int64) {
+	wb.lock.Lock()
+	wb.availableBytes += sizeBytes
+	close(wb.released)
+	wb.released = make(chan struct{})
+	wb.lock.Unlock()
+}
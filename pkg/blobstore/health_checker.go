@@ -0,0 +1,37 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// HealthCheckDigest is the digest of the sentinel blob for which
+// existence is checked by CheckHealth() for storage backends that do
+// not implement HealthChecker. It is not expected to be present.
+var HealthCheckDigest = digest.MustNewDigest("", "0000000000000000000000000000000000000000000000000000000000000000", 1)
+
+// HealthChecker is an optional capability of BlobAccess
+// implementations that are capable of checking whether they are able
+// to serve requests. As opposed to checking the state of connections,
+// health checks should exercise the same code paths as regular
+// requests, while being cheap enough to be performed every few
+// seconds (e.g., as part of a readiness probe).
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// CheckHealth checks whether a storage backend is able to serve
+// requests. If the BlobAccess implements HealthChecker, the request is
+// forwarded to CheckHealth(). Otherwise, FindMissing() is called
+// against HealthCheckDigest.
+func CheckHealth(ctx context.Context, blobAccess BlobAccess) error {
+	if healthChecker, ok := blobAccess.(HealthChecker); ok {
+		return healthChecker.CheckHealth(ctx)
+	}
+	if _, err := blobAccess.FindMissing(ctx, HealthCheckDigest.ToSingletonSet()); err != nil {
+		return util.StatusWrap(err, "Failed to find missing sentinel blob")
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// healthCheckingBlobAccess is a BlobAccess that implements
+// HealthChecker, whose calls are forwarded to mocks.
+type healthCheckingBlobAccess struct {
+	*mock.MockBlobAccess
+	*mock.MockHealthChecker
+}
+
+func TestCheckHealth(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	t.Run("Fallback", func(t *testing.T) {
+		// Backends that don't implement HealthChecker should
+		// have the sentinel blob checked for existence.
+		blobAccess := mock.NewMockBlobAccess(ctrl)
+		blobAccess.EXPECT().FindMissing(ctx, blobstore.HealthCheckDigest.ToSingletonSet()).
+			Return(blobstore.HealthCheckDigest.ToSingletonSet(), nil)
+
+		require.NoError(t, blobstore.CheckHealth(ctx, blobAccess))
+	})
+
+	t.Run("ThroughAdapters", func(t *testing.T) {
+		// The adapters that are placed in front of every
+		// backend should forward health checks.
+		backend := healthCheckingBlobAccess{
+			MockBlobAccess:    mock.NewMockBlobAccess(ctrl),
+			MockHealthChecker: mock.NewMockHealthChecker(ctrl),
+		}
+		backend.MockHealthChecker.EXPECT().CheckHealth(ctx).
+			Return(status.Error(codes.Unavailable, "Cursors could not be read"))
+		blobAccess := blobstore.NewTracingBlobAccess(
+			blobstore.NewMetricsBlobAccess(backend, mock.NewMockClock(ctrl), "health_checker_test"),
+			"health_checker_test",
+			mock.NewMockTracer(ctrl))
+
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Cursors could not be read"),
+			blobstore.CheckHealth(ctx, blobAccess))
+	})
+}
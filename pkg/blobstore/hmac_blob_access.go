@@ -0,0 +1,101 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type hmacBlobAccess struct {
+	BlobAccess
+	macStore BlobAccess
+	key      []byte
+}
+
+// NewHMACBlobAccess creates a decorator for the Content Addressable
+// Storage (CAS) that ensures that blobs returned by Get() have been
+// written by a party that is in possession of a secret key. This may be
+// used to obtain end-to-end integrity when blobs are transferred
+// through proxies that are not trusted.
+//
+// As blobs are content addressed, swapping the contents of a blob is
+// already detected by validating the data returned by Get() against
+// the requested digest. What remains is a keyed message authentication
+// code (MAC) over the blob's metadata. For every blob written through
+// Put(), an HMAC-SHA256 is computed over its digest, including the
+// instance name. This MAC is stored in macStore, using the blob's
+// digest as a key.
+//
+// macStore must be a store that does not validate its contents against
+// the key, similar to the Action Cache (AC). Were the MAC stored in the
+// CAS instead, the digest under which it is stored would already
+// determine its contents, making it impossible to detect tampering. As
+// digests of blobs may coincide with digests of actions, macStore must
+// not be shared with the Action Cache.
+//
+// Get() fails with DATA_LOSS if the MAC obtained from macStore does not
+// match. Blobs without a MAC are reported as absent by Get() and
+// FindMissing(), causing clients to upload them again.
+func NewHMACBlobAccess(base BlobAccess, macStore BlobAccess, key []byte) BlobAccess {
+	return &hmacBlobAccess{
+		BlobAccess: base,
+		macStore:   macStore,
+		key:        key,
+	}
+}
+
+// computeMAC computes the MAC of a blob.
+func (ba *hmacBlobAccess) computeMAC(blobDigest digest.Digest) []byte {
+	mac := hmac.New(sha256.New, ba.key)
+	mac.Write([]byte(blobDigest.GetKey(digest.KeyWithInstance)))
+	return mac.Sum(nil)
+}
+
+func (ba *hmacBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	observedMAC, err := ba.macStore.Get(ctx, blobDigest).ToByteSlice(sha256.Size)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob has no MAC"))
+		}
+		return buffer.NewBufferFromError(util.StatusWrap(err, "Failed to obtain MAC"))
+	}
+	if !hmac.Equal(observedMAC, ba.computeMAC(blobDigest)) {
+		return buffer.NewBufferFromError(buffer.MarkDataIntegrityError(status.Error(codes.DataLoss, "Blob has an invalid MAC")))
+	}
+	return ba.BlobAccess.Get(ctx, blobDigest)
+}
+
+func (ba *hmacBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	if err := ba.BlobAccess.Put(ctx, blobDigest, b); err != nil {
+		return err
+	}
+
+	// Only store the MAC after the blob has been written, so that
+	// the presence of a MAC implies that the blob exists.
+	if err := ba.macStore.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(ba.computeMAC(blobDigest))); err != nil {
+		return util.StatusWrap(err, "Failed to store MAC")
+	}
+	return nil
+}
+
+func (ba *hmacBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Blobs for which the MAC is absent are reported as missing,
+	// so that clients upload them again.
+	missingMACs, err := ba.macStore.FindMissing(ctx, digests)
+	if err != nil {
+		return digest.EmptySet, util.StatusWrap(err, "Failed to check for existence of MACs")
+	}
+
+	missingBlobs, err := ba.BlobAccess.FindMissing(ctx, digests)
+	if err != nil {
+		return digest.EmptySet, err
+	}
+	return digest.GetUnion([]digest.Set{missingBlobs, missingMACs}), nil
+}
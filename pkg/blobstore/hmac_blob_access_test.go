@@ -0,0 +1,115 @@
+package blobstore_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHMACBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	macStore := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewHMACBlobAccess(baseBlobAccess, macStore, []byte("secret"))
+
+	blobDigest1 := digest.MustNewDigest("hello", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(blobDigest1.GetKey(digest.KeyWithInstance)))
+	macBytes1 := mac.Sum(nil)
+	blobDigest2 := digest.MustNewDigest("hello", "82e35a63ceba37e9646434c5dd412ea577147f1e4a41ccde1614253187e3dbf9", 7)
+
+	t.Run("GetSuccess", func(t *testing.T) {
+		macStore.EXPECT().Get(ctx, blobDigest1).Return(buffer.NewValidatedBufferFromByteSlice(macBytes1))
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest1).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+
+		data, err := blobAccess.Get(ctx, blobDigest1).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("GetMissingMAC", func(t *testing.T) {
+		// Blobs without a MAC should be treated as absent.
+		macStore.EXPECT().Get(ctx, blobDigest1).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		_, err := blobAccess.Get(ctx, blobDigest1).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Blob has no MAC"), err)
+	})
+
+	t.Run("GetInvalidMAC", func(t *testing.T) {
+		// The MAC store returning a MAC that doesn't belong to
+		// the requested blob (e.g., because it has been tampered
+		// with) should cause a DATA_LOSS error.
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(blobDigest2.GetKey(digest.KeyWithInstance)))
+		macStore.EXPECT().Get(ctx, blobDigest1).Return(buffer.NewValidatedBufferFromByteSlice(mac.Sum(nil)))
+
+		_, err := blobAccess.Get(ctx, blobDigest1).ToByteSlice(100)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.DataLoss, "Blob has an invalid MAC")), err)
+	})
+
+	t.Run("GetMACStoreFailure", func(t *testing.T) {
+		macStore.EXPECT().Get(ctx, blobDigest1).Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+
+		_, err := blobAccess.Get(ctx, blobDigest1).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Unavailable, "Failed to obtain MAC: Server offline"), err)
+	})
+
+	t.Run("PutSuccess", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest1, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello world"), data)
+				return nil
+			})
+		macStore.EXPECT().Put(ctx, blobDigest1, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, macBytes1, data)
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, blobDigest1, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+
+	t.Run("PutFailure", func(t *testing.T) {
+		// No MAC should be stored if the blob could not be
+		// written.
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest1, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.Internal, "Disk on fire")
+			})
+
+		require.Equal(
+			t,
+			status.Error(codes.Internal, "Disk on fire"),
+			blobAccess.Put(ctx, blobDigest1, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		// The first blob is present, but has no MAC. The second
+		// blob is absent, but does have a MAC. Both should be
+		// reported as missing.
+		digests := digest.NewSetBuilder().Add(blobDigest1).Add(blobDigest2).Build()
+		macStore.EXPECT().FindMissing(ctx, digests).Return(blobDigest1.ToSingletonSet(), nil)
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(blobDigest2.ToSingletonSet(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, digests, missing)
+	})
+}
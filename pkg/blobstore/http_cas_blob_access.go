@@ -0,0 +1,215 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/jsonpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type httpCASBlobAccess struct {
+	httpClient HTTPClient
+	address    string
+	slots      chan struct{}
+}
+
+// NewHTTPCASBlobAccess creates a BlobAccess for the Content Addressable
+// Storage that is backed by a plain HTTP service. This permits
+// integration with object store gateways that are not capable of
+// exposing the gRPC based remote execution protocol.
+//
+// Blobs are read and written by issuing GET and PUT requests against
+// ${address}/${instanceName}/blobs/${hash}/${size}, respectively. The
+// existence of blobs is checked by issuing POST requests against
+// ${address}/v2/${instanceName}/blobs:findMissing, having a JSON
+// encoded FindMissingBlobsRequest as a body and returning a JSON
+// encoded FindMissingBlobsResponse. This corresponds to the HTTP
+// mapping of the FindMissingBlobs() method of the remote execution
+// protocol.
+//
+// The number of requests that may be in flight at the same time is
+// limited by maximumConcurrency. Requests issued through Get() remain
+// in flight until the returned buffer is consumed.
+func NewHTTPCASBlobAccess(httpClient HTTPClient, address string, maximumConcurrency int) BlobAccess {
+	return &httpCASBlobAccess{
+		httpClient: httpClient,
+		address:    address,
+		slots:      make(chan struct{}, maximumConcurrency),
+	}
+}
+
+func (ba *httpCASBlobAccess) acquireSlot(ctx context.Context) error {
+	select {
+	case ba.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return util.StatusFromContext(ctx)
+	}
+}
+
+func (ba *httpCASBlobAccess) releaseSlot() {
+	<-ba.slots
+}
+
+func (ba *httpCASBlobAccess) getBlobURL(blobDigest digest.Digest) string {
+	return fmt.Sprintf("%s/%s", ba.address, blobDigest.GetByteStreamReadPath())
+}
+
+// convertHTTPErrorStatus converts the status code of a HTTP response
+// to a gRPC status. The response body is closed.
+func convertHTTPErrorStatus(resp *http.Response) error {
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, "Blob not found")
+	case http.StatusBadRequest:
+		return status.Errorf(codes.InvalidArgument, "HTTP request failed with status %#v", resp.Status)
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return status.Errorf(codes.Unavailable, "HTTP request failed with status %#v", resp.Status)
+	default:
+		return status.Errorf(codes.Unknown, "HTTP request failed with status %#v", resp.Status)
+	}
+}
+
+func (ba *httpCASBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	if err := ba.acquireSlot(ctx); err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ba.getBlobURL(digest), nil)
+	if err != nil {
+		ba.releaseSlot()
+		return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.Internal, "Failed to create HTTP request"))
+	}
+	resp, err := ba.httpClient.Do(req)
+	if err != nil {
+		ba.releaseSlot()
+		return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.Unavailable, "HTTP request failed"))
+	}
+	if resp.StatusCode != http.StatusOK {
+		ba.releaseSlot()
+		return buffer.NewBufferFromError(convertHTTPErrorStatus(resp))
+	}
+	return buffer.NewCASBufferFromReader(
+		digest,
+		&slotReleasingReadCloser{
+			ReadCloser: resp.Body,
+			blobAccess: ba,
+		},
+		buffer.BackendProvided(buffer.Irreparable(digest)))
+}
+
+func (ba *httpCASBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if err := ba.acquireSlot(ctx); err != nil {
+		b.Discard()
+		return err
+	}
+	defer ba.releaseSlot()
+
+	r := b.ToReader()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, ba.getBlobURL(digest), r)
+	if err != nil {
+		r.Close()
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to create HTTP request")
+	}
+	req.ContentLength = digest.GetSizeBytes()
+	resp, err := ba.httpClient.Do(req)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Unavailable, "HTTP request failed")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return convertHTTPErrorStatus(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (ba *httpCASBlobAccess) findMissingForInstance(ctx context.Context, instanceName digest.InstanceName, blobDigests []*remoteexecution.Digest) ([]*remoteexecution.Digest, error) {
+	if err := ba.acquireSlot(ctx); err != nil {
+		return nil, err
+	}
+	defer ba.releaseSlot()
+
+	var requestBody bytes.Buffer
+	if err := (&jsonpb.Marshaler{}).Marshal(&requestBody, &remoteexecution.FindMissingBlobsRequest{
+		InstanceName: instanceName.String(),
+		BlobDigests:  blobDigests,
+	}); err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal request")
+	}
+	url := fmt.Sprintf("%s/%s", ba.address, path.Join("v2", instanceName.String(), "blobs:findMissing"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &requestBody)
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to create HTTP request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ba.httpClient.Do(req)
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Unavailable, "HTTP request failed")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, convertHTTPErrorStatus(resp)
+	}
+	defer resp.Body.Close()
+
+	var response remoteexecution.FindMissingBlobsResponse
+	if err := (&jsonpb.Unmarshaler{AllowUnknownFields: true}).Unmarshal(resp.Body, &response); err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to unmarshal response")
+	}
+	return response.MissingBlobDigests, nil
+}
+
+func (ba *httpCASBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	if digests.Empty() {
+		return digest.EmptySet, nil
+	}
+
+	// Partition all digests by instance name, as the
+	// FindMissingBlobs() method can only process digests for a
+	// single instance.
+	perInstanceDigests := map[digest.InstanceName][]*remoteexecution.Digest{}
+	for _, digest := range digests.Items() {
+		instanceName := digest.GetInstanceName()
+		perInstanceDigests[instanceName] = append(perInstanceDigests[instanceName], digest.GetProto())
+	}
+
+	missingDigests := digest.NewSetBuilder()
+	for instanceName, blobDigests := range perInstanceDigests {
+		missingBlobDigests, err := ba.findMissingForInstance(ctx, instanceName, blobDigests)
+		if err != nil {
+			return digest.EmptySet, err
+		}
+		for _, proto := range missingBlobDigests {
+			blobDigest, err := instanceName.NewDigestFromProto(proto)
+			if err != nil {
+				return digest.EmptySet, err
+			}
+			missingDigests.Add(blobDigest)
+		}
+	}
+	return missingDigests.Build(), nil
+}
+
+// slotReleasingReadCloser is a decorator for the body of a HTTP
+// response that releases the slot held by the request upon closure.
+type slotReleasingReadCloser struct {
+	io.ReadCloser
+	blobAccess *httpCASBlobAccess
+}
+
+func (r *slotReleasingReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.blobAccess.releaseSlot()
+	return err
+}
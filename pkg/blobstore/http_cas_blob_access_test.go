@@ -0,0 +1,157 @@
+package blobstore_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHTTPCASBlobAccessGet(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	httpClient := mock.NewMockHTTPClient(ctrl)
+	blobAccess := blobstore.NewHTTPCASBlobAccess(httpClient, "http://example.com", 1)
+	helloDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Success", func(t *testing.T) {
+		httpClient.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			require.Equal(t, http.MethodGet, req.Method)
+			require.Equal(t, "http://example.com/instance/blobs/8b1a9953c4611296a827abf8c47804d7/5", req.URL.String())
+			return &http.Response{
+				Status:     "200 OK",
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(strings.NewReader("Hello")),
+			}, nil
+		})
+
+		data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("ChecksumFailure", func(t *testing.T) {
+		// Data returned by the server should be validated.
+		httpClient.EXPECT().Do(gomock.Any()).Return(&http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader("Hallo")),
+		}, nil)
+
+		_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected")), err)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		// Because the previous requests have released their
+		// slot, this request may be issued.
+		httpClient.EXPECT().Do(gomock.Any()).Return(&http.Response{
+			Status:     "404 Not Found",
+			StatusCode: 404,
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+		}, nil)
+
+		_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
+	})
+}
+
+func TestHTTPCASBlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	httpClient := mock.NewMockHTTPClient(ctrl)
+	blobAccess := blobstore.NewHTTPCASBlobAccess(httpClient, "http://example.com", 1)
+	helloDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Success", func(t *testing.T) {
+		httpClient.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			require.Equal(t, http.MethodPut, req.Method)
+			require.Equal(t, "http://example.com/instance/blobs/8b1a9953c4611296a827abf8c47804d7/5", req.URL.String())
+			require.Equal(t, int64(5), req.ContentLength)
+			data, err := ioutil.ReadAll(req.Body)
+			require.NoError(t, err)
+			require.Equal(t, []byte("Hello"), data)
+			req.Body.Close()
+			return &http.Response{
+				Status:     "201 Created",
+				StatusCode: 201,
+				Body:       ioutil.NopCloser(strings.NewReader("")),
+			}, nil
+		})
+
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("ServiceUnavailable", func(t *testing.T) {
+		httpClient.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			req.Body.Close()
+			return &http.Response{
+				Status:     "503 Service Unavailable",
+				StatusCode: 503,
+				Body:       ioutil.NopCloser(strings.NewReader("")),
+			}, nil
+		})
+
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "HTTP request failed with status \"503 Service Unavailable\""),
+			blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+}
+
+func TestHTTPCASBlobAccessFindMissing(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	httpClient := mock.NewMockHTTPClient(ctrl)
+	blobAccess := blobstore.NewHTTPCASBlobAccess(httpClient, "http://example.com", 1)
+
+	t.Run("Empty", func(t *testing.T) {
+		// Empty sets should not cause any requests to be issued.
+		missing, err := blobAccess.FindMissing(ctx, digest.EmptySet)
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		httpClient.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			require.Equal(t, http.MethodPost, req.Method)
+			require.Equal(t, "http://example.com/v2/instance/blobs:findMissing", req.URL.String())
+			var request remoteexecution.FindMissingBlobsRequest
+			require.NoError(t, jsonpb.Unmarshal(req.Body, &request))
+			require.True(t, proto.Equal(&remoteexecution.FindMissingBlobsRequest{
+				InstanceName: "instance",
+				BlobDigests: []*remoteexecution.Digest{
+					{
+						Hash:      "8b1a9953c4611296a827abf8c47804d7",
+						SizeBytes: 5,
+					},
+				},
+			}, &request))
+			return &http.Response{
+				Status:     "200 OK",
+				StatusCode: 200,
+				Body: ioutil.NopCloser(strings.NewReader(
+					`{"missingBlobDigests": [{"hash": "8b1a9953c4611296a827abf8c47804d7", "sizeBytes": "5"}]}`)),
+			}, nil
+		})
+
+		helloDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+		missing, err := blobAccess.FindMissing(ctx, helloDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, helloDigest.ToSingletonSet(), missing)
+	})
+}
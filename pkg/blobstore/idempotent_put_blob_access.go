@@ -0,0 +1,160 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	idempotentPutBlobAccessPrometheusMetrics sync.Once
+
+	idempotentPutBlobAccessPuts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "idempotent_put_blob_access_puts_total",
+			Help:      "Number of calls to Put(), and the outcome of comparing the blob against an existing copy.",
+		},
+		[]string{"outcome"})
+	idempotentPutBlobAccessPutsTooLarge   = idempotentPutBlobAccessPuts.WithLabelValues("TooLarge")
+	idempotentPutBlobAccessPutsWritten    = idempotentPutBlobAccessPuts.WithLabelValues("Written")
+	idempotentPutBlobAccessPutsMatched    = idempotentPutBlobAccessPuts.WithLabelValues("Matched")
+	idempotentPutBlobAccessPutsMismatched = idempotentPutBlobAccessPuts.WithLabelValues("Mismatched")
+)
+
+// idempotentPutBlobAccessChunkSizeBytes is the size of the chunks in
+// which the blob being uploaded is compared against the existing copy.
+const idempotentPutBlobAccessChunkSizeBytes = 64 * 1024
+
+type idempotentPutBlobAccess struct {
+	BlobAccess
+	maximumSizeBytes int
+}
+
+// NewIdempotentPutBlobAccess creates a decorator for BlobAccess that
+// prevents blobs stored in the Content Addressable Storage from being
+// overwritten with different contents. Prior to calling Put(), the
+// existing copy of the blob is read. If present, the blob is not
+// written. Instead, its contents are compared against the blob that is
+// being uploaded:
+//
+//   - If the contents of the blob being uploaded don't match its digest,
+//     the data integrity error of the buffer (INVALID_ARGUMENT) is
+//     returned.
+//   - If the contents match the digest, but differ from the existing
+//     copy, ALREADY_EXISTS is returned.
+//   - If the contents are identical, the call succeeds without writing.
+//
+// As both copies are validated against the same digest, they can only
+// differ if the digest function has a collision. The comparison is
+// performed byte for byte, so that such collisions are detected
+// regardless of the digest function being used.
+//
+// If the existing copy cannot be read for any reason other than it
+// being absent, the blob is written without performing a comparison.
+// This prevents transient failures of the backend from causing uploads
+// to fail.
+//
+// The blob being uploaded is compared against the existing copy while
+// it is being streamed, without loading either of them into memory.
+// As this still requires reading the existing copy, comparisons are
+// only performed for blobs up to a given size. Larger blobs are
+// written without performing any comparison.
+//
+// This decorator should not be used for the Action Cache, as entries
+// stored in it may legitimately be overwritten.
+func NewIdempotentPutBlobAccess(base BlobAccess, maximumSizeBytes int) BlobAccess {
+	idempotentPutBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(idempotentPutBlobAccessPuts)
+	})
+
+	return &idempotentPutBlobAccess{
+		BlobAccess:       base,
+		maximumSizeBytes: maximumSizeBytes,
+	}
+}
+
+func (ba *idempotentPutBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if digest.GetSizeBytes() > int64(ba.maximumSizeBytes) {
+		idempotentPutBlobAccessPutsTooLarge.Inc()
+		return ba.BlobAccess.Put(ctx, digest, b)
+	}
+
+	// Read the first chunk of the existing copy of the blob prior
+	// to consuming the buffer that is being uploaded. This permits
+	// the buffer to still be written if no existing copy is present.
+	existingReader := ba.BlobAccess.Get(ctx, digest).ToReader()
+	defer existingReader.Close()
+	existingChunk := make([]byte, idempotentPutBlobAccessChunkSizeBytes)
+	existingSizeBytes, err := io.ReadFull(existingReader, existingChunk)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		idempotentPutBlobAccessPutsWritten.Inc()
+		return ba.BlobAccess.Put(ctx, digest, b)
+	}
+
+	// Compare the blob being uploaded against the existing copy.
+	// Validation of the blob being uploaded is performed through a
+	// separate clone of the stream, so that data integrity errors
+	// are reported even if the comparison terminates early.
+	b1, b2 := b.CloneStream()
+	validationErr := make(chan error, 1)
+	go func() {
+		validationErr <- b2.IntoWriter(ioutil.Discard)
+	}()
+	matched, compareErr := compareWithExistingCopy(b1.ToReader(), existingReader, existingChunk, existingSizeBytes, err)
+	if err := <-validationErr; err != nil {
+		return err
+	}
+	if compareErr != nil {
+		return compareErr
+	}
+	if !matched {
+		idempotentPutBlobAccessPutsMismatched.Inc()
+		return status.Errorf(codes.AlreadyExists, "Blob %#v already exists with different contents", digest.String())
+	}
+	idempotentPutBlobAccessPutsMatched.Inc()
+	return nil
+}
+
+// compareWithExistingCopy compares the contents of a blob being
+// uploaded against the existing copy of the blob, one chunk at a time.
+// The first chunk of the existing copy has already been read by the
+// caller.
+func compareWithExistingCopy(r io.ReadCloser, existingReader io.Reader, existingChunk []byte, existingSizeBytes int, existingErr error) (bool, error) {
+	defer r.Close()
+
+	chunk := make([]byte, len(existingChunk))
+	for {
+		n, err := io.ReadFull(r, chunk[:existingSizeBytes])
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(chunk[:n], existingChunk[:existingSizeBytes]) {
+			return false, nil
+		}
+		if existingErr != nil {
+			// The existing copy has been read entirely. The
+			// blob being uploaded should end as well.
+			if _, err := io.ReadFull(r, chunk[:1]); err != io.EOF {
+				return false, err
+			}
+			return true, nil
+		}
+
+		existingSizeBytes, existingErr = io.ReadFull(existingReader, existingChunk)
+		if existingErr != nil && existingErr != io.EOF && existingErr != io.ErrUnexpectedEOF {
+			return false, util.StatusWrap(existingErr, "Failed to read existing copy of blob")
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIdempotentPutBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewIdempotentPutBlobAccess(baseBlobAccess, 10)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	expectPut := func(blobDigest digest.Digest, expectedData []byte) {
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, expectedData, data)
+				return nil
+			})
+	}
+
+	t.Run("TooLarge", func(t *testing.T) {
+		// Blobs exceeding the maximum size should be written
+		// without comparing them against an existing copy.
+		largeDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+		expectPut(largeDigest, []byte("Hello world"))
+
+		require.NoError(t, blobAccess.Put(ctx, largeDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		expectPut(helloDigest, []byte("Hello"))
+
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("GetFailure", func(t *testing.T) {
+		// Failures to read the existing copy should not cause
+		// the upload to fail. The blob should be written
+		// without performing a comparison.
+		baseBlobAccess.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+		expectPut(helloDigest, []byte("Hello"))
+
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("Matched", func(t *testing.T) {
+		// Uploading a blob that is identical to the existing
+		// copy should succeed without writing it.
+		baseBlobAccess.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewCASBufferFromByteSlice(helloDigest, []byte("Hello"), buffer.UserProvided)))
+	})
+
+	t.Run("MatchedStream", func(t *testing.T) {
+		// The blob being uploaded may be backed by a stream,
+		// in which case it is compared against the existing
+		// copy while being read.
+		baseBlobAccess.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewCASBufferFromReader(helloDigest, ioutil.NopCloser(bytes.NewBufferString("Hello")), buffer.UserProvided)))
+	})
+
+	t.Run("ChecksumFailure", func(t *testing.T) {
+		// Blobs whose contents don't match their digest should
+		// be rejected by the buffer itself.
+		baseBlobAccess.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		require.Equal(
+			t,
+			buffer.MarkDataIntegrityError(status.Error(codes.InvalidArgument, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected")),
+			blobAccess.Put(ctx, helloDigest, buffer.NewCASBufferFromByteSlice(helloDigest, []byte("Hallo"), buffer.UserProvided)))
+	})
+
+	t.Run("ChecksumFailureStream", func(t *testing.T) {
+		// Even though the comparison already fails on the
+		// second byte, the blob being uploaded should be read
+		// entirely to report the data integrity error.
+		baseBlobAccess.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		require.Equal(
+			t,
+			buffer.MarkDataIntegrityError(status.Error(codes.InvalidArgument, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected")),
+			blobAccess.Put(ctx, helloDigest, buffer.NewCASBufferFromReader(helloDigest, ioutil.NopCloser(bytes.NewBufferString("Hallo")), buffer.UserProvided)))
+	})
+
+	t.Run("Mismatched", func(t *testing.T) {
+		// Simulate a hash collision, where the existing copy
+		// differs from the blob being uploaded. The existing
+		// copy should not be overwritten.
+		baseBlobAccess.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hallo")))
+
+		require.Equal(
+			t,
+			status.Error(codes.AlreadyExists, "Blob \"8b1a9953c4611296a827abf8c47804d7-5-hello\" already exists with different contents"),
+			blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+}
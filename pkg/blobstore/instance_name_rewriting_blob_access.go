@@ -0,0 +1,96 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// DigestRewriter is the callback type used by
+// InstanceNameRewritingBlobAccess to translate digests provided by
+// the caller to the digests used by the backend.
+type DigestRewriter func(blobDigest digest.Digest) (digest.Digest, error)
+
+// NewSingleInstanceNameDigestRewriter creates a DigestRewriter that
+// rewrites all digests to use the same instance name. This can be used
+// to let many instance names share a single namespace in a backend
+// that keys objects by instance name, so that identical objects are
+// only stored once.
+func NewSingleInstanceNameDigestRewriter(instanceName digest.InstanceName) DigestRewriter {
+	return func(blobDigest digest.Digest) (digest.Digest, error) {
+		rewrittenDigest, err := instanceName.NewDigestFromProto(blobDigest.GetProto())
+		if err != nil {
+			return digest.BadDigest, util.StatusWrapf(err, "Failed to rewrite digest %#v", blobDigest.String())
+		}
+		return rewrittenDigest, nil
+	}
+}
+
+type instanceNameRewritingBlobAccess struct {
+	base    BlobAccess
+	rewrite DigestRewriter
+}
+
+// NewInstanceNameRewritingBlobAccess creates a decorator for BlobAccess
+// that rewrites the instance name of digests before forwarding requests
+// to a backend. This can be used to front a backend that uses one
+// instance naming scheme with clients that use another.
+//
+// The rewrite function is not required to be injective. Multiple
+// digests may be rewritten to the same digest. In that case, all of
+// them are reported as missing by FindMissing() if the backend reports
+// the rewritten digest as missing.
+func NewInstanceNameRewritingBlobAccess(base BlobAccess, rewrite DigestRewriter) BlobAccess {
+	return &instanceNameRewritingBlobAccess{
+		base:    base,
+		rewrite: rewrite,
+	}
+}
+
+func (ba *instanceNameRewritingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	rewrittenDigest, err := ba.rewrite(digest)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return ba.base.Get(ctx, rewrittenDigest)
+}
+
+func (ba *instanceNameRewritingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	rewrittenDigest, err := ba.rewrite(digest)
+	if err != nil {
+		b.Discard()
+		return err
+	}
+	return ba.base.Put(ctx, rewrittenDigest, b)
+}
+
+func (ba *instanceNameRewritingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Rewrite all digests, keeping track of which digests provided
+	// by the caller correspond to each rewritten digest.
+	originalDigests := map[digest.Digest][]digest.Digest{}
+	rewrittenDigests := digest.NewSetBuilder()
+	for _, blobDigest := range digests.Items() {
+		rewrittenDigest, err := ba.rewrite(blobDigest)
+		if err != nil {
+			return digest.EmptySet, err
+		}
+		originalDigests[rewrittenDigest] = append(originalDigests[rewrittenDigest], blobDigest)
+		rewrittenDigests.Add(rewrittenDigest)
+	}
+
+	missing, err := ba.base.FindMissing(ctx, rewrittenDigests.Build())
+	if err != nil {
+		return digest.EmptySet, err
+	}
+
+	// Undo the rewriting on the results.
+	originalMissing := digest.NewSetBuilder()
+	for _, rewrittenDigest := range missing.Items() {
+		for _, blobDigest := range originalDigests[rewrittenDigest] {
+			originalMissing.Add(blobDigest)
+		}
+	}
+	return originalMissing.Build(), nil
+}
@@ -0,0 +1,157 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestInstanceNameRewritingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	// Rewrite instance names "a" and "b" to "old". This rewrite is
+	// not injective.
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewInstanceNameRewritingBlobAccess(
+		baseBlobAccess,
+		func(blobDigest digest.Digest) (digest.Digest, error) {
+			switch blobDigest.GetInstanceName().String() {
+			case "a", "b":
+				return digest.MustNewDigest("old", blobDigest.GetHashString(), blobDigest.GetSizeBytes()), nil
+			case "d":
+				return digest.BadDigest, status.Error(codes.InvalidArgument, "Instance name \"d\" cannot be rewritten")
+			}
+			return blobDigest, nil
+		})
+
+	t.Run("Get", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, digest.MustNewDigest("old", "8b1a9953c4611296a827abf8c47804d7", 5)).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, digest.MustNewDigest("a", "8b1a9953c4611296a827abf8c47804d7", 5)).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		b := buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))
+		baseBlobAccess.EXPECT().Put(ctx, digest.MustNewDigest("old", "8b1a9953c4611296a827abf8c47804d7", 5), b).
+			Return(status.Error(codes.Internal, "Server on fire"))
+
+		require.Equal(
+			t,
+			status.Error(codes.Internal, "Server on fire"),
+			blobAccess.Put(ctx, digest.MustNewDigest("b", "8b1a9953c4611296a827abf8c47804d7", 5), b))
+	})
+
+	t.Run("RewriteFailure", func(t *testing.T) {
+		// Errors returned by the rewrite function should be
+		// propagated, without calling into the backend.
+		_, err := blobAccess.Get(ctx, digest.MustNewDigest("d", "8b1a9953c4611296a827abf8c47804d7", 5)).ToByteSlice(10)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Instance name \"d\" cannot be rewritten"), err)
+
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Instance name \"d\" cannot be rewritten"),
+			blobAccess.Put(ctx, digest.MustNewDigest("d", "8b1a9953c4611296a827abf8c47804d7", 5), buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+		_, err = blobAccess.FindMissing(ctx, digest.MustNewDigest("d", "8b1a9953c4611296a827abf8c47804d7", 5).ToSingletonSet())
+		require.Equal(t, status.Error(codes.InvalidArgument, "Instance name \"d\" cannot be rewritten"), err)
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		// Digests for "a" and "b" that have the same hash get
+		// collapsed into a single digest. If reported missing,
+		// both should be returned to the caller.
+		baseBlobAccess.EXPECT().FindMissing(
+			ctx,
+			digest.NewSetBuilder().
+				Add(digest.MustNewDigest("old", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Add(digest.MustNewDigest("old", "6fc422233a40a75a1f028e11c3cd1140", 7)).
+				Add(digest.MustNewDigest("c", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Build(),
+		).Return(
+			digest.NewSetBuilder().
+				Add(digest.MustNewDigest("old", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Add(digest.MustNewDigest("c", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Build(),
+			nil)
+
+		missing, err := blobAccess.FindMissing(
+			ctx,
+			digest.NewSetBuilder().
+				Add(digest.MustNewDigest("a", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Add(digest.MustNewDigest("b", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Add(digest.MustNewDigest("b", "6fc422233a40a75a1f028e11c3cd1140", 7)).
+				Add(digest.MustNewDigest("c", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Build())
+		require.NoError(t, err)
+		require.Equal(
+			t,
+			digest.NewSetBuilder().
+				Add(digest.MustNewDigest("a", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Add(digest.MustNewDigest("b", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Add(digest.MustNewDigest("c", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Build(),
+			missing)
+	})
+}
+
+func TestInstanceNameRewritingBlobAccessSingleInstanceName(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewInstanceNameRewritingBlobAccess(
+		baseBlobAccess,
+		blobstore.NewSingleInstanceNameDigestRewriter(digest.MustNewInstanceName("shared")))
+
+	t.Run("FindMissing", func(t *testing.T) {
+		// Two instance names requesting the same object should
+		// cause a single object to be queried, while both are
+		// reported as missing.
+		baseBlobAccess.EXPECT().FindMissing(
+			ctx,
+			digest.NewSetBuilder().
+				Add(digest.MustNewDigest("shared", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Add(digest.MustNewDigest("shared", "6fc422233a40a75a1f028e11c3cd1140", 7)).
+				Build(),
+		).Return(
+			digest.MustNewDigest("shared", "8b1a9953c4611296a827abf8c47804d7", 5).ToSingletonSet(),
+			nil)
+
+		missing, err := blobAccess.FindMissing(
+			ctx,
+			digest.NewSetBuilder().
+				Add(digest.MustNewDigest("a", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Add(digest.MustNewDigest("a", "6fc422233a40a75a1f028e11c3cd1140", 7)).
+				Add(digest.MustNewDigest("b", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Add(digest.MustNewDigest("b", "6fc422233a40a75a1f028e11c3cd1140", 7)).
+				Build())
+		require.NoError(t, err)
+		require.Equal(
+			t,
+			digest.NewSetBuilder().
+				Add(digest.MustNewDigest("a", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Add(digest.MustNewDigest("b", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Build(),
+			missing)
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, digest.MustNewDigest("shared", "8b1a9953c4611296a827abf8c47804d7", 5)).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, digest.MustNewDigest("b", "8b1a9953c4611296a827abf8c47804d7", 5)).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+}
@@ -0,0 +1,37 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+type memoryLimitingBlobAccess struct {
+	BlobAccess
+	limiter *buffer.MemoryLimiter
+}
+
+// NewMemoryLimitingBlobAccess creates a decorator for BlobAccess that
+// attaches a buffer.MemoryLimiter to every buffer that is transferred
+// through Get() and Put(). This causes memory to be reserved whenever
+// the contents of such a buffer are loaded into memory. A single
+// limiter may be shared by many instances of this decorator, so that a
+// hard ceiling is placed on the total amount of memory used.
+//
+// See buffer.WithMemoryLimiter() for details on how much memory is
+// reserved, and when it is released.
+func NewMemoryLimitingBlobAccess(base BlobAccess, limiter *buffer.MemoryLimiter) BlobAccess {
+	return &memoryLimitingBlobAccess{
+		BlobAccess: base,
+		limiter:    limiter,
+	}
+}
+
+func (ba *memoryLimitingBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	return buffer.WithMemoryLimiter(ctx, ba.BlobAccess.Get(ctx, blobDigest), ba.limiter)
+}
+
+func (ba *memoryLimitingBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	return ba.BlobAccess.Put(ctx, blobDigest, buffer.WithMemoryLimiter(ctx, b, ba.limiter))
+}
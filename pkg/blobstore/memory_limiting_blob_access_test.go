@@ -0,0 +1,70 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMemoryLimitingBlobAccessGet(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewMemoryLimitingBlobAccess(
+		baseBlobAccess,
+		buffer.NewMemoryLimiter(8, false))
+	largeDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	t.Run("ToByteSliceTooLarge", func(t *testing.T) {
+		// Loading a buffer that exceeds the limit into memory
+		// should fail.
+		baseBlobAccess.EXPECT().Get(ctx, largeDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+
+		_, err := blobAccess.Get(ctx, largeDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.ResourceExhausted, "Buffer requires 11 bytes of memory, which exceeds the total limit of 8 bytes"), err)
+	})
+
+	t.Run("IntoWriterTooLarge", func(t *testing.T) {
+		// Streaming a buffer does not allocate memory
+		// proportional to its size. It should therefore be
+		// permitted.
+		baseBlobAccess.EXPECT().Get(ctx, largeDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+
+		var out bytes.Buffer
+		require.NoError(t, blobAccess.Get(ctx, largeDigest).IntoWriter(&out))
+		require.Equal(t, []byte("Hello world"), out.Bytes())
+	})
+}
+
+func TestMemoryLimitingBlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewMemoryLimitingBlobAccess(
+		baseBlobAccess,
+		buffer.NewMemoryLimiter(8, false))
+	largeDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	// Backends that load the buffer into memory should be subject
+	// to the limit.
+	baseBlobAccess.EXPECT().Put(ctx, largeDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+			_, err := b.ToByteSlice(100)
+			return err
+		})
+
+	require.Equal(
+		t,
+		status.Error(codes.ResourceExhausted, "Buffer requires 11 bytes of memory, which exceeds the total limit of 8 bytes"),
+		blobAccess.Put(ctx, largeDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+}
@@ -44,54 +44,139 @@ var (
 			Help:      "Amount of time spent per operation on blob access objects, in seconds.",
 			Buckets:   util.DecimalExponentialBuckets(-3, 6, 2),
 		},
-		[]string{"name", "operation", "grpc_code"})
+		[]string{"name", "operation", "digest_function", "grpc_code"})
+	blobAccessOperationsDurationBySizeClassSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "blob_access_operations_duration_by_size_class_seconds",
+			Help:      "Amount of time spent per operation on blob access objects, in seconds, partitioned by the size class of the blob.",
+			Buckets:   util.DecimalExponentialBuckets(-3, 6, 2),
+		},
+		[]string{"name", "operation", "size_class"})
 )
 
+// blobSizeClasses contains the upper bounds of the size classes that
+// are used to partition the duration of operations. Blobs that are
+// larger than the last upper bound are placed in a final size class.
+// The number of size classes is fixed to keep the cardinality of
+// metrics bounded.
+var blobSizeClasses = []struct {
+	maximumSizeBytes int64
+	name             string
+}{
+	{4*1024 - 1, "LessThan4KiB"},
+	{1024*1024 - 1, "LessThan1MiB"},
+	{100*1024*1024 - 1, "LessThan100MiB"},
+}
+
+// getBlobSizeClass returns the index of the size class of a blob.
+func getBlobSizeClass(digest digest.Digest) int {
+	sizeBytes := digest.GetSizeBytes()
+	for i, sizeClass := range blobSizeClasses {
+		if sizeBytes <= sizeClass.maximumSizeBytes {
+			return i
+		}
+	}
+	return len(blobSizeClasses)
+}
+
+// newBlobSizeClassObservers creates an Observer for every size class,
+// so that metrics for all size classes may be obtained without any
+// lookups.
+func newBlobSizeClassObservers(name string, operation string) []prometheus.Observer {
+	observers := make([]prometheus.Observer, 0, len(blobSizeClasses)+1)
+	for _, sizeClass := range blobSizeClasses {
+		observers = append(observers, blobAccessOperationsDurationBySizeClassSeconds.WithLabelValues(name, operation, sizeClass.name))
+	}
+	return append(observers, blobAccessOperationsDurationBySizeClassSeconds.WithLabelValues(name, operation, "AtLeast100MiB"))
+}
+
+// mixedDigestFunctions is used as the value of the "digest_function"
+// label for FindMissing() calls that contain digests using more than
+// one digest function. Splitting up such calls would cause their
+// duration to be accounted multiple times.
+const mixedDigestFunctions = "Mixed"
+
+// getDigestFunctionLabel returns the value of the "digest_function"
+// label for a set of digests. As the set of digest functions is
+// enumerated, the cardinality of this label remains bounded.
+func getDigestFunctionLabel(digests digest.Set) string {
+	items := digests.Items()
+	digestFunction := items[0].GetDigestFunction()
+	for _, blobDigest := range items[1:] {
+		if blobDigest.GetDigestFunction() != digestFunction {
+			return mixedDigestFunctions
+		}
+	}
+	return digestFunction.String()
+}
+
 type metricsBlobAccess struct {
 	blobAccess BlobAccess
 	clock      clock.Clock
 
-	getBlobSizeBytes           prometheus.Observer
-	getDurationSeconds         prometheus.ObserverVec
-	putBlobSizeBytes           prometheus.Observer
-	putDurationSeconds         prometheus.ObserverVec
-	findMissingBatchSize       prometheus.Observer
-	findMissingDurationSeconds prometheus.ObserverVec
+	getBlobSizeBytes            prometheus.Observer
+	getDurationSeconds          prometheus.ObserverVec
+	getDurationBySizeClass      []prometheus.Observer
+	putBlobSizeBytes            prometheus.Observer
+	putDurationSeconds          prometheus.ObserverVec
+	putDurationBySizeClass      []prometheus.Observer
+	getRangeDurationSeconds     prometheus.ObserverVec
+	getRangeDurationBySizeClass []prometheus.Observer
+	findMissingBatchSize        prometheus.Observer
+	findMissingDurationSeconds  prometheus.ObserverVec
 }
 
 // NewMetricsBlobAccess creates an adapter for BlobAccess that adds
-// basic instrumentation in the form of Prometheus metrics.
+// basic instrumentation in the form of Prometheus metrics. The duration
+// of operations is partitioned by the digest function of the blobs.
+//
+// As this adapter is applied to every backend, it also implements the
+// optional capabilities of BlobAccess (e.g., RangeReadingBlobAccess),
+// forwarding calls to the backend. This ensures that these capabilities
+// remain visible to callers.
 func NewMetricsBlobAccess(blobAccess BlobAccess, clock clock.Clock, name string) BlobAccess {
 	blobAccessOperationsPrometheusMetrics.Do(func() {
 		prometheus.MustRegister(blobAccessOperationsBlobSizeBytes)
 		prometheus.MustRegister(blobAccessOperationsFindMissingBatchSize)
 		prometheus.MustRegister(blobAccessOperationsDurationSeconds)
+		prometheus.MustRegister(blobAccessOperationsDurationBySizeClassSeconds)
 	})
 
 	return &metricsBlobAccess{
 		blobAccess: blobAccess,
 		clock:      clock,
 
-		getBlobSizeBytes:           blobAccessOperationsBlobSizeBytes.WithLabelValues(name, "Get"),
-		getDurationSeconds:         blobAccessOperationsDurationSeconds.MustCurryWith(map[string]string{"name": name, "operation": "Get"}),
-		putBlobSizeBytes:           blobAccessOperationsBlobSizeBytes.WithLabelValues(name, "Put"),
-		putDurationSeconds:         blobAccessOperationsDurationSeconds.MustCurryWith(map[string]string{"name": name, "operation": "Put"}),
-		findMissingBatchSize:       blobAccessOperationsFindMissingBatchSize.WithLabelValues(name),
-		findMissingDurationSeconds: blobAccessOperationsDurationSeconds.MustCurryWith(map[string]string{"name": name, "operation": "FindMissing"}),
+		getBlobSizeBytes:            blobAccessOperationsBlobSizeBytes.WithLabelValues(name, "Get"),
+		getDurationSeconds:          blobAccessOperationsDurationSeconds.MustCurryWith(map[string]string{"name": name, "operation": "Get"}),
+		getDurationBySizeClass:      newBlobSizeClassObservers(name, "Get"),
+		putBlobSizeBytes:            blobAccessOperationsBlobSizeBytes.WithLabelValues(name, "Put"),
+		putDurationSeconds:          blobAccessOperationsDurationSeconds.MustCurryWith(map[string]string{"name": name, "operation": "Put"}),
+		putDurationBySizeClass:      newBlobSizeClassObservers(name, "Put"),
+		getRangeDurationSeconds:     blobAccessOperationsDurationSeconds.MustCurryWith(map[string]string{"name": name, "operation": "GetRange"}),
+		getRangeDurationBySizeClass: newBlobSizeClassObservers(name, "GetRange"),
+		findMissingBatchSize:        blobAccessOperationsFindMissingBatchSize.WithLabelValues(name),
+		findMissingDurationSeconds:  blobAccessOperationsDurationSeconds.MustCurryWith(map[string]string{"name": name, "operation": "FindMissing"}),
 	}
 }
 
-func (ba *metricsBlobAccess) updateDurationSeconds(vec prometheus.ObserverVec, code codes.Code, timeStart time.Time) {
-	vec.WithLabelValues(code.String()).Observe(ba.clock.Now().Sub(timeStart).Seconds())
+func (ba *metricsBlobAccess) updateDurationSeconds(vec prometheus.ObserverVec, digestFunction string, code codes.Code, timeStart time.Time) float64 {
+	durationSeconds := ba.clock.Now().Sub(timeStart).Seconds()
+	vec.WithLabelValues(digestFunction, code.String()).Observe(durationSeconds)
+	return durationSeconds
 }
 
 func (ba *metricsBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
 	b := buffer.WithErrorHandler(
 		ba.blobAccess.Get(ctx, digest),
 		&metricsErrorHandler{
-			blobAccess: ba,
-			timeStart:  ba.clock.Now(),
-			errorCode:  codes.OK,
+			blobAccess:          ba,
+			durationSeconds:     ba.getDurationSeconds,
+			digestFunction:      digest.GetDigestFunction().String(),
+			timeStart:           ba.clock.Now(),
+			errorCode:           codes.OK,
+			durationBySizeClass: ba.getDurationBySizeClass[getBlobSizeClass(digest)],
 		})
 	if sizeBytes, err := b.GetSizeBytes(); err == nil {
 		ba.getBlobSizeBytes.Observe(float64(sizeBytes))
@@ -104,14 +189,16 @@ func (ba *metricsBlobAccess) Put(ctx context.Context, digest digest.Digest, b bu
 	// here instead of propagating the error to the underlying
 	// BlobAccess. Such a Put() call wouldn't have any effect.
 	sizeBytes, err := b.GetSizeBytes()
-	if err != nil {
+	if err == nil {
+		ba.putBlobSizeBytes.Observe(float64(sizeBytes))
+	} else if err != buffer.ErrSizeUnknown {
 		return err
 	}
-	ba.putBlobSizeBytes.Observe(float64(sizeBytes))
 
 	timeStart := ba.clock.Now()
 	err = ba.blobAccess.Put(ctx, digest, b)
-	ba.updateDurationSeconds(ba.putDurationSeconds, status.Code(err), timeStart)
+	ba.putDurationBySizeClass[getBlobSizeClass(digest)].Observe(
+		ba.updateDurationSeconds(ba.putDurationSeconds, digest.GetDigestFunction().String(), status.Code(err), timeStart))
 	return err
 }
 
@@ -124,16 +211,49 @@ func (ba *metricsBlobAccess) FindMissing(ctx context.Context, digests digest.Set
 	}
 
 	ba.findMissingBatchSize.Observe(float64(digests.Length()))
+	digestFunction := getDigestFunctionLabel(digests)
 	timeStart := ba.clock.Now()
 	digests, err := ba.blobAccess.FindMissing(ctx, digests)
-	ba.updateDurationSeconds(ba.findMissingDurationSeconds, status.Code(err), timeStart)
+	ba.updateDurationSeconds(ba.findMissingDurationSeconds, digestFunction, status.Code(err), timeStart)
 	return digests, err
 }
 
+func (ba *metricsBlobAccess) GetRange(ctx context.Context, digest digest.Digest, offset int64, sizeBytes int64) buffer.Buffer {
+	return buffer.WithErrorHandler(
+		GetRange(ctx, ba.blobAccess, digest, offset, sizeBytes),
+		&metricsErrorHandler{
+			blobAccess:          ba,
+			durationSeconds:     ba.getRangeDurationSeconds,
+			digestFunction:      digest.GetDigestFunction().String(),
+			timeStart:           ba.clock.Now(),
+			errorCode:           codes.OK,
+			durationBySizeClass: ba.getRangeDurationBySizeClass[getBlobSizeClass(digest)],
+		})
+}
+
+func (ba *metricsBlobAccess) GetCapabilities(ctx context.Context, instanceName digest.InstanceName) (Capabilities, error) {
+	return GetCapabilities(ctx, ba.blobAccess, instanceName)
+}
+
+func (ba *metricsBlobAccess) CheckHealth(ctx context.Context) error {
+	return CheckHealth(ctx, ba.blobAccess)
+}
+
+func (ba *metricsBlobAccess) Prefetch(ctx context.Context, digests digest.Set) {
+	Prefetch(ctx, ba.blobAccess, digests)
+}
+
+func (ba *metricsBlobAccess) ListDigests(ctx context.Context, instanceName digest.InstanceName, callback func(digest digest.Digest) error) error {
+	return ListDigests(ctx, ba.blobAccess, instanceName, callback)
+}
+
 type metricsErrorHandler struct {
-	blobAccess *metricsBlobAccess
-	timeStart  time.Time
-	errorCode  codes.Code
+	blobAccess          *metricsBlobAccess
+	durationSeconds     prometheus.ObserverVec
+	digestFunction      string
+	timeStart           time.Time
+	errorCode           codes.Code
+	durationBySizeClass prometheus.Observer
 }
 
 func (eh *metricsErrorHandler) OnError(err error) (buffer.Buffer, error) {
@@ -142,5 +262,6 @@ func (eh *metricsErrorHandler) OnError(err error) (buffer.Buffer, error) {
 }
 
 func (eh *metricsErrorHandler) Done() {
-	eh.blobAccess.updateDurationSeconds(eh.blobAccess.getDurationSeconds, eh.errorCode, eh.timeStart)
+	eh.durationBySizeClass.Observe(
+		eh.blobAccess.updateDurationSeconds(eh.durationSeconds, eh.digestFunction, eh.errorCode, eh.timeStart))
 }
@@ -0,0 +1,147 @@
+package blobstore_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMetricsBlobAccessGetRange(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	rangeReadingBlobAccess := mock.NewMockRangeReadingBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(time.Unix(1000, 0)).AnyTimes()
+	blobAccess := blobstore.NewMetricsBlobAccess(rangeReadingBlobAccess, clock, "metrics_test")
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	// Even though the adapter is placed in front of the backend,
+	// GetRange() calls should still be forwarded to it.
+	rangeReadingBlobAccess.EXPECT().GetRange(ctx, helloDigest, int64(1), int64(3)).
+		Return(buffer.NewValidatedBufferFromByteSlice([]byte("ell")))
+
+	data, err := blobstore.GetRange(ctx, blobAccess, helloDigest, 1, 3).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ell"), data)
+}
+
+// newLabelFilteringGatherer creates a Gatherer that only returns
+// metrics from the default registry that have a given set of labels.
+// This prevents metrics created by other tests from interfering.
+// Histogram buckets are omitted, so that only their sample counts and
+// sums are compared.
+func newLabelFilteringGatherer(labels map[string]string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := prometheus.DefaultGatherer.Gather()
+		if err != nil {
+			return nil, err
+		}
+		var filteredFamilies []*dto.MetricFamily
+		for _, family := range families {
+			var filteredMetrics []*dto.Metric
+			for _, metric := range family.Metric {
+				matches := 0
+				for _, label := range metric.Label {
+					if value, ok := labels[label.GetName()]; ok && value == label.GetValue() {
+						matches++
+					}
+				}
+				if matches == len(labels) {
+					if metric.Histogram != nil {
+						metric.Histogram.Bucket = nil
+					}
+					filteredMetrics = append(filteredMetrics, metric)
+				}
+			}
+			if len(filteredMetrics) > 0 {
+				family.Metric = filteredMetrics
+				filteredFamilies = append(filteredFamilies, family)
+			}
+		}
+		return filteredFamilies, nil
+	})
+}
+
+func TestMetricsBlobAccessLabels(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	now := time.Unix(1000, 0)
+	clock.EXPECT().Now().DoAndReturn(func() time.Time {
+		// Let every operation take half a second.
+		now = now.Add(500 * time.Millisecond)
+		return now
+	}).AnyTimes()
+	blobAccess := blobstore.NewMetricsBlobAccess(baseBlobAccess, clock, "metrics_test_labels")
+
+	md5Digest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	sha256Digest := digest.MustNewDigest("hello", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 2*1024*1024)
+
+	// Put() calls should be labeled with the digest function of
+	// the blob and be placed in the size class of the blob.
+	baseBlobAccess.EXPECT().Put(ctx, gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			b.Discard()
+			return nil
+		}).Times(2)
+	require.NoError(t, blobAccess.Put(ctx, md5Digest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	require.NoError(t, blobAccess.Put(ctx, sha256Digest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	// FindMissing() calls containing digests using different
+	// digest functions should not be split up.
+	mixedDigests := digest.NewSetBuilder().Add(md5Digest).Add(sha256Digest).Build()
+	baseBlobAccess.EXPECT().FindMissing(ctx, mixedDigests).Return(digest.EmptySet, nil)
+	missing, err := blobAccess.FindMissing(ctx, mixedDigests)
+	require.NoError(t, err)
+	require.Equal(t, digest.EmptySet, missing)
+
+	require.NoError(t, testutil.GatherAndCompare(
+		newLabelFilteringGatherer(map[string]string{"name": "metrics_test_labels"}),
+		strings.NewReader(`
+# HELP buildbarn_blobstore_blob_access_operations_duration_seconds Amount of time spent per operation on blob access objects, in seconds.
+# TYPE buildbarn_blobstore_blob_access_operations_duration_seconds histogram
+buildbarn_blobstore_blob_access_operations_duration_seconds_bucket{digest_function="MD5",grpc_code="OK",name="metrics_test_labels",operation="Put",le="+Inf"} 1
+buildbarn_blobstore_blob_access_operations_duration_seconds_sum{digest_function="MD5",grpc_code="OK",name="metrics_test_labels",operation="Put"} 0.5
+buildbarn_blobstore_blob_access_operations_duration_seconds_count{digest_function="MD5",grpc_code="OK",name="metrics_test_labels",operation="Put"} 1
+buildbarn_blobstore_blob_access_operations_duration_seconds_bucket{digest_function="SHA256",grpc_code="OK",name="metrics_test_labels",operation="Put",le="+Inf"} 1
+buildbarn_blobstore_blob_access_operations_duration_seconds_sum{digest_function="SHA256",grpc_code="OK",name="metrics_test_labels",operation="Put"} 0.5
+buildbarn_blobstore_blob_access_operations_duration_seconds_count{digest_function="SHA256",grpc_code="OK",name="metrics_test_labels",operation="Put"} 1
+buildbarn_blobstore_blob_access_operations_duration_seconds_bucket{digest_function="Mixed",grpc_code="OK",name="metrics_test_labels",operation="FindMissing",le="+Inf"} 1
+buildbarn_blobstore_blob_access_operations_duration_seconds_sum{digest_function="Mixed",grpc_code="OK",name="metrics_test_labels",operation="FindMissing"} 0.5
+buildbarn_blobstore_blob_access_operations_duration_seconds_count{digest_function="Mixed",grpc_code="OK",name="metrics_test_labels",operation="FindMissing"} 1
+`),
+		"buildbarn_blobstore_blob_access_operations_duration_seconds"))
+
+	require.NoError(t, testutil.GatherAndCompare(
+		newLabelFilteringGatherer(map[string]string{"name": "metrics_test_labels", "operation": "Put"}),
+		strings.NewReader(`
+# HELP buildbarn_blobstore_blob_access_operations_duration_by_size_class_seconds Amount of time spent per operation on blob access objects, in seconds, partitioned by the size class of the blob.
+# TYPE buildbarn_blobstore_blob_access_operations_duration_by_size_class_seconds histogram
+buildbarn_blobstore_blob_access_operations_duration_by_size_class_seconds_bucket{name="metrics_test_labels",operation="Put",size_class="LessThan4KiB",le="+Inf"} 1
+buildbarn_blobstore_blob_access_operations_duration_by_size_class_seconds_sum{name="metrics_test_labels",operation="Put",size_class="LessThan4KiB"} 0.5
+buildbarn_blobstore_blob_access_operations_duration_by_size_class_seconds_count{name="metrics_test_labels",operation="Put",size_class="LessThan4KiB"} 1
+buildbarn_blobstore_blob_access_operations_duration_by_size_class_seconds_bucket{name="metrics_test_labels",operation="Put",size_class="LessThan1MiB",le="+Inf"} 0
+buildbarn_blobstore_blob_access_operations_duration_by_size_class_seconds_sum{name="metrics_test_labels",operation="Put",size_class="LessThan1MiB"} 0
+buildbarn_blobstore_blob_access_operations_duration_by_size_class_seconds_count{name="metrics_test_labels",operation="Put",size_class="LessThan1MiB"} 0
+buildbarn_blobstore_blob_access_operations_duration_by_size_class_seconds_bucket{name="metrics_test_labels",operation="Put",size_class="LessThan100MiB",le="+Inf"} 1
+buildbarn_blobstore_blob_access_operations_duration_by_size_class_seconds_sum{name="metrics_test_labels",operation="Put",size_class="LessThan100MiB"} 0.5
+buildbarn_blobstore_blob_access_operations_duration_by_size_class_seconds_count{name="metrics_test_labels",operation="Put",size_class="LessThan100MiB"} 1
+buildbarn_blobstore_blob_access_operations_duration_by_size_class_seconds_bucket{name="metrics_test_labels",operation="Put",size_class="AtLeast100MiB",le="+Inf"} 0
+buildbarn_blobstore_blob_access_operations_duration_by_size_class_seconds_sum{name="metrics_test_labels",operation="Put",size_class="AtLeast100MiB"} 0
+buildbarn_blobstore_blob_access_operations_duration_by_size_class_seconds_count{name="metrics_test_labels",operation="Put",size_class="AtLeast100MiB"} 0
+`),
+		"buildbarn_blobstore_blob_access_operations_duration_by_size_class_seconds"))
+}
@@ -30,14 +30,41 @@ var (
 		[]string{"direction"})
 	mirroredBlobAccessFindMissingSynchronizationsFromAToB = mirroredBlobAccessFindMissingSynchronizations.WithLabelValues("FromAToB")
 	mirroredBlobAccessFindMissingSynchronizationsFromBToA = mirroredBlobAccessFindMissingSynchronizations.WithLabelValues("FromBToA")
+
+	mirroredBlobAccessPendingReplications = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "mirrored_blob_access_pending_replications_total",
+			Help:      "Number of blobs that were only written into backend A and were queued for replication, and the number of them that were replicated or dropped by Reconcile()",
+		},
+		[]string{"operation"})
+	mirroredBlobAccessPendingReplicationsQueued     = mirroredBlobAccessPendingReplications.WithLabelValues("Queued")
+	mirroredBlobAccessPendingReplicationsReconciled = mirroredBlobAccessPendingReplications.WithLabelValues("Reconciled")
+	mirroredBlobAccessPendingReplicationsDropped    = mirroredBlobAccessPendingReplications.WithLabelValues("Dropped")
 )
 
+// MirroredBlobAccess is a BlobAccess that mirrors data between two
+// storage backends.
+type MirroredBlobAccess interface {
+	blobstore.BlobAccess
+
+	// Reconcile copies blobs that were only written into backend A,
+	// due to backend B being unavailable at the time, into backend
+	// B. It should be called periodically when a
+	// PendingReplicationQueue is provided. Blobs that are no longer
+	// present in backend A are removed from the queue without being
+	// copied.
+	Reconcile(ctx context.Context) error
+}
+
 type mirroredBlobAccess struct {
-	backendA       blobstore.BlobAccess
-	backendB       blobstore.BlobAccess
-	replicatorAToB replication.BlobReplicator
-	replicatorBToA replication.BlobReplicator
-	round          uint32
+	backendA                blobstore.BlobAccess
+	backendB                blobstore.BlobAccess
+	replicatorAToB          replication.BlobReplicator
+	replicatorBToA          replication.BlobReplicator
+	pendingReplicationQueue PendingReplicationQueue
+	round                   uint32
 }
 
 // NewMirroredBlobAccess creates a BlobAccess that applies operations to
@@ -45,16 +72,24 @@ type mirroredBlobAccess struct {
 // inconsistencies between the two storage backends are detected (i.e.,
 // a blob is only present in one of the backends), the blob is
 // replicated.
-func NewMirroredBlobAccess(backendA blobstore.BlobAccess, backendB blobstore.BlobAccess, replicatorAToB replication.BlobReplicator, replicatorBToA replication.BlobReplicator) blobstore.BlobAccess {
+//
+// If a PendingReplicationQueue is provided, Put() operations succeed
+// as long as they succeed against backend A, even if backend B is
+// unavailable. Such blobs are added to the queue, so that they may be
+// copied into backend B by Reconcile() later on. This permits builds
+// to continue while backend B is down.
+func NewMirroredBlobAccess(backendA blobstore.BlobAccess, backendB blobstore.BlobAccess, replicatorAToB replication.BlobReplicator, replicatorBToA replication.BlobReplicator, pendingReplicationQueue PendingReplicationQueue) MirroredBlobAccess {
 	mirroredBlobAccessPrometheusMetrics.Do(func() {
 		prometheus.MustRegister(mirroredBlobAccessFindMissingSynchronizations)
+		prometheus.MustRegister(mirroredBlobAccessPendingReplications)
 	})
 
 	return &mirroredBlobAccess{
-		backendA:       backendA,
-		backendB:       backendB,
-		replicatorAToB: replicatorAToB,
-		replicatorBToA: replicatorBToA,
+		backendA:                backendA,
+		backendB:                backendB,
+		replicatorAToB:          replicatorAToB,
+		replicatorBToA:          replicatorBToA,
+		pendingReplicationQueue: pendingReplicationQueue,
 	}
 }
 
@@ -85,8 +120,12 @@ func (ba *mirroredBlobAccess) Get(ctx context.Context, digest digest.Digest) buf
 }
 
 func (ba *mirroredBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
-	// Store object in both storage backends.
+	// Store object in both storage backends. Bind the context to
+	// both clones, so that a stalling backend does not prevent the
+	// other backend from returning once the request is cancelled.
 	b1, b2 := b.CloneStream()
+	b1 = buffer.WithContext(b1, ctx)
+	b2 = buffer.WithContext(b2, ctx)
 	errAChan := make(chan error, 1)
 	go func() {
 		errAChan <- ba.backendA.Put(ctx, digest, b1)
@@ -96,11 +135,78 @@ func (ba *mirroredBlobAccess) Put(ctx context.Context, digest digest.Digest, b b
 		return util.StatusWrap(errA, "Backend A")
 	}
 	if errB != nil {
+		// Permit writes to succeed while backend B is
+		// unavailable, as long as the blob can be replicated
+		// later on.
+		if status.Code(errB) == codes.Unavailable && ba.pendingReplicationQueue != nil {
+			if errQueue := ba.pendingReplicationQueue.Add(digest); errQueue == nil {
+				mirroredBlobAccessPendingReplicationsQueued.Inc()
+				return nil
+			}
+		}
 		return util.StatusWrap(errB, "Backend B")
 	}
 	return nil
 }
 
+func (ba *mirroredBlobAccess) CheckHealth(ctx context.Context) error {
+	// Check the health of both storage backends.
+	errAChan := make(chan error, 1)
+	go func() {
+		errAChan <- blobstore.CheckHealth(ctx, ba.backendA)
+	}()
+	errB := blobstore.CheckHealth(ctx, ba.backendB)
+	if errA := <-errAChan; errA != nil {
+		return util.StatusWrap(errA, "Backend A")
+	}
+	// Similar to Put(), backend B being unavailable is tolerated
+	// if writes can be replicated later on.
+	if errB != nil && !(status.Code(errB) == codes.Unavailable && ba.pendingReplicationQueue != nil) {
+		return util.StatusWrap(errB, "Backend B")
+	}
+	return nil
+}
+
+func (ba *mirroredBlobAccess) Reconcile(ctx context.Context) error {
+	if ba.pendingReplicationQueue == nil {
+		return nil
+	}
+	pending := ba.pendingReplicationQueue.GetAll()
+	if pending.Empty() {
+		return nil
+	}
+
+	// Only copy the blobs that are still absent in backend B. Some
+	// of them may have been written by other clients in the
+	// meantime.
+	missing, err := ba.backendB.FindMissing(ctx, pending)
+	if err != nil {
+		return util.StatusWrap(err, "Backend B")
+	}
+
+	// Blobs that have disappeared from backend A as well (e.g.,
+	// due to them being evicted) can no longer be replicated.
+	// Drop them from the queue, as they would otherwise cause
+	// every subsequent call to fail.
+	lost := digest.EmptySet
+	if !missing.Empty() {
+		lost, err = ba.backendA.FindMissing(ctx, missing)
+		if err != nil {
+			return util.StatusWrap(err, "Backend A")
+		}
+		missing, _, _ = digest.GetDifferenceAndIntersection(missing, lost)
+	}
+	if err := ba.replicatorAToB.ReplicateMultiple(ctx, missing); err != nil {
+		return util.StatusWrap(err, "Failed to synchronize from backend A to backend B")
+	}
+	if err := ba.pendingReplicationQueue.Remove(pending); err != nil {
+		return err
+	}
+	mirroredBlobAccessPendingReplicationsReconciled.Add(float64(pending.Length() - lost.Length()))
+	mirroredBlobAccessPendingReplicationsDropped.Add(float64(lost.Length()))
+	return nil
+}
+
 type findMissingResults struct {
 	missing digest.Set
 	err     error
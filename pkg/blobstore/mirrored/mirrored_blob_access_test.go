@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
 	"github.com/buildbarn/bb-storage/pkg/digest"
@@ -33,7 +34,7 @@ func TestMirroredBlobAccessGet(t *testing.T) {
 			backendA.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))),
 		)
 
-		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, nil)
 		for i := 0; i < 3; i++ {
 			data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
 			require.NoError(t, err)
@@ -48,7 +49,7 @@ func TestMirroredBlobAccessGet(t *testing.T) {
 		backendA.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
 		replicatorBToA.EXPECT().ReplicateSingle(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
 
-		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, nil)
 		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
 		require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
 	})
@@ -59,7 +60,7 @@ func TestMirroredBlobAccessGet(t *testing.T) {
 		backendA.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
 		replicatorBToA.EXPECT().ReplicateSingle(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
 
-		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, nil)
 		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
 		require.NoError(t, err)
 		require.Equal(t, []byte("Hello world"), data)
@@ -70,7 +71,7 @@ func TestMirroredBlobAccessGet(t *testing.T) {
 
 		// In case of fatal errors, the name of the backend
 		// should be prepended.
-		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, nil)
 		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
 		require.Equal(t, status.Error(codes.Internal, "Backend A: Server on fire"), err)
 	})
@@ -79,7 +80,7 @@ func TestMirroredBlobAccessGet(t *testing.T) {
 		backendA.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
 		replicatorBToA.EXPECT().ReplicateSingle(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.Internal, "Server on fire")))
 
-		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, nil)
 		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
 		require.Equal(t, status.Error(codes.Internal, "Backend B: Server on fire"), err)
 	})
@@ -93,7 +94,7 @@ func TestMirroredBlobAccessPut(t *testing.T) {
 	replicatorAToB := mock.NewMockBlobReplicator(ctrl)
 	replicatorBToA := mock.NewMockBlobReplicator(ctrl)
 	blobDigest := digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
-	blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+	blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, nil)
 
 	t.Run("Success", func(t *testing.T) {
 		backendA.EXPECT().Put(gomock.Any(), blobDigest, gomock.Any()).DoAndReturn(
@@ -167,7 +168,7 @@ func TestMirroredBlobAccessFindMissing(t *testing.T) {
 	onlyOnB := digestB.ToSingletonSet()
 	missingFromA := digest.NewSetBuilder().Add(digestNone).Add(digestB).Build()
 	missingFromB := digest.NewSetBuilder().Add(digestNone).Add(digestA).Build()
-	blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+	blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, nil)
 
 	t.Run("Success", func(t *testing.T) {
 		// Listings of both backends should be requested.
@@ -224,3 +225,159 @@ func TestMirroredBlobAccessFindMissing(t *testing.T) {
 		require.Equal(t, status.Error(codes.Internal, "Failed to synchronize from backend B to backend A: Server on fire"), err)
 	})
 }
+
+func TestMirroredBlobAccessPendingReplication(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	backendA := mock.NewMockBlobAccess(ctrl)
+	backendB := mock.NewMockBlobAccess(ctrl)
+	replicatorAToB := mock.NewMockBlobReplicator(ctrl)
+	replicatorBToA := mock.NewMockBlobReplicator(ctrl)
+	blobDigest1 := digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+	blobDigest2 := digest.MustNewDigest("default", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+	pendingReplicationQueue := mirrored.NewInMemoryPendingReplicationQueue(1)
+	blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, pendingReplicationQueue)
+
+	discardingPut := func(err error) func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+		return func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			b.Discard()
+			return err
+		}
+	}
+
+	t.Run("ReconcileEmpty", func(t *testing.T) {
+		// Reconciliation should be a no-op if no blobs have been
+		// queued.
+		require.NoError(t, blobAccess.Reconcile(ctx))
+	})
+
+	t.Run("PutQueued", func(t *testing.T) {
+		// Writes should succeed if only backend B is
+		// unavailable. The blob should be queued.
+		backendA.EXPECT().Put(gomock.Any(), blobDigest1, gomock.Any()).DoAndReturn(discardingPut(nil))
+		backendB.EXPECT().Put(gomock.Any(), blobDigest1, gomock.Any()).DoAndReturn(discardingPut(status.Error(codes.Unavailable, "Connection refused")))
+
+		require.NoError(t, blobAccess.Put(ctx, blobDigest1, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+		require.Equal(t, blobDigest1.ToSingletonSet(), pendingReplicationQueue.GetAll())
+	})
+
+	t.Run("PutQueueFull", func(t *testing.T) {
+		// Once the queue is full, errors of backend B should be
+		// propagated once again.
+		backendA.EXPECT().Put(gomock.Any(), blobDigest2, gomock.Any()).DoAndReturn(discardingPut(nil))
+		backendB.EXPECT().Put(gomock.Any(), blobDigest2, gomock.Any()).DoAndReturn(discardingPut(status.Error(codes.Unavailable, "Connection refused")))
+
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Backend B: Connection refused"),
+			blobAccess.Put(ctx, blobDigest2, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("PutNotUnavailable", func(t *testing.T) {
+		// Errors other than UNAVAILABLE should not cause blobs
+		// to be queued, as they are unlikely to be resolved by
+		// retrying.
+		backendA.EXPECT().Put(gomock.Any(), blobDigest1, gomock.Any()).DoAndReturn(discardingPut(nil))
+		backendB.EXPECT().Put(gomock.Any(), blobDigest1, gomock.Any()).DoAndReturn(discardingPut(status.Error(codes.Internal, "Server on fire")))
+
+		require.Equal(
+			t,
+			status.Error(codes.Internal, "Backend B: Server on fire"),
+			blobAccess.Put(ctx, blobDigest1, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+
+	t.Run("ReconcileFailure", func(t *testing.T) {
+		// Blobs should remain queued if replication fails.
+		backendB.EXPECT().FindMissing(ctx, blobDigest1.ToSingletonSet()).Return(blobDigest1.ToSingletonSet(), nil)
+		backendA.EXPECT().FindMissing(ctx, blobDigest1.ToSingletonSet()).Return(digest.EmptySet, nil)
+		replicatorAToB.EXPECT().ReplicateMultiple(ctx, blobDigest1.ToSingletonSet()).Return(status.Error(codes.Unavailable, "Connection refused"))
+
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Failed to synchronize from backend A to backend B: Connection refused"),
+			blobAccess.Reconcile(ctx))
+		require.Equal(t, blobDigest1.ToSingletonSet(), pendingReplicationQueue.GetAll())
+	})
+
+	t.Run("ReconcileSuccess", func(t *testing.T) {
+		// Successful replication should cause the queue to be
+		// emptied.
+		backendB.EXPECT().FindMissing(ctx, blobDigest1.ToSingletonSet()).Return(blobDigest1.ToSingletonSet(), nil)
+		backendA.EXPECT().FindMissing(ctx, blobDigest1.ToSingletonSet()).Return(digest.EmptySet, nil)
+		replicatorAToB.EXPECT().ReplicateMultiple(ctx, blobDigest1.ToSingletonSet())
+
+		require.NoError(t, blobAccess.Reconcile(ctx))
+		require.True(t, pendingReplicationQueue.GetAll().Empty())
+	})
+
+	t.Run("ReconcileMissingFromBackendA", func(t *testing.T) {
+		// Blobs that have disappeared from backend A cannot be
+		// replicated. They should be dropped from the queue, so
+		// that they don't prevent other blobs from being
+		// replicated.
+		backendA.EXPECT().Put(gomock.Any(), blobDigest2, gomock.Any()).DoAndReturn(discardingPut(nil))
+		backendB.EXPECT().Put(gomock.Any(), blobDigest2, gomock.Any()).DoAndReturn(discardingPut(status.Error(codes.Unavailable, "Connection refused")))
+		require.NoError(t, blobAccess.Put(ctx, blobDigest2, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+		backendB.EXPECT().FindMissing(ctx, blobDigest2.ToSingletonSet()).Return(blobDigest2.ToSingletonSet(), nil)
+		backendA.EXPECT().FindMissing(ctx, blobDigest2.ToSingletonSet()).Return(blobDigest2.ToSingletonSet(), nil)
+		replicatorAToB.EXPECT().ReplicateMultiple(ctx, digest.EmptySet)
+
+		require.NoError(t, blobAccess.Reconcile(ctx))
+		require.True(t, pendingReplicationQueue.GetAll().Empty())
+	})
+}
+
+func TestMirroredBlobAccessCheckHealth(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	backendA := mock.NewMockBlobAccess(ctrl)
+	backendB := mock.NewMockBlobAccess(ctrl)
+	replicatorAToB := mock.NewMockBlobReplicator(ctrl)
+	replicatorBToA := mock.NewMockBlobReplicator(ctrl)
+	sentinelDigests := blobstore.HealthCheckDigest.ToSingletonSet()
+
+	t.Run("Success", func(t *testing.T) {
+		// Backends that don't implement HealthChecker should be
+		// checked by calling FindMissing().
+		backendA.EXPECT().FindMissing(ctx, sentinelDigests).Return(sentinelDigests, nil)
+		backendB.EXPECT().FindMissing(ctx, sentinelDigests).Return(sentinelDigests, nil)
+
+		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, nil)
+		require.NoError(t, blobstore.CheckHealth(ctx, blobAccess))
+	})
+
+	t.Run("BackendBUnavailable", func(t *testing.T) {
+		backendA.EXPECT().FindMissing(ctx, sentinelDigests).Return(sentinelDigests, nil)
+		backendB.EXPECT().FindMissing(ctx, sentinelDigests).Return(digest.EmptySet, status.Error(codes.Unavailable, "Server not reachable"))
+
+		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, nil)
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Backend B: Failed to find missing sentinel blob: Server not reachable"),
+			blobstore.CheckHealth(ctx, blobAccess))
+	})
+
+	t.Run("BackendBUnavailableWithPendingReplication", func(t *testing.T) {
+		// If blobs can be replicated into backend B later on,
+		// the mirrored backend as a whole remains healthy.
+		backendA.EXPECT().FindMissing(ctx, sentinelDigests).Return(sentinelDigests, nil)
+		backendB.EXPECT().FindMissing(ctx, sentinelDigests).Return(digest.EmptySet, status.Error(codes.Unavailable, "Server not reachable"))
+
+		pendingReplicationQueue := mirrored.NewInMemoryPendingReplicationQueue(1)
+		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, pendingReplicationQueue)
+		require.NoError(t, blobstore.CheckHealth(ctx, blobAccess))
+	})
+
+	t.Run("BackendAFailure", func(t *testing.T) {
+		backendA.EXPECT().FindMissing(ctx, sentinelDigests).Return(digest.EmptySet, status.Error(codes.Internal, "Disk on fire"))
+		backendB.EXPECT().FindMissing(ctx, sentinelDigests).Return(sentinelDigests, nil)
+
+		pendingReplicationQueue := mirrored.NewInMemoryPendingReplicationQueue(1)
+		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, pendingReplicationQueue)
+		require.Equal(
+			t,
+			status.Error(codes.Internal, "Backend A: Failed to find missing sentinel blob: Disk on fire"),
+			blobstore.CheckHealth(ctx, blobAccess))
+	})
+}
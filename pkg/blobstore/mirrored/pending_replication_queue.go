@@ -0,0 +1,174 @@
+package mirrored
+
+import (
+	"bufio"
+	"os"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PendingReplicationQueue keeps track of blobs that MirroredBlobAccess
+// only managed to write into backend A, because backend B was
+// unavailable at the time. These blobs are copied to backend B at a
+// later point in time by calling MirroredBlobAccess.Reconcile().
+type PendingReplicationQueue interface {
+	// Add a blob to the queue. This function fails if the queue
+	// has reached its maximum size.
+	Add(blobDigest digest.Digest) error
+	// Get the set of blobs that are currently in the queue.
+	GetAll() digest.Set
+	// Remove blobs from the queue, after they have been replicated
+	// successfully.
+	Remove(digests digest.Set) error
+}
+
+type pendingReplicationQueue struct {
+	maximumSize         int
+	persistentStatePath string
+
+	lock    sync.Mutex
+	digests map[digest.Digest]struct{}
+	file    *os.File
+}
+
+// NewInMemoryPendingReplicationQueue creates a PendingReplicationQueue
+// that only stores its contents in memory. Pending work is lost when
+// the process is restarted. To bound memory usage, the queue holds at
+// most maximumSize blobs.
+func NewInMemoryPendingReplicationQueue(maximumSize int) PendingReplicationQueue {
+	return &pendingReplicationQueue{
+		maximumSize: maximumSize,
+		digests:     map[digest.Digest]struct{}{},
+	}
+}
+
+// NewPersistentPendingReplicationQueue creates a
+// PendingReplicationQueue that, in addition to storing its contents in
+// memory, writes them to a file. This prevents pending work from being
+// lost when the process is restarted. The file contains one ByteStream
+// read path per line. Additions are appended to the file and
+// synchronized to disk, while the file is rewritten in its entirety
+// when blobs are removed.
+func NewPersistentPendingReplicationQueue(persistentStatePath string, maximumSize int) (PendingReplicationQueue, error) {
+	q := &pendingReplicationQueue{
+		maximumSize:         maximumSize,
+		persistentStatePath: persistentStatePath,
+		digests:             map[digest.Digest]struct{}{},
+	}
+
+	// Load blobs that were queued by a previous invocation.
+	f, err := os.Open(persistentStatePath)
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			blobDigest, err := digest.NewDigestFromByteStreamReadPath(scanner.Text())
+			if err != nil {
+				f.Close()
+				return nil, util.StatusWrapf(err, "Invalid digest in pending replication queue %#v", persistentStatePath)
+			}
+			q.digests[blobDigest] = struct{}{}
+		}
+		err := scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, util.StatusWrapfWithCode(err, codes.Internal, "Failed to read pending replication queue %#v", persistentStatePath)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, util.StatusWrapfWithCode(err, codes.Internal, "Failed to open pending replication queue %#v", persistentStatePath)
+	}
+
+	// Rewrite the file, so that duplicate entries are removed and
+	// the file is opened for appending.
+	if err := q.rewrite(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// rewrite the file backing the queue, so that it only contains the
+// blobs that are currently in the queue. The file is replaced
+// atomically, so that a crash does not cause pending work to be lost.
+func (q *pendingReplicationQueue) rewrite() error {
+	temporaryPath := q.persistentStatePath + ".tmp"
+	f, err := os.OpenFile(temporaryPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return util.StatusWrapfWithCode(err, codes.Internal, "Failed to create pending replication queue %#v", temporaryPath)
+	}
+	w := bufio.NewWriter(f)
+	for blobDigest := range q.digests {
+		w.WriteString(blobDigest.GetByteStreamReadPath())
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return util.StatusWrapfWithCode(err, codes.Internal, "Failed to write pending replication queue %#v", temporaryPath)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return util.StatusWrapfWithCode(err, codes.Internal, "Failed to synchronize pending replication queue %#v", temporaryPath)
+	}
+	if err := os.Rename(temporaryPath, q.persistentStatePath); err != nil {
+		f.Close()
+		return util.StatusWrapfWithCode(err, codes.Internal, "Failed to rename pending replication queue %#v", temporaryPath)
+	}
+
+	// Continue to append to the newly written file.
+	if q.file != nil {
+		q.file.Close()
+	}
+	q.file = f
+	return nil
+}
+
+func (q *pendingReplicationQueue) Add(blobDigest digest.Digest) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if _, ok := q.digests[blobDigest]; ok {
+		return nil
+	}
+	if len(q.digests) >= q.maximumSize {
+		return status.Errorf(codes.ResourceExhausted, "Pending replication queue has reached its maximum size of %d blobs", q.maximumSize)
+	}
+	if q.file != nil {
+		// Synchronize the file, as the caller acknowledges the
+		// write to its client once this function returns.
+		if _, err := q.file.WriteString(blobDigest.GetByteStreamReadPath() + "\n"); err != nil {
+			return util.StatusWrapfWithCode(err, codes.Internal, "Failed to write pending replication queue %#v", q.persistentStatePath)
+		}
+		if err := q.file.Sync(); err != nil {
+			return util.StatusWrapfWithCode(err, codes.Internal, "Failed to synchronize pending replication queue %#v", q.persistentStatePath)
+		}
+	}
+	q.digests[blobDigest] = struct{}{}
+	return nil
+}
+
+func (q *pendingReplicationQueue) GetAll() digest.Set {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	digests := digest.NewSetBuilder()
+	for blobDigest := range q.digests {
+		digests.Add(blobDigest)
+	}
+	return digests.Build()
+}
+
+func (q *pendingReplicationQueue) Remove(digests digest.Set) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, blobDigest := range digests.Items() {
+		delete(q.digests, blobDigest)
+	}
+	if q.file != nil {
+		return q.rewrite()
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package mirrored_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPersistentPendingReplicationQueue(t *testing.T) {
+	directory, err := ioutil.TempDir("", "pending_replication_queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "queue")
+
+	blobDigest1 := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	blobDigest2 := digest.MustNewDigest("world", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	blobDigest3 := digest.MustNewDigest("", "d41d8cd98f00b204e9800998ecf8427e", 0)
+
+	// Blobs added to the queue should be retained after reopening.
+	queue, err := mirrored.NewPersistentPendingReplicationQueue(path, 2)
+	require.NoError(t, err)
+	require.True(t, queue.GetAll().Empty())
+	require.NoError(t, queue.Add(blobDigest1))
+	require.NoError(t, queue.Add(blobDigest2))
+	require.NoError(t, queue.Add(blobDigest2))
+	require.Equal(
+		t,
+		status.Error(codes.ResourceExhausted, "Pending replication queue has reached its maximum size of 2 blobs"),
+		queue.Add(blobDigest3))
+
+	queue, err = mirrored.NewPersistentPendingReplicationQueue(path, 2)
+	require.NoError(t, err)
+	require.Equal(t, digest.NewSetBuilder().Add(blobDigest1).Add(blobDigest2).Build(), queue.GetAll())
+
+	// Removals should also be retained after reopening.
+	require.NoError(t, queue.Remove(blobDigest1.ToSingletonSet()))
+	require.NoError(t, queue.Add(blobDigest3))
+
+	queue, err = mirrored.NewPersistentPendingReplicationQueue(path, 2)
+	require.NoError(t, err)
+	require.Equal(t, digest.NewSetBuilder().Add(blobDigest2).Add(blobDigest3).Build(), queue.GetAll())
+}
@@ -0,0 +1,57 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+type negativeExistenceCachingBlobAccess struct {
+	BlobAccess
+	missingCache *digest.ExistenceCache
+}
+
+// NewNegativeExistenceCachingBlobAccess creates a decorator for
+// BlobAccess that caches which digests were reported as missing by
+// FindMissing(). It is the counterpart of ExistenceCachingBlobAccess,
+// which caches which digests were reported as present. The two may be
+// combined to let a storm of FindMissing() calls with overlapping sets
+// of digests collapse into far fewer calls against the backend.
+//
+// Entries are removed from the cache when the corresponding blob is
+// written through Put(). The cache should use a short expiration time,
+// as blobs may also be written through other paths. It should also use
+// digest.KeyWithInstance, as blobs may be missing for one instance
+// name, while being present for another.
+func NewNegativeExistenceCachingBlobAccess(base BlobAccess, missingCache *digest.ExistenceCache) BlobAccess {
+	return &negativeExistenceCachingBlobAccess{
+		BlobAccess:   base,
+		missingCache: missingCache,
+	}
+}
+
+func (ba *negativeExistenceCachingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if err := ba.BlobAccess.Put(ctx, digest, b); err != nil {
+		return err
+	}
+	ba.missingCache.Remove(digest.ToSingletonSet())
+	return nil
+}
+
+func (ba *negativeExistenceCachingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Determine which digests don't need to be checked, because
+	// they were reported as missing recently.
+	maybePresent := ba.missingCache.RemoveExisting(digests)
+	knownMissing, _, _ := digest.GetDifferenceAndIntersection(digests, maybePresent)
+
+	// Check existence of the remaining digests.
+	missing, err := ba.BlobAccess.FindMissing(ctx, maybePresent)
+	if err != nil {
+		return digest.EmptySet, err
+	}
+
+	// Insert the digests that were missing for future calls.
+	ba.missingCache.Add(missing)
+	return digest.GetUnion([]digest.Set{knownMissing, missing}), nil
+}
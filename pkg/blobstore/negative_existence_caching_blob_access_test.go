@@ -0,0 +1,70 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNegativeExistenceCachingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewNegativeExistenceCachingBlobAccess(
+		baseBlobAccess,
+		digest.NewExistenceCache(clock, digest.KeyWithInstance, 10, time.Minute, eviction.NewLRUSet()))
+
+	existingDigest := digest.MustNewDigest("instance", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+	missingDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+	bothDigests := digest.NewSetBuilder().Add(existingDigest).Add(missingDigest).Build()
+
+	// As the cache is empty upon initialization, the first request
+	// should cause both digests to be queried on the backend.
+	clock.EXPECT().Now().Return(time.Unix(1000, 0)).Times(2)
+	baseBlobAccess.EXPECT().FindMissing(ctx, bothDigests).Return(missingDigest.ToSingletonSet(), nil)
+	missing, err := blobAccess.FindMissing(ctx, bothDigests)
+	require.NoError(t, err)
+	require.Equal(t, missingDigest.ToSingletonSet(), missing)
+
+	// The missing object should be cached for up to a minute,
+	// causing FindMissing() on the backend to only be called with
+	// the existing one.
+	clock.EXPECT().Now().Return(time.Unix(1060, 0)).Times(2)
+	baseBlobAccess.EXPECT().FindMissing(ctx, existingDigest.ToSingletonSet()).Return(digest.EmptySet, nil)
+	missing, err = blobAccess.FindMissing(ctx, bothDigests)
+	require.NoError(t, err)
+	require.Equal(t, missingDigest.ToSingletonSet(), missing)
+
+	// Errors from the backend should be propagated.
+	clock.EXPECT().Now().Return(time.Unix(1060, 0))
+	baseBlobAccess.EXPECT().FindMissing(ctx, existingDigest.ToSingletonSet()).Return(digest.EmptySet, status.Error(codes.Internal, "Server on fire"))
+	_, err = blobAccess.FindMissing(ctx, bothDigests)
+	require.Equal(t, status.Error(codes.Internal, "Server on fire"), err)
+
+	// Uploading the missing object should cause it to be removed
+	// from the cache, causing it to be queried once again.
+	baseBlobAccess.EXPECT().Put(ctx, missingDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+			b.Discard()
+			return nil
+		})
+	require.NoError(t, blobAccess.Put(ctx, missingDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	clock.EXPECT().Now().Return(time.Unix(1060, 0)).Times(2)
+	baseBlobAccess.EXPECT().FindMissing(ctx, bothDigests).Return(digest.EmptySet, nil)
+	missing, err = blobAccess.FindMissing(ctx, bothDigests)
+	require.NoError(t, err)
+	require.Equal(t, digest.EmptySet, missing)
+}
@@ -0,0 +1,87 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	notifyingBlobAccessPrometheusMetrics sync.Once
+
+	notifyingBlobAccessNotificationsDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "notifying_blob_access_notifications_dropped_total",
+			Help:      "Number of notifications of blobs being written that were dropped, due to the queue of notifications being full.",
+		})
+	notifyingBlobAccessNotificationsFailed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "notifying_blob_access_notifications_failed_total",
+			Help:      "Number of notifications of blobs being written that could not be delivered.",
+		})
+)
+
+// PutNotifier is used by NotifyingBlobAccess to announce that a blob
+// has been written. It may be implemented to publish digests to a
+// message queue or pub/sub system (e.g., NATS or Redis), so that
+// downstream caches can be invalidated or warmed.
+type PutNotifier interface {
+	// NotifyPut announces that a blob has been written. The
+	// instance name of the blob is part of the digest.
+	NotifyPut(digest digest.Digest) error
+}
+
+type notifyingBlobAccess struct {
+	BlobAccess
+	digests chan<- digest.Digest
+}
+
+// NewNotifyingBlobAccess creates a decorator for BlobAccess that calls
+// into a PutNotifier for every blob that has been written
+// successfully.
+//
+// Notifications are delivered asynchronously, so that they do not add
+// latency to Put(). Notifications are dropped if more than queueSize
+// notifications are pending. Failures to deliver notifications are
+// reported through the provided ErrorLogger, but do not cause Put() to
+// fail.
+func NewNotifyingBlobAccess(base BlobAccess, notifier PutNotifier, queueSize int, errorLogger util.ErrorLogger) BlobAccess {
+	notifyingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(notifyingBlobAccessNotificationsDropped)
+		prometheus.MustRegister(notifyingBlobAccessNotificationsFailed)
+	})
+
+	digests := make(chan digest.Digest, queueSize)
+	go func() {
+		for digest := range digests {
+			if err := notifier.NotifyPut(digest); err != nil {
+				notifyingBlobAccessNotificationsFailed.Inc()
+				errorLogger.Log(util.StatusWrapf(err, "Failed to send notification for blob %#v", digest.String()))
+			}
+		}
+	}()
+	return &notifyingBlobAccess{
+		BlobAccess: base,
+		digests:    digests,
+	}
+}
+
+func (ba *notifyingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if err := ba.BlobAccess.Put(ctx, digest, b); err != nil {
+		return err
+	}
+	select {
+	case ba.digests <- digest:
+	default:
+		notifyingBlobAccessNotificationsDropped.Inc()
+	}
+	return nil
+}
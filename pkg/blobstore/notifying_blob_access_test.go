@@ -0,0 +1,77 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNotifyingBlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	putNotifier := mock.NewMockPutNotifier(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	blobAccess := blobstore.NewNotifyingBlobAccess(baseBlobAccess, putNotifier, 10, errorLogger)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Success", func(t *testing.T) {
+		// Notifications are sent asynchronously. Wait for it
+		// to arrive.
+		notified := make(chan struct{})
+		baseBlobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		putNotifier.EXPECT().NotifyPut(helloDigest).DoAndReturn(func(digest digest.Digest) error {
+			close(notified)
+			return nil
+		})
+
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+		<-notified
+	})
+
+	t.Run("PutFailure", func(t *testing.T) {
+		// Blobs that could not be written should not be
+		// announced.
+		baseBlobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.Unavailable, "Server not reachable")
+			})
+
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Server not reachable"),
+			blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("NotificationFailure", func(t *testing.T) {
+		// Failures to deliver notifications should not cause
+		// Put() to fail. They should be reported through the
+		// ErrorLogger instead.
+		logged := make(chan struct{})
+		baseBlobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		putNotifier.EXPECT().NotifyPut(helloDigest).Return(status.Error(codes.Unavailable, "Message queue not reachable"))
+		errorLogger.EXPECT().Log(status.Error(codes.Unavailable, "Failed to send notification for blob \"8b1a9953c4611296a827abf8c47804d7-5-hello\": Message queue not reachable")).
+			Do(func(err error) { close(logged) })
+
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+		<-logged
+	})
+}
@@ -0,0 +1,86 @@
+package blobstore
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// BlobRepairer is called by storage backends after detecting that a
+// blob they store is corrupted. It may attempt to restore the blob by
+// writing a valid copy of it into the provided BlobAccess, so that
+// successive reads succeed.
+//
+// Storage backends call the BlobRepairer without holding any locks,
+// meaning implementations may write into the provided BlobAccess
+// synchronously. As they are called while the error is being returned
+// to the caller, long running repairs should be performed in the
+// background, as done by NewPeerBlobRepairer().
+type BlobRepairer func(blobDigest digest.Digest, destination BlobAccess)
+
+type peerBlobRepairer struct {
+	peer         BlobAccess
+	clock        clock.Clock
+	maximumDelay time.Duration
+	timeout      time.Duration
+	errorLogger  util.ErrorLogger
+
+	lock     sync.Mutex
+	inFlight map[digest.Digest]struct{}
+}
+
+// NewPeerBlobRepairer creates a BlobRepairer that repairs corrupted
+// blobs by fetching a copy from a peer in a replicated deployment.
+// Repairs are performed in the background on a best-effort basis.
+//
+// To prevent replicas that observe the same corruption from all
+// contacting the peer at the same time, repairs are started after a
+// random delay of at most maximumDelay. Concurrent attempts to repair
+// the same blob are coalesced. Every repair is bounded by a timeout,
+// so that a peer that stops responding cannot cause repairs to remain
+// in flight indefinitely.
+func NewPeerBlobRepairer(peer BlobAccess, clock clock.Clock, maximumDelay time.Duration, timeout time.Duration, errorLogger util.ErrorLogger) BlobRepairer {
+	br := &peerBlobRepairer{
+		peer:         peer,
+		clock:        clock,
+		maximumDelay: maximumDelay,
+		timeout:      timeout,
+		errorLogger:  errorLogger,
+		inFlight:     map[digest.Digest]struct{}{},
+	}
+	return br.repair
+}
+
+func (br *peerBlobRepairer) repair(blobDigest digest.Digest, destination BlobAccess) {
+	br.lock.Lock()
+	if _, ok := br.inFlight[blobDigest]; ok {
+		br.lock.Unlock()
+		return
+	}
+	br.inFlight[blobDigest] = struct{}{}
+	br.lock.Unlock()
+
+	go func() {
+		defer func() {
+			br.lock.Lock()
+			delete(br.inFlight, blobDigest)
+			br.lock.Unlock()
+		}()
+
+		if br.maximumDelay > 0 {
+			_, t := br.clock.NewTimer(time.Duration(rand.Int63n(int64(br.maximumDelay))))
+			<-t
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), br.timeout)
+		defer cancel()
+		if err := destination.Put(ctx, blobDigest, br.peer.Get(ctx, blobDigest)); err != nil {
+			br.errorLogger.Log(util.StatusWrapf(err, "Failed to repair blob %#v from peer", blobDigest.String()))
+		}
+	}()
+}
@@ -0,0 +1,86 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPeerBlobRepairer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	peer := mock.NewMockBlobAccess(ctrl)
+	destination := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	repairer := blobstore.NewPeerBlobRepairer(peer, clock, time.Minute, time.Minute, errorLogger)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Success", func(t *testing.T) {
+		// The blob should be fetched from the peer after a
+		// random delay, and written into the destination.
+		timer := mock.NewMockTimer(ctrl)
+		timerChannel := make(chan time.Time, 1)
+		clock.EXPECT().NewTimer(gomock.Any()).DoAndReturn(func(d time.Duration) (*mock.MockTimer, <-chan time.Time) {
+			require.True(t, d >= 0 && d < time.Minute)
+			return timer, timerChannel
+		})
+		peer.EXPECT().Get(gomock.Any(), helloDigest).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+				// Repairs should not be able to block
+				// indefinitely.
+				_, ok := ctx.Deadline()
+				require.True(t, ok)
+				return buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))
+			})
+		done := make(chan struct{})
+		destination.EXPECT().Put(gomock.Any(), helloDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(10)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				close(done)
+				return nil
+			})
+
+		// Repeated attempts to repair the same blob while a
+		// repair is in progress should be coalesced.
+		repairer(helloDigest, destination)
+		repairer(helloDigest, destination)
+		timerChannel <- time.Unix(1000, 0)
+		<-done
+	})
+
+	t.Run("PeerFailure", func(t *testing.T) {
+		// Failures should only be logged, as there is nobody
+		// to return them to.
+		timerChannel := make(chan time.Time, 1)
+		timerChannel <- time.Unix(1000, 0)
+		clock.EXPECT().NewTimer(gomock.Any()).Return(mock.NewMockTimer(ctrl), timerChannel)
+		peer.EXPECT().Get(gomock.Any(), helloDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
+		destination.EXPECT().Put(gomock.Any(), helloDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				_, err := b.ToByteSlice(10)
+				return err
+			})
+		done := make(chan struct{})
+		errorLogger.EXPECT().Log(status.Error(codes.NotFound, "Failed to repair blob \"8b1a9953c4611296a827abf8c47804d7-5-hello\" from peer: Blob not found")).Do(
+			func(err error) { close(done) })
+
+		// Use a separate repairer, as the repair started by the
+		// previous test may not have been fully completed yet.
+		repairer := blobstore.NewPeerBlobRepairer(peer, clock, time.Minute, time.Minute, errorLogger)
+		repairer(helloDigest, destination)
+		<-done
+	})
+}
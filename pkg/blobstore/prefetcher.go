@@ -0,0 +1,31 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+// Prefetcher is an optional capability of BlobAccess implementations
+// that are able to warm caches ahead of time. Clients that know which
+// blobs they are going to read in the near future (e.g., the input
+// root of an action that is about to be executed) may announce this
+// through Prefetch(), so that these blobs may already be copied into
+// a fast storage tier.
+type Prefetcher interface {
+	// Prefetch provides a hint that a set of blobs is going to be
+	// read soon. Prefetching is performed on a best-effort basis.
+	// Implementations should not block until blobs have been
+	// copied, and may ignore hints entirely (e.g., when overloaded).
+	Prefetch(ctx context.Context, digests digest.Set)
+}
+
+// Prefetch provides a hint to a storage backend that a set of blobs is
+// going to be read soon. If the BlobAccess implements Prefetcher, the
+// request is forwarded to Prefetch(). Otherwise, this function is a
+// no-op.
+func Prefetch(ctx context.Context, blobAccess BlobAccess, digests digest.Set) {
+	if prefetcher, ok := blobAccess.(Prefetcher); ok {
+		prefetcher.Prefetch(ctx, digests)
+	}
+}
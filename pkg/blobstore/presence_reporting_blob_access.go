@@ -0,0 +1,47 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+// PresenceReportingBlobAccess is an extension of BlobAccess,
+// implemented by backends that determine for every blob whether it is
+// present or missing as part of checking for existence (e.g., by
+// performing a lookup per digest). Such backends can return the set of
+// present blobs directly, as opposed to letting callers compute it by
+// taking the difference between the input and the missing blobs.
+//
+// Backends that can only cheaply compute the set of missing blobs
+// (e.g., because a remote service only returns missing digests)
+// should not implement this interface. FindMissingAndPresent() will
+// then derive the set of present blobs for them.
+type PresenceReportingBlobAccess interface {
+	BlobAccess
+
+	// FindMissingAndPresent partitions a set of digests into the
+	// digests of blobs that are missing and the digests of blobs
+	// that are present. The union of both resulting sets is equal
+	// to the input set, and the sets are disjoint.
+	FindMissingAndPresent(ctx context.Context, digests digest.Set) (missing, present digest.Set, err error)
+}
+
+// FindMissingAndPresent partitions a set of digests into the digests
+// of blobs that are missing and the digests of blobs that are present,
+// using the semantics of PresenceReportingBlobAccess. If the
+// BlobAccess implements PresenceReportingBlobAccess, the request is
+// forwarded. Otherwise, FindMissing() is called and the set of present
+// blobs is derived by removing the missing blobs from the input set.
+func FindMissingAndPresent(ctx context.Context, blobAccess BlobAccess, digests digest.Set) (missing, present digest.Set, err error) {
+	if presenceReportingBlobAccess, ok := blobAccess.(PresenceReportingBlobAccess); ok {
+		return presenceReportingBlobAccess.FindMissingAndPresent(ctx, digests)
+	}
+
+	missing, err = blobAccess.FindMissing(ctx, digests)
+	if err != nil {
+		return digest.EmptySet, digest.EmptySet, err
+	}
+	present, _, _ = digest.GetDifferenceAndIntersection(digests, missing)
+	return missing, present, nil
+}
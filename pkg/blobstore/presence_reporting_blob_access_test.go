@@ -0,0 +1,59 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFindMissingAndPresent(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	digest1 := digest.MustNewDigest("hello", "00000000000000000000000000000001", 1)
+	digest2 := digest.MustNewDigest("hello", "00000000000000000000000000000002", 2)
+	digest3 := digest.MustNewDigest("hello", "00000000000000000000000000000003", 3)
+	allDigests := digest.NewSetBuilder().Add(digest1).Add(digest2).Add(digest3).Build()
+
+	t.Run("Forwarded", func(t *testing.T) {
+		// Backends that implement PresenceReportingBlobAccess
+		// should have their results returned as is.
+		blobAccess := mock.NewMockPresenceReportingBlobAccess(ctrl)
+		blobAccess.EXPECT().FindMissingAndPresent(ctx, allDigests).Return(
+			digest2.ToSingletonSet(),
+			digest.NewSetBuilder().Add(digest1).Add(digest3).Build(),
+			nil)
+
+		missing, present, err := blobstore.FindMissingAndPresent(ctx, blobAccess, allDigests)
+		require.NoError(t, err)
+		require.Equal(t, digest2.ToSingletonSet(), missing)
+		require.Equal(t, digest.NewSetBuilder().Add(digest1).Add(digest3).Build(), present)
+	})
+
+	t.Run("Derived", func(t *testing.T) {
+		// For other backends, the set of present blobs should
+		// be derived from the results of FindMissing().
+		blobAccess := mock.NewMockBlobAccess(ctrl)
+		blobAccess.EXPECT().FindMissing(ctx, allDigests).Return(digest2.ToSingletonSet(), nil)
+
+		missing, present, err := blobstore.FindMissingAndPresent(ctx, blobAccess, allDigests)
+		require.NoError(t, err)
+		require.Equal(t, digest2.ToSingletonSet(), missing)
+		require.Equal(t, digest.NewSetBuilder().Add(digest1).Add(digest3).Build(), present)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		blobAccess := mock.NewMockBlobAccess(ctrl)
+		blobAccess.EXPECT().FindMissing(ctx, allDigests).Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline"))
+
+		_, _, err := blobstore.FindMissingAndPresent(ctx, blobAccess, allDigests)
+		require.Equal(t, status.Error(codes.Unavailable, "Server offline"), err)
+	})
+}
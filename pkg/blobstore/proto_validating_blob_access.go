@@ -0,0 +1,58 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+)
+
+// ProtoClassifier is the callback type used by ProtoValidatingBlobAccess
+// to determine whether a blob is expected to contain a Protobuf
+// message. If so, it returns an empty message of the expected type.
+// For blobs that may contain arbitrary data, it returns nil.
+type ProtoClassifier func(blobDigest digest.Digest) proto.Message
+
+type protoValidatingBlobAccess struct {
+	BlobAccess
+	classifier              ProtoClassifier
+	maximumMessageSizeBytes int
+}
+
+// NewProtoValidatingBlobAccess creates a decorator for BlobAccess that
+// rejects attempts to store blobs that are expected to contain a
+// Protobuf message (e.g., ActionResult, Command or Directory), but
+// cannot be parsed as such. This prevents malformed messages from
+// entering storage. Which blobs are expected to contain messages is
+// determined by a ProtoClassifier, as this depends on the deployment.
+//
+// Blobs that are classified need to be loaded into memory to be
+// validated. Their size is therefore limited to the provided maximum
+// message size. Blobs that are not classified are forwarded as is.
+func NewProtoValidatingBlobAccess(base BlobAccess, classifier ProtoClassifier, maximumMessageSizeBytes int) BlobAccess {
+	return &protoValidatingBlobAccess{
+		BlobAccess:              base,
+		classifier:              classifier,
+		maximumMessageSizeBytes: maximumMessageSizeBytes,
+	}
+}
+
+func (ba *protoValidatingBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	message := ba.classifier(blobDigest)
+	if message == nil {
+		return ba.BlobAccess.Put(ctx, blobDigest, b)
+	}
+
+	data, err := b.ToByteSlice(ba.maximumMessageSizeBytes)
+	if err != nil {
+		return err
+	}
+	if err := proto.Unmarshal(data, message); err != nil {
+		return util.StatusWrapfWithCode(err, codes.InvalidArgument, "Blob is not a valid %s message", proto.MessageName(message))
+	}
+	return ba.BlobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(data))
+}
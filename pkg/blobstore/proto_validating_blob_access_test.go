@@ -0,0 +1,76 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestProtoValidatingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	// Treat blobs stored under instance name "commands" as Command
+	// messages.
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewProtoValidatingBlobAccess(
+		baseBlobAccess,
+		func(blobDigest digest.Digest) proto.Message {
+			if blobDigest.GetInstanceName().String() == "commands" {
+				return &remoteexecution.Command{}
+			}
+			return nil
+		},
+		1000)
+
+	t.Run("Unclassified", func(t *testing.T) {
+		// Blobs that are not classified should be forwarded
+		// without being inspected.
+		blobDigest := digest.MustNewDigest("files", "8b1a9953c4611296a827abf8c47804d7", 5)
+		b := buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, b).Return(nil)
+
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, b))
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		data, err := proto.Marshal(&remoteexecution.Command{
+			Arguments: []string{"cc", "-o", "hello.o", "hello.c"},
+		})
+		require.NoError(t, err)
+		blobDigest := digest.MustNewDigest("commands", "8b1a9953c4611296a827abf8c47804d7", int64(len(data)))
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				storedData, err := b.ToByteSlice(1000)
+				require.NoError(t, err)
+				require.Equal(t, data, storedData)
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(data)))
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		// Data that cannot be parsed should be rejected without
+		// being forwarded to the backend.
+		blobDigest := digest.MustNewDigest("commands", "8b1a9953c4611296a827abf8c47804d7", 5)
+		err := blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("\x0a\x05Hel")))
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		blobDigest := digest.MustNewDigest("commands", "8b1a9953c4611296a827abf8c47804d7", 2000)
+		err := blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(make([]byte, 2000)))
+		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is 2000 bytes in size, while a maximum of 1000 bytes is permitted"), err)
+	})
+}
@@ -0,0 +1,35 @@
+package blobstore
+
+import (
+	"context"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+// PutAndGetDigest stores the contents of a buffer in a BlobAccess,
+// using a digest that is computed using the provided digest function.
+// The resulting digest is returned. This can be used to ingest data
+// whose digest is not known in advance.
+//
+// As Put() requires the digest to be known up front, the data is read
+// in its entirety to compute its digest before calling Put(). Contents
+// of up to maximumMemorySizeBytes in size are kept in memory, while
+// larger contents are written to files obtained through
+// spillFileFactory.
+//
+// If the size of the buffer is known, the data read from it must match
+// this size exactly. Otherwise, INVALID_ARGUMENT is returned and the
+// data is not stored.
+func PutAndGetDigest(ctx context.Context, blobAccess BlobAccess, instanceName digest.InstanceName, digestFunction remoteexecution.DigestFunction_Value, b buffer.Buffer, maximumMemorySizeBytes int, spillFileFactory buffer.SpillFileFactory) (digest.Digest, error) {
+	blobDigest, b := buffer.WithComputedDigest(b, instanceName, digestFunction, maximumMemorySizeBytes, spillFileFactory)
+	if _, err := b.GetSizeBytes(); err != nil {
+		b.Discard()
+		return digest.BadDigest, err
+	}
+	if err := blobAccess.Put(ctx, blobDigest, b); err != nil {
+		return digest.BadDigest, err
+	}
+	return blobDigest, nil
+}
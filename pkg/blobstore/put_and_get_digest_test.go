@@ -0,0 +1,61 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPutAndGetDigest(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Success", func(t *testing.T) {
+		// The blob should be stored under the digest that is
+		// computed while reading it.
+		blobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				return nil
+			})
+
+		blobDigest, err := blobstore.PutAndGetDigest(
+			ctx,
+			blobAccess,
+			digest.MustNewInstanceName("hello"),
+			remoteexecution.DigestFunction_MD5,
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+			100,
+			nil)
+		require.NoError(t, err)
+		require.Equal(t, helloDigest, blobDigest)
+	})
+
+	t.Run("ReadFailure", func(t *testing.T) {
+		// Put() should not be called if the contents of the
+		// buffer cannot be read.
+		_, err := blobstore.PutAndGetDigest(
+			ctx,
+			blobAccess,
+			digest.MustNewInstanceName("hello"),
+			remoteexecution.DigestFunction_MD5,
+			buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")),
+			100,
+			nil)
+		require.Equal(t, status.Error(codes.Unavailable, "Server offline"), err)
+	})
+}
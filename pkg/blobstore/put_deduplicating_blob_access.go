@@ -0,0 +1,85 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// putCall keeps track of a call to Put() against the backend, whose
+// outcome may be shared by multiple callers.
+type putCall struct {
+	done chan struct{}
+	err  error
+}
+
+type putDeduplicatingBlobAccess struct {
+	BlobAccess
+
+	lock  sync.Mutex
+	calls map[digest.Digest]*putCall
+}
+
+// NewPutDeduplicatingBlobAccess creates a decorator for BlobAccess that
+// collapses concurrent calls to Put() for the same digest into a
+// single call against the backend. Callers that wait for another call
+// to complete discard the buffer they provided. This prevents
+// redundant writes in case many clients upload the same blob at the
+// same time (e.g., files that are part of a shared toolchain).
+//
+// Only successful calls are shared. If the call against the backend
+// fails, waiting callers retry the operation using their own buffer.
+// This ensures that a single client providing invalid data cannot
+// cause uploads of other clients to fail.
+//
+// This decorator may only be used for the Content Addressable Storage,
+// where concurrent calls for the same digest are guaranteed to carry
+// the same data. Concurrent writes to the Action Cache may carry
+// different ActionResult messages, of which all but one would be
+// dropped.
+func NewPutDeduplicatingBlobAccess(base BlobAccess) BlobAccess {
+	return &putDeduplicatingBlobAccess{
+		BlobAccess: base,
+		calls:      map[digest.Digest]*putCall{},
+	}
+}
+
+func (ba *putDeduplicatingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	for {
+		ba.lock.Lock()
+		if call, ok := ba.calls[digest]; ok {
+			// An identical call is already in flight. Wait
+			// for it to complete.
+			ba.lock.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				b.Discard()
+				return util.StatusFromContext(ctx)
+			}
+			if call.err == nil {
+				b.Discard()
+				return nil
+			}
+			continue
+		}
+
+		// Perform the call against the backend.
+		call := &putCall{
+			done: make(chan struct{}),
+		}
+		ba.calls[digest] = call
+		ba.lock.Unlock()
+
+		call.err = ba.BlobAccess.Put(ctx, digest, b)
+
+		ba.lock.Lock()
+		delete(ba.calls, digest)
+		ba.lock.Unlock()
+		close(call.done)
+		return call.err
+	}
+}
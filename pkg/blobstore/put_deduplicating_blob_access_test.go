@@ -0,0 +1,123 @@
+package blobstore_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// waitNotifyingContext is a context that reports when a caller starts
+// waiting for its completion. It is used to determine when a call to
+// Put() has started waiting for another call.
+type waitNotifyingContext struct {
+	context.Context
+	once    sync.Once
+	waiting chan struct{}
+}
+
+func newWaitNotifyingContext(ctx context.Context) *waitNotifyingContext {
+	return &waitNotifyingContext{
+		Context: ctx,
+		waiting: make(chan struct{}),
+	}
+}
+
+func (ctx *waitNotifyingContext) Done() <-chan struct{} {
+	ctx.once.Do(func() { close(ctx.waiting) })
+	return ctx.Context.Done()
+}
+
+func TestPutDeduplicatingBlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewPutDeduplicatingBlobAccess(baseBlobAccess)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Failure", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).
+			Return(status.Error(codes.Internal, "Server on fire"))
+
+		require.Equal(
+			t,
+			status.Error(codes.Internal, "Server on fire"),
+			blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("ConcurrentSuccess", func(t *testing.T) {
+		// While a call is in flight, calls for the same digest
+		// should wait for it to complete. If the call
+		// succeeds, its result should be shared.
+		done := make(chan error, 1)
+		baseBlobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				followerCtx := newWaitNotifyingContext(ctx)
+				go func() {
+					done <- blobAccess.Put(followerCtx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+				}()
+				<-followerCtx.waiting
+				b.Discard()
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+		require.NoError(t, <-done)
+	})
+
+	t.Run("ConcurrentFailure", func(t *testing.T) {
+		// If the call fails, callers that were waiting should
+		// not report success. They should retry the operation
+		// using their own buffer.
+		done := make(chan error, 1)
+		baseBlobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				followerCtx := newWaitNotifyingContext(ctx)
+				go func() {
+					done <- blobAccess.Put(followerCtx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+				}()
+				<-followerCtx.waiting
+				b.Discard()
+				return buffer.MarkDataIntegrityError(status.Error(codes.InvalidArgument, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected"))
+			})
+		baseBlobAccess.EXPECT().Put(gomock.Any(), helloDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				return nil
+			})
+
+		require.Equal(
+			t,
+			buffer.MarkDataIntegrityError(status.Error(codes.InvalidArgument, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected")),
+			blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hallo"))))
+		require.NoError(t, <-done)
+	})
+
+	t.Run("ConcurrentCanceled", func(t *testing.T) {
+		// Waiting should respect cancelation.
+		baseBlobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				waiterCtx, cancel := context.WithCancel(ctx)
+				cancel()
+				require.Equal(
+					t,
+					status.Error(codes.Canceled, "context canceled"),
+					blobAccess.Put(waiterCtx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+				b.Discard()
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+}
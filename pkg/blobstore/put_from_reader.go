@@ -0,0 +1,29 @@
+package blobstore
+
+import (
+	"context"
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PutFromReader stores the contents of a reader in the Content
+// Addressable Storage. This can be used to ingest data from sources
+// that are plain readers (e.g., HTTP response bodies or files) without
+// needing to construct a buffer manually.
+//
+// The size of the data must match the size of the digest, and the data
+// must match the digest's hash. Otherwise, the data is rejected with
+// INVALID_ARGUMENT. The reader is always closed, even if the call
+// fails before any data is read.
+func PutFromReader(ctx context.Context, blobAccess BlobAccess, blobDigest digest.Digest, r io.ReadCloser, sizeBytes int64) error {
+	if digestSizeBytes := blobDigest.GetSizeBytes(); sizeBytes != digestSizeBytes {
+		r.Close()
+		return status.Errorf(codes.InvalidArgument, "Data is %d bytes in size, while the digest has size %d bytes", sizeBytes, digestSizeBytes)
+	}
+	return blobAccess.Put(ctx, blobDigest, buffer.NewCASBufferFromReader(blobDigest, r, buffer.UserProvided))
+}
@@ -0,0 +1,55 @@
+package blobstore_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPutFromReader(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("SizeMismatch", func(t *testing.T) {
+		// The reader should be closed, even though the request
+		// is rejected before reading any data.
+		r := mock.NewMockReadCloser(ctrl)
+		r.EXPECT().Close()
+
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Data is 6 bytes in size, while the digest has size 5 bytes"),
+			blobstore.PutFromReader(ctx, blobAccess, helloDigest, r, 6))
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		r := mock.NewMockReadCloser(ctrl)
+		gomock.InOrder(
+			r.EXPECT().Read(gomock.Any()).DoAndReturn(func(p []byte) (int, error) {
+				return copy(p, "Hello"), nil
+			}),
+			r.EXPECT().Read(gomock.Any()).Return(0, io.EOF).AnyTimes())
+		r.EXPECT().Close()
+		blobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(10)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				return nil
+			})
+
+		require.NoError(t, blobstore.PutFromReader(ctx, blobAccess, helloDigest, r, 5))
+	})
+}
@@ -0,0 +1,67 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	putSkippingBlobAccessPrometheusMetrics sync.Once
+
+	putSkippingBlobAccessPuts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "put_skipping_blob_access_puts_total",
+			Help:      "Number of calls to Put(), and whether they were skipped due to the blob already being present.",
+		},
+		[]string{"outcome"})
+	putSkippingBlobAccessPutsSkipped = putSkippingBlobAccessPuts.WithLabelValues("Skipped")
+	putSkippingBlobAccessPutsWritten = putSkippingBlobAccessPuts.WithLabelValues("Written")
+)
+
+type putSkippingBlobAccess struct {
+	BlobAccess
+}
+
+// NewPutSkippingBlobAccess creates a decorator for BlobAccess that
+// calls FindMissing() for the blob to be written before calling Put().
+// If the blob is already present, the buffer is discarded and the
+// write is skipped.
+//
+// Clients like Bazel already call FindMissingBlobs() prior to
+// uploading, meaning this decorator is not needed for them. It is
+// intended for tools that upload blobs unconditionally. As the
+// existence check adds a round trip for blobs that are absent, it only
+// pays off if uploads of blobs that are already present are common.
+//
+// Failures to check for existence are ignored, as the blob is written
+// in that case.
+//
+// This decorator may only be used for the Content Addressable Storage.
+// FindMissing() reports keys in the Action Cache as present if any
+// entry is stored, meaning that updated ActionResult messages would be
+// dropped.
+func NewPutSkippingBlobAccess(base BlobAccess) BlobAccess {
+	putSkippingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(putSkippingBlobAccessPuts)
+	})
+
+	return &putSkippingBlobAccess{
+		BlobAccess: base,
+	}
+}
+
+func (ba *putSkippingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if missing, err := ba.BlobAccess.FindMissing(ctx, digest.ToSingletonSet()); err == nil && missing.Empty() {
+		b.Discard()
+		putSkippingBlobAccessPutsSkipped.Inc()
+		return nil
+	}
+	putSkippingBlobAccessPutsWritten.Inc()
+	return ba.BlobAccess.Put(ctx, digest, b)
+}
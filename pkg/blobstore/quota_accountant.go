@@ -0,0 +1,118 @@
+package blobstore
+
+import (
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	quotaAccountantPrometheusMetrics sync.Once
+
+	quotaAccountantUsageBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "quota_accountant_usage_bytes",
+			Help:      "Amount of storage space accounted to an instance name, in bytes.",
+		},
+		[]string{"instance_name"})
+)
+
+// QuotaAccountant keeps track of the amount of storage space used per
+// instance name. It is used by QuotaBlobAccess to enforce quotas.
+//
+// Storage backends may discard data without notice (e.g., the
+// circular storage backend overwriting old data). The usage tracked
+// by a QuotaAccountant is therefore only an approximation. Through
+// SetUsageBytes() it may be reconciled against the actual usage.
+type QuotaAccountant interface {
+	// Reserve storage space for an instance name, but only if
+	// doing so does not cause its usage to exceed the provided
+	// quota. The resulting usage is returned.
+	Reserve(instanceName digest.InstanceName, sizeBytes int64, quotaBytes int64) (int64, bool)
+	// Release storage space that was previously reserved.
+	Release(instanceName digest.InstanceName, sizeBytes int64)
+	// Overwrite the usage of an instance name.
+	SetUsageBytes(instanceName digest.InstanceName, usageBytes int64)
+}
+
+// quotaAccountantOtherInstanceNames is the label value under which the
+// usage of instance names that are not reported individually is
+// exposed. Parentheses are used to prevent collisions with actual
+// instance names.
+const quotaAccountantOtherInstanceNames = "(other)"
+
+type inMemoryQuotaAccountant struct {
+	reportedInstanceNames map[digest.InstanceName]struct{}
+
+	lock            sync.Mutex
+	usageBytes      map[digest.InstanceName]int64
+	otherUsageBytes int64
+}
+
+// NewInMemoryQuotaAccountant creates a QuotaAccountant that keeps track
+// of usage in memory. Usage is exposed as a Prometheus metric.
+//
+// As instance names are provided by clients, the number of distinct
+// instance names is unbounded. To prevent the number of Prometheus
+// time series from growing without limit, only the usage of the
+// provided instance names is reported individually. The usage of all
+// other instance names is summed up and reported with label value
+// "(other)".
+func NewInMemoryQuotaAccountant(reportedInstanceNames []digest.InstanceName) QuotaAccountant {
+	quotaAccountantPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(quotaAccountantUsageBytes)
+	})
+
+	qa := &inMemoryQuotaAccountant{
+		reportedInstanceNames: map[digest.InstanceName]struct{}{},
+		usageBytes:            map[digest.InstanceName]int64{},
+	}
+	for _, instanceName := range reportedInstanceNames {
+		qa.reportedInstanceNames[instanceName] = struct{}{}
+	}
+	return qa
+}
+
+func (qa *inMemoryQuotaAccountant) setUsageBytesLocked(instanceName digest.InstanceName, usageBytes int64) {
+	if _, ok := qa.reportedInstanceNames[instanceName]; ok {
+		quotaAccountantUsageBytes.WithLabelValues(instanceName.String()).Set(float64(usageBytes))
+	} else {
+		qa.otherUsageBytes += usageBytes - qa.usageBytes[instanceName]
+		quotaAccountantUsageBytes.WithLabelValues(quotaAccountantOtherInstanceNames).Set(float64(qa.otherUsageBytes))
+	}
+	qa.usageBytes[instanceName] = usageBytes
+}
+
+func (qa *inMemoryQuotaAccountant) Reserve(instanceName digest.InstanceName, sizeBytes int64, quotaBytes int64) (int64, bool) {
+	qa.lock.Lock()
+	defer qa.lock.Unlock()
+
+	usageBytes := qa.usageBytes[instanceName]
+	if sizeBytes > quotaBytes-usageBytes {
+		return usageBytes, false
+	}
+	usageBytes += sizeBytes
+	qa.setUsageBytesLocked(instanceName, usageBytes)
+	return usageBytes, true
+}
+
+func (qa *inMemoryQuotaAccountant) Release(instanceName digest.InstanceName, sizeBytes int64) {
+	qa.lock.Lock()
+	defer qa.lock.Unlock()
+
+	usageBytes := qa.usageBytes[instanceName] - sizeBytes
+	if usageBytes < 0 {
+		usageBytes = 0
+	}
+	qa.setUsageBytesLocked(instanceName, usageBytes)
+}
+
+func (qa *inMemoryQuotaAccountant) SetUsageBytes(instanceName digest.InstanceName, usageBytes int64) {
+	qa.lock.Lock()
+	defer qa.lock.Unlock()
+
+	qa.setUsageBytesLocked(instanceName, usageBytes)
+}
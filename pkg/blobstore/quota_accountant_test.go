@@ -0,0 +1,37 @@
+package blobstore_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryQuotaAccountant(t *testing.T) {
+	accountant := blobstore.NewInMemoryQuotaAccountant([]digest.InstanceName{digest.MustNewInstanceName("reported")})
+
+	// Instance names that are not reported individually should
+	// still have their usage tracked separately, as their usage
+	// is only aggregated for the purpose of metrics.
+	usageBytes, ok := accountant.Reserve(digest.MustNewInstanceName("a"), 5, 8)
+	require.True(t, ok)
+	require.Equal(t, int64(5), usageBytes)
+
+	usageBytes, ok = accountant.Reserve(digest.MustNewInstanceName("b"), 5, 8)
+	require.True(t, ok)
+	require.Equal(t, int64(5), usageBytes)
+
+	usageBytes, ok = accountant.Reserve(digest.MustNewInstanceName("a"), 5, 8)
+	require.False(t, ok)
+	require.Equal(t, int64(5), usageBytes)
+
+	accountant.Release(digest.MustNewInstanceName("a"), 5)
+	usageBytes, ok = accountant.Reserve(digest.MustNewInstanceName("a"), 8, 8)
+	require.True(t, ok)
+	require.Equal(t, int64(8), usageBytes)
+
+	usageBytes, ok = accountant.Reserve(digest.MustNewInstanceName("reported"), 8, 8)
+	require.True(t, ok)
+	require.Equal(t, int64(8), usageBytes)
+}
@@ -0,0 +1,59 @@
+package blobstore
+
+import (
+	"context"
+	"math"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// QuotaGetter is the callback type used by QuotaBlobAccess to obtain
+// the maximum number of bytes that may be stored for an instance name.
+// If no quota is returned, the amount of storage space is unlimited.
+type QuotaGetter func(instanceName digest.InstanceName) (int64, bool)
+
+type quotaBlobAccess struct {
+	BlobAccess
+	quotaGetter QuotaGetter
+	accountant  QuotaAccountant
+}
+
+// NewQuotaBlobAccess creates a decorator for BlobAccess that places a
+// limit on the number of bytes that may be stored per instance name.
+// This can be used to ensure fairness between tenants sharing the
+// same storage backend.
+//
+// Usage is tracked by a QuotaAccountant, which is incremented on every
+// successful call to Put(). As this decorator has no insight in blobs
+// being stored repeatedly or discarded by the backend, the accountant
+// needs to be reconciled against actual usage separately.
+func NewQuotaBlobAccess(base BlobAccess, quotaGetter QuotaGetter, accountant QuotaAccountant) BlobAccess {
+	return &quotaBlobAccess{
+		BlobAccess:  base,
+		quotaGetter: quotaGetter,
+		accountant:  accountant,
+	}
+}
+
+func (ba *quotaBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	instanceName := digest.GetInstanceName()
+	quotaBytes, ok := ba.quotaGetter(instanceName)
+	if !ok {
+		quotaBytes = math.MaxInt64
+	}
+	sizeBytes := digest.GetSizeBytes()
+	if usageBytes, ok := ba.accountant.Reserve(instanceName, sizeBytes, quotaBytes); !ok {
+		b.Discard()
+		return status.Errorf(codes.ResourceExhausted, "Storing a blob of %d bytes would cause instance name %#v to exceed its quota of %d bytes, as it already uses %d bytes", sizeBytes, instanceName.String(), quotaBytes, usageBytes)
+	}
+
+	if err := ba.BlobAccess.Put(ctx, digest, b); err != nil {
+		ba.accountant.Release(instanceName, sizeBytes)
+		return err
+	}
+	return nil
+}
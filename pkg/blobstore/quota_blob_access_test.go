@@ -0,0 +1,85 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestQuotaBlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	accountant := blobstore.NewInMemoryQuotaAccountant([]digest.InstanceName{digest.MustNewInstanceName("limited")})
+	blobAccess := blobstore.NewQuotaBlobAccess(
+		baseBlobAccess,
+		func(instanceName digest.InstanceName) (int64, bool) {
+			if instanceName == digest.MustNewInstanceName("limited") {
+				return 8, true
+			}
+			return 0, false
+		},
+		accountant)
+	helloDigest := digest.MustNewDigest("limited", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Success", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("QuotaExceeded", func(t *testing.T) {
+		// Storing the blob a second time would exceed the quota.
+		require.Equal(
+			t,
+			status.Error(codes.ResourceExhausted, "Storing a blob of 5 bytes would cause instance name \"limited\" to exceed its quota of 8 bytes, as it already uses 5 bytes"),
+			blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("BackendFailure", func(t *testing.T) {
+		// Failed writes should not count towards the quota.
+		accountant.SetUsageBytes(digest.MustNewInstanceName("limited"), 0)
+		baseBlobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.Internal, "Server on fire")
+			})
+
+		require.Equal(
+			t,
+			status.Error(codes.Internal, "Server on fire"),
+			blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+		usageBytes, ok := accountant.Reserve(digest.MustNewInstanceName("limited"), 0, 8)
+		require.True(t, ok)
+		require.Equal(t, int64(0), usageBytes)
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		// Instance names without a quota may store any amount
+		// of data.
+		blobDigest := digest.MustNewDigest("other", "8b1a9953c4611296a827abf8c47804d7", 5)
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			}).Times(3)
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+		}
+	})
+}
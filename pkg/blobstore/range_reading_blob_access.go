@@ -0,0 +1,88 @@
+package blobstore
+
+import (
+	"context"
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RangeReadingBlobAccess is an extension of BlobAccess, implemented by
+// backends that are capable of reading parts of blobs without reading
+// the data surrounding it. This is useful in case many small files
+// are stored inside a single large blob in the Content Addressable
+// Storage.
+type RangeReadingBlobAccess interface {
+	BlobAccess
+
+	// GetRange returns a buffer containing at most sizeBytes bytes
+	// of a blob, starting at a given offset. The range is clamped
+	// to the size of the blob, meaning that fewer bytes are
+	// returned in case the range extends beyond the end of the
+	// blob.
+	//
+	// As the checksum of a blob can only be computed over its
+	// contents in their entirety, the data contained in the
+	// returned buffer is not validated against the digest. Only
+	// its size is checked. Callers that require validated data
+	// should either call Get() or validate the data at a higher
+	// level (e.g., by storing the digests of the individual files
+	// contained in the blob).
+	//
+	// Implementations may load the data into memory, meaning that
+	// this function should only be used to read small ranges.
+	GetRange(ctx context.Context, digest digest.Digest, offset int64, sizeBytes int64) buffer.Buffer
+}
+
+// GetRangeSizeBytes validates the arguments of a call to
+// RangeReadingBlobAccess.GetRange(). It returns the number of bytes
+// that need to be read, which is sizeBytes clamped to the size of the
+// blob.
+func GetRangeSizeBytes(digest digest.Digest, offset int64, sizeBytes int64) (int64, error) {
+	blobSizeBytes := digest.GetSizeBytes()
+	if offset < 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "Negative read offset: %d", offset)
+	}
+	if offset > blobSizeBytes {
+		return 0, status.Errorf(codes.InvalidArgument, "Read offset %d exceeds blob size %d", offset, blobSizeBytes)
+	}
+	if sizeBytes < 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "Negative read size: %d", sizeBytes)
+	}
+	if remainingBytes := blobSizeBytes - offset; sizeBytes > remainingBytes {
+		return remainingBytes, nil
+	}
+	return sizeBytes, nil
+}
+
+// GetRange reads part of a blob from a BlobAccess. If the BlobAccess
+// implements RangeReadingBlobAccess, the request is forwarded to
+// GetRange(), meaning that the resulting data is not validated.
+// Otherwise, the blob is read in its entirety, discarding the data
+// surrounding the requested range. In that case the blob is validated
+// against its digest.
+func GetRange(ctx context.Context, blobAccess BlobAccess, digest digest.Digest, offset int64, sizeBytes int64) buffer.Buffer {
+	if rangeReadingBlobAccess, ok := blobAccess.(RangeReadingBlobAccess); ok {
+		return rangeReadingBlobAccess.GetRange(ctx, digest, offset, sizeBytes)
+	}
+
+	sizeBytes, err := GetRangeSizeBytes(digest, offset, sizeBytes)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	data := make([]byte, sizeBytes)
+	n, err := blobAccess.Get(ctx, digest).ReadAt(data, offset)
+	if err == io.EOF {
+		// Reading up to the end of the blob is permitted.
+		if int64(n) != sizeBytes {
+			return buffer.NewBufferFromError(status.Errorf(codes.Internal, "Blob contained %d bytes at offset %d, while %d bytes were expected", n, offset, sizeBytes))
+		}
+	} else if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return buffer.NewValidatedBufferFromByteSlice(data)
+}
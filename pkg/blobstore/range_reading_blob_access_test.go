@@ -0,0 +1,65 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetRange(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	// BlobAccess does not implement RangeReadingBlobAccess, meaning
+	// that GetRange() needs to fall back to reading the full blob.
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("NegativeOffset", func(t *testing.T) {
+		_, err := blobstore.GetRange(ctx, blobAccess, helloDigest, -1, 2).ToByteSlice(10)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Negative read offset: -1"), err)
+	})
+
+	t.Run("OffsetTooLarge", func(t *testing.T) {
+		_, err := blobstore.GetRange(ctx, blobAccess, helloDigest, 6, 2).ToByteSlice(10)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Read offset 6 exceeds blob size 5"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		blobAccess.EXPECT().Get(ctx, helloDigest).Return(
+			buffer.NewCASBufferFromByteSlice(helloDigest, []byte("Hello"), buffer.UserProvided))
+
+		data, err := blobstore.GetRange(ctx, blobAccess, helloDigest, 1, 3).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("ell"), data)
+	})
+
+	t.Run("Clamped", func(t *testing.T) {
+		// Ranges extending beyond the end of the blob should
+		// be truncated.
+		blobAccess.EXPECT().Get(ctx, helloDigest).Return(
+			buffer.NewCASBufferFromByteSlice(helloDigest, []byte("Hello"), buffer.UserProvided))
+
+		data, err := blobstore.GetRange(ctx, blobAccess, helloDigest, 3, 10).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("lo"), data)
+	})
+
+	t.Run("ChecksumFailure", func(t *testing.T) {
+		// The full blob is validated, even though only a part
+		// of it is returned.
+		blobAccess.EXPECT().Get(ctx, helloDigest).Return(
+			buffer.NewCASBufferFromByteSlice(helloDigest, []byte("Hallo"), buffer.UserProvided))
+
+		_, err := blobstore.GetRange(ctx, blobAccess, helloDigest, 3, 2).ToByteSlice(10)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.InvalidArgument, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected")), err)
+	})
+}
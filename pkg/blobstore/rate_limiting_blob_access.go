@@ -0,0 +1,189 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// tokenBucket is a simple implementation of the token bucket
+// algorithm. The bucket is refilled at a constant rate, and can hold
+// at most one second worth of tokens.
+//
+// Requests for more tokens than are available are permitted to
+// borrow against future refills, causing the caller to wait until the
+// balance is no longer negative. This ensures that requests for more
+// tokens than the bucket can hold don't block indefinitely.
+type tokenBucket struct {
+	clock         clock.Clock
+	ratePerSecond float64
+
+	lock       sync.Mutex
+	tokens     float64
+	lastRefill time.Time
+}
+
+func newTokenBucket(clock clock.Clock, ratePerSecond float64) *tokenBucket {
+	return &tokenBucket{
+		clock:         clock,
+		ratePerSecond: ratePerSecond,
+		tokens:        ratePerSecond,
+		lastRefill:    clock.Now(),
+	}
+}
+
+// take a number of tokens from the bucket, waiting for them to become
+// available if needed. RESOURCE_EXHAUSTED is returned if the wait
+// would exceed the maximum.
+func (tb *tokenBucket) take(ctx context.Context, count float64, maximumWait time.Duration) error {
+	tb.lock.Lock()
+	now := tb.clock.Now()
+	tb.tokens += now.Sub(tb.lastRefill).Seconds() * tb.ratePerSecond
+	if tb.tokens > tb.ratePerSecond {
+		tb.tokens = tb.ratePerSecond
+	}
+	tb.lastRefill = now
+
+	if tb.tokens >= count {
+		tb.tokens -= count
+		tb.lock.Unlock()
+		return nil
+	}
+	wait := time.Duration((count - tb.tokens) / tb.ratePerSecond * float64(time.Second))
+	if wait > maximumWait {
+		tb.lock.Unlock()
+		return status.Errorf(codes.ResourceExhausted, "Rate limit exceeded: operation would need to wait for %s, while a maximum of %s is permitted", wait, maximumWait)
+	}
+	tb.tokens -= count
+	tb.lock.Unlock()
+
+	timer, timerChannel := tb.clock.NewTimer(wait)
+	select {
+	case <-timerChannel:
+		return nil
+	case <-ctx.Done():
+		// Return the tokens that were borrowed, so that other
+		// callers don't need to wait for them.
+		timer.Stop()
+		tb.lock.Lock()
+		tb.tokens += count
+		tb.lock.Unlock()
+		return util.StatusFromContext(ctx)
+	}
+}
+
+type rateLimitingBlobAccess struct {
+	BlobAccess
+	operationsBucket *tokenBucket
+	bytesBucket      *tokenBucket
+	maximumWait      time.Duration
+}
+
+// NewRateLimitingBlobAccess creates a decorator for BlobAccess that
+// limits the rate at which Get() and Put() operations are performed,
+// and the rate at which data is transferred by them. This may be used
+// to prevent a single misbehaving client from saturating a backend.
+//
+// Byte throughput is limited while buffers are streamed, as opposed
+// to being accounted for up front. This means that large blobs are
+// transferred at the permitted rate, instead of being rejected or
+// delayed until enough budget has accumulated. Chunks that are larger
+// than the bucket's capacity are accounted for in multiple steps.
+//
+// Operations are only rejected with RESOURCE_EXHAUSTED if they would
+// need to wait longer than maximumWait. Rates that are zero or
+// negative disable the corresponding limit.
+func NewRateLimitingBlobAccess(base BlobAccess, clock clock.Clock, operationsPerSecond float64, bytesPerSecond float64, maximumWait time.Duration) BlobAccess {
+	ba := &rateLimitingBlobAccess{
+		BlobAccess:  base,
+		maximumWait: maximumWait,
+	}
+	if operationsPerSecond > 0 {
+		ba.operationsBucket = newTokenBucket(clock, operationsPerSecond)
+	}
+	if bytesPerSecond > 0 {
+		ba.bytesBucket = newTokenBucket(clock, bytesPerSecond)
+	}
+	return ba
+}
+
+func (ba *rateLimitingBlobAccess) waitForOperation(ctx context.Context) error {
+	if ba.operationsBucket == nil {
+		return nil
+	}
+	return ba.operationsBucket.take(ctx, 1, ba.maximumWait)
+}
+
+func (ba *rateLimitingBlobAccess) throttleBuffer(ctx context.Context, b buffer.Buffer) buffer.Buffer {
+	if ba.bytesBucket == nil {
+		return b
+	}
+	return buffer.WithChunkReaderDecorator(b, func(r buffer.ChunkReader, off int64) buffer.ChunkReader {
+		return &throttlingChunkReader{
+			ChunkReader: r,
+			context:     ctx,
+			blobAccess:  ba,
+		}
+	})
+}
+
+func (ba *rateLimitingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	if err := ba.waitForOperation(ctx); err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return ba.throttleBuffer(ctx, ba.BlobAccess.Get(ctx, digest))
+}
+
+func (ba *rateLimitingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if err := ba.waitForOperation(ctx); err != nil {
+		b.Discard()
+		return err
+	}
+	return ba.BlobAccess.Put(ctx, digest, ba.throttleBuffer(ctx, b))
+}
+
+// throttlingChunkReader is a decorator for ChunkReader that delays
+// returning chunks until the bytes token bucket permits it.
+type throttlingChunkReader struct {
+	buffer.ChunkReader
+	context    context.Context
+	blobAccess *rateLimitingBlobAccess
+}
+
+func (r *throttlingChunkReader) Read() ([]byte, error) {
+	chunk, err := r.ChunkReader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	// Chunks may be larger than what the bucket permits to be
+	// taken at once. Take tokens in pieces that can be obtained
+	// without exceeding the maximum waiting time, so that large
+	// chunks are merely delayed, as opposed to being rejected.
+	bytesBucket := r.blobAccess.bytesBucket
+	maximumPiece := bytesBucket.ratePerSecond * r.blobAccess.maximumWait.Seconds()
+	if maximumPiece > bytesBucket.ratePerSecond {
+		maximumPiece = bytesBucket.ratePerSecond
+	}
+	if maximumPiece < 1 {
+		maximumPiece = 1
+	}
+	for remaining := float64(len(chunk)); remaining > 0; remaining -= maximumPiece {
+		piece := remaining
+		if piece > maximumPiece {
+			piece = maximumPiece
+		}
+		if err := bytesBucket.take(r.context, piece, r.blobAccess.maximumWait); err != nil {
+			return nil, err
+		}
+	}
+	return chunk, nil
+}
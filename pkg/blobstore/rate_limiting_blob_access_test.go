@@ -0,0 +1,110 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRateLimitingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	// Let time only advance when timers fire. This makes it easy
+	// to reason about waiting times.
+	now := time.Unix(1000, 0)
+	clock := mock.NewMockClock(ctrl)
+	clock.EXPECT().Now().DoAndReturn(func() time.Time { return now }).AnyTimes()
+	expectTimer := func(d time.Duration) {
+		timerChannel := make(chan time.Time, 1)
+		timerChannel <- now.Add(d)
+		clock.EXPECT().NewTimer(d).
+			Do(func(d time.Duration) { now = now.Add(d) }).
+			Return(mock.NewMockTimer(ctrl), timerChannel)
+	}
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewRateLimitingBlobAccess(baseBlobAccess, clock, 10, 100, 5*time.Second)
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("WithinBudget", func(t *testing.T) {
+		// Operations should complete immediately, as long as
+		// enough tokens are available.
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(1000)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("Throttled", func(t *testing.T) {
+		// Only 95 bytes worth of tokens remain. Reading 145
+		// bytes should cause the caller to wait for half a
+		// second. As the bucket holds at most 100 tokens, they
+		// are taken in two steps.
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice(make([]byte, 145)))
+		expectTimer(50 * time.Millisecond)
+		expectTimer(450 * time.Millisecond)
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(1000)
+		require.NoError(t, err)
+		require.Len(t, data, 145)
+	})
+
+	t.Run("LargeChunk", func(t *testing.T) {
+		// Writing a single chunk of 1000 bytes takes longer
+		// than the maximum permitted amount of time. It should
+		// not be rejected, but be transferred at the permitted
+		// rate.
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				_, err := b.ToByteSlice(1000)
+				return err
+			})
+		for i := 0; i < 10; i++ {
+			expectTimer(time.Second)
+		}
+
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(make([]byte, 1000))))
+	})
+}
+
+func TestRateLimitingBlobAccessResourceExhausted(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	// Let time stand still, so that token buckets are never
+	// refilled. Every piece of the chunk that is taken from the
+	// bucket increases the amount of time that subsequent pieces
+	// need to wait.
+	clock := mock.NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(time.Unix(1000, 0)).AnyTimes()
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewRateLimitingBlobAccess(baseBlobAccess, clock, 0, 100, 2*time.Second)
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			_, err := b.ToByteSlice(1000)
+			return err
+		})
+	for _, d := range []time.Duration{time.Second, 2 * time.Second} {
+		timerChannel := make(chan time.Time, 1)
+		timerChannel <- time.Unix(1000, 0)
+		clock.EXPECT().NewTimer(d).Return(mock.NewMockTimer(ctrl), timerChannel)
+	}
+
+	require.Equal(
+		t,
+		status.Error(codes.ResourceExhausted, "Rate limit exceeded: operation would need to wait for 3s, while a maximum of 2s is permitted"),
+		blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(make([]byte, 500))))
+}
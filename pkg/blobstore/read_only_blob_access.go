@@ -0,0 +1,30 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type readOnlyBlobAccess struct {
+	BlobAccess
+}
+
+// NewReadOnlyBlobAccess creates a decorator for BlobAccess that
+// rejects all writes with FAILED_PRECONDITION, while forwarding reads.
+// This may be used to serve data from immutable snapshots of storage,
+// without any risk of them being mutated accidentally.
+func NewReadOnlyBlobAccess(base BlobAccess) BlobAccess {
+	return &readOnlyBlobAccess{
+		BlobAccess: base,
+	}
+}
+
+func (ba *readOnlyBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	b.Discard()
+	return status.Error(codes.FailedPrecondition, "Storage backend is read-only")
+}
@@ -2,20 +2,60 @@ package readcaching
 
 import (
 	"context"
+	"sync"
 
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+var (
+	readCachingBlobAccessPrometheusMetrics sync.Once
+
+	readCachingBlobAccessGets = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "read_caching_blob_access_gets_total",
+			Help:      "Number of Get() calls, labeled by whether the blob was present in the fast backend.",
+		},
+		[]string{"result"})
+	readCachingBlobAccessGetsHit  = readCachingBlobAccessGets.WithLabelValues("Hit")
+	readCachingBlobAccessGetsMiss = readCachingBlobAccessGets.WithLabelValues("Miss")
+
+	readCachingBlobAccessPrefetches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "read_caching_blob_access_prefetches_total",
+			Help:      "Number of blobs for which prefetching was requested, labeled by outcome.",
+		},
+		[]string{"outcome"})
+	readCachingBlobAccessPrefetchesPresent   = readCachingBlobAccessPrefetches.WithLabelValues("Present")
+	readCachingBlobAccessPrefetchesDropped   = readCachingBlobAccessPrefetches.WithLabelValues("Dropped")
+	readCachingBlobAccessPrefetchesCompleted = readCachingBlobAccessPrefetches.WithLabelValues("Completed")
+	readCachingBlobAccessPrefetchesFailed    = readCachingBlobAccessPrefetches.WithLabelValues("Failed")
+)
+
 type readCachingBlobAccess struct {
 	slow       blobstore.BlobAccess
 	fast       blobstore.BlobAccess
 	replicator replication.BlobReplicator
+
+	maximumPrefetchQueuedSizeBytes int64
+	errorLogger                    util.ErrorLogger
+
+	prefetchLock            sync.Mutex
+	prefetchWakeup          *sync.Cond
+	prefetchQueue           []digest.Digest
+	prefetchPending         map[digest.Digest]struct{}
+	prefetchQueuedSizeBytes int64
 }
 
 // NewReadCachingBlobAccess turns a fast data store into a read cache
@@ -23,12 +63,38 @@ type readCachingBlobAccess struct {
 // store directly. The slow data store is only accessed for reading in
 // case the fast data store does not contain the blob. The blob is then
 // streamed into the fast data store using a replicator.
-func NewReadCachingBlobAccess(slow blobstore.BlobAccess, fast blobstore.BlobAccess, replicator replication.BlobReplicator) blobstore.BlobAccess {
-	return &readCachingBlobAccess{
+//
+// The resulting BlobAccess implements blobstore.Prefetcher, allowing
+// blobs to be copied into the fast data store ahead of time. At most
+// maximumPrefetchConcurrency blobs are copied in parallel, while the
+// total size of blobs that are queued or being copied is limited to
+// maximumPrefetchQueuedSizeBytes. Prefetching is disabled if
+// maximumPrefetchConcurrency is zero. As prefetching is performed in
+// the background, failures are reported through the provided
+// ErrorLogger.
+func NewReadCachingBlobAccess(slow blobstore.BlobAccess, fast blobstore.BlobAccess, replicator replication.BlobReplicator, maximumPrefetchConcurrency int, maximumPrefetchQueuedSizeBytes int64, errorLogger util.ErrorLogger) blobstore.BlobAccess {
+	readCachingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(readCachingBlobAccessGets)
+		prometheus.MustRegister(readCachingBlobAccessPrefetches)
+	})
+
+	ba := &readCachingBlobAccess{
 		slow:       slow,
 		fast:       fast,
 		replicator: replicator,
+
+		errorLogger: errorLogger,
+
+		prefetchPending: map[digest.Digest]struct{}{},
+	}
+	ba.prefetchWakeup = sync.NewCond(&ba.prefetchLock)
+	if maximumPrefetchConcurrency > 0 {
+		ba.maximumPrefetchQueuedSizeBytes = maximumPrefetchQueuedSizeBytes
+		for i := 0; i < maximumPrefetchConcurrency; i++ {
+			go ba.runPrefetchWorker()
+		}
 	}
+	return ba
 }
 
 func (ba *readCachingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
@@ -49,19 +115,88 @@ func (ba *readCachingBlobAccess) FindMissing(ctx context.Context, digests digest
 	return ba.slow.FindMissing(ctx, digests)
 }
 
+func (ba *readCachingBlobAccess) Prefetch(ctx context.Context, digests digest.Set) {
+	if ba.maximumPrefetchQueuedSizeBytes <= 0 {
+		return
+	}
+
+	// Only enqueue blobs that are absent in the fast backend, so
+	// that the queue size limit is not consumed by blobs that
+	// don't need to be copied.
+	missing, err := ba.fast.FindMissing(ctx, digests)
+	if err != nil {
+		ba.errorLogger.Log(util.StatusWrap(err, "Failed to determine which blobs to prefetch"))
+		return
+	}
+	readCachingBlobAccessPrefetchesPresent.Add(float64(digests.Length() - missing.Length()))
+
+	ba.prefetchLock.Lock()
+	defer ba.prefetchLock.Unlock()
+	for _, blobDigest := range missing.Items() {
+		if _, ok := ba.prefetchPending[blobDigest]; ok {
+			continue
+		}
+		sizeBytes := blobDigest.GetSizeBytes()
+		if ba.prefetchQueuedSizeBytes+sizeBytes > ba.maximumPrefetchQueuedSizeBytes {
+			readCachingBlobAccessPrefetchesDropped.Inc()
+			continue
+		}
+		ba.prefetchQueue = append(ba.prefetchQueue, blobDigest)
+		ba.prefetchPending[blobDigest] = struct{}{}
+		ba.prefetchQueuedSizeBytes += sizeBytes
+		ba.prefetchWakeup.Signal()
+	}
+}
+
+// runPrefetchWorker is executed by goroutines that copy blobs that are
+// queued for prefetching into the fast backend. Copying is performed
+// using a context that is detached from the one provided to
+// Prefetch(), as the caller does not wait for completion.
+func (ba *readCachingBlobAccess) runPrefetchWorker() {
+	for {
+		ba.prefetchLock.Lock()
+		for len(ba.prefetchQueue) == 0 {
+			ba.prefetchWakeup.Wait()
+		}
+		blobDigest := ba.prefetchQueue[0]
+		ba.prefetchQueue[0] = digest.BadDigest
+		ba.prefetchQueue = ba.prefetchQueue[1:]
+		ba.prefetchLock.Unlock()
+
+		if err := ba.replicator.ReplicateMultiple(context.Background(), blobDigest.ToSingletonSet()); err == nil {
+			readCachingBlobAccessPrefetchesCompleted.Inc()
+		} else {
+			readCachingBlobAccessPrefetchesFailed.Inc()
+			ba.errorLogger.Log(util.StatusWrapf(err, "Failed to prefetch blob %#v", blobDigest.String()))
+		}
+
+		ba.prefetchLock.Lock()
+		delete(ba.prefetchPending, blobDigest)
+		ba.prefetchQueuedSizeBytes -= blobDigest.GetSizeBytes()
+		ba.prefetchLock.Unlock()
+	}
+}
+
 type readCachingErrorHandler struct {
-	replicator replication.BlobReplicator
-	context    context.Context
-	digest     digest.Digest
+	replicator    replication.BlobReplicator
+	context       context.Context
+	digest        digest.Digest
+	observedError bool
 }
 
 func (eh *readCachingErrorHandler) OnError(observedErr error) (buffer.Buffer, error) {
+	eh.observedError = true
 	if eh.replicator == nil || status.Code(observedErr) != codes.NotFound {
 		return nil, observedErr
 	}
 	replicator := eh.replicator
 	eh.replicator = nil
+	readCachingBlobAccessGetsMiss.Inc()
 	return replicator.ReplicateSingle(eh.context, eh.digest), nil
 }
 
-func (eh *readCachingErrorHandler) Done() {}
+func (eh *readCachingErrorHandler) Done() {
+	if !eh.observedError {
+		readCachingBlobAccessGetsHit.Inc()
+	}
+}
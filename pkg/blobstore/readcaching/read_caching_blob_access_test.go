@@ -5,8 +5,10 @@ import (
 	"testing"
 
 	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/readcaching"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
@@ -20,8 +22,9 @@ func TestReadCachingBlobAccessGet(t *testing.T) {
 
 	slowBlobAccess := mock.NewMockBlobAccess(ctrl)
 	fastBlobAccess := mock.NewMockBlobAccess(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
 	blobReplicator := mock.NewMockBlobReplicator(ctrl)
-	blobAccess := readcaching.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess, blobReplicator)
+	blobAccess := readcaching.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess, blobReplicator, 0, 0, errorLogger)
 	blobDigest := digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
 
 	t.Run("Fast", func(t *testing.T) {
@@ -63,13 +66,58 @@ func TestReadCachingBlobAccessGet(t *testing.T) {
 	})
 }
 
+func TestReadCachingBlobAccessGetPopulatesCache(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	slowBlobAccess := mock.NewMockBlobAccess(ctrl)
+	fastBlobAccess := mock.NewMockBlobAccess(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	blobAccess := readcaching.NewReadCachingBlobAccess(
+		slowBlobAccess,
+		fastBlobAccess,
+		replication.NewConcurrencyLimitingBlobReplicator(
+			slowBlobAccess,
+			replication.NewLocalBlobReplicator(slowBlobAccess, fastBlobAccess),
+			1),
+		0,
+		0,
+		errorLogger)
+	blobDigest := digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+
+	// The first call should cause the blob to be read from the slow
+	// backend, while also storing a copy in the fast backend.
+	var cachedData []byte
+	fastBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
+	slowBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+	fastBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			data, err := b.ToByteSlice(100)
+			require.NoError(t, err)
+			cachedData = data
+			return nil
+		})
+
+	data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello world"), data)
+
+	// The second call should be served by the fast backend, without
+	// contacting the slow backend.
+	fastBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice(cachedData))
+
+	data, err = blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello world"), data)
+}
+
 func TestReadCachingBlobAccessPut(t *testing.T) {
 	ctrl, ctx := gomock.WithContext(context.Background(), t)
 
 	slowBlobAccess := mock.NewMockBlobAccess(ctrl)
 	fastBlobAccess := mock.NewMockBlobAccess(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
 	blobReplicator := mock.NewMockBlobReplicator(ctrl)
-	blobAccess := readcaching.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess, blobReplicator)
+	blobAccess := readcaching.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess, blobReplicator, 0, 0, errorLogger)
 	blobDigest := digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
 	buffer := buffer.NewValidatedBufferFromByteSlice([]byte("Hello, world"))
 
@@ -88,8 +136,9 @@ func TestReadCachingBlobAccessFindMissing(t *testing.T) {
 
 	slowBlobAccess := mock.NewMockBlobAccess(ctrl)
 	fastBlobAccess := mock.NewMockBlobAccess(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
 	blobReplicator := mock.NewMockBlobReplicator(ctrl)
-	blobAccess := readcaching.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess, blobReplicator)
+	blobAccess := readcaching.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess, blobReplicator, 0, 0, errorLogger)
 	digests := digest.NewSetBuilder().
 		Add(digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)).
 		Add(digest.MustNewDigest("default", "82e35a63ceba37e9646434c5dd412ea577147f1e4a41ccde1614253187e3dbf9", 7)).
@@ -105,3 +154,51 @@ func TestReadCachingBlobAccessFindMissing(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, digests, missing)
 }
+
+func TestReadCachingBlobAccessPrefetch(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	slowBlobAccess := mock.NewMockBlobAccess(ctrl)
+	fastBlobAccess := mock.NewMockBlobAccess(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	blobReplicator := mock.NewMockBlobReplicator(ctrl)
+	digest1 := digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+	digest2 := digest.MustNewDigest("default", "82e35a63ceba37e9646434c5dd412ea577147f1e4a41ccde1614253187e3dbf9", 7)
+	digest3 := digest.MustNewDigest("default", "3e25960a79dbc69b674cd4ec67a72c62e2f4f2e8f2ad94c5c1e1c0e2b6d8b1a7", 300)
+	digests := digest.NewSetBuilder().Add(digest1).Add(digest2).Add(digest3).Build()
+
+	t.Run("Disabled", func(t *testing.T) {
+		// If no prefetch concurrency is configured, hints
+		// should be ignored entirely.
+		blobAccess := readcaching.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess, blobReplicator, 0, 0, errorLogger)
+		blobstore.Prefetch(ctx, blobAccess, digests)
+	})
+
+	t.Run("FindMissingError", func(t *testing.T) {
+		// Failures to check for the existence of blobs in the
+		// fast backend should cause the hint to be discarded.
+		blobAccess := readcaching.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess, blobReplicator, 1, 100, errorLogger)
+		fastBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, status.Error(codes.Internal, "Disk on fire"))
+		errorLogger.EXPECT().Log(status.Error(codes.Internal, "Failed to determine which blobs to prefetch: Disk on fire"))
+
+		blobstore.Prefetch(ctx, blobAccess, digests)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// The first blob is already present in the fast
+		// backend, while the third blob exceeds the maximum
+		// queued size. Only the second blob should be copied.
+		blobAccess := readcaching.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess, blobReplicator, 1, 100, errorLogger)
+		fastBlobAccess.EXPECT().FindMissing(ctx, digests).
+			Return(digest.NewSetBuilder().Add(digest2).Add(digest3).Build(), nil)
+		done := make(chan struct{})
+		blobReplicator.EXPECT().ReplicateMultiple(gomock.Any(), digest2.ToSingletonSet()).
+			DoAndReturn(func(ctx context.Context, digests digest.Set) error {
+				close(done)
+				return nil
+			})
+
+		blobstore.Prefetch(ctx, blobAccess, digests)
+		<-done
+	})
+}
@@ -0,0 +1,174 @@
+package readfallback
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type readFallbackChainBlobAccess struct {
+	backends         []blobstore.BlobAccess
+	putToAllBackends bool
+	continueOnError  bool
+}
+
+// NewReadFallbackChainBlobAccess creates a BlobAccess that reads data
+// from an ordered list of backends. This may be used to construct a
+// tiered storage hierarchy (e.g., memory, followed by local disk,
+// followed by a remote cluster). At least one backend must be
+// provided.
+//
+// Get() attempts to read the object from every backend in order, until
+// one of the backends returns it. FindMissing() queries all backends
+// in parallel, and only reports objects as missing if they are absent
+// in all backends. Objects found in later backends are not copied into
+// earlier ones.
+//
+// Put() writes objects into the first backend. If putToAllBackends is
+// set, objects are written into all backends instead.
+//
+// By default, only NOT_FOUND errors cause the next backend to be
+// tried. Other errors are returned immediately, as falling back could
+// mask persistent failures of a backend. If continueOnError is set,
+// the next backend is tried regardless, only returning an error if no
+// backend is able to serve the request.
+func NewReadFallbackChainBlobAccess(backends []blobstore.BlobAccess, putToAllBackends bool, continueOnError bool) blobstore.BlobAccess {
+	return &readFallbackChainBlobAccess{
+		backends:         backends,
+		putToAllBackends: putToAllBackends,
+		continueOnError:  continueOnError,
+	}
+}
+
+func (ba *readFallbackChainBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	return buffer.WithErrorHandler(
+		ba.backends[0].Get(ctx, digest),
+		&readFallbackChainErrorHandler{
+			backends:        ba.backends,
+			continueOnError: ba.continueOnError,
+			context:         ctx,
+			digest:          digest,
+		})
+}
+
+func (ba *readFallbackChainBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if !ba.putToAllBackends {
+		return ba.backends[0].Put(ctx, digest, b)
+	}
+
+	// Store the object in all backends concurrently. Bind the
+	// context to all clones, so that a stalling backend does not
+	// prevent the other backends from returning once the request
+	// is cancelled.
+	errs := make([]error, len(ba.backends))
+	var wg sync.WaitGroup
+	wg.Add(len(ba.backends) - 1)
+	for i, backend := range ba.backends[:len(ba.backends)-1] {
+		var bClone buffer.Buffer
+		b, bClone = b.CloneStream()
+		go func(i int, backend blobstore.BlobAccess, b buffer.Buffer) {
+			errs[i] = backend.Put(ctx, digest, buffer.WithContext(b, ctx))
+			wg.Done()
+		}(i, backend, bClone)
+	}
+	last := len(ba.backends) - 1
+	errs[last] = ba.backends[last].Put(ctx, digest, buffer.WithContext(b, ctx))
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return util.StatusWrapf(err, "Backend %d", i)
+		}
+	}
+	return nil
+}
+
+func (ba *readFallbackChainBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Query all backends concurrently, as opposed to only
+	// forwarding the digests that are missing in one backend to
+	// the next. This prevents the latency of the call from growing
+	// with the number of backends.
+	missingPerBackend := make([]digest.Set, len(ba.backends))
+	errs := make([]error, len(ba.backends))
+	var wg sync.WaitGroup
+	wg.Add(len(ba.backends))
+	for i, backend := range ba.backends {
+		go func(i int, backend blobstore.BlobAccess) {
+			missingPerBackend[i], errs[i] = backend.FindMissing(ctx, digests)
+			wg.Done()
+		}(i, backend)
+	}
+	wg.Wait()
+
+	// Objects are only missing if they are missing in all
+	// backends. Backends that failed are treated as if they
+	// don't contain any objects if continueOnError is set.
+	missing := digests
+	var firstErr error
+	succeeded := false
+	for i, err := range errs {
+		if err != nil {
+			err = util.StatusWrapf(err, "Backend %d", i)
+			if !ba.continueOnError {
+				return digest.EmptySet, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		_, missing, _ = digest.GetDifferenceAndIntersection(missing, missingPerBackend[i])
+		succeeded = true
+	}
+	if !succeeded {
+		return digest.EmptySet, firstErr
+	}
+	return missing, nil
+}
+
+type readFallbackChainErrorHandler struct {
+	backends        []blobstore.BlobAccess
+	continueOnError bool
+	context         context.Context
+	digest          digest.Digest
+
+	index    int
+	firstErr error
+}
+
+func (eh *readFallbackChainErrorHandler) OnError(observedErr error) (buffer.Buffer, error) {
+	if status.Code(observedErr) != codes.NotFound {
+		// The backend returned an error other than NOT_FOUND.
+		// Prepend the index of the backend to make debugging
+		// easier.
+		err := util.StatusWrapf(observedErr, "Backend %d", eh.index)
+		if !eh.continueOnError {
+			return nil, err
+		}
+		if eh.firstErr == nil {
+			eh.firstErr = err
+		}
+	}
+
+	eh.index++
+	if eh.index >= len(eh.backends) {
+		// None of the backends were able to return the object.
+		// Prefer returning errors other than NOT_FOUND, as
+		// the object may have been present in a failing
+		// backend.
+		if eh.firstErr != nil {
+			return nil, eh.firstErr
+		}
+		return nil, observedErr
+	}
+	return eh.backends[eh.index].Get(eh.context, eh.digest), nil
+}
+
+func (eh *readFallbackChainErrorHandler) Done() {}
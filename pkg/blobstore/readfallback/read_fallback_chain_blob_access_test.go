@@ -0,0 +1,230 @@
+package readfallback_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/readfallback"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReadFallbackChainBlobAccessGet(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	backend0 := mock.NewMockBlobAccess(ctrl)
+	backend1 := mock.NewMockBlobAccess(ctrl)
+	backend2 := mock.NewMockBlobAccess(ctrl)
+	backends := []blobstore.BlobAccess{backend0, backend1, backend2}
+	helloDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("FirstBackendSuccess", func(t *testing.T) {
+		// Later backends should not be accessed if the first
+		// backend is able to serve the object.
+		backend0.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		blobAccess := readfallback.NewReadFallbackChainBlobAccess(backends, false, false)
+		data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("LastBackendSuccess", func(t *testing.T) {
+		backend0.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		backend1.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		backend2.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		blobAccess := readfallback.NewReadFallbackChainBlobAccess(backends, false, false)
+		data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		// If none of the backends contain the object, the
+		// NOT_FOUND error of the last backend is returned.
+		backend0.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		backend1.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		backend2.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		blobAccess := readfallback.NewReadFallbackChainBlobAccess(backends, false, false)
+		_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("BackendFailure", func(t *testing.T) {
+		// Errors other than NOT_FOUND should be returned
+		// immediately by default.
+		backend0.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		backend1.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+
+		blobAccess := readfallback.NewReadFallbackChainBlobAccess(backends, false, false)
+		_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Unavailable, "Backend 1: Server offline"), err)
+	})
+
+	t.Run("BackendFailureContinueSuccess", func(t *testing.T) {
+		// If configured to do so, errors should cause the next
+		// backend to be tried.
+		backend0.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+		backend1.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		blobAccess := readfallback.NewReadFallbackChainBlobAccess(backends, false, true)
+		data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("BackendFailureContinueNotFound", func(t *testing.T) {
+		// If no backend contains the object, the first error
+		// other than NOT_FOUND should be returned, as the
+		// object may have been present in that backend.
+		backend0.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		backend1.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+		backend2.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		blobAccess := readfallback.NewReadFallbackChainBlobAccess(backends, false, true)
+		_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Unavailable, "Backend 1: Server offline"), err)
+	})
+}
+
+func TestReadFallbackChainBlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	backend0 := mock.NewMockBlobAccess(ctrl)
+	backend1 := mock.NewMockBlobAccess(ctrl)
+	backends := []blobstore.BlobAccess{backend0, backend1}
+	helloDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	expectPut := func(backend *mock.MockBlobAccess, err error) {
+		backend.EXPECT().Put(ctx, helloDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, readErr := b.ToByteSlice(100)
+				require.NoError(t, readErr)
+				require.Equal(t, []byte("Hello"), data)
+				return err
+			})
+	}
+
+	t.Run("FirstBackend", func(t *testing.T) {
+		// By default, objects should only be written into the
+		// first backend.
+		expectPut(backend0, nil)
+
+		blobAccess := readfallback.NewReadFallbackChainBlobAccess(backends, false, false)
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("AllBackendsSuccess", func(t *testing.T) {
+		expectPut(backend0, nil)
+		expectPut(backend1, nil)
+
+		blobAccess := readfallback.NewReadFallbackChainBlobAccess(backends, true, false)
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("AllBackendsFailure", func(t *testing.T) {
+		expectPut(backend0, nil)
+		expectPut(backend1, status.Error(codes.Internal, "I/O error"))
+
+		blobAccess := readfallback.NewReadFallbackChainBlobAccess(backends, true, false)
+		require.Equal(
+			t,
+			status.Error(codes.Internal, "Backend 1: I/O error"),
+			blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+}
+
+func TestReadFallbackChainBlobAccessFindMissing(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	backend0 := mock.NewMockBlobAccess(ctrl)
+	backend1 := mock.NewMockBlobAccess(ctrl)
+	backends := []blobstore.BlobAccess{backend0, backend1}
+
+	allDigests := digest.NewSetBuilder().
+		Add(digest.MustNewDigest("instance", "00000000000000000000000000000000", 100)).
+		Add(digest.MustNewDigest("instance", "00000000000000000000000000000001", 101)).
+		Add(digest.MustNewDigest("instance", "00000000000000000000000000000002", 102)).
+		Build()
+	missingFromBackend0 := digest.NewSetBuilder().
+		Add(digest.MustNewDigest("instance", "00000000000000000000000000000000", 100)).
+		Add(digest.MustNewDigest("instance", "00000000000000000000000000000001", 101)).
+		Build()
+	missingFromBackend1 := digest.NewSetBuilder().
+		Add(digest.MustNewDigest("instance", "00000000000000000000000000000000", 100)).
+		Add(digest.MustNewDigest("instance", "00000000000000000000000000000002", 102)).
+		Build()
+	missingFromBoth := digest.MustNewDigest("instance", "00000000000000000000000000000000", 100).ToSingletonSet()
+
+	t.Run("Success", func(t *testing.T) {
+		// All backends should be queried for the full set of
+		// digests. Only digests absent in all of them should
+		// be reported as missing.
+		backend0.EXPECT().FindMissing(ctx, allDigests).Return(missingFromBackend0, nil)
+		backend1.EXPECT().FindMissing(ctx, allDigests).Return(missingFromBackend1, nil)
+
+		blobAccess := readfallback.NewReadFallbackChainBlobAccess(backends, false, false)
+		missing, err := blobAccess.FindMissing(ctx, allDigests)
+		require.NoError(t, err)
+		require.Equal(t, missingFromBoth, missing)
+	})
+
+	t.Run("BackendFailure", func(t *testing.T) {
+		backend0.EXPECT().FindMissing(ctx, allDigests).Return(missingFromBackend0, nil)
+		backend1.EXPECT().FindMissing(ctx, allDigests).
+			Return(digest.EmptySet, status.Error(codes.Internal, "I/O error"))
+
+		blobAccess := readfallback.NewReadFallbackChainBlobAccess(backends, false, false)
+		_, err := blobAccess.FindMissing(ctx, allDigests)
+		require.Equal(t, status.Error(codes.Internal, "Backend 1: I/O error"), err)
+	})
+
+	t.Run("BackendFailureContinue", func(t *testing.T) {
+		// Failing backends should be ignored if configured to
+		// continue on errors.
+		backend0.EXPECT().FindMissing(ctx, allDigests).Return(missingFromBackend0, nil)
+		backend1.EXPECT().FindMissing(ctx, allDigests).
+			Return(digest.EmptySet, status.Error(codes.Internal, "I/O error"))
+
+		blobAccess := readfallback.NewReadFallbackChainBlobAccess(backends, false, true)
+		missing, err := blobAccess.FindMissing(ctx, allDigests)
+		require.NoError(t, err)
+		require.Equal(t, missingFromBackend0, missing)
+	})
+
+	t.Run("AllBackendsFailureContinue", func(t *testing.T) {
+		// If no backend can be queried, an error must be
+		// returned regardless.
+		backend0.EXPECT().FindMissing(ctx, allDigests).
+			Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline"))
+		backend1.EXPECT().FindMissing(ctx, allDigests).
+			Return(digest.EmptySet, status.Error(codes.Internal, "I/O error"))
+
+		blobAccess := readfallback.NewReadFallbackChainBlobAccess(backends, false, true)
+		_, err := blobAccess.FindMissing(ctx, allDigests)
+		require.Equal(t, status.Error(codes.Unavailable, "Backend 0: Server offline"), err)
+	})
+}
@@ -0,0 +1,175 @@
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The recording file format.
+//
+// A recording is a directory that contains a file named "calls.jsonl",
+// containing one JSON encoded Record per line. Records are written in
+// the order in which calls complete, meaning that sequence numbers may
+// appear out of order.
+//
+// The contents of blobs transferred through Get() and Put() are stored
+// in separate files in the same directory, named after the sequence
+// number of the call in hexadecimal notation, followed by ".blob". The
+// name of this file is stored in Record.ContentsFile. Contents are only
+// stored if the blob was transferred in its entirety and is not larger
+// than the threshold configured on the Recorder.
+const callsFilename = "calls.jsonl"
+
+// Operations that may be stored in Record.Operation.
+const (
+	OperationGet         = "Get"
+	OperationPut         = "Put"
+	OperationFindMissing = "FindMissing"
+)
+
+// RecordedDigest is the representation of a digest.Digest in a
+// recording.
+type RecordedDigest struct {
+	InstanceName string `json:"instance_name"`
+	Hash         string `json:"hash"`
+	SizeBytes    int64  `json:"size_bytes"`
+}
+
+func newRecordedDigest(blobDigest digest.Digest) RecordedDigest {
+	return RecordedDigest{
+		InstanceName: blobDigest.GetInstanceName().String(),
+		Hash:         blobDigest.GetHashString(),
+		SizeBytes:    blobDigest.GetSizeBytes(),
+	}
+}
+
+func newRecordedDigests(digests digest.Set) []RecordedDigest {
+	items := digests.Items()
+	recordedDigests := make([]RecordedDigest, 0, len(items))
+	for _, blobDigest := range items {
+		recordedDigests = append(recordedDigests, newRecordedDigest(blobDigest))
+	}
+	return recordedDigests
+}
+
+func (rd RecordedDigest) toDigest() (digest.Digest, error) {
+	instanceName, err := digest.NewInstanceName(rd.InstanceName)
+	if err != nil {
+		return digest.BadDigest, util.StatusWrapf(err, "Invalid instance name %#v", rd.InstanceName)
+	}
+	return instanceName.NewDigest(rd.Hash, rd.SizeBytes)
+}
+
+// Record of a single call against a BlobAccess.
+type Record struct {
+	// Sequence number of the call, in the order in which calls
+	// were started.
+	Sequence uint64 `json:"sequence"`
+	// Operation is one of OperationGet, OperationPut and
+	// OperationFindMissing.
+	Operation string `json:"operation"`
+	// Digest of the blob. Only set for Get() and Put().
+	Digest *RecordedDigest `json:"digest,omitempty"`
+	// Digests provided to FindMissing().
+	Digests []RecordedDigest `json:"digests,omitempty"`
+	// Digests reported as missing by FindMissing().
+	Missing []RecordedDigest `json:"missing,omitempty"`
+	// Code and message of the gRPC status of the call.
+	Code    codes.Code `json:"code"`
+	Message string     `json:"message,omitempty"`
+	// Name of the file containing the contents of the blob, and
+	// its size. Only set for Get() and Put() if the contents were
+	// recorded.
+	ContentsFile      string `json:"contents_file,omitempty"`
+	ContentsSizeBytes int64  `json:"contents_size_bytes,omitempty"`
+}
+
+func (r *Record) setError(err error) {
+	s := status.Convert(err)
+	r.Code = s.Code()
+	r.Message = s.Message()
+}
+
+func (r *Record) getError() error {
+	return status.Error(r.Code, r.Message)
+}
+
+// Recorder writes a recording of calls performed against a BlobAccess
+// to a directory, using the format described above. It is used by
+// NewRecordingBlobAccess().
+type Recorder struct {
+	directory                filesystem.Directory
+	maximumContentsSizeBytes int64
+	nextSequence             uint64
+
+	lock  sync.Mutex
+	calls filesystem.FileAppender
+}
+
+// NewRecorder creates a Recorder that writes a recording into a
+// directory. Contents of blobs that are larger than
+// maximumContentsSizeBytes are omitted. The directory may not contain
+// a recording already.
+func NewRecorder(directory filesystem.Directory, maximumContentsSizeBytes int64) (*Recorder, error) {
+	calls, err := directory.OpenAppend(callsFilename, filesystem.CreateExcl(0644))
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to create calls file")
+	}
+	return &Recorder{
+		directory:                directory,
+		maximumContentsSizeBytes: maximumContentsSizeBytes,
+		calls:                    calls,
+	}, nil
+}
+
+// Close the recording. Calls that complete after closing are not
+// recorded.
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.calls == nil {
+		return nil
+	}
+	err := r.calls.Close()
+	r.calls = nil
+	return err
+}
+
+func (r *Recorder) newRecord(operation string) *Record {
+	return &Record{
+		Sequence:  atomic.AddUint64(&r.nextSequence, 1) - 1,
+		Operation: operation,
+	}
+}
+
+func getContentsFilename(sequence uint64) string {
+	return fmt.Sprintf("%016x.blob", sequence)
+}
+
+func (r *Recorder) writeRecord(record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal record")
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.calls == nil {
+		return status.Error(codes.FailedPrecondition, "Recording has already been closed")
+	}
+	if _, err := r.calls.Write(append(data, '\n')); err != nil {
+		return util.StatusWrap(err, "Failed to write record")
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package recording
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+)
+
+type recordingBlobAccess struct {
+	base        blobstore.BlobAccess
+	recorder    *Recorder
+	errorLogger util.ErrorLogger
+}
+
+// NewRecordingBlobAccess creates a decorator for BlobAccess that
+// records all Get(), Put() and FindMissing() calls, so that they may be
+// analyzed or replayed using NewReplayBlobAccess() at a later point in
+// time. This may be used to reproduce issues observed in production
+// offline.
+//
+// The contents of blobs are written to disk while they are being
+// transferred, as opposed to being buffered in memory. Contents are
+// only recorded if the blob is read in its entirety, starting at the
+// beginning. Get() calls for which the buffer is discarded without
+// being read are recorded as having been cancelled. Failures to write
+// the recording are reported through the provided ErrorLogger, but do
+// not cause calls to fail.
+func NewRecordingBlobAccess(base blobstore.BlobAccess, recorder *Recorder, errorLogger util.ErrorLogger) blobstore.BlobAccess {
+	return &recordingBlobAccess{
+		base:        base,
+		recorder:    recorder,
+		errorLogger: errorLogger,
+	}
+}
+
+func (ba *recordingBlobAccess) writeRecord(record *Record) {
+	if err := ba.recorder.writeRecord(record); err != nil {
+		ba.errorLogger.Log(util.StatusWrap(err, "Failed to record call"))
+	}
+}
+
+// recordContents decorates a buffer, so that its contents are written
+// to disk while being read. Only the first reader obtained from the
+// buffer is recorded, so that cloned buffers don't cause data to be
+// recorded multiple times. Contents are not recorded if that reader
+// starts at a non-zero offset, as the data would then not correspond
+// to the blob as a whole. The provided callback is invoked once the
+// reader is closed.
+func (ba *recordingBlobAccess) recordContents(b buffer.Buffer, record *Record, onClose func()) buffer.Buffer {
+	var once sync.Once
+	return buffer.WithChunkReaderDecorator(b, func(r buffer.ChunkReader, off int64) buffer.ChunkReader {
+		decorated := r
+		once.Do(func() {
+			cr := &recordingChunkReader{
+				ChunkReader: r,
+				recorder:    ba.recorder,
+				errorLogger: ba.errorLogger,
+				record:      record,
+				onClose:     onClose,
+			}
+			if off == 0 {
+				filename := getContentsFilename(record.Sequence)
+				if contents, err := ba.recorder.directory.OpenAppend(filename, filesystem.CreateExcl(0644)); err == nil {
+					cr.contents = contents
+					cr.contentsFilename = filename
+				} else {
+					ba.errorLogger.Log(util.StatusWrap(err, "Failed to create contents file"))
+				}
+			}
+			decorated = cr
+		})
+		return decorated
+	})
+}
+
+func (ba *recordingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	record := ba.recorder.newRecord(OperationGet)
+	recordedDigest := newRecordedDigest(digest)
+	record.Digest = &recordedDigest
+
+	// The record is written once the buffer reaches the end of its
+	// lifetime. This includes cases where the buffer is discarded
+	// or rejected without a reader being created.
+	read := false
+	b := ba.recordContents(ba.base.Get(ctx, digest), record, func() {
+		read = true
+	})
+	return buffer.WithReleaseFunc(b, func() {
+		if !read {
+			record.Code = codes.Canceled
+			record.Message = "Buffer was discarded without being read"
+		}
+		ba.writeRecord(record)
+	})
+}
+
+func (ba *recordingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	record := ba.recorder.newRecord(OperationPut)
+	recordedDigest := newRecordedDigest(digest)
+	record.Digest = &recordedDigest
+
+	// The record is written after the backend returns, as that is
+	// when the outcome of the call is known. Contents are only
+	// added if the backend has finished reading them by then.
+	var lock sync.Mutex
+	contentsRecord := Record{Sequence: record.Sequence}
+	err := ba.base.Put(ctx, digest, ba.recordContents(b, &contentsRecord, func() {
+		lock.Lock()
+		defer lock.Unlock()
+		record.ContentsFile = contentsRecord.ContentsFile
+		record.ContentsSizeBytes = contentsRecord.ContentsSizeBytes
+	}))
+
+	lock.Lock()
+	defer lock.Unlock()
+	if err != nil {
+		record.setError(err)
+	}
+	ba.writeRecord(record)
+	return err
+}
+
+func (ba *recordingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	record := ba.recorder.newRecord(OperationFindMissing)
+	record.Digests = newRecordedDigests(digests)
+	missing, err := ba.base.FindMissing(ctx, digests)
+	if err == nil {
+		record.Missing = newRecordedDigests(missing)
+	} else {
+		record.setError(err)
+	}
+	ba.writeRecord(record)
+	return missing, err
+}
+
+// recordingChunkReader is a decorator for ChunkReader that writes all
+// data that is read to a file. The outcome of reading is stored in a
+// Record.
+type recordingChunkReader struct {
+	buffer.ChunkReader
+	recorder         *Recorder
+	errorLogger      util.ErrorLogger
+	record           *Record
+	onClose          func()
+	contents         filesystem.FileAppender
+	contentsFilename string
+	sizeBytes        int64
+	completed        bool
+	closed           bool
+}
+
+func (r *recordingChunkReader) discardContents() {
+	if r.contents != nil {
+		r.contents.Close()
+		r.contents = nil
+		if err := r.recorder.directory.Remove(r.contentsFilename); err != nil {
+			r.errorLogger.Log(util.StatusWrap(err, "Failed to remove contents file"))
+		}
+	}
+}
+
+func (r *recordingChunkReader) Read() ([]byte, error) {
+	chunk, err := r.ChunkReader.Read()
+	if err == nil {
+		r.sizeBytes += int64(len(chunk))
+		if r.contents != nil {
+			if r.sizeBytes > r.recorder.maximumContentsSizeBytes {
+				// Blob is too large to be recorded.
+				r.discardContents()
+			} else if _, err := r.contents.Write(chunk); err != nil {
+				r.errorLogger.Log(util.StatusWrap(err, "Failed to write contents file"))
+				r.discardContents()
+			}
+		}
+	} else if err == io.EOF {
+		r.completed = true
+	} else if r.record.Code == codes.OK {
+		r.record.setError(err)
+	}
+	return chunk, err
+}
+
+func (r *recordingChunkReader) Close() {
+	r.ChunkReader.Close()
+	if r.closed {
+		return
+	}
+	r.closed = true
+
+	if !r.completed {
+		if r.record.Code == codes.OK {
+			r.record.Code = codes.Canceled
+			r.record.Message = "Buffer was closed before being read in its entirety"
+		}
+		r.discardContents()
+	} else if r.contents != nil {
+		if err := r.contents.Close(); err == nil {
+			r.record.ContentsFile = r.contentsFilename
+			r.record.ContentsSizeBytes = r.sizeBytes
+		} else {
+			r.errorLogger.Log(util.StatusWrap(err, "Failed to close contents file"))
+		}
+		r.contents = nil
+	}
+	r.onClose()
+}
@@ -0,0 +1,183 @@
+package recording_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/recording"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func openTemporaryDirectory(t *testing.T) filesystem.DirectoryCloser {
+	p, err := ioutil.TempDir("", "recording")
+	require.NoError(t, err)
+	d, err := filesystem.NewLocalDirectory(p)
+	require.NoError(t, err)
+	return &temporaryDirectory{DirectoryCloser: d, path: p}
+}
+
+// temporaryDirectory removes the directory from disk upon closure.
+type temporaryDirectory struct {
+	filesystem.DirectoryCloser
+	path string
+}
+
+func (d *temporaryDirectory) Close() error {
+	err := d.DirectoryCloser.Close()
+	os.RemoveAll(d.path)
+	return err
+}
+
+func TestRecordingBlobAccessReplay(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	directory := openTemporaryDirectory(t)
+	defer directory.Close()
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	largeDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	missingDigest := digest.MustNewDigest("hello", "6fc422233a40a75a1f028e11c3cd1140", 7)
+	failingDigest := digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 0)
+	partialDigest := digest.MustNewDigest("hello", "6cd3556deb0da54bca060b4c39479839", 13)
+	discardedDigest := digest.MustNewDigest("hello", "b665d826e919381052ec23b9eaec3b62", 3)
+	rejectedDigest := digest.MustNewDigest("hello", "952d2c56d0485958336747bcdd98590d", 6)
+
+	// Perform calls against a backend, while recording them.
+	// Contents of blobs larger than 8 bytes should be omitted.
+	recorder, err := recording.NewRecorder(directory, 8)
+	require.NoError(t, err)
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	blobAccess := recording.NewRecordingBlobAccess(baseBlobAccess, recorder, errorLogger)
+
+	baseBlobAccess.EXPECT().Get(ctx, helloDigest).
+		Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+	data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+
+	baseBlobAccess.EXPECT().Get(ctx, largeDigest).
+		Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+	data, err = blobAccess.Get(ctx, largeDigest).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello world"), data)
+
+	// Reads that don't start at the beginning of the blob should
+	// not cause the contents to be recorded, as the data would not
+	// correspond to the blob as a whole.
+	baseBlobAccess.EXPECT().Get(ctx, partialDigest).
+		Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello, world!")))
+	r := blobAccess.Get(ctx, partialDigest).ToChunkReader(7, buffer.ChunkSizeAtMost(100))
+	chunk, err := r.Read()
+	require.NoError(t, err)
+	require.Equal(t, []byte("world!"), chunk)
+	_, err = r.Read()
+	require.Equal(t, io.EOF, err)
+	r.Close()
+
+	baseBlobAccess.EXPECT().Get(ctx, missingDigest).
+		Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
+	_, err = blobAccess.Get(ctx, missingDigest).ToByteSlice(100)
+	require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
+
+	// Buffers that are discarded, or rejected before any data is
+	// read, should still cause the Get() call to be recorded.
+	baseBlobAccess.EXPECT().Get(ctx, discardedDigest).
+		Return(buffer.NewValidatedBufferFromByteSlice([]byte("Bye")))
+	blobAccess.Get(ctx, discardedDigest).Discard()
+
+	baseBlobAccess.EXPECT().Get(ctx, rejectedDigest).
+		Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello!")))
+	_, err = blobAccess.Get(ctx, rejectedDigest).ToByteSlice(5)
+	require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is 6 bytes in size, while a maximum of 5 bytes is permitted"), err)
+
+	baseBlobAccess.EXPECT().Put(ctx, failingDigest, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			b.Discard()
+			return status.Error(codes.Unavailable, "Server not reachable")
+		})
+	require.Equal(
+		t,
+		status.Error(codes.Unavailable, "Server not reachable"),
+		blobAccess.Put(ctx, failingDigest, buffer.NewValidatedBufferFromByteSlice(nil)))
+
+	allDigests := digest.NewSetBuilder().Add(helloDigest).Add(missingDigest).Build()
+	baseBlobAccess.EXPECT().FindMissing(ctx, allDigests).Return(missingDigest.ToSingletonSet(), nil)
+	missing, err := blobAccess.FindMissing(ctx, allDigests)
+	require.NoError(t, err)
+	require.Equal(t, missingDigest.ToSingletonSet(), missing)
+
+	require.NoError(t, recorder.Close())
+
+	// Replay the recording.
+	replayBlobAccess, err := recording.NewReplayBlobAccess(directory, blobstore.CASReadBufferFactory)
+	require.NoError(t, err)
+
+	t.Run("GetRecorded", func(t *testing.T) {
+		data, err := replayBlobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("GetTooLarge", func(t *testing.T) {
+		_, err := replayBlobAccess.Get(ctx, largeDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Unavailable, "Contents of this blob were not recorded"), err)
+	})
+
+	t.Run("GetPartial", func(t *testing.T) {
+		_, err := replayBlobAccess.Get(ctx, partialDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Unavailable, "Contents of this blob were not recorded"), err)
+	})
+
+	t.Run("GetFailure", func(t *testing.T) {
+		_, err := replayBlobAccess.Get(ctx, missingDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
+	})
+
+	t.Run("GetDiscarded", func(t *testing.T) {
+		_, err := replayBlobAccess.Get(ctx, discardedDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Canceled, "Buffer was discarded without being read"), err)
+	})
+
+	t.Run("GetRejected", func(t *testing.T) {
+		_, err := replayBlobAccess.Get(ctx, rejectedDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Canceled, "Buffer was discarded without being read"), err)
+	})
+
+	t.Run("GetNotRecorded", func(t *testing.T) {
+		_, err := replayBlobAccess.Get(ctx, failingDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "No Get() call was recorded for this blob"), err)
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Server not reachable"),
+			replayBlobAccess.Put(ctx, failingDigest, buffer.NewValidatedBufferFromByteSlice(nil)))
+	})
+
+	t.Run("FindMissingRecorded", func(t *testing.T) {
+		missing, err := replayBlobAccess.FindMissing(ctx, allDigests)
+		require.NoError(t, err)
+		require.Equal(t, missingDigest.ToSingletonSet(), missing)
+	})
+
+	t.Run("FindMissingDerived", func(t *testing.T) {
+		// For sets of digests for which no FindMissing() call
+		// was recorded, the results of Get() calls are used.
+		missing, err := replayBlobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(largeDigest).Add(failingDigest).Build())
+		require.NoError(t, err)
+		require.Equal(t, failingDigest.ToSingletonSet(), missing)
+	})
+}
@@ -0,0 +1,176 @@
+package recording
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maximumRecordSizeBytes is the maximum size of a single line in a
+// calls file. Records of FindMissing() calls can be large, as they
+// contain all digests provided.
+const maximumRecordSizeBytes = 64 * 1024 * 1024
+
+type replayBlobAccess struct {
+	directory         filesystem.Directory
+	readBufferFactory blobstore.ReadBufferFactory
+	gets              map[digest.Digest]*Record
+	puts              map[digest.Digest]*Record
+	findMissing       map[string]*Record
+}
+
+// getSetKey returns a string representation of a set of digests that
+// does not depend on the order of the digests.
+func getSetKey(recordedDigests []RecordedDigest) (string, error) {
+	keys := make([]string, 0, len(recordedDigests))
+	for _, recordedDigest := range recordedDigests {
+		blobDigest, err := recordedDigest.toDigest()
+		if err != nil {
+			return "", err
+		}
+		keys = append(keys, blobDigest.GetKey(digest.KeyWithInstance))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\n"), nil
+}
+
+// NewReplayBlobAccess creates a BlobAccess that serves responses from a
+// recording created by NewRecordingBlobAccess().
+//
+// Get() returns the response of the last recorded Get() call for the
+// same digest, while Put() returns the result of the last recorded
+// Put() call. FindMissing() returns the recorded response for the same
+// set of digests. If no such call was recorded, blobs are reported as
+// present if a successful Get() or Put() call was recorded for them.
+//
+// Recorded contents are returned through the provided
+// ReadBufferFactory, so that they are validated against the digest
+// (e.g., using CASReadBufferFactory) before being served.
+func NewReplayBlobAccess(directory filesystem.Directory, readBufferFactory blobstore.ReadBufferFactory) (blobstore.BlobAccess, error) {
+	f, err := directory.OpenRead(callsFilename)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to open calls file")
+	}
+	defer f.Close()
+
+	ba := &replayBlobAccess{
+		directory:         directory,
+		readBufferFactory: readBufferFactory,
+		gets:              map[digest.Digest]*Record{},
+		puts:              map[digest.Digest]*Record{},
+		findMissing:       map[string]*Record{},
+	}
+	scanner := bufio.NewScanner(io.NewSectionReader(f, 0, math.MaxInt64))
+	scanner.Buffer(nil, maximumRecordSizeBytes)
+	for line := 1; scanner.Scan(); line++ {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Failed to unmarshal record on line %d", line)
+		}
+		switch record.Operation {
+		case OperationGet, OperationPut:
+			if record.Digest == nil {
+				return nil, status.Errorf(codes.InvalidArgument, "Record on line %d has no digest", line)
+			}
+			blobDigest, err := record.Digest.toDigest()
+			if err != nil {
+				return nil, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Record on line %d has an invalid digest", line)
+			}
+			if record.Operation == OperationGet {
+				ba.gets[blobDigest] = &record
+			} else {
+				ba.puts[blobDigest] = &record
+			}
+		case OperationFindMissing:
+			key, err := getSetKey(record.Digests)
+			if err != nil {
+				return nil, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Record on line %d has an invalid digest", line)
+			}
+			ba.findMissing[key] = &record
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "Record on line %d has unknown operation %#v", line, record.Operation)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, util.StatusWrap(err, "Failed to read calls file")
+	}
+	return ba, nil
+}
+
+func (ba *replayBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	record, ok := ba.gets[digest]
+	if !ok {
+		return buffer.NewBufferFromError(status.Error(codes.NotFound, "No Get() call was recorded for this blob"))
+	}
+	if record.Code != codes.OK {
+		return buffer.NewBufferFromError(record.getError())
+	}
+	if record.ContentsFile == "" {
+		return buffer.NewBufferFromError(status.Error(codes.Unavailable, "Contents of this blob were not recorded"))
+	}
+	f, err := ba.directory.OpenRead(record.ContentsFile)
+	if err != nil {
+		return buffer.NewBufferFromError(util.StatusWrap(err, "Failed to open contents file"))
+	}
+	return ba.readBufferFactory.NewBufferFromFileReader(digest, f, record.ContentsSizeBytes, buffer.Irreparable(digest))
+}
+
+func (ba *replayBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	b.Discard()
+	if record, ok := ba.puts[digest]; ok && record.Code != codes.OK {
+		return record.getError()
+	}
+	return nil
+}
+
+func (ba *replayBlobAccess) isPresent(blobDigest digest.Digest) bool {
+	if record, ok := ba.gets[blobDigest]; ok && record.Code == codes.OK {
+		return true
+	}
+	if record, ok := ba.puts[blobDigest]; ok && record.Code == codes.OK {
+		return true
+	}
+	return false
+}
+
+func (ba *replayBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	key, err := getSetKey(newRecordedDigests(digests))
+	if err != nil {
+		return digest.EmptySet, err
+	}
+	if record, ok := ba.findMissing[key]; ok {
+		if record.Code != codes.OK {
+			return digest.EmptySet, record.getError()
+		}
+		missing := digest.NewSetBuilder()
+		for _, recordedDigest := range record.Missing {
+			blobDigest, err := recordedDigest.toDigest()
+			if err != nil {
+				return digest.EmptySet, err
+			}
+			missing.Add(blobDigest)
+		}
+		return missing.Build(), nil
+	}
+
+	missing := digest.NewSetBuilder()
+	for _, blobDigest := range digests.Items() {
+		if !ba.isPresent(blobDigest) {
+			missing.Add(blobDigest)
+		}
+	}
+	return missing.Build(), nil
+}
@@ -11,8 +11,13 @@ import (
 	"github.com/go-redis/redis"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// RedisMaximumValueSizeBytes is the maximum size of a value that may
+// be stored in Redis.
+const RedisMaximumValueSizeBytes = 512 * 1024 * 1024
+
 // RedisClient is an interface that contains the set of functions of the
 // Redis library that is used by this package. This permits unit testing
 // and uniform switching between clustered and single-node Redis.
@@ -25,35 +30,72 @@ type redisBlobAccess struct {
 	redisClient        RedisClient
 	readBufferFactory  ReadBufferFactory
 	digestKeyFormat    digest.KeyFormat
+	keyPrefix          string
+	maximumSizeBytes   int64
+	contentAddressed   bool
 	keyTTL             time.Duration
 	replicationCount   int64
 	replicationTimeout int
 }
 
 // NewRedisBlobAccess creates a BlobAccess that uses Redis as its
-// backing store.
-func NewRedisBlobAccess(redisClient RedisClient, readBufferFactory ReadBufferFactory, digestKeyFormat digest.KeyFormat, keyTTL time.Duration, replicationCount int64, replicationTimeout time.Duration) BlobAccess {
+// backing store. As Redis keeps all data in memory, it is best suited
+// for storing small objects that are accessed frequently (e.g.,
+// Command messages and action results). Requests for blobs larger than
+// maximumSizeBytes are rejected with INVALID_ARGUMENT.
+//
+// If contentAddressed is set, the size of a blob is assumed to be equal
+// to the size stored in its digest, as is the case for the Content
+// Addressable Storage. This permits rejecting requests for blobs that
+// are too large without contacting Redis. Otherwise (e.g., for the
+// Action Cache), the size of the data itself is checked.
+//
+// Keys are prefixed with keyPrefix, so that a single Redis server may
+// be shared by multiple storage backends.
+func NewRedisBlobAccess(redisClient RedisClient, readBufferFactory ReadBufferFactory, digestKeyFormat digest.KeyFormat, keyPrefix string, maximumSizeBytes int64, contentAddressed bool, keyTTL time.Duration, replicationCount int64, replicationTimeout time.Duration) BlobAccess {
 	return &redisBlobAccess{
 		redisClient:        redisClient,
 		readBufferFactory:  readBufferFactory,
 		digestKeyFormat:    digestKeyFormat,
+		keyPrefix:          keyPrefix,
+		maximumSizeBytes:   maximumSizeBytes,
+		contentAddressed:   contentAddressed,
 		keyTTL:             keyTTL,
 		replicationCount:   int64(replicationCount),
 		replicationTimeout: int(replicationTimeout.Milliseconds()),
 	}
 }
 
+func (ba *redisBlobAccess) getKey(digest digest.Digest) string {
+	return ba.keyPrefix + digest.GetKey(ba.digestKeyFormat)
+}
+
+func (ba *redisBlobAccess) checkSize(sizeBytes int64) error {
+	if sizeBytes > ba.maximumSizeBytes {
+		return status.Errorf(codes.InvalidArgument, "Blob is %d bytes in size, while this backend is only capable of storing blobs of up to %d bytes in size", sizeBytes, ba.maximumSizeBytes)
+	}
+	return nil
+}
+
 func (ba *redisBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
 	if err := util.StatusFromContext(ctx); err != nil {
 		return buffer.NewBufferFromError(err)
 	}
-	key := digest.GetKey(ba.digestKeyFormat)
+	if ba.contentAddressed {
+		if err := ba.checkSize(digest.GetSizeBytes()); err != nil {
+			return buffer.NewBufferFromError(err)
+		}
+	}
+	key := ba.getKey(digest)
 	value, err := ba.redisClient.Get(key).Bytes()
 	if err == redis.Nil {
 		return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.NotFound, "Blob not found"))
 	} else if err != nil {
 		return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.Unavailable, "Failed to get blob"))
 	}
+	if err := ba.checkSize(int64(len(value))); err != nil {
+		return buffer.NewBufferFromError(err)
+	}
 	return ba.readBufferFactory.NewBufferFromByteSlice(
 		digest,
 		value,
@@ -73,12 +115,26 @@ func (ba *redisBlobAccess) Put(ctx context.Context, digest digest.Digest, b buff
 		b.Discard()
 		return err
 	}
-	// Redis can only store values up to 512 MiB in size.
-	value, err := b.ToByteSlice(512 * 1024 * 1024)
+	if ba.contentAddressed {
+		if err := ba.checkSize(digest.GetSizeBytes()); err != nil {
+			b.Discard()
+			return err
+		}
+	}
+	if sizeBytes, err := b.GetSizeBytes(); err == nil {
+		if err := ba.checkSize(sizeBytes); err != nil {
+			b.Discard()
+			return err
+		}
+	}
+	// Extracting the contents of the buffer causes it to be
+	// validated against the digest. Preserve the error code, so
+	// that clients can distinguish invalid data from outages.
+	value, err := b.ToByteSlice(int(ba.maximumSizeBytes))
 	if err != nil {
-		return util.StatusWrapWithCode(err, codes.Unavailable, "Failed to put blob")
+		return util.StatusWrap(err, "Failed to put blob")
 	}
-	if err := ba.redisClient.Set(digest.GetKey(ba.digestKeyFormat), value, ba.keyTTL).Err(); err != nil {
+	if err := ba.redisClient.Set(ba.getKey(digest), value, ba.keyTTL).Err(); err != nil {
 		return util.StatusWrapWithCode(err, codes.Unavailable, "Failed to put blob")
 	}
 	return ba.waitIfReplicationEnabled()
@@ -106,30 +162,36 @@ func (ba *redisBlobAccess) waitIfReplicationEnabled() error {
 }
 
 func (ba *redisBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	missing, _, err := ba.FindMissingAndPresent(ctx, digests)
+	return missing, err
+}
+
+func (ba *redisBlobAccess) FindMissingAndPresent(ctx context.Context, digests digest.Set) (digest.Set, digest.Set, error) {
 	if err := util.StatusFromContext(ctx); err != nil {
-		return digest.EmptySet, err
+		return digest.EmptySet, digest.EmptySet, err
 	}
 	if digests.Empty() {
-		return digest.EmptySet, nil
+		return digest.EmptySet, digest.EmptySet, nil
 	}
 
 	// Execute "EXISTS" requests all in a single pipeline.
 	pipeline := ba.redisClient.Pipeline()
 	cmds := make([]*redis.IntCmd, 0, digests.Length())
 	for _, digest := range digests.Items() {
-		cmds = append(cmds, pipeline.Exists(digest.GetKey(ba.digestKeyFormat)))
+		cmds = append(cmds, pipeline.Exists(ba.getKey(digest)))
 	}
 	if _, err := pipeline.Exec(); err != nil {
-		return digest.EmptySet, util.StatusWrapWithCode(err, codes.Unavailable, "Failed to find missing blobs")
+		return digest.EmptySet, digest.EmptySet, util.StatusWrapWithCode(err, codes.Unavailable, "Failed to find missing blobs")
 	}
 
 	missing := digest.NewSetBuilder()
-	i := 0
-	for _, digest := range digests.Items() {
+	present := digest.NewSetBuilder()
+	for i, digest := range digests.Items() {
 		if cmds[i].Val() == 0 {
 			missing.Add(digest)
+		} else {
+			present.Add(digest)
 		}
-		i++
 	}
-	return missing.Build(), nil
+	return missing.Build(), present.Build(), nil
 }
@@ -3,12 +3,16 @@ package blobstore_test
 import (
 	"context"
 	"testing"
+	"time"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/go-redis/redis"
 	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/require"
 
 	"google.golang.org/grpc/codes"
@@ -19,7 +23,7 @@ func TestRedisBlobAccessContextCanceled(t *testing.T) {
 	ctrl, ctx := gomock.WithContext(context.Background(), t)
 
 	redisClient := mock.NewMockRedisClient(ctrl)
-	blobAccess := blobstore.NewRedisBlobAccess(redisClient, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, 0, 0, 0)
+	blobAccess := blobstore.NewRedisBlobAccess(redisClient, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, "", blobstore.RedisMaximumValueSizeBytes, true, 0, 0, 0)
 
 	canceledCtx, cancel := context.WithCancel(ctx)
 	cancel()
@@ -42,3 +46,133 @@ func TestRedisBlobAccessContextCanceled(t *testing.T) {
 	_, err = blobAccess.FindMissing(canceledCtx, digest.EmptySet)
 	require.Equal(t, err, status.Error(codes.Canceled, "context canceled"))
 }
+
+func TestRedisBlobAccessGet(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	redisClient := mock.NewMockRedisClient(ctrl)
+	blobAccess := blobstore.NewRedisBlobAccess(redisClient, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, "cas:", 10, true, 0, 0, 0)
+
+	t.Run("TooLarge", func(t *testing.T) {
+		// Blobs exceeding the maximum size should be rejected
+		// without contacting Redis.
+		_, err := blobAccess.Get(ctx, digest.MustNewDigest("hello", "a59a1ee2c9bc3c8ef6d3fc7a0e0f1e4b", 11)).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Blob is 11 bytes in size, while this backend is only capable of storing blobs of up to 10 bytes in size"), err)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		redisClient.EXPECT().Get("cas:8b1a9953c4611296a827abf8c47804d7-5").
+			Return(redis.NewStringResult("", redis.Nil))
+
+		_, err := blobAccess.Get(ctx, digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Blob not found: redis: nil"), err)
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		// Corrupted data stored in Redis should be detected
+		// and removed.
+		redisClient.EXPECT().Get("cas:8b1a9953c4611296a827abf8c47804d7-5").
+			Return(redis.NewStringResult("Hallo", nil))
+		redisClient.EXPECT().Del("cas:8b1a9953c4611296a827abf8c47804d7-5").
+			Return(redis.NewIntResult(1, nil))
+
+		_, err := blobAccess.Get(ctx, digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)).ToByteSlice(100)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected")), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		redisClient.EXPECT().Get("cas:8b1a9953c4611296a827abf8c47804d7-5").
+			Return(redis.NewStringResult("Hello", nil))
+
+		data, err := blobAccess.Get(ctx, digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+}
+
+func TestRedisBlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	redisClient := mock.NewMockRedisClient(ctrl)
+	blobAccess := blobstore.NewRedisBlobAccess(redisClient, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, "cas:", 10, true, 0, 0, 0)
+
+	t.Run("TooLarge", func(t *testing.T) {
+		err := blobAccess.Put(
+			ctx,
+			digest.MustNewDigest("hello", "a59a1ee2c9bc3c8ef6d3fc7a0e0f1e4b", 11),
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+		require.Equal(t, status.Error(codes.InvalidArgument, "Blob is 11 bytes in size, while this backend is only capable of storing blobs of up to 10 bytes in size"), err)
+	})
+
+	t.Run("InvalidData", func(t *testing.T) {
+		// Data provided by the client should be validated
+		// before being stored. The error code should be
+		// preserved.
+		err := blobAccess.Put(
+			ctx,
+			digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5),
+			buffer.NewCASBufferFromByteSlice(
+				digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5),
+				[]byte("Hallo"),
+				buffer.UserProvided))
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.InvalidArgument, "Failed to put blob: Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected")), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		redisClient.EXPECT().Set("cas:8b1a9953c4611296a827abf8c47804d7-5", []byte("Hello"), time.Duration(0)).
+			Return(redis.NewStatusResult("OK", nil))
+
+		require.NoError(t, blobAccess.Put(
+			ctx,
+			digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5),
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+}
+
+func TestRedisBlobAccessActionCache(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	redisClient := mock.NewMockRedisClient(ctrl)
+	blobAccess := blobstore.NewRedisBlobAccess(redisClient, blobstore.NewACReadBufferFactory(100, false), digest.KeyWithInstance, "ac:", 10, false, 0, 0, 0)
+	actionDigest := digest.MustNewDigest("hello", "a59a1ee2c9bc3c8ef6d3fc7a0e0f1e4b", 123)
+
+	// The size of an action's digest has no relation to the size
+	// of its action result. Entries should therefore not be
+	// rejected based on the size stored in the digest.
+	t.Run("PutSuccess", func(t *testing.T) {
+		redisClient.EXPECT().Set("ac:a59a1ee2c9bc3c8ef6d3fc7a0e0f1e4b-123-hello", []byte{0x20, 0x01}, time.Duration(0)).
+			Return(redis.NewStatusResult("OK", nil))
+
+		require.NoError(t, blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{
+			ExitCode: 1,
+		}, buffer.UserProvided)))
+	})
+
+	t.Run("PutTooLarge", func(t *testing.T) {
+		// The size of the action result itself should be
+		// checked instead.
+		err := blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{
+			StdoutRaw: []byte("Hello world"),
+		}, buffer.UserProvided))
+		require.Equal(t, status.Error(codes.InvalidArgument, "Blob is 13 bytes in size, while this backend is only capable of storing blobs of up to 10 bytes in size"), err)
+	})
+
+	t.Run("GetSuccess", func(t *testing.T) {
+		redisClient.EXPECT().Get("ac:a59a1ee2c9bc3c8ef6d3fc7a0e0f1e4b-123-hello").
+			Return(redis.NewStringResult("\x20\x01", nil))
+
+		actionResult, err := blobAccess.Get(ctx, actionDigest).ToProto(&remoteexecution.ActionResult{}, 100)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(&remoteexecution.ActionResult{
+			ExitCode: 1,
+		}, actionResult))
+	})
+
+	t.Run("GetTooLarge", func(t *testing.T) {
+		redisClient.EXPECT().Get("ac:a59a1ee2c9bc3c8ef6d3fc7a0e0f1e4b-123-hello").
+			Return(redis.NewStringResult("Hello world", nil))
+
+		_, err := blobAccess.Get(ctx, actionDigest).ToProto(&remoteexecution.ActionResult{}, 100)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Blob is 11 bytes in size, while this backend is only capable of storing blobs of up to 10 bytes in size"), err)
+	})
+}
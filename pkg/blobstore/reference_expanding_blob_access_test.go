@@ -144,7 +144,7 @@ func TestReferenceExpandingBlobAccessGet(t *testing.T) {
 		body.EXPECT().Close()
 
 		_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
-		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected"), err)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected")), err)
 	})
 
 	t.Run("HTTPSuccessPlain", func(t *testing.T) {
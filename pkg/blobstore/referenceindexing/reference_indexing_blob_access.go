@@ -0,0 +1,204 @@
+package referenceindexing
+
+import (
+	"context"
+	"sync"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	referenceIndexingBlobAccessPrometheusMetrics sync.Once
+
+	referenceIndexingBlobAccessIndexingFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "reference_indexing_blob_access_indexing_failures_total",
+			Help:      "Number of action results written into the Action Cache for which references could not be recorded in the index.",
+		})
+)
+
+// ReferenceIndex is a store of references from entries in the Action
+// Cache (AC) to objects in the Content Addressable Storage (CAS). It
+// can be used to determine which action results keep a given object
+// alive, which is needed to implement mark-and-sweep garbage
+// collection of the CAS.
+type ReferenceIndex interface {
+	// AddReferences records that the action result stored under
+	// actionDigest references all of the objects in
+	// referencedDigests.
+	AddReferences(ctx context.Context, actionDigest digest.Digest, referencedDigests digest.Set) error
+	// GetReferencingActions returns the digests of all actions
+	// whose action results reference a given object.
+	GetReferencingActions(ctx context.Context, blobDigest digest.Digest) (digest.Set, error)
+}
+
+// referenceCollector is a helper for collecting the digests of all
+// objects referenced by an action result, while ensuring that the
+// number of digests remains bounded.
+type referenceCollector struct {
+	instanceName      digest.InstanceName
+	maximumReferences int
+
+	references digest.SetBuilder
+}
+
+func (rc *referenceCollector) deriveDigest(blobDigest *remoteexecution.Digest) (digest.Digest, error) {
+	derivedDigest, err := rc.instanceName.NewDigestFromProto(blobDigest)
+	if err != nil {
+		return digest.BadDigest, util.StatusWrap(err, "Action result contained malformed digest")
+	}
+	return derivedDigest, nil
+}
+
+func (rc *referenceCollector) add(blobDigest *remoteexecution.Digest) error {
+	if blobDigest == nil {
+		return nil
+	}
+	derivedDigest, err := rc.deriveDigest(blobDigest)
+	if err != nil {
+		return err
+	}
+	if rc.references.Length() >= rc.maximumReferences {
+		return status.Errorf(codes.ResourceExhausted, "Action result references more than %d objects", rc.maximumReferences)
+	}
+	rc.references.Add(derivedDigest)
+	return nil
+}
+
+func (rc *referenceCollector) addDirectory(directory *remoteexecution.Directory) error {
+	if directory == nil {
+		return nil
+	}
+	for _, child := range directory.Files {
+		if err := rc.add(child.Digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type referenceIndexingBlobAccess struct {
+	blobstore.BlobAccess
+	contentAddressableStorage blobstore.BlobAccess
+	index                     ReferenceIndex
+	maximumMessageSizeBytes   int
+	maximumReferences         int
+	errorLogger               util.ErrorLogger
+}
+
+// NewReferenceIndexingBlobAccess creates a wrapper around an Action
+// Cache (AC) that records the objects in the Content Addressable
+// Storage (CAS) that are referenced by ActionResult messages that are
+// written. This includes objects contained in output directories,
+// which requires that remoteexecution.Tree objects are loaded from the
+// CAS.
+//
+// Recording references is performed on a best-effort basis. If an
+// ActionResult references more than maximumReferences objects or if
+// writing into the index fails, the failure is reported through the
+// provided ErrorLogger, but Put() still succeeds.
+func NewReferenceIndexingBlobAccess(actionCache blobstore.BlobAccess, contentAddressableStorage blobstore.BlobAccess, index ReferenceIndex, maximumMessageSizeBytes int, maximumReferences int, errorLogger util.ErrorLogger) blobstore.BlobAccess {
+	referenceIndexingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(referenceIndexingBlobAccessIndexingFailures)
+	})
+
+	return &referenceIndexingBlobAccess{
+		BlobAccess:                actionCache,
+		contentAddressableStorage: contentAddressableStorage,
+		index:                     index,
+		maximumMessageSizeBytes:   maximumMessageSizeBytes,
+		maximumReferences:         maximumReferences,
+		errorLogger:               errorLogger,
+	}
+}
+
+func (ba *referenceIndexingBlobAccess) indexReferences(ctx context.Context, actionDigest digest.Digest, actionResult *remoteexecution.ActionResult) error {
+	collector := referenceCollector{
+		instanceName:      actionDigest.GetInstanceName(),
+		maximumReferences: ba.maximumReferences,
+		references:        digest.NewSetBuilder(),
+	}
+	for _, outputFile := range actionResult.OutputFiles {
+		if err := collector.add(outputFile.Digest); err != nil {
+			return err
+		}
+	}
+	for _, outputDirectory := range actionResult.OutputDirectories {
+		if err := collector.add(outputDirectory.TreeDigest); err != nil {
+			return err
+		}
+	}
+	if err := collector.add(actionResult.StdoutDigest); err != nil {
+		return err
+	}
+	if err := collector.add(actionResult.StderrDigest); err != nil {
+		return err
+	}
+
+	// Recurse into output directories, so that files contained in
+	// them are indexed as well.
+	for _, outputDirectory := range actionResult.OutputDirectories {
+		treeDigest, err := collector.deriveDigest(outputDirectory.TreeDigest)
+		if err != nil {
+			return err
+		}
+		treeMessage, err := ba.contentAddressableStorage.Get(ctx, treeDigest).ToProto(&remoteexecution.Tree{}, ba.maximumMessageSizeBytes)
+		if err != nil {
+			return util.StatusWrapf(err, "Failed to fetch output directory %#v", outputDirectory.Path)
+		}
+		tree := treeMessage.(*remoteexecution.Tree)
+		if err := collector.addDirectory(tree.Root); err != nil {
+			return err
+		}
+		for _, child := range tree.Children {
+			if err := collector.addDirectory(child); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := ba.index.AddReferences(ctx, actionDigest, collector.references.Build()); err != nil {
+		return util.StatusWrap(err, "Failed to add references to index")
+	}
+	return nil
+}
+
+func (ba *referenceIndexingBlobAccess) Put(ctx context.Context, actionDigest digest.Digest, b buffer.Buffer) error {
+	// Parse the action result while it is being written into the
+	// backend, so that its contents don't need to be copied.
+	b1, b2 := b.CloneStream()
+	var actionResult proto.Message
+	var indexErr error
+	parsed := make(chan struct{})
+	go func() {
+		actionResult, indexErr = b1.ToProto(&remoteexecution.ActionResult{}, ba.maximumMessageSizeBytes)
+		close(parsed)
+	}()
+	err := ba.BlobAccess.Put(ctx, actionDigest, b2)
+	<-parsed
+	if err != nil {
+		return err
+	}
+
+	// Indexing is performed on a best-effort basis. Failures are
+	// only reported, as the action result has been stored.
+	if indexErr == nil {
+		indexErr = ba.indexReferences(ctx, actionDigest, actionResult.(*remoteexecution.ActionResult))
+	}
+	if indexErr != nil {
+		referenceIndexingBlobAccessIndexingFailures.Inc()
+		ba.errorLogger.Log(util.StatusWrapf(indexErr, "Failed to index references of action result %#v", actionDigest.String()))
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package referenceindexing_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/referenceindexing"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReferenceIndexingBlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	actionCache := mock.NewMockBlobAccess(ctrl)
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	referenceIndex := mock.NewMockReferenceIndex(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	blobAccess := referenceindexing.NewReferenceIndexingBlobAccess(
+		actionCache,
+		contentAddressableStorage,
+		referenceIndex,
+		1000,
+		3,
+		errorLogger)
+
+	actionDigest := digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 123)
+	actionResult := &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{
+				Path: "bazel-out/foo.o",
+				Digest: &remoteexecution.Digest{
+					Hash:      "8b1a9953c4611296a827abf8c47804d7",
+					SizeBytes: 5,
+				},
+			},
+		},
+		OutputDirectories: []*remoteexecution.OutputDirectory{
+			{
+				Path: "bazel-out/foo",
+				TreeDigest: &remoteexecution.Digest{
+					Hash:      "6fc422233a40a75a1f028e11c3cd1140",
+					SizeBytes: 7,
+				},
+			},
+		},
+	}
+	treeDigest := digest.MustNewDigest("hello", "6fc422233a40a75a1f028e11c3cd1140", 7)
+
+	t.Run("ActionCacheFailure", func(t *testing.T) {
+		// Errors on the backing action cache should be passed
+		// on directly, without updating the index.
+		actionCache.EXPECT().Put(ctx, actionDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.Internal, "Disk on fire")
+			})
+
+		require.Equal(
+			t,
+			status.Error(codes.Internal, "Disk on fire"),
+			blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(actionResult, buffer.UserProvided)))
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Files contained in output directories should be
+		// indexed, in addition to the Tree objects themselves.
+		actionCache.EXPECT().Put(ctx, actionDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		contentAddressableStorage.EXPECT().Get(ctx, treeDigest).Return(
+			buffer.NewProtoBufferFromProto(&remoteexecution.Tree{
+				Root: &remoteexecution.Directory{
+					Files: []*remoteexecution.FileNode{
+						{
+							Name: "bar",
+							Digest: &remoteexecution.Digest{
+								Hash:      "ac4e33cf3f7dcba8ee8bde4be4e5f4bd",
+								SizeBytes: 42,
+							},
+						},
+					},
+				},
+			}, buffer.UserProvided))
+		referenceIndex.EXPECT().AddReferences(
+			ctx,
+			actionDigest,
+			digest.NewSetBuilder().
+				Add(digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Add(treeDigest).
+				Add(digest.MustNewDigest("hello", "ac4e33cf3f7dcba8ee8bde4be4e5f4bd", 42)).
+				Build())
+
+		require.NoError(t, blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(actionResult, buffer.UserProvided)))
+	})
+
+	t.Run("TooManyReferences", func(t *testing.T) {
+		// Action results that reference too many objects should
+		// not be indexed. This should not cause Put() to fail.
+		actionCache.EXPECT().Put(ctx, actionDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		contentAddressableStorage.EXPECT().Get(ctx, treeDigest).Return(
+			buffer.NewProtoBufferFromProto(&remoteexecution.Tree{
+				Root: &remoteexecution.Directory{
+					Files: []*remoteexecution.FileNode{
+						{
+							Name: "bar",
+							Digest: &remoteexecution.Digest{
+								Hash:      "ac4e33cf3f7dcba8ee8bde4be4e5f4bd",
+								SizeBytes: 42,
+							},
+						},
+						{
+							Name: "baz",
+							Digest: &remoteexecution.Digest{
+								Hash:      "a4d0c7e5b5bfb5d1a6f7e4b8a9c0d1e2",
+								SizeBytes: 12,
+							},
+						},
+					},
+				},
+			}, buffer.UserProvided))
+		errorLogger.EXPECT().Log(status.Error(codes.ResourceExhausted, "Failed to index references of action result \"d41d8cd98f00b204e9800998ecf8427e-123-hello\": Action result references more than 3 objects"))
+
+		require.NoError(t, blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(actionResult, buffer.UserProvided)))
+	})
+
+	t.Run("IndexFailure", func(t *testing.T) {
+		// Failures writing into the index should not cause
+		// Put() to fail either.
+		actionCache.EXPECT().Put(ctx, actionDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		contentAddressableStorage.EXPECT().Get(ctx, treeDigest).Return(
+			buffer.NewProtoBufferFromProto(&remoteexecution.Tree{}, buffer.UserProvided))
+		referenceIndex.EXPECT().AddReferences(ctx, actionDigest, gomock.Any()).
+			Return(status.Error(codes.Unavailable, "Index offline"))
+		errorLogger.EXPECT().Log(status.Error(codes.Unavailable, "Failed to index references of action result \"d41d8cd98f00b204e9800998ecf8427e-123-hello\": Failed to add references to index: Index offline"))
+
+		require.NoError(t, blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(actionResult, buffer.UserProvided)))
+	})
+}
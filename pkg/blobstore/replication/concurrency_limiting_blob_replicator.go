@@ -0,0 +1,75 @@
+package replication
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+type concurrencyLimitingBlobReplicator struct {
+	source blobstore.BlobAccess
+	base   BlobReplicator
+	slots  chan struct{}
+}
+
+// NewConcurrencyLimitingBlobReplicator creates a decorator for
+// BlobReplicator that places a limit on the number of replication
+// operations that run concurrently.
+//
+// Calls to ReplicateSingle() are made by clients that are waiting for
+// data. Instead of letting them wait for a slot to become available,
+// they are served from the source directly in case the limit has been
+// reached, without performing any replication. This makes this
+// decorator suitable for ReadCachingBlobAccess, where a burst of cache
+// misses should not cause the cache backend to be overwhelmed.
+// Calls to ReplicateMultiple() do block until a slot is available.
+func NewConcurrencyLimitingBlobReplicator(source blobstore.BlobAccess, base BlobReplicator, maximumConcurrency int) BlobReplicator {
+	return &concurrencyLimitingBlobReplicator{
+		source: source,
+		base:   base,
+		slots:  make(chan struct{}, maximumConcurrency),
+	}
+}
+
+func (br *concurrencyLimitingBlobReplicator) ReplicateSingle(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	select {
+	case br.slots <- struct{}{}:
+	default:
+		return br.source.Get(ctx, blobDigest)
+	}
+
+	// Only release the slot once the caller is done consuming the
+	// buffer, as the replication runs at the caller's pace.
+	return buffer.WithErrorHandler(
+		br.base.ReplicateSingle(ctx, blobDigest),
+		&slotReleasingErrorHandler{slots: br.slots})
+}
+
+func (br *concurrencyLimitingBlobReplicator) ReplicateMultiple(ctx context.Context, digests digest.Set) error {
+	select {
+	case br.slots <- struct{}{}:
+	case <-ctx.Done():
+		return util.StatusFromContext(ctx)
+	}
+	defer func() { <-br.slots }()
+	return br.base.ReplicateMultiple(ctx, digests)
+}
+
+// slotReleasingErrorHandler is an implementation of
+// buffer.ErrorHandler that does not alter errors. It is merely used to
+// get notified when the buffer returned by ReplicateSingle() is done
+// being consumed.
+type slotReleasingErrorHandler struct {
+	slots <-chan struct{}
+}
+
+func (eh *slotReleasingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	return nil, err
+}
+
+func (eh *slotReleasingErrorHandler) Done() {
+	<-eh.slots
+}
@@ -0,0 +1,82 @@
+package replication_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConcurrencyLimitingBlobReplicatorReplicateSingle(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	source := mock.NewMockBlobAccess(ctrl)
+	baseReplicator := mock.NewMockBlobReplicator(ctrl)
+	replicator := replication.NewConcurrencyLimitingBlobReplicator(source, baseReplicator, 1)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	// The first call should be forwarded to the base replicator.
+	// As the buffer isn't consumed yet, it keeps holding on to the
+	// only slot that is available.
+	chunkReader := mock.NewMockChunkReader(ctrl)
+	baseReplicator.EXPECT().ReplicateSingle(ctx, helloDigest).Return(
+		buffer.NewCASBufferFromChunkReader(helloDigest, chunkReader, buffer.BackendProvided(buffer.Irreparable(helloDigest))))
+	b1 := replicator.ReplicateSingle(ctx, helloDigest)
+
+	// The second call should be served from the source directly.
+	source.EXPECT().Get(ctx, helloDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+	data, err := replicator.ReplicateSingle(ctx, helloDigest).ToByteSlice(10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+
+	// Consuming the first buffer should release the slot, causing
+	// subsequent calls to be forwarded to the base replicator again.
+	chunkReader.EXPECT().Read().Return([]byte("Hello"), nil)
+	chunkReader.EXPECT().Read().Return(nil, status.Error(codes.Internal, "Server on fire"))
+	chunkReader.EXPECT().Close()
+	_, err = b1.ToByteSlice(10)
+	require.Equal(t, status.Error(codes.Internal, "Server on fire"), err)
+
+	baseReplicator.EXPECT().ReplicateSingle(ctx, helloDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+	data, err = replicator.ReplicateSingle(ctx, helloDigest).ToByteSlice(10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+}
+
+func TestConcurrencyLimitingBlobReplicatorReplicateMultiple(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	source := mock.NewMockBlobAccess(ctrl)
+	baseReplicator := mock.NewMockBlobReplicator(ctrl)
+	replicator := replication.NewConcurrencyLimitingBlobReplicator(source, baseReplicator, 1)
+	digests := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5).ToSingletonSet()
+
+	t.Run("Success", func(t *testing.T) {
+		baseReplicator.EXPECT().ReplicateMultiple(gomock.Any(), digests)
+		require.NoError(t, replicator.ReplicateMultiple(context.Background(), digests))
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		// While a slot is occupied, other calls should block
+		// until their context is cancelled.
+		ctx, cancel := context.WithCancel(context.Background())
+		baseReplicator.EXPECT().ReplicateMultiple(gomock.Any(), digests).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) error {
+				cancel()
+				require.Equal(
+					t,
+					status.Error(codes.Canceled, "context canceled"),
+					replicator.ReplicateMultiple(ctx, digests))
+				return nil
+			})
+		require.NoError(t, replicator.ReplicateMultiple(ctx, digests))
+	})
+}
@@ -0,0 +1,173 @@
+package blobstore
+
+import (
+	"context"
+	"io"
+	"path"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	cloud_aws "github.com/buildbarn/bb-storage/pkg/cloud/aws"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type s3BlobAccess struct {
+	s3                 cloud_aws.S3
+	uploader           cloud_aws.S3Uploader
+	bucket             *string
+	keyPrefix          string
+	readBufferFactory  ReadBufferFactory
+	maximumConcurrency int
+}
+
+// NewS3BlobAccess creates a BlobAccess that stores blobs as objects in
+// an S3 bucket. Objects are named after the instance name, hash and
+// size of the digest of the blob, meaning that data is not shared
+// between instance names.
+//
+// Objects are written using the S3 upload manager, so that data can be
+// streamed into the bucket without loading blobs into memory in their
+// entirety. Calls to FindMissing() are translated to HeadObject()
+// calls, of which at most maximumConcurrency are performed in
+// parallel.
+func NewS3BlobAccess(s3 cloud_aws.S3, uploader cloud_aws.S3Uploader, bucket string, keyPrefix string, readBufferFactory ReadBufferFactory, maximumConcurrency int) BlobAccess {
+	return &s3BlobAccess{
+		s3:                 s3,
+		uploader:           uploader,
+		bucket:             aws.String(bucket),
+		keyPrefix:          keyPrefix,
+		readBufferFactory:  readBufferFactory,
+		maximumConcurrency: maximumConcurrency,
+	}
+}
+
+func (ba *s3BlobAccess) getKey(blobDigest digest.Digest) *string {
+	return aws.String(ba.keyPrefix + path.Join(
+		blobDigest.GetInstanceName().String(),
+		blobDigest.GetHashString(),
+		strconv.FormatInt(blobDigest.GetSizeBytes(), 10)))
+}
+
+// convertS3Error converts an error returned by the AWS SDK to a gRPC
+// status.
+func convertS3Error(err error, msg string) error {
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return status.Error(codes.NotFound, msg+": Object not found")
+		}
+	}
+	return util.StatusWrapWithCode(err, codes.Unavailable, msg)
+}
+
+func (ba *s3BlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	output, err := ba.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: ba.bucket,
+		Key:    ba.getKey(digest),
+	})
+	if err != nil {
+		return buffer.NewBufferFromError(convertS3Error(err, "Failed to get object"))
+	}
+	return ba.readBufferFactory.NewBufferFromReader(digest, output.Body, buffer.Irreparable(digest))
+}
+
+// errorCapturingReader is a decorator for io.Reader that stores the
+// first error returned by the underlying reader. The AWS SDK wraps
+// errors returned by readers, causing their status codes to be lost.
+type errorCapturingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *errorCapturingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (ba *s3BlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	r := b.ToReader()
+	defer r.Close()
+
+	body := errorCapturingReader{r: r}
+	if _, err := ba.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: ba.bucket,
+		Key:    ba.getKey(digest),
+		Body:   &body,
+	}); err != nil {
+		// Errors that occurred while reading the buffer (e.g.,
+		// checksum mismatches) should be returned as is, so
+		// that they aren't mistaken for S3 being unavailable.
+		if body.err != nil {
+			return body.err
+		}
+		return convertS3Error(err, "Failed to upload object")
+	}
+	return nil
+}
+
+func (ba *s3BlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	if digests.Empty() {
+		return digest.EmptySet, nil
+	}
+
+	// Call HeadObject() for every digest using a bounded number of
+	// workers. Stop processing digests after the first error.
+	ctxWithCancel, cancel := context.WithCancel(ctx)
+	defer cancel()
+	digestsChannel := make(chan digest.Digest)
+	go func() {
+		defer close(digestsChannel)
+		for _, blobDigest := range digests.Items() {
+			select {
+			case digestsChannel <- blobDigest:
+			case <-ctxWithCancel.Done():
+				return
+			}
+		}
+	}()
+
+	var lock sync.Mutex
+	var firstErr error
+	missing := digest.NewSetBuilder()
+	var wg sync.WaitGroup
+	for i := 0; i < ba.maximumConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for blobDigest := range digestsChannel {
+				if _, err := ba.s3.HeadObjectWithContext(ctxWithCancel, &s3.HeadObjectInput{
+					Bucket: ba.bucket,
+					Key:    ba.getKey(blobDigest),
+				}); err != nil {
+					err = convertS3Error(err, "Failed to obtain object metadata")
+					lock.Lock()
+					if status.Code(err) == codes.NotFound {
+						missing.Add(blobDigest)
+					} else if firstErr == nil {
+						firstErr = util.StatusWrapf(err, "Digest %#v", blobDigest.String())
+						cancel()
+					}
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return digest.EmptySet, firstErr
+	}
+	return missing.Build(), nil
+}
@@ -0,0 +1,150 @@
+package blobstore_test
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestS3BlobAccessGet(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	s3Client := mock.NewMockS3(ctrl)
+	uploader := mock.NewMockS3Uploader(ctrl)
+	blobAccess := blobstore.NewS3BlobAccess(s3Client, uploader, "mybucket", "cas/", blobstore.CASReadBufferFactory, 2)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("NotFound", func(t *testing.T) {
+		s3Client.EXPECT().GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String("mybucket"),
+			Key:    aws.String("cas/hello/8b1a9953c4611296a827abf8c47804d7/5"),
+		}).Return(nil, awserr.New("NoSuchKey", "The specified key does not exist.", nil))
+
+		_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Failed to get object: Object not found"), err)
+	})
+
+	t.Run("ChecksumFailure", func(t *testing.T) {
+		// Data returned by S3 should be validated.
+		s3Client.EXPECT().GetObjectWithContext(ctx, gomock.Any()).Return(&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(strings.NewReader("Hallo")),
+		}, nil)
+
+		_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.Equal(t, buffer.MarkDataIntegrityError(status.Error(codes.Internal, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected")), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		s3Client.EXPECT().GetObjectWithContext(ctx, gomock.Any()).Return(&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(strings.NewReader("Hello")),
+		}, nil)
+
+		data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+}
+
+func TestS3BlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	s3Client := mock.NewMockS3(ctrl)
+	uploader := mock.NewMockS3Uploader(ctrl)
+	blobAccess := blobstore.NewS3BlobAccess(s3Client, uploader, "mybucket", "cas/", blobstore.CASReadBufferFactory, 2)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Success", func(t *testing.T) {
+		uploader.EXPECT().UploadWithContext(ctx, gomock.Any()).DoAndReturn(
+			func(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+				require.Equal(t, "mybucket", *input.Bucket)
+				require.Equal(t, "cas/hello/8b1a9953c4611296a827abf8c47804d7/5", *input.Key)
+				data, err := ioutil.ReadAll(input.Body)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				return &s3manager.UploadOutput{}, nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("ChecksumFailure", func(t *testing.T) {
+		// The AWS SDK wraps errors returned by the body. The
+		// original error should be returned, as opposed to
+		// reporting that S3 is unavailable.
+		uploader.EXPECT().UploadWithContext(ctx, gomock.Any()).DoAndReturn(
+			func(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+				_, err := ioutil.ReadAll(input.Body)
+				return nil, awserr.New("ReadRequestBody", "read upload data failed", err)
+			})
+
+		require.Equal(
+			t,
+			buffer.MarkDataIntegrityError(status.Error(codes.InvalidArgument, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected")),
+			blobAccess.Put(ctx, helloDigest, buffer.NewCASBufferFromByteSlice(helloDigest, []byte("Hallo"), buffer.UserProvided)))
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		uploader.EXPECT().UploadWithContext(ctx, gomock.Any()).
+			Return(nil, awserr.New("AccessDenied", "Access Denied", nil))
+
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Failed to upload object: AccessDenied: Access Denied"),
+			blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+}
+
+func TestS3BlobAccessFindMissing(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	s3Client := mock.NewMockS3(ctrl)
+	uploader := mock.NewMockS3Uploader(ctrl)
+	blobAccess := blobstore.NewS3BlobAccess(s3Client, uploader, "mybucket", "cas/", blobstore.CASReadBufferFactory, 2)
+	digest1 := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digest2 := digest.MustNewDigest("hello", "6fc422233a40a75a1f028e11c3cd1140", 7)
+	digest3 := digest.MustNewDigest("hello", "00000000000000000000000000000000", 3)
+	allDigests := digest.NewSetBuilder().Add(digest1).Add(digest2).Add(digest3).Build()
+
+	t.Run("Success", func(t *testing.T) {
+		s3Client.EXPECT().HeadObjectWithContext(gomock.Any(), &s3.HeadObjectInput{
+			Bucket: aws.String("mybucket"),
+			Key:    aws.String("cas/hello/8b1a9953c4611296a827abf8c47804d7/5"),
+		}).Return(&s3.HeadObjectOutput{}, nil)
+		s3Client.EXPECT().HeadObjectWithContext(gomock.Any(), &s3.HeadObjectInput{
+			Bucket: aws.String("mybucket"),
+			Key:    aws.String("cas/hello/6fc422233a40a75a1f028e11c3cd1140/7"),
+		}).Return(nil, awserr.New("NotFound", "Not Found", nil))
+		s3Client.EXPECT().HeadObjectWithContext(gomock.Any(), &s3.HeadObjectInput{
+			Bucket: aws.String("mybucket"),
+			Key:    aws.String("cas/hello/00000000000000000000000000000000/3"),
+		}).Return(&s3.HeadObjectOutput{}, nil)
+
+		missing, err := blobAccess.FindMissing(ctx, allDigests)
+		require.NoError(t, err)
+		require.Equal(t, digest2.ToSingletonSet(), missing)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		// Errors other than NotFound should be propagated.
+		s3Client.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).
+			Return(nil, awserr.New("AccessDenied", "Access Denied", nil))
+
+		_, err := blobAccess.FindMissing(ctx, digest1.ToSingletonSet())
+		require.Equal(t, status.Error(codes.Unavailable, "Digest \"8b1a9953c4611296a827abf8c47804d7-5-hello\": Failed to obtain object metadata: AccessDenied: Access Denied"), err)
+	})
+}
@@ -0,0 +1,26 @@
+package sharding
+
+import (
+	"context"
+)
+
+type affinityKeyContextKey struct{}
+
+// WithAffinityKey returns a copy of a context that carries an affinity
+// key. ShardingBlobAccess routes requests made using this context by
+// hashing the affinity key, as opposed to the digest. This can be used
+// to store groups of related blobs (e.g., the outputs of an action) on
+// the same shard, so that FindMissing() calls for these groups only
+// need to contact a single shard.
+//
+// Blobs stored using an affinity key are also stored in the shard
+// selected by their digest, so that they remain accessible to requests
+// that don't provide one.
+func WithAffinityKey(ctx context.Context, affinityKey string) context.Context {
+	return context.WithValue(ctx, affinityKeyContextKey{}, affinityKey)
+}
+
+func getAffinityKey(ctx context.Context) (string, bool) {
+	affinityKey, ok := ctx.Value(affinityKeyContextKey{}).(string)
+	return affinityKey, ok
+}
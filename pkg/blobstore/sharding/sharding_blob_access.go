@@ -2,10 +2,15 @@ package sharding
 
 import (
 	"context"
+	"sync"
 
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type shardingBlobAccess struct {
@@ -18,6 +23,14 @@ type shardingBlobAccess struct {
 // NewShardingBlobAccess is an adapter for BlobAccess that partitions
 // requests across backends by hashing the digest. A ShardPermuter is
 // used to map hashes to backends.
+//
+// If the context of a request carries an affinity key provided to
+// WithAffinityKey(), the affinity key is hashed as well. Put() stores
+// blobs both in the backend selected by the affinity key and the one
+// selected by the digest, so that requests without an affinity key are
+// still capable of accessing them. Get() and FindMissing() first
+// consult the backend selected by the affinity key, falling back to
+// the one selected by the digest for blobs that are absent.
 func NewShardingBlobAccess(backends []blobstore.BlobAccess, shardPermuter ShardPermuter, digestKeyFormat digest.KeyFormat, hashInitialization uint64) blobstore.BlobAccess {
 	return &shardingBlobAccess{
 		backends:           backends,
@@ -27,10 +40,10 @@ func NewShardingBlobAccess(backends []blobstore.BlobAccess, shardPermuter ShardP
 	}
 }
 
-func (ba *shardingBlobAccess) getBackend(digest digest.Digest) blobstore.BlobAccess {
+func (ba *shardingBlobAccess) getBackendForKey(key string) blobstore.BlobAccess {
 	// Hash the key using FNV-1a.
 	h := ba.hashInitialization
-	for _, c := range digest.GetKey(ba.digestKeyFormat) {
+	for _, c := range key {
 		h ^= uint64(c)
 		h *= 1099511628211
 	}
@@ -44,12 +57,55 @@ func (ba *shardingBlobAccess) getBackend(digest digest.Digest) blobstore.BlobAcc
 	return backend
 }
 
+// getBackends returns the backend to which requests for a blob should
+// be routed. If the context carries an affinity key that selects a
+// different backend than the digest, the backend selected by the
+// digest is returned as a fallback.
+func (ba *shardingBlobAccess) getBackends(ctx context.Context, digest digest.Digest) (blobstore.BlobAccess, blobstore.BlobAccess) {
+	digestBackend := ba.getBackendForKey(digest.GetKey(ba.digestKeyFormat))
+	if affinityKey, ok := getAffinityKey(ctx); ok {
+		if affinityBackend := ba.getBackendForKey(affinityKey); affinityBackend != digestBackend {
+			return affinityBackend, digestBackend
+		}
+	}
+	return digestBackend, nil
+}
+
 func (ba *shardingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
-	return ba.getBackend(digest).Get(ctx, digest)
+	backend, fallbackBackend := ba.getBackends(ctx, digest)
+	if fallbackBackend == nil {
+		return backend.Get(ctx, digest)
+	}
+	return buffer.WithErrorHandler(
+		backend.Get(ctx, digest),
+		&affinityFallbackErrorHandler{
+			fallbackBackend: fallbackBackend,
+			context:         ctx,
+			digest:          digest,
+		})
 }
 
 func (ba *shardingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
-	return ba.getBackend(digest).Put(ctx, digest, b)
+	backend, fallbackBackend := ba.getBackends(ctx, digest)
+	if fallbackBackend == nil {
+		return backend.Put(ctx, digest, b)
+	}
+
+	// Store the blob in both backends. Bind the context to both
+	// clones, so that a stalling backend does not prevent the
+	// other backend from returning once the request is cancelled.
+	b1, b2 := b.CloneStream()
+	b1 = buffer.WithContext(b1, ctx)
+	b2 = buffer.WithContext(b2, ctx)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- fallbackBackend.Put(ctx, digest, b2)
+	}()
+	err := backend.Put(ctx, digest, b1)
+	if fallbackErr := <-errChan; err == nil {
+		err = fallbackErr
+	}
+	return err
 }
 
 type findMissingResults struct {
@@ -62,17 +118,7 @@ func callFindMissing(ctx context.Context, blobAccess blobstore.BlobAccess, diges
 	return findMissingResults{missing: missing, err: err}
 }
 
-func (ba *shardingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
-	// Determine which backends to contact.
-	digestsPerBackend := map[blobstore.BlobAccess]digest.SetBuilder{}
-	for _, blobDigest := range digests.Items() {
-		backend := ba.getBackend(blobDigest)
-		if _, ok := digestsPerBackend[backend]; !ok {
-			digestsPerBackend[backend] = digest.NewSetBuilder()
-		}
-		digestsPerBackend[backend].Add(blobDigest)
-	}
-
+func findMissingInBackends(ctx context.Context, digestsPerBackend map[blobstore.BlobAccess]digest.SetBuilder) (digest.Set, error) {
 	// Asynchronously call FindMissing() on backends.
 	resultsChan := make(chan findMissingResults, len(digestsPerBackend))
 	for backend, digests := range digestsPerBackend {
@@ -97,3 +143,90 @@ func (ba *shardingBlobAccess) FindMissing(ctx context.Context, digests digest.Se
 	}
 	return digest.GetUnion(missingDigestSets), nil
 }
+
+func addDigestForBackend(digestsPerBackend map[blobstore.BlobAccess]digest.SetBuilder, backend blobstore.BlobAccess, blobDigest digest.Digest) {
+	if _, ok := digestsPerBackend[backend]; !ok {
+		digestsPerBackend[backend] = digest.NewSetBuilder()
+	}
+	digestsPerBackend[backend].Add(blobDigest)
+}
+
+func (ba *shardingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Determine which backends to contact.
+	digestsPerBackend := map[blobstore.BlobAccess]digest.SetBuilder{}
+	fallbackBackends := map[digest.Digest]blobstore.BlobAccess{}
+	for _, blobDigest := range digests.Items() {
+		backend, fallbackBackend := ba.getBackends(ctx, blobDigest)
+		addDigestForBackend(digestsPerBackend, backend, blobDigest)
+		if fallbackBackend != nil {
+			fallbackBackends[blobDigest] = fallbackBackend
+		}
+	}
+
+	missing, err := findMissingInBackends(ctx, digestsPerBackend)
+	if err != nil || len(fallbackBackends) == 0 {
+		return missing, err
+	}
+
+	// Blobs that are absent in the backend selected by the
+	// affinity key may have been stored without one. Check whether
+	// they are present in the backend selected by the digest.
+	missingWithoutFallback := digest.NewSetBuilder()
+	fallbackDigestsPerBackend := map[blobstore.BlobAccess]digest.SetBuilder{}
+	for _, blobDigest := range missing.Items() {
+		if fallbackBackend, ok := fallbackBackends[blobDigest]; ok {
+			addDigestForBackend(fallbackDigestsPerBackend, fallbackBackend, blobDigest)
+		} else {
+			missingWithoutFallback.Add(blobDigest)
+		}
+	}
+	missingInFallback, err := findMissingInBackends(ctx, fallbackDigestsPerBackend)
+	if err != nil {
+		return digest.EmptySet, err
+	}
+	return digest.GetUnion([]digest.Set{missingWithoutFallback.Build(), missingInFallback}), nil
+}
+
+func (ba *shardingBlobAccess) CheckHealth(ctx context.Context) error {
+	// Asynchronously check the health of all undrained backends.
+	errs := make([]error, len(ba.backends))
+	var wg sync.WaitGroup
+	for i, backend := range ba.backends {
+		if backend != nil {
+			wg.Add(1)
+			go func(i int, backend blobstore.BlobAccess) {
+				errs[i] = blobstore.CheckHealth(ctx, backend)
+				wg.Done()
+			}(i, backend)
+		}
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return util.StatusWrapf(err, "Shard %d", i)
+		}
+	}
+	return nil
+}
+
+// affinityFallbackErrorHandler is an implementation of
+// buffer.ErrorHandler that causes Get() calls for blobs that are
+// absent in the backend selected by the affinity key to be retried
+// against the backend selected by the digest.
+type affinityFallbackErrorHandler struct {
+	fallbackBackend blobstore.BlobAccess
+	context         context.Context
+	digest          digest.Digest
+}
+
+func (eh *affinityFallbackErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	if status.Code(err) != codes.NotFound || eh.fallbackBackend == nil {
+		return nil, err
+	}
+	b := eh.fallbackBackend.Get(eh.context, eh.digest)
+	eh.fallbackBackend = nil
+	return b, nil
+}
+
+func (eh *affinityFallbackErrorHandler) Done() {}
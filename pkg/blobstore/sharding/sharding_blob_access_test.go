@@ -0,0 +1,153 @@
+package sharding_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/sharding"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestShardingBlobAccessAffinityKey(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	// Keep track of which backends receive FindMissing() calls.
+	// These calls are made concurrently.
+	backends := make([]blobstore.BlobAccess, 0, 4)
+	var lock sync.Mutex
+	var contactedBackends map[int]int
+	for i := 0; i < 4; i++ {
+		backend := mock.NewMockBlobAccess(ctrl)
+		index := i
+		backend.EXPECT().FindMissing(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) (digest.Set, error) {
+				lock.Lock()
+				contactedBackends[index] += digests.Length()
+				lock.Unlock()
+				return digest.EmptySet, nil
+			}).AnyTimes()
+		backends = append(backends, backend)
+	}
+	blobAccess := sharding.NewShardingBlobAccess(
+		backends,
+		sharding.NewWeightedShardPermuter([]uint32{1, 1, 1, 1}),
+		digest.KeyWithoutInstance,
+		0x62d3d1e4d3d81ae4)
+
+	digests := digest.NewSetBuilder()
+	for i := 0; i < 20; i++ {
+		digests.Add(digest.MustNewDigest("hello", fmt.Sprintf("%032x", i), 5))
+	}
+
+	t.Run("WithoutAffinityKey", func(t *testing.T) {
+		// Digests should be spread out across backends.
+		contactedBackends = map[int]int{}
+		missing, err := blobAccess.FindMissing(ctx, digests.Build())
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+		require.Greater(t, len(contactedBackends), 1)
+	})
+
+	t.Run("WithAffinityKey", func(t *testing.T) {
+		// All digests should be routed to the same backend.
+		contactedBackends = map[int]int{}
+		missing, err := blobAccess.FindMissing(sharding.WithAffinityKey(ctx, "action1"), digests.Build())
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+		require.Len(t, contactedBackends, 1)
+		for _, count := range contactedBackends {
+			require.Equal(t, 20, count)
+		}
+	})
+}
+
+func TestShardingBlobAccessAffinityKeyFallback(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	backend0 := mock.NewMockBlobAccess(ctrl)
+	backend1 := mock.NewMockBlobAccess(ctrl)
+	blobAccess := sharding.NewShardingBlobAccess(
+		[]blobstore.BlobAccess{backend0, backend1},
+		sharding.NewWeightedShardPermuter([]uint32{1, 1}),
+		digest.KeyWithoutInstance,
+		0x62d3d1e4d3d81ae4)
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	// Determine which backend is selected by the digest, and pick
+	// an affinity key that selects the other backend.
+	var digestBackend, affinityBackend *mock.MockBlobAccess
+	backend0.EXPECT().FindMissing(ctx, blobDigest.ToSingletonSet()).Return(digest.EmptySet, nil).
+		Do(func(ctx context.Context, digests digest.Set) { digestBackend, affinityBackend = backend0, backend1 }).MaxTimes(1)
+	backend1.EXPECT().FindMissing(ctx, blobDigest.ToSingletonSet()).Return(digest.EmptySet, nil).
+		Do(func(ctx context.Context, digests digest.Set) { digestBackend, affinityBackend = backend1, backend0 }).MaxTimes(1)
+	_, err := blobAccess.FindMissing(ctx, blobDigest.ToSingletonSet())
+	require.NoError(t, err)
+
+	var affinityCtx context.Context
+	for i := 0; affinityCtx == nil; i++ {
+		candidateCtx := sharding.WithAffinityKey(ctx, fmt.Sprintf("action%d", i))
+		affinityBackend.EXPECT().FindMissing(candidateCtx, blobDigest.ToSingletonSet()).Return(digest.EmptySet, nil).
+			Do(func(ctx context.Context, digests digest.Set) { affinityCtx = ctx }).MaxTimes(1)
+		digestBackend.EXPECT().FindMissing(candidateCtx, blobDigest.ToSingletonSet()).Return(digest.EmptySet, nil).MaxTimes(1)
+		_, err := blobAccess.FindMissing(candidateCtx, blobDigest.ToSingletonSet())
+		require.NoError(t, err)
+	}
+
+	t.Run("GetFallback", func(t *testing.T) {
+		// Blobs absent in the backend selected by the affinity
+		// key should be read from the one selected by the
+		// digest.
+		affinityBackend.EXPECT().Get(affinityCtx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		digestBackend.EXPECT().Get(affinityCtx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(affinityCtx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		affinityBackend.EXPECT().Get(affinityCtx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		digestBackend.EXPECT().Get(affinityCtx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		_, err := blobAccess.Get(affinityCtx, blobDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("FindMissingFallback", func(t *testing.T) {
+		// Blobs absent in the backend selected by the affinity
+		// key should only be reported missing if they are also
+		// absent in the one selected by the digest.
+		affinityBackend.EXPECT().FindMissing(affinityCtx, blobDigest.ToSingletonSet()).Return(blobDigest.ToSingletonSet(), nil)
+		digestBackend.EXPECT().FindMissing(affinityCtx, blobDigest.ToSingletonSet()).Return(digest.EmptySet, nil)
+
+		missing, err := blobAccess.FindMissing(affinityCtx, blobDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		// Blobs should be stored in both backends, so that
+		// requests without an affinity key can access them.
+		for _, backend := range []*mock.MockBlobAccess{affinityBackend, digestBackend} {
+			backend.EXPECT().Put(gomock.Any(), blobDigest, gomock.Any()).DoAndReturn(
+				func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+					data, err := b.ToByteSlice(100)
+					require.NoError(t, err)
+					require.Equal(t, []byte("Hello"), data)
+					return nil
+				})
+		}
+
+		require.NoError(t, blobAccess.Put(affinityCtx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+}
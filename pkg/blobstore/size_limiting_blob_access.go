@@ -0,0 +1,51 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MaximumSizeBytesGetter is the callback type used by
+// SizeLimitingBlobAccess to obtain an instance name specific limit on
+// the size of blobs. If no limit is returned for an instance name, the
+// default limit is used.
+type MaximumSizeBytesGetter func(instanceName digest.InstanceName) (int64, bool)
+
+type sizeLimitingBlobAccess struct {
+	BlobAccess
+	maximumSizeBytes       int64
+	maximumSizeBytesGetter MaximumSizeBytesGetter
+}
+
+// NewSizeLimitingBlobAccess creates a decorator for BlobAccess that
+// rejects attempts to store blobs whose size exceeds a given limit.
+// This can be used to prevent storage from being consumed by
+// pathologically large uploads. The limit may be overridden on a per
+// instance name basis by providing a MaximumSizeBytesGetter, which may
+// be nil.
+func NewSizeLimitingBlobAccess(base BlobAccess, maximumSizeBytes int64, maximumSizeBytesGetter MaximumSizeBytesGetter) BlobAccess {
+	return &sizeLimitingBlobAccess{
+		BlobAccess:             base,
+		maximumSizeBytes:       maximumSizeBytes,
+		maximumSizeBytesGetter: maximumSizeBytesGetter,
+	}
+}
+
+func (ba *sizeLimitingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	maximumSizeBytes := ba.maximumSizeBytes
+	if ba.maximumSizeBytesGetter != nil {
+		if instanceMaximumSizeBytes, ok := ba.maximumSizeBytesGetter(digest.GetInstanceName()); ok {
+			maximumSizeBytes = instanceMaximumSizeBytes
+		}
+	}
+	if sizeBytes := digest.GetSizeBytes(); sizeBytes > maximumSizeBytes {
+		b.Discard()
+		return status.Errorf(codes.InvalidArgument, "Blob is %d bytes in size, while a maximum of %d bytes is permitted", sizeBytes, maximumSizeBytes)
+	}
+	return ba.BlobAccess.Put(ctx, digest, b)
+}
@@ -0,0 +1,72 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSizeLimitingBlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewSizeLimitingBlobAccess(
+		baseBlobAccess,
+		4,
+		func(instanceName digest.InstanceName) (int64, bool) {
+			if instanceName == digest.MustNewInstanceName("large") {
+				return 10, true
+			}
+			return 0, false
+		})
+
+	t.Run("Success", func(t *testing.T) {
+		// Blobs within the limit should be forwarded.
+		blobDigest := digest.MustNewDigest("hello", "7fc56270e7a70fa81a5935b72eacbe29", 1)
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(10)
+				require.NoError(t, err)
+				require.Equal(t, []byte("A"), data)
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("A"))))
+	})
+
+	t.Run("TooBig", func(t *testing.T) {
+		// Blobs exceeding the limit should be rejected, without
+		// reading any data from the buffer.
+		blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+		chunkReader := mock.NewMockChunkReader(ctrl)
+		chunkReader.EXPECT().Close()
+
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Blob is 5 bytes in size, while a maximum of 4 bytes is permitted"),
+			blobAccess.Put(ctx, blobDigest, buffer.NewCASBufferFromChunkReader(blobDigest, chunkReader, buffer.UserProvided)))
+	})
+
+	t.Run("PerInstanceLimit", func(t *testing.T) {
+		// Instance names may have their own limit.
+		blobDigest := digest.MustNewDigest("large", "8b1a9953c4611296a827abf8c47804d7", 5)
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(10)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+}
@@ -0,0 +1,206 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	speculativeFetchingBlobAccessPrometheusMetrics sync.Once
+
+	speculativeFetchingBlobAccessGets = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "speculative_fetching_blob_access_gets_total",
+			Help:      "Number of Get() calls that were served from blobs fetched speculatively, or were forwarded to the backend.",
+		},
+		[]string{"result"})
+	speculativeFetchingBlobAccessGetsHit  = speculativeFetchingBlobAccessGets.WithLabelValues("Hit")
+	speculativeFetchingBlobAccessGetsMiss = speculativeFetchingBlobAccessGets.WithLabelValues("Miss")
+
+	speculativeFetchingBlobAccessFetches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "speculative_fetching_blob_access_fetches_total",
+			Help:      "Number of blobs reported present by FindMissing() for which a speculative fetch was attempted, or which were skipped.",
+		},
+		[]string{"outcome"})
+	speculativeFetchingBlobAccessFetchesCompleted = speculativeFetchingBlobAccessFetches.WithLabelValues("Completed")
+	speculativeFetchingBlobAccessFetchesFailed    = speculativeFetchingBlobAccessFetches.WithLabelValues("Failed")
+	speculativeFetchingBlobAccessFetchesTooBig    = speculativeFetchingBlobAccessFetches.WithLabelValues("TooBig")
+	speculativeFetchingBlobAccessFetchesSaturated = speculativeFetchingBlobAccessFetches.WithLabelValues("Saturated")
+
+	speculativeFetchingBlobAccessEvictions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "speculative_fetching_blob_access_evictions_total",
+			Help:      "Number of blobs fetched speculatively that were evicted from the cache, partitioned by whether they were used.",
+		},
+		[]string{"used"})
+	speculativeFetchingBlobAccessEvictionsUsed   = speculativeFetchingBlobAccessEvictions.WithLabelValues("true")
+	speculativeFetchingBlobAccessEvictionsUnused = speculativeFetchingBlobAccessEvictions.WithLabelValues("false")
+)
+
+type speculativeFetchingCacheEntry struct {
+	data []byte
+	used bool
+}
+
+type speculativeFetchingBlobAccess struct {
+	BlobAccess
+	fetchContext          context.Context
+	keyFormat             digest.KeyFormat
+	maximumBlobSizeBytes  int64
+	maximumCacheSizeBytes int64
+	slots                 chan struct{}
+
+	lock           sync.Mutex
+	entries        map[string]*speculativeFetchingCacheEntry
+	inFlight       map[string]chan struct{}
+	evictionSet    eviction.Set
+	cacheSizeBytes int64
+}
+
+// NewSpeculativeFetchingBlobAccess creates an experimental decorator
+// for BlobAccess that speculatively fetches blobs that FindMissing()
+// reports as being present. Clients such as Bazel tend to call Get()
+// on such blobs right after calling FindMissing(). Serving these calls
+// from a local cache reduces latency.
+//
+// Calls to Get() for blobs that are still being fetched speculatively
+// wait for the fetch to complete, so that blobs are not fetched twice.
+//
+// Blobs are only fetched if they do not exceed a given size. Fetches
+// that cannot run immediately due to the concurrency limit being
+// reached are skipped, as opposed to queued. Fetched blobs are stored
+// in a cache that is bounded in size, using the provided eviction
+// policy. Speculative fetches run in the context of fetchContext,
+// meaning they can be cancelled by cancelling it.
+//
+// Metrics are exposed on the number of blobs fetched and the number
+// of blobs that were evicted without being used, so that the
+// effectiveness of this decorator can be assessed.
+func NewSpeculativeFetchingBlobAccess(fetchContext context.Context, base BlobAccess, keyFormat digest.KeyFormat, evictionSet eviction.Set, maximumBlobSizeBytes, maximumCacheSizeBytes int64, maximumConcurrency int) BlobAccess {
+	speculativeFetchingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(speculativeFetchingBlobAccessGets)
+		prometheus.MustRegister(speculativeFetchingBlobAccessFetches)
+		prometheus.MustRegister(speculativeFetchingBlobAccessEvictions)
+	})
+
+	return &speculativeFetchingBlobAccess{
+		BlobAccess:            base,
+		fetchContext:          fetchContext,
+		keyFormat:             keyFormat,
+		maximumBlobSizeBytes:  maximumBlobSizeBytes,
+		maximumCacheSizeBytes: maximumCacheSizeBytes,
+		slots:                 make(chan struct{}, maximumConcurrency),
+
+		entries:     map[string]*speculativeFetchingCacheEntry{},
+		inFlight:    map[string]chan struct{}{},
+		evictionSet: evictionSet,
+	}
+}
+
+func (ba *speculativeFetchingBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	key := blobDigest.GetKey(ba.keyFormat)
+	ba.lock.Lock()
+	if fetched, ok := ba.inFlight[key]; ok {
+		// The blob is being fetched speculatively. Wait for the
+		// fetch to complete, instead of fetching it once more.
+		ba.lock.Unlock()
+		select {
+		case <-fetched:
+		case <-ctx.Done():
+			return buffer.NewBufferFromError(util.StatusFromContext(ctx))
+		}
+		ba.lock.Lock()
+	}
+	if entry, ok := ba.entries[key]; ok {
+		entry.used = true
+		ba.evictionSet.Touch(key)
+		ba.lock.Unlock()
+		speculativeFetchingBlobAccessGetsHit.Inc()
+		// The data has already been validated while fetching.
+		return buffer.NewValidatedBufferFromByteSlice(entry.data)
+	}
+	ba.lock.Unlock()
+	speculativeFetchingBlobAccessGetsMiss.Inc()
+	return ba.BlobAccess.Get(ctx, blobDigest)
+}
+
+func (ba *speculativeFetchingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	missing, present, err := FindMissingAndPresent(ctx, ba.BlobAccess, digests)
+	if err != nil {
+		return digest.EmptySet, err
+	}
+
+	for _, blobDigest := range present.Items() {
+		if blobDigest.GetSizeBytes() > ba.maximumBlobSizeBytes || blobDigest.GetSizeBytes() > ba.maximumCacheSizeBytes {
+			speculativeFetchingBlobAccessFetchesTooBig.Inc()
+			continue
+		}
+
+		key := blobDigest.GetKey(ba.keyFormat)
+		ba.lock.Lock()
+		_, isCached := ba.entries[key]
+		_, isInFlight := ba.inFlight[key]
+		if isCached || isInFlight {
+			ba.lock.Unlock()
+			continue
+		}
+
+		select {
+		case ba.slots <- struct{}{}:
+			ba.inFlight[key] = make(chan struct{})
+			ba.lock.Unlock()
+			go ba.fetch(blobDigest, key)
+		default:
+			ba.lock.Unlock()
+			speculativeFetchingBlobAccessFetchesSaturated.Inc()
+		}
+	}
+	return missing, nil
+}
+
+// fetch a single blob from the backend and insert it into the cache.
+func (ba *speculativeFetchingBlobAccess) fetch(blobDigest digest.Digest, key string) {
+	data, err := ba.BlobAccess.Get(ba.fetchContext, blobDigest).ToByteSlice(int(ba.maximumBlobSizeBytes))
+	<-ba.slots
+
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+	close(ba.inFlight[key])
+	delete(ba.inFlight, key)
+	if err != nil {
+		speculativeFetchingBlobAccessFetchesFailed.Inc()
+		return
+	}
+	speculativeFetchingBlobAccessFetchesCompleted.Inc()
+
+	// Free up space to insert the blob.
+	sizeBytes := int64(len(data))
+	for ba.cacheSizeBytes+sizeBytes > ba.maximumCacheSizeBytes {
+		evictedKey := ba.evictionSet.Peek()
+		ba.evictionSet.Remove()
+		evictedEntry := ba.entries[evictedKey]
+		if evictedEntry.used {
+			speculativeFetchingBlobAccessEvictionsUsed.Inc()
+		} else {
+			speculativeFetchingBlobAccessEvictionsUnused.Inc()
+		}
+		ba.cacheSizeBytes -= int64(len(evictedEntry.data))
+		delete(ba.entries, evictedKey)
+	}
+	ba.entries[key] = &speculativeFetchingCacheEntry{data: data}
+	ba.evictionSet.Insert(key)
+	ba.cacheSizeBytes += sizeBytes
+}
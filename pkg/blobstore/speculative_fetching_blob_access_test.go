@@ -0,0 +1,145 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSpeculativeFetchingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewSpeculativeFetchingBlobAccess(
+		context.Background(),
+		baseBlobAccess,
+		digest.KeyWithInstance,
+		eviction.NewLRUSet(),
+		/* maximumBlobSizeBytes = */ 100,
+		/* maximumCacheSizeBytes = */ 15,
+		/* maximumConcurrency = */ 10)
+
+	blobDigest1 := digest.MustNewDigest("hello", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+	blobDigest2 := digest.MustNewDigest("hello", "c015ad6ddaf8bb50689d2d7cbf1539dff6dd84473582a08ed1d15d841f4254f4", 7)
+	blobDigest3 := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7e6c49c6b001de8d0f80b5d9b5e3f5f1e", 1000)
+	blobDigest4 := digest.MustNewDigest("hello", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+
+	t.Run("Speculation", func(t *testing.T) {
+		// Blobs reported present should be fetched, except for
+		// ones that are too large.
+		allDigests := digest.NewSetBuilder().Add(blobDigest1).Add(blobDigest3).Add(blobDigest4).Build()
+		baseBlobAccess.EXPECT().FindMissing(ctx, allDigests).Return(blobDigest4.ToSingletonSet(), nil)
+		baseBlobAccess.EXPECT().Get(gomock.Any(), blobDigest1).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+
+		missing, err := blobAccess.FindMissing(ctx, allDigests)
+		require.NoError(t, err)
+		require.Equal(t, blobDigest4.ToSingletonSet(), missing)
+
+		// The blob should be returned without contacting the
+		// backend once more.
+		data, err := blobAccess.Get(ctx, blobDigest1).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+
+		// Blobs that were not fetched should be obtained from
+		// the backend.
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest3).Return(buffer.NewBufferFromError(status.Error(codes.Internal, "Server on fire")))
+		_, err = blobAccess.Get(ctx, blobDigest3).ToByteSlice(2000)
+		require.Equal(t, status.Error(codes.Internal, "Server on fire"), err)
+	})
+
+	t.Run("Eviction", func(t *testing.T) {
+		// Fetching another blob should cause the first blob to
+		// be evicted, as both don't fit in the cache.
+		baseBlobAccess.EXPECT().FindMissing(ctx, blobDigest2.ToSingletonSet()).Return(digest.EmptySet, nil)
+		baseBlobAccess.EXPECT().Get(gomock.Any(), blobDigest2).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Goodbye")))
+
+		missing, err := blobAccess.FindMissing(ctx, blobDigest2.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+
+		data, err := blobAccess.Get(ctx, blobDigest2).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Goodbye"), data)
+
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest1).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+		data, err = blobAccess.Get(ctx, blobDigest1).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("FetchFailure", func(t *testing.T) {
+		// Failed fetches should not be cached, causing Get() to
+		// be forwarded to the backend.
+		baseBlobAccess.EXPECT().FindMissing(ctx, blobDigest4.ToSingletonSet()).Return(digest.EmptySet, nil)
+		baseBlobAccess.EXPECT().Get(gomock.Any(), blobDigest4).Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+
+		missing, err := blobAccess.FindMissing(ctx, blobDigest4.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest4).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		data, err := blobAccess.Get(ctx, blobDigest4).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("MissingNotFetched", func(t *testing.T) {
+		// Blobs reported missing should not be fetched. As the
+		// backend's Get() is not expected to be called, gomock
+		// fails the test if this were to happen.
+		blobDigest5 := digest.MustNewDigest("hello", "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447", 6)
+		baseBlobAccess.EXPECT().FindMissing(ctx, blobDigest5.ToSingletonSet()).Return(blobDigest5.ToSingletonSet(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, blobDigest5.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, blobDigest5.ToSingletonSet(), missing)
+	})
+}
+
+func TestSpeculativeFetchingBlobAccessOnlyFetchesPresentBlobs(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewSpeculativeFetchingBlobAccess(
+		context.Background(),
+		baseBlobAccess,
+		digest.KeyWithInstance,
+		eviction.NewLRUSet(),
+		/* maximumBlobSizeBytes = */ 100,
+		/* maximumCacheSizeBytes = */ 100,
+		/* maximumConcurrency = */ 10)
+
+	// Regression test: the set of present blobs used to be computed
+	// incorrectly, causing the missing blobs to be fetched instead.
+	presentDigest := digest.MustNewDigest("hello", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+	missingDigest := digest.MustNewDigest("hello", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+	allDigests := digest.NewSetBuilder().Add(presentDigest).Add(missingDigest).Build()
+	baseBlobAccess.EXPECT().FindMissing(ctx, allDigests).Return(missingDigest.ToSingletonSet(), nil)
+	fetched := make(chan struct{})
+	baseBlobAccess.EXPECT().Get(gomock.Any(), presentDigest).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+			close(fetched)
+			return buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))
+		})
+
+	missing, err := blobAccess.FindMissing(ctx, allDigests)
+	require.NoError(t, err)
+	require.Equal(t, missingDigest.ToSingletonSet(), missing)
+	<-fetched
+
+	// The present blob should be served from the cache.
+	data, err := blobAccess.Get(ctx, presentDigest).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello world"), data)
+}
@@ -0,0 +1,80 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// StreamingFindMissingBlobAccess is an extension of BlobAccess,
+// implemented by backends that are capable of checking for the
+// existence of blobs in a streaming fashion. This permits pipelining
+// existence checks (e.g., by sending multiple batched requests
+// concurrently) for very large numbers of digests, without needing to
+// construct a digest.Set containing all of them.
+type StreamingFindMissingBlobAccess interface {
+	BlobAccess
+
+	// FindMissingStream reads digests from the provided channel
+	// until it is closed, and writes the digests of blobs that are
+	// absent to the missing channel. It returns once all digests
+	// have been processed, or once an error occurs. The missing
+	// channel is not closed by this function.
+	//
+	// Digests may be written to the missing channel in any order.
+	// Writes to the missing channel are blocking, meaning that a
+	// caller that stops receiving missing digests eventually
+	// causes digests to no longer be read from the input channel.
+	// Callers should therefore keep receiving from the missing
+	// channel until this function returns.
+	FindMissingStream(ctx context.Context, digests <-chan digest.Digest, missing chan<- digest.Digest) error
+}
+
+// FindMissingStream checks for the existence of a stream of blobs,
+// using the semantics of StreamingFindMissingBlobAccess. If the
+// BlobAccess implements StreamingFindMissingBlobAccess, the request is
+// forwarded. Otherwise, digests are grouped into sets of at most
+// batchSize elements, for which FindMissing() is called sequentially.
+func FindMissingStream(ctx context.Context, blobAccess BlobAccess, digests <-chan digest.Digest, missing chan<- digest.Digest, batchSize int) error {
+	if streamingBlobAccess, ok := blobAccess.(StreamingFindMissingBlobAccess); ok {
+		return streamingBlobAccess.FindMissingStream(ctx, digests, missing)
+	}
+
+	batch := digest.NewSetBuilder()
+	flush := func() error {
+		if batch.Length() == 0 {
+			return nil
+		}
+		missingBatch, err := blobAccess.FindMissing(ctx, batch.Build())
+		if err != nil {
+			return err
+		}
+		batch = digest.NewSetBuilder()
+		for _, blobDigest := range missingBatch.Items() {
+			select {
+			case missing <- blobDigest:
+			case <-ctx.Done():
+				return util.StatusFromContext(ctx)
+			}
+		}
+		return nil
+	}
+
+	for {
+		select {
+		case blobDigest, ok := <-digests:
+			if !ok {
+				return flush()
+			}
+			batch.Add(blobDigest)
+			if batch.Length() >= batchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		case <-ctx.Done():
+			return util.StatusFromContext(ctx)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFindMissingStream(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	digest1 := digest.MustNewDigest("hello", "00000000000000000000000000000001", 1)
+	digest2 := digest.MustNewDigest("hello", "00000000000000000000000000000002", 2)
+	digest3 := digest.MustNewDigest("hello", "00000000000000000000000000000003", 3)
+
+	t.Run("Success", func(t *testing.T) {
+		// Digests should be grouped into batches of the
+		// provided size. The final batch may be smaller.
+		baseBlobAccess.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest1).Add(digest2).Build()).
+			Return(digest2.ToSingletonSet(), nil)
+		baseBlobAccess.EXPECT().FindMissing(ctx, digest3.ToSingletonSet()).
+			Return(digest3.ToSingletonSet(), nil)
+
+		digests := make(chan digest.Digest, 3)
+		digests <- digest1
+		digests <- digest2
+		digests <- digest3
+		close(digests)
+		missing := make(chan digest.Digest, 3)
+		require.NoError(t, blobstore.FindMissingStream(ctx, baseBlobAccess, digests, missing, 2))
+		close(missing)
+
+		var missingDigests []digest.Digest
+		for blobDigest := range missing {
+			missingDigests = append(missingDigests, blobDigest)
+		}
+		require.Equal(t, []digest.Digest{digest2, digest3}, missingDigests)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		baseBlobAccess.EXPECT().FindMissing(ctx, digest1.ToSingletonSet()).
+			Return(digest.EmptySet, status.Error(codes.Unavailable, "Server not reachable"))
+
+		digests := make(chan digest.Digest, 1)
+		digests <- digest1
+		close(digests)
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Server not reachable"),
+			blobstore.FindMissingStream(ctx, baseBlobAccess, digests, make(chan digest.Digest), 10))
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		// Callers that stop providing digests should be able
+		// to interrupt the operation through the context.
+		canceledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		require.Equal(
+			t,
+			status.Error(codes.Canceled, "context canceled"),
+			blobstore.FindMissingStream(canceledCtx, baseBlobAccess, make(chan digest.Digest), make(chan digest.Digest), 10))
+	})
+}
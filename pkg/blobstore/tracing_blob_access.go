@@ -0,0 +1,129 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/tracing"
+
+	"google.golang.org/grpc/status"
+)
+
+type tracingBlobAccess struct {
+	blobAccess          BlobAccess
+	tracer              tracing.Tracer
+	getSpanName         string
+	putSpanName         string
+	findMissingSpanName string
+	getRangeSpanName    string
+}
+
+// NewTracingBlobAccess creates an adapter for BlobAccess that creates
+// a span for every call to Get(), Put() and FindMissing(). Spans are
+// annotated with the digest of the blob and the resulting status of
+// the operation. By applying this adapter to every backend, tracing is
+// provided uniformly, without requiring the backends to create spans
+// themselves.
+//
+// Spans are created through a tracing.Tracer, making it possible to
+// emit them to tracing libraries other than OpenCensus.
+//
+// Like NewMetricsBlobAccess(), this adapter forwards calls against
+// optional capabilities of BlobAccess to the backend.
+func NewTracingBlobAccess(blobAccess BlobAccess, name string, tracer tracing.Tracer) BlobAccess {
+	return &tracingBlobAccess{
+		blobAccess:          blobAccess,
+		tracer:              tracer,
+		getSpanName:         name + ".Get",
+		putSpanName:         name + ".Put",
+		findMissingSpanName: name + ".FindMissing",
+		getRangeSpanName:    name + ".GetRange",
+	}
+}
+
+func addDigestAttributes(span tracing.Span, digest digest.Digest) {
+	span.AddStringAttribute("instance_name", digest.GetInstanceName().String())
+	span.AddStringAttribute("hash", digest.GetHashString())
+	span.AddInt64Attribute("size_bytes", digest.GetSizeBytes())
+}
+
+func setSpanStatus(span tracing.Span, err error) {
+	if err != nil {
+		s := status.Convert(err)
+		span.SetStatus(s.Code(), s.Message())
+	}
+}
+
+func (ba *tracingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	ctx, span := ba.tracer.StartSpan(ctx, ba.getSpanName)
+	addDigestAttributes(span, digest)
+	return buffer.WithErrorHandler(
+		ba.blobAccess.Get(ctx, digest),
+		&tracingErrorHandler{span: span})
+}
+
+func (ba *tracingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	ctx, span := ba.tracer.StartSpan(ctx, ba.putSpanName)
+	defer span.End()
+
+	addDigestAttributes(span, digest)
+	err := ba.blobAccess.Put(ctx, digest, b)
+	setSpanStatus(span, err)
+	return err
+}
+
+func (ba *tracingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	ctx, span := ba.tracer.StartSpan(ctx, ba.findMissingSpanName)
+	defer span.End()
+
+	span.AddInt64Attribute("digests_count", int64(digests.Length()))
+	missing, err := ba.blobAccess.FindMissing(ctx, digests)
+	if err == nil {
+		span.AddInt64Attribute("missing_count", int64(missing.Length()))
+	}
+	setSpanStatus(span, err)
+	return missing, err
+}
+
+func (ba *tracingBlobAccess) GetRange(ctx context.Context, digest digest.Digest, offset int64, sizeBytes int64) buffer.Buffer {
+	ctx, span := ba.tracer.StartSpan(ctx, ba.getRangeSpanName)
+	addDigestAttributes(span, digest)
+	span.AddInt64Attribute("read_offset", offset)
+	span.AddInt64Attribute("read_limit", sizeBytes)
+	return buffer.WithErrorHandler(
+		GetRange(ctx, ba.blobAccess, digest, offset, sizeBytes),
+		&tracingErrorHandler{span: span})
+}
+
+func (ba *tracingBlobAccess) GetCapabilities(ctx context.Context, instanceName digest.InstanceName) (Capabilities, error) {
+	return GetCapabilities(ctx, ba.blobAccess, instanceName)
+}
+
+func (ba *tracingBlobAccess) CheckHealth(ctx context.Context) error {
+	return CheckHealth(ctx, ba.blobAccess)
+}
+
+func (ba *tracingBlobAccess) Prefetch(ctx context.Context, digests digest.Set) {
+	Prefetch(ctx, ba.blobAccess, digests)
+}
+
+func (ba *tracingBlobAccess) ListDigests(ctx context.Context, instanceName digest.InstanceName, callback func(digest digest.Digest) error) error {
+	return ListDigests(ctx, ba.blobAccess, instanceName, callback)
+}
+
+// tracingErrorHandler is an implementation of buffer.ErrorHandler that
+// records the outcome of a call to Get() in its span. The span is
+// ended once the buffer returned by Get() is done being consumed.
+type tracingErrorHandler struct {
+	span tracing.Span
+}
+
+func (eh *tracingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	setSpanStatus(eh.span, err)
+	return nil, err
+}
+
+func (eh *tracingErrorHandler) Done() {
+	eh.span.End()
+}
@@ -0,0 +1,129 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTracingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	tracer := mock.NewMockTracer(ctrl)
+	blobAccess := blobstore.NewTracingBlobAccess(baseBlobAccess, "cas_grpc", tracer)
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	expectDigestAttributes := func(span *mock.MockSpan) {
+		span.EXPECT().AddStringAttribute("instance_name", "hello")
+		span.EXPECT().AddStringAttribute("hash", "8b1a9953c4611296a827abf8c47804d7")
+		span.EXPECT().AddInt64Attribute("size_bytes", int64(5))
+	}
+
+	t.Run("GetSuccess", func(t *testing.T) {
+		span := mock.NewMockSpan(ctrl)
+		tracer.EXPECT().StartSpan(ctx, "cas_grpc.Get").Return(ctx, span)
+		expectDigestAttributes(span)
+		baseBlobAccess.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		span.EXPECT().End()
+
+		data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("GetFailure", func(t *testing.T) {
+		// The status of the span should be set, based on the
+		// error that was returned by the backend.
+		span := mock.NewMockSpan(ctrl)
+		tracer.EXPECT().StartSpan(ctx, "cas_grpc.Get").Return(ctx, span)
+		expectDigestAttributes(span)
+		baseBlobAccess.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
+		span.EXPECT().SetStatus(codes.NotFound, "Blob not found")
+		span.EXPECT().End()
+
+		_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
+	})
+
+	t.Run("PutFailure", func(t *testing.T) {
+		span := mock.NewMockSpan(ctrl)
+		tracer.EXPECT().StartSpan(ctx, "cas_grpc.Put").Return(ctx, span)
+		expectDigestAttributes(span)
+		baseBlobAccess.EXPECT().Put(ctx, helloDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.Unavailable, "Server offline")
+			})
+		span.EXPECT().SetStatus(codes.Unavailable, "Server offline")
+		span.EXPECT().End()
+
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Server offline"),
+			blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("FindMissingSuccess", func(t *testing.T) {
+		span := mock.NewMockSpan(ctrl)
+		tracer.EXPECT().StartSpan(ctx, "cas_grpc.FindMissing").Return(ctx, span)
+		span.EXPECT().AddInt64Attribute("digests_count", int64(1))
+		baseBlobAccess.EXPECT().FindMissing(ctx, helloDigest.ToSingletonSet()).
+			Return(helloDigest.ToSingletonSet(), nil)
+		span.EXPECT().AddInt64Attribute("missing_count", int64(1))
+		span.EXPECT().End()
+
+		missing, err := blobAccess.FindMissing(ctx, helloDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, helloDigest.ToSingletonSet(), missing)
+	})
+
+	t.Run("GetRangeForwarded", func(t *testing.T) {
+		// GetRange() calls should be forwarded to backends that
+		// implement RangeReadingBlobAccess, even though the
+		// adapter is placed in front of them.
+		rangeReadingBlobAccess := mock.NewMockRangeReadingBlobAccess(ctrl)
+		blobAccess := blobstore.NewTracingBlobAccess(rangeReadingBlobAccess, "cas_grpc", tracer)
+
+		span := mock.NewMockSpan(ctrl)
+		tracer.EXPECT().StartSpan(ctx, "cas_grpc.GetRange").Return(ctx, span)
+		expectDigestAttributes(span)
+		span.EXPECT().AddInt64Attribute("read_offset", int64(1))
+		span.EXPECT().AddInt64Attribute("read_limit", int64(3))
+		rangeReadingBlobAccess.EXPECT().GetRange(ctx, helloDigest, int64(1), int64(3)).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("ell")))
+		span.EXPECT().End()
+
+		data, err := blobstore.GetRange(ctx, blobAccess, helloDigest, 1, 3).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("ell"), data)
+	})
+
+	t.Run("GetRangeFallback", func(t *testing.T) {
+		// Backends that don't implement RangeReadingBlobAccess
+		// should have the blob read in its entirety.
+		span := mock.NewMockSpan(ctrl)
+		tracer.EXPECT().StartSpan(ctx, "cas_grpc.GetRange").Return(ctx, span)
+		expectDigestAttributes(span)
+		span.EXPECT().AddInt64Attribute("read_offset", int64(1))
+		span.EXPECT().AddInt64Attribute("read_limit", int64(3))
+		baseBlobAccess.EXPECT().Get(ctx, helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		span.EXPECT().End()
+
+		data, err := blobstore.GetRange(ctx, blobAccess, helloDigest, 1, 3).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("ell"), data)
+	})
+}
@@ -0,0 +1,403 @@
+package blobstore
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	walEntrySuffix          = ".wal"
+	walTemporaryEntrySuffix = ".tmp"
+	walCorruptedEntrySuffix = ".corrupted"
+)
+
+// WALBlobAccess is a BlobAccess that writes blobs into a write-ahead
+// log, prior to storing them in a backend. It is returned by
+// NewWALBlobAccess().
+type WALBlobAccess interface {
+	BlobAccess
+
+	// Recover stores all blobs in the write-ahead log that have
+	// not been stored in the backend yet. It is called upon
+	// construction to replay entries that were not applied before
+	// the process terminated. It may also be called periodically
+	// to retry entries for which storing them failed.
+	Recover(ctx context.Context) error
+}
+
+type walBlobAccess struct {
+	base              BlobAccess
+	directory         filesystem.Directory
+	readBufferFactory ReadBufferFactory
+	workers           chan struct{}
+	retryInterval     time.Duration
+	errorLogger       util.ErrorLogger
+
+	lock         sync.Mutex
+	nextSequence uint64
+	pending      map[digest.Digest]uint64
+}
+
+// NewWALBlobAccess creates a decorator for BlobAccess that provides
+// durability by writing blobs into a write-ahead log (WAL) before
+// storing them in the backend. Put() returns as soon as the blob has
+// been written to the WAL and synchronized to disk. Blobs are then
+// stored in the backend asynchronously, using at most maximumWorkers
+// goroutines. Get() and FindMissing() take blobs into account that are
+// in the WAL, but not yet in the backend.
+//
+// The WAL is a directory containing one file per entry, named after a
+// sequence number in hexadecimal notation with the suffix ".wal".
+// Every file starts with a line containing the ByteStream read path of
+// the blob's digest, followed by the contents of the blob. Entries are
+// first written to a file with the suffix ".tmp", which is linked
+// under its final name after synchronizing it. This ensures that only
+// complete entries are replayed. Files of entries are removed after
+// the blob has been stored in the backend successfully.
+//
+// Entries whose header cannot be parsed are renamed to have the suffix
+// ".corrupted", so that they are no longer replayed, but remain
+// available for inspection. This is reported through the ErrorLogger.
+//
+// Entries written by a previous invocation are replayed before this
+// function returns. Entries for which storing fails remain in the WAL
+// until Recover() is called again. Such failures are reported through
+// the ErrorLogger. If retryInterval is positive, Recover() is called
+// periodically in the background, until ctx is canceled.
+func NewWALBlobAccess(ctx context.Context, base BlobAccess, directory filesystem.Directory, readBufferFactory ReadBufferFactory, maximumWorkers int, clock clock.Clock, retryInterval time.Duration, errorLogger util.ErrorLogger) (WALBlobAccess, error) {
+	ba := &walBlobAccess{
+		base:              base,
+		directory:         directory,
+		readBufferFactory: readBufferFactory,
+		workers:           make(chan struct{}, maximumWorkers),
+		retryInterval:     retryInterval,
+		errorLogger:       errorLogger,
+		nextSequence:      1,
+		pending:           map[digest.Digest]uint64{},
+	}
+
+	// Load entries written by a previous invocation, and remove
+	// entries that were not written completely.
+	files, err := directory.ReadDir()
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to read write-ahead log directory")
+	}
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasSuffix(name, walTemporaryEntrySuffix) {
+			if err := directory.Remove(name); err != nil {
+				return nil, util.StatusWrapfWithCode(err, codes.Internal, "Failed to remove incomplete write-ahead log entry %#v", name)
+			}
+			continue
+		}
+		sequence, ok := parseWALEntryName(name)
+		if !ok {
+			continue
+		}
+		r, blobDigest, err := ba.openEntry(sequence)
+		if status.Code(err) == codes.DataLoss {
+			ba.quarantineEntry(sequence, err)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		r.Close()
+		if sequence > ba.pending[blobDigest] {
+			ba.pending[blobDigest] = sequence
+		}
+		if sequence >= ba.nextSequence {
+			ba.nextSequence = sequence + 1
+		}
+	}
+
+	// Replay the entries that were loaded. Failing to do so is not
+	// fatal, as the entries can still be served from the WAL.
+	if err := ba.Recover(ctx); err != nil {
+		errorLogger.Log(util.StatusWrap(err, "Failed to recover write-ahead log"))
+	}
+	if retryInterval > 0 {
+		go ba.retryPeriodically(ctx, clock, retryInterval)
+	}
+	return ba, nil
+}
+
+// retryPeriodically calls Recover() at a fixed interval, so that
+// entries for which storing failed, or which were never applied due
+// to clients giving up waiting for a worker, are eventually stored in
+// the backend.
+func (ba *walBlobAccess) retryPeriodically(ctx context.Context, clock clock.Clock, retryInterval time.Duration) {
+	for {
+		_, t := clock.NewTimer(retryInterval)
+		select {
+		case <-t:
+		case <-ctx.Done():
+			return
+		}
+		if err := ba.Recover(ctx); err != nil {
+			ba.errorLogger.Log(util.StatusWrap(err, "Failed to recover write-ahead log"))
+		}
+	}
+}
+
+func parseWALEntryName(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, walEntrySuffix) {
+		return 0, false
+	}
+	sequence, err := strconv.ParseUint(strings.TrimSuffix(name, walEntrySuffix), 16, 64)
+	return sequence, err == nil
+}
+
+func getWALEntryName(sequence uint64, suffix string) string {
+	return fmt.Sprintf("%016x%s", sequence, suffix)
+}
+
+// openEntry opens an entry in the WAL, returning a reader of the
+// contents of the blob and its digest.
+func (ba *walBlobAccess) openEntry(sequence uint64) (io.ReadCloser, digest.Digest, error) {
+	name := getWALEntryName(sequence, walEntrySuffix)
+	f, err := ba.directory.OpenRead(name)
+	if os.IsNotExist(err) {
+		return nil, digest.BadDigest, util.StatusWrapfWithCode(err, codes.NotFound, "Write-ahead log entry %#v does not exist", name)
+	} else if err != nil {
+		return nil, digest.BadDigest, util.StatusWrapfWithCode(err, codes.Internal, "Failed to open write-ahead log entry %#v", name)
+	}
+	r := bufio.NewReader(io.NewSectionReader(f, 0, math.MaxInt64))
+	header, err := r.ReadString('\n')
+	if err != nil {
+		f.Close()
+		return nil, digest.BadDigest, util.StatusWrapfWithCode(err, codes.DataLoss, "Failed to read header of write-ahead log entry %#v", name)
+	}
+	blobDigest, err := digest.NewDigestFromByteStreamReadPath(strings.TrimSuffix(header, "\n"))
+	if err != nil {
+		f.Close()
+		return nil, digest.BadDigest, util.StatusWrapfWithCode(err, codes.DataLoss, "Invalid digest in write-ahead log entry %#v", name)
+	}
+	return &struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: r,
+		Closer: f,
+	}, blobDigest, nil
+}
+
+// quarantineEntry renames an entry in the WAL whose header cannot be
+// parsed, so that it is no longer replayed. As the digest of the blob
+// is unknown, the entry is removed from the set of pending entries by
+// its sequence number.
+func (ba *walBlobAccess) quarantineEntry(sequence uint64, err error) {
+	ba.errorLogger.Log(err)
+
+	name := getWALEntryName(sequence, walEntrySuffix)
+	if err := ba.directory.Link(name, ba.directory, getWALEntryName(sequence, walCorruptedEntrySuffix)); err != nil && !os.IsExist(err) {
+		ba.errorLogger.Log(util.StatusWrapfWithCode(err, codes.Internal, "Failed to quarantine corrupted write-ahead log entry %#v", name))
+		return
+	}
+	if err := ba.directory.Remove(name); err != nil && !os.IsNotExist(err) {
+		ba.errorLogger.Log(util.StatusWrapfWithCode(err, codes.Internal, "Failed to remove corrupted write-ahead log entry %#v", name))
+		return
+	}
+
+	ba.lock.Lock()
+	for blobDigest, pendingSequence := range ba.pending {
+		if pendingSequence == sequence {
+			delete(ba.pending, blobDigest)
+		}
+	}
+	ba.lock.Unlock()
+}
+
+// writeEntry writes a blob into a new entry in the WAL. The entry and
+// the directory containing it are synchronized to disk before
+// returning, so that the entry survives crashes once Put() has been
+// acknowledged.
+func (ba *walBlobAccess) writeEntry(blobDigest digest.Digest, b buffer.Buffer) (uint64, error) {
+	ba.lock.Lock()
+	sequence := ba.nextSequence
+	ba.nextSequence++
+	ba.lock.Unlock()
+
+	temporaryName := getWALEntryName(sequence, walTemporaryEntrySuffix)
+	f, err := ba.directory.OpenAppend(temporaryName, filesystem.CreateExcl(0644))
+	if err != nil {
+		b.Discard()
+		return 0, util.StatusWrapfWithCode(err, codes.Internal, "Failed to create write-ahead log entry %#v", temporaryName)
+	}
+	if _, err := f.Write([]byte(blobDigest.GetByteStreamReadPath() + "\n")); err != nil {
+		b.Discard()
+		f.Close()
+		ba.directory.Remove(temporaryName)
+		return 0, util.StatusWrapfWithCode(err, codes.Internal, "Failed to write write-ahead log entry %#v", temporaryName)
+	}
+	if err := b.IntoWriter(f); err != nil {
+		f.Close()
+		ba.directory.Remove(temporaryName)
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		ba.directory.Remove(temporaryName)
+		return 0, util.StatusWrapfWithCode(err, codes.Internal, "Failed to synchronize write-ahead log entry %#v", temporaryName)
+	}
+	f.Close()
+
+	// Make the entry visible under its final name, and ensure that
+	// this is persisted.
+	name := getWALEntryName(sequence, walEntrySuffix)
+	if err := ba.directory.Link(temporaryName, ba.directory, name); err != nil {
+		ba.directory.Remove(temporaryName)
+		return 0, util.StatusWrapfWithCode(err, codes.Internal, "Failed to link write-ahead log entry %#v", temporaryName)
+	}
+	if err := ba.directory.Remove(temporaryName); err != nil {
+		return 0, util.StatusWrapfWithCode(err, codes.Internal, "Failed to remove write-ahead log entry %#v", temporaryName)
+	}
+	if err := ba.directory.Sync(); err != nil {
+		return 0, util.StatusWrapWithCode(err, codes.Internal, "Failed to synchronize write-ahead log directory")
+	}
+	return sequence, nil
+}
+
+// applyEntry stores the blob contained in an entry of the WAL in the
+// backend. The entry is removed afterwards.
+func (ba *walBlobAccess) applyEntry(ctx context.Context, sequence uint64) error {
+	r, blobDigest, err := ba.openEntry(sequence)
+	if status.Code(err) == codes.DataLoss {
+		// The header of the entry got corrupted after it was
+		// written. Retrying will not succeed.
+		ba.quarantineEntry(sequence, err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	name := getWALEntryName(sequence, walEntrySuffix)
+	dataIsValid := true
+	if err := ba.base.Put(ctx, blobDigest, ba.readBufferFactory.NewBufferFromReader(blobDigest, r, func(valid bool) {
+		dataIsValid = valid
+	})); err != nil && dataIsValid {
+		return util.StatusWrapf(err, "Failed to apply write-ahead log entry %#v", name)
+	} else if err != nil {
+		// The entry got corrupted after it was written.
+		// Replaying it again will not succeed, so discard it.
+		ba.errorLogger.Log(util.StatusWrapfWithCode(err, codes.DataLoss, "Discarding corrupted write-ahead log entry %#v", name))
+	}
+
+	if err := ba.directory.Remove(name); err != nil && !os.IsNotExist(err) {
+		return util.StatusWrapfWithCode(err, codes.Internal, "Failed to remove write-ahead log entry %#v", name)
+	}
+	ba.lock.Lock()
+	if ba.pending[blobDigest] == sequence {
+		delete(ba.pending, blobDigest)
+	}
+	ba.lock.Unlock()
+	return nil
+}
+
+func (ba *walBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	ba.lock.Lock()
+	sequence, ok := ba.pending[blobDigest]
+	ba.lock.Unlock()
+	if ok {
+		// Serve the blob from the WAL, as it may not have been
+		// stored in the backend yet. If the entry has been
+		// applied in the meantime, fall back to the backend.
+		if r, _, err := ba.openEntry(sequence); err == nil {
+			return ba.readBufferFactory.NewBufferFromReader(blobDigest, r, func(dataIsValid bool) {})
+		}
+	}
+	return ba.base.Get(ctx, blobDigest)
+}
+
+func (ba *walBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	sequence, err := ba.writeEntry(blobDigest, b)
+	if err != nil {
+		return err
+	}
+
+	ba.lock.Lock()
+	ba.pending[blobDigest] = sequence
+	ba.lock.Unlock()
+
+	// Store the blob in the backend without blocking the client,
+	// but do wait for a worker to become available. This applies
+	// backpressure to clients when the backend cannot keep up. The
+	// blob is already stored durably, so if the client gives up
+	// waiting, the entry is left for Recover() to apply. Unless
+	// periodic retrying is enabled, the client is informed of this.
+	select {
+	case ba.workers <- struct{}{}:
+	case <-ctx.Done():
+		if ba.retryInterval > 0 {
+			return nil
+		}
+		return util.StatusFromContext(ctx)
+	}
+	// The context of the client may be canceled after returning,
+	// so a separate context is used.
+	go func() {
+		if err := ba.applyEntry(context.Background(), sequence); err != nil {
+			ba.errorLogger.Log(err)
+		}
+		<-ba.workers
+	}()
+	return nil
+}
+
+func (ba *walBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	missing, err := ba.base.FindMissing(ctx, digests)
+	if err != nil {
+		return digest.EmptySet, err
+	}
+
+	// Blobs that are in the WAL are not missing.
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+	stillMissing := digest.NewSetBuilder()
+	for _, blobDigest := range missing.Items() {
+		if _, ok := ba.pending[blobDigest]; !ok {
+			stillMissing.Add(blobDigest)
+		}
+	}
+	return stillMissing.Build(), nil
+}
+
+func (ba *walBlobAccess) Recover(ctx context.Context) error {
+	files, err := ba.directory.ReadDir()
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to read write-ahead log directory")
+	}
+	var sequences []uint64
+	for _, file := range files {
+		if sequence, ok := parseWALEntryName(file.Name()); ok {
+			sequences = append(sequences, sequence)
+		}
+	}
+
+	// Apply entries in the order in which they were written.
+	sort.Slice(sequences, func(i, j int) bool { return sequences[i] < sequences[j] })
+	for _, sequence := range sequences {
+		// Entries may have been applied concurrently by
+		// Put(), in which case they no longer exist.
+		if err := ba.applyEntry(ctx, sequence); err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+	}
+	return nil
+}
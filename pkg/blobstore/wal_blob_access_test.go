@@ -0,0 +1,203 @@
+package blobstore_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWALBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	path, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(path)
+	directory, err := filesystem.NewLocalDirectory(path)
+	require.NoError(t, err)
+	defer directory.Close()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	blobAccess, err := blobstore.NewWALBlobAccess(ctx, baseBlobAccess, directory, blobstore.CASReadBufferFactory, 1, clock.SystemClock, 0, errorLogger)
+	require.NoError(t, err)
+	blobDigest := digest.MustNewDigest("hello", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+
+	t.Run("PutApplyFailure", func(t *testing.T) {
+		// Put() should succeed once the blob is written to the
+		// WAL, even if storing it in the backend fails. The
+		// failure should be reported through the ErrorLogger.
+		baseBlobAccess.EXPECT().Put(gomock.Any(), blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello world"), data)
+				return status.Error(codes.Unavailable, "Server offline")
+			})
+		logged := make(chan struct{})
+		errorLogger.EXPECT().Log(status.Error(codes.Unavailable, "Failed to apply write-ahead log entry \"0000000000000001.wal\": Server offline")).
+			Do(func(err error) { close(logged) })
+
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+		<-logged
+	})
+
+	t.Run("GetFromWAL", func(t *testing.T) {
+		// Blobs that are not stored in the backend should be
+		// served from the WAL.
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		// Blobs in the WAL should not be reported as missing.
+		baseBlobAccess.EXPECT().FindMissing(ctx, blobDigest.ToSingletonSet()).
+			Return(blobDigest.ToSingletonSet(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, blobDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	})
+
+	t.Run("Recover", func(t *testing.T) {
+		// A new instance should pick up the existing entry and
+		// replay it upon construction, removing it from the WAL
+		// afterwards.
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello world"), data)
+				return nil
+			})
+		blobAccess, err := blobstore.NewWALBlobAccess(ctx, baseBlobAccess, directory, blobstore.CASReadBufferFactory, 1, clock.SystemClock, 0, errorLogger)
+		require.NoError(t, err)
+
+		files, err := ioutil.ReadDir(path)
+		require.NoError(t, err)
+		require.Empty(t, files)
+
+		// Subsequent reads should go to the backend.
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("RecoverCorrupted", func(t *testing.T) {
+		// Entries that got corrupted after they were written
+		// cannot be applied. They should be discarded, and this
+		// should be reported through the ErrorLogger.
+		require.NoError(t, ioutil.WriteFile(
+			filepath.Join(path, "0000000000000007.wal"),
+			[]byte(blobDigest.GetByteStreamReadPath()+"\nHello World"),
+			0644))
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				_, err := b.ToByteSlice(100)
+				return err
+			})
+		errorLogger.EXPECT().Log(gomock.Any()).Do(func(err error) {
+			require.Equal(t, codes.DataLoss, status.Code(err))
+		})
+		_, err := blobstore.NewWALBlobAccess(ctx, baseBlobAccess, directory, blobstore.CASReadBufferFactory, 1, clock.SystemClock, 0, errorLogger)
+		require.NoError(t, err)
+
+		files, err := ioutil.ReadDir(path)
+		require.NoError(t, err)
+		require.Empty(t, files)
+	})
+
+	t.Run("RecoverMalformedHeader", func(t *testing.T) {
+		// Entries whose header cannot be parsed should not
+		// prevent the WAL from being opened. They should be
+		// renamed, so that they are no longer replayed.
+		require.NoError(t, ioutil.WriteFile(
+			filepath.Join(path, "0000000000000008.wal"),
+			[]byte("This is not a digest\nHello world"),
+			0644))
+		errorLogger.EXPECT().Log(gomock.Any()).Do(func(err error) {
+			require.Equal(t, codes.DataLoss, status.Code(err))
+		})
+		_, err := blobstore.NewWALBlobAccess(ctx, baseBlobAccess, directory, blobstore.CASReadBufferFactory, 1, clock.SystemClock, 0, errorLogger)
+		require.NoError(t, err)
+
+		files, err := ioutil.ReadDir(path)
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		require.Equal(t, "0000000000000008.corrupted", files[0].Name())
+	})
+}
+
+func TestWALBlobAccessRetry(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	path, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(path)
+	directory, err := filesystem.NewLocalDirectory(path)
+	require.NoError(t, err)
+	defer directory.Close()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	timerChannel1 := make(chan time.Time, 1)
+	clock.EXPECT().NewTimer(time.Minute).Return(nil, timerChannel1)
+	retryCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	blobAccess, err := blobstore.NewWALBlobAccess(retryCtx, baseBlobAccess, directory, blobstore.CASReadBufferFactory, 1, clock, time.Minute, errorLogger)
+	require.NoError(t, err)
+	blobDigest := digest.MustNewDigest("hello", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+
+	// Let storing the blob in the backend fail initially. The entry
+	// should remain in the WAL.
+	baseBlobAccess.EXPECT().Put(gomock.Any(), blobDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+			b.Discard()
+			return status.Error(codes.Unavailable, "Server offline")
+		})
+	logged := make(chan struct{})
+	errorLogger.EXPECT().Log(status.Error(codes.Unavailable, "Failed to apply write-ahead log entry \"0000000000000001.wal\": Server offline")).
+		Do(func(err error) { close(logged) })
+
+	require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	<-logged
+
+	// Once the retry interval has passed, the entry should be
+	// applied again. This time storing it succeeds, causing the
+	// entry to be removed.
+	baseBlobAccess.EXPECT().Put(retryCtx, blobDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+			data, err := b.ToByteSlice(100)
+			require.NoError(t, err)
+			require.Equal(t, []byte("Hello world"), data)
+			return nil
+		})
+	retried := make(chan struct{})
+	clock.EXPECT().NewTimer(time.Minute).DoAndReturn(func(d time.Duration) (*mock.MockTimer, <-chan time.Time) {
+		close(retried)
+		return nil, nil
+	})
+	timerChannel1 <- time.Unix(1000, 0)
+	<-retried
+
+	files, err := ioutil.ReadDir(path)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
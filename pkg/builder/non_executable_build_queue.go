@@ -4,43 +4,46 @@ import (
 	"context"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
-	"github.com/bazelbuild/remote-apis/build/bazel/semver"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 type nonExecutableBuildQueue struct {
+	contentAddressableStorage blobstore.BlobAccess
+	maximumMessageSizeBytes   int64
 }
 
-// NonExecutableBuildQueue is a build queue that is incapable of
+// NewNonExecutableBuildQueue creates a build queue that is incapable of
 // executing anything. It is merely needed to provide a functional
 // implementation of GetCapabilities() for instances that provide remote
-// caching without the execution.
-var NonExecutableBuildQueue BuildQueue = nonExecutableBuildQueue{}
-
-func (bq nonExecutableBuildQueue) GetCapabilities(ctx context.Context, in *remoteexecution.GetCapabilitiesRequest) (*remoteexecution.ServerCapabilities, error) {
-	return &remoteexecution.ServerCapabilities{
-		CacheCapabilities: &remoteexecution.CacheCapabilities{
-			DigestFunction: digest.SupportedDigestFunctions,
-			ActionCacheUpdateCapabilities: &remoteexecution.ActionCacheUpdateCapabilities{
-				UpdateEnabled: false,
-			},
-			// CachePriorityCapabilities: Priorities not supported.
-			// MaxBatchTotalSize: Not used by Bazel yet.
-			SymlinkAbsolutePathStrategy: remoteexecution.SymlinkAbsolutePathStrategy_ALLOWED,
-		},
-		// TODO(edsch): DeprecatedApiVersion.
-		LowApiVersion:  &semver.SemVer{Major: 2},
-		HighApiVersion: &semver.SemVer{Major: 2},
-	}, nil
+// caching without the execution. The capabilities that are returned
+// are derived from those of the Content Addressable Storage.
+func NewNonExecutableBuildQueue(contentAddressableStorage blobstore.BlobAccess, maximumMessageSizeBytes int64) BuildQueue {
+	return &nonExecutableBuildQueue{
+		contentAddressableStorage: contentAddressableStorage,
+		maximumMessageSizeBytes:   maximumMessageSizeBytes,
+	}
 }
 
-func (bq nonExecutableBuildQueue) Execute(in *remoteexecution.ExecuteRequest, out remoteexecution.Execution_ExecuteServer) error {
+func (bq *nonExecutableBuildQueue) GetCapabilities(ctx context.Context, in *remoteexecution.GetCapabilitiesRequest) (*remoteexecution.ServerCapabilities, error) {
+	instanceName, err := digest.NewInstanceName(in.InstanceName)
+	if err != nil {
+		return nil, util.StatusWrapf(err, "Invalid instance name %#v", in.InstanceName)
+	}
+	// Action Cache updates are disabled by default. They may be
+	// enabled by wrapping this build queue in
+	// UpdateEnabledTogglingBuildQueue.
+	return blobstore.GetServerCapabilities(ctx, bq.contentAddressableStorage, instanceName, bq.maximumMessageSizeBytes, false)
+}
+
+func (bq *nonExecutableBuildQueue) Execute(in *remoteexecution.ExecuteRequest, out remoteexecution.Execution_ExecuteServer) error {
 	return status.Errorf(codes.InvalidArgument, "This instance name cannot be used for remote execution; only remote caching")
 }
 
-func (bq nonExecutableBuildQueue) WaitExecution(in *remoteexecution.WaitExecutionRequest, out remoteexecution.Execution_WaitExecutionServer) error {
+func (bq *nonExecutableBuildQueue) WaitExecution(in *remoteexecution.WaitExecutionRequest, out remoteexecution.Execution_WaitExecutionServer) error {
 	return status.Errorf(codes.InvalidArgument, "This instance name cannot be used for remote execution; only remote caching")
 }
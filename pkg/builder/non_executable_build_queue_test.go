@@ -0,0 +1,53 @@
+package builder_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/bazelbuild/remote-apis/build/bazel/semver"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/builder"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNonExecutableBuildQueueGetCapabilities(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	buildQueue := builder.NewNonExecutableBuildQueue(contentAddressableStorage, 4*1024*1024)
+
+	t.Run("InvalidInstanceName", func(t *testing.T) {
+		_, err := buildQueue.GetCapabilities(ctx, &remoteexecution.GetCapabilitiesRequest{
+			InstanceName: "hello/blobs/world",
+		})
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid instance name \"hello/blobs/world\": Instance name contains reserved keyword \"blobs\""), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// The capabilities should be derived from those of the
+		// Content Addressable Storage. As the mock doesn't
+		// provide any, the defaults should be announced.
+		response, err := buildQueue.GetCapabilities(ctx, &remoteexecution.GetCapabilitiesRequest{
+			InstanceName: "hello",
+		})
+		require.NoError(t, err)
+		require.Equal(t, &remoteexecution.ServerCapabilities{
+			CacheCapabilities: &remoteexecution.CacheCapabilities{
+				DigestFunction: digest.SupportedDigestFunctions,
+				ActionCacheUpdateCapabilities: &remoteexecution.ActionCacheUpdateCapabilities{
+					UpdateEnabled: false,
+				},
+				MaxBatchTotalSizeBytes:      4 * 1024 * 1024,
+				SymlinkAbsolutePathStrategy: remoteexecution.SymlinkAbsolutePathStrategy_ALLOWED,
+			},
+			LowApiVersion:  &semver.SemVer{Major: 2},
+			HighApiVersion: &semver.SemVer{Major: 2},
+		}, response)
+	})
+}
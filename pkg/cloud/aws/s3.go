@@ -4,12 +4,24 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 // S3 is an interface around the AWS SDK S3 client. It has been added to
 // aid unit testing.
 type S3 interface {
 	GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error)
+	HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error)
 }
 
 var _ S3 = &s3.S3{}
+
+// S3Uploader is an interface around the AWS SDK S3 upload manager. As
+// opposed to S3.PutObject(), it is capable of uploading objects from
+// an io.Reader, without loading them into memory in their entirety. It
+// has been added to aid unit testing.
+type S3Uploader interface {
+	UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
+}
+
+var _ S3Uploader = &s3manager.Uploader{}
@@ -208,12 +208,47 @@ func (d Digest) GetHashString() string {
 	return d.value[:hashEnd]
 }
 
+// GetDigestFunction returns the digest function that was used to
+// compute the hash of the object. The digest function is derived from
+// the length of the hash. DigestFunction_UNKNOWN is returned if the
+// length of the hash does not correspond to any supported digest
+// function, causing GetHasherFactory() to fail.
+func (d Digest) GetDigestFunction() remoteexecution.DigestFunction_Value {
+	hashEnd, _, _ := d.unpack()
+	switch hashEnd {
+	case md5.Size * 2:
+		return remoteexecution.DigestFunction_MD5
+	case sha1.Size * 2:
+		return remoteexecution.DigestFunction_SHA1
+	case sha256.Size * 2:
+		return remoteexecution.DigestFunction_SHA256
+	case sha512.Size384 * 2:
+		return remoteexecution.DigestFunction_SHA384
+	case sha512.Size * 2:
+		return remoteexecution.DigestFunction_SHA512
+	default:
+		return remoteexecution.DigestFunction_UNKNOWN
+	}
+}
+
 // GetSizeBytes returns the size of the object, in bytes.
 func (d Digest) GetSizeBytes() int64 {
 	_, sizeBytes, _ := d.unpack()
 	return sizeBytes
 }
 
+// IsEmptyBlob returns whether the digest corresponds to the empty
+// blob. In addition to the size being zero, the hash must be the one
+// of empty content. Storage backends may treat such blobs as always
+// being present, as required by the Remote Execution protocol.
+func (d Digest) IsEmptyBlob() bool {
+	if d.GetSizeBytes() != 0 {
+		return false
+	}
+	_, ok := emptyBlobHashes[d.GetHashString()]
+	return ok
+}
+
 // KeyFormat is an enumeration type that determines the format of object
 // keys returned by Digest.GetKey().
 type KeyFormat int
@@ -288,26 +323,54 @@ func (d Digest) ToSingletonSet() Set {
 	}
 }
 
+// HasherFactory is a function that creates a standard hash.Hash object
+// for a given digest function.
+type HasherFactory func() hash.Hash
+
+var hasherFactories = map[remoteexecution.DigestFunction_Value]HasherFactory{
+	remoteexecution.DigestFunction_MD5:    md5.New,
+	remoteexecution.DigestFunction_SHA1:   sha1.New,
+	remoteexecution.DigestFunction_SHA256: sha256.New,
+	remoteexecution.DigestFunction_SHA384: sha512.New384,
+	remoteexecution.DigestFunction_SHA512: sha512.New,
+}
+
+// emptyBlobHashes contains the hashes of the empty blob for every
+// supported digest function.
+var emptyBlobHashes = func() map[string]struct{} {
+	hashes := map[string]struct{}{}
+	for _, hasherFactory := range hasherFactories {
+		hashes[hex.EncodeToString(hasherFactory().Sum(nil))] = struct{}{}
+	}
+	return hashes
+}()
+
+// GetHasherFactory returns a HasherFactory for a given digest function.
+// An error is returned if the digest function is not supported.
+func GetHasherFactory(digestFunction remoteexecution.DigestFunction_Value) (HasherFactory, error) {
+	if hasherFactory, ok := hasherFactories[digestFunction]; ok {
+		return hasherFactory, nil
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "Unsupported digest function %s", digestFunction)
+}
+
+// GetHasherFactory returns a HasherFactory that creates hash.Hash
+// objects using the same algorithm as the one that was used to create
+// the digest, making it possible to validate data against the digest.
+func (d Digest) GetHasherFactory() (HasherFactory, error) {
+	return GetHasherFactory(d.GetDigestFunction())
+}
+
 // NewHasher creates a standard hash.Hash object that may be used to
 // compute a checksum of data. The hash.Hash object uses the same
 // algorithm as the one that was used to create the digest, making it
 // possible to validate data against a digest.
 func (d Digest) NewHasher() hash.Hash {
-	hashEnd, _, _ := d.unpack()
-	switch hashEnd {
-	case md5.Size * 2:
-		return md5.New()
-	case sha1.Size * 2:
-		return sha1.New()
-	case sha256.Size * 2:
-		return sha256.New()
-	case sha512.Size384 * 2:
-		return sha512.New384()
-	case sha512.Size * 2:
-		return sha512.New()
-	default:
-		panic("Digest hash is of unknown type")
+	hasherFactory, err := d.GetHasherFactory()
+	if err != nil {
+		panic(err)
 	}
+	return hasherFactory()
 }
 
 // NewGenerator creates a writer that may be used to compute digests of
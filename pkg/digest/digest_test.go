@@ -227,6 +227,53 @@ func TestDigestGetHashString(t *testing.T) {
 			123).GetHashString())
 }
 
+func TestDigestGetDigestFunction(t *testing.T) {
+	require.Equal(
+		t,
+		remoteexecution.DigestFunction_MD5,
+		digest.MustNewDigest(
+			"hello",
+			"8b1a9953c4611296a827abf8c47804d7",
+			5).GetDigestFunction())
+	require.Equal(
+		t,
+		remoteexecution.DigestFunction_SHA256,
+		digest.MustNewDigest(
+			"hello",
+			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			123).GetDigestFunction())
+}
+
+func TestGetHasherFactory(t *testing.T) {
+	t.Run("Supported", func(t *testing.T) {
+		// All supported digest functions should have a hasher
+		// that yields hashes of the right length.
+		for _, digestFunction := range digest.SupportedDigestFunctions {
+			hasherFactory, err := digest.GetHasherFactory(digestFunction)
+			require.NoError(t, err)
+			require.NotNil(t, hasherFactory())
+		}
+
+		hasherFactory, err := digest.GetHasherFactory(remoteexecution.DigestFunction_SHA384)
+		require.NoError(t, err)
+		require.Equal(t, 48, hasherFactory().Size())
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, err := digest.GetHasherFactory(remoteexecution.DigestFunction_VSO)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Unsupported digest function VSO"), err)
+	})
+}
+
+func TestDigestIsEmptyBlob(t *testing.T) {
+	require.True(t, digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 0).IsEmptyBlob())
+	require.True(t, digest.MustNewDigest("hello", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0).IsEmptyBlob())
+
+	// Both the size and the hash need to match.
+	require.False(t, digest.MustNewDigest("hello", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 5).IsEmptyBlob())
+	require.False(t, digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 0).IsEmptyBlob())
+}
+
 func TestDigestGetSizeBytes(t *testing.T) {
 	require.Equal(
 		t,
@@ -82,3 +82,19 @@ func (ec *ExistenceCache) Add(digests Set) {
 	}
 	ec.lock.Unlock()
 }
+
+// Remove digests from the cache, causing subsequent calls to
+// RemoveExisting() to no longer remove them from the provided set.
+func (ec *ExistenceCache) Remove(digests Set) {
+	ec.lock.Lock()
+	for _, d := range digests.Items() {
+		// Entries are not removed from the eviction set, as it
+		// only permits removal of the entry that is up for
+		// eviction. Marking the entry as expired suffices.
+		key := d.GetKey(ec.keyFormat)
+		if _, ok := ec.insertionTimes[key]; ok {
+			ec.insertionTimes[key] = time.Time{}
+		}
+	}
+	ec.lock.Unlock()
+}
@@ -110,3 +110,31 @@ func TestExistenceCache(t *testing.T) {
 		allDigests,
 		existenceCache.RemoveExisting(allDigests))
 }
+
+func TestExistenceCacheRemove(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	clock := mock.NewMockClock(ctrl)
+	existenceCache := digest.NewExistenceCache(clock, digest.KeyWithoutInstance, 2, time.Minute, eviction.NewLRUSet())
+	blobDigest := digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 5)
+
+	// Removing digests that are not present should have no effect.
+	existenceCache.Remove(blobDigest.ToSingletonSet())
+
+	clock.EXPECT().Now().Return(time.Unix(1000, 0))
+	existenceCache.Add(blobDigest.ToSingletonSet())
+	clock.EXPECT().Now().Return(time.Unix(1001, 0))
+	require.Equal(t, digest.EmptySet, existenceCache.RemoveExisting(blobDigest.ToSingletonSet()))
+
+	// Once removed, the digest should no longer be reported as
+	// existing, even though it hasn't expired yet.
+	existenceCache.Remove(blobDigest.ToSingletonSet())
+	clock.EXPECT().Now().Return(time.Unix(1002, 0))
+	require.Equal(t, blobDigest.ToSingletonSet(), existenceCache.RemoveExisting(blobDigest.ToSingletonSet()))
+
+	// Adding it again should cause it to be reported as existing.
+	clock.EXPECT().Now().Return(time.Unix(1003, 0))
+	existenceCache.Add(blobDigest.ToSingletonSet())
+	clock.EXPECT().Now().Return(time.Unix(1004, 0))
+	require.Equal(t, digest.EmptySet, existenceCache.RemoveExisting(blobDigest.ToSingletonSet()))
+}
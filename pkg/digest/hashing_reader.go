@@ -0,0 +1,48 @@
+package digest
+
+import (
+	"io"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// HashingReader is a decorator for io.Reader that computes the digest
+// of the data that is read through it. It can be used to ingest data
+// whose digest is not known in advance (e.g., the output of a build
+// action), without needing to buffer the data to compute the digest
+// before uploading it.
+type HashingReader struct {
+	r         io.Reader
+	generator *Generator
+	digest    Digest
+}
+
+// NewHashingReader creates a HashingReader that computes a digest for a
+// given instance name, using a given digest function.
+func NewHashingReader(r io.Reader, instanceName InstanceName, digestFunction remoteexecution.DigestFunction_Value) (*HashingReader, error) {
+	generator, err := instanceName.NewGenerator(digestFunction)
+	if err != nil {
+		return nil, err
+	}
+	return &HashingReader{
+		r:         r,
+		generator: generator,
+	}, nil
+}
+
+func (hr *HashingReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	hr.generator.Write(p[:n])
+	if err == io.EOF && hr.digest == BadDigest {
+		hr.digest = hr.generator.Sum()
+	}
+	return n, err
+}
+
+// GetDigest returns the digest of the data read through the
+// HashingReader. The digest only becomes available once the underlying
+// reader has returned io.EOF. The size of the resulting digest
+// corresponds to the total number of bytes read.
+func (hr *HashingReader) GetDigest() (Digest, bool) {
+	return hr.digest, hr.digest != BadDigest
+}
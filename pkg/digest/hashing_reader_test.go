@@ -0,0 +1,51 @@
+package digest_test
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHashingReader(t *testing.T) {
+	instanceName := digest.MustNewInstanceName("hello")
+
+	t.Run("SHA256", func(t *testing.T) {
+		r, err := digest.NewHashingReader(strings.NewReader("Hello"), instanceName, remoteexecution.DigestFunction_SHA256)
+		require.NoError(t, err)
+
+		// The digest should not be available until EOF.
+		_, ok := r.GetDigest()
+		require.False(t, ok)
+
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+		blobDigest, ok := r.GetDigest()
+		require.True(t, ok)
+		require.Equal(t, digest.MustNewDigest("hello", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5), blobDigest)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		r, err := digest.NewHashingReader(strings.NewReader(""), instanceName, remoteexecution.DigestFunction_MD5)
+		require.NoError(t, err)
+
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Empty(t, data)
+		blobDigest, ok := r.GetDigest()
+		require.True(t, ok)
+		require.Equal(t, digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 0), blobDigest)
+	})
+
+	t.Run("UnsupportedDigestFunction", func(t *testing.T) {
+		_, err := digest.NewHashingReader(strings.NewReader("Hello"), instanceName, remoteexecution.DigestFunction_VSO)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Unsupported digest function: VSO"), err)
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"crypto/sha512"
 	"fmt"
+	"hash"
 	"strings"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
@@ -130,6 +131,32 @@ func (in InstanceName) newDigestUnchecked(hash string, sizeBytes int64) Digest {
 	}
 }
 
+// NewGenerator creates a writer that may be used to compute digests of
+// newly created files, using a given digest function. This can be used
+// in case there is no existing digest from which the digest function
+// can be derived.
+func (in InstanceName) NewGenerator(digestFunction remoteexecution.DigestFunction_Value) (*Generator, error) {
+	var partialHash hash.Hash
+	switch digestFunction {
+	case remoteexecution.DigestFunction_MD5:
+		partialHash = md5.New()
+	case remoteexecution.DigestFunction_SHA1:
+		partialHash = sha1.New()
+	case remoteexecution.DigestFunction_SHA256:
+		partialHash = sha256.New()
+	case remoteexecution.DigestFunction_SHA384:
+		partialHash = sha512.New384()
+	case remoteexecution.DigestFunction_SHA512:
+		partialHash = sha512.New()
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "Unsupported digest function: %s", digestFunction)
+	}
+	return &Generator{
+		instanceName: in,
+		partialHash:  partialHash,
+	}, nil
+}
+
 func (in InstanceName) String() string {
 	return in.value
 }
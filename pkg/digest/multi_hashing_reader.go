@@ -0,0 +1,63 @@
+package digest
+
+import (
+	"io"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MultiHashingReader is a decorator for io.Reader that, like
+// HashingReader, computes digests of the data that is read through
+// it. Instead of using a single digest function, it computes digests
+// using multiple digest functions simultaneously. This can be used to
+// migrate data between storage backends that use different digest
+// functions, without needing to read data multiple times.
+type MultiHashingReader struct {
+	r          io.Reader
+	generators map[remoteexecution.DigestFunction_Value]*Generator
+	digests    map[remoteexecution.DigestFunction_Value]Digest
+}
+
+// NewMultiHashingReader creates a MultiHashingReader that computes
+// digests for a given instance name, using a set of digest functions.
+func NewMultiHashingReader(r io.Reader, instanceName InstanceName, digestFunctions []remoteexecution.DigestFunction_Value) (*MultiHashingReader, error) {
+	generators := make(map[remoteexecution.DigestFunction_Value]*Generator, len(digestFunctions))
+	for _, digestFunction := range digestFunctions {
+		generator, err := instanceName.NewGenerator(digestFunction)
+		if err != nil {
+			return nil, err
+		}
+		generators[digestFunction] = generator
+	}
+	return &MultiHashingReader{
+		r:          r,
+		generators: generators,
+	}, nil
+}
+
+func (hr *MultiHashingReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	for _, generator := range hr.generators {
+		generator.Write(p[:n])
+	}
+	if err == io.EOF && hr.digests == nil {
+		hr.digests = make(map[remoteexecution.DigestFunction_Value]Digest, len(hr.generators))
+		for digestFunction, generator := range hr.generators {
+			hr.digests[digestFunction] = generator.Sum()
+		}
+	}
+	return n, err
+}
+
+// GetDigests returns the digests of the data read through the
+// MultiHashingReader, keyed by digest function. The digests only
+// become available once the underlying reader has returned io.EOF.
+func (hr *MultiHashingReader) GetDigests() (map[remoteexecution.DigestFunction_Value]Digest, error) {
+	if hr.digests == nil {
+		return nil, status.Error(codes.FailedPrecondition, "Digests cannot be computed, as the data has not been read in its entirety")
+	}
+	return hr.digests, nil
+}
@@ -0,0 +1,56 @@
+package digest_test
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMultiHashingReader(t *testing.T) {
+	instanceName := digest.MustNewInstanceName("hello")
+
+	t.Run("Success", func(t *testing.T) {
+		r, err := digest.NewMultiHashingReader(
+			strings.NewReader("Hello"),
+			instanceName,
+			[]remoteexecution.DigestFunction_Value{
+				remoteexecution.DigestFunction_MD5,
+				remoteexecution.DigestFunction_SHA1,
+				remoteexecution.DigestFunction_SHA256,
+			})
+		require.NoError(t, err)
+
+		// The digests should not be available until EOF.
+		_, err = r.GetDigests()
+		require.Equal(t, status.Error(codes.FailedPrecondition, "Digests cannot be computed, as the data has not been read in its entirety"), err)
+
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+		digests, err := r.GetDigests()
+		require.NoError(t, err)
+		require.Equal(t, map[remoteexecution.DigestFunction_Value]digest.Digest{
+			remoteexecution.DigestFunction_MD5:    digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5),
+			remoteexecution.DigestFunction_SHA1:   digest.MustNewDigest("hello", "f7ff9e8b7bb2e09b70935a5d785e0cc5d9d0abf0", 5),
+			remoteexecution.DigestFunction_SHA256: digest.MustNewDigest("hello", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5),
+		}, digests)
+	})
+
+	t.Run("UnsupportedDigestFunction", func(t *testing.T) {
+		_, err := digest.NewMultiHashingReader(
+			strings.NewReader("Hello"),
+			instanceName,
+			[]remoteexecution.DigestFunction_Value{
+				remoteexecution.DigestFunction_SHA256,
+				remoteexecution.DigestFunction_VSO,
+			})
+		require.Equal(t, status.Error(codes.InvalidArgument, "Unsupported digest function: VSO"), err)
+	})
+}
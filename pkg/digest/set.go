@@ -2,6 +2,11 @@ package digest
 
 import (
 	"container/heap"
+	"encoding/binary"
+	"encoding/hex"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Set of digests. Sets are immutable and can be created using
@@ -62,6 +67,132 @@ func (s Set) RemoveEmptyBlob() Set {
 	return s
 }
 
+// MarshalBinary converts the set to a compact binary representation,
+// so that it may be persisted. The encoding consists of a table of
+// instance names, followed by the digests in the set. Each digest is
+// stored as an index into the table of instance names, its binary
+// hash, and its size. All integers are stored as varints, while all
+// variable length fields are prefixed with their length. The digest
+// function is implied by the length of the hash.
+func (s Set) MarshalBinary() ([]byte, error) {
+	// Construct the table of instance names.
+	instanceNameIndices := map[InstanceName]uint64{}
+	var instanceNames []InstanceName
+	for _, digest := range s.digests {
+		instanceName := digest.GetInstanceName()
+		if _, ok := instanceNameIndices[instanceName]; !ok {
+			instanceNameIndices[instanceName] = uint64(len(instanceNames))
+			instanceNames = append(instanceNames, instanceName)
+		}
+	}
+
+	var scratch [binary.MaxVarintLen64]byte
+	appendUvarint := func(data []byte, v uint64) []byte {
+		return append(data, scratch[:binary.PutUvarint(scratch[:], v)]...)
+	}
+	data := appendUvarint(nil, uint64(len(instanceNames)))
+	for _, instanceName := range instanceNames {
+		data = appendUvarint(data, uint64(len(instanceName.value)))
+		data = append(data, instanceName.value...)
+	}
+	data = appendUvarint(data, uint64(len(s.digests)))
+	for _, digest := range s.digests {
+		hash := digest.GetHashBytes()
+		data = appendUvarint(data, instanceNameIndices[digest.GetInstanceName()])
+		data = appendUvarint(data, uint64(len(hash)))
+		data = append(data, hash...)
+		data = appendUvarint(data, uint64(digest.GetSizeBytes()))
+	}
+	return data, nil
+}
+
+// setDecoder is a helper type for UnmarshalBinary() that extracts
+// fields from the binary representation of a set.
+type setDecoder struct {
+	data []byte
+}
+
+func (d *setDecoder) readUvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		return 0, status.Error(codes.InvalidArgument, "Invalid or truncated integer")
+	}
+	d.data = d.data[n:]
+	return v, nil
+}
+
+func (d *setDecoder) readBytes() ([]byte, error) {
+	length, err := d.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(len(d.data)) {
+		return nil, status.Errorf(codes.InvalidArgument, "Field is %d bytes in size, while only %d bytes remain", length, len(d.data))
+	}
+	v := d.data[:length]
+	d.data = d.data[length:]
+	return v, nil
+}
+
+// UnmarshalBinary replaces the contents of the set with those stored
+// in a binary representation created by MarshalBinary(). All digests
+// are validated.
+func (s *Set) UnmarshalBinary(data []byte) error {
+	d := setDecoder{data: data}
+
+	// Parse the table of instance names.
+	instanceNamesCount, err := d.readUvarint()
+	if err != nil {
+		return err
+	}
+	var instanceNames []InstanceName
+	for i := uint64(0); i < instanceNamesCount; i++ {
+		value, err := d.readBytes()
+		if err != nil {
+			return err
+		}
+		instanceName, err := NewInstanceName(string(value))
+		if err != nil {
+			return err
+		}
+		instanceNames = append(instanceNames, instanceName)
+	}
+
+	// Parse the digests.
+	digestsCount, err := d.readUvarint()
+	if err != nil {
+		return err
+	}
+	builder := NewSetBuilder()
+	for i := uint64(0); i < digestsCount; i++ {
+		instanceNameIndex, err := d.readUvarint()
+		if err != nil {
+			return err
+		}
+		if instanceNameIndex >= uint64(len(instanceNames)) {
+			return status.Errorf(codes.InvalidArgument, "Instance name index %d exceeds the number of instance names %d", instanceNameIndex, len(instanceNames))
+		}
+		hash, err := d.readBytes()
+		if err != nil {
+			return err
+		}
+		sizeBytes, err := d.readUvarint()
+		if err != nil {
+			return err
+		}
+		digest, err := instanceNames[instanceNameIndex].NewDigest(hex.EncodeToString(hash), int64(sizeBytes))
+		if err != nil {
+			return err
+		}
+		builder.Add(digest)
+	}
+	if len(d.data) > 0 {
+		return status.Errorf(codes.InvalidArgument, "Encountered %d bytes of trailing data", len(d.data))
+	}
+	*s = builder.Build()
+	return nil
+}
+
 // GetDifferenceAndIntersection partitions the elements stored in sets A
 // and B across three resulting sets: one containing the elements
 // present only in A, one containing the elements present in both A and
@@ -5,6 +5,9 @@ import (
 
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestSetEmpty(t *testing.T) {
@@ -183,3 +186,61 @@ func TestGetUnion(t *testing.T) {
 			}).Items())
 	})
 }
+
+func TestSetMarshalBinary(t *testing.T) {
+	digests := []digest.Digest{
+		digest.MustNewDigest("", "8b1a9953c4611296a827abf8c47804d7", 5),
+		digest.MustNewDigest("a", "f7ff9e8b7bb2e09b70935a5d785e0cc5d9d0abf0", 5),
+		digest.MustNewDigest("a/b", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5),
+		digest.MustNewDigest("a/b", "3519fe5ad2c596efe3e276a6f351b8fc0b03db861782490d45f7598ebd0ab5fd5520ed102f38c4a5ec834e98668035fc", 5),
+		digest.MustNewDigest("a", "3615f80c9d293ed7402687f94b22d58e529b8cc7916f8fac7fddf7fbd5af4cf777d3d795a7a00a16bf7e7f3fb9561ee9baae480da9fe7a18769e71886b03f315", 5),
+		digest.MustNewDigest("a", "8b1a9953c4611296a827abf8c47804d7", 1<<40),
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		// The encoding should not depend on the order in which
+		// digests were added to the set.
+		forward := digest.NewSetBuilder()
+		for _, d := range digests {
+			forward.Add(d)
+		}
+		backward := digest.NewSetBuilder()
+		for i := len(digests) - 1; i >= 0; i-- {
+			backward.Add(digests[i])
+		}
+
+		data, err := forward.Build().MarshalBinary()
+		require.NoError(t, err)
+		var s digest.Set
+		require.NoError(t, s.UnmarshalBinary(data))
+		require.Equal(t, backward.Build(), s)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		data, err := digest.EmptySet.MarshalBinary()
+		require.NoError(t, err)
+		var s digest.Set
+		require.NoError(t, s.UnmarshalBinary(data))
+		require.Equal(t, digest.EmptySet, s)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		data, err := digest.NewSetBuilder().Add(digests[0]).Build().MarshalBinary()
+		require.NoError(t, err)
+		var s digest.Set
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Field is 16 bytes in size, while only 2 bytes remain"),
+			s.UnmarshalBinary(data[:len(data)-15]))
+	})
+
+	t.Run("TrailingData", func(t *testing.T) {
+		data, err := digest.NewSetBuilder().Add(digests[0]).Build().MarshalBinary()
+		require.NoError(t, err)
+		var s digest.Set
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Encountered 1 bytes of trailing data"),
+			s.UnmarshalBinary(append(data, 0)))
+	})
+}
@@ -113,6 +113,9 @@ type Directory interface {
 	RemoveAllChildren() error
 	// Symlink is the equivalent of os.Symlink().
 	Symlink(oldName string, newName string) error
+	// Sync flushes changes to the entries of the directory (e.g.,
+	// files being created or removed) to persistent storage.
+	Sync() error
 	// Chtimes sets the atime and mtime of the named file.
 	Chtimes(name string, atime, mtime time.Time) error
 
@@ -9,6 +9,9 @@ import (
 type FileAppender interface {
 	io.Closer
 	io.Writer
+
+	// Sync is the equivalent of os.File.Sync().
+	Sync() error
 }
 
 // FileReader is returned by Directory.OpenRead(). It is a handle
@@ -362,6 +362,12 @@ func (d *localDirectory) Symlink(oldName string, newName string) error {
 	return unix.Symlinkat(oldName, d.fd, newName)
 }
 
+func (d *localDirectory) Sync() error {
+	defer runtime.KeepAlive(d)
+
+	return unix.Fsync(d.fd)
+}
+
 func (d *localDirectory) Chtimes(name string, atime, mtime time.Time) error {
 	if err := validateFilename(name); err != nil {
 		return err
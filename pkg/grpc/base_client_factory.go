@@ -2,14 +2,18 @@ package grpc
 
 import (
 	"context"
+	"encoding/json"
+	"sync"
 
 	configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
 	"github.com/buildbarn/bb-storage/pkg/util"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/oauth"
 	"google.golang.org/grpc/keepalive"
@@ -18,6 +22,19 @@ import (
 	"go.opencensus.io/plugin/ocgrpc"
 )
 
+var (
+	baseClientFactoryPrometheusMetrics sync.Once
+
+	baseClientFactoryConnectionStateTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "grpc",
+			Name:      "client_connection_state_transitions_total",
+			Help:      "Number of times gRPC client connections transitioned to a connectivity state.",
+		},
+		[]string{"address", "state"})
+)
+
 func init() {
 	// Add Prometheus timing metrics.
 	grpc_prometheus.EnableClientHandlingTimeHistogram(
@@ -31,6 +48,9 @@ func (cf baseClientFactory) NewClientFromConfiguration(config *configuration.Cli
 	if config == nil {
 		return nil, status.Error(codes.InvalidArgument, "No gRPC client configuration provided")
 	}
+	baseClientFactoryPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(baseClientFactoryConnectionStateTransitions)
+	})
 
 	dialOptions := []grpc.DialOption{
 		grpc.WithStatsHandler(&ocgrpc.ClientHandler{}),
@@ -88,6 +108,25 @@ func (cf baseClientFactory) NewClientFromConfiguration(config *configuration.Cli
 		}))
 	}
 
+	// Optional: client side health checking. This requires the
+	// use of a load balancing policy that supports it. The health
+	// checking client is registered by importing the "health"
+	// package.
+	if serviceName := config.HealthCheckServiceName; serviceName != "" {
+		serviceConfig, err := json.Marshal(map[string]interface{}{
+			"loadBalancingConfig": []interface{}{
+				map[string]interface{}{"round_robin": map[string]interface{}{}},
+			},
+			"healthCheckConfig": map[string]interface{}{
+				"serviceName": serviceName,
+			},
+		})
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to create service configuration")
+		}
+		dialOptions = append(dialOptions, grpc.WithDefaultServiceConfig(string(serviceConfig)))
+	}
+
 	// Optional: metadata forwarding.
 	if headers := config.ForwardMetadata; len(headers) > 0 {
 		unaryInterceptors = append(
@@ -123,7 +162,29 @@ func (cf baseClientFactory) NewClientFromConfiguration(config *configuration.Cli
 		dialOptions,
 		grpc.WithChainUnaryInterceptor(unaryInterceptors...),
 		grpc.WithChainStreamInterceptor(streamInterceptors...))
-	return grpc.Dial(config.Address, dialOptions...)
+	conn, err := grpc.Dial(config.Address, dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	go watchConnectionState(conn, config.Address)
+	return conn, nil
+}
+
+// watchConnectionState keeps track of the connectivity state of a gRPC
+// client connection, exposing transitions as a Prometheus metric. This
+// makes it possible to detect connections that are broken or being
+// reestablished frequently (e.g., due to keepalive timeouts). It runs
+// until the connection is closed.
+func watchConnectionState(conn *grpc.ClientConn, address string) {
+	state := conn.GetState()
+	for {
+		baseClientFactoryConnectionStateTransitions.WithLabelValues(address, state.String()).Inc()
+		if state == connectivity.Shutdown {
+			return
+		}
+		conn.WaitForStateChange(context.Background(), state)
+		state = conn.GetState()
+	}
 }
 
 // BaseClientFactory creates gRPC clients using the go-grpc library.
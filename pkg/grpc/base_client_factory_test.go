@@ -0,0 +1,89 @@
+package grpc_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// newHealthServer starts a gRPC server on a local port that only
+// provides the health checking service.
+func newHealthServer(t *testing.T) (*grpc.Server, *health.Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("bb_storage", grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+	go server.Serve(listener)
+	return server, healthServer, listener.Addr().String()
+}
+
+func TestBaseClientFactoryHealthCheck(t *testing.T) {
+	server, healthServer, address := newHealthServer(t)
+	defer server.Stop()
+
+	client, err := bb_grpc.BaseClientFactory.NewClientFromConfiguration(&configuration.ClientConfiguration{
+		Address:                address,
+		HealthCheckServiceName: "bb_storage",
+	})
+	require.NoError(t, err)
+	conn := client.(*grpc.ClientConn)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	healthClient := grpc_health_v1.NewHealthClient(client)
+	request := &grpc_health_v1.HealthCheckRequest{Service: "bb_storage"}
+
+	// Calls should succeed while the server is healthy.
+	_, err = healthClient.Check(ctx, request, grpc.WaitForReady(true))
+	require.NoError(t, err)
+
+	// Once the server reports that it's no longer serving, the
+	// connection should leave the READY state. Calls should then
+	// fail immediately, as opposed to being sent to the server.
+	healthServer.SetServingStatus("bb_storage", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	for state := conn.GetState(); state == connectivity.Ready; state = conn.GetState() {
+		require.True(t, conn.WaitForStateChange(ctx, state))
+	}
+	_, err = healthClient.Check(ctx, request)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestBaseClientFactoryServerStopped(t *testing.T) {
+	server, _, address := newHealthServer(t)
+
+	client, err := bb_grpc.BaseClientFactory.NewClientFromConfiguration(&configuration.ClientConfiguration{
+		Address: address,
+	})
+	require.NoError(t, err)
+	conn := client.(*grpc.ClientConn)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	healthClient := grpc_health_v1.NewHealthClient(client)
+	request := &grpc_health_v1.HealthCheckRequest{Service: "bb_storage"}
+
+	_, err = healthClient.Check(ctx, request, grpc.WaitForReady(true))
+	require.NoError(t, err)
+
+	// Calls against a server that has gone away should fail with
+	// UNAVAILABLE, as opposed to hanging until the context expires.
+	server.Stop()
+	_, err = healthClient.Check(ctx, request)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
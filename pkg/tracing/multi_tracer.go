@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+)
+
+type multiTracer struct {
+	tracers []Tracer
+}
+
+// NewMultiTracer creates a Tracer that forwards spans to multiple
+// Tracers. This can be used to emit spans through multiple tracing
+// libraries while migrating from one to the other. Spans are created
+// in the order in which Tracers are provided.
+func NewMultiTracer(tracers []Tracer) Tracer {
+	return &multiTracer{
+		tracers: tracers,
+	}
+}
+
+func (t *multiTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	spans := make(multiSpan, 0, len(t.tracers))
+	for _, tracer := range t.tracers {
+		var span Span
+		ctx, span = tracer.StartSpan(ctx, name)
+		spans = append(spans, span)
+	}
+	return ctx, spans
+}
+
+type multiSpan []Span
+
+func (s multiSpan) AddStringAttribute(key, value string) {
+	for _, span := range s {
+		span.AddStringAttribute(key, value)
+	}
+}
+
+func (s multiSpan) AddInt64Attribute(key string, value int64) {
+	for _, span := range s {
+		span.AddInt64Attribute(key, value)
+	}
+}
+
+func (s multiSpan) SetStatus(code codes.Code, message string) {
+	for _, span := range s {
+		span.SetStatus(code, message)
+	}
+}
+
+func (s multiSpan) End() {
+	for _, span := range s {
+		span.End()
+	}
+}
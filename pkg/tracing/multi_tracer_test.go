@@ -0,0 +1,51 @@
+package tracing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/tracing"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+)
+
+type contextKey struct{}
+
+func TestMultiTracer(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	// Spans should be created in order, where the context returned
+	// by the first tracer is passed to the second.
+	tracer1 := mock.NewMockTracer(ctrl)
+	span1 := mock.NewMockSpan(ctrl)
+	ctx1 := context.WithValue(ctx, contextKey{}, 1)
+	tracer1.EXPECT().StartSpan(ctx, "Operation").Return(ctx1, span1)
+	tracer2 := mock.NewMockTracer(ctrl)
+	span2 := mock.NewMockSpan(ctrl)
+	ctx2 := context.WithValue(ctx1, contextKey{}, 2)
+	tracer2.EXPECT().StartSpan(ctx1, "Operation").Return(ctx2, span2)
+
+	tracer := tracing.NewMultiTracer([]tracing.Tracer{tracer1, tracer2})
+	spanCtx, span := tracer.StartSpan(ctx, "Operation")
+	require.Equal(t, ctx2, spanCtx)
+
+	// Calls against the span should be forwarded to both spans.
+	span1.EXPECT().AddStringAttribute("hash", "8b1a9953c4611296a827abf8c47804d7")
+	span2.EXPECT().AddStringAttribute("hash", "8b1a9953c4611296a827abf8c47804d7")
+	span.AddStringAttribute("hash", "8b1a9953c4611296a827abf8c47804d7")
+
+	span1.EXPECT().AddInt64Attribute("size_bytes", int64(5))
+	span2.EXPECT().AddInt64Attribute("size_bytes", int64(5))
+	span.AddInt64Attribute("size_bytes", 5)
+
+	span1.EXPECT().SetStatus(codes.NotFound, "Blob not found")
+	span2.EXPECT().SetStatus(codes.NotFound, "Blob not found")
+	span.SetStatus(codes.NotFound, "Blob not found")
+
+	span1.EXPECT().End()
+	span2.EXPECT().End()
+	span.End()
+}
@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+
+	"go.opencensus.io/trace"
+)
+
+type openCensusTracer struct{}
+
+// OpenCensusTracer is an implementation of Tracer that creates spans
+// using OpenCensus.
+var OpenCensusTracer Tracer = openCensusTracer{}
+
+func (openCensusTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	ctx, span := trace.StartSpan(ctx, name)
+	return ctx, openCensusSpan{span: span}
+}
+
+type openCensusSpan struct {
+	span *trace.Span
+}
+
+func (s openCensusSpan) AddStringAttribute(key, value string) {
+	s.span.AddAttributes(trace.StringAttribute(key, value))
+}
+
+func (s openCensusSpan) AddInt64Attribute(key string, value int64) {
+	s.span.AddAttributes(trace.Int64Attribute(key, value))
+}
+
+func (s openCensusSpan) SetStatus(code codes.Code, message string) {
+	s.span.SetStatus(trace.Status{
+		Code:    int32(code),
+		Message: message,
+	})
+}
+
+func (s openCensusSpan) End() {
+	s.span.End()
+}
@@ -0,0 +1,28 @@
+package tracing
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Tracer is an abstraction over tracing libraries, such as OpenCensus
+// and OpenTelemetry. Instrumentation that creates spans through this
+// interface can target any of these libraries, or multiple of them at
+// the same time while migrating from one library to another.
+type Tracer interface {
+	// StartSpan creates a new span, which is a child of the span
+	// stored in the provided context, if any. The returned context
+	// contains the newly created span.
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single span created by a Tracer.
+type Span interface {
+	AddStringAttribute(key, value string)
+	AddInt64Attribute(key string, value int64)
+	// SetStatus records that the operation corresponding with the
+	// span failed.
+	SetStatus(code codes.Code, message string)
+	End()
+}